@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var amendArgs struct {
+	remove []string
+}
+
+var amendCmd = &ffcli.Command{
+	Name:       "amend",
+	ShortUsage: "amend <cid>",
+	ShortHelp:  "Reopen a committed ref to add or remove files",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop amend' command stages all the entries of a committed ref in the current transaction.
+New files can then be added with 'pop put' and the new version committed with 'pop commit'.
+Unchanged entries link to the same blocks so they are not added again. Keys can be removed
+with the rm flag i.e. 'pop amend -rm data.txt,old.txt <cid>'.
+
+`),
+	Exec: runAmend,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("amend", flag.ExitOnError)
+		fs.Var(utils.ListValue(&amendArgs.remove, nil), "rm", "comma separated list of keys to remove from the ref")
+		return fs
+	})(),
+}
+
+func runAmend(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	arc := make(chan *node.AmendResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ar := n.AmendResult; ar != nil {
+			arc <- ar
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Amend(&node.AmendArgs{
+		Ref:    args[0],
+		Remove: amendArgs.remove,
+	})
+	select {
+	case ar := <-arc:
+		if ar.Err != "" {
+			return errors.New(ar.Err)
+		}
+		fmt.Printf("==> Staged %d entries from %s\n", len(ar.Keys), args[0])
+		for _, k := range ar.Keys {
+			fmt.Printf("%s\n", k)
+		}
+		fmt.Printf("==> Root %s\n", ar.RootCid)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
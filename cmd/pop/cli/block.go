@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var blockArgs struct {
+	rm bool
+}
+
+var blockCmd = &ffcli.Command{
+	Name:       "block",
+	ShortUsage: "block <peer-id|subnet>...",
+	ShortHelp:  "Block connections with peers or subnets",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop block' command prevents the given peers or CIDR subnets (i.e. 10.0.0.0/8) from connecting with us
+and closes the connections we already have with them. Blocked peers and subnets are persisted across restarts.
+Peers sending us too many invalid messages are blocked automatically. Use the -rm flag to allow connections
+again. Without arguments it lists all the blocked peers and subnets.
+
+`),
+	Exec: runBlock,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("block", flag.ExitOnError)
+		fs.BoolVar(&blockArgs.rm, "rm", false, "unblock the given peers or subnets")
+		return fs
+	})(),
+}
+
+func runBlock(ctx context.Context, args []string) error {
+	if blockArgs.rm && len(args) == 0 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	brc := make(chan *node.BlockResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if br := n.BlockResult; br != nil {
+			brc <- br
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Block(&node.BlockArgs{
+		Targets: args,
+		Unblock: blockArgs.rm,
+	})
+	select {
+	case br := <-brc:
+		if br.Err != "" {
+			return errors.New(br.Err)
+		}
+		if len(br.Peers) == 0 && len(br.Subnets) == 0 {
+			fmt.Printf("==> Nothing blocked\n")
+			return nil
+		}
+		if len(br.Peers) > 0 {
+			fmt.Printf("==> Blocked peers:\n")
+			for _, p := range br.Peers {
+				fmt.Printf("%s\n", p)
+			}
+		}
+		if len(br.Subnets) > 0 {
+			fmt.Printf("==> Blocked subnets:\n")
+			for _, s := range br.Subnets {
+				fmt.Printf("%s\n", s)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -45,6 +46,7 @@ func Run(args []string) error {
 
 	rootfs := flag.NewFlagSet("pop", flag.ExitOnError)
 	logLevel := rootfs.String("log", zerolog.InfoLevel.String(), "Set logging mode")
+	record := rootfs.Bool("record", false, "Record the notifications of the command to replay them with 'pop debug replay'")
 
 	// env vars can be used as program args, i.e : ENV LOG=debug go run . start
 	err := ff.Parse(rootfs, args, ff.WithEnvVarNoPrefix())
@@ -59,6 +61,9 @@ func Run(args []string) error {
 
 	zerolog.SetGlobalLevel(loggingLevel)
 
+	recordOps = *record
+	opArgs = args
+
 	if loggingLevel < zerolog.InfoLevel {
 		output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
 		output.FormatLevel = func(i interface{}) string {
@@ -97,9 +102,26 @@ change until a first stable release. To get started run 'pop start'.
 			putCmd,
 			statusCmd,
 			commCmd,
+			amendCmd,
 			getCmd,
 			listCmd,
+			searchCmd,
+			labelCmd,
+			protectCmd,
+			statsCmd,
+			earningsCmd,
+			usageCmd,
+			filesCmd,
+			moveCmd,
+			regionCmd,
+			schemeCmd,
+			blockCmd,
+			pinCmd,
+			dispatchCmd,
 			walletCmd,
+			paychCmd,
+			dealCmd,
+			debugCmd,
 		},
 		FlagSet: rootfs,
 		Exec:    func(context.Context, []string) error { return flag.ErrHelp },
@@ -116,24 +138,61 @@ change until a first stable release. To get started run 'pop start'.
 	return err
 }
 
+// recordOps is set with the -record flag to record the notifications received by the command
+var recordOps bool
+
+// opArgs are the args of the command being run, recorded so the operation can be replayed
+var opArgs []string
+
 func connect(ctx context.Context) (net.Conn, *node.CommandClient, context.Context, context.CancelFunc) {
-	c, err := node.SocketConnect()
-	if err != nil {
-		log.Fatal().Msg("Unable to connect")
+	var c net.Conn
+	if replaying {
+		c = replayConn(replayMsgs)
+	} else {
+		var err error
+		c, err = node.SocketConnect()
+		if err != nil {
+			log.Fatal().Msg("Unable to connect")
+		}
+		if recordOps {
+			rec, id, err := newOpRecorder(opArgs)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to record operation")
+			} else {
+				opRec = rec
+				fmt.Fprintf(os.Stderr, "Recording operation %s\n", id)
+			}
+		}
 	}
 
 	clientToServer := func(b []byte) {
 		node.WriteMsg(c, b)
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancelCtx := context.WithCancel(ctx)
+	// cancel ends the command, closing the connection and the recording of the operation if any
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			cancelCtx()
+			c.Close()
+			if opRec != nil {
+				if err := opRec.close(); err != nil {
+					log.Error().Err(err).Msg("failed to close operation recording")
+				}
+			}
+		})
+	}
 
 	go func() {
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
-		<-interrupt
-		c.Close()
-		cancel()
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interrupt)
 	}()
 
 	cc := node.NewCommandClient(clientToServer)
@@ -152,6 +211,9 @@ func receive(ctx context.Context, cc *node.CommandClient, conn net.Conn) {
 			log.Error().Err(err).Msg("ReadMsg")
 			break
 		}
+		if opRec != nil {
+			opRec.record(msg)
+		}
 		cc.GotNotifyMsg(msg)
 	}
 }
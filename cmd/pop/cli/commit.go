@@ -5,7 +5,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -15,6 +18,16 @@ var commArgs struct {
 	cacheOnly bool
 	cacheRF   int
 	storageRF int
+	private   bool
+	tags      string
+	desc      string
+	labels    string
+	name      string
+	incentive string
+	hold      time.Duration
+	dryRun    bool
+	regions   string
+	from      string
 }
 
 var commCmd = &ffcli.Command{
@@ -25,12 +38,27 @@ var commCmd = &ffcli.Command{
 
 The 'pop commit' command deploys a DAG archive initialized with one or multiple 'put' on the Filecoin storage
 with a given level of cashing. By default it will attempt multiple storage deals for 6 months with caching in the initial regions.
+Passing a name i.e. 'pop commit -name my-site' records the commit as the latest version of that name so it can be
+retrieved with 'pop get my-site' and its history listed with 'pop list -name my-site'.
+Use the -dry-run flag to preview which caches would receive the content without committing the transaction.
+The -regions flag lists regions in order of priority, i.e. 'pop commit -regions Europe,NorthAmerica' only
+requests caches in NorthAmerica if not enough caches in Europe stored the content.
 
 `),
 	Exec: runCommit,
 	FlagSet: (func() *flag.FlagSet {
 		fs := flag.NewFlagSet("commit", flag.ExitOnError)
 		fs.IntVar(&commArgs.cacheRF, "cache-rf", 2, "number of cache providers to dispatch to")
+		fs.StringVar(&commArgs.tags, "tags", "", "tags to search the commit by, separated by commas")
+		fs.StringVar(&commArgs.labels, "labels", "", "labels to set on the ref, i.e. env=prod,tier=best-effort")
+		fs.StringVar(&commArgs.desc, "desc", "", "description to search the commit by")
+		fs.BoolVar(&commArgs.private, "private", false, "do not list the content on public index endpoints")
+		fs.StringVar(&commArgs.name, "name", "", "name the commit as the latest version of, previous versions are linked as parents")
+		fs.StringVar(&commArgs.incentive, "incentive", "", "amount of FIL offered to each cache holding the content, i.e. 0.001")
+		fs.DurationVar(&commArgs.hold, "hold", 24*time.Hour, "how long caches must hold the content to claim the incentive")
+		fs.BoolVar(&commArgs.dryRun, "dry-run", false, "list the caches we would dispatch to without committing")
+		fs.StringVar(&commArgs.regions, "regions", "", "regions to dispatch to in order of priority, separated by commas")
+		fs.StringVar(&commArgs.from, "from", "", "wallet address paying the incentive (default: wallet default address)")
 		return fs
 	})(),
 }
@@ -47,8 +75,35 @@ func runCommit(ctx context.Context, args []string) error {
 	})
 	go receive(ctx, cc, c)
 
+	var tags []string
+	if commArgs.tags != "" {
+		tags = strings.Split(commArgs.tags, ",")
+	}
+	var regions []string
+	if commArgs.regions != "" {
+		regions = strings.Split(commArgs.regions, ",")
+	}
+	var labels map[string]string
+	if commArgs.labels != "" {
+		var err error
+		labels, err = parseLabels(strings.Split(commArgs.labels, ","))
+		if err != nil {
+			return err
+		}
+	}
+
 	cc.Commit(&node.CommArgs{
-		CacheRF: commArgs.cacheRF,
+		CacheRF:     commArgs.cacheRF,
+		Private:     commArgs.private,
+		Tags:        tags,
+		Description: commArgs.desc,
+		Labels:      labels,
+		Name:        commArgs.name,
+		Incentive:   commArgs.incentive,
+		Hold:        commArgs.hold,
+		DryRun:      commArgs.dryRun,
+		Regions:     regions,
+		From:        commArgs.from,
 	})
 	for {
 		select {
@@ -56,6 +111,13 @@ func runCommit(ctx context.Context, args []string) error {
 			if cr.Err != "" {
 				return errors.New(cr.Err)
 			}
+			if cr.DryRun {
+				printCandidates(cr.Candidates)
+				return nil
+			}
+			if cr.Progress != "" {
+				fmt.Printf("  %s\n", cr.Progress)
+			}
 			if len(cr.Caches) > 0 {
 				fmt.Printf("Cached by %s\n", cr.Caches)
 			}
@@ -68,3 +130,17 @@ func runCommit(ctx context.Context, args []string) error {
 		}
 	}
 }
+
+func printCandidates(candidates []node.CandidateResult) {
+	if len(candidates) == 0 {
+		fmt.Printf("==> No caches available in our regions\n")
+		return
+	}
+	fmt.Printf("==> Would dispatch to %d caches:\n", len(candidates))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Peer\tRegions\tCapacity\tLatency\tScore\n")
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f\n", c.Peer, strings.Join(c.Regions, ","), c.Capacity, c.Latency, c.Score)
+	}
+	w.Flush()
+}
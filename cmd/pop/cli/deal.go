@@ -0,0 +1,455 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var dealListArgs struct {
+	status string
+}
+
+var dealProposalsArgs struct {
+	miner   string
+	ref     string
+	outcome string
+}
+
+var dealPushArgs struct {
+	miners   string
+	prices   string
+	rf       int
+	maxPrice string
+	region   string
+	duration time.Duration
+	verified bool
+	fast     bool
+	buffer   time.Duration
+	start    int64
+	coll     uint64
+	minRep   float64
+	regions  string
+	latency  time.Duration
+	owners   bool
+	lifetime bool
+}
+
+var dealQueueArgs struct {
+	all bool
+}
+
+var dealOfflineArgs struct {
+	miners   string
+	duration time.Duration
+	output   string
+	verified bool
+}
+
+var dealCmd = &ffcli.Command{
+	Name:       "deal",
+	ShortUsage: "deal <subcommand>",
+	ShortHelp:  "Manage the storage deals proposed to Filecoin miners",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal' commands inspect the storage deals the daemon proposed to Filecoin miners. Deals are tracked
+until they are active or failed and connected clients are notified whenever one changes status.
+
+`),
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	FlagSet:     flag.NewFlagSet("deal", flag.ExitOnError),
+	Subcommands: []*ffcli.Command{dealListCmd, dealProposalsCmd, dealPushCmd, dealQueueCmd, dealOfflineCmd},
+}
+
+var dealListCmd = &ffcli.Command{
+	Name:       "list",
+	ShortUsage: "deal list [-status proposed|published|sealing|active|error]",
+	ShortHelp:  "List the storage deals and their status",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal list' command prints the storage deals we proposed, most recent first, with their status:
+proposed when the miner accepted the proposal, published once the deal is on chain, sealing while the miner
+seals it in a sector, active once the sector is proven and error if the deal failed or was slashed.
+
+`),
+	Exec: runDealList,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		fs.StringVar(&dealListArgs.status, "status", "", "only list the deals with the given status")
+		return fs
+	})(),
+}
+
+var dealProposalsCmd = &ffcli.Command{
+	Name:       "proposals",
+	ShortUsage: "deal proposals [-miner <addr>] [-ref <cid|name>] [-outcome accepted|rejected|error|failed]",
+	ShortHelp:  "Audit the deal proposals sent to miners and how they answered",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal proposals' command prints every deal proposal the daemon sent, most recent first, with the
+price offered, the piece, the epoch range and the outcome: accepted, rejected or error when the miner
+answered and failed when the proposal never reached it. The message explains why a push failed.
+It then summarizes the outcomes per miner, the miners rejecting our deals the most first.
+
+`),
+	Exec: runDealProposals,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("proposals", flag.ExitOnError)
+		fs.StringVar(&dealProposalsArgs.miner, "miner", "", "only list the proposals sent to the given miner")
+		fs.StringVar(&dealProposalsArgs.ref, "ref", "", "only list the proposals for the given content")
+		fs.StringVar(&dealProposalsArgs.outcome, "outcome", "", "only list the proposals with the given outcome")
+		return fs
+	})(),
+}
+
+var dealPushCmd = &ffcli.Command{
+	Name:       "push",
+	ShortUsage: "deal push [-miners <addr,...> [-prices <FIL,...>]] [-rf <n> -max-price <FIL>] [-duration <duration>] [-verified] [-fast-retrieval] [-start-buffer <duration>|-start-epoch <epoch>] [-collateral <n>] [-min-reputation <score>] [-regions <region,...>] [-max-latency <duration>] [-distinct-owners] <cid|name>...",
+	ShortHelp:  "Queue online storage deals for the content",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal push' command queues storage deals for the content which is pushed to the miners over graphsync.
+The daemon spreads the proposals over time and miners and retries the failed ones with a backoff.
+Miners can be given explicitly with the price per GiB per epoch offered to each of them, their ask is used if
+no prices are given. Otherwise miners asking less than the max price are selected and quoted on the spot so
+scripted pushes don't depend on a previous quote.
+Deal parameters trade cost against retrievability: fast retrieval asks the miners to keep an unsealed copy,
+the start buffer leaves time for the miners to seal before the deal starts and the collateral multiplier
+raises what the miners lose if a deal is slashed, which fewer miners may accept.
+Selected miners can be further restricted by reputation, regions and ask latency, to miners whose sectors
+live as long as the deals and to miners with distinct owners so the replicas don't depend on one operator.
+Passing multiple refs packs them in a single piece so small commits share the cost of the deals.
+
+`),
+	Exec: runDealPush,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("push", flag.ExitOnError)
+		fs.StringVar(&dealPushArgs.miners, "miners", "", "comma separated addresses of the miners to propose deals to")
+		fs.StringVar(&dealPushArgs.prices, "prices", "", "comma separated price per GiB per epoch offered to each miner i.e. 0.0000001FIL")
+		fs.IntVar(&dealPushArgs.rf, "rf", 1, "number of miners to select when none are given")
+		fs.StringVar(&dealPushArgs.maxPrice, "max-price", "", "max price per GiB per epoch a selected miner may ask i.e. 0.0000001FIL")
+		fs.StringVar(&dealPushArgs.region, "region", "", "select miners in the given region")
+		fs.DurationVar(&dealPushArgs.duration, "duration", 180*24*time.Hour, "how long the miners must store the content")
+		fs.BoolVar(&dealPushArgs.verified, "verified", false, "make verified deals using the datacap of the default address")
+		fs.BoolVar(&dealPushArgs.fast, "fast-retrieval", true, "ask the miners to keep an unsealed copy for fast retrievals")
+		fs.DurationVar(&dealPushArgs.buffer, "start-buffer", 49*time.Hour, "how long after the proposal the deals start")
+		fs.Int64Var(&dealPushArgs.start, "start-epoch", 0, "exact epoch the deals start at, overrides the start buffer")
+		fs.Uint64Var(&dealPushArgs.coll, "collateral", 1, "multiplier of the minimum collateral the miners lose if a deal is slashed")
+		fs.Float64Var(&dealPushArgs.minRep, "min-reputation", 0, "lowest reputation score (0-100) of a selected miner")
+		fs.StringVar(&dealPushArgs.regions, "regions", "", "comma separated regions the selected miners must be in")
+		fs.DurationVar(&dealPushArgs.latency, "max-latency", 0, "longest a selected miner may take to answer our ask")
+		fs.BoolVar(&dealPushArgs.owners, "distinct-owners", false, "select miners controlled by different owners")
+		fs.BoolVar(&dealPushArgs.lifetime, "sector-lifetime", true, "only select miners whose sectors live as long as the deals")
+		return fs
+	})(),
+}
+
+var dealQueueCmd = &ffcli.Command{
+	Name:       "queue",
+	ShortUsage: "deal queue [-all]",
+	ShortHelp:  "List the storage deals waiting to be proposed",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal queue' command prints the deals pushed to the queue which are waiting for their turn or to be
+retried, oldest first, with the number of attempts and the error of the last one.
+
+`),
+	Exec: runDealQueue,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("queue", flag.ExitOnError)
+		fs.BoolVar(&dealQueueArgs.all, "all", false, "include the deals which are done or failed")
+		return fs
+	})(),
+}
+
+var dealOfflineCmd = &ffcli.Command{
+	Name:       "offline",
+	ShortUsage: "deal offline -miners <addr,...> [-output <path>] [-duration <duration>] [-verified] <cid|name>...",
+	ShortHelp:  "Propose offline deals delivering the content as a CAR out-of-band",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop deal offline' command writes the content as a CAR file, computes its piece commitment and proposes
+offline deals for it to the given miners. No data is transferred online, the CAR must be delivered to each
+miner who then imports it with the printed command. This is the way to store very large commits.
+Passing multiple refs packs them in a single piece so small commits share the cost of the deals.
+
+`),
+	Exec: runDealOffline,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("offline", flag.ExitOnError)
+		fs.StringVar(&dealOfflineArgs.miners, "miners", "", "comma separated addresses of the miners to propose deals to")
+		fs.DurationVar(&dealOfflineArgs.duration, "duration", 180*24*time.Hour, "how long the miners must store the content")
+		fs.StringVar(&dealOfflineArgs.output, "output", "", "path where the CAR is written (default: <cid|name>.car or aggregate.car)")
+		fs.BoolVar(&dealOfflineArgs.verified, "verified", false, "make verified deals using the datacap of the default address")
+		return fs
+	})(),
+}
+
+func runDealOffline(ctx context.Context, args []string) error {
+	if len(args) == 0 || dealOfflineArgs.miners == "" {
+		return flag.ErrHelp
+	}
+	out := dealOfflineArgs.output
+	if out == "" {
+		out = args[0] + ".car"
+		if len(args) > 1 {
+			out = "aggregate.car"
+		}
+	}
+	// The daemon writes the CAR so it needs an absolute path
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	orc := make(chan *node.OfflineDealResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if or := n.OfflineResult; or != nil {
+			orc <- or
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.DealOffline(&node.DealOfflineArgs{
+		Refs:     args,
+		Miners:   strings.Split(dealOfflineArgs.miners, ","),
+		Duration: dealOfflineArgs.duration,
+		Out:      out,
+		Verified: dealOfflineArgs.verified,
+	})
+	select {
+	case or := <-orc:
+		if or.Err != "" {
+			return errors.New(or.Err)
+		}
+		fmt.Printf("==> Wrote CAR to %s\n", or.Car)
+		fmt.Printf("Piece CID: %s\n", or.PieceCID)
+		fmt.Printf("Piece size: %s\n", or.PieceSize)
+		if or.Aggregate != "" {
+			fmt.Printf("==> Packed %d payloads in aggregate %s\n", len(or.Payloads), or.Aggregate)
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "Root\tOffset\tSize\n")
+			for _, p := range or.Payloads {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", p.Root, p.Offset, p.Size)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("==> Proposed %d offline deals, deliver the CAR to each miner to import it with:\n", len(or.Deals))
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Miner\tImport\n")
+		for i, d := range or.Deals {
+			fmt.Fprintf(w, "%s\t%s\n", d.Miner, or.Imports[i])
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runDealList(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	drc := make(chan *node.DealResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if dr := n.DealResult; dr != nil {
+			drc <- dr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.DealList(&node.DealListArgs{Status: dealListArgs.status})
+	select {
+	case dr := <-drc:
+		if dr.Err != "" {
+			return errors.New(dr.Err)
+		}
+		if len(dr.Deals) == 0 {
+			fmt.Printf("==> No storage deals\n")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Proposal\tMiner\tDeal ID\tStatus\tUpdated\tMessage\n")
+		for _, d := range dr.Deals {
+			id := "-"
+			if d.DealID != 0 {
+				id = fmt.Sprintf("%d", d.DealID)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.ProposalCid, d.Miner, id, d.Status, d.Updated.Format("2006-01-02 15:04"), d.Message)
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runDealProposals(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.ProposalResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.ProposalResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.DealProposals(&node.DealProposalsArgs{
+		Miner:   dealProposalsArgs.miner,
+		Ref:     dealProposalsArgs.ref,
+		Outcome: dealProposalsArgs.outcome,
+	})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if len(pr.Proposals) == 0 {
+			fmt.Printf("==> No deal proposals\n")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Created\tMiner\tRoot\tPrice/epoch\tPiece size\tEpochs\tOutcome\tMessage\n")
+		for _, p := range pr.Proposals {
+			epochs := "-"
+			if p.EndEpoch != 0 {
+				epochs = fmt.Sprintf("%d-%d", p.StartEpoch, p.EndEpoch)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Created.Format("2006-01-02 15:04"), p.Miner, p.Root, p.Price, p.PieceSize, epochs, p.Outcome, p.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("==> Outcomes per miner\n")
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Miner\tAccepted\tRejected\tError\tFailed\tReject rate\n")
+		for _, m := range pr.Miners {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.0f%%\n", m.Miner, m.Accepted, m.Rejected, m.Errored, m.Failed, m.RejectRate*100)
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runDealPush(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	var miners, prices []string
+	if dealPushArgs.miners != "" {
+		miners = strings.Split(dealPushArgs.miners, ",")
+	}
+	if dealPushArgs.prices != "" {
+		prices = strings.Split(dealPushArgs.prices, ",")
+	}
+	var regions []string
+	if dealPushArgs.regions != "" {
+		regions = strings.Split(dealPushArgs.regions, ",")
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	drc := make(chan *node.DealResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if dr := n.DealResult; dr != nil {
+			drc <- dr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.DealPush(&node.DealPushArgs{
+		Ref:      args[0],
+		Refs:     args[1:],
+		Miners:   miners,
+		Prices:   prices,
+		RF:       dealPushArgs.rf,
+		MaxPrice: dealPushArgs.maxPrice,
+		Region:   dealPushArgs.region,
+		Duration: dealPushArgs.duration,
+		Verified: dealPushArgs.verified,
+
+		FastRetrieval:        dealPushArgs.fast,
+		StartBuffer:          dealPushArgs.buffer,
+		StartEpoch:           dealPushArgs.start,
+		CollateralMultiplier: dealPushArgs.coll,
+
+		MinReputation:  dealPushArgs.minRep,
+		Regions:        regions,
+		MaxLatency:     dealPushArgs.latency,
+		DistinctOwners: dealPushArgs.owners,
+		SectorLifetime: dealPushArgs.lifetime,
+	})
+	select {
+	case dr := <-drc:
+		if dr.Err != "" {
+			return errors.New(dr.Err)
+		}
+		if dr.Aggregate != "" {
+			fmt.Printf("==> Packed %d refs in aggregate %s\n", len(args), dr.Aggregate)
+		}
+		fmt.Printf("==> Queued %d storage deals, follow them with 'pop deal queue' and 'pop deal list'\n", len(dr.Jobs))
+		return printJobs(dr.Jobs)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runDealQueue(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	drc := make(chan *node.DealResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if dr := n.DealResult; dr != nil {
+			drc <- dr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.DealQueue(&node.DealQueueArgs{All: dealQueueArgs.all})
+	select {
+	case dr := <-drc:
+		if dr.Err != "" {
+			return errors.New(dr.Err)
+		}
+		if len(dr.Jobs) == 0 {
+			fmt.Printf("==> No queued deals\n")
+			return nil
+		}
+		return printJobs(dr.Jobs)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func printJobs(jobs []node.DealJobInfo) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Root\tMiner\tStatus\tAttempts\tNext attempt\tError\n")
+	for _, j := range jobs {
+		next := "-"
+		if j.Status == "queued" || j.Status == "retrying" {
+			next = j.NextAttempt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", j.Root, j.Miner, j.Status, j.Attempts, next, j.Err)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var replayCmd = &ffcli.Command{
+	Name:       "replay",
+	ShortUsage: "debug replay <op-id>",
+	ShortHelp:  "Re-render the output of a recorded operation",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop debug replay <op-id>' command renders the notifications recorded during an operation as the CLI
+displayed them without connecting to the daemon. Operations are recorded when running a command with
+'pop -record <subcommand>' and are stored in the debug/ops directory of the repo, the files can be
+attached to bug reports.
+
+`),
+}
+
+func init() {
+	// set here since replaying runs the root command which lists this one
+	replayCmd.Exec = runReplay
+}
+
+var debugCmd = &ffcli.Command{
+	Name:       "debug",
+	ShortUsage: "debug <subcommand>",
+	ShortHelp:  "Debug operations recorded with -record",
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	FlagSet:     flag.NewFlagSet("debug", flag.ExitOnError),
+	Subcommands: []*ffcli.Command{replayCmd},
+}
+
+// opHeader is the first entry of a recorded operation
+type opHeader struct {
+	ID      string
+	Args    []string
+	Started time.Time
+}
+
+// opMsg is a notification received during a recorded operation
+type opMsg struct {
+	Elapsed time.Duration
+	Notify  json.RawMessage
+}
+
+// opRecorder appends the notifications of an operation to a file
+type opRecorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// opRec records the notifications received by the current command if -record is set
+var opRec *opRecorder
+
+// replaying is set when the current command is served a recorded operation instead of connecting to the daemon
+var replaying bool
+
+// replayMsgs are the notifications of the operation being replayed
+var replayMsgs []opMsg
+
+func opsDir() (string, error) {
+	return utils.FullPath(filepath.Join(utils.RepoPath(), "debug", "ops"))
+}
+
+// newOpRecorder creates a file to record the operation executed with the given args and returns its ID
+func newOpRecorder(args []string) (*opRecorder, string, error) {
+	dir, err := opsDir()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", err
+	}
+	start := time.Now()
+	id := fmt.Sprintf("%s-%d", start.UTC().Format("20060102T150405"), os.Getpid())
+	f, err := os.Create(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, "", err
+	}
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(opHeader{ID: id, Args: args, Started: start}); err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return &opRecorder{f: f, enc: enc, start: start}, id, nil
+}
+
+func (r *opRecorder) record(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// notifications received after the command is over are not part of the operation
+	if r.f == nil {
+		return
+	}
+	err := r.enc.Encode(opMsg{
+		Elapsed: time.Since(r.start),
+		Notify:  json.RawMessage(msg),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to record notification: %v\n", err)
+	}
+}
+
+// close the recording file once the command is over
+func (r *opRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// loadOp reads a recorded operation
+func loadOp(id string) (opHeader, []opMsg, error) {
+	dir, err := opsDir()
+	if err != nil {
+		return opHeader{}, nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return opHeader{}, nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	var hdr opHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return opHeader{}, nil, fmt.Errorf("invalid recording: %w", err)
+	}
+	var msgs []opMsg
+	for {
+		var m opMsg
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// The operation may have been interrupted while writing so we replay what we could read
+			fmt.Fprintf(os.Stderr, "recording truncated: %v\n", err)
+			break
+		}
+		msgs = append(msgs, m)
+	}
+	return hdr, msgs, nil
+}
+
+// replayConn returns a connection sending the recorded notifications to the client as the daemon did
+func replayConn(msgs []opMsg) net.Conn {
+	client, server := net.Pipe()
+	// Commands sent by the client are discarded since the operation already ran.
+	// The client closing its end stops the copy and we close ours.
+	go func() {
+		io.Copy(io.Discard, server)
+		server.Close()
+	}()
+	go func() {
+		for _, m := range msgs {
+			if err := node.WriteMsg(server, m.Notify); err != nil {
+				return
+			}
+		}
+	}()
+	return client
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	hdr, msgs, err := loadOp(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replaying 'pop %s' from %s\n", strings.Join(hdr.Args, " "), hdr.Started.Format(time.RFC3339))
+	replaying = true
+	replayMsgs = msgs
+	return Run(hdr.Args)
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/stretchr/testify/require"
+)
+
+// setRepo points the repo used for recordings to a temporary directory
+func setRepo(t *testing.T) {
+	for k, v := range map[string]string{"HOME": t.TempDir(), "POP_PATH": ".pop"} {
+		prev, ok := os.LookupEnv(k)
+		require.NoError(t, os.Setenv(k, v))
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, prev)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestRecordOp(t *testing.T) {
+	setRepo(t)
+
+	args := []string{"wallet", "send", "f01001", "0.1"}
+	rec, id, err := newOpRecorder(args)
+	require.NoError(t, err)
+
+	notifs := []node.Notify{
+		{WalletResult: &node.WalletResult{Addresses: []string{"f01000"}}},
+		{WalletResult: &node.WalletResult{Err: "insufficient funds"}},
+	}
+	for _, n := range notifs {
+		b, err := json.Marshal(n)
+		require.NoError(t, err)
+		rec.record(b)
+	}
+	require.NoError(t, rec.close())
+	// notifications received after the command is over are not recorded
+	rec.record([]byte(`{}`))
+
+	hdr, msgs, err := loadOp(id)
+	require.NoError(t, err)
+	require.Equal(t, id, hdr.ID)
+	require.Equal(t, args, hdr.Args)
+	require.Len(t, msgs, 2)
+	require.True(t, msgs[0].Elapsed <= msgs[1].Elapsed)
+
+	// the conn serves the recorded notifications in order
+	conn := replayConn(msgs)
+	defer conn.Close()
+	for _, expected := range notifs {
+		msg, err := node.ReadMsg(conn)
+		require.NoError(t, err)
+		var n node.Notify
+		require.NoError(t, json.Unmarshal(msg, &n))
+		require.Equal(t, expected, n)
+	}
+
+	// a recording interrupted while writing is replayed up to the last complete notification
+	dir, err := opsDir()
+	require.NoError(t, err)
+	f, err := os.OpenFile(filepath.Join(dir, id+".json"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"Elapsed":10,"Notify":{"WalletRes`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, msgs, err = loadOp(id)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	_, _, err = loadOp("unknown")
+	require.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	setRepo(t)
+	t.Cleanup(func() {
+		replaying = false
+		replayMsgs = nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	rec, id, err := newOpRecorder([]string{"wallet", "send", "f01001", "0.1"})
+	require.NoError(t, err)
+	b, err := json.Marshal(node.Notify{WalletResult: &node.WalletResult{Err: "insufficient funds"}})
+	require.NoError(t, err)
+	rec.record(b)
+
+	// the command renders the recorded result without a daemon
+	require.EqualError(t, runReplay(ctx, []string{id}), "insufficient funds")
+}
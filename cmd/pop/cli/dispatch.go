@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var dispatchArgs struct {
+	cacheRF int
+}
+
+var dispatchCmd = &ffcli.Command{
+	Name:       "dispatch",
+	ShortUsage: "dispatch <cid>...",
+	ShortHelp:  "Dispatch committed refs to caches in a single batch",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop dispatch' command sends one or multiple committed refs to cache providers in our regions.
+All the refs are dispatched in a single operation so each selected cache receives requests for all of them
+instead of running a round of peer selection for each ref, i.e. when publishing a release made of multiple commits.
+
+`),
+	Exec: runDispatch,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("dispatch", flag.ExitOnError)
+		fs.IntVar(&dispatchArgs.cacheRF, "cache-rf", 2, "number of cache providers to dispatch each ref to")
+		return fs
+	})(),
+}
+
+func runDispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	drc := make(chan *node.DispatchResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if dr := n.DispatchResult; dr != nil {
+			drc <- dr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Dispatch(&node.DispatchArgs{
+		Refs:    args,
+		CacheRF: dispatchArgs.cacheRF,
+	})
+	caches := make(map[string]int)
+	for {
+		select {
+		case dr := <-drc:
+			if dr.Err != "" {
+				return errors.New(dr.Err)
+			}
+			if dr.Last {
+				for _, r := range args {
+					fmt.Printf("%s cached by %d peers\n", r, caches[r])
+				}
+				fmt.Printf("==> Dispatched %d refs\n", len(args))
+				return nil
+			}
+			caches[dr.Ref]++
+			fmt.Printf("  %s cached by %s\n", dr.Ref, dr.Cache)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var earningsArgs struct {
+	by string
+}
+
+var earningsCmd = &ffcli.Command{
+	Name:       "earnings",
+	ShortUsage: "earnings [-by peer|cid]",
+	ShortHelp:  "Print the FIL spent on retrievals and earned from serving",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop earnings' command prints how much FIL the daemon spent retrieving content and earned serving it,
+aggregated per peer or per content root with the 'by' flag i.e. 'pop earnings -by cid'. Accounts are sorted
+with the most profitable first so cache providers can tell which content is worth keeping.
+
+`),
+	Exec: runEarnings,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("earnings", flag.ExitOnError)
+		fs.StringVar(&earningsArgs.by, "by", node.AccountByPeer, "aggregate accounts by peer or cid")
+		return fs
+	})(),
+}
+
+func runEarnings(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	erc := make(chan *node.EarningsResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if er := n.EarningsResult; er != nil {
+			erc <- er
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Earnings(&node.EarningsArgs{By: earningsArgs.by})
+	select {
+	case er := <-erc:
+		if er.Err != "" {
+			return errors.New(er.Err)
+		}
+		if len(er.Accounts) == 0 {
+			fmt.Printf("==> No funds spent or earned yet\n")
+			return nil
+		}
+		key := "Peer"
+		if earningsArgs.by == node.AccountByCID {
+			key = "Root"
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tSpent\tEarned\tNet\n", key)
+		for _, a := range er.Accounts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Key, a.Spent, a.Earned, a.Net)
+		}
+		fmt.Fprintf(w, "Total\t%s\t%s\t%s\n", er.Spent, er.Earned, er.Net)
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
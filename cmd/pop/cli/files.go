@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var filesWrite = &ffcli.Command{
+	Name:       "write",
+	ShortUsage: "files write <local-file> </namespace/path>",
+	ShortHelp:  "Write a local file at a mutable path",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 2 {
+			return flag.ErrHelp
+		}
+		src, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		return runFiles(ctx, &node.FilesArgs{Op: node.FilesWrite, Source: src, Path: args[1]})
+	},
+}
+
+var filesMv = &ffcli.Command{
+	Name:       "mv",
+	ShortUsage: "files mv </namespace/src> </namespace/dst>",
+	ShortHelp:  "Move a file or directory within a namespace",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 2 {
+			return flag.ErrHelp
+		}
+		return runFiles(ctx, &node.FilesArgs{Op: node.FilesMv, Path: args[0], Dst: args[1]})
+	},
+}
+
+var filesRm = &ffcli.Command{
+	Name:       "rm",
+	ShortUsage: "files rm </namespace/path>",
+	ShortHelp:  "Remove a file, a directory or a whole namespace",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return flag.ErrHelp
+		}
+		return runFiles(ctx, &node.FilesArgs{Op: node.FilesRm, Path: args[0]})
+	},
+}
+
+var filesLs = &ffcli.Command{
+	Name:       "ls",
+	ShortUsage: "files ls [/namespace/path]",
+	ShortHelp:  "List the entries of a directory or all the namespaces",
+	Exec: func(ctx context.Context, args []string) error {
+		p := ""
+		if len(args) > 0 {
+			p = args[0]
+		}
+		return runFiles(ctx, &node.FilesArgs{Op: node.FilesLs, Path: p})
+	},
+}
+
+var filesPublish = &ffcli.Command{
+	Name:       "publish",
+	ShortUsage: "files publish <namespace>",
+	ShortHelp:  "Stage a snapshot of a namespace in the current transaction",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return flag.ErrHelp
+		}
+		return runFiles(ctx, &node.FilesArgs{Op: node.FilesPublish, Path: args[0]})
+	},
+}
+
+var filesCmd = &ffcli.Command{
+	Name:      "files",
+	ShortHelp: "Manage mutable files",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop files' command treats the pop as a mutable filesystem. Files are written at paths starting with a
+namespace i.e. /blog/posts/hello.md and each namespace keeps a root CID updated after every change.
+'pop files publish <namespace>' stages a snapshot of the namespace so it can be committed with 'pop commit'.
+
+`),
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	FlagSet:     flag.NewFlagSet("files", flag.ExitOnError),
+	Subcommands: []*ffcli.Command{filesWrite, filesMv, filesRm, filesLs, filesPublish},
+}
+
+func runFiles(ctx context.Context, args *node.FilesArgs) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	frc := make(chan *node.FilesResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if fr := n.FilesResult; fr != nil {
+			frc <- fr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Files(args)
+	select {
+	case fr := <-frc:
+		if fr.Err != "" {
+			return errors.New(fr.Err)
+		}
+		for _, e := range fr.Entries {
+			fmt.Println(e)
+		}
+		if fr.Root != "" {
+			fmt.Printf("==> Root %s\n", fr.Root)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
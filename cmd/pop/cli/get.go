@@ -12,34 +12,41 @@ import (
 )
 
 var getArgs struct {
-	selector string
-	output   string
-	timeout  int
-	verbose  bool
-	miner    string
-	strategy string
-	maxppb   int64
+	selector  string
+	output    string
+	timeout   int
+	verbose   bool
+	miner     string
+	strategy  string
+	maxppb    int64
+	from      string
+	maxUnseal int64
 }
 
 var getCmd = &ffcli.Command{
 	Name:       "get",
-	ShortUsage: "get <cid>",
+	ShortUsage: "get <cid|name>",
 	ShortHelp:  "Retrieve content from the network",
 	LongHelp: strings.TrimSpace(`
 The 'pop get' command retrieves blocks with a given root cid and an optional selector
 (defaults retrieves all the linked blocks). Passing an output flag with a path will write the
 data to disk. Adding a miner flag will fallback to miner if content is not available on the secondary market.
+A name given to a commit can be used instead of the cid to get its latest version.
+If no cache offers content we stored with Filecoin deals, the miners holding it are queried automatically
+and the max-unseal flag caps the price we pay them to unseal it.
 `),
 	Exec: runGet,
 	FlagSet: (func() *flag.FlagSet {
 		fs := flag.NewFlagSet("get", flag.ExitOnError)
 		fs.StringVar(&getArgs.selector, "selector", "all", "select blocks to retrieve for a root cid")
-		fs.StringVar(&getArgs.output, "output", "", "write the file to the path")
-		fs.IntVar(&getArgs.timeout, "timeout", 60, "timeout before the request should be cancelled by the node (in minutes)")
+		fs.StringVar(&getArgs.output, "output", "", "write the file to the path or to an object storage url i.e. s3://bucket/key or gs://bucket/key")
+		fs.IntVar(&getArgs.timeout, "timeout", 60, "timeout before the request should be cancelled by the node in minutes (0=\"derived from the content size\")")
 		fs.BoolVar(&getArgs.verbose, "verbose", false, "print the state transitions")
 		fs.StringVar(&getArgs.miner, "miner", "", "ask storage miner and use as fallback if network does not have the content")
-		fs.StringVar(&getArgs.strategy, "strategy", "SelectFirst", "strategy for selecting offers from providers")
+		fs.StringVar(&getArgs.strategy, "strategy", "SelectFirst", "strategy for selecting offers from providers (SelectFirst, SelectCheapest, SelectFirstLowerThan, SelectByReputation)")
 		fs.Int64Var(&getArgs.maxppb, "maxppb", 0, "max price per byte (0=\"default node's value\", -1=\"free retrieval\")")
+		fs.StringVar(&getArgs.from, "from", "", "wallet address paying for the retrieval (default: wallet default address)")
+		fs.Int64Var(&getArgs.maxUnseal, "max-unseal", 0, "max price in attoFIL paid to a fallback storage miner to unseal the content (0=\"no paid unseal\", -1=\"no limit\")")
 		return fs
 	})(),
 }
@@ -57,14 +64,16 @@ func runGet(ctx context.Context, args []string) error {
 	go receive(ctx, cc, c)
 
 	cc.Get(&node.GetArgs{
-		Cid:      args[0],
-		Timeout:  getArgs.timeout,
-		Sel:      getArgs.selector,
-		Out:      getArgs.output,
-		Verbose:  getArgs.verbose,
-		Miner:    getArgs.miner,
-		Strategy: getArgs.strategy,
-		MaxPPB:   getArgs.maxppb,
+		Cid:       args[0],
+		Timeout:   getArgs.timeout,
+		Sel:       getArgs.selector,
+		Out:       getArgs.output,
+		Verbose:   getArgs.verbose,
+		Miner:     getArgs.miner,
+		Strategy:  getArgs.strategy,
+		MaxPPB:    getArgs.maxppb,
+		From:      getArgs.from,
+		MaxUnseal: getArgs.maxUnseal,
 	})
 
 	for {
@@ -73,6 +82,14 @@ func runGet(ctx context.Context, args []string) error {
 			if gr.Err != "" {
 				return errors.New(gr.Err)
 			}
+			if gr.Shortfall != "" {
+				fmt.Printf("==> Paused retrieval deal %s, run \"pop paych topup %s\" to add the missing %s\n", gr.DealID, gr.DealID, gr.Shortfall)
+				continue
+			}
+			if gr.Paused != "" {
+				fmt.Printf("==> Retrieval deal %s: %s, resuming once the payment channel has the funds\n", gr.DealID, gr.Paused)
+				continue
+			}
 			if gr.DealID != "" && gr.TotalFunds == "0" {
 				fmt.Printf("==> Started free transfer\n")
 				continue
@@ -81,6 +98,12 @@ func runGet(ctx context.Context, args []string) error {
 				fmt.Printf("==> Started retrieval deal %s for a total of %s (%s/b)\n", gr.DealID, gr.TotalFunds, gr.PricePerByte)
 				continue
 			}
+			if gr.Status == "DealStatusSelectedOffer" {
+				if gr.UnsealPrice != "" && gr.UnsealPrice != "0" {
+					fmt.Printf("==> Storage miner must unseal the content for %s\n", gr.UnsealPrice)
+				}
+				continue
+			}
 			if gr.Local {
 				fmt.Printf("Blocks already in store\n")
 				return nil
@@ -92,7 +115,7 @@ func runGet(ctx context.Context, args []string) error {
 			}
 
 			if getArgs.output != "" {
-				fmt.Printf("==> Exported content to disk\n")
+				fmt.Printf("==> Exported content to %s\n", getArgs.output)
 			}
 			return nil
 		case <-ctx.Done():
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var labelCmd = &ffcli.Command{
+	Name:       "label",
+	ShortUsage: "label <cid> <key=value>...",
+	ShortHelp:  "Set labels on a ref",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop label' command attaches key/value labels to a ref in the index, i.e. 'pop label <cid> env=prod'.
+Labels can be used to filter refs when listing or searching and in eviction policies. Set an empty value
+to remove a label, i.e. 'pop label <cid> env='.
+
+`),
+	Exec: runLabel,
+}
+
+// parseLabels converts a list of key=value strings into a map
+func parseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %s: expected key=value", a)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+func runLabel(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+	labels, err := parseLabels(args[1:])
+	if err != nil {
+		return err
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	lrc := make(chan *node.LabelResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if lr := n.LabelResult; lr != nil {
+			lrc <- lr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Label(&node.LabelArgs{
+		Cid:    args[0],
+		Labels: labels,
+	})
+	select {
+	case lr := <-lrc:
+		if lr.Err != "" {
+			return errors.New(lr.Err)
+		}
+		fmt.Printf("==> Labeled %s %s\n", args[0], formatLabels(lr.Labels))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatLabels prints labels as a comma separated list of key=value
+func formatLabels(labels map[string]string) string {
+	var kvs []string
+	for k, v := range labels {
+		kvs = append(kvs, k+"="+v)
+	}
+	return strings.Join(kvs, ",")
+}
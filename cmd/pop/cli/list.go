@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"strings"
 
@@ -11,19 +12,40 @@ import (
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
+var listArgs struct {
+	labels string
+	name   string
+}
+
 var listCmd = &ffcli.Command{
 	Name:      "list",
 	ShortHelp: "List all content indexed in this pop",
 	LongHelp: strings.TrimSpace(`
 
 The 'pop list' command prints root CIDs for all the indexed content currently provided by this pop. Content is
-indexed by DAG root so usage frequencies is compiled by root too.
+indexed by DAG root so usage frequencies is compiled by root too. The name flag lists all the versions of a name
+from the latest.
 
 `),
 	Exec: runList,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		fs.StringVar(&listArgs.labels, "labels", "", "only list refs with these labels, i.e. env=prod,tier=best-effort")
+		fs.StringVar(&listArgs.name, "name", "", "only list the versions of this name")
+		return fs
+	})(),
 }
 
 func runList(ctx context.Context, args []string) error {
+	var labels map[string]string
+	if listArgs.labels != "" {
+		var err error
+		labels, err = parseLabels(strings.Split(listArgs.labels, ","))
+		if err != nil {
+			return err
+		}
+	}
+
 	c, cc, ctx, cancel := connect(ctx)
 	defer cancel()
 
@@ -38,12 +60,25 @@ func runList(ctx context.Context, args []string) error {
 	})
 	go receive(ctx, cc, c)
 
-	cc.List(&node.ListArgs{})
+	cc.List(&node.ListArgs{Labels: labels, Name: listArgs.name})
 	for ref := range lrc {
 		if ref.Err != "" {
 			return errors.New(ref.Err)
 		}
-		fmt.Printf("Tx %s %s %d\n", ref.Root, filecoin.SizeStr(filecoin.NewInt(uint64(ref.Size))), ref.Freq)
+		pinned := ""
+		if ref.Pinned {
+			pinned = " pinned"
+		}
+		fmt.Printf("Tx %s%s %s %d queries=%d retrievals=%d %s %s\n",
+			ref.Root,
+			pinned,
+			filecoin.SizeStr(filecoin.NewInt(uint64(ref.Size))),
+			ref.Freq,
+			ref.Queries,
+			ref.Retrievals,
+			ref.Name,
+			formatLabels(ref.Labels),
+		)
 	}
 	return nil
 }
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var moveArgs struct {
+	to string
+}
+
+var moveCmd = &ffcli.Command{
+	Name:       "move",
+	ShortUsage: "move -to <peer> <cid>",
+	ShortHelp:  "Move a committed ref to another peer",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop move -to <peer> <cid>' command dispatches a committed ref to the given peer ID or address, waits for
+the peer to sign a receipt and pass an audit proving it holds the content then removes the local copy.
+It can be used to rebalance content between caches without a window during which the content is under replicated.
+
+`),
+	Exec: runMove,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("move", flag.ExitOnError)
+		fs.StringVar(&moveArgs.to, "to", "", "peer ID or address of the peer to move the ref to")
+		return fs
+	})(),
+}
+
+func runMove(ctx context.Context, args []string) error {
+	// Flags after the CID are not parsed by the flag set
+	if len(args) == 3 && (args[1] == "-to" || args[1] == "--to") {
+		moveArgs.to = args[2]
+		args = args[:1]
+	}
+	if len(args) != 1 || moveArgs.to == "" {
+		return flag.ErrHelp
+	}
+	ref := args[0]
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	mrc := make(chan *node.MoveResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if mr := n.MoveResult; mr != nil && mr.Ref == ref {
+			mrc <- mr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Move(&node.MoveArgs{
+		Ref: ref,
+		To:  moveArgs.to,
+	})
+	for {
+		select {
+		case mr := <-mrc:
+			if mr.Err != "" {
+				return errors.New(mr.Err)
+			}
+			if mr.Status == "moved" {
+				fmt.Printf("==> Moved %s to %s (%d bytes)\n", mr.Ref, mr.Peer, mr.Size)
+				return nil
+			}
+			fmt.Printf("%s to %s\n", mr.Status, mr.Peer)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
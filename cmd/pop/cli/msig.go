@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var msigPropose = &ffcli.Command{
+	Name:       "propose",
+	ShortUsage: "wallet msig propose <msig> <from> <to> <amount>",
+	ShortHelp:  "Propose to send funds from a multisig wallet",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 4 {
+			return flag.ErrHelp
+		}
+		return runMsig(ctx, &node.WalletMsigArgs{Op: "propose", Msig: args[0], From: args[1], To: args[2], Amount: args[3]})
+	},
+}
+
+var msigFund = &ffcli.Command{
+	Name:       "fund",
+	ShortUsage: "wallet msig fund <msig> <from> <client> <amount>",
+	ShortHelp:  "Propose to add funds from a multisig wallet to the storage market escrow of a client address",
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 4 {
+			return flag.ErrHelp
+		}
+		return runMsig(ctx, &node.WalletMsigArgs{Op: "fund", Msig: args[0], From: args[1], To: args[2], Amount: args[3]})
+	},
+}
+
+var msigApprove = &ffcli.Command{
+	Name:       "approve",
+	ShortUsage: "wallet msig approve <msig> <from> <txid>",
+	ShortHelp:  "Approve a pending multisig transaction",
+	Exec: func(ctx context.Context, args []string) error {
+		return runMsigTxn(ctx, "approve", args)
+	},
+}
+
+var msigCancel = &ffcli.Command{
+	Name:       "cancel",
+	ShortUsage: "wallet msig cancel <msig> <from> <txid>",
+	ShortHelp:  "Cancel a multisig transaction you proposed",
+	Exec: func(ctx context.Context, args []string) error {
+		return runMsigTxn(ctx, "cancel", args)
+	},
+}
+
+var msigPending = &ffcli.Command{
+	Name:       "pending",
+	ShortUsage: "wallet msig pending [msig]",
+	ShortHelp:  "List the multisig transactions waiting for approvals",
+	Exec: func(ctx context.Context, args []string) error {
+		margs := &node.WalletMsigArgs{Op: "pending"}
+		if len(args) > 0 {
+			margs.Msig = args[0]
+		}
+		return runMsig(ctx, margs)
+	},
+}
+
+var msigCmd = &ffcli.Command{
+	Name:       "msig",
+	ShortUsage: "wallet msig <subcommand>",
+	ShortHelp:  "Spend from a multisig wallet you are a signer of",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop wallet msig' command proposes and approves the transactions of a multisig wallet.
+Organizations may fund storage deals from a treasury controlled by several signers by proposing
+to add funds to the market escrow of a client address. Pending proposals sent or approved from
+this node are tracked until they are executed.
+
+`),
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	Subcommands: []*ffcli.Command{msigPropose, msigFund, msigApprove, msigCancel, msigPending},
+}
+
+func runMsigTxn(ctx context.Context, op string, args []string) error {
+	if len(args) != 3 {
+		return flag.ErrHelp
+	}
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid transaction ID: %w", err)
+	}
+	return runMsig(ctx, &node.WalletMsigArgs{Op: op, Msig: args[0], From: args[1], TxnID: id})
+}
+
+func runMsig(ctx context.Context, args *node.WalletMsigArgs) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	results := make(chan *node.WalletResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.WalletMsig(args)
+
+	select {
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+		if args.Op == "cancel" {
+			fmt.Printf("==> Cancelled transaction %d\n", args.TxnID)
+			return nil
+		}
+		if args.Op != "pending" && len(wr.Proposals) == 1 && wr.Proposals[0].Applied {
+			fmt.Printf("==> Executed transaction %d\n", wr.Proposals[0].TxnID)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Msig\tTxID\tTo\tAmount\tMethod\tApprovals\n")
+		for _, p := range wr.Proposals {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\n", p.Msig, p.TxnID, p.To, p.Amount, p.Method, p.Approvals)
+		}
+		return w.Flush()
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
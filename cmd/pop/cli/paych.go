@@ -0,0 +1,344 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var listChans = &ffcli.Command{
+	Name:       "list",
+	ShortUsage: "paych list",
+	ShortHelp:  "List all your payment channels",
+	Exec:       runListChans,
+}
+
+var inspect = &ffcli.Command{
+	Name:       "inspect",
+	ShortUsage: "paych inspect <channel>",
+	ShortHelp:  "Show the funds, pending messages and lanes of a payment channel",
+	Exec:       runInspect,
+}
+
+var settle = &ffcli.Command{
+	Name:       "settle",
+	ShortUsage: "paych settle <channel>",
+	ShortHelp:  "Redeem vouchers and settle a payment channel",
+	Exec:       runSettle,
+}
+
+var collect = &ffcli.Command{
+	Name:       "collect",
+	ShortUsage: "paych collect <channel>",
+	ShortHelp:  "Collect the funds of a settled payment channel",
+	Exec:       runCollect,
+}
+
+var topUp = &ffcli.Command{
+	Name:       "topup",
+	ShortUsage: "paych topup <deal> [amount]",
+	ShortHelp:  "Add funds to the channel of a retrieval deal paused for lack of funds",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop paych topup' command adds funds to the payment channel of a retrieval deal which
+cannot pay for the next interval. The deal resumes once the funds are available. If no amount
+is given, the shortfall of the deal is added.
+
+`),
+	Exec: runTopUp,
+}
+
+var exportChans = &ffcli.Command{
+	Name:       "export",
+	ShortUsage: "paych export <path>",
+	ShortHelp:  "Export your payment channels and vouchers to an encrypted file",
+	Exec:       runExportChans,
+}
+
+var importChans = &ffcli.Command{
+	Name:       "import",
+	ShortUsage: "paych import <path>",
+	ShortHelp:  "Import payment channels and vouchers exported from another node",
+	Exec:       runImportChans,
+}
+
+var paychCmd = &ffcli.Command{
+	Name:      "paych",
+	ShortHelp: "Manage your payment channels",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop paych' command manages the payment channels used to pay for retrievals. You can list your channels
+and inspect their funds, pending messages and lanes. Settling an inbound channel
+submits the best vouchers we received then starts the settlement window. Once it elapsed the funds can be
+collected. Settled channels are also collected automatically while the node is running.
+Channels and vouchers can be exported to a file encrypted with a passphrase and imported on another
+node when migrating hardware. The passphrase is read from $POP_PASSPHRASE or prompted.
+
+`),
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	FlagSet:     flag.NewFlagSet("paych", flag.ExitOnError),
+	Subcommands: []*ffcli.Command{listChans, inspect, settle, collect, topUp, exportChans, importChans},
+}
+
+func runListChans(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PaychResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PaychResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.PaychList(&node.PaychListArgs{})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if len(pr.Channels) == 0 {
+			fmt.Printf("==> No payment channels\n")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Channel\tDirection\tTarget\tAmount\tVouchers\tSettling\n")
+		for _, ch := range pr.Channels {
+			settling := "-"
+			if ch.Settling {
+				settling = fmt.Sprintf("epoch %d", ch.SettlingAt)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", ch.Channel, ch.Direction, ch.Target, ch.Amount, ch.Vouchers, settling)
+		}
+		w.Flush()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runInspect(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PaychResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PaychResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.PaychInspect(&node.PaychInspectArgs{Channel: args[0]})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		ch := pr.Channels[0]
+		fmt.Printf("Channel:    %s\n", ch.Channel)
+		fmt.Printf("Direction:  %s\n", ch.Direction)
+		fmt.Printf("Control:    %s\n", ch.Control)
+		fmt.Printf("Target:     %s\n", ch.Target)
+		fmt.Printf("Amount:     %s\n", ch.Amount)
+		if ch.Pending != "" {
+			fmt.Printf("Pending:    %s\n", ch.Pending)
+		}
+		if ch.Redeemed != "" {
+			fmt.Printf("Redeemed:   %s\n", ch.Redeemed)
+		}
+		fmt.Printf("Vouchers:   %d\n", ch.Vouchers)
+		if ch.Settling {
+			fmt.Printf("Settling:   collectable after epoch %d\n", ch.SettlingAt)
+		}
+		for _, m := range ch.PendingMsgs {
+			fmt.Printf("Pending message: %s\n", m)
+		}
+		if len(ch.Lanes) > 0 {
+			fmt.Printf("==> Lanes:\n")
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "Lane\tNonce\tRedeemed\n")
+			for _, l := range ch.Lanes {
+				fmt.Fprintf(w, "%d\t%d\t%s\n", l.Lane, l.Nonce, l.Redeemed)
+			}
+			w.Flush()
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runSettle(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	return runPaych(ctx, func(cc *node.CommandClient) {
+		cc.PaychSettle(&node.PaychSettleArgs{Channel: args[0]})
+	})
+}
+
+func runCollect(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	return runPaych(ctx, func(cc *node.CommandClient) {
+		cc.PaychCollect(&node.PaychCollectArgs{Channel: args[0]})
+	})
+}
+
+func runTopUp(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return flag.ErrHelp
+	}
+	targs := &node.PaychTopUpArgs{Deal: args[0]}
+	if len(args) == 2 {
+		targs.Amount = args[1]
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PaychResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PaychResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.PaychTopUp(targs)
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		fmt.Printf("==> Topped up retrieval deal %s\n", args[0])
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runPaych sends a payment channel command and prints the resulting channel state
+func runPaych(ctx context.Context, send func(cc *node.CommandClient)) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PaychResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PaychResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	send(cc)
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if pr.Settling {
+			fmt.Printf("==> Channel %s settling, collectable after epoch %d\n", pr.Channel, pr.SettlingAt)
+			return nil
+		}
+		fmt.Printf("==> Collected channel %s\n", pr.Channel)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runExportChans(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	pass, err := exportPassphrase()
+	if err != nil {
+		return err
+	}
+	return runPaychFile(ctx, func(cc *node.CommandClient) {
+		cc.PaychExport(&node.PaychExportArgs{Path: path, Passphrase: pass})
+	}, func(pr *node.PaychResult) {
+		fmt.Printf("==> Exported payment channels to %s\n", pr.Path)
+	})
+}
+
+func runImportChans(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	pass, err := exportPassphrase()
+	if err != nil {
+		return err
+	}
+	return runPaychFile(ctx, func(cc *node.CommandClient) {
+		cc.PaychImport(&node.PaychImportArgs{Path: path, Passphrase: pass})
+	}, func(pr *node.PaychResult) {
+		fmt.Printf("==> Imported %d payment channels from %s\n", pr.Imported, pr.Path)
+	})
+}
+
+// exportPassphrase reads the passphrase encrypting payment channel exports
+func exportPassphrase() (string, error) {
+	if pass, ok := os.LookupEnv("POP_PASSPHRASE"); ok {
+		return pass, nil
+	}
+	var pass string
+	prompt := &survey.Password{
+		Message: "Passphrase to encrypt the payment channels export",
+	}
+	if err := survey.AskOne(prompt, &pass); err != nil {
+		return "", err
+	}
+	return pass, nil
+}
+
+// runPaychFile sends an export or import command and prints the result
+func runPaychFile(ctx context.Context, send func(cc *node.CommandClient), show func(pr *node.PaychResult)) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PaychResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PaychResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	send(cc)
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		show(pr)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var pinArgs struct {
+	rm bool
+}
+
+var pinCmd = &ffcli.Command{
+	Name:       "pin",
+	ShortUsage: "pin <cid>...",
+	ShortHelp:  "Protect refs from eviction",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop pin' command protects the given refs so they are never evicted when the index is over capacity
+nor garbage collected. Pinned refs are only removed when explicitly moved or dropped. Use the -rm flag
+to unpin them. Without arguments it lists all the pinned refs.
+
+`),
+	Exec: runPin,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("pin", flag.ExitOnError)
+		fs.BoolVar(&pinArgs.rm, "rm", false, "unpin the given refs")
+		return fs
+	})(),
+}
+
+func runPin(ctx context.Context, args []string) error {
+	if pinArgs.rm && len(args) == 0 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PinResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PinResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Pin(&node.PinArgs{
+		Cids:  args,
+		Unpin: pinArgs.rm,
+	})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if len(pr.Roots) == 0 {
+			fmt.Printf("==> Nothing pinned\n")
+			return nil
+		}
+		fmt.Printf("==> Pinned refs:\n")
+		for _, r := range pr.Roots {
+			fmt.Printf("%s\n", r)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
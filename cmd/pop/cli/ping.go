@@ -60,6 +60,9 @@ Peers          %s
 Latency (s)    %f
 Version        %s
 		`, pr.ID, pr.Addrs, pr.Peers, pr.LatencySeconds, pr.Version)
+		for name, n := range pr.Crashes {
+			fmt.Printf("Crashes        %s: %d\n", name, n)
+		}
 
 	case <-ctx.Done():
 		return ctx.Err()
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var protectArgs struct {
+	rm bool
+}
+
+var protectCmd = &ffcli.Command{
+	Name:       "protect",
+	ShortUsage: "protect <peer-id>...",
+	ShortHelp:  "Protect peer connections from being pruned",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop protect' command prevents the connection manager from trimming connections with the given peers
+i.e. the top caches in our regions. Protected peers are persisted across restarts. Use the -rm flag to remove
+peers from the protected set. Without arguments it lists all the protected peers.
+
+`),
+	Exec: runProtect,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("protect", flag.ExitOnError)
+		fs.BoolVar(&protectArgs.rm, "rm", false, "unprotect the given peers")
+		return fs
+	})(),
+}
+
+func runProtect(ctx context.Context, args []string) error {
+	if protectArgs.rm && len(args) == 0 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.ProtectResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.ProtectResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Protect(&node.ProtectArgs{
+		Peers:     args,
+		Unprotect: protectArgs.rm,
+	})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if len(pr.Peers) == 0 {
+			fmt.Printf("==> No protected peers\n")
+			return nil
+		}
+		fmt.Printf("==> Protected peers:\n")
+		for _, p := range pr.Peers {
+			fmt.Printf("%s\n", p)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"github.com/myelnet/pop/internal/objstore"
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
@@ -21,12 +22,16 @@ var putArgs struct {
 
 var putCmd = &ffcli.Command{
 	Name:       "put",
-	ShortUsage: "put <file-path>",
+	ShortUsage: "put <file-path|url>",
 	ShortHelp:  "Put a file into an exchange transaction for storage",
 	LongHelp: strings.TrimSpace(`
 
 The 'pop put' command opens a given file, chunks it, links it as an ipld DAG and 
 stores the blocks in the block store. The DAG is then staged in a pending or new storage transaction.
+Each file in a directory is staged as a separate entry while nested directories keep their structure
+so files can be retrieved with their full path i.e. 'pop get <root>/assets/img/logo.png'.
+Passing an object storage url i.e. s3://bucket/prefix or gs://bucket/prefix streams all the objects
+under the prefix without staging them on disk. Running it again after a failure resumes where it stopped.
 
 `),
 	Exec: runPut,
@@ -50,7 +55,7 @@ func runPut(ctx context.Context, args []string) error {
 	go receive(ctx, cc, c)
 
 	filePath := args[0]
-	isAbsPath := filepath.IsAbs(filePath)
+	isAbsPath := filepath.IsAbs(filePath) || objstore.IsURL(filePath)
 	if !isAbsPath {
 		// if path is relative, convert it to absolute
 		mydir, err := os.Getwd()
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var regionLsArgs struct {
+	region string
+	limit  int
+}
+
+var regionLs = &ffcli.Command{
+	Name:       "ls",
+	ShortUsage: "region ls [-region name] [-limit 20]",
+	ShortHelp:  "List the content recently seen in our regions",
+	Exec:       runRegionLs,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("ls", flag.ExitOnError)
+		fs.StringVar(&regionLsArgs.region, "region", "", "only list the content seen in the given region")
+		fs.IntVar(&regionLsArgs.limit, "limit", 20, "maximum number of refs to list")
+		return fs
+	})(),
+}
+
+var regionCmd = &ffcli.Command{
+	Name:      "region",
+	ShortHelp: "Inspect the content popular in our regions",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop region ls' command lists the refs queried by clients or dispatched to us in our regions during the
+last 24 hours, most popular first. Refs we don't hold yet are good candidates to cache with 'pop get' in order
+to serve them and earn from retrievals.
+
+`),
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+	FlagSet:     flag.NewFlagSet("region", flag.ExitOnError),
+	Subcommands: []*ffcli.Command{regionLs},
+}
+
+func runRegionLs(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	rrc := make(chan *node.RegionResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if rr := n.RegionResult; rr != nil {
+			rrc <- rr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Region(&node.RegionArgs{Region: regionLsArgs.region, Limit: regionLsArgs.limit})
+	select {
+	case rr := <-rrc:
+		if rr.Err != "" {
+			return errors.New(rr.Err)
+		}
+		if len(rr.Entries) == 0 {
+			fmt.Printf("==> No content seen in our regions yet\n")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Ref\tRegion\tQueries\tDispatches\tSize\tLast seen\tCached\n")
+		for _, e := range rr.Entries {
+			size := "-"
+			if e.Size > 0 {
+				size = filecoin.SizeStr(filecoin.NewInt(uint64(e.Size)))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s ago\t%t\n",
+				e.Ref,
+				e.Region,
+				e.Queries,
+				e.Dispatches,
+				size,
+				time.Since(e.LastSeen).Round(time.Second),
+				e.Cached,
+			)
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var schemeCmd = &ffcli.Command{
+	Name:       "scheme",
+	ShortUsage: "scheme",
+	ShortHelp:  "List the replication schemes we joined and the peers who joined ours",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop scheme' command shows our position in the replication graph. We join the scheme of a peer when we
+fetch its index or pull content it dispatched to us and a peer joins ours when it fetches our index or pulls
+content we dispatched.
+
+`),
+	Exec: runScheme,
+}
+
+func runScheme(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.SchemeResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.SchemeResult; sr != nil {
+			src <- sr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Scheme(&node.SchemeArgs{})
+	select {
+	case sr := <-src:
+		if sr.Err != "" {
+			return errors.New(sr.Err)
+		}
+		fmt.Printf("==> Joined the scheme of %d peers\n", len(sr.Joined))
+		if err := printSchemeMembers(sr.Joined); err != nil {
+			return err
+		}
+		fmt.Printf("==> %d peers joined our scheme\n", len(sr.Members))
+		return printSchemeMembers(sr.Members)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func printSchemeMembers(members []node.SchemeMemberResult) error {
+	if len(members) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Peer\tRegions\tJoined\tLast active\tConnected\n")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%t\n",
+			m.Peer,
+			strings.Join(m.Regions, ","),
+			m.Joined.Format(time.RFC3339),
+			time.Since(m.LastActive).Round(time.Second),
+			m.Connected,
+		)
+	}
+	return w.Flush()
+}
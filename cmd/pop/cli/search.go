@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var searchArgs struct {
+	labels string
+}
+
+var searchCmd = &ffcli.Command{
+	Name:       "search",
+	ShortUsage: "search <query>",
+	ShortHelp:  "Search committed content by file name, tag or description",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop search' command looks for commits matching any of the words in the query. File names in the transaction,
+tags and descriptions provided with 'pop commit' are indexed. Best matches are printed first.
+
+`),
+	Exec: runSearch,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("search", flag.ExitOnError)
+		fs.StringVar(&searchArgs.labels, "labels", "", "only return refs with these labels, i.e. env=prod")
+		return fs
+	})(),
+}
+
+func runSearch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	var labels map[string]string
+	if searchArgs.labels != "" {
+		var err error
+		labels, err = parseLabels(strings.Split(searchArgs.labels, ","))
+		if err != nil {
+			return err
+		}
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.SearchResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.SearchResult; sr != nil {
+			src <- sr
+			if sr.Last || sr.Err != "" {
+				close(src)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Search(&node.SearchArgs{
+		Query:  strings.Join(args, " "),
+		Labels: labels,
+	})
+	for res := range src {
+		if res.Err != "" {
+			return errors.New(res.Err)
+		}
+		fmt.Printf("Tx %s %s\n", res.Root, filecoin.SizeStr(filecoin.NewInt(uint64(res.Size))))
+		if len(res.Tags) > 0 {
+			fmt.Printf("  tags: %s\n", strings.Join(res.Tags, ", "))
+		}
+		if res.Description != "" {
+			fmt.Printf("  %s\n", res.Description)
+		}
+		for _, k := range res.Keys {
+			fmt.Printf("  - %s\n", k)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,8 +16,17 @@ import (
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/internal/alert"
 	"github.com/myelnet/pop/internal/utils"
 	"github.com/myelnet/pop/node"
+	"github.com/myelnet/pop/retrieval"
+	"github.com/myelnet/pop/wallet"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/rs/zerolog/log"
@@ -28,13 +38,54 @@ type PopConfig struct {
 	privKeyPath  string
 	regions      string
 	replInterval time.Duration
+	keyPath      string
+	recover      bool
+	recoverCount int
+	publicIndex  string
+	publicRate   int
 	// Exported fields can be set by survey.Ask
-	Bootstrap    string `json:"bootstrap"`
-	Capacity     string `json:"capacity"`
-	MaxPPB       int    `json:"maxppb"`
-	FilEndpoint  string `json:"fil-endpoint"`
-	FilToken     string `json:"fil-token"`
-	FilTokenType string `json:"fil-token-type"`
+	Bootstrap     string `json:"bootstrap"`
+	Capacity      string `json:"capacity"`
+	Eviction      string `json:"eviction"`
+	EvictLabels   string `json:"evict-labels"`
+	Discovery     string `json:"discovery"`
+	Indexer       string `json:"indexer"`
+	Trusted       string `json:"trusted"`
+	FleetKey      string `json:"fleet-key"`
+	LowPower      bool   `json:"low-power"`
+	Verify        bool   `json:"verify"`
+	CacheBudget   string `json:"cache-budget"`
+	MaxGas        string `json:"max-gas"`
+	RepairBudget  string `json:"repair-budget"`
+	Quota         string `json:"quota"`
+	QuotaWindow   string `json:"quota-window"`
+	QuotaRate     string `json:"quota-rate"`
+	DispatchMax   string `json:"dispatch-max-size"`
+	DispatchQuota string `json:"dispatch-quota"`
+	DispatchPPB   int64  `json:"dispatch-min-incentive"`
+	IncentiveMax  string `json:"dispatch-incentive-max-size"`
+	MaxPPB        int    `json:"maxppb"`
+	Ledger        int    `json:"ledger"`
+	SignerURL     string `json:"signer-url"`
+	SignerCert    string `json:"signer-cert"`
+	SignerKey     string `json:"signer-key"`
+	SignerCA      string `json:"signer-ca"`
+	ServeSigner   string `json:"serve-signer"`
+	ServeCert     string `json:"serve-signer-cert"`
+	ServeKey      string `json:"serve-signer-key"`
+	ServeCA       string `json:"serve-signer-ca"`
+	ServeAllow    string `json:"serve-signer-allow"`
+	FilEndpoint   string `json:"fil-endpoint"`
+	FilToken      string `json:"fil-token"`
+	FilTokenType  string `json:"fil-token-type"`
+	FilLight      bool   `json:"fil-light"`
+	FilPeers      string `json:"fil-peers"`
+	FilCheckpoint string `json:"fil-checkpoint"`
+	Encrypt       bool   `json:"encrypt"`
+	AlertSlack    string `json:"alert-slack"`
+	AlertSMTP     string `json:"alert-smtp"`
+	AlertFrom     string `json:"alert-from"`
+	AlertEmail    string `json:"alert-email"`
 }
 
 var startArgs PopConfig
@@ -55,11 +106,52 @@ The 'pop start' command starts a pop daemon service.
 		fs.StringVar(&startArgs.FilEndpoint, "fil-endpoint", "", "endpoint to reach a filecoin api")
 		fs.StringVar(&startArgs.FilToken, "fil-token", "", "token to authorize filecoin api access")
 		fs.StringVar(&startArgs.FilTokenType, "fil-token-type", "Bearer", "auth token type")
+		fs.BoolVar(&startArgs.FilLight, "fil-light", false, "follow the chain over libp2p instead of using a filecoin api endpoint")
+		fs.StringVar(&startArgs.FilPeers, "fil-peers", "", "trusted filecoin nodes the light client receives blocks from separated by commas")
+		fs.StringVar(&startArgs.FilCheckpoint, "fil-checkpoint", "", "block CIDs of a tipset the chain followed by the light client must descend from separated by commas")
 		fs.StringVar(&startArgs.privKeyPath, "privkey", "", "path to private key to use by default")
+		fs.BoolVar(&startArgs.recover, "recover", false, "restore the addresses derived from a recovery phrase read from $POP_MNEMONIC or prompted")
+		fs.IntVar(&startArgs.recoverCount, "recover-count", 1, "number of addresses to restore from the recovery phrase when they cannot be looked up on chain")
 		fs.StringVar(&startArgs.regions, "regions", "", "provider regions separated by commas")
 		fs.StringVar(&startArgs.Capacity, "capacity", "10GB", "storage space allocated for the node")
+		fs.StringVar(&startArgs.Discovery, "discovery", "gossip", "how to find providers for the content we retrieve (gossip, dht, indexer)")
+		fs.StringVar(&startArgs.Indexer, "indexer", "", "url of the network indexer to find providers with when using indexer discovery")
+		fs.StringVar(&startArgs.Trusted, "trusted", "", "peer IDs allowed to pull any of our content for free separated by commas")
+		fs.StringVar(&startArgs.FleetKey, "fleet-key", "", "secret shared by the nodes of an operator so they may pull each other's content for free")
+		fs.BoolVar(&startArgs.LowPower, "low-power", false, "limit concurrent transfers and background work for constrained devices such as a Raspberry Pi")
+		fs.BoolVar(&startArgs.Verify, "verify", false, "verify the content we receive is complete before serving it")
+		fs.StringVar(&startArgs.CacheBudget, "cache-budget", "", "storage space used to cache popular content we relay queries for i.e. 500MB, disabled by default")
+		fs.StringVar(&startArgs.MaxGas, "max-gas", "", "max fee to pay for a payment channel message i.e. 0.001FIL, more expensive operations are retried later")
+		fs.StringVar(&startArgs.RepairBudget, "repair-budget", "", "max to spend on replacement deals when storage deals are lost i.e. 1FIL, disabled by default")
+		fs.StringVar(&startArgs.Quota, "quota", "", "max amount of data a single client can retrieve from us per window i.e. 10GB, no limit by default")
+		fs.StringVar(&startArgs.QuotaWindow, "quota-window", "24h", "period after which client quotas are reset")
+		fs.StringVar(&startArgs.QuotaRate, "quota-rate", "", "max rate at which a single client can retrieve from us per second i.e. 1MB, no limit by default")
+		fs.StringVar(&startArgs.DispatchMax, "dispatch-max-size", "", "largest content other peers can dispatch to us i.e. 1GB, no limit by default")
+		fs.StringVar(&startArgs.DispatchQuota, "dispatch-quota", "", "max amount of content a single peer can dispatch to us per day i.e. 5GB, no limit by default")
+		fs.Int64Var(&startArgs.DispatchPPB, "dispatch-min-incentive", 0, "min incentive price per byte in attoFIL lifting the dispatch max size, disabled if 0")
+		fs.StringVar(&startArgs.IncentiveMax, "dispatch-incentive-max-size", "", "largest content we accept when dispatched with an incentive of at least dispatch-min-incentive, no limit by default")
+		fs.StringVar(&startArgs.Eviction, "eviction", "lfu", "which content to evict first once capacity is reached (lfu, lru, largest, ttl)")
+		fs.StringVar(&startArgs.EvictLabels, "evict-labels", "", "only evict refs with all the given labels separated by commas i.e. tier=best-effort")
 		fs.DurationVar(&startArgs.replInterval, "replinterval", 0, "at which interval to check for new content from peers. 0 means the feature is deactivated")
 		fs.IntVar(&startArgs.MaxPPB, "maxppb", 5, "max price per byte")
+		fs.StringVar(&startArgs.SignerURL, "signer-url", "", "url of a remote signing service holding keys shared by a fleet of nodes")
+		fs.StringVar(&startArgs.SignerCert, "signer-cert", "", "client certificate to authenticate with the remote signing service")
+		fs.StringVar(&startArgs.SignerKey, "signer-key", "", "private key of the client certificate for the remote signing service")
+		fs.StringVar(&startArgs.SignerCA, "signer-ca", "", "CA certificate of the remote signing service if not trusted by the system")
+		fs.StringVar(&startArgs.ServeSigner, "serve-signer", "", "address to serve the keys of our wallet to a fleet of nodes as a remote signing service on, i.e. :8443 (disabled if empty)")
+		fs.StringVar(&startArgs.ServeCert, "serve-signer-cert", "", "server certificate of the signing service")
+		fs.StringVar(&startArgs.ServeKey, "serve-signer-key", "", "private key of the signing service certificate")
+		fs.StringVar(&startArgs.ServeCA, "serve-signer-ca", "", "CA certificate the client certificates of the fleet nodes must be signed with")
+		fs.StringVar(&startArgs.ServeAllow, "serve-signer-allow", "", "addresses the signing service may sign with separated by commas, only the default address if empty")
+		fs.IntVar(&startArgs.Ledger, "ledger", 0, "number of addresses to use from a connected Ledger device to sign chain messages, disabled if 0")
+		fs.BoolVar(&startArgs.Encrypt, "encrypt", false, "encrypt blocks at rest with a passphrase read from $POP_PASSPHRASE or prompted")
+		fs.StringVar(&startArgs.publicIndex, "public-index", "", "address to serve a public list of the refs we provide on, i.e. :8080 (disabled if empty)")
+		fs.IntVar(&startArgs.publicRate, "public-index-rate", 60, "max requests per minute per client on the public index")
+		fs.StringVar(&startArgs.keyPath, "encrypt-keyfile", "", "path to a 32 bytes key to encrypt blocks at rest with instead of a passphrase")
+		fs.StringVar(&startArgs.AlertSlack, "alert-slack", "", "slack webhook url to send operator alerts to")
+		fs.StringVar(&startArgs.AlertSMTP, "alert-smtp", "", "host:port of an smtp server to email operator alerts with, credentials are read from $POP_SMTP_USERNAME and $POP_SMTP_PASSWORD")
+		fs.StringVar(&startArgs.AlertFrom, "alert-from", "", "sender address of alert emails")
+		fs.StringVar(&startArgs.AlertEmail, "alert-email", "", "addresses to email alerts to separated by commas")
 
 		return fs
 	})(),
@@ -118,10 +210,28 @@ Manage your Myel point of presence from the command line.
 		defer os.RemoveAll(path)
 	}
 
-	privKey := setupWallet(init)
+	privKey, mnemonic, seed, err := setupWallet(init)
+	if err != nil {
+		return err
+	}
 
 	regions := setupRegions()
 
+	cipher, err := setupCipher(path)
+	if err != nil {
+		return err
+	}
+
+	signer, err := setupSigner(ctx)
+	if err != nil {
+		return err
+	}
+
+	serveTLS, serveAllowed, err := setupSignerServer()
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	interrupt := make(chan os.Signal, 1)
@@ -139,6 +249,21 @@ Manage your Myel point of presence from the command line.
 
 	filToken := utils.FormatToken(startArgs.FilToken, startArgs.FilTokenType)
 
+	var filPeers []string
+	if startArgs.FilPeers != "" {
+		filPeers = strings.Split(strings.ReplaceAll(startArgs.FilPeers, " ", ""), ",")
+	}
+	var filCheckpoint []cid.Cid
+	if startArgs.FilCheckpoint != "" {
+		for _, s := range strings.Split(strings.ReplaceAll(startArgs.FilCheckpoint, " ", ""), ",") {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return fmt.Errorf("invalid checkpoint: %w", err)
+			}
+			filCheckpoint = append(filCheckpoint, c)
+		}
+	}
+
 	var bAddrs []string
 	if startArgs.Bootstrap != "" {
 		startArgs.Bootstrap = strings.ReplaceAll(startArgs.Bootstrap, " ", "")
@@ -170,17 +295,137 @@ Manage your Myel point of presence from the command line.
 		fmt.Println("failed to parse capacity")
 	}
 
+	var cacheBudget uint64
+	if startArgs.CacheBudget != "" {
+		if size, err := units.FromHumanSize(startArgs.CacheBudget); err == nil {
+			cacheBudget = uint64(size)
+		} else {
+			fmt.Println("failed to parse cache budget")
+		}
+	}
+
+	var maxGas abi.TokenAmount
+	if startArgs.MaxGas != "" {
+		if amt, err := filecoin.ParseFIL(startArgs.MaxGas); err == nil {
+			maxGas = abi.TokenAmount(amt)
+		} else {
+			fmt.Println("failed to parse max gas")
+		}
+	}
+
+	var repairBudget abi.TokenAmount
+	if startArgs.RepairBudget != "" {
+		if amt, err := filecoin.ParseFIL(startArgs.RepairBudget); err == nil {
+			repairBudget = abi.TokenAmount(amt)
+		} else {
+			fmt.Println("failed to parse repair budget")
+		}
+	}
+
+	var quota retrieval.Quota
+	if startArgs.Quota != "" {
+		if size, err := units.FromHumanSize(startArgs.Quota); err == nil {
+			quota.MaxBytes = uint64(size)
+		} else {
+			fmt.Println("failed to parse quota")
+		}
+	}
+	if startArgs.QuotaWindow != "" {
+		if window, err := time.ParseDuration(startArgs.QuotaWindow); err == nil {
+			quota.Window = window
+		} else {
+			fmt.Println("failed to parse quota window")
+		}
+	}
+	if startArgs.QuotaRate != "" {
+		if size, err := units.FromHumanSize(startArgs.QuotaRate); err == nil {
+			quota.MaxRate = uint64(size)
+		} else {
+			fmt.Println("failed to parse quota rate")
+		}
+	}
+
+	var dispatch exchange.DispatchPolicy
+	if startArgs.DispatchMax != "" {
+		if size, err := units.FromHumanSize(startArgs.DispatchMax); err == nil {
+			dispatch.MaxSize = uint64(size)
+		} else {
+			fmt.Println("failed to parse dispatch max size")
+		}
+	}
+	if startArgs.DispatchQuota != "" {
+		if size, err := units.FromHumanSize(startArgs.DispatchQuota); err == nil {
+			dispatch.PeerQuota = uint64(size)
+		} else {
+			fmt.Println("failed to parse dispatch quota")
+		}
+	}
+	if startArgs.DispatchPPB > 0 {
+		dispatch.MinIncentive = big.NewInt(startArgs.DispatchPPB)
+	}
+	if startArgs.IncentiveMax != "" {
+		if size, err := units.FromHumanSize(startArgs.IncentiveMax); err == nil {
+			dispatch.IncentiveMaxSize = uint64(size)
+		} else {
+			fmt.Println("failed to parse dispatch incentive max size")
+		}
+	}
+
+	var evictLabels map[string]string
+	if startArgs.EvictLabels != "" {
+		evictLabels, err = parseLabels(strings.Split(startArgs.EvictLabels, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	var trusted []string
+	if startArgs.Trusted != "" {
+		trusted = strings.Split(startArgs.Trusted, ",")
+	}
+
 	opts := node.Options{
 		RepoPath:       path,
 		BootstrapPeers: bAddrs,
 		FilEndpoint:    startArgs.FilEndpoint,
 		FilToken:       filToken,
+		FilLight:       startArgs.FilLight,
+		FilPeers:       filPeers,
+		FilCheckpoint:  filCheckpoint,
 		PrivKey:        privKey,
+		Mnemonic:       mnemonic,
+		RecoverCount:   startArgs.recoverCount,
+		Seed:           seed,
 		MaxPPB:         int64(startArgs.MaxPPB),
 		Regions:        regions,
 		Capacity:       capacity,
+		EvictionPolicy: startArgs.Eviction,
+		EvictLabels:    evictLabels,
+		Discovery:      startArgs.Discovery,
+		IndexerURL:     startArgs.Indexer,
+		TrustedPeers:   trusted,
+		FleetKey:       startArgs.FleetKey,
+		LowPower:       startArgs.LowPower,
 		ReplInterval:   startArgs.replInterval,
 		CancelFunc:     cancel,
+		Cipher:         cipher,
+
+		VerifyTransfers: startArgs.Verify,
+		CacheBudget:     cacheBudget,
+		MaxGas:          maxGas,
+		RepairBudget:    repairBudget,
+		Quota:           quota,
+		DispatchPolicy:  dispatch,
+		LedgerAccounts:  startArgs.Ledger,
+		RemoteSigner:    signer,
+		SignerAddr:      startArgs.ServeSigner,
+		SignerTLS:       serveTLS,
+		SignerAllowed:   serveAllowed,
+
+		PublicIndexAddr:      startArgs.publicIndex,
+		PublicIndexRateLimit: startArgs.publicRate,
+
+		Alerts: setupAlerts(),
 	}
 
 	err = node.Run(ctx, opts)
@@ -315,16 +560,110 @@ func setupRepo() (string, bool, error) {
 	return path, true, nil
 }
 
-// setupWallet prompts user to import a key or generate a new one
-func setupWallet(init bool) string {
+// setupAlerts configures where the node sends alerts
+func setupAlerts() alert.Config {
+	cfg := alert.Config{
+		SlackWebhook: startArgs.AlertSlack,
+		MinLevel:     alert.Warning,
+	}
+	if startArgs.AlertSMTP != "" && startArgs.AlertEmail != "" {
+		cfg.SMTP = &alert.SMTPConfig{
+			Addr:     startArgs.AlertSMTP,
+			From:     startArgs.AlertFrom,
+			To:       strings.Split(startArgs.AlertEmail, ","),
+			Username: os.Getenv("POP_SMTP_USERNAME"),
+			Password: os.Getenv("POP_SMTP_PASSWORD"),
+		}
+	}
+	return cfg
+}
+
+// setupSigner connects with the remote signing service if one is configured
+func setupSigner(ctx context.Context) (*wallet.RemoteSigner, error) {
+	if startArgs.SignerURL == "" {
+		return nil, nil
+	}
+	conf, err := wallet.LoadSignerTLS(startArgs.SignerCert, startArgs.SignerKey, startArgs.SignerCA)
+	if err != nil {
+		return nil, err
+	}
+	signer := wallet.NewRemoteSigner(startArgs.SignerURL, conf)
+	addrs, err := signer.Addresses(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect with remote signer: %w", err)
+	}
+	for _, a := range addrs {
+		fmt.Printf("==> Loaded remote FIL address: %s\n", a)
+	}
+	return signer, nil
+}
+
+// setupSignerServer loads the TLS config and addresses of the signing service we serve if one is configured
+func setupSignerServer() (*tls.Config, []address.Address, error) {
+	if startArgs.ServeSigner == "" {
+		return nil, nil, nil
+	}
+	conf, err := wallet.LoadSignerServerTLS(startArgs.ServeCert, startArgs.ServeKey, startArgs.ServeCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	var allowed []address.Address
+	if startArgs.ServeAllow != "" {
+		for _, s := range strings.Split(startArgs.ServeAllow, ",") {
+			addr, err := address.NewFromString(strings.TrimSpace(s))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid signer address %s: %w", s, err)
+			}
+			allowed = append(allowed, addr)
+		}
+	}
+	return conf, allowed, nil
+}
+
+// setupCipher loads the key used for encrypting blocks at rest if needed
+func setupCipher(path string) (utils.Cipher, error) {
+	if startArgs.keyPath != "" {
+		key, err := os.ReadFile(startArgs.keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return utils.NewAESCipher(key)
+	}
+	if !startArgs.Encrypt {
+		return nil, nil
+	}
+	pass, ok := os.LookupEnv("POP_PASSPHRASE")
+	if !ok {
+		prompt := &survey.Password{
+			Message: "Passphrase to encrypt blocks",
+		}
+		if err := survey.AskOne(prompt, &pass); err != nil {
+			return nil, err
+		}
+	}
+	salt, err := utils.RepoSalt(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := utils.KeyFromPassphrase(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewAESCipher(key)
+}
+
+// setupWallet prompts user to import a key, restore addresses from a recovery phrase or generate a new one.
+// It returns the key to import, the phrase to restore from and the new phrase to derive keys from.
+func setupWallet(init bool) (string, string, string, error) {
 	// If we're not initializing the repo we don't prompt for key
-	if startArgs.privKeyPath == "" && init {
+	if startArgs.privKeyPath == "" && !startArgs.recover && init {
 		var a int
 		prompt := &survey.Select{
 			Message: "Setup wallet",
 			Options: []string{
 				"Generate a default address",
 				"Import a new address",
+				"Restore addresses from a recovery phrase",
 			},
 		}
 		survey.AskOne(prompt, &a)
@@ -334,6 +673,37 @@ func setupWallet(init bool) string {
 			}
 			survey.AskOne(prompt, &startArgs.privKeyPath)
 		}
+		startArgs.recover = a == 2
+	}
+
+	var mnemonic string
+	if startArgs.recover {
+		var ok bool
+		mnemonic, ok = os.LookupEnv("POP_MNEMONIC")
+		if !ok {
+			prompt := &survey.Password{
+				Message: "Recovery phrase",
+			}
+			if err := survey.AskOne(prompt, &mnemonic); err != nil {
+				return "", "", "", err
+			}
+		}
+	}
+
+	// New repos derive their keys from a recovery phrase so they can be restored after a disk loss.
+	// The phrase is only persisted encrypted so new keys can still be derived from it after a restart.
+	var seed string
+	if init && startArgs.privKeyPath == "" && !startArgs.recover {
+		if startArgs.Encrypt || startArgs.keyPath != "" {
+			var err error
+			seed, err = wallet.NewMnemonic()
+			if err != nil {
+				return "", "", "", err
+			}
+			fmt.Printf("==> Write down this recovery phrase, it is the only way to restore your addresses:\n\n%s\n\n", seed)
+		} else {
+			fmt.Printf("==> Start with -encrypt to derive your addresses from a recovery phrase\n")
+		}
 	}
 
 	var privKey string
@@ -346,7 +716,7 @@ func setupWallet(init bool) string {
 		}
 	}
 
-	return privKey
+	return privKey, mnemonic, seed, nil
 }
 
 // setupRegions formats the regions to join from cli flag or user prompt
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var statsArgs struct {
+	since string
+}
+
+var statsCmd = &ffcli.Command{
+	Name:       "stats",
+	ShortUsage: "stats [-since 30d]",
+	ShortHelp:  "Print daily metrics recorded by the pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop stats' command prints the metrics recorded each day by the daemon: bytes served to clients,
+earnings from retrievals, the rate of queries for content we had and the highest number of connected peers
+in each region. Metrics are kept for a year, use the since flag to select the period i.e. 'pop stats -since 7d'.
+
+`),
+	Exec: runStats,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("stats", flag.ExitOnError)
+		fs.StringVar(&statsArgs.since, "since", "30d", "how far back to print metrics for, in days (30d) or hours (12h)")
+		return fs
+	})(),
+}
+
+func runStats(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.StatsResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.StatsResult; sr != nil {
+			src <- sr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Stats(&node.StatsArgs{Since: statsArgs.since})
+	select {
+	case sr := <-src:
+		if sr.Err != "" {
+			return errors.New(sr.Err)
+		}
+		if len(sr.Days) == 0 {
+			fmt.Printf("==> No metrics recorded since %s\n", statsArgs.since)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Day\tServed\tEarnings\tQueries\tHit rate\tPeers\n")
+		for _, d := range sr.Days {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f%%\t%s\n",
+				d.Day,
+				filecoin.SizeStr(filecoin.NewInt(d.BytesServed)),
+				d.Earnings,
+				d.Queries,
+				d.HitRate*100,
+				formatPeers(d.Peers),
+			)
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatPeers prints the number of peers in each region sorted by region name
+func formatPeers(peers map[string]int) string {
+	regions := make([]string, 0, len(peers))
+	for r := range peers {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	strs := make([]string, len(regions))
+	for i, r := range regions {
+		strs[i] = fmt.Sprintf("%s=%d", r, peers[r])
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var usageArgs struct {
+	by    string
+	reset string
+}
+
+var usageCmd = &ffcli.Command{
+	Name:       "usage",
+	ShortUsage: "usage [-by peer|wallet] [-reset <peer-id>]",
+	ShortHelp:  "Print the bandwidth each client used retrieving from us",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop usage' command prints how much data each client retrieved from the daemon, aggregated per peer or
+per paying wallet with the 'by' flag i.e. 'pop usage -by wallet'. Operators can enforce fair-use limits with
+the quota flags of 'pop start' and clear the records of a client once invoiced with 'pop usage -reset <peer-id>'.
+
+`),
+	Exec: runUsage,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("usage", flag.ExitOnError)
+		fs.StringVar(&usageArgs.by, "by", node.AccountByPeer, "aggregate usage by peer or wallet")
+		fs.StringVar(&usageArgs.reset, "reset", "", "clear the usage of the given peer once listed")
+		return fs
+	})(),
+}
+
+func runUsage(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	urc := make(chan *node.UsageResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ur := n.UsageResult; ur != nil {
+			urc <- ur
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Usage(&node.UsageArgs{By: usageArgs.by, Reset: usageArgs.reset})
+	select {
+	case ur := <-urc:
+		if ur.Err != "" && len(ur.Clients) == 0 {
+			return errors.New(ur.Err)
+		}
+		if len(ur.Clients) == 0 {
+			fmt.Printf("==> No content retrieved from us yet\n")
+			return nil
+		}
+		key := "Peer"
+		if usageArgs.by == node.UsageByWallet {
+			key = "Wallet"
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tTotal\tWindow\tDeals\tLast Seen\n", key)
+		for _, u := range ur.Clients {
+			k := u.Peer
+			if usageArgs.by == node.UsageByWallet {
+				k = u.Wallet
+			}
+			if k == "" {
+				k = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", k, units.BytesSize(float64(u.TotalSent)), units.BytesSize(float64(u.WindowSent)), u.Deals, u.LastSeen.Format("2006-01-02 15:04"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if ur.Err != "" {
+			return errors.New(ur.Err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
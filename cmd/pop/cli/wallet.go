@@ -2,10 +2,13 @@ package cli
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -25,11 +28,88 @@ var export = &ffcli.Command{
 	Exec:       runExport,
 }
 
+var payArgs struct {
+	from string
+}
+
+// payFlags are shared by pay and its send alias
+func payFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&payArgs.from, "from", "", "address to send from instead of the default address")
+	return fs
+}
+
 var pay = &ffcli.Command{
 	Name:       "pay",
-	ShortUsage: "wallet pay <from> <to> <amount>",
+	ShortUsage: "wallet pay [-from <address>] <to> <amount>",
 	ShortHelp:  "Make a transaction in FIL",
+	LongHelp: strings.TrimSpace(`
+The 'pop wallet pay' command sends FIL from the default address unless -from is set.
+The address to send from can also be passed first as in 'pop wallet pay <from> <to> <amount>'.
+`),
+	Exec:    runPay,
+	FlagSet: payFlags("pay"),
+}
+
+var send = &ffcli.Command{
+	Name:       "send",
+	ShortUsage: "wallet send [-from <address>] <to> <amount>",
+	ShortHelp:  "Alias of pay",
 	Exec:       runPay,
+	FlagSet:    payFlags("send"),
+}
+
+var signArgs struct {
+	from string
+}
+
+var sign = &ffcli.Command{
+	Name:       "sign",
+	ShortUsage: "wallet sign <data>",
+	ShortHelp:  "Sign a message with the default address and print the hex encoded signature",
+	Exec:       runSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("sign", flag.ExitOnError)
+		fs.StringVar(&signArgs.from, "from", "", "address to sign with instead of the default address")
+		return fs
+	})(),
+}
+
+var verify = &ffcli.Command{
+	Name:       "verify",
+	ShortUsage: "wallet verify <address> <data> <signature>",
+	ShortHelp:  "Verify a message was signed by the given address",
+	Exec:       runVerify,
+}
+
+var historyArgs struct {
+	limit int
+}
+
+var history = &ffcli.Command{
+	Name:       "history",
+	ShortUsage: "wallet history [address]",
+	ShortHelp:  "List the messages the node pushed on chain with their status",
+	Exec:       runHistory,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("history", flag.ExitOnError)
+		fs.IntVar(&historyArgs.limit, "limit", 20, "maximum number of messages to list, 0 lists all of them")
+		return fs
+	})(),
+}
+
+var watch = &ffcli.Command{
+	Name:       "watch",
+	ShortUsage: "wallet watch",
+	ShortHelp:  "Print funds arriving or leaving the node addresses until interrupted",
+	Exec:       runWatch,
+}
+
+var balance = &ffcli.Command{
+	Name:       "balance",
+	ShortUsage: "wallet balance [address]",
+	ShortHelp:  "Show the balance of an address and the funds it holds in payment channels",
+	Exec:       runBalance,
 }
 
 var walletCmd = &ffcli.Command{
@@ -38,14 +118,14 @@ var walletCmd = &ffcli.Command{
 	LongHelp: strings.TrimSpace(`
 
 The 'pop wallet' command is a multipurpose wallet command used for managing your private key & FIL address.
-You can list or export your addresses, as well as paying to a FIL address.
+You can list or export your addresses, check their balance, as well as paying to a FIL address or from a multisig wallet.
 
 `),
 	Exec: func(context.Context, []string) error {
 		return flag.ErrHelp
 	},
 	FlagSet:     flag.NewFlagSet("wallet", flag.ExitOnError),
-	Subcommands: []*ffcli.Command{listKeys, export, pay},
+	Subcommands: []*ffcli.Command{listKeys, balance, history, watch, sign, verify, export, pay, send, msigCmd},
 }
 
 func runListKeys(ctx context.Context, args []string) error {
@@ -117,21 +197,22 @@ func runExport(ctx context.Context, args []string) error {
 }
 
 func runPay(ctx context.Context, args []string) error {
-	if len(args) < 3 {
-		return errors.New("incorrect number of args, see usage")
+	from := payArgs.from
+	if len(args) == 3 {
+		from, args = args[0], args[1:]
 	}
-
-	from := args[0]
-	to := args[1]
-	amount := args[2]
+	if len(args) != 2 {
+		return flag.ErrHelp
+	}
+	to, amount := args[0], args[1]
 
 	c, cc, ctx, cancel := connect(ctx)
 	defer cancel()
 
-	keyResults := make(chan *node.WalletResult, 1)
+	results := make(chan *node.WalletResult, 1)
 	cc.SetNotifyCallback(func(n node.Notify) {
-		if sr := n.WalletResult; sr != nil {
-			keyResults <- sr
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
 		}
 	})
 	go receive(ctx, cc, c)
@@ -143,12 +224,195 @@ func runPay(ctx context.Context, args []string) error {
 	})
 
 	select {
-	case kr := <-keyResults:
-		if kr.Err != "" {
-			return errors.New(kr.Err)
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+		// the node reports the address it paid from if we didn't set one
+		if len(wr.Addresses) > 0 {
+			from = wr.Addresses[0]
+		}
+
+		fmt.Printf("==> Sent %s from %s to %s\n", amount, from, to)
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runBalance(ctx context.Context, args []string) error {
+	bargs := &node.WalletBalanceArgs{}
+	if len(args) > 0 {
+		bargs.Address = args[0]
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	results := make(chan *node.WalletResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.WalletBalance(bargs)
+
+	select {
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+
+		fmt.Printf("==> %s\n", wr.Addresses[0])
+		fmt.Printf("Balance: %s\n", wr.Balance)
+		fmt.Printf("Locked in payment channels: %s\n", wr.Locked)
+		fmt.Printf("Pending in payment channels: %s\n", wr.Pending)
+		if wr.DataCap != "" {
+			fmt.Printf("Datacap for verified deals: %s\n", wr.DataCap)
+		}
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runHistory(ctx context.Context, args []string) error {
+	hargs := &node.WalletHistoryArgs{Limit: historyArgs.limit}
+	if len(args) > 0 {
+		hargs.Address = args[0]
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	results := make(chan *node.WalletResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.WalletHistory(hargs)
+
+	select {
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Time\tKind\tStatus\tFrom\tTo\tAmount\tGas Used\tFee\tHeight\tCid\n")
+		for _, tx := range wr.History {
+			status := tx.Status
+			if tx.ReplacedBy != "" {
+				status += " by " + tx.ReplacedBy
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\n",
+				tx.Time.Format("2006-01-02 15:04:05"), tx.Kind, status, tx.From, tx.To, tx.Amount, tx.GasUsed, tx.Fee, tx.Height, tx.Cid)
+		}
+		return w.Flush()
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if fe := n.FundsEvent; fe != nil {
+			sign := "+"
+			if fe.Direction == "outgoing" {
+				sign = "-"
+			}
+			fmt.Printf("==> [%d] %s %s%s (balance %s)\n", fe.Height, fe.Address, sign, fe.Amount, fe.Balance)
+		}
+	})
+	go receive(ctx, cc, c)
+
+	fmt.Printf("==> Watching funds of the node addresses\n")
+	<-ctx.Done()
+	return nil
+}
+
+func runSign(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	results := make(chan *node.WalletResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.WalletSign(&node.WalletSignArgs{
+		Address: signArgs.from,
+		Data:    []byte(args[0]),
+	})
+
+	select {
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+
+		fmt.Println(hex.EncodeToString(wr.Signature))
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runVerify(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return flag.ErrHelp
+	}
+	sig, err := hex.DecodeString(args[2])
+	if err != nil {
+		return fmt.Errorf("signature must be hex encoded: %w", err)
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	results := make(chan *node.WalletResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WalletResult; wr != nil {
+			results <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.WalletVerify(&node.WalletVerifyArgs{
+		Address:   args[0],
+		Data:      []byte(args[1]),
+		Signature: sig,
+	})
+
+	select {
+	case wr := <-results:
+		if wr.Err != "" {
+			return errors.New(wr.Err)
+		}
+		if !wr.Valid {
+			return errors.New("invalid signature")
 		}
 
-		fmt.Printf("Successfully paid %s from %s to %s\n", amount, from, to)
+		fmt.Printf("==> Valid signature from %s\n", args[0])
 		return nil
 
 	case <-ctx.Done():
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/stretchr/testify/require"
+)
+
+// serve makes the next command read the given notifications instead of connecting to the daemon
+func serve(t *testing.T, notifs ...node.Notify) {
+	var msgs []opMsg
+	for _, n := range notifs {
+		b, err := json.Marshal(n)
+		require.NoError(t, err)
+		msgs = append(msgs, opMsg{Notify: b})
+	}
+	replaying = true
+	replayMsgs = msgs
+	t.Cleanup(func() {
+		replaying = false
+		replayMsgs = nil
+	})
+}
+
+func TestWalletPay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	require.Equal(t, flag.ErrHelp, runPay(ctx, []string{"f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq"}))
+
+	serve(t, node.Notify{WalletResult: &node.WalletResult{
+		Addresses: []string{"f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq"},
+	}})
+	require.NoError(t, runPay(ctx, []string{"f01001", "0.1"}))
+
+	// the address to pay from can still be passed first
+	serve(t, node.Notify{WalletResult: &node.WalletResult{
+		Addresses: []string{"f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq"},
+	}})
+	require.NoError(t, runPay(ctx, []string{"f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq", "f01001", "0.1"}))
+
+	serve(t, node.Notify{WalletResult: &node.WalletResult{
+		Err: "insufficient funds",
+	}})
+	require.EqualError(t, runPay(ctx, []string{"f01001", "0.1"}), "insufficient funds")
+}
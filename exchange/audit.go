@@ -0,0 +1,227 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/internal/utils"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate cbor-gen-for AuditRequest Receipt
+
+// AuditProtocolID is the protocol for challenging a peer to prove it holds some content
+const AuditProtocolID = protocol.ID("/myel/pop/audit/1.0")
+
+// ErrAuditFailed is returned when a peer could not prove it holds the content
+var ErrAuditFailed = errors.New("audit failed")
+
+// AuditRequest challenges a peer to prove it holds the content for a root
+type AuditRequest struct {
+	PayloadCID cid.Cid
+	// Challenge is a block of the DAG picked at random
+	Challenge cid.Cid
+	// Nonce prevents a peer from replaying an earlier proof without keeping the block
+	Nonce []byte
+}
+
+// Receipt is signed by a peer to acknowledge it stores some content
+type Receipt struct {
+	PayloadCID cid.Cid
+	Size       uint64
+	// Proof is the hash of the challenge nonce followed by the challenged block
+	Proof []byte
+	// Signature of all the other fields by the peer holding the content
+	Signature []byte
+}
+
+// payload returns the bytes signed by the peer
+func (rc Receipt) payload() ([]byte, error) {
+	rc.Signature = nil
+	buf := new(bytes.Buffer)
+	if err := rc.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks the receipt was signed by the given peer
+func (rc Receipt) Verify(h host.Host, p peer.ID) error {
+	pub, err := p.ExtractPublicKey()
+	if err != nil || pub == nil {
+		pub = h.Peerstore().PubKey(p)
+	}
+	if pub == nil {
+		return fmt.Errorf("no public key for %s", p)
+	}
+	msg, err := rc.payload()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(msg, rc.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("bad signature from %s", p)
+	}
+	return nil
+}
+
+// auditProof hashes the nonce with the challenged block so the proof cannot be computed without the block
+func auditProof(nonce []byte, data []byte) []byte {
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// handleAudit answers a challenge with a signed receipt if we hold the content. The stream is closed
+// without a receipt otherwise.
+func (r *Replication) handleAudit(s network.Stream) {
+	defer r.sup.Recover("audit-handler")
+	defer s.Close()
+	p := s.Conn().RemotePeer()
+
+	var req AuditRequest
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxAuditSize, &req); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("invalid audit request")
+		r.strikes.Record(p, err)
+		return
+	}
+	// The dispatching peer may audit us as soon as the transfer completes so we wait until
+	// the content is migrated to our blockstore
+	if done := r.pulls.done(req.PayloadCID); done != nil {
+		select {
+		case <-done:
+		case <-time.After(AuditTimeout):
+			return
+		}
+	}
+	// Audits don't count as reads of the content
+	ref, err := r.idx.PeekRef(req.PayloadCID)
+	if err != nil {
+		return
+	}
+	blk, err := r.bs.Get(req.Challenge)
+	if err != nil {
+		log.Error().Err(err).Str("root", req.PayloadCID.String()).Msg("challenged block not found")
+		return
+	}
+	rc := Receipt{
+		PayloadCID: req.PayloadCID,
+		Size:       uint64(ref.PayloadSize),
+		Proof:      auditProof(req.Nonce, blk.RawData()),
+	}
+	key := r.h.Peerstore().PrivKey(r.h.ID())
+	if key == nil {
+		log.Error().Msg("no private key to sign receipt")
+		return
+	}
+	msg, err := rc.payload()
+	if err != nil {
+		return
+	}
+	rc.Signature, err = key.Sign(msg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to sign receipt")
+		return
+	}
+	if err := cborutil.WriteCborRPC(s, &rc); err != nil {
+		log.Debug().Err(err).Msg("failed to write receipt")
+	}
+}
+
+// Audit challenges a peer to prove it holds the content for the given root and returns its signed receipt.
+// The challenge is a random block of the DAG so we must have the content in our blockstore.
+func (r *Replication) Audit(ctx context.Context, p peer.ID, root cid.Cid) (Receipt, error) {
+	var keys []cid.Cid
+	err := utils.WalkDAG(ctx, root, r.bs, sel.All(), func(blk blocks.Block) error {
+		keys = append(keys, blk.Cid())
+		return nil
+	})
+	if err != nil {
+		return Receipt{}, err
+	}
+	if len(keys) == 0 {
+		return Receipt{}, fmt.Errorf("no blocks to challenge for %s", root)
+	}
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(keys))))
+	if err != nil {
+		return Receipt{}, err
+	}
+	blk, err := r.bs.Get(keys[i.Int64()])
+	if err != nil {
+		return Receipt{}, err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return Receipt{}, err
+	}
+
+	s, err := OpenStream(ctx, r.h, p, []protocol.ID{AuditProtocolID})
+	if err != nil {
+		return Receipt{}, err
+	}
+	defer s.Close()
+
+	req := AuditRequest{
+		PayloadCID: root,
+		Challenge:  blk.Cid(),
+		Nonce:      nonce,
+	}
+	if err := cborutil.WriteCborRPC(s, &req); err != nil {
+		return Receipt{}, err
+	}
+	var rc Receipt
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxAuditSize, &rc); err != nil {
+		return Receipt{}, fmt.Errorf("%w: %s sent no receipt: %v", ErrAuditFailed, p, err)
+	}
+	if rc.PayloadCID != root || !bytes.Equal(rc.Proof, auditProof(nonce, blk.RawData())) {
+		return Receipt{}, fmt.Errorf("%w: invalid proof from %s", ErrAuditFailed, p)
+	}
+	if err := rc.Verify(r.h, p); err != nil {
+		return Receipt{}, fmt.Errorf("%w: %v", ErrAuditFailed, err)
+	}
+	return rc, nil
+}
+
+// Move dispatches the content to the given peer and returns its signed receipt once it passed an audit.
+// The content must be committed to our blockstore and we can remove our copy after without a window
+// during which the content is not available.
+func (r *Replication) Move(ctx context.Context, root cid.Cid, to peer.ID) (Receipt, error) {
+	ref, err := r.idx.GetRef(root)
+	if err != nil {
+		return Receipt{}, err
+	}
+	opts := DefaultDispatchOptions
+	opts.RF = 1
+	opts.Peers = []peer.ID{to}
+	opts.FromBlockstore = true
+	res, err := r.Dispatch(root, uint64(ref.PayloadSize), opts)
+	if err != nil {
+		return Receipt{}, err
+	}
+	select {
+	case rec, ok := <-res.Out():
+		if !ok || rec.Provider != to {
+			return Receipt{}, fmt.Errorf("%s did not pull the content", to)
+		}
+	case <-ctx.Done():
+		res.Cancel()
+		return Receipt{}, ctx.Err()
+	}
+	return r.Audit(ctx, to, root)
+}
@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveAndAudit(t *testing.T) {
+	bgCtx := context.Background()
+	ctx, cancel := context.WithTimeout(bgCtx, 20*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(bgCtx)
+	regions := []Region{
+		{
+			Name: "TestRegion",
+			Code: CustomRegion,
+		},
+	}
+
+	var tnds []*testutil.TestNode
+	var repls []*Replication
+	for i := 0; i < 3; i++ {
+		tnode := testutil.NewTestNode(mn, t)
+		tnode.SetupDataTransfer(bgCtx, t)
+		t.Cleanup(func() {
+			require.NoError(t, tnode.Dt.Stop(bgCtx))
+		})
+		idx, err := NewIndex(tnode.Ds, tnode.Bs)
+		require.NoError(t, err)
+		opts := Options{Regions: regions, MultiStore: tnode.Ms, Blockstore: tnode.Bs}
+		r, err := NewReplication(tnode.Host, idx, tnode.Dt, NewMockRetriever(tnode.Dt, idx), opts)
+		require.NoError(t, err)
+		tnds = append(tnds, tnode)
+		repls = append(repls, r)
+	}
+	src, dst, other := repls[0], repls[1], repls[2]
+
+	// The content to move is committed to the main blockstore of the source
+	fname := tnds[0].CreateRandomFile(t, 256000)
+	link, storeID, origBytes := tnds[0].LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	store, err := tnds[0].Ms.Get(storeID)
+	require.NoError(t, err)
+	require.NoError(t, utils.MigrateBlocks(ctx, store.Bstore, tnds[0].Bs))
+	require.NoError(t, src.idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: int64(len(origBytes)),
+	}))
+
+	sub, err := dst.h.EventBus().Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+	for _, r := range repls {
+		require.NoError(t, r.Start(bgCtx))
+	}
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	// The destination only accepts dispatches from peers in its regions
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sub.Out():
+		case <-ctx.Done():
+			t.Fatal("peers didn't get in the peermgr")
+		}
+	}
+
+	rc, err := src.Move(ctx, root, dst.h.ID())
+	require.NoError(t, err)
+	require.Equal(t, root, rc.PayloadCID)
+	require.Equal(t, uint64(len(origBytes)), rc.Size)
+	require.NoError(t, rc.Verify(src.h, dst.h.ID()))
+
+	_, err = dst.idx.GetRef(root)
+	require.NoError(t, err)
+	tnds[1].VerifyFileTransferred(ctx, t, tnds[1].DAG, root, origBytes)
+
+	// Receipts cannot be attributed to another peer
+	require.Error(t, rc.Verify(src.h, other.h.ID()))
+
+	// Peers without the content fail the audit
+	_, err = src.Audit(ctx, other.h.ID(), root)
+	require.ErrorIs(t, err, ErrAuditFailed)
+}
+
+func TestAuditCache(t *testing.T) {
+	bgCtx := context.Background()
+	ctx, cancel := context.WithTimeout(bgCtx, 20*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(bgCtx)
+	regions := []Region{
+		{
+			Name: "TestRegion",
+			Code: CustomRegion,
+		},
+	}
+
+	var tnds []*testutil.TestNode
+	var repls []*Replication
+	for i := 0; i < 3; i++ {
+		tnode := testutil.NewTestNode(mn, t)
+		tnode.SetupDataTransfer(bgCtx, t)
+		t.Cleanup(func() {
+			require.NoError(t, tnode.Dt.Stop(bgCtx))
+		})
+		idx, err := NewIndex(tnode.Ds, tnode.Bs)
+		require.NoError(t, err)
+		opts := Options{Regions: regions, MultiStore: tnode.Ms, Blockstore: tnode.Bs}
+		r, err := NewReplication(tnode.Host, idx, tnode.Dt, NewMockRetriever(tnode.Dt, idx), opts)
+		require.NoError(t, err)
+		tnds = append(tnds, tnode)
+		repls = append(repls, r)
+	}
+	src, dst, lost := repls[0], repls[1], repls[2]
+	src.rep = NewReputation()
+
+	fname := tnds[0].CreateRandomFile(t, 256000)
+	link, storeID, origBytes := tnds[0].LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	store, err := tnds[0].Ms.Get(storeID)
+	require.NoError(t, err)
+	require.NoError(t, utils.MigrateBlocks(ctx, store.Bstore, tnds[0].Bs))
+	require.NoError(t, src.idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: int64(len(origBytes)),
+	}))
+
+	sub, err := dst.h.EventBus().Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+	for _, r := range repls {
+		require.NoError(t, r.Start(bgCtx))
+	}
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sub.Out():
+		case <-ctx.Done():
+			t.Fatal("peers didn't get in the peermgr")
+		}
+	}
+
+	// We dispatched the content to a peer which silently dropped it
+	src.trackReplicas(root, 1)
+	src.addReplica(root, lost.h.ID())
+	require.Equal(t, []auditTarget{{root: root, peer: lost.h.ID()}}, src.auditTargets(AuditBatchSize))
+
+	completed := make(chan ReplicationState, 1)
+	lostReplica := make(chan ReplicationState, 1)
+	unsub := src.SubscribeToEvents(func(event ReplicationEvent, state ReplicationState) {
+		switch event {
+		case ReplicationEventTransferCompleted:
+			completed <- state
+		case ReplicationEventReplicaLost:
+			lostReplica <- state
+		}
+	})
+	defer unsub()
+
+	require.ErrorIs(t, src.auditCache(ctx, root, lost.h.ID()), ErrAuditFailed)
+	require.Equal(t, 1, src.rep.Stats(lost.h.ID()).Failures)
+	state := <-lostReplica
+	require.Equal(t, lost.h.ID(), state.Peer)
+	require.Equal(t, "0 of 1 replicas left", state.Message)
+
+	// The replica is replaced by a peer which didn't lose it
+	select {
+	case state := <-completed:
+		require.Equal(t, dst.h.ID(), state.Peer)
+	case <-ctx.Done():
+		t.Fatal("replica not replaced")
+	}
+	require.Equal(t, []peer.ID{dst.h.ID()}, src.catalog.Providers(root))
+	set, ok := src.Replicas(root)
+	require.True(t, ok)
+	require.Equal(t, []peer.ID{dst.h.ID()}, set.Holders)
+	require.NoError(t, src.auditCache(ctx, root, dst.h.ID()))
+}
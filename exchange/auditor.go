@@ -0,0 +1,93 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultAuditInterval is the interval at which we audit the caches holding the content we dispatched
+const DefaultAuditInterval = time.Hour
+
+// AuditBatchSize is the number of caches challenged each audit round
+const AuditBatchSize = 4
+
+// AuditTimeout bounds how long a cache can take to answer a challenge
+const AuditTimeout = 30 * time.Second
+
+// auditTarget is a cache which should hold some content we dispatched
+type auditTarget struct {
+	root cid.Cid
+	peer peer.ID
+}
+
+// auditTargets returns at most n caches picked at random among the holders of the content we dispatched
+// and still hold ourselves since challenges are blocks of the DAG
+func (r *Replication) auditTargets(n int) []auditTarget {
+	var targets []auditTarget
+	for _, set := range r.replicaSets() {
+		if _, err := r.idx.PeekRef(set.PayloadCID); err != nil {
+			continue
+		}
+		for _, p := range set.Holders {
+			targets = append(targets, auditTarget{root: set.PayloadCID, peer: p})
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+	if len(targets) > n {
+		targets = targets[:n]
+	}
+	return targets
+}
+
+// runAudits regularly challenges a random sample of the caches holding the content we dispatched
+func (r *Replication) runAudits(ctx context.Context) {
+	ticker := time.NewTicker(r.auditInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, t := range r.auditTargets(AuditBatchSize) {
+				r.auditCache(ctx, t.root, t.peer)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// auditCache challenges a cache and replaces its replica if it cannot prove it still holds the content.
+// Caches we cannot reach are left to the heartbeats as they may only be offline for a while.
+// It returns the audit error if any.
+func (r *Replication) auditCache(ctx context.Context, root cid.Cid, p peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, AuditTimeout)
+	defer cancel()
+	_, err := r.Audit(ctx, p, root)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrAuditFailed) {
+		log.Debug().Err(err).Str("peer", p.String()).Str("root", root.String()).Msg("could not audit cache")
+		return err
+	}
+	log.Info().Err(err).Str("peer", p.String()).Str("root", root.String()).Msg("cache lost content")
+	if r.rep != nil {
+		r.rep.RecordFailure(p)
+	}
+	r.catalog.Remove(root, p)
+	r.removeReplica(root, p)
+	r.publish(ReplicationEventAuditFailed, ReplicationState{
+		PayloadCID: root,
+		Peer:       p,
+		Message:    err.Error(),
+	})
+	r.restoreReplicas(root, p)
+	return err
+}
@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/rs/zerolog/log"
+)
+
+// CacheLabel is set on the refs we cached opportunistically so they can be told apart from the content
+// we were dispatched or added ourselves, i.e. to restrict eviction to them.
+const CacheLabel = "origin"
+
+// CacheLabelValue is the value of the CacheLabel for the refs we cached opportunistically
+const CacheLabelValue = "cache"
+
+// DefaultCacheMaxSize is the largest content we cache opportunistically unless specified otherwise
+const DefaultCacheMaxSize = 4 << 20
+
+// DefaultCacheMinQueries is the number of queries we must observe for a CID before caching it
+const DefaultCacheMinQueries = 3
+
+// CachePolicy sets the rules for caching popular content we see queries and offers for but don't hold
+type CachePolicy struct {
+	// Budget is the total size in bytes of the content we may cache opportunistically. 0 disables caching.
+	Budget uint64
+	// MaxSize is the largest content size in bytes we cache. Defaults to DefaultCacheMaxSize.
+	MaxSize uint64
+	// MinQueries is the number of queries we must observe for a CID before caching it.
+	// Defaults to DefaultCacheMinQueries.
+	MinQueries uint64
+}
+
+// cacheCandidate is content we don't hold which other peers are looking for
+type cacheCandidate struct {
+	queries uint64
+	// size is only known once we relayed an offer for the content
+	size     uint64
+	lastSeen time.Time
+}
+
+// FetchFunc retrieves the DAG for a root and adds it to our index
+type FetchFunc func(context.Context, cid.Cid) error
+
+// OpportunisticCache fetches small popular content we relay queries and offers for so a node forwarding
+// traffic becomes a useful cache without operator intervention. Content is fetched one item at a time and
+// the total size of the content it cached stays within the policy budget.
+type OpportunisticCache struct {
+	ctx    context.Context
+	idx    *Index
+	policy CachePolicy
+	fetch  FetchFunc
+	sup    *utils.Supervisor
+
+	mu         sync.Mutex
+	candidates map[cid.Cid]*cacheCandidate
+	fetching   bool
+}
+
+// NewOpportunisticCache creates a new cache. It returns nil if the policy has no budget.
+func NewOpportunisticCache(ctx context.Context, idx *Index, policy CachePolicy, fetch FetchFunc, sup *utils.Supervisor) *OpportunisticCache {
+	if policy.Budget == 0 {
+		return nil
+	}
+	if policy.MaxSize == 0 {
+		policy.MaxSize = DefaultCacheMaxSize
+	}
+	if policy.MinQueries == 0 {
+		policy.MinQueries = DefaultCacheMinQueries
+	}
+	return &OpportunisticCache{
+		ctx:        ctx,
+		idx:        idx,
+		policy:     policy,
+		fetch:      fetch,
+		sup:        sup,
+		candidates: make(map[cid.Cid]*cacheCandidate),
+	}
+}
+
+// ObserveQuery records a query for content we don't hold
+func (c *OpportunisticCache) ObserveQuery(root cid.Cid) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.candidate(root).queries++
+	c.maybeFetch(root)
+}
+
+// ObserveOffer records the size of content offered by another peer
+func (c *OpportunisticCache) ObserveOffer(offer deal.Offer) {
+	if c == nil || offer.Size == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.candidate(offer.PayloadCID).size = offer.Size
+	c.maybeFetch(offer.PayloadCID)
+}
+
+func (c *OpportunisticCache) candidate(root cid.Cid) *cacheCandidate {
+	cc, ok := c.candidates[root]
+	if !ok {
+		if len(c.candidates) >= maxSummaryEntries {
+			c.forget()
+		}
+		cc = &cacheCandidate{}
+		c.candidates[root] = cc
+	}
+	cc.lastSeen = time.Now()
+	return cc
+}
+
+// forget removes the candidates we haven't seen in a while or the least recently seen one
+func (c *OpportunisticCache) forget() {
+	var oldest cid.Cid
+	var oldestSeen time.Time
+	for k, cc := range c.candidates {
+		if time.Since(cc.lastSeen) > SummaryWindow {
+			delete(c.candidates, k)
+			continue
+		}
+		if !oldest.Defined() || cc.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = k, cc.lastSeen
+		}
+	}
+	if len(c.candidates) >= maxSummaryEntries {
+		delete(c.candidates, oldest)
+	}
+}
+
+// maybeFetch starts fetching the content if it is popular and small enough for our budget.
+// It must be called with the lock held.
+func (c *OpportunisticCache) maybeFetch(root cid.Cid) {
+	cc := c.candidates[root]
+	if c.fetching || cc.queries < c.policy.MinQueries || cc.size == 0 || cc.size > c.policy.MaxSize {
+		return
+	}
+	if _, err := c.idx.PeekRef(root); err == nil {
+		delete(c.candidates, root)
+		return
+	}
+	used, err := c.Used()
+	if err != nil || used+cc.size > c.policy.Budget {
+		return
+	}
+	c.fetching = true
+	delete(c.candidates, root)
+	go func() {
+		defer c.sup.Recover("opportunistic-cache")
+		defer func() {
+			c.mu.Lock()
+			c.fetching = false
+			c.mu.Unlock()
+		}()
+		if err := c.fetch(c.ctx, root); err != nil {
+			log.Debug().Err(err).Str("root", root.String()).Msg("failed to cache content")
+			return
+		}
+		if err := c.idx.LabelRef(root, map[string]string{CacheLabel: CacheLabelValue}); err != nil {
+			log.Error().Err(err).Msg("failed to label cached ref")
+			return
+		}
+		log.Info().Str("root", root.String()).Msg("cached popular content")
+	}()
+}
+
+// Used returns the total size of the content we cached opportunistically which is still in our index
+func (c *OpportunisticCache) Used() (uint64, error) {
+	refs, err := c.idx.ListRefs()
+	if err != nil {
+		return 0, err
+	}
+	var used uint64
+	for _, ref := range refs {
+		if ref.Labels[CacheLabel] == CacheLabelValue {
+			used += uint64(ref.PayloadSize)
+		}
+	}
+	return used, nil
+}
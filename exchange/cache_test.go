@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpportunisticCache(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	require.Nil(t, NewOpportunisticCache(ctx, idx, CachePolicy{}, nil, nil))
+
+	fetched := make(chan cid.Cid, 1)
+	fetch := func(ctx context.Context, root cid.Cid) error {
+		fetched <- root
+		return idx.SetRef(&DataRef{PayloadCID: root, PayloadSize: 1000})
+	}
+	c := NewOpportunisticCache(ctx, idx, CachePolicy{Budget: 1500, MaxSize: 1000, MinQueries: 2}, fetch, nil)
+
+	popular := blockGen.Next().Cid()
+	c.ObserveQuery(popular)
+	c.ObserveOffer(deal.Offer{PayloadCID: popular, Size: 1000})
+	// not popular enough yet
+	require.Len(t, fetched, 0)
+
+	c.ObserveQuery(popular)
+	select {
+	case root := <-fetched:
+		require.Equal(t, popular, root)
+	case <-time.After(time.Second):
+		t.Fatal("content not fetched")
+	}
+	require.Eventually(t, func() bool {
+		ref, err := idx.PeekRef(popular)
+		return err == nil && ref.Labels[CacheLabel] == CacheLabelValue
+	}, time.Second, 10*time.Millisecond)
+
+	used, err := c.Used()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), used)
+
+	// too large
+	large := blockGen.Next().Cid()
+	c.ObserveOffer(deal.Offer{PayloadCID: large, Size: 2000})
+	c.ObserveQuery(large)
+	c.ObserveQuery(large)
+
+	// over budget
+	other := blockGen.Next().Cid()
+	c.ObserveOffer(deal.Offer{PayloadCID: other, Size: 1000})
+	c.ObserveQuery(other)
+	c.ObserveQuery(other)
+
+	select {
+	case root := <-fetched:
+		t.Fatalf("unexpected fetch for %s", root)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
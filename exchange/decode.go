@@ -0,0 +1,213 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/retrieval"
+	"github.com/myelnet/pop/retrieval/deal"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrInvalidMsg is returned when a message received from a peer is malformed or exceeds our limits
+var ErrInvalidMsg = errors.New("invalid message")
+
+const (
+	// MaxHeySize is the maximum size in bytes of an encoded Hey message
+	MaxHeySize = 4 << 10
+	// MaxRequestSize is the maximum size in bytes of an encoded Request message
+	MaxRequestSize = 1 << 10
+	// MaxAuditSize is the maximum size in bytes of an encoded AuditRequest or Receipt message
+	MaxAuditSize = 2 << 10
+	// MaxIncentiveSize is the maximum size in bytes of an encoded IncentiveClaim or IncentivePayment message
+	MaxIncentiveSize = 2 << 10
+	// MaxQuerySize is the maximum size in bytes of an encoded Query message including the selector
+	MaxQuerySize = 16 << 10
+	// maxHeyRegions is the maximum number of regions a peer can advertise
+	maxHeyRegions = 32
+	// maxHeyProtocols is the maximum number of protocols a peer can advertise
+	maxHeyProtocols = 16
+	// maxProtocolLen is the maximum length of a protocol ID
+	maxProtocolLen = 128
+)
+
+// limitReader fails with ErrInvalidMsg once more than n bytes are read so a peer cannot make us
+// read an unbounded message
+type limitReader struct {
+	r io.Reader
+	n int64
+	// err is the last error of the underlying reader
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf("%w: message too large", ErrInvalidMsg)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if err != nil {
+		l.err = err
+	}
+	return n, err
+}
+
+// decodeMsg decodes a CBOR message reading at most max bytes. Any panic in the decoder is
+// recovered and returned as an error. Failing to read the whole message because the stream was
+// closed, reset or timed out doesn't mean the message is invalid.
+func decodeMsg(r io.Reader, max int64, msg cbg.CBORUnmarshaler) (err error) {
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidMsg, rerr)
+		}
+	}()
+	lr := &limitReader{r: r, n: max}
+	if err := msg.UnmarshalCBOR(lr); err != nil {
+		if errors.Is(err, ErrInvalidMsg) {
+			return err
+		}
+		if lr.err != nil {
+			return fmt.Errorf("failed to read message: %w", lr.err)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidMsg, err)
+	}
+	return nil
+}
+
+// Validate checks a Hey message respects the protocol limits
+func (h Hey) Validate() error {
+	if len(h.Regions) > maxHeyRegions {
+		return fmt.Errorf("%w: too many regions (%d)", ErrInvalidMsg, len(h.Regions))
+	}
+	if len(h.Protocols) > maxHeyProtocols {
+		return fmt.Errorf("%w: too many protocols (%d)", ErrInvalidMsg, len(h.Protocols))
+	}
+	for _, p := range h.Protocols {
+		if len(p) == 0 || len(p) > maxProtocolLen {
+			return fmt.Errorf("%w: invalid protocol length (%d)", ErrInvalidMsg, len(p))
+		}
+	}
+	if h.PPB.Int != nil && h.PPB.Sign() < 0 {
+		return fmt.Errorf("%w: negative price", ErrInvalidMsg)
+	}
+	return nil
+}
+
+// Validate checks a Request message is well formed
+func (r Request) Validate() error {
+	if r.Method != Dispatch && r.Method != FetchIndex {
+		return fmt.Errorf("%w: unknown method %d", ErrInvalidMsg, r.Method)
+	}
+	if !r.PayloadCID.Defined() {
+		return fmt.Errorf("%w: undefined payload cid", ErrInvalidMsg)
+	}
+	return nil
+}
+
+// validateQuery checks a Query has a payload and a valid selector if any
+func validateQuery(q deal.Query) error {
+	if !q.PayloadCID.Defined() {
+		return fmt.Errorf("%w: undefined payload cid", ErrInvalidMsg)
+	}
+	// a query without selector encodes it as cbor null
+	if q.Selector == nil || bytes.Equal(q.Selector.Raw, cbg.CborNull) {
+		return nil
+	}
+	nd, err := retrieval.DecodeNode(q.Selector)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode selector: %v", ErrInvalidMsg, err)
+	}
+	if _, err := selector.ParseSelector(nd); err != nil {
+		return fmt.Errorf("%w: invalid selector: %v", ErrInvalidMsg, err)
+	}
+	return nil
+}
+
+// DecodeHey reads and validates a Hey message encoded with the latest hey protocol
+func DecodeHey(r io.Reader) (Hey, error) {
+	return DecodeHeyVersion(r, HeyProtocol)
+}
+
+// DecodeHeyVersion reads and validates a Hey message encoded with the given version of the hey protocol
+func DecodeHeyVersion(r io.Reader, proto protocol.ID) (Hey, error) {
+	var h Hey
+	var err error
+	if proto == HeyProtocolV1 {
+		var legacy HeyV1
+		err = decodeMsg(r, MaxHeySize, &legacy)
+		h = legacy.Hey()
+	} else {
+		err = decodeMsg(r, MaxHeySize, &h)
+	}
+	if err != nil {
+		return Hey{}, err
+	}
+	if err := h.Validate(); err != nil {
+		return Hey{}, err
+	}
+	return h, nil
+}
+
+// DecodeRequest reads and validates a Request message encoded with the latest request protocol
+func DecodeRequest(r io.Reader) (Request, error) {
+	return DecodeRequestVersion(r, PopRequestProtocolID)
+}
+
+// DecodeRequestVersion reads and validates a Request message encoded with the given version of the
+// request protocol
+func DecodeRequestVersion(r io.Reader, proto protocol.ID) (Request, error) {
+	req, _, err := DecodeDispatch(r, proto)
+	return req, err
+}
+
+// DecodeDispatch reads and validates a Request message with the extras the sender may send along with it.
+// Only version 2.0 of the request protocol can carry extras.
+func DecodeDispatch(r io.Reader, proto protocol.ID) (Request, DispatchExtras, error) {
+	var req Request
+	var ext DispatchExtras
+	var err error
+	switch proto {
+	case PopRequestProtocolV1:
+		var legacy RequestV1
+		err = decodeMsg(r, MaxRequestSize, &legacy)
+		req = legacy.Request()
+	case PopRequestProtocolV11:
+		err = decodeMsg(r, MaxRequestSize, &req)
+	default:
+		var ereq extensibleRequest
+		err = decodeMsg(r, MaxRequestSize, &ereq)
+		req = ereq.Request
+		ext = DispatchExtras{Incentive: ereq.Incentive, Private: ereq.Private}
+	}
+	if err != nil {
+		return Request{}, DispatchExtras{}, err
+	}
+	if err := req.Validate(); err != nil {
+		return Request{}, DispatchExtras{}, err
+	}
+	if ext.Incentive != nil {
+		if err := ext.Incentive.Validate(); err != nil {
+			return Request{}, DispatchExtras{}, err
+		}
+	}
+	return req, ext, nil
+}
+
+// DecodeQuery reads and validates a Query message
+func DecodeQuery(r io.Reader) (deal.Query, error) {
+	var q deal.Query
+	if err := decodeMsg(r, MaxQuerySize, &q); err != nil {
+		return deal.Query{}, err
+	}
+	if err := validateQuery(q); err != nil {
+		return deal.Query{}, err
+	}
+	return q, nil
+}
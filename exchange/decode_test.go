@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeHey(t *testing.T) {
+	encode := func(h Hey) *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		require.NoError(t, h.MarshalCBOR(buf))
+		return buf
+	}
+
+	h, err := DecodeHey(encode(Hey{
+		Regions:   []RegionCode{GlobalRegion},
+		Protocols: SupportedProtocols,
+		Capacity:  1024,
+		PPB:       abi.NewTokenAmount(1),
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1024), h.Capacity)
+
+	testCases := []struct {
+		name string
+		hey  Hey
+	}{
+		{"too many regions", Hey{Regions: make([]RegionCode, maxHeyRegions+1), PPB: abi.NewTokenAmount(0)}},
+		{"too many protocols", Hey{Protocols: make([]string, maxHeyProtocols+1), PPB: abi.NewTokenAmount(0)}},
+		{"protocol too long", Hey{Protocols: []string{strings.Repeat("a", maxProtocolLen+1)}, PPB: abi.NewTokenAmount(0)}},
+		{"message too large", Hey{Protocols: []string{strings.Repeat("a", MaxHeySize)}, PPB: abi.NewTokenAmount(0)}},
+		{"negative price", Hey{PPB: abi.NewTokenAmount(-1)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeHey(encode(tc.hey))
+			require.True(t, errors.Is(err, ErrInvalidMsg))
+		})
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	encode := func(r Request) *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		require.NoError(t, r.MarshalCBOR(buf))
+		return buf
+	}
+
+	req := Request{Method: Dispatch, PayloadCID: bgen.Next().Cid(), Size: 100}
+	r, err := DecodeRequest(encode(req))
+	require.NoError(t, err)
+	require.Equal(t, req, r)
+
+	_, err = DecodeRequest(encode(Request{Method: Method(10), PayloadCID: bgen.Next().Cid()}))
+	require.True(t, errors.Is(err, ErrInvalidMsg))
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	buf := new(bytes.Buffer)
+	req := Request{Method: Dispatch, PayloadCID: bgen.Next().Cid(), Size: 100}
+	require.NoError(t, req.MarshalCBOR(buf))
+
+	// a stream closed before the end of the message is not the sign of a malicious peer
+	_, err := DecodeRequest(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrInvalidMsg))
+
+	_, err = DecodeRequest(bytes.NewReader(nil))
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrInvalidMsg))
+}
+
+func TestDecodeQuery(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	buf := new(bytes.Buffer)
+	q := deal.Query{PayloadCID: bgen.Next().Cid()}
+	require.NoError(t, q.MarshalCBOR(buf))
+
+	dq, err := DecodeQuery(buf)
+	require.NoError(t, err)
+	require.Equal(t, q.PayloadCID, dq.PayloadCID)
+}
+
+func TestDecodeGarbage(t *testing.T) {
+	// random bytes should never panic and decoding errors are ErrInvalidMsg unless the message is cut short
+	check := func(err error) {
+		if err != nil {
+			require.True(t, errors.Is(err, ErrInvalidMsg) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, rand.Intn(512))
+		rand.Read(b)
+		_, err := DecodeHey(bytes.NewReader(b))
+		check(err)
+		_, err = DecodeRequest(bytes.NewReader(b))
+		check(err)
+		_, err = DecodeQuery(bytes.NewReader(b))
+		check(err)
+	}
+}
@@ -0,0 +1,319 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/rs/zerolog/log"
+)
+
+// Routing is the discovery mechanism finding the providers of some content and collecting their offers.
+// Offers are sent asynchronously to the receiver set with SetReceiver.
+type Routing interface {
+	// StartProviding answers the queries of other peers with the offers returned by the ResponseFunc
+	StartProviding(context.Context, ResponseFunc) error
+	// Query starts looking for offers for a given root and selector
+	Query(context.Context, cid.Cid, ipld.Node) error
+	// QueryProvider asks a known provider for an offer directly
+	QueryProvider(peer.AddrInfo, cid.Cid, ipld.Node) (deal.Offer, error)
+	// SetReceiver sets a callback to receive the offers
+	SetReceiver(ReceiveOffer)
+	// AddAddrs adds the addresses of a provider to our peerstore
+	AddAddrs(peer.ID, []ma.Multiaddr)
+}
+
+// Discovery is the name of a discovery backend
+type Discovery string
+
+const (
+	// DiscoveryGossip publishes queries to providers in our regions over gossipsub. It is the default.
+	DiscoveryGossip Discovery = "gossip"
+	// DiscoveryDHT finds providers with content routing records in the DHT
+	DiscoveryDHT Discovery = "dht"
+	// DiscoveryIndexer finds providers with a network indexer service
+	DiscoveryIndexer Discovery = "indexer"
+)
+
+// ErrUnknownDiscovery is returned when the discovery backend is not supported
+var ErrUnknownDiscovery = errors.New("unknown discovery backend")
+
+// ErrNoContentRouting is returned when using DHT discovery without a content routing service
+var ErrNoContentRouting = errors.New("no content routing")
+
+// ErrNoIndexer is returned when using indexer discovery without an indexer endpoint
+var ErrNoIndexer = errors.New("no indexer endpoint")
+
+// MaxProviders is the maximum number of providers we query for each content
+const MaxProviders = 10
+
+// ReprovideInterval is how often we publish provider records again so they don't expire
+const ReprovideInterval = 12 * time.Hour
+
+// announceInterval is how often we check for new refs to publish provider records for
+const announceInterval = time.Minute
+
+// newRouting creates the routing service for the discovery backend set in the options
+func newRouting(h host.Host, idx *Index, opts Options) (Routing, error) {
+	if opts.Routing != nil {
+		return opts.Routing, nil
+	}
+	switch opts.Discovery {
+	case "", DiscoveryGossip:
+		gr := NewGossipRouting(h, opts.PubSub, opts.GossipTracer, opts.Regions)
+		gr.sup = opts.Supervisor
+		return gr, nil
+	case DiscoveryDHT:
+		if opts.ContentRouting == nil {
+			return nil, ErrNoContentRouting
+		}
+		dr := NewDHTRouting(h, opts.ContentRouting, idx, opts.Regions)
+		dr.sup = opts.Supervisor
+		if opts.LowPower {
+			dr.interval = LowPowerAnnounceInterval
+		}
+		return dr, nil
+	case DiscoveryIndexer:
+		if opts.IndexerURL == "" {
+			return nil, ErrNoIndexer
+		}
+		ir := NewIndexerRouting(h, opts.IndexerURL, opts.Regions)
+		ir.sup = opts.Supervisor
+		return ir, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDiscovery, opts.Discovery)
+	}
+}
+
+// receiver keeps the callback offers are sent to
+type receiver struct {
+	mu sync.Mutex
+	fn ReceiveOffer
+}
+
+// SetReceiver sets a callback to receive offers
+func (r *receiver) SetReceiver(fn ReceiveOffer) {
+	r.mu.Lock()
+	r.fn = fn
+	r.mu.Unlock()
+}
+
+func (r *receiver) receive(offer deal.Offer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fn != nil {
+		r.fn(offer)
+	}
+}
+
+// queryAll asks each provider for an offer and sends them to the receiver
+func queryAll(h host.Host, rcv *receiver, providers <-chan peer.AddrInfo, root cid.Cid, sel ipld.Node) {
+	for p := range providers {
+		if p.ID == h.ID() {
+			continue
+		}
+		h.Peerstore().AddAddrs(p.ID, p.Addrs, 8*time.Hour)
+		offer, err := queryProvider(h, p, root, sel)
+		if err != nil {
+			log.Debug().Err(err).Str("peer", p.ID.String()).Msg("failed to query provider")
+			continue
+		}
+		rcv.receive(offer)
+	}
+}
+
+// DHTRouting finds providers with content routing records, i.e. the libp2p DHT, and asks them directly
+// for offers. We publish a provider record for each ref in our index.
+type DHTRouting struct {
+	receiver
+	h       host.Host
+	cr      routing.ContentRouting
+	idx     *Index
+	regions []Region
+	sup     *utils.Supervisor
+	// interval is how often we check for new refs to announce
+	interval time.Duration
+}
+
+// NewDHTRouting creates a new DHTRouting service
+func NewDHTRouting(h host.Host, cr routing.ContentRouting, idx *Index, rgs []Region) *DHTRouting {
+	return &DHTRouting{
+		h:        h,
+		cr:       cr,
+		idx:      idx,
+		regions:  rgs,
+		interval: announceInterval,
+	}
+}
+
+// StartProviding answers direct queries and publishes provider records for the content in our index
+func (dr *DHTRouting) StartProviding(ctx context.Context, fn ResponseFunc) error {
+	handleQueries(ctx, dr.h, dr.sup, dr.regions[0], fn)
+	dr.sup.Go(ctx, "dht-provider", dr.provide)
+	return nil
+}
+
+// provide publishes records for the new refs in our index and republishes them before they expire
+func (dr *DHTRouting) provide(ctx context.Context) {
+	provided := make(map[cid.Cid]time.Time)
+	ticker := time.NewTicker(dr.interval)
+	defer ticker.Stop()
+	for {
+		refs, err := dr.idx.ListRefs()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list refs to provide")
+		}
+		current := make(map[cid.Cid]struct{}, len(refs))
+		for _, ref := range refs {
+			// Private content is not announced to the network
+			if ref.Private {
+				continue
+			}
+			current[ref.PayloadCID] = struct{}{}
+			if t, ok := provided[ref.PayloadCID]; ok && time.Since(t) < ReprovideInterval {
+				continue
+			}
+			if err := dr.cr.Provide(ctx, ref.PayloadCID, true); err != nil {
+				log.Debug().Err(err).Str("root", ref.PayloadCID.String()).Msg("failed to provide")
+				continue
+			}
+			provided[ref.PayloadCID] = time.Now()
+		}
+		// forget the refs we evicted so they are provided again if we get them back
+		for c := range provided {
+			if _, ok := current[c]; !ok {
+				delete(provided, c)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Query looks for providers in the DHT and asks each of them for an offer
+func (dr *DHTRouting) Query(ctx context.Context, root cid.Cid, sel ipld.Node) error {
+	providers := dr.cr.FindProvidersAsync(ctx, root, MaxProviders)
+	go queryAll(dr.h, &dr.receiver, providers, root, sel)
+	return nil
+}
+
+// QueryProvider asks a provider directly for retrieval conditions
+func (dr *DHTRouting) QueryProvider(p peer.AddrInfo, root cid.Cid, sel ipld.Node) (deal.Offer, error) {
+	return queryProvider(dr.h, p, root, sel)
+}
+
+// AddAddrs adds a new peer into the host peerstore
+func (dr *DHTRouting) AddAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	dr.h.Peerstore().AddAddrs(p, addrs, 8*time.Hour)
+}
+
+// IndexerRouting finds providers by looking up content in a network indexer service and asks them
+// directly for offers. Providers must advertise their content to the indexer separately.
+type IndexerRouting struct {
+	receiver
+	h        host.Host
+	endpoint string
+	client   *http.Client
+	regions  []Region
+	sup      *utils.Supervisor
+}
+
+// NewIndexerRouting creates a new IndexerRouting service querying the indexer at the given url
+func NewIndexerRouting(h host.Host, endpoint string, rgs []Region) *IndexerRouting {
+	return &IndexerRouting{
+		h:        h,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		regions:  rgs,
+	}
+}
+
+// indexerResponse is the body returned by an indexer when looking up a multihash
+type indexerResponse struct {
+	MultihashResults []struct {
+		ProviderResults []struct {
+			Provider peer.AddrInfo
+		}
+	}
+}
+
+// FindProviders returns the providers the indexer knows for a given root
+func (ir *IndexerRouting) FindProviders(ctx context.Context, root cid.Cid) ([]peer.AddrInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ir.endpoint+"/multihash/"+root.Hash().B58String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := ir.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("indexer returned status %s", res.Status)
+	}
+	var body indexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	var providers []peer.AddrInfo
+	for _, mr := range body.MultihashResults {
+		for _, pr := range mr.ProviderResults {
+			providers = append(providers, pr.Provider)
+			if len(providers) == MaxProviders {
+				return providers, nil
+			}
+		}
+	}
+	return providers, nil
+}
+
+// StartProviding answers direct queries from the peers who found us in the indexer
+func (ir *IndexerRouting) StartProviding(ctx context.Context, fn ResponseFunc) error {
+	handleQueries(ctx, ir.h, ir.sup, ir.regions[0], fn)
+	return nil
+}
+
+// Query looks up providers in the indexer and asks each of them for an offer
+func (ir *IndexerRouting) Query(ctx context.Context, root cid.Cid, sel ipld.Node) error {
+	providers, err := ir.FindProviders(ctx, root)
+	if err != nil {
+		return err
+	}
+	pchan := make(chan peer.AddrInfo, len(providers))
+	for _, p := range providers {
+		pchan <- p
+	}
+	close(pchan)
+	go queryAll(ir.h, &ir.receiver, pchan, root, sel)
+	return nil
+}
+
+// QueryProvider asks a provider directly for retrieval conditions
+func (ir *IndexerRouting) QueryProvider(p peer.AddrInfo, root cid.Cid, sel ipld.Node) (deal.Offer, error) {
+	return queryProvider(ir.h, p, root, sel)
+}
+
+// AddAddrs adds a new peer into the host peerstore
+func (ir *IndexerRouting) AddAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	ir.h.Peerstore().AddAddrs(p, addrs, 8*time.Hour)
+}
@@ -0,0 +1,115 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// recordRouting records the roots we provide
+type recordRouting struct {
+	mu       sync.Mutex
+	provided map[cid.Cid]bool
+	first    chan struct{}
+}
+
+func (rr *recordRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if len(rr.provided) == 0 {
+		close(rr.first)
+	}
+	rr.provided[c] = true
+	return nil
+}
+
+func (rr *recordRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	close(out)
+	return out
+}
+
+func TestDHTProvideSkipsPrivate(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	pub := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{PayloadCID: pub, PayloadSize: 100}))
+	priv := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{PayloadCID: priv, PayloadSize: 100, Private: true}))
+
+	rr := &recordRouting{provided: make(map[cid.Cid]bool), first: make(chan struct{})}
+	dr := NewDHTRouting(nil, rr, idx, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dr.provide(ctx)
+		close(done)
+	}()
+	<-rr.first
+	// the provider finishes announcing the refs it listed before returning
+	cancel()
+	<-done
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	require.True(t, rr.provided[pub])
+	require.False(t, rr.provided[priv])
+}
+
+func TestIndexerFindProviders(t *testing.T) {
+	root := blockGen.Next().Cid()
+	pid := "12D3KooWLJp52qe5Fa2ND3nsWocdnRhi7ERo2SzkApE1q8jUg2Xy"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/multihash/"+root.Hash().B58String() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"MultihashResults":[{"Multihash":"","ProviderResults":[{"ContextID":"","Metadata":"",
+			"Provider":{"ID":"` + pid + `","Addrs":["/ip4/127.0.0.1/tcp/41504"]}}]}]}`))
+	}))
+	defer srv.Close()
+
+	ir := NewIndexerRouting(nil, srv.URL+"/", []Region{global})
+
+	providers, err := ir.FindProviders(context.Background(), root)
+	require.NoError(t, err)
+	require.Len(t, providers, 1)
+	expected, err := peer.Decode(pid)
+	require.NoError(t, err)
+	require.Equal(t, expected, providers[0].ID)
+	require.Len(t, providers[0].Addrs, 1)
+
+	// content the indexer doesn't know about
+	providers, err = ir.FindProviders(context.Background(), blockGen.Next().Cid())
+	require.NoError(t, err)
+	require.Len(t, providers, 0)
+}
+
+func TestNewRouting(t *testing.T) {
+	_, err := newRouting(nil, nil, Options{Discovery: DiscoveryDHT})
+	require.ErrorIs(t, err, ErrNoContentRouting)
+
+	_, err = newRouting(nil, nil, Options{Discovery: DiscoveryIndexer})
+	require.ErrorIs(t, err, ErrNoIndexer)
+
+	_, err = newRouting(nil, nil, Options{Discovery: "carrier-pigeon"})
+	require.ErrorIs(t, err, ErrUnknownDiscovery)
+
+	r, err := newRouting(nil, nil, Options{Discovery: DiscoveryIndexer, IndexerURL: "https://cid.contact"})
+	require.NoError(t, err)
+	require.IsType(t, &IndexerRouting{}, r)
+}
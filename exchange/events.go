@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"fmt"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval"
+)
+
+// ReplicationEvent is an event emitted while replicating content on the network
+type ReplicationEvent uint64
+
+const (
+	// ReplicationEventRequestSent is emitted when we ask a peer to cache some content
+	ReplicationEventRequestSent ReplicationEvent = iota
+	// ReplicationEventPeerAccepted is emitted when a peer starts pulling the content we dispatched
+	ReplicationEventPeerAccepted
+	// ReplicationEventTransferCompleted is emitted when a peer received all the content we dispatched
+	ReplicationEventTransferCompleted
+	// ReplicationEventTransferFailed is emitted when a transfer to a peer failed, was cancelled or stalled
+	ReplicationEventTransferFailed
+	// ReplicationEventRefDropped is emitted when a ref is removed from our index
+	ReplicationEventRefDropped
+	// ReplicationEventVerified is emitted when the content we received matches its root and size
+	ReplicationEventVerified
+	// ReplicationEventVerificationFailed is emitted when the content we received is missing blocks or corrupt
+	ReplicationEventVerificationFailed
+	// ReplicationEventDispatchCancelled is emitted when a dispatch is cancelled before reaching its replication factor
+	ReplicationEventDispatchCancelled
+	// ReplicationEventAuditFailed is emitted when a cache could not prove it still holds content we dispatched
+	ReplicationEventAuditFailed
+	// ReplicationEventReplicaLost is emitted when content we published drops below its replication factor
+	// and is dispatched to replacement peers
+	ReplicationEventReplicaLost
+)
+
+// ReplicationEvents maps replication event codes to string names
+var ReplicationEvents = map[ReplicationEvent]string{
+	ReplicationEventRequestSent:        "RequestSent",
+	ReplicationEventPeerAccepted:       "PeerAccepted",
+	ReplicationEventTransferCompleted:  "TransferCompleted",
+	ReplicationEventTransferFailed:     "TransferFailed",
+	ReplicationEventRefDropped:         "RefDropped",
+	ReplicationEventVerified:           "Verified",
+	ReplicationEventVerificationFailed: "VerificationFailed",
+	ReplicationEventDispatchCancelled:  "DispatchCancelled",
+	ReplicationEventAuditFailed:        "AuditFailed",
+	ReplicationEventReplicaLost:        "ReplicaLost",
+}
+
+func (e ReplicationEvent) String() string {
+	if name, ok := ReplicationEvents[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("ReplicationEvent(%d)", uint64(e))
+}
+
+// ReplicationState describes the content and peer a replication event is about
+type ReplicationState struct {
+	PayloadCID cid.Cid
+	// Peer is the peer we are replicating to, it is empty for RefDropped events
+	Peer peer.ID
+	// Size is the size of the content in bytes
+	Size uint64
+	// Message explains why a transfer failed
+	Message string
+}
+
+// ReplicationSubscriber is a callback registered to listen for replication events
+type ReplicationSubscriber func(event ReplicationEvent, state ReplicationState)
+
+type internalReplicationEvent struct {
+	evt   ReplicationEvent
+	state ReplicationState
+}
+
+func replicationDispatcher(evt pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	ie, ok := evt.(internalReplicationEvent)
+	if !ok {
+		return fmt.Errorf("wrong type of event")
+	}
+	cb, ok := subscriberFn.(ReplicationSubscriber)
+	if !ok {
+		return fmt.Errorf("wrong type of subscriber")
+	}
+	cb(ie.evt, ie.state)
+	return nil
+}
+
+// SubscribeToEvents listens to the progress of the content we dispatch and the refs leaving our index
+func (r *Replication) SubscribeToEvents(subscriber ReplicationSubscriber) retrieval.Unsubscribe {
+	return retrieval.Unsubscribe(r.subscribers.Subscribe(subscriber))
+}
+
+func (r *Replication) publish(evt ReplicationEvent, state ReplicationState) {
+	if r.subscribers == nil {
+		return
+	}
+	_ = r.subscribers.Publish(internalReplicationEvent{evt, state})
+}
+
+// refDropped is called by the index when a ref was evicted or dropped
+func (r *Replication) refDropped(ref *DataRef) {
+	// We cannot replace replicas of content we don't hold anymore
+	r.dropReplicas(ref.PayloadCID)
+	r.publish(ReplicationEventRefDropped, ReplicationState{
+		PayloadCID: ref.PayloadCID,
+		Size:       uint64(ref.PayloadSize),
+	})
+}
@@ -0,0 +1,48 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationEvents(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	r := &Replication{subscribers: pubsub.New(replicationDispatcher)}
+	idx, err := NewIndex(ds, bs, WithDropFunc(r.refDropped))
+	require.NoError(t, err)
+
+	var events []ReplicationEvent
+	var states []ReplicationState
+	unsub := r.SubscribeToEvents(func(event ReplicationEvent, state ReplicationState) {
+		events = append(events, event)
+		states = append(states, state)
+	})
+
+	ref := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 1024,
+	}
+	require.NoError(t, idx.SetRef(ref))
+	require.NoError(t, idx.DropRef(ref.PayloadCID))
+
+	require.Equal(t, []ReplicationEvent{ReplicationEventRefDropped}, events)
+	require.Equal(t, ref.PayloadCID, states[0].PayloadCID)
+	require.Equal(t, uint64(1024), states[0].Size)
+
+	r.publish(ReplicationEventTransferFailed, ReplicationState{Message: "stalled"})
+	require.Len(t, events, 2)
+	require.Equal(t, "TransferFailed", events[1].String())
+	require.Equal(t, "stalled", states[1].Message)
+
+	unsub()
+	r.publish(ReplicationEventRequestSent, ReplicationState{})
+	require.Len(t, events, 2)
+}
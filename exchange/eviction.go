@@ -0,0 +1,54 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrUnknownEvictionPolicy is returned when parsing the name of a policy we don't support
+var ErrUnknownEvictionPolicy = errors.New("unknown eviction policy")
+
+// EvictionPolicy sorts the refs which can be evicted when the index is over capacity so the refs
+// at the front are evicted first. Refs are given in least frequently used order.
+type EvictionPolicy func(refs []*DataRef)
+
+// EvictLFU evicts the least frequently read refs first. It is the default policy.
+func EvictLFU(refs []*DataRef) {}
+
+// EvictLRU evicts the refs which haven't been read for the longest time first
+func EvictLRU(refs []*DataRef) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].LastRead < refs[j].LastRead
+	})
+}
+
+// EvictLargestFirst evicts the largest refs first so we free space removing as few refs as possible
+func EvictLargestFirst(refs []*DataRef) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].PayloadSize > refs[j].PayloadSize
+	})
+}
+
+// EvictOldestFirst evicts the refs stored for the longest time first as if they all had the same time to live
+func EvictOldestFirst(refs []*DataRef) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Created < refs[j].Created
+	})
+}
+
+// ParseEvictionPolicy returns the policy for a given name: lfu, lru, largest or ttl. Defaults to lfu if empty.
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "", "lfu":
+		return EvictLFU, nil
+	case "lru":
+		return EvictLRU, nil
+	case "largest":
+		return EvictLargestFirst, nil
+	case "ttl":
+		return EvictOldestFirst, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvictionPolicy, name)
+	}
+}
@@ -0,0 +1,74 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	a := &DataRef{PayloadSize: 100, Created: 3, LastRead: 10}
+	b := &DataRef{PayloadSize: 300, Created: 1, LastRead: 30}
+	c := &DataRef{PayloadSize: 200, Created: 2, LastRead: 20}
+
+	testCases := []struct {
+		name   string
+		policy string
+		order  []*DataRef
+	}{
+		{"lfu keeps the frequency order", "lfu", []*DataRef{a, b, c}},
+		{"lru", "lru", []*DataRef{a, c, b}},
+		{"largest", "largest", []*DataRef{b, c, a}},
+		{"ttl", "ttl", []*DataRef{b, c, a}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := ParseEvictionPolicy(tc.policy)
+			require.NoError(t, err)
+			refs := []*DataRef{a, b, c}
+			policy(refs)
+			require.Equal(t, tc.order, refs)
+		})
+	}
+
+	_, err := ParseEvictionPolicy("random")
+	require.ErrorIs(t, err, ErrUnknownEvictionPolicy)
+}
+
+func TestIndexEvictLargestFirst(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs, WithBounds(512000, 500000), WithEvictionPolicy(EvictLargestFirst))
+	require.NoError(t, err)
+
+	small := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 100000,
+	}
+	require.NoError(t, idx.SetRef(small))
+
+	large := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 300000,
+	}
+	require.NoError(t, idx.SetRef(large))
+
+	// reading the large ref would keep it with the LFU policy
+	_, err = idx.GetRef(large.PayloadCID)
+	require.NoError(t, err)
+
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 200000,
+	}))
+
+	_, err = idx.PeekRef(large.PayloadCID)
+	require.ErrorIs(t, err, ErrRefNotFound)
+	_, err = idx.PeekRef(small.PayloadCID)
+	require.NoError(t, err)
+}
@@ -2,14 +2,19 @@ package exchange
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
 
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
@@ -18,9 +23,11 @@ import (
 	"github.com/myelnet/pop/payments"
 	"github.com/myelnet/pop/retrieval"
 	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
 	"github.com/myelnet/pop/selectors"
 	sel "github.com/myelnet/pop/selectors"
 	"github.com/myelnet/pop/wallet"
+	"github.com/rs/zerolog/log"
 )
 
 // Exchange is a financially incentivized IPLD  block exchange
@@ -36,11 +43,25 @@ type Exchange struct {
 	// retrieval handles all metered data transfers
 	rtv retrieval.Manager
 	// Routing service
-	rou *GossipRouting
+	rou Routing
 	// Replication scheme
 	rpl *Replication
 	// Index keeps track of all content stored under this exchange
 	idx *Index
+	// Reputation scores peers based on past transfers
+	rep *Reputation
+	// offers caches recent offers for content we retrieved
+	offers *OfferCache
+	// cache fetches popular content we relay queries for if the CachePolicy has a budget
+	cache *OpportunisticCache
+	// hits and misses count the queries for which we had the content or not.
+	// Only accessed atomically.
+	hits   uint64
+	misses uint64
+
+	smu sync.Mutex
+	// txStores are the stores used by transactions in progress
+	txStores map[multistore.StoreID]struct{}
 }
 
 // New creates a long running exchange process from a libp2p host, an IPFS datastore and some optional
@@ -55,6 +76,8 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 		opts.Blockstore,
 		// leave a 20% lower bound so we don't evict too frequently
 		WithBounds(opts.Capacity, opts.Capacity-uint64(math.Round(float64(opts.Capacity)*0.2))),
+		WithEvictLabels(opts.EvictLabels),
+		WithEvictionPolicy(opts.EvictionPolicy),
 	)
 	if err != nil {
 		return nil, err
@@ -62,18 +85,34 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 
 	// register a pubsub topic for each region
 	exch := &Exchange{
-		h:    h,
-		ds:   ds,
-		opts: opts,
-		idx:  idx,
-		rou:  NewGossipRouting(h, opts.PubSub, opts.GossipTracer, opts.Regions),
-		pay:  payments.New(ctx, opts.FilecoinAPI, opts.Wallet, ds, opts.Blockstore),
+		h:      h,
+		ds:     ds,
+		opts:   opts,
+		idx:    idx,
+		pay:    payments.New(ctx, opts.FilecoinAPI, opts.Wallet, ds, opts.Blockstore, payments.WithCheckpoints(opts.CheckpointInterval, opts.CheckpointAmount), payments.WithGasPolicy(opts.GasPolicy)),
+		rep:    NewReputation(),
+		offers: NewOfferCache(opts.OfferTTL),
+
+		txStores: make(map[multistore.StoreID]struct{}),
+	}
+
+	exch.rou, err = newRouting(h, idx, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	exch.rpl, err = NewReplication(h, idx, opts.DataTransfer, exch, opts)
 	if err != nil {
 		return nil, err
 	}
+	// incentives offered with dispatches are paid and redeemed with our payment channels
+	exch.rpl.pay = exch.pay
+	exch.rpl.rep = exch.rep
+
+	exch.cache = NewOpportunisticCache(ctx, idx, opts.CachePolicy, exch.FindAndRetrieve, opts.Supervisor)
+	if gr, ok := exch.rou.(*GossipRouting); ok && exch.cache != nil {
+		gr.SetRelayObserver(exch.cache.ObserveOffer)
+	}
 
 	if opts.Wallet.DefaultAddress() == address.Undef {
 		_, err = opts.Wallet.NewKey(ctx, wallet.KTSecp256k1)
@@ -93,6 +132,8 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 	if err != nil {
 		return nil, err
 	}
+	exch.rtv.Provider().SetQuota(opts.Quota)
+	exch.rtv.Provider().SubscribeToEvents(exch.countRetrieval)
 	if err := exch.rpl.Start(ctx); err != nil {
 		return nil, err
 	}
@@ -103,6 +144,11 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 }
 
 func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.Query) (deal.Offer, error) {
+	e.rpl.Summary().ObserveQuery(r.Name, q.PayloadCID)
+	// Let other providers pick up the query if we cannot take another transfer
+	if e.busy() {
+		return deal.Offer{}, ErrTooManyTransfers
+	}
 	// This is used to increment LFU cache if the node is available
 	// the Stat method actually checks if the content is available.
 	_, _ = e.idx.GetRef(q.PayloadCID)
@@ -118,8 +164,17 @@ func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.
 	// We don't have the block we don't even reply to avoid taking bandwidth
 	// On the client side we assume no response means they don't have it
 	if err != nil || stats.Size == 0 {
+		atomic.AddUint64(&e.misses, 1)
+		e.cache.ObserveQuery(q.PayloadCID)
+		// If we know a peer who has it we point the client in the right direction
+		if offer, ok := e.redirect(p, q.PayloadCID); ok {
+			return offer, nil
+		}
 		return deal.Offer{}, fmt.Errorf("%s content unavailable: %w", e.h.ID(), err)
 	}
+	atomic.AddUint64(&e.hits, 1)
+	// Content may be in our blockstore without being indexed in which case we don't count the query
+	_ = e.idx.CountQuery(q.PayloadCID)
 	ask := deal.Offer{
 		PayloadCID:                 q.PayloadCID,
 		Size:                       uint64(stats.Size),
@@ -127,6 +182,10 @@ func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.
 		MinPricePerByte:            r.PPB, // TODO: dynamic pricing
 		MaxPaymentInterval:         deal.DefaultPaymentInterval,
 		MaxPaymentIntervalIncrease: deal.DefaultPaymentIntervalIncrease,
+		// Hints help clients pick between providers beyond the price
+		Throughput: e.rtv.Provider().Usage().Throughput(),
+		Load:       e.rtv.Provider().Load(),
+		FreeTier:   r.PPB.IsZero(),
 	}
 	// We need to remember the offer we made so we can validate against it once
 	// clients start the retrieval
@@ -134,14 +193,32 @@ func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.
 	return ask, nil
 }
 
+// countRetrieval records the retrievals we complete in the demand counters of the index
+func (e *Exchange) countRetrieval(event provider.Event, state deal.ProviderState) {
+	if event != provider.EventCleanupComplete {
+		return
+	}
+	if err := e.idx.CountRetrieval(state.PayloadCID); err != nil && !errors.Is(err, ErrRefNotFound) {
+		log.Error().Err(err).Msg("failed to count retrieval")
+	}
+}
+
+// Crashes returns the number of panics recovered in each of our background routines
+func (e *Exchange) Crashes() map[string]int {
+	return e.opts.Supervisor.Crashes()
+}
+
 // Tx returns a new transaction. The caller must also call tx.Close to cleanup and perist the new blocks
 // retrieved or created by the transaction.
 func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 	// This cancel allows us to shutdown the retrieval process with the session if needed
 	ctx, cancel := context.WithCancel(ctx)
 	ms := e.opts.MultiStore
+	e.smu.Lock()
 	storeID := ms.Next()
 	store, err := ms.Get(storeID)
+	e.txStores[storeID] = struct{}{}
+	e.smu.Unlock()
 	tx := &Tx{
 		ctx:        ctx,
 		cancelCtx:  cancel,
@@ -151,6 +228,8 @@ func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 		retriever:  e.rtv.Client(),
 		index:      e.idx,
 		repl:       e.rpl,
+		rep:        e.rep,
+		offers:     e.offers,
 		cacheRF:    6,
 		clientAddr: e.opts.Wallet.DefaultAddress(),
 		sel:        selectors.All(),
@@ -163,6 +242,14 @@ func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 		storeID: storeID,
 		store:   store,
 		Err:     err,
+
+		stallTimeout: e.opts.StallTimeout,
+		h:            e.h,
+		release: func() {
+			e.smu.Lock()
+			delete(e.txStores, storeID)
+			e.smu.Unlock()
+		},
 	}
 	for _, opt := range opts {
 		opt(tx)
@@ -170,6 +257,46 @@ func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 	return tx
 }
 
+// ReapStores deletes the multistore stores which are not used by a transaction or a transfer in progress.
+// Stores can be left behind by transfers which failed or were interrupted by a restart since the index
+// only keeps track of completed content. Any store listed in keep is not deleted.
+func (e *Exchange) ReapStores(keep []multistore.StoreID) ([]multistore.StoreID, error) {
+	ms := e.opts.MultiStore
+	// List before collecting the stores in use so any store created in between is not reaped
+	all := ms.List()
+
+	inUse := make(map[multistore.StoreID]struct{})
+	for _, id := range keep {
+		inUse[id] = struct{}{}
+	}
+	for _, id := range e.rpl.StoreIDs() {
+		inUse[id] = struct{}{}
+	}
+	e.smu.Lock()
+	for id := range e.txStores {
+		inUse[id] = struct{}{}
+	}
+	e.smu.Unlock()
+
+	var reaped []multistore.StoreID
+	for _, id := range all {
+		if _, ok := inUse[id]; ok {
+			continue
+		}
+		if err := ms.Delete(id); err != nil {
+			return reaped, err
+		}
+		reaped = append(reaped, id)
+	}
+	return reaped, nil
+}
+
+// VerifyTransfer checks the content selected for a root and retrieved in a transaction is complete if
+// verification is enabled
+func (e *Exchange) VerifyTransfer(ctx context.Context, root cid.Cid, s ipld.Node, bs blockstore.Blockstore, size uint64) error {
+	return e.rpl.verifyTransfer(ctx, "", root, s, bs, size)
+}
+
 // FindAndRetrieve starts a new transaction for fetching an entire dag on the market.
 // It handles everything from content routing to offer selection and blocks until done.
 // It is used in the replication protocol for retrieving new content to serve.
@@ -187,6 +314,10 @@ func (e *Exchange) FindAndRetrieve(ctx context.Context, root cid.Cid) error {
 			return res.Err
 		}
 
+		if err := e.rpl.verifyTransfer(ctx, "", root, sel.All(), tx.Store().Bstore, res.Size); err != nil {
+			return err
+		}
+
 		keys, err := utils.MapLoadableKeys(ctx, root, tx.Store().Loader)
 		if err != nil {
 			return err
@@ -234,11 +365,26 @@ func (e *Exchange) R() *Replication {
 	return e.rpl
 }
 
+// Offers returns the cache of recent offers for the content we retrieved
+func (e *Exchange) Offers() *OfferCache {
+	return e.offers
+}
+
+// Reputation returns the reputation of the peers we retrieved from
+func (e *Exchange) Reputation() *Reputation {
+	return e.rep
+}
+
 // Index returns the exchange data index
 func (e *Exchange) Index() *Index {
 	return e.idx
 }
 
+// QueryStats returns the number of queries we received for content we had and didn't have since the exchange started
+func (e *Exchange) QueryStats() (hits, misses uint64) {
+	return atomic.LoadUint64(&e.hits), atomic.LoadUint64(&e.misses)
+}
+
 // Payments returns the payment manager
 func (e *Exchange) Payments() payments.Manager {
 	return e.pay
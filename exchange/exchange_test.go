@@ -148,6 +148,46 @@ func TestSelectionStrategies(t *testing.T) {
 	}
 }
 
+func TestSelectFirstFallbackOrder(t *testing.T) {
+	exec := testExecutor{
+		done: make(chan deal.Offer, 1),
+		conf: make(chan deal.Offer, 4),
+		err:  make(chan error, 1),
+	}
+	wq := SelectFirst(exec)
+	wq.Start()
+
+	offer := func(price int64) deal.Offer {
+		return deal.Offer{MinPricePerByte: abi.NewTokenAmount(price)}
+	}
+	// the first offer executes right away and the others are queued
+	wq.PushBack(offer(9))
+	wq.PushBack(offer(5))
+	wq.PushBack(offer(1))
+	wq.PushFront(offer(7))
+
+	exec.SetError(errors.New("failing"))
+	exec.SetError(errors.New("failing"))
+	exec.SetError(nil)
+
+	select {
+	case of := <-exec.done:
+		require.Equal(t, abi.NewTokenAmount(5), of.MinPricePerByte)
+	case <-time.After(3 * time.Second):
+		t.Fatal("offer never executed")
+	}
+
+	// offers are executed in the order they were received after the one pushed to the front
+	// instead of being sorted by price
+	var prices []abi.TokenAmount
+	for i := 0; i < 3; i++ {
+		prices = append(prices, (<-exec.conf).MinPricePerByte)
+	}
+	require.Equal(t, []abi.TokenAmount{abi.NewTokenAmount(9), abi.NewTokenAmount(7), abi.NewTokenAmount(5)}, prices)
+
+	_ = wq.Close()
+}
+
 // Stress test strategies to make sure they scale well to handle hundreds of offers
 func BenchmarkStrategies(b *testing.B) {
 	testCases := []struct {
@@ -260,7 +300,7 @@ func TestExchangeE2E(t *testing.T) {
 			require.NoError(t, err)
 
 			var records []PRecord
-			for rec := range res {
+			for rec := range res.Out() {
 				records = append(records, rec)
 			}
 			require.Equal(t, 6, len(records))
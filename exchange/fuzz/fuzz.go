@@ -0,0 +1,33 @@
+// Package fuzz provides go-fuzz entry points for the messages we decode from other peers.
+// Build with go-fuzz-build -func FuzzHey github.com/myelnet/pop/exchange/fuzz
+package fuzz
+
+import (
+	"bytes"
+
+	"github.com/myelnet/pop/exchange"
+)
+
+// FuzzHey decodes random bytes as a Hey message
+func FuzzHey(data []byte) int {
+	if _, err := exchange.DecodeHey(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzRequest decodes random bytes as a Request message
+func FuzzRequest(data []byte) int {
+	if _, err := exchange.DecodeRequest(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzQuery decodes random bytes as a Query message
+func FuzzQuery(data []byte) int {
+	if _, err := exchange.DecodeQuery(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,93 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"time"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate cbor-gen-for Goodbye
+
+// GoodbyeProtocol lets a peer tell the others it is leaving so they can replace the content it held
+const GoodbyeProtocol = "/myel/pop/goodbye/1.0"
+
+// GoodbyeTimeout bounds how long we try to say goodbye to our peers when leaving
+const GoodbyeTimeout = 5 * time.Second
+
+// Goodbye is sent to the peers in our regions before we shut down
+type Goodbye struct {
+	Reason string
+}
+
+// GoodbyeEvt is emitted when a peer tells us it is leaving and accessible via the libp2p event bus subscription
+type GoodbyeEvt struct {
+	Peer   peer.ID
+	Reason string
+}
+
+// handleGoodbye forgets a peer who is leaving and lets the replication scheme know about it
+func (pm *PeerMgr) handleGoodbye(s network.Stream) {
+	defer pm.sup.Recover("goodbye-handler")
+	defer s.Close()
+	p := s.Conn().RemotePeer()
+
+	var msg Goodbye
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxHeySize, &msg); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("invalid goodbye")
+		pm.strikes.Record(p, err)
+		return
+	}
+	pm.mu.Lock()
+	_, ok := pm.peers[p]
+	delete(pm.peers, p)
+	pm.mu.Unlock()
+	pm.forgetRecord(p)
+	// We only care about the peers in our regions
+	if !ok {
+		return
+	}
+	log.Info().Str("peer", p.String()).Str("reason", msg.Reason).Msg("peer leaving")
+	if err := pm.byeEmitter.Emit(GoodbyeEvt{Peer: p, Reason: msg.Reason}); err != nil {
+		log.Error().Err(err).Msg("failed to emit event")
+	}
+}
+
+// SayGoodbye tells all the peers in our regions we are leaving. It returns once every peer was notified
+// or after GoodbyeTimeout.
+func (pm *PeerMgr) SayGoodbye(ctx context.Context, reason string) {
+	pm.mu.Lock()
+	var peers []peer.ID
+	for p, info := range pm.peers {
+		if info.Supports(GoodbyeProtocol) {
+			peers = append(peers, p)
+		}
+	}
+	pm.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, GoodbyeTimeout)
+	defer cancel()
+	msg := Goodbye{Reason: reason}
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			s, err := pm.h.NewStream(ctx, p, GoodbyeProtocol)
+			if err != nil {
+				log.Debug().Err(err).Str("peer", p.String()).Msg("failed to say goodbye")
+				return
+			}
+			defer s.Close()
+			if err := cborutil.WriteCborRPC(s, &msg); err != nil {
+				log.Debug().Err(err).Str("peer", p.String()).Msg("failed to say goodbye")
+			}
+		}(p)
+	}
+	wg.Wait()
+}
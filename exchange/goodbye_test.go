@@ -0,0 +1,68 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-eventbus"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoodbye(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+
+	p1 := NewPeerMgr(n1.Host, idx, []Region{global})
+	p2 := NewPeerMgr(n2.Host, idx, []Region{global})
+	p3 := NewPeerMgr(n3.Host, idx, []Region{global})
+	require.NoError(t, p2.Run(ctx))
+	require.NoError(t, p3.Run(ctx))
+
+	sub2, err := n2.Host.EventBus().Subscribe(new(GoodbyeEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+	sub3, err := n3.Host.EventBus().Subscribe(new(GoodbyeEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+
+	// n2 and n3 know about n1 but only n2 speaks the goodbye protocol
+	hey := Hey{Regions: []RegionCode{GlobalRegion}, Protocols: SupportedProtocols}
+	p1.handleHey(n2.Host.ID(), hey)
+	p1.handleHey(n3.Host.ID(), Hey{Regions: []RegionCode{GlobalRegion}, Protocols: []string{string(HeyProtocol)}})
+	p2.handleHey(n1.Host.ID(), hey)
+	p3.handleHey(n1.Host.ID(), hey)
+
+	p1.SayGoodbye(ctx, "shutting down")
+
+	select {
+	case evt := <-sub2.Out():
+		bye := evt.(GoodbyeEvt)
+		require.Equal(t, n1.Host.ID(), bye.Peer)
+		require.Equal(t, "shutting down", bye.Reason)
+	case <-ctx.Done():
+		t.Fatal("goodbye not received")
+	}
+	// n2 forgot about n1
+	require.Eventually(t, func() bool {
+		_, ok := p2.Peer(n1.Host.ID())
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case <-sub3.Out():
+		t.Fatal("n3 doesn't speak the goodbye protocol")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, ok := p3.Peer(n1.Host.ID())
+	require.True(t, ok)
+}
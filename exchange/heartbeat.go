@@ -0,0 +1,162 @@
+package exchange
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// HeartbeatProtocol lets us check the peers in our regions are still responsive without sending them
+// a full Hey which would trigger an index fetch each time
+const HeartbeatProtocol = "/myel/pop/heartbeat/1.0"
+
+// HeartbeatInterval is the interval at which we ping the peers in our regions
+const HeartbeatInterval = 30 * time.Second
+
+// HeartbeatTimeout is how long we wait for a peer to answer a heartbeat
+const HeartbeatTimeout = 10 * time.Second
+
+// MaxMissedHeartbeats is the number of heartbeats a peer can miss in a row before it is marked inactive
+const MaxMissedHeartbeats = 3
+
+// heartbeatSize is the size of the seed sent back and forth to measure the roundtrip time
+const heartbeatSize = 32
+
+// PeerStatusEvt is emitted when a peer stops answering our heartbeats or becomes responsive again
+// and accessible via the libp2p event bus subscription
+type PeerStatusEvt struct {
+	Peer   peer.ID
+	Active bool
+}
+
+// handleHeartbeat sends the seed back so the peer can measure the roundtrip time
+func (pm *PeerMgr) handleHeartbeat(s network.Stream) {
+	defer pm.sup.Recover("heartbeat-handler")
+	defer s.Close()
+
+	s.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	buf := make([]byte, heartbeatSize)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		log.Debug().Err(err).Msg("failed to read heartbeat")
+		return
+	}
+	if _, err := s.Write(buf); err != nil {
+		log.Debug().Err(err).Msg("failed to write heartbeat")
+		return
+	}
+	// A peer pinging us is alive too
+	pm.recordHeartbeat(s.Conn().RemotePeer(), 0, nil)
+}
+
+// heartbeats regularly pings the peers in our regions to detect the ones which stopped responding
+// without disconnecting
+func (pm *PeerMgr) heartbeats(ctx context.Context) {
+	ticker := time.NewTicker(pm.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pm.pingAll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pingAll sends a heartbeat to all the peers in our regions supporting the protocol and returns once
+// they all answered or timed out
+func (pm *PeerMgr) pingAll(ctx context.Context) {
+	pm.mu.Lock()
+	var peers []peer.ID
+	for p, info := range pm.peers {
+		if info.Supports(HeartbeatProtocol) {
+			peers = append(peers, p)
+		}
+	}
+	pm.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			defer pm.sup.Recover("heartbeat")
+			rtt, err := pm.ping(ctx, p)
+			if err != nil {
+				log.Debug().Err(err).Str("peer", p.String()).Msg("missed heartbeat")
+			}
+			pm.recordHeartbeat(p, rtt, err)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// ping sends a heartbeat to a peer and returns the roundtrip time
+func (pm *PeerMgr) ping(ctx context.Context, p peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, HeartbeatTimeout)
+	defer cancel()
+	s, err := pm.h.NewStream(ctx, p, HeartbeatProtocol)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	s.SetDeadline(time.Now().Add(HeartbeatTimeout))
+	buf := make([]byte, heartbeatSize)
+	start := time.Now()
+	if _, err := s.Write(buf); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(s, buf); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// recordHeartbeat updates the status of a peer after a heartbeat. A peer missing MaxMissedHeartbeats in a row
+// is marked inactive until it answers again. A zero roundtrip time leaves the latency unchanged.
+func (pm *PeerMgr) recordHeartbeat(p peer.ID, rtt time.Duration, err error) {
+	pm.mu.Lock()
+	info, ok := pm.peers[p]
+	if !ok {
+		pm.mu.Unlock()
+		return
+	}
+	wasInactive := info.Inactive
+	if err != nil {
+		info.Missed++
+		if info.Missed >= MaxMissedHeartbeats {
+			info.Inactive = true
+		}
+	} else {
+		info.Missed = 0
+		info.Inactive = false
+		info.LastSeen = time.Now()
+		if rtt > 0 {
+			info.Latency = rtt
+		}
+	}
+	pm.peers[p] = info
+	pm.mu.Unlock()
+	pm.saveRecord(p, info)
+
+	if info.Inactive != wasInactive {
+		pm.emitStatus(p, !info.Inactive)
+	}
+}
+
+func (pm *PeerMgr) emitStatus(p peer.ID, active bool) {
+	if active {
+		log.Info().Str("peer", p.String()).Msg("peer active again")
+	} else {
+		log.Info().Str("peer", p.String()).Msg("peer inactive")
+	}
+	if err := pm.statusEmitter.Emit(PeerStatusEvt{Peer: p, Active: active}); err != nil {
+		log.Error().Err(err).Msg("failed to emit event")
+	}
+}
@@ -0,0 +1,336 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate cbor-gen-for Incentive IncentiveClaim IncentivePayment
+
+// IncentiveProtocolID is the protocol for claiming the payment offered with a dispatch once the content
+// was held for the required duration
+const IncentiveProtocolID = protocol.ID("/myel/pop/incentive/1.0")
+
+// IncentiveClaimInterval is the interval at which we claim the incentives which are due
+const IncentiveClaimInterval = time.Minute
+
+// IncentiveTimeout is how long we spend on an incentive claim from a peer including auditing its content
+// and creating the payment
+const IncentiveTimeout = 2 * time.Minute
+
+// ErrIncentiveRejected is returned when an incentive claim cannot be paid
+var ErrIncentiveRejected = errors.New("incentive rejected")
+
+// Incentive is a payment offered along with a dispatch to the caches who accept the content and hold it
+// for a minimum duration. It gives caches a reason to accept content they would find too large otherwise.
+type Incentive struct {
+	Amount abi.TokenAmount
+	// Hold is the number of seconds the content must be held before the payment can be claimed
+	Hold uint64
+}
+
+// Validate checks an incentive is well formed
+func (inc Incentive) Validate() error {
+	if inc.Amount.Int == nil || inc.Amount.Sign() <= 0 {
+		return fmt.Errorf("%w: invalid incentive amount", ErrInvalidMsg)
+	}
+	return nil
+}
+
+// HoldDuration returns how long the content must be held before the payment can be claimed
+func (inc Incentive) HoldDuration() time.Duration {
+	return time.Duration(inc.Hold) * time.Second
+}
+
+// IncentiveClaim is sent by a cache to the peer who dispatched some content to get paid
+type IncentiveClaim struct {
+	PayloadCID cid.Cid
+	// Recipient is the address the payment channel is created for
+	Recipient address.Address
+}
+
+// IncentivePayment is the voucher paying for an incentive claim
+type IncentivePayment struct {
+	Channel address.Address
+	Voucher *paych.SignedVoucher
+}
+
+// pendingIncentive is an incentive offered to or by a peer for holding some content. It is persisted
+// so it can be claimed after a restart.
+type pendingIncentive struct {
+	Peer       peer.ID
+	PayloadCID cid.Cid
+	Incentive  Incentive
+	// Payer is the address paying an incentive we offered, undefined if we use our default address
+	Payer address.Address
+	// Since is when the content was transferred
+	Since time.Time
+}
+
+// due returns whether the content was held long enough to claim the payment
+func (pi pendingIncentive) due(now time.Time) bool {
+	return !now.Before(pi.Since.Add(pi.Incentive.HoldDuration()))
+}
+
+// incentiveStore persists pending incentives by content and peer
+type incentiveStore struct {
+	ds datastore.Batching
+}
+
+func newIncentiveStore(ds datastore.Batching, prefix string) *incentiveStore {
+	return &incentiveStore{
+		ds: namespace.Wrap(ds, datastore.NewKey(prefix)),
+	}
+}
+
+func incentiveKey(root cid.Cid, p peer.ID) datastore.Key {
+	return datastore.NewKey(root.String()).ChildString(p.String())
+}
+
+func (is *incentiveStore) put(pi pendingIncentive) error {
+	b, err := json.Marshal(pi)
+	if err != nil {
+		return err
+	}
+	return is.ds.Put(incentiveKey(pi.PayloadCID, pi.Peer), b)
+}
+
+func (is *incentiveStore) get(root cid.Cid, p peer.ID) (pendingIncentive, error) {
+	var pi pendingIncentive
+	b, err := is.ds.Get(incentiveKey(root, p))
+	if err != nil {
+		return pi, err
+	}
+	err = json.Unmarshal(b, &pi)
+	return pi, err
+}
+
+func (is *incentiveStore) remove(root cid.Cid, p peer.ID) error {
+	return is.ds.Delete(incentiveKey(root, p))
+}
+
+func (is *incentiveStore) list() ([]pendingIncentive, error) {
+	res, err := is.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var incentives []pendingIncentive
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var pi pendingIncentive
+		if err := json.Unmarshal(r.Value, &pi); err != nil {
+			log.Error().Err(err).Str("key", r.Key).Msg("invalid pending incentive")
+			continue
+		}
+		incentives = append(incentives, pi)
+	}
+	return incentives, nil
+}
+
+// payable returns whether we can send or receive incentive payments
+func (r *Replication) payable() bool {
+	return r.pay != nil && r.wallet != nil
+}
+
+// handleIncentive pays a peer who held the content we dispatched for the duration of the incentive we offered.
+// The peer must still prove it holds the content. The stream is closed without payment otherwise.
+func (r *Replication) handleIncentive(s network.Stream) {
+	defer r.sup.Recover("incentive-handler")
+	defer s.Close()
+	p := s.Conn().RemotePeer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), IncentiveTimeout)
+	defer cancel()
+	s.SetDeadline(time.Now().Add(IncentiveTimeout))
+
+	var claim IncentiveClaim
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &claim); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("invalid incentive claim")
+		r.strikes.Record(p, err)
+		return
+	}
+	pi, pay, err := r.payIncentive(ctx, p, claim)
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Str("root", claim.PayloadCID.String()).Msg("incentive not paid")
+		return
+	}
+	if err := cborutil.WriteCborRPC(s, &pay); err != nil {
+		log.Error().Err(err).Msg("failed to write incentive payment")
+		// The peer never got paid so it can claim the incentive again
+		r.restoreClaim(pi)
+		if err := r.pay.RevokeVoucher(ctx, pay.Channel, pay.Voucher); err != nil {
+			log.Error().Err(err).Str("channel", pay.Channel.String()).Msg("failed to revoke undelivered voucher")
+		}
+		return
+	}
+	r.countIncentive(p, true)
+}
+
+// payIncentive checks a claim and creates a voucher for the incentive we offered
+func (r *Replication) payIncentive(ctx context.Context, p peer.ID, claim IncentiveClaim) (pendingIncentive, IncentivePayment, error) {
+	pi, err := r.acceptClaim(ctx, p, claim)
+	if err != nil {
+		return pi, IncentivePayment{}, err
+	}
+	from := pi.Payer
+	if from == address.Undef {
+		from = r.wallet.DefaultAddress()
+	}
+	pay, err := r.createVoucher(ctx, from, claim.Recipient, pi.Incentive.Amount)
+	if err != nil {
+		r.restoreClaim(pi)
+		return pi, IncentivePayment{}, err
+	}
+	return pi, pay, nil
+}
+
+// restoreClaim offers an incentive again when we failed to pay or tally a claim we accepted
+func (r *Replication) restoreClaim(pi pendingIncentive) {
+	if err := r.offered.put(pi); err != nil {
+		log.Error().Err(err).Str("peer", pi.Peer.String()).Str("root", pi.PayloadCID.String()).Msg("failed to restore incentive offer")
+	}
+}
+
+// acceptClaim checks a peer held the content long enough and can still prove it then forgets the offer
+// so it cannot be claimed twice at the same time. The offer must be restored if the claim isn't paid.
+func (r *Replication) acceptClaim(ctx context.Context, p peer.ID, claim IncentiveClaim) (pendingIncentive, error) {
+	if !r.payable() {
+		return pendingIncentive{}, fmt.Errorf("%w: payments not available", ErrIncentiveRejected)
+	}
+	pi, err := r.offered.get(claim.PayloadCID, p)
+	if err != nil {
+		return pendingIncentive{}, fmt.Errorf("%w: no incentive offered", ErrIncentiveRejected)
+	}
+	if !pi.due(time.Now()) {
+		return pendingIncentive{}, fmt.Errorf("%w: content not held long enough", ErrIncentiveRejected)
+	}
+	if _, err := r.Audit(ctx, p, claim.PayloadCID); err != nil {
+		return pendingIncentive{}, fmt.Errorf("%w: %v", ErrIncentiveRejected, err)
+	}
+	if err := r.offered.remove(claim.PayloadCID, p); err != nil {
+		return pendingIncentive{}, err
+	}
+	return pi, nil
+}
+
+// createVoucher creates a voucher paying amt to a recipient on our channel from the given address
+func (r *Replication) createVoucher(ctx context.Context, from, to address.Address, amt abi.TokenAmount) (IncentivePayment, error) {
+	res, err := r.pay.GetChannel(ctx, from, to, amt)
+	if err != nil {
+		return IncentivePayment{}, err
+	}
+	defer r.pay.ReleaseFunds(ctx, from, to, amt)
+	ch := res.Channel
+	if res.WaitSentinel != cid.Undef {
+		ch, err = r.pay.WaitForChannel(ctx, res.WaitSentinel)
+		if err != nil {
+			return IncentivePayment{}, err
+		}
+	}
+	// Payments to the same receiver share lanes so we don't accumulate a voucher per payment
+	lane, err := r.pay.AcquireLane(ctx, ch)
+	if err != nil {
+		return IncentivePayment{}, err
+	}
+	defer r.pay.ReleaseLane(ctx, ch, lane)
+	vres, err := r.pay.CreateVoucher(ctx, ch, amt, lane)
+	if err != nil {
+		return IncentivePayment{}, err
+	}
+	if vres.Voucher == nil {
+		return IncentivePayment{}, fmt.Errorf("not enough funds in channel: shortfall %s", vres.Shortfall)
+	}
+	return IncentivePayment{Channel: ch, Voucher: vres.Voucher}, nil
+}
+
+// claimIncentives regularly claims the payments for the content we held long enough
+func (r *Replication) claimIncentives(ctx context.Context) {
+	ticker := time.NewTicker(IncentiveClaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			claims, err := r.claims.list()
+			if err != nil {
+				log.Error().Err(err).Msg("error when listing incentive claims")
+				continue
+			}
+			now := time.Now()
+			for _, pi := range claims {
+				if !pi.due(now) || r.h.Network().Connectedness(pi.Peer) != network.Connected {
+					continue
+				}
+				if err := r.claimIncentive(ctx, pi); err != nil {
+					log.Error().Err(err).Str("peer", pi.Peer.String()).Str("root", pi.PayloadCID.String()).Msg("failed to claim incentive")
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// claimIncentive requests the payment for an incentive from the peer who offered it then redeems the voucher
+func (r *Replication) claimIncentive(ctx context.Context, pi pendingIncentive) error {
+	// The content was evicted so we are not entitled to the payment anymore
+	if _, err := r.idx.PeekRef(pi.PayloadCID); err != nil {
+		return r.claims.remove(pi.PayloadCID, pi.Peer)
+	}
+	// Frequent peers add the incentive to the tally of our mutual debts instead of paying it right away
+	if r.tallied(pi.Peer) {
+		return r.tallyIncentive(ctx, pi)
+	}
+	s, err := r.h.NewStream(ctx, pi.Peer, IncentiveProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	claim := IncentiveClaim{
+		PayloadCID: pi.PayloadCID,
+		Recipient:  r.wallet.DefaultAddress(),
+	}
+	if err := cborutil.WriteCborRPC(s, &claim); err != nil {
+		return err
+	}
+	var pay IncentivePayment
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &pay); err != nil {
+		return err
+	}
+	if pay.Voucher == nil {
+		return fmt.Errorf("%w: no voucher", ErrIncentiveRejected)
+	}
+	// Verifies the voucher pays at least the amount we were offered
+	if _, err := r.pay.AddVoucherInbound(ctx, pay.Channel, pay.Voucher, nil, pi.Incentive.Amount); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncentiveRejected, err)
+	}
+	if err := r.claims.remove(pi.PayloadCID, pi.Peer); err != nil {
+		return err
+	}
+	r.countIncentive(pi.Peer, false)
+	// The voucher is redeemed with the others at the next checkpoint
+	if err := r.pay.Checkpoint(ctx, pay.Channel); err != nil {
+		return fmt.Errorf("failed to redeem voucher: %w", err)
+	}
+	log.Info().Str("peer", pi.Peer.String()).Str("root", pi.PayloadCID.String()).Msg("received incentive")
+	return nil
+}
@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncentiveStore(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	is := newIncentiveStore(ds, "/incentives/offered")
+
+	root := blockGen.Next().Cid()
+	since := time.Now().Add(-2 * time.Hour)
+	for _, p := range []*testutil.TestNode{n1, n2} {
+		require.NoError(t, is.put(pendingIncentive{
+			Peer:       p.Host.ID(),
+			PayloadCID: root,
+			Incentive:  Incentive{Amount: big.NewInt(1000), Hold: 3600},
+			Since:      since,
+		}))
+	}
+
+	// incentives survive a restart
+	list, err := newIncentiveStore(ds, "/incentives/offered").list()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	pi, err := is.get(root, n2.Host.ID())
+	require.NoError(t, err)
+	require.Equal(t, n2.Host.ID(), pi.Peer)
+	require.Equal(t, big.NewInt(1000), pi.Incentive.Amount)
+	require.True(t, pi.Since.Equal(since))
+
+	// the content was held for more than an hour
+	require.True(t, pi.due(time.Now()))
+	require.False(t, pi.due(since.Add(30*time.Minute)))
+
+	// other stores don't see the incentives
+	list, err = newIncentiveStore(ds, "/incentives/claims").list()
+	require.NoError(t, err)
+	require.Len(t, list, 0)
+
+	require.NoError(t, is.remove(root, n2.Host.ID()))
+	_, err = is.get(root, n2.Host.ID())
+	require.ErrorIs(t, err, datastore.ErrNotFound)
+	list, err = is.list()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
+
+func TestIncentiveClaimEncoding(t *testing.T) {
+	recipient, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	claim := IncentiveClaim{PayloadCID: blockGen.Next().Cid(), Recipient: recipient}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, claim.MarshalCBOR(buf))
+	var dec IncentiveClaim
+	require.NoError(t, decodeMsg(buf, MaxIncentiveSize, &dec))
+	require.Equal(t, claim, dec)
+
+	// a payment without voucher decodes so the claimer can reject it
+	pay := IncentivePayment{Channel: recipient}
+	buf = new(bytes.Buffer)
+	require.NoError(t, pay.MarshalCBOR(buf))
+	var decPay IncentivePayment
+	require.NoError(t, decodeMsg(buf, MaxIncentiveSize, &decPay))
+	require.Equal(t, pay, decPay)
+}
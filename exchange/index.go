@@ -4,17 +4,21 @@ import (
 	"bytes"
 	"container/list"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-hamt-ipld/v3"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	ipldformat "github.com/ipfs/go-ipld-format"
 	"github.com/myelnet/pop/internal/utils"
 	sel "github.com/myelnet/pop/selectors"
 	"github.com/rs/zerolog/log"
@@ -28,9 +32,21 @@ var ErrRefNotFound = errors.New("ref not found")
 
 var ErrRefAlreadyExists = errors.New("ref already exists")
 
+// ErrNameNotFound is returned when no ref in the index has a given name
+var ErrNameNotFound = errors.New("name not found")
+
+// ErrCorruptedBlock is returned when the data of a block doesn't match its CID
+var ErrCorruptedBlock = errors.New("corrupted block")
+
 // KIndex is the datastore key for persisting the index of a workdag
 const KIndex = "idx"
 
+// KBlockRefs is the datastore namespace for persisting how many refs link to each block
+const KBlockRefs = "blockrefs"
+
+// KRefLinks is the datastore namespace for persisting the blocks counted for each ref
+const KRefLinks = "reflinks"
+
 // Index contains the information about which objects are currently stored
 // the key is a CID.String().
 // It also implements a Least Frequently Used cache eviction mechanism to maintain storage withing given
@@ -49,6 +65,9 @@ type Index struct {
 	// updateFunc, if not nil, is called after every read transactions. The hook can be used
 	// to trigger request for new content and refreshing the index with new popular content
 	updateFunc func()
+	// dropFunc, if not nil, is called with each ref removed from the index. It is called while holding
+	// the index lock so it must not call the index.
+	dropFunc func(*DataRef)
 
 	emu sync.Mutex
 	// gcSet is a cid Set where we put all the cid that will be evicted when calling the Garbage Collector GC()
@@ -59,6 +78,10 @@ type Index struct {
 	size uint64
 	// linked list keeps track of all refs in least to most popular order to access as fast as possible
 	blist *list.List
+	// only refs with these labels can be evicted if set
+	evictLabels map[string]string
+	// policy decides which refs are evicted first
+	policy EvictionPolicy
 	// We still need to keep a map in memory
 	Refs    map[string]*DataRef
 	rootCID cid.Cid
@@ -77,6 +100,25 @@ type DataRef struct {
 	Keys        [][]byte
 	Freq        int64
 	BucketID    int64
+	// Private refs are not listed on public endpoints
+	Private bool
+	// Labels are arbitrary key/value pairs users can filter refs by
+	Labels map[string]string
+	// Parent is the root of the previous version of this ref if any
+	Parent *cid.Cid
+	// Name is a human readable name like a git tag. The latest version of a name can be retrieved
+	// without knowing its CID.
+	Name string
+	// Created is the unix time at which the ref was added to the index
+	Created int64
+	// LastRead is the unix time of the last read of this ref
+	LastRead int64
+	// Queries is the number of queries we answered with an offer for this ref
+	Queries int64
+	// Retrievals is the number of retrievals of this ref we completed
+	Retrievals int64
+	// Pinned refs are never evicted to make room for new content nor garbage collected
+	Pinned bool
 	// do not serialize
 	bucketNode *list.Element
 }
@@ -90,6 +132,16 @@ func (d DataRef) Has(key string) bool {
 	return false
 }
 
+// HasLabels returns whether the ref has all the given labels
+func (d DataRef) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if d.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 // IndexOption customizes the behavior of the index
 type IndexOption func(*Index)
 
@@ -105,6 +157,20 @@ func WithBounds(up, lo uint64) IndexOption {
 	}
 }
 
+// WithEvictLabels restricts eviction to refs with all the given labels
+func WithEvictLabels(labels map[string]string) IndexOption {
+	return func(idx *Index) {
+		idx.evictLabels = labels
+	}
+}
+
+// WithEvictionPolicy sets the order in which refs are evicted when the index is over capacity
+func WithEvictionPolicy(policy EvictionPolicy) IndexOption {
+	return func(idx *Index) {
+		idx.policy = policy
+	}
+}
+
 // WithUpdateFunc sets an UpdateFunc callback and a read interval after which to call it
 func WithUpdateFunc(fn func()) IndexOption {
 	return func(idx *Index) {
@@ -112,6 +178,22 @@ func WithUpdateFunc(fn func()) IndexOption {
 	}
 }
 
+// WithDropFunc adds a callback called with each ref evicted or dropped from the index.
+// Callbacks are called in the order they were added.
+func WithDropFunc(fn func(*DataRef)) IndexOption {
+	return func(idx *Index) {
+		prev := idx.dropFunc
+		if prev == nil {
+			idx.dropFunc = fn
+			return
+		}
+		idx.dropFunc = func(ref *DataRef) {
+			prev(ref)
+			fn(ref)
+		}
+	}
+}
+
 // NewIndex creates a new Index instance, loading entries into a doubly linked list for faster read and writes
 func NewIndex(ds datastore.Batching, bstore blockstore.Blockstore, opts ...IndexOption) (*Index, error) {
 	idx := &Index{
@@ -172,6 +254,9 @@ func NewIndex(ds datastore.Batching, bstore blockstore.Blockstore, opts ...Index
 	if err != nil {
 		return nil, err
 	}
+	if err := idx.initBlockCounts(); err != nil {
+		return nil, err
+	}
 
 	return idx, nil
 }
@@ -195,7 +280,8 @@ func (idx *Index) loadFromStore() error {
 		}
 		idx.root, err = idx.LoadRoot(r, idx.store)
 		if err != nil {
-			return err
+			// Starting with an empty index would lose every ref and let the cleanup wipe the blockstore
+			return fmt.Errorf("failed to load index root %s: %w", r, err)
 		}
 		idx.rootCID = r
 	}
@@ -215,6 +301,13 @@ func (idx *Index) Root() cid.Cid {
 	return idx.rootCID
 }
 
+// Bounded returns whether the index storage capacity is limited
+func (idx *Index) Bounded() bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.ub > 0
+}
+
 // Available returns the storage capacity still available or 0 if full
 // a margin set by lower bound (lb) provides leeway for the eviction algorithm
 func (idx *Index) Available() uint64 {
@@ -227,6 +320,13 @@ func (idx *Index) Available() uint64 {
 	return idx.ub - idx.size
 }
 
+// Usage returns the amount of bytes currently stored and the capacity after which we start evicting content
+func (idx *Index) Usage() (uint64, uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.size, idx.ub
+}
+
 // Flush persists the Refs to the store, callers must take care of the mutex
 // context is not actually used downstream so we use a TODO()
 func (idx *Index) Flush() error {
@@ -260,6 +360,12 @@ func (idx *Index) DropRef(k cid.Cid) error {
 	idx.remBlistEntry(ref.bucketNode, ref)
 
 	delete(idx.Refs, k.String())
+	if idx.dropFunc != nil {
+		idx.dropFunc(ref)
+	}
+	if err := idx.unname(ref); err != nil {
+		return err
+	}
 	return idx.Flush()
 }
 
@@ -284,13 +390,89 @@ func (idx *Index) UpdateRef(ref *DataRef) error {
 		}
 	}
 
-	if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+	// the merged ref keeps the state of the current one such as its pin
+	if err := idx.root.Set(context.TODO(), k, curef); err != nil {
+		return err
+	}
+	// more blocks of the DAG may have been added with the new keys
+	if err := idx.refBlocks(curef); err != nil {
 		return err
 	}
 
 	return idx.Flush()
 }
 
+// OnDrop adds a callback called with each ref evicted or dropped from the index once it is running.
+// The callback is called while holding the index lock so it must not call the index.
+func (idx *Index) OnDrop(fn func(*DataRef)) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	WithDropFunc(fn)(idx)
+}
+
+// LabelRef sets labels on a ref. Labels with an empty value are removed.
+func (idx *Index) LabelRef(k cid.Cid, labels map[string]string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ref, ok := idx.Refs[k.String()]
+	if !ok {
+		return ErrRefNotFound
+	}
+	// Refs returned by the index may be read without holding the lock so we never mutate
+	// their labels in place, the updated labels are set as a new map.
+	updated := make(map[string]string, len(ref.Labels)+len(labels))
+	for lk, lv := range ref.Labels {
+		updated[lk] = lv
+	}
+	for lk, lv := range labels {
+		if lv == "" {
+			delete(updated, lk)
+			continue
+		}
+		updated[lk] = lv
+	}
+	ref.Labels = updated
+	if err := idx.root.Set(context.TODO(), k.String(), ref); err != nil {
+		return err
+	}
+	return idx.Flush()
+}
+
+// CountQuery records a query we answered with an offer for the given ref
+func (idx *Index) CountQuery(k cid.Cid) error {
+	return idx.modify(k, func(ref *DataRef) { ref.Queries++ })
+}
+
+// CountRetrieval records a retrieval of the given ref we completed
+func (idx *Index) CountRetrieval(k cid.Cid) error {
+	return idx.modify(k, func(ref *DataRef) { ref.Retrievals++ })
+}
+
+// Pin protects a ref from eviction so it stays in the index until it is unpinned or explicitly dropped
+func (idx *Index) Pin(k cid.Cid) error {
+	return idx.modify(k, func(ref *DataRef) { ref.Pinned = true })
+}
+
+// Unpin lets a ref be evicted again when the index is over capacity
+func (idx *Index) Unpin(k cid.Cid) error {
+	return idx.modify(k, func(ref *DataRef) { ref.Pinned = false })
+}
+
+// modify updates the fields of a ref and persists them
+func (idx *Index) modify(k cid.Cid, fn func(*DataRef)) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ref, ok := idx.Refs[k.String()]
+	if !ok {
+		return ErrRefNotFound
+	}
+	fn(ref)
+	if err := idx.root.Set(context.TODO(), k.String(), ref); err != nil {
+		return err
+	}
+	return idx.Flush()
+}
+
 // SetRef adds a ref in the index and increments the LFU queue
 func (idx *Index) SetRef(ref *DataRef) error {
 	idx.mu.Lock()
@@ -302,6 +484,10 @@ func (idx *Index) SetRef(ref *DataRef) error {
 		return ErrRefAlreadyExists
 	}
 
+	if ref.Created == 0 {
+		ref.Created = time.Now().Unix()
+	}
+	ref.LastRead = ref.Created
 	idx.Refs[k] = ref
 	idx.size += uint64(ref.PayloadSize)
 	if idx.ub > 0 && idx.lb > 0 {
@@ -314,9 +500,92 @@ func (idx *Index) SetRef(ref *DataRef) error {
 	if err := idx.root.Set(context.TODO(), k, ref); err != nil {
 		return err
 	}
+	if err := idx.refBlocks(ref); err != nil {
+		return err
+	}
+	if ref.Name != "" {
+		if err := idx.ds.Put(nameKey(ref.Name), ref.PayloadCID.Bytes()); err != nil {
+			return err
+		}
+	}
 	return idx.Flush()
 }
 
+func nameKey(name string) datastore.Key {
+	return datastore.NewKey("names").ChildString(name)
+}
+
+// unname moves the name of a ref back to its parent if the ref was the latest version. Must hold the lock.
+func (idx *Index) unname(ref *DataRef) error {
+	if ref.Name == "" {
+		return nil
+	}
+	latest, err := idx.latest(ref.Name)
+	if err != nil || !latest.Equals(ref.PayloadCID) {
+		return nil
+	}
+	if ref.Parent != nil {
+		if _, ok := idx.Refs[ref.Parent.String()]; ok {
+			return idx.ds.Put(nameKey(ref.Name), ref.Parent.Bytes())
+		}
+	}
+	return idx.ds.Delete(nameKey(ref.Name))
+}
+
+// latest returns the root of the latest version of a name. Must hold the lock.
+func (idx *Index) latest(name string) (cid.Cid, error) {
+	b, err := idx.ds.Get(nameKey(name))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return cid.Undef, fmt.Errorf("%s: %w", name, ErrNameNotFound)
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cast(b)
+}
+
+// Latest returns the latest version of the ref with the given name
+func (idx *Index) Latest(name string) (*DataRef, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	c, err := idx.latest(name)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := idx.Refs[c.String()]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNameNotFound)
+	}
+	return ref, nil
+}
+
+// Versions returns all the versions of a name we have in the index from the latest to the oldest.
+// The history stops at the first parent which is no longer in the index.
+func (idx *Index) Versions(name string) ([]*DataRef, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	c, err := idx.latest(name)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*DataRef
+	for {
+		ref, ok := idx.Refs[c.String()]
+		if !ok {
+			break
+		}
+		versions = append(versions, ref)
+		if ref.Parent == nil {
+			break
+		}
+		c = *ref.Parent
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, ErrNameNotFound)
+	}
+	return versions, nil
+}
+
 // GetRef gets a ref in the index for a given root CID and increments the LFU list registering a Read
 func (idx *Index) GetRef(k cid.Cid) (*DataRef, error) {
 	idx.mu.Lock()
@@ -326,6 +595,7 @@ func (idx *Index) GetRef(k cid.Cid) (*DataRef, error) {
 		return nil, ErrRefNotFound
 	}
 	idx.increment(ref)
+	ref.LastRead = time.Now().Unix()
 	// Update the freq
 	if err := idx.root.Set(context.TODO(), k.String(), ref); err != nil {
 		return nil, err
@@ -449,44 +719,243 @@ func (idx *Index) remFreqEntry(place *list.Element, entry *DataRef) {
 func (idx *Index) evict(size uint64) uint64 {
 	// No lock here so it can be called
 	// from within the lock (during Set)
-	var evicted uint64
+	var candidates []*DataRef
 	for place := idx.blist.Front(); place != nil; place = place.Next() {
 		for entry := range place.Value.(*bucket).entries {
-			err := idx.tagForGC(entry)
-			if err != nil {
-				log.Error().Err(err).Msgf("failed to tag ref %s for eviction", entry.PayloadCID.String())
+			if !entry.Pinned && entry.HasLabels(idx.evictLabels) {
+				candidates = append(candidates, entry)
 			}
+		}
+	}
+	if idx.policy != nil {
+		idx.policy(candidates)
+	}
 
-			delete(idx.Refs, entry.PayloadCID.String())
-			idx.remBlistEntry(place, entry)
-			evicted += uint64(entry.PayloadSize)
-			idx.size -= uint64(entry.PayloadSize)
-			if evicted >= size {
-				return evicted
-			}
+	var evicted uint64
+	for _, entry := range candidates {
+		err := idx.tagForGC(entry)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to tag ref %s for eviction", entry.PayloadCID.String())
+		}
+
+		delete(idx.Refs, entry.PayloadCID.String())
+		idx.remBlistEntry(entry.bucketNode, entry)
+		if idx.dropFunc != nil {
+			idx.dropFunc(entry)
+		}
+		if err := idx.unname(entry); err != nil {
+			log.Error().Err(err).Str("name", entry.Name).Msg("failed to update name after eviction")
+		}
+		evicted += uint64(entry.PayloadSize)
+		idx.size -= uint64(entry.PayloadSize)
+		if evicted >= size {
+			return evicted
 		}
 	}
 	return evicted
 }
 
-// tagForGC tags CIDs that will be evicted during garbage collection
+// tagForGC releases the blocks linked by a ref and tags the ones no other ref links to
+// so they are evicted during garbage collection
 func (idx *Index) tagForGC(ref *DataRef) error {
 	idx.emu.Lock()
 	defer idx.emu.Unlock()
 
-	return utils.WalkDAG(context.TODO(), ref.PayloadCID, idx.bstore, sel.All(), func(block blocks.Block) error {
-		idx.gcSet.Add(block.Cid())
+	return idx.releaseBlocks(ref.PayloadCID)
+}
+
+// TrackBlocks counts the blocks of a ref once its content was moved to the blockstore. Refs are usually
+// set before their transaction migrates the content from its own store so SetRef may not find them yet.
+func (idx *Index) TrackBlocks(root cid.Cid) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ref, ok := idx.Refs[root.String()]
+	if !ok {
 		return nil
+	}
+	return idx.refBlocks(ref)
+}
+
+// refBlocks counts the blocks linked by a ref added to the index
+func (idx *Index) refBlocks(ref *DataRef) error {
+	idx.emu.Lock()
+	defer idx.emu.Unlock()
+
+	return idx.countBlocks(ref.PayloadCID)
+}
+
+// blockCountKey is where we persist the number of refs linking to a block. Blocks are identified
+// by multihash as that is how the blockstore deduplicates them regardless of the codec.
+func blockCountKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey(KBlockRefs).ChildString(c.Hash().B58String())
+}
+
+// refLinksKey is the namespace listing the blocks counted for a given ref
+func refLinksKey(root cid.Cid) datastore.Key {
+	return datastore.NewKey(KRefLinks).ChildString(root.String())
+}
+
+// blockCount returns how many refs in the index link to a block. Must hold the emu lock.
+func (idx *Index) blockCount(c cid.Cid) (uint64, error) {
+	v, err := idx.ds.Get(blockCountKey(c))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, read := binary.Uvarint(v)
+	if read <= 0 {
+		return 0, fmt.Errorf("invalid reference count for block %s", c)
+	}
+	return n, nil
+}
+
+func (idx *Index) putBlockCount(b datastore.Batch, c cid.Cid, n uint64) error {
+	if n == 0 {
+		return b.Delete(blockCountKey(c))
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	return b.Put(blockCountKey(c), buf[:binary.PutUvarint(buf, n)])
+}
+
+// countBlocks increments the reference count of every block of a ref DAG we have in the blockstore.
+// Blocks already counted for this ref are skipped so it can be called again when more of the content
+// is added. Must hold the emu lock.
+func (idx *Index) countBlocks(root cid.Cid) error {
+	var links []cid.Cid
+	err := idx.walkLinks(root, func(c cid.Cid) {
+		links = append(links, c)
 	})
+	if err != nil {
+		return err
+	}
+	b, err := idx.ds.Batch()
+	if err != nil {
+		return err
+	}
+	prefix := refLinksKey(root)
+	for _, c := range links {
+		lk := prefix.ChildString(c.Hash().B58String())
+		if has, err := idx.ds.Has(lk); err != nil {
+			return err
+		} else if has {
+			continue
+		}
+		n, err := idx.blockCount(c)
+		if err != nil {
+			return err
+		}
+		if err := idx.putBlockCount(b, c, n+1); err != nil {
+			return err
+		}
+		if err := b.Put(lk, c.Bytes()); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
 }
 
-// GC removes tagged CIDs
-func (idx *Index) GC() error {
+// releaseBlocks decrements the reference count of the blocks counted for a ref and adds the blocks
+// no ref links to anymore to the GC set. Must hold the emu lock.
+func (idx *Index) releaseBlocks(root cid.Cid) error {
+	prefix := refLinksKey(root)
+	res, err := idx.ds.Query(query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	b, err := idx.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		c, err := cid.Cast(r.Value)
+		if err != nil {
+			return err
+		}
+		n, err := idx.blockCount(c)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			n--
+		}
+		if err := idx.putBlockCount(b, c, n); err != nil {
+			return err
+		}
+		if n == 0 {
+			idx.gcSet.Add(c)
+		}
+		if err := b.Delete(datastore.RawKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// initBlockCounts counts the blocks of all the refs in an index created before block reference counts
+// were persisted. It only runs once per datastore.
+func (idx *Index) initBlockCounts() error {
 	idx.emu.Lock()
 	defer idx.emu.Unlock()
 
+	if has, err := idx.ds.Has(datastore.NewKey(KBlockRefs)); err != nil || has {
+		return err
+	}
+	for _, ref := range idx.Refs {
+		if err := idx.countBlocks(ref.PayloadCID); err != nil {
+			return err
+		}
+	}
+	return idx.ds.Put(datastore.NewKey(KBlockRefs), []byte{1})
+}
+
+// walkLinks calls f for every block of a DAG available in the blockstore. Unlike WalkDAG it does not fail
+// when blocks are missing so it can be used on refs we only have part of the content for.
+func (idx *Index) walkLinks(root cid.Cid, f func(c cid.Cid)) error {
+	seen := make(map[string]struct{})
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[string(c.Hash())]; ok {
+			continue
+		}
+		seen[string(c.Hash())] = struct{}{}
+
+		blk, err := idx.bstore.Get(c)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f(c)
+		nd, err := ipldformat.Decode(blk)
+		if err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			queue = append(queue, l.Cid)
+		}
+	}
+	return nil
+}
+
+// GC removes tagged CIDs. Every transaction writes its content to the same blockstore which stores
+// each block once so different refs may share blocks. Tagged blocks are only removed if their
+// reference count is still zero, a ref linking to them may have been added since they were tagged.
+func (idx *Index) GC() error {
+	idx.emu.Lock()
+	empty := idx.gcSet.Len() == 0
+	idx.emu.Unlock()
 	// exit if there is nothing to evict
-	if idx.gcSet.Len() == 0 {
+	if empty {
 		return nil
 	}
 
@@ -496,15 +965,31 @@ func (idx *Index) GC() error {
 		return errors.New("blockstore is not a GCBlockstore")
 	}
 
+	// the GC lock is taken first as transactions hold it while migrating and counting their blocks
 	unlock := gcbs.GCLock()
 	defer unlock.Unlock()
 
+	idx.emu.Lock()
+	defer idx.emu.Unlock()
+
+	shared := 0
 	err := idx.gcSet.ForEach(func(c cid.Cid) error {
+		n, err := idx.blockCount(c)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			shared++
+			return nil
+		}
 		return idx.bstore.DeleteBlock(c)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to run garbage collector: %v", err)
 	}
+	if shared > 0 {
+		log.Debug().Int("shared", shared).Msg("kept blocks linked by other refs")
+	}
 
 	idx.gcSet = cid.NewSet()
 
@@ -521,12 +1006,128 @@ func (idx *Index) GC() error {
 	return nil
 }
 
+// IntegrityReport lists the inconsistencies found and repaired when checking the index against the blockstore
+type IntegrityReport struct {
+	// Checked is the number of refs we checked
+	Checked int
+	// Dropped are the roots of refs with missing or corrupted blocks which were removed from the index
+	Dropped []cid.Cid
+	// Pinned are the dropped refs which were pinned, their content must be added or retrieved again
+	Pinned []cid.Cid
+}
+
+// VerifyBlock returns ErrCorruptedBlock if the block data doesn't hash to its CID
+func VerifyBlock(blk blocks.Block) error {
+	c, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !c.Equals(blk.Cid()) {
+		return fmt.Errorf("%s: %w", blk.Cid(), ErrCorruptedBlock)
+	}
+	return nil
+}
+
+// checkBlockstore remembers why the last read failed since traversal errors don't wrap the loader errors
+type checkBlockstore struct {
+	blockstore.Blockstore
+	err error
+}
+
+// Get returns an error if the block is missing, unreadable or doesn't match its CID
+func (bs *checkBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(c)
+	if err == nil {
+		err = VerifyBlock(blk)
+	}
+	if err != nil {
+		bs.err = err
+		return nil, err
+	}
+	return blk, nil
+}
+
+// Check walks the DAG of every ref to make sure all the blocks of its keys are available and intact.
+// Refs with missing or corrupted blocks are removed from the index so we never serve partial or corrupted content.
+// Their blocks are removed by the next CleanBlockStore. Any other read error is returned as the
+// blockstore may not be readable at all, for example with the wrong encryption key.
+func (idx *Index) Check(ctx context.Context) (IntegrityReport, error) {
+	var report IntegrityReport
+
+	refs, err := idx.ListRefs()
+	if err != nil {
+		return report, err
+	}
+	for _, ref := range refs {
+		report.Checked++
+		// partial refs only hold the blocks of their keys
+		s := sel.All()
+		if len(ref.Keys) > 0 {
+			keys := make([]string, len(ref.Keys))
+			for i, k := range ref.Keys {
+				keys[i] = string(k)
+			}
+			s = sel.Keys(keys...)
+		}
+		bs := &checkBlockstore{Blockstore: idx.bstore}
+		err := utils.WalkDAG(ctx, ref.PayloadCID, bs, s, func(blocks.Block) error { return nil })
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if !errors.Is(bs.err, blockstore.ErrNotFound) && !errors.Is(bs.err, ErrCorruptedBlock) {
+			return report, fmt.Errorf("failed to read ref %s: %w", ref.PayloadCID, err)
+		}
+		log.Error().Err(bs.err).Str("root", ref.PayloadCID.String()).Msg("dropping ref with missing or corrupted blocks")
+		if err := idx.removeRef(ref); err != nil {
+			return report, err
+		}
+		report.Dropped = append(report.Dropped, ref.PayloadCID)
+		if ref.Pinned {
+			report.Pinned = append(report.Pinned, ref.PayloadCID)
+		}
+	}
+	return report, nil
+}
+
+// removeRef removes a ref from the index without walking its DAG since some blocks may be missing
+func (idx *Index) removeRef(ref *DataRef) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	k := ref.PayloadCID.String()
+	if _, err := idx.root.Delete(context.TODO(), k); err != nil {
+		return err
+	}
+	idx.remBlistEntry(ref.bucketNode, ref)
+	delete(idx.Refs, k)
+	idx.size -= uint64(ref.PayloadSize)
+	if err := idx.unname(ref); err != nil {
+		return err
+	}
+	return idx.Flush()
+}
+
 // CleanBlockStore removes blocks from blockstore which CIDs are not in index
 func (idx *Index) CleanBlockStore(ctx context.Context) error {
 	idx.emu.Lock()
 	defer idx.emu.Unlock()
 
-	cidSet := cid.NewSet()
+	// The blockstore only keeps multihashes so we compare those regardless of the codec
+	keep := make(map[string]struct{})
+	add := func(blk blocks.Block) error {
+		keep[string(blk.Cid().Hash())] = struct{}{}
+		return nil
+	}
+
+	// Keep the blocks of the index itself
+	if idx.rootCID.Defined() {
+		err := utils.WalkDAG(ctx, idx.rootCID, idx.bstore, sel.All(), add)
+		if err != nil {
+			return err
+		}
+	}
 
 	err := idx.root.ForEach(ctx, func(k string, val *cbg.Deferred) error {
 		ref := new(DataRef)
@@ -535,10 +1136,7 @@ func (idx *Index) CleanBlockStore(ctx context.Context) error {
 			return err
 		}
 
-		return utils.WalkDAG(ctx, ref.PayloadCID, idx.bstore, sel.All(), func(blk blocks.Block) error {
-			cidSet.Add(blk.Cid())
-			return nil
-		})
+		return utils.WalkDAG(ctx, ref.PayloadCID, idx.bstore, sel.All(), add)
 	})
 	if err != nil {
 		return err
@@ -549,8 +1147,7 @@ func (idx *Index) CleanBlockStore(ctx context.Context) error {
 		return err
 	}
 	for k := range kc {
-		key := cid.NewCidV1(cid.DagCBOR, k.Hash())
-		if cidSet.Has(key) {
+		if _, ok := keep[string(k.Hash())]; ok {
 			continue
 		}
 		err = idx.Bstore().DeleteBlock(k)
@@ -609,6 +1206,10 @@ func (idx *Index) LoadInterest(r cid.Cid, store cbor.IpldStore) error {
 		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
 			return err
 		}
+		// Private content is only held by the caches it was dispatched to
+		if v.Private {
+			return nil
+		}
 
 		// Check if this ref already is in the interest list
 		if ref, ok := idx.interest[k]; ok {
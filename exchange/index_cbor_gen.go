@@ -21,7 +21,7 @@ func (t *DataRef) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{165}); err != nil {
+	if _, err := w.Write([]byte{174}); err != nil {
 		return err
 	}
 
@@ -141,6 +141,225 @@ func (t *DataRef) MarshalCBOR(w io.Writer) error {
 			return err
 		}
 	}
+
+	// t.Private (bool) (bool)
+	if len("Private") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Private\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Private"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Private")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Private); err != nil {
+		return err
+	}
+
+	// t.Labels (map[string]string) (map)
+	if len("Labels") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Labels\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Labels"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Labels")); err != nil {
+		return err
+	}
+
+	{
+		if len(t.Labels) > 4096 {
+			return xerrors.Errorf("cannot marshal t.Labels map too large")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajMap, uint64(len(t.Labels))); err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(t.Labels))
+		for k := range t.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := t.Labels[k]
+
+			if len(k) > cbg.MaxLength {
+				return xerrors.Errorf("Value in field k was too long")
+			}
+
+			if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(k))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, string(k)); err != nil {
+				return err
+			}
+
+			if len(v) > cbg.MaxLength {
+				return xerrors.Errorf("Value in field v was too long")
+			}
+
+			if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(v))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, string(v)); err != nil {
+				return err
+			}
+
+		}
+	}
+
+	// t.Parent (cid.Cid) (struct)
+	if len("Parent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Parent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Parent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Parent")); err != nil {
+		return err
+	}
+
+	if t.Parent == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.Parent); err != nil {
+			return xerrors.Errorf("failed to write cid field t.Parent: %w", err)
+		}
+	}
+
+	// t.Name (string) (string)
+	if len("Name") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Name\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Name"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Name")); err != nil {
+		return err
+	}
+
+	if len(t.Name) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Name)); err != nil {
+		return err
+	}
+
+	// t.Created (int64) (int64)
+	if len("Created") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Created\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Created"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Created")); err != nil {
+		return err
+	}
+
+	if t.Created >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Created)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Created-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.LastRead (int64) (int64)
+	if len("LastRead") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"LastRead\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("LastRead"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("LastRead")); err != nil {
+		return err
+	}
+
+	if t.LastRead >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.LastRead)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.LastRead-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Queries (int64) (int64)
+	if len("Queries") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Queries\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Queries"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Queries")); err != nil {
+		return err
+	}
+
+	if t.Queries >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Queries)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Queries-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Retrievals (int64) (int64)
+	if len("Retrievals") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Retrievals\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Retrievals"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Retrievals")); err != nil {
+		return err
+	}
+
+	if t.Retrievals >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Retrievals)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Retrievals-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Pinned (bool) (bool)
+	if len("Pinned") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Pinned\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Pinned"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Pinned")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Pinned); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -316,6 +535,223 @@ func (t *DataRef) UnmarshalCBOR(r io.Reader) error {
 
 				t.BucketID = int64(extraI)
 			}
+			// t.Private (bool) (bool)
+		case "Private":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Private = false
+			case 21:
+				t.Private = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Labels (map[string]string) (map)
+		case "Labels":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajMap {
+				return fmt.Errorf("expected a map (major type 5)")
+			}
+			if extra > 4096 {
+				return fmt.Errorf("t.Labels: map too large")
+			}
+
+			t.Labels = make(map[string]string, extra)
+
+			for i, l := 0, int(extra); i < l; i++ {
+
+				var k string
+
+				{
+					sval, err := cbg.ReadStringBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					k = string(sval)
+				}
+
+				var v string
+
+				{
+					sval, err := cbg.ReadStringBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					v = string(sval)
+				}
+
+				t.Labels[k] = v
+
+			}
+			// t.Parent (cid.Cid) (struct)
+		case "Parent":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.Parent: %w", err)
+					}
+
+					t.Parent = &c
+				}
+
+			}
+			// t.Name (string) (string)
+		case "Name":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Name = string(sval)
+			}
+			// t.Created (int64) (int64)
+		case "Created":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Created = int64(extraI)
+			}
+			// t.LastRead (int64) (int64)
+		case "LastRead":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.LastRead = int64(extraI)
+			}
+			// t.Queries (int64) (int64)
+		case "Queries":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Queries = int64(extraI)
+			}
+			// t.Retrievals (int64) (int64)
+		case "Retrievals":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Retrievals = int64(extraI)
+			}
+			// t.Pinned (bool) (bool)
+		case "Pinned":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Pinned = false
+			case 21:
+				t.Pinned = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
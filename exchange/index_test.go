@@ -3,6 +3,7 @@ package exchange
 import (
 	"bytes"
 	"context"
+	"errors"
 	"math/rand"
 	"runtime"
 	"testing"
@@ -15,10 +16,12 @@ import (
 	"github.com/ipfs/go-graphsync/storeutil"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/myelnet/pop/internal/testutil"
 	sel "github.com/myelnet/pop/selectors"
 	"github.com/stretchr/testify/require"
@@ -293,6 +296,8 @@ func TestIndexUpdateRef(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 1, len(ref.Keys))
 
+	require.NoError(t, idx.Pin(c))
+
 	// to add the other key we need to use the updateRef method
 	require.NoError(t, idx.UpdateRef(ref2))
 	ref, err = idx.GetRef(c)
@@ -302,6 +307,149 @@ func TestIndexUpdateRef(t *testing.T) {
 	require.Equal(t, 2, len(ref.Keys))
 	require.Equal(t, "data1", string(ref.Keys[0]))
 	require.Equal(t, "data2", string(ref.Keys[1]))
+	require.True(t, ref.Pinned)
+
+	// the merged ref is persisted with its pin
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+	ref, err = idx.PeekRef(c)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ref.Keys))
+	require.True(t, ref.Pinned)
+}
+
+func TestIndexLabels(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs, WithBounds(512000, 500000), WithEvictLabels(map[string]string{"tier": "best-effort"}))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 256000,
+		Labels:      map[string]string{"env": "prod"},
+	}
+	require.NoError(t, idx.SetRef(ref1))
+
+	ref2 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 200000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+	require.NoError(t, idx.LabelRef(ref2.PayloadCID, map[string]string{"tier": "best-effort", "env": "prod"}))
+
+	// unknown refs cannot be labeled
+	require.Error(t, idx.LabelRef(blockGen.Next().Cid(), map[string]string{"env": "prod"}))
+
+	// labels are persisted
+	idx, err = NewIndex(ds, bs, WithBounds(512000, 500000), WithEvictLabels(map[string]string{"tier": "best-effort"}))
+	require.NoError(t, err)
+	ref, err := idx.PeekRef(ref2.PayloadCID)
+	require.NoError(t, err)
+	require.True(t, ref.HasLabels(map[string]string{"tier": "best-effort"}))
+	require.False(t, ref.HasLabels(map[string]string{"env": "dev"}))
+
+	// an empty value removes the label
+	labels := ref.Labels
+	require.NoError(t, idx.LabelRef(ref2.PayloadCID, map[string]string{"env": ""}))
+	ref, err = idx.PeekRef(ref2.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"tier": "best-effort"}, ref.Labels)
+	// labels previously read are never mutated
+	require.Equal(t, "prod", labels["env"])
+
+	ref3 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 100000,
+	}
+	require.NoError(t, idx.SetRef(ref3))
+
+	// only the best-effort ref should be evicted even if ref1 is less popular
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.Error(t, err)
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+}
+
+func TestIndexDemand(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	ref := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(ref))
+
+	require.NoError(t, idx.CountQuery(ref.PayloadCID))
+	require.NoError(t, idx.CountQuery(ref.PayloadCID))
+	require.NoError(t, idx.CountRetrieval(ref.PayloadCID))
+
+	require.ErrorIs(t, idx.CountQuery(blockGen.Next().Cid()), ErrRefNotFound)
+
+	// counters are persisted
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+	got, err := idx.PeekRef(ref.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), got.Queries)
+	require.Equal(t, int64(1), got.Retrievals)
+}
+
+func TestIndexPin(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs, WithBounds(512000, 500000))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	require.NoError(t, idx.Pin(ref1.PayloadCID))
+
+	ref2 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 200000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+
+	require.ErrorIs(t, idx.Pin(blockGen.Next().Cid()), ErrRefNotFound)
+
+	// pins are persisted
+	idx, err = NewIndex(ds, bs, WithBounds(512000, 500000))
+	require.NoError(t, err)
+	ref, err := idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+	require.True(t, ref.Pinned)
+
+	ref3 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 100000,
+	}
+	require.NoError(t, idx.SetRef(ref3))
+
+	// the pinned ref is kept even though it is the least frequently used
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.Error(t, err)
+
+	// once unpinned it can be evicted again
+	require.NoError(t, idx.Unpin(ref1.PayloadCID))
+	ref4 := &DataRef{
+		PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+		PayloadSize: 400000,
+	}
+	require.NoError(t, idx.SetRef(ref4))
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.Error(t, err)
 }
 
 func TestIndexListRefs(t *testing.T) {
@@ -651,6 +799,115 @@ func TestUnitGC(t *testing.T) {
 	require.Equal(t, true, has)
 }
 
+// rawBlock creates a block with a raw CID so it is decoded as a leaf when walking a DAG
+func rawBlock(t *testing.T, data []byte) blocks.Block {
+	h, err := mh.Sum(data, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, h))
+	require.NoError(t, err)
+	return blk
+}
+
+func TestGCSharedBlocks(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	shared := rawBlock(t, []byte("block shared between both refs"))
+	require.NoError(t, bs.Put(shared))
+
+	// two different roots linking to the same block
+	root1, err := cbor.WrapObject(map[string]interface{}{"name": "first", "data": shared.Cid()}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(root1))
+	root2, err := cbor.WrapObject(map[string]interface{}{"name": "second", "data": shared.Cid()}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(root2))
+
+	for _, r := range []blocks.Block{root1, root2} {
+		require.NoError(t, idx.SetRef(&DataRef{
+			PayloadCID:  r.Cid(),
+			PayloadSize: int64(len(r.RawData()) + len(shared.RawData())),
+		}))
+	}
+
+	require.NoError(t, idx.DropRef(root1.Cid()))
+	require.NoError(t, idx.GC())
+
+	has, err := bs.Has(root1.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// the shared block is still linked by the second ref
+	has, err = bs.Has(shared.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	has, err = bs.Has(root2.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// once no ref links to it anymore it can be collected
+	require.NoError(t, idx.DropRef(root2.Cid()))
+	require.NoError(t, idx.GC())
+
+	has, err = bs.Has(shared.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestBlockRefsPersist(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	shared := rawBlock(t, []byte("block shared between both refs"))
+	require.NoError(t, bs.Put(shared))
+
+	root1, err := cbor.WrapObject(map[string]interface{}{"name": "first", "data": shared.Cid()}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(root1))
+	root2, err := cbor.WrapObject(map[string]interface{}{"name": "second", "data": shared.Cid()}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(root2))
+
+	for _, r := range []blocks.Block{root1, root2} {
+		require.NoError(t, idx.SetRef(&DataRef{
+			PayloadCID:  r.Cid(),
+			PayloadSize: int64(len(r.RawData()) + len(shared.RawData())),
+		}))
+	}
+
+	// the counts are loaded from the datastore when restarting
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	n, err := idx.blockCount(shared.Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+
+	require.NoError(t, idx.DropRef(root1.Cid()))
+
+	n, err = idx.blockCount(shared.Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), n)
+
+	// re-adding the ref before the GC runs keeps its blocks
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  root1.Cid(),
+		PayloadSize: int64(len(root1.RawData()) + len(shared.RawData())),
+	}))
+	require.NoError(t, idx.GC())
+
+	has, err := bs.Has(root1.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
 func TestCleanBlockStore(t *testing.T) {
 	ds := dss.MutexWrap(datastore.NewMapDatastore())
 	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
@@ -714,3 +971,130 @@ func TestCleanBlockStore(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, true, has)
 }
+
+func TestIndexCheck(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	var blks []blocks.Block
+	for i := 0; i < 3; i++ {
+		blk := testutil.CreateRandomBlock(t, bs)
+		blks = append(blks, blk)
+		require.NoError(t, idx.SetRef(&DataRef{
+			PayloadCID:  blk.Cid(),
+			PayloadSize: int64(len(blk.RawData())),
+		}))
+	}
+
+	require.NoError(t, idx.Pin(blks[0].Cid()))
+
+	// the first block is missing
+	require.NoError(t, bs.DeleteBlock(blks[0].Cid()))
+	// the second block is corrupted
+	corrupted, err := blocks.NewBlockWithCid([]byte("not the original data"), blks[1].Cid())
+	require.NoError(t, err)
+	require.NoError(t, bs.DeleteBlock(blks[1].Cid()))
+	require.NoError(t, bs.Put(corrupted))
+
+	report, err := idx.Check(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Checked)
+	require.ElementsMatch(t, []cid.Cid{blks[0].Cid(), blks[1].Cid()}, report.Dropped)
+	require.Equal(t, []cid.Cid{blks[0].Cid()}, report.Pinned)
+
+	_, err = idx.PeekRef(blks[1].Cid())
+	require.ErrorIs(t, err, ErrRefNotFound)
+	_, err = idx.PeekRef(blks[2].Cid())
+	require.NoError(t, err)
+	require.Equal(t, 1, idx.Len())
+
+	// the index blocks are not removed when cleaning the blockstore
+	require.NoError(t, idx.CleanBlockStore(ctx))
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx.Len())
+
+	// refs are not dropped when the blockstore cannot be read
+	idx, err = NewIndex(ds, &failingBlockstore{Blockstore: bs, fail: blks[2].Cid()})
+	require.NoError(t, err)
+	_, err = idx.Check(ctx)
+	require.Error(t, err)
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx.Len())
+
+	// an unreadable root fails instead of starting with an empty index
+	require.NoError(t, bs.DeleteBlock(idx.Root()))
+	_, err = NewIndex(ds, bs)
+	require.Error(t, err)
+}
+
+// failingBlockstore fails to read a block like a blockstore opened with the wrong encryption key would
+type failingBlockstore struct {
+	blockstore.Blockstore
+	fail cid.Cid
+}
+
+func (bs *failingBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	if c.Equals(bs.fail) {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	return bs.Blockstore.Get(c)
+}
+
+func TestIndexVersions(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+
+	idx, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+
+	_, err = idx.Latest("site")
+	require.ErrorIs(t, err, ErrNameNotFound)
+
+	var roots []cid.Cid
+	for i := 0; i < 3; i++ {
+		ref := &DataRef{
+			PayloadCID:  testutil.CreateRandomBlock(t, bs).Cid(),
+			PayloadSize: 10,
+			Name:        "site",
+		}
+		if i > 0 {
+			ref.Parent = &roots[i-1]
+		}
+		require.NoError(t, idx.SetRef(ref))
+		roots = append(roots, ref.PayloadCID)
+	}
+
+	latest, err := idx.Latest("site")
+	require.NoError(t, err)
+	require.Equal(t, roots[2], latest.PayloadCID)
+
+	// names and parents are persisted
+	idx, err = NewIndex(ds, bs)
+	require.NoError(t, err)
+	versions, err := idx.Versions("site")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, v := range versions {
+		require.Equal(t, roots[2-i], v.PayloadCID)
+	}
+	require.Equal(t, roots[1], *versions[0].Parent)
+	require.Nil(t, versions[2].Parent)
+
+	// dropping the latest version moves the name back to its parent
+	require.NoError(t, idx.DropRef(roots[2]))
+	latest, err = idx.Latest("site")
+	require.NoError(t, err)
+	require.Equal(t, roots[1], latest.PayloadCID)
+
+	// history stops when a parent is no longer in the index
+	require.NoError(t, idx.DropRef(roots[0]))
+	versions, err = idx.Versions("site")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+}
@@ -0,0 +1,98 @@
+package exchange
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// SchemeMember is a peer we share a replication scheme with
+type SchemeMember struct {
+	Peer    peer.ID
+	Regions []RegionCode
+	// Joined is when content was first replicated between us
+	Joined time.Time
+	// LastActive is the last time content was replicated between us
+	LastActive time.Time
+}
+
+// Membership keeps track of our position in the replication graph. We join the scheme of a peer when we fetch
+// its index or pull the content it dispatched and a peer joins ours when it fetches our index or pulls our
+// dispatches.
+type Membership struct {
+	mu sync.Mutex
+	// joined are the peers whose scheme we joined
+	joined map[peer.ID]*SchemeMember
+	// members are the peers who joined our scheme
+	members map[peer.ID]*SchemeMember
+}
+
+// NewMembership creates an empty Membership
+func NewMembership() *Membership {
+	return &Membership{
+		joined:  make(map[peer.ID]*SchemeMember),
+		members: make(map[peer.ID]*SchemeMember),
+	}
+}
+
+func recordMember(set map[peer.ID]*SchemeMember, p peer.ID, regions []RegionCode) {
+	now := time.Now()
+	m, ok := set[p]
+	if !ok {
+		m = &SchemeMember{Peer: p, Joined: now}
+		set[p] = m
+	}
+	if len(regions) > 0 {
+		m.Regions = regions
+	}
+	m.LastActive = now
+}
+
+// Join records we joined the scheme of a peer in the given regions
+func (m *Membership) Join(p peer.ID, regions []RegionCode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	recordMember(m.joined, p, regions)
+}
+
+// Add records a peer joined our scheme in the given regions
+func (m *Membership) Add(p peer.ID, regions []RegionCode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	recordMember(m.members, p, regions)
+}
+
+func listMembers(set map[peer.ID]*SchemeMember) []SchemeMember {
+	l := make([]SchemeMember, 0, len(set))
+	for _, m := range set {
+		l = append(l, *m)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Joined.Before(l[j].Joined)
+	})
+	return l
+}
+
+// Remove forgets a peer who left the replication graph
+func (m *Membership) Remove(p peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.joined, p)
+	delete(m.members, p)
+}
+
+// Joined returns the peers whose scheme we joined, oldest first
+func (m *Membership) Joined() []SchemeMember {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return listMembers(m.joined)
+}
+
+// Members returns the peers who joined our scheme, oldest first
+func (m *Membership) Members() []SchemeMember {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return listMembers(m.members)
+}
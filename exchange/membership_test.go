@@ -0,0 +1,38 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembership(t *testing.T) {
+	m := NewMembership()
+	p1, p2 := peer.ID("peer1"), peer.ID("peer2")
+
+	m.Join(p1, []RegionCode{GlobalRegion})
+	time.Sleep(time.Millisecond)
+	m.Join(p2, nil)
+	m.Add(p2, []RegionCode{EuropeRegion})
+
+	joined := m.Joined()
+	require.Len(t, joined, 2)
+	require.Equal(t, p1, joined[0].Peer)
+	require.Equal(t, []RegionCode{GlobalRegion}, joined[0].Regions)
+	require.Equal(t, p2, joined[1].Peer)
+
+	members := m.Members()
+	require.Len(t, members, 1)
+	require.Equal(t, p2, members[0].Peer)
+
+	// the join time doesn't change with activity and known regions are kept
+	first := joined[0].Joined
+	time.Sleep(time.Millisecond)
+	m.Join(p1, nil)
+	joined = m.Joined()
+	require.Equal(t, first, joined[0].Joined)
+	require.True(t, joined[0].LastActive.After(first))
+	require.Equal(t, []RegionCode{GlobalRegion}, joined[0].Regions)
+}
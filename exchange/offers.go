@@ -0,0 +1,97 @@
+package exchange
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// DefaultOfferTTL is the duration after which a cached offer is no longer used
+const DefaultOfferTTL = 10 * time.Minute
+
+// ErrOfferNotFound is returned when no valid offer is cached for a given root
+var ErrOfferNotFound = errors.New("no existing offer")
+
+type cachedOffer struct {
+	offer   deal.Offer
+	expires time.Time
+}
+
+// OfferCache keeps recent offers for each root CID so repeated retrievals can skip discovery
+// and go straight to a known provider, reusing existing connections and payment channels.
+type OfferCache struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	offers map[cid.Cid]cachedOffer
+	// swept is the last time we removed expired offers
+	swept time.Time
+}
+
+// NewOfferCache creates a new in memory OfferCache. Offers expire after the given ttl.
+func NewOfferCache(ttl time.Duration) *OfferCache {
+	if ttl <= 0 {
+		ttl = DefaultOfferTTL
+	}
+	return &OfferCache{
+		ttl:    ttl,
+		offers: make(map[cid.Cid]cachedOffer),
+		swept:  time.Now(),
+	}
+}
+
+// sweep removes expired offers at most once per ttl so roots we never retrieve again don't pile up.
+// The caller must hold the lock.
+func (oc *OfferCache) sweep(now time.Time) {
+	if now.Sub(oc.swept) < oc.ttl {
+		return
+	}
+	for k, co := range oc.offers {
+		if now.After(co.expires) {
+			delete(oc.offers, k)
+		}
+	}
+	oc.swept = now
+}
+
+// Set caches an offer for a given root CID. It currently assumes the offer is valid for the entire DAG.
+func (oc *OfferCache) Set(k cid.Cid, o deal.Offer) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	now := time.Now()
+	oc.sweep(now)
+	oc.offers[k] = cachedOffer{
+		offer:   o,
+		expires: now.Add(oc.ttl),
+	}
+}
+
+// Get returns a cached offer for a given root CID if it hasn't expired
+func (oc *OfferCache) Get(k cid.Cid) (deal.Offer, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	now := time.Now()
+	oc.sweep(now)
+	co, ok := oc.offers[k]
+	if !ok {
+		return deal.Offer{}, ErrOfferNotFound
+	}
+	if now.After(co.expires) {
+		delete(oc.offers, k)
+		return deal.Offer{}, ErrOfferNotFound
+	}
+	return co.offer, nil
+}
+
+// Invalidate removes the offer for a given root CID for example if the transfer failed
+func (oc *OfferCache) Invalidate(k cid.Cid) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	delete(oc.offers, k)
+}
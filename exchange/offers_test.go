@@ -0,0 +1,56 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/myelnet/pop/retrieval/deal"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfferCache(t *testing.T) {
+	oc := NewOfferCache(50 * time.Millisecond)
+
+	root := blockGen.Next().Cid()
+	offer := deal.Offer{
+		PayloadCID:      root,
+		Size:            1000,
+		MinPricePerByte: abi.NewTokenAmount(1),
+	}
+
+	_, err := oc.Get(root)
+	require.Equal(t, ErrOfferNotFound, err)
+
+	oc.Set(root, offer)
+	o, err := oc.Get(root)
+	require.NoError(t, err)
+	require.Equal(t, offer, o)
+
+	// failed transfers invalidate the offer
+	oc.Invalidate(root)
+	_, err = oc.Get(root)
+	require.Equal(t, ErrOfferNotFound, err)
+
+	// offers expire after the ttl
+	oc.Set(root, offer)
+	time.Sleep(60 * time.Millisecond)
+	_, err = oc.Get(root)
+	require.Equal(t, ErrOfferNotFound, err)
+
+	// expired offers for roots we never look up again are swept
+	other := blockGen.Next().Cid()
+	oc.Set(other, offer)
+	time.Sleep(60 * time.Millisecond)
+	oc.Set(root, offer)
+	oc.mu.Lock()
+	require.Len(t, oc.offers, 1)
+	oc.mu.Unlock()
+}
+
+func TestSelectsAll(t *testing.T) {
+	require.True(t, selectsAll(sel.All()))
+	require.False(t, selectsAll(sel.Key("file.txt")))
+	require.False(t, selectsAll(nil))
+}
@@ -12,6 +12,7 @@ import (
 	dtnet "github.com/filecoin-project/go-data-transfer/network"
 	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	"github.com/ipfs/go-graphsync"
@@ -21,8 +22,13 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/payments"
+	"github.com/myelnet/pop/retrieval"
 	"github.com/myelnet/pop/wallet"
 	"github.com/rs/zerolog/log"
 )
@@ -69,11 +75,77 @@ type Options struct {
 	// ReplInterval is the replication interval after which a worker will try to retrieve fresh new content
 	// on the network
 	ReplInterval time.Duration
+	// AuditInterval is the interval at which we challenge a random sample of the caches holding the content
+	// we dispatched. Defaults to DefaultAuditInterval, a negative interval disables audits.
+	AuditInterval time.Duration
+	// NettingInterval is the interval at which we pay the difference of our mutual debts with the peers we
+	// frequently exchange incentives with. Defaults to DefaultNettingInterval, a negative interval pays every
+	// incentive right away.
+	NettingInterval time.Duration
+	// EvictLabels restricts eviction to refs with all the given labels, i.e. tier=best-effort.
+	// Default is any ref can be evicted.
+	EvictLabels map[string]string
+	// EvictionPolicy decides which refs are evicted first when we reach capacity.
+	// Default evicts the least frequently used refs first.
+	EvictionPolicy EvictionPolicy
+	// DispatchPolicy sets the rules for accepting content dispatched to us by other peers.
+	// Default accepts any content we have capacity for.
+	DispatchPolicy DispatchPolicy
+	// Quota limits the bandwidth each client can use when retrieving content from us.
+	// Default is no limit.
+	Quota retrieval.Quota
+	// OfferTTL is the duration after which a cached offer is no longer used. Defaults to DefaultOfferTTL.
+	OfferTTL time.Duration
+	// StallTimeout is the duration without progress after which a transfer is considered hung and we fail over
+	// to another peer. Defaults to DefaultStallTimeout.
+	StallTimeout time.Duration
+	// Discovery selects how we find providers for the content we retrieve. Default is DiscoveryGossip.
+	Discovery Discovery
+	// ContentRouting publishes and finds provider records when using DiscoveryDHT.
+	ContentRouting routing.ContentRouting
+	// IndexerURL is the endpoint of the network indexer to look up providers with when using DiscoveryIndexer.
+	IndexerURL string
+	// Routing can be set to use a custom discovery mechanism instead of the Discovery backends.
+	Routing Routing
+	// TrustedPeers may pull any of our content without payment, i.e. other nodes owned by the operator.
+	TrustedPeers []peer.ID
+	// FleetKey is a secret shared by the nodes of an operator. Peers proving they know it are trusted too.
+	FleetKey string
+	// Gater blocks the peers sending us too many invalid messages. Default is to never block peers.
+	Gater PeerGater
+	// MaxTransfers is the number of transfers we serve at once before declining queries. Default is no limit.
+	MaxTransfers int
+	// MaxDispatchTransfers is the number of transfers we serve at once across all our dispatches so large
+	// commits don't saturate our uplink. Defaults to DefaultMaxDispatchTransfers or LowPowerMaxDispatchTransfers
+	// in low power mode.
+	MaxDispatchTransfers int
+	// LowPower is a profile for constrained devices such as a Raspberry Pi or a phone. It limits concurrent
+	// transfers to LowPowerMaxTransfers unless MaxTransfers is set and batches content announcements.
+	LowPower bool
+	// VerifyTransfers walks the DAG received after a dispatch pull or a retrieval before adding it to our index.
+	// Content with missing or corrupt blocks is dropped. Default is false.
+	VerifyTransfers bool
+	// CachePolicy sets the rules for fetching popular content we relay queries for without being dispatched it.
+	// Default is no opportunistic caching.
+	CachePolicy CachePolicy
+	// CheckpointInterval is how many epochs we wait before submitting the best vouchers of our inbound payment
+	// channels. Defaults to payments.DefaultCheckpointInterval.
+	CheckpointInterval abi.ChainEpoch
+	// CheckpointAmount is the unsubmitted value of an inbound channel after which we submit its vouchers right away.
+	// Default is to only submit at checkpoint intervals and when channels settle.
+	CheckpointAmount filecoin.BigInt
+	// GasPolicy sets the fees we pay for payment channel messages. Default is to use the estimates of the Filecoin node.
+	GasPolicy payments.GasPolicy
+	// Supervisor recovers and restarts background routines if they panic.
+	Supervisor *utils.Supervisor
 }
 
 // Everything isn't thoroughly validated so we trust users who provide options know what they're doing
 func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.Batching) (Options, error) {
 	var err error
+	if opts.Supervisor == nil {
+		opts.Supervisor = utils.NewSupervisor()
+	}
 	if opts.Blockstore == nil {
 		opts.Blockstore = blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
 	} else if _, ok := opts.Blockstore.(blockstore.GCBlockstore); !ok {
@@ -133,6 +205,27 @@ func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.
 	if opts.ReplInterval == 0 {
 		opts.ReplInterval = 60 * time.Second
 	}
+	if opts.AuditInterval == 0 {
+		opts.AuditInterval = DefaultAuditInterval
+	}
+	if opts.NettingInterval == 0 {
+		opts.NettingInterval = DefaultNettingInterval
+	}
+	if opts.StallTimeout == 0 {
+		opts.StallTimeout = DefaultStallTimeout
+	}
+	if opts.CheckpointInterval == 0 {
+		opts.CheckpointInterval = payments.DefaultCheckpointInterval
+	}
+	if opts.LowPower && opts.MaxTransfers == 0 {
+		opts.MaxTransfers = LowPowerMaxTransfers
+	}
+	if opts.MaxDispatchTransfers == 0 {
+		opts.MaxDispatchTransfers = DefaultMaxDispatchTransfers
+		if opts.LowPower {
+			opts.MaxDispatchTransfers = LowPowerMaxDispatchTransfers
+		}
+	}
 
 	return opts, nil
 }
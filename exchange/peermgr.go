@@ -5,28 +5,51 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 	"sync"
 	"time"
 
-	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-eventbus"
 	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/internal/utils"
 	"github.com/rs/zerolog/log"
 )
 
-// HeyProtocol identifies the supply greeter protocol
-const HeyProtocol = "/myel/pop/hey/1.0"
-
 //go:generate cbor-gen-for Hey
 
 // Hey is the greeting message which takes in network info
 type Hey struct {
 	Regions   []RegionCode
 	IndexRoot *cid.Cid // If the node has an empty index the root will be nil
+	// Protocols lists the protocol versions this peer supports
+	Protocols []string
+	// Capacity is the storage space in bytes available for new content, 0 when full
+	Capacity uint64
+	// FreeTier is true if the peer serves content at no cost in at least one region
+	FreeTier bool
+	// PPB is a hint of the lowest price per byte this peer charges for retrievals
+	PPB abi.TokenAmount
+	// FleetProof proves the peer knows the fleet key of the operator if any
+	FleetProof []byte
+}
+
+// SupportedProtocols are advertised in our Hey messages so peers know which protocol versions we speak
+var SupportedProtocols = []string{
+	string(HeyProtocol),
+	string(HeyProtocolV1),
+	string(PopRequestProtocolV2),
+	string(PopRequestProtocolV11),
+	string(PopRequestProtocolV1),
+	string(PopQueryProtocolID),
+	GoodbyeProtocol,
+	HeartbeatProtocol,
 }
 
 // HeyEvt is emitted when a Hey is received and accessible via the libp2p event bus subscription
@@ -37,16 +60,52 @@ type HeyEvt struct {
 
 // Peer contains information recorded while interacted with a peer
 type Peer struct {
-	Regions []RegionCode
-	Latency time.Duration
+	Regions   []RegionCode
+	Latency   time.Duration
+	Protocols []string
+	// Capacity is the space available for new content, unbounded if the peer didn't tell us
+	Capacity uint64
+	// LastSeen is the last time the peer greeted us or answered a heartbeat
+	LastSeen time.Time
+	// Missed is the number of heartbeats the peer missed in a row
+	Missed int
+	// Inactive peers stopped answering our heartbeats and are not selected until they answer again
+	Inactive bool
 }
 
+// Supports returns whether the peer advertised support for a given protocol
+func (p Peer) Supports(proto string) bool {
+	for _, pr := range p.Protocols {
+		if pr == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerFilter returns true if a peer should be selected
+type PeerFilter func(peer.ID, Peer) bool
+
 // PeerMgr is in charge of maintaining an optimal network of peers to coordinate with
 type PeerMgr struct {
 	h       host.Host
 	regions map[RegionCode]Region
 	emitter event.Emitter
-	idx     *Index
+	// byeEmitter lets the replication scheme know when peers leave
+	byeEmitter event.Emitter
+	// statusEmitter lets dispatches know when peers stop responding
+	statusEmitter event.Emitter
+	idx           *Index
+	// heartbeat is the interval at which we ping our peers
+	heartbeat time.Duration
+	// records persists our peers so we can reach them right away after a restart
+	records *peerRecordStore
+	// sup recovers from panics in our handlers
+	sup *utils.Supervisor
+	// trust adds the peers proving they're part of our fleet to the allowlist
+	trust *Allowlist
+	// strikes blocks the peers sending us too many invalid messages
+	strikes *Strikes
 
 	mu    sync.Mutex
 	peers map[peer.ID]Peer
@@ -64,12 +123,26 @@ func NewPeerMgr(h host.Host, idx *Index, regions []Region) *PeerMgr {
 		log.Error().Err(err).Msg("failed to create emitter event")
 	}
 
+	byeEmitter, err := h.EventBus().Emitter(new(GoodbyeEvt))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create emitter event")
+	}
+
+	statusEmitter, err := h.EventBus().Emitter(new(PeerStatusEvt))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create emitter event")
+	}
+
 	pm := &PeerMgr{
-		h:       h,
-		regions: reg,
-		idx:     idx,
-		peers:   make(map[peer.ID]Peer),
-		emitter: emitter,
+		h:             h,
+		regions:       reg,
+		idx:           idx,
+		peers:         make(map[peer.ID]Peer),
+		emitter:       emitter,
+		byeEmitter:    byeEmitter,
+		statusEmitter: statusEmitter,
+		heartbeat:     HeartbeatInterval,
+		records:       newPeerRecordStore(idx.ds),
 	}
 
 	h.Network().Notify(&network.NotifyBundle{
@@ -79,6 +152,7 @@ func NewPeerMgr(h host.Host, idx *Index, regions []Region) *PeerMgr {
 			if _, ok := pm.peers[c.RemotePeer()]; ok {
 				delete(pm.peers, c.RemotePeer())
 			}
+			pm.trust.Forget(c.RemotePeer())
 		},
 	})
 
@@ -86,42 +160,78 @@ func NewPeerMgr(h host.Host, idx *Index, regions []Region) *PeerMgr {
 }
 
 func (pm *PeerMgr) Run(ctx context.Context) error {
-	pm.h.SetStreamHandler(HeyProtocol, pm.handleStream)
+	for _, proto := range HeyProtocols {
+		pm.h.SetStreamHandler(proto, pm.handleStream)
+	}
+	pm.h.SetStreamHandler(GoodbyeProtocol, pm.handleGoodbye)
+	pm.h.SetStreamHandler(HeartbeatProtocol, pm.handleHeartbeat)
+
+	if err := pm.loadPeers(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to load peer records")
+	}
 
 	sub, err := pm.h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted), eventbus.BufSize(1024))
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to event bus: %w", err)
 	}
 
-	go func() {
+	pm.sup.Go(ctx, "hey-events", func(ctx context.Context) {
 		for evt := range sub.Out() {
 			pic := evt.(event.EvtPeerIdentificationCompleted)
 			go func() {
+				defer pm.sup.Recover("hey")
 				if err := pm.sendHey(ctx, pic.Peer); err != nil {
 					return
 				}
 			}()
 		}
-	}()
+	})
+	if pm.heartbeat > 0 {
+		pm.sup.Go(ctx, "heartbeats", pm.heartbeats)
+	}
 	return nil
 }
 
 // Peers returns n active peers for a given list of regions and peers to ignore
 func (pm *PeerMgr) Peers(n int, rl []Region, ignore map[peer.ID]bool) []peer.ID {
+	return pm.FilterPeers(n, rl, ignore, nil)
+}
+
+// FilterPeers returns n active peers for a given list of regions and peers to ignore.
+// Peers are selected in the order of the regions then of their IDs so the same peers are returned every time
+// i.e. a dry run previews the selection of the actual dispatch. Only peers passing the filter are selected if one is provided.
+func (pm *PeerMgr) FilterPeers(n int, rl []Region, ignore map[peer.ID]bool, filter PeerFilter) []peer.ID {
 	var peers []peer.ID
 	if n == 0 {
 		return peers
 	}
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	ids := make([]peer.ID, 0, len(pm.peers))
+	for p := range pm.peers {
+		ids = append(ids, p)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	// peers in multiple regions are only selected once
+	selected := make(map[peer.ID]bool)
 	for _, r := range rl {
-		for p, v := range pm.peers {
-			if ignore[p] {
+		for _, p := range ids {
+			v := pm.peers[p]
+			if ignore[p] || selected[p] {
+				continue
+			}
+			// Peers who stopped answering our heartbeats are likely gone
+			if v.Inactive {
+				continue
+			}
+			if filter != nil && !filter(p, v) {
 				continue
 			}
 			for _, rc := range v.Regions {
 				if rc == r.Code {
 					peers = append(peers, p)
+					selected[p] = true
+					break
 				}
 			}
 			// Check if we have enough peers and return
@@ -133,10 +243,33 @@ func (pm *PeerMgr) Peers(n int, rl []Region, ignore map[peer.ID]bool) []peer.ID
 	return peers
 }
 
+// Peer returns the information we have about a given peer
+func (pm *PeerMgr) Peer(p peer.ID) (Peer, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	v, ok := pm.peers[p]
+	return v, ok
+}
+
+// RegionCounts returns the number of connected peers in each region. Peers in multiple regions are counted in each.
+func (pm *PeerMgr) RegionCounts() map[RegionCode]int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	counts := make(map[RegionCode]int)
+	for _, p := range pm.peers {
+		for _, r := range p.Regions {
+			counts[r]++
+		}
+	}
+	return counts
+}
+
 // handleStream is the multistream handler for the Hey protocol, it reads a Hey message and handles it
 func (pm *PeerMgr) handleStream(s network.Stream) {
-	var hmsg Hey
-	if err := cborutil.ReadCborRPC(s, &hmsg); err != nil {
+	defer pm.sup.Recover("hey-handler")
+	hmsg, err := DecodeHeyVersion(s, s.Protocol())
+	if err != nil {
+		pm.strikes.Record(s.Conn().RemotePeer(), err)
 		connErr := s.Conn().Close()
 		if connErr != nil {
 			log.Error().Err(connErr).Msg("could not close stream connection")
@@ -161,6 +294,9 @@ func (pm *PeerMgr) handleStream(s network.Stream) {
 
 // Receive a new greeting from peer
 func (pm *PeerMgr) handleHey(p peer.ID, h Hey) {
+	if pm.trust.Verify(p, h.FleetProof) {
+		log.Debug().Str("peer", p.String()).Msg("fleet member joined")
+	}
 	for _, r := range h.Regions {
 		// We only save peers who are in the same region as us
 		if reg, ok := pm.regions[r]; ok {
@@ -174,18 +310,28 @@ func (pm *PeerMgr) handleHey(p peer.ID, h Hey) {
 
 			// These peers should be trimmed last when the number of connections overflows
 			pm.h.ConnManager().TagPeer(p, reg.Name, 10)
-			pm.mu.Lock()
-			pm.peers[p] = Peer{
-				Regions: h.Regions,
+			info := Peer{
+				Regions:   h.Regions,
+				Protocols: h.Protocols,
+				Capacity:  h.Capacity,
+				LastSeen:  time.Now(),
 			}
+			pm.mu.Lock()
+			prev, known := pm.peers[p]
+			pm.peers[p] = info
 			pm.mu.Unlock()
+			pm.saveRecord(p, info)
+			// A peer greeting us again is responsive
+			if known && prev.Inactive {
+				pm.emitStatus(p, true)
+			}
 		}
 	}
 }
 
 // sendHey message to a given peer
 func (pm *PeerMgr) sendHey(ctx context.Context, pid peer.ID) error {
-	s, err := pm.h.NewStream(ctx, pid, HeyProtocol)
+	s, err := pm.h.NewStream(ctx, pid, HeyProtocols...)
 	if err != nil {
 		return err
 	}
@@ -193,7 +339,7 @@ func (pm *PeerMgr) sendHey(ctx context.Context, pid peer.ID) error {
 	hmsg := pm.getHey()
 
 	start := time.Now()
-	if err := cborutil.WriteCborRPC(s, &hmsg); err != nil {
+	if err := writeHey(s, s.Protocol(), hmsg); err != nil {
 		return err
 	}
 	go func() {
@@ -215,13 +361,34 @@ func (pm *PeerMgr) sendHey(ctx context.Context, pid peer.ID) error {
 // getHey formats a new Hey message
 func (pm *PeerMgr) getHey() Hey {
 	regions := make([]RegionCode, len(pm.regions))
+	var ppb abi.TokenAmount
 	i := 0
 	for _, rg := range pm.regions {
 		regions[i] = rg.Code
 		i++
+		if rg.PPB.Nil() {
+			continue
+		}
+		if ppb.Nil() || rg.PPB.LessThan(ppb) {
+			ppb = rg.PPB
+		}
+	}
+	if ppb.Nil() {
+		ppb = big.Zero()
+	}
+	// An index without bounds can store as much as we want
+	capacity := uint64(math.MaxUint64)
+	if pm.idx.Bounded() {
+		capacity = pm.idx.Available()
 	}
 	h := Hey{
-		Regions: regions,
+		Regions:   regions,
+		Protocols: SupportedProtocols,
+		Capacity:  capacity,
+		FreeTier:  ppb.IsZero(),
+		PPB:       ppb,
+		// The proof is bound to our peer ID so it is fine to send it to everyone
+		FleetProof: pm.trust.Proof(pm.h.ID()),
 	}
 
 	idxr := pm.idx.Root()
@@ -16,7 +16,7 @@ var _ = xerrors.Errorf
 var _ = cid.Undef
 var _ = sort.Sort
 
-var lengthBufHey = []byte{130}
+var lengthBufHey = []byte{135}
 
 func (t *Hey) MarshalCBOR(w io.Writer) error {
 	if t == nil {
@@ -55,6 +55,55 @@ func (t *Hey) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.Protocols ([]string) (slice)
+	if len(t.Protocols) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Protocols was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.Protocols))); err != nil {
+		return err
+	}
+	for _, v := range t.Protocols {
+		if len(v) > cbg.MaxLength {
+			return xerrors.Errorf("Value in field v was too long")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(v))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, string(v)); err != nil {
+			return err
+		}
+	}
+
+	// t.Capacity (uint64) (uint64)
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Capacity)); err != nil {
+		return err
+	}
+
+	// t.FreeTier (bool) (bool)
+	if err := cbg.WriteBool(w, t.FreeTier); err != nil {
+		return err
+	}
+
+	// t.PPB (big.Int) (struct)
+	if err := t.PPB.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.FleetProof ([]uint8) (slice)
+	if len(t.FleetProof) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.FleetProof was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.FleetProof))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.FleetProof[:]); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -72,7 +121,7 @@ func (t *Hey) UnmarshalCBOR(r io.Reader) error {
 		return fmt.Errorf("cbor input should be of type array")
 	}
 
-	if extra != 2 {
+	if extra != 7 {
 		return fmt.Errorf("cbor input had wrong number of fields")
 	}
 
@@ -131,5 +180,97 @@ func (t *Hey) UnmarshalCBOR(r io.Reader) error {
 		}
 
 	}
+	// t.Protocols ([]string) (slice)
+
+	maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("t.Protocols: array too large (%d)", extra)
+	}
+
+	if maj != cbg.MajArray {
+		return fmt.Errorf("expected cbor array")
+	}
+
+	if extra > 0 {
+		t.Protocols = make([]string, extra)
+	}
+
+	for i := 0; i < int(extra); i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			t.Protocols[i] = string(sval)
+		}
+	}
+
+	// t.Capacity (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Capacity = uint64(extra)
+
+	}
+	// t.FreeTier (bool) (bool)
+
+	maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajOther {
+		return fmt.Errorf("booleans must be major type 7")
+	}
+	switch extra {
+	case 20:
+		t.FreeTier = false
+	case 21:
+		t.FreeTier = true
+	default:
+		return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+	}
+	// t.PPB (big.Int) (struct)
+
+	{
+
+		if err := t.PPB.UnmarshalCBOR(br); err != nil {
+			return xerrors.Errorf("unmarshaling t.PPB: %w", err)
+		}
+
+	}
+	// t.FleetProof ([]uint8) (slice)
+
+	maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+
+	if extra > cbg.ByteArrayMaxLen {
+		return fmt.Errorf("t.FleetProof: byte array too large (%d)", extra)
+	}
+	if maj != cbg.MajByteString {
+		return fmt.Errorf("expected byte array")
+	}
+
+	if extra > 0 {
+		t.FleetProof = make([]uint8, extra)
+	}
+
+	if _, err := io.ReadFull(br, t.FleetProof[:]); err != nil {
+		return err
+	}
 	return nil
 }
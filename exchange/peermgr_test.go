@@ -1,13 +1,18 @@
 package exchange
 
 import (
+	"bytes"
 	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/peer"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/myelnet/pop/internal/testutil"
 	"github.com/stretchr/testify/require"
-	"testing"
-	"time"
 )
 
 func TestHeyEvtPeerMgr(t *testing.T) {
@@ -72,3 +77,155 @@ func TestRecordLatency(t *testing.T) {
 	p1Latency := p1.peers[n2.Host.ID()].Latency
 	require.Equal(t, latency, p1Latency)
 }
+
+func TestHeyCapabilities(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+	idx, err := NewIndex(n1.Ds, n1.Bs, WithBounds(1000, 800))
+	require.NoError(t, err)
+
+	p1 := NewPeerMgr(n1.Host, idx, []Region{global})
+
+	hey := p1.getHey()
+	require.Equal(t, uint64(1000), hey.Capacity)
+	require.Equal(t, SupportedProtocols, hey.Protocols)
+	// the global region is free
+	require.True(t, hey.FreeTier)
+
+	// Check the message survives encoding
+	buf := new(bytes.Buffer)
+	require.NoError(t, hey.MarshalCBOR(buf))
+	var dec Hey
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.Equal(t, hey.Capacity, dec.Capacity)
+	require.Equal(t, hey.Protocols, dec.Protocols)
+	require.True(t, hey.PPB.Equals(dec.PPB))
+
+	p1.handleHey(n2.Host.ID(), Hey{
+		Regions:   []RegionCode{GlobalRegion},
+		Protocols: SupportedProtocols,
+		Capacity:  100,
+		FreeTier:  true,
+		PPB:       big.Zero(),
+	})
+	p1.handleHey(n3.Host.ID(), Hey{
+		Regions:  []RegionCode{GlobalRegion},
+		Capacity: 10000,
+		PPB:      abi.NewTokenAmount(1),
+	})
+
+	info, ok := p1.Peer(n2.Host.ID())
+	require.True(t, ok)
+	require.True(t, info.Supports(string(HeyProtocol)))
+
+	peers := p1.FilterPeers(2, []Region{global}, nil, func(_ peer.ID, p Peer) bool {
+		return p.Capacity >= 1000
+	})
+	require.Equal(t, []peer.ID{n3.Host.ID()}, peers)
+
+	// the same peer is selected every time
+	first := n2.Host.ID()
+	if n3.Host.ID() < first {
+		first = n3.Host.ID()
+	}
+	for i := 0; i < 10; i++ {
+		require.Equal(t, []peer.ID{first}, p1.Peers(1, []Region{global}, nil))
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+
+	p1 := NewPeerMgr(n1.Host, idx, []Region{global})
+	p2 := NewPeerMgr(n2.Host, idx, []Region{global})
+	sub, err := p1.h.EventBus().Subscribe(new(PeerStatusEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+
+	p1.handleHey(n2.Host.ID(), Hey{
+		Regions:   []RegionCode{GlobalRegion},
+		Protocols: SupportedProtocols,
+	})
+
+	// n2 doesn't answer heartbeats yet
+	for i := 0; i < MaxMissedHeartbeats-1; i++ {
+		p1.pingAll(ctx)
+	}
+	info, _ := p1.Peer(n2.Host.ID())
+	require.False(t, info.Inactive)
+	require.Equal(t, MaxMissedHeartbeats-1, info.Missed)
+
+	p1.pingAll(ctx)
+	evt := (<-sub.Out()).(PeerStatusEvt)
+	require.Equal(t, n2.Host.ID(), evt.Peer)
+	require.False(t, evt.Active)
+	require.Len(t, p1.Peers(1, []Region{global}, nil), 0)
+
+	n2.Host.SetStreamHandler(HeartbeatProtocol, p2.handleHeartbeat)
+	p1.pingAll(ctx)
+	evt = (<-sub.Out()).(PeerStatusEvt)
+	require.True(t, evt.Active)
+
+	info, _ = p1.Peer(n2.Host.ID())
+	require.False(t, info.Inactive)
+	require.Equal(t, 0, info.Missed)
+	require.NotZero(t, info.Latency)
+	require.Equal(t, []peer.ID{n2.Host.ID()}, p1.Peers(1, []Region{global}, nil))
+}
+
+func TestLoadPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+
+	p1 := NewPeerMgr(n1.Host, idx, []Region{global})
+	p1.handleHey(n2.Host.ID(), Hey{
+		Regions:   []RegionCode{GlobalRegion},
+		Protocols: SupportedProtocols,
+		Capacity:  1000,
+		PPB:       big.Zero(),
+	})
+	// A record we haven't refreshed in a long time is discarded
+	p1.saveRecord(n3.Host.ID(), Peer{
+		Regions:  []RegionCode{GlobalRegion},
+		LastSeen: time.Now().Add(-2 * PeerRecordTTL),
+	})
+
+	// Restart with the same datastore
+	p2 := NewPeerMgr(n1.Host, idx, []Region{global})
+	require.NoError(t, p2.loadPeers(ctx))
+
+	info, ok := p2.Peer(n2.Host.ID())
+	require.True(t, ok)
+	require.Equal(t, uint64(1000), info.Capacity)
+	require.True(t, info.Supports(HeartbeatProtocol))
+	require.Equal(t, []peer.ID{n2.Host.ID()}, p2.Peers(2, []Region{global}, nil))
+
+	_, ok = p2.Peer(n3.Host.ID())
+	require.False(t, ok)
+	recs, err := p2.records.list()
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+}
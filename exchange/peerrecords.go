@@ -0,0 +1,146 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/rs/zerolog/log"
+)
+
+// PeerRecordTTL is how long we keep the record of a peer we haven't seen
+const PeerRecordTTL = 7 * 24 * time.Hour
+
+// peerRecord is what we persist about a peer so we can dispatch and query right away after a restart
+type peerRecord struct {
+	ID    peer.ID
+	Addrs []string
+	Info  Peer
+}
+
+// peerRecordStore persists the peers in our regions
+type peerRecordStore struct {
+	ds datastore.Batching
+}
+
+func newPeerRecordStore(ds datastore.Batching) *peerRecordStore {
+	return &peerRecordStore{
+		ds: namespace.Wrap(ds, datastore.NewKey("/peers")),
+	}
+}
+
+func (ps *peerRecordStore) put(rec peerRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return ps.ds.Put(datastore.NewKey(rec.ID.String()), b)
+}
+
+func (ps *peerRecordStore) remove(p peer.ID) error {
+	return ps.ds.Delete(datastore.NewKey(p.String()))
+}
+
+func (ps *peerRecordStore) list() ([]peerRecord, error) {
+	res, err := ps.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var recs []peerRecord
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var rec peerRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			log.Error().Err(err).Str("key", r.Key).Msg("invalid peer record")
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// saveRecord persists what we know about a peer along with its current addresses
+func (pm *PeerMgr) saveRecord(p peer.ID, info Peer) {
+	addrs := pm.h.Peerstore().Addrs(p)
+	rec := peerRecord{
+		ID:    p,
+		Addrs: make([]string, len(addrs)),
+		Info:  info,
+	}
+	for i, a := range addrs {
+		rec.Addrs[i] = a.String()
+	}
+	if err := pm.records.put(rec); err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to persist peer record")
+	}
+}
+
+// forgetRecord removes the record of a peer who left
+func (pm *PeerMgr) forgetRecord(p peer.ID) {
+	if err := pm.records.remove(p); err != nil && err != datastore.ErrNotFound {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to remove peer record")
+	}
+}
+
+// inRegions returns whether any of the given regions is one of ours
+func (pm *PeerMgr) inRegions(codes []RegionCode) bool {
+	for _, c := range codes {
+		if _, ok := pm.regions[c]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPeers restores the peers we knew before restarting and reconnects to them in the background.
+// Records older than PeerRecordTTL or outside our regions are discarded. The peers who don't come back
+// are marked inactive once they miss their heartbeats.
+func (pm *PeerMgr) loadPeers(ctx context.Context) error {
+	recs, err := pm.records.list()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, rec := range recs {
+		if rec.ID == pm.h.ID() {
+			continue
+		}
+		if now.Sub(rec.Info.LastSeen) > PeerRecordTTL || !pm.inRegions(rec.Info.Regions) {
+			pm.forgetRecord(rec.ID)
+			continue
+		}
+		var addrs []ma.Multiaddr
+		for _, a := range rec.Addrs {
+			maddr, err := ma.NewMultiaddr(a)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, maddr)
+		}
+		pm.h.Peerstore().AddAddrs(rec.ID, addrs, 8*time.Hour)
+
+		pm.mu.Lock()
+		// A peer may have greeted us already
+		if _, ok := pm.peers[rec.ID]; !ok {
+			pm.peers[rec.ID] = rec.Info
+		}
+		pm.mu.Unlock()
+
+		go func(pi peer.AddrInfo) {
+			defer pm.sup.Recover("reconnect")
+			if err := pm.h.Connect(ctx, pi); err != nil {
+				log.Debug().Err(err).Str("peer", pi.ID.String()).Msg("failed to reconnect")
+			}
+		}(peer.AddrInfo{ID: rec.ID, Addrs: addrs})
+	}
+	log.Debug().Int("peers", len(recs)).Msg("loaded peer records")
+	return nil
+}
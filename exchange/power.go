@@ -0,0 +1,78 @@
+package exchange
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LowPowerMaxTransfers is the number of transfers served at once in low power mode if MaxTransfers isn't set
+const LowPowerMaxTransfers = 2
+
+// LowPowerAnnounceInterval is how often new content is announced in low power mode so announcements
+// are batched instead of waking the radio every minute
+const LowPowerAnnounceInterval = 30 * time.Minute
+
+// ErrTooManyTransfers is returned when declining a query because we are serving as many transfers as we can
+var ErrTooManyTransfers = errors.New("too many transfers in progress")
+
+// powerState keeps track of whether the host app asked us to save power, i.e. when running on battery
+// or a metered connection. Background replication is paused while saving power.
+type powerState struct {
+	// saving is 1 when saving power. Only accessed atomically.
+	saving int32
+
+	mu sync.Mutex
+	// resumed is closed when we stop saving power
+	resumed chan struct{}
+}
+
+func (ps *powerState) set(on bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&ps.saving, v)
+	if !on && ps.resumed != nil {
+		close(ps.resumed)
+		ps.resumed = nil
+	}
+}
+
+// resume returns a channel closed once we stop saving power
+func (ps *powerState) resume() <-chan struct{} {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if !ps.on() {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if ps.resumed == nil {
+		ps.resumed = make(chan struct{})
+	}
+	return ps.resumed
+}
+
+func (ps *powerState) on() bool {
+	return atomic.LoadInt32(&ps.saving) == 1
+}
+
+// SetPowerSaving pauses or resumes background replication. Apps embedding the exchange can call it when
+// the device switches to battery or to a metered connection. We keep serving retrievals either way.
+func (e *Exchange) SetPowerSaving(on bool) {
+	e.rpl.power.set(on)
+}
+
+// PowerSaving returns whether background replication is paused to save power
+func (e *Exchange) PowerSaving() bool {
+	return e.rpl.power.on()
+}
+
+// busy returns whether we already serve as many transfers as we are allowed to
+func (e *Exchange) busy() bool {
+	return e.opts.MaxTransfers > 0 && e.rtv.Provider().Load() >= uint64(e.opts.MaxTransfers)
+}
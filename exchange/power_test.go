@@ -0,0 +1,29 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPowerSaving(t *testing.T) {
+	e := &Exchange{rpl: &Replication{}}
+	require.False(t, e.PowerSaving())
+
+	e.SetPowerSaving(true)
+	require.True(t, e.PowerSaving())
+	require.True(t, e.rpl.power.on())
+
+	resumed := e.rpl.power.resume()
+	select {
+	case <-resumed:
+		t.Fatal("resumed while saving power")
+	default:
+	}
+
+	e.SetPowerSaving(false)
+	require.False(t, e.PowerSaving())
+	<-resumed
+	// we don't wait if we are not saving power
+	<-e.rpl.power.resume()
+}
@@ -0,0 +1,173 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/rs/zerolog/log"
+)
+
+// CatalogTTL is how long we remember a peer holds some content after we last heard about it
+const CatalogTTL = 24 * time.Hour
+
+// ErrInvalidRedirect is returned when a redirect hint is not signed by the provider who sent it
+var ErrInvalidRedirect = errors.New("invalid redirect")
+
+// Catalog remembers which peers hold content we don't have so we can redirect queries to them
+// instead of staying silent. It is fed by the content we dispatch and the indexes we fetch from peers.
+type Catalog struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[cid.Cid]map[peer.ID]time.Time
+}
+
+// NewCatalog creates an empty catalog forgetting entries after the given ttl
+func NewCatalog(ttl time.Duration) *Catalog {
+	return &Catalog{
+		ttl:     ttl,
+		entries: make(map[cid.Cid]map[peer.ID]time.Time),
+	}
+}
+
+// Add records that a peer holds the content for the given root
+func (c *Catalog) Add(root cid.Cid, p peer.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	peers, ok := c.entries[root]
+	if !ok {
+		peers = make(map[peer.ID]time.Time)
+		c.entries[root] = peers
+	}
+	peers[p] = time.Now()
+}
+
+// Remove forgets a peer holds the content for the given root
+func (c *Catalog) Remove(root cid.Cid, p peer.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries[root], p)
+	if len(c.entries[root]) == 0 {
+		delete(c.entries, root)
+	}
+}
+
+// Forget removes a peer from all the entries and returns the roots it was known to hold
+func (c *Catalog) Forget(p peer.ID) []cid.Cid {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var roots []cid.Cid
+	for root, peers := range c.entries {
+		if _, ok := peers[p]; !ok {
+			continue
+		}
+		roots = append(roots, root)
+		delete(peers, p)
+		if len(peers) == 0 {
+			delete(c.entries, root)
+		}
+	}
+	return roots
+}
+
+// Providers returns the peers known to hold the content for the given root, most recently seen first
+func (c *Catalog) Providers(root cid.Cid) []peer.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	peers := c.entries[root]
+	now := time.Now()
+	var ps []peer.ID
+	for p, seen := range peers {
+		if c.ttl > 0 && now.Sub(seen) > c.ttl {
+			delete(peers, p)
+			continue
+		}
+		ps = append(ps, p)
+	}
+	if len(peers) == 0 {
+		delete(c.entries, root)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return peers[ps[i]].After(peers[ps[j]])
+	})
+	return ps
+}
+
+// redirectPayload is the message signed by the provider issuing a redirect
+func redirectPayload(root cid.Cid, addr []byte) []byte {
+	return append(root.Bytes(), addr...)
+}
+
+// signRedirect sets a redirect hint to the given peer on the offer and signs it with our host key
+func signRedirect(h host.Host, offer *deal.Offer, to peer.AddrInfo) error {
+	addrs, err := peer.AddrInfoToP2pAddrs(&to)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses for peer %s", to.ID)
+	}
+	key := h.Peerstore().PrivKey(h.ID())
+	if key == nil {
+		return fmt.Errorf("no private key for host %s", h.ID())
+	}
+	offer.RedirectAddr = addrs[0].Bytes()
+	offer.RedirectSig, err = key.Sign(redirectPayload(offer.PayloadCID, offer.RedirectAddr))
+	return err
+}
+
+// VerifyRedirect checks the redirect hint of an offer was signed by the provider who sent the offer
+// and returns the address of the peer we are redirected to
+func VerifyRedirect(h host.Host, offer deal.Offer) (*peer.AddrInfo, error) {
+	issuer, err := offer.AddrInfo()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	pub, err := issuer.ID.ExtractPublicKey()
+	if err != nil || pub == nil {
+		// Keys too large to be inlined in the peer ID must have been exchanged already
+		pub = h.Peerstore().PubKey(issuer.ID)
+	}
+	if pub == nil {
+		return nil, fmt.Errorf("%w: no public key for %s", ErrInvalidRedirect, issuer.ID)
+	}
+	ok, err := pub.Verify(redirectPayload(offer.PayloadCID, offer.RedirectAddr), offer.RedirectSig)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("%w: bad signature from %s", ErrInvalidRedirect, issuer.ID)
+	}
+	to, err := offer.RedirectInfo()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	if to.ID == issuer.ID {
+		return nil, fmt.Errorf("%w: provider redirected to itself", ErrInvalidRedirect)
+	}
+	return to, nil
+}
+
+// redirect returns a signed offer pointing to a peer we know holds the content or false if we don't know any
+func (e *Exchange) redirect(p peer.ID, root cid.Cid) (deal.Offer, bool) {
+	for _, pid := range e.rpl.Catalog().Providers(root) {
+		if pid == p || pid == e.h.ID() {
+			continue
+		}
+		info := e.h.Peerstore().PeerInfo(pid)
+		if len(info.Addrs) == 0 {
+			continue
+		}
+		offer := deal.Offer{PayloadCID: root}
+		if err := signRedirect(e.h, &offer, info); err != nil {
+			log.Error().Err(err).Str("peer", pid.String()).Msg("failed to sign redirect")
+			return deal.Offer{}, false
+		}
+		return offer, true
+	}
+	return deal.Offer{}, false
+}
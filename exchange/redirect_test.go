@@ -0,0 +1,117 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalog(t *testing.T) {
+	root := blockGen.Next().Cid()
+	p1 := peer.ID("peer1")
+	p2 := peer.ID("peer2")
+
+	c := NewCatalog(time.Hour)
+	require.Len(t, c.Providers(root), 0)
+
+	c.Add(root, p1)
+	time.Sleep(time.Millisecond)
+	c.Add(root, p2)
+	require.Equal(t, []peer.ID{p2, p1}, c.Providers(root))
+
+	c.Remove(root, p2)
+	require.Equal(t, []peer.ID{p1}, c.Providers(root))
+
+	// expired entries are forgotten
+	c.entries[root][p1] = time.Now().Add(-2 * time.Hour)
+	require.Len(t, c.Providers(root), 0)
+}
+
+func TestCatalogForget(t *testing.T) {
+	r1 := blockGen.Next().Cid()
+	r2 := blockGen.Next().Cid()
+	p1 := peer.ID("peer1")
+	p2 := peer.ID("peer2")
+
+	c := NewCatalog(time.Hour)
+	c.Add(r1, p1)
+	c.Add(r1, p2)
+	c.Add(r2, p1)
+	c.Add(r2, p2)
+	c.Remove(r2, p1)
+
+	require.Equal(t, []cid.Cid{r1}, c.Forget(p1))
+	require.Equal(t, []peer.ID{p2}, c.Providers(r1))
+	require.Len(t, c.Forget(p1), 0)
+}
+
+func TestCatalogIndexPrivate(t *testing.T) {
+	// the index of a peer holding public and private content
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	remote, err := NewIndex(ds, bs)
+	require.NoError(t, err)
+	public := blockGen.Next().Cid()
+	private := blockGen.Next().Cid()
+	require.NoError(t, remote.SetRef(&DataRef{PayloadCID: public, PayloadSize: 100}))
+	require.NoError(t, remote.SetRef(&DataRef{PayloadCID: private, PayloadSize: 100, Private: true}))
+
+	idx, err := NewIndex(dss.MutexWrap(datastore.NewMapDatastore()), blockstore.NewBlockstore(ds))
+	require.NoError(t, err)
+	r := &Replication{idx: idx, catalog: NewCatalog(time.Hour)}
+	p := peer.ID("peer1")
+	require.NoError(t, r.catalogIndex(remote.Root(), p, cbor.NewCborStore(bs)))
+
+	require.Equal(t, []peer.ID{p}, r.catalog.Providers(public))
+	require.Len(t, r.catalog.Providers(private), 0)
+}
+
+func TestVerifyRedirect(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	issuer := testutil.NewTestNode(mn, t)
+	target := testutil.NewTestNode(mn, t)
+	client := testutil.NewTestNode(mn, t)
+
+	// public keys are exchanged when peers connect
+	for _, n := range []*testutil.TestNode{issuer, target} {
+		require.NoError(t, client.Host.Peerstore().AddPubKey(n.Host.ID(), n.Host.Peerstore().PubKey(n.Host.ID())))
+	}
+
+	root := blockGen.Next().Cid()
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: issuer.Host.ID(), Addrs: issuer.Host.Addrs()})
+	require.NoError(t, err)
+
+	offer := deal.Offer{
+		PeerAddr:   addrs[0].Bytes(),
+		PayloadCID: root,
+	}
+	require.NoError(t, signRedirect(issuer.Host, &offer, peer.AddrInfo{ID: target.Host.ID(), Addrs: target.Host.Addrs()}))
+	require.True(t, offer.IsRedirect())
+
+	to, err := VerifyRedirect(client.Host, offer)
+	require.NoError(t, err)
+	require.Equal(t, target.Host.ID(), to.ID)
+
+	// a redirect for a different root doesn't match the signature
+	forged := offer
+	forged.PayloadCID = blockGen.Next().Cid()
+	_, err = VerifyRedirect(client.Host, forged)
+	require.ErrorIs(t, err, ErrInvalidRedirect)
+
+	// a redirect signed by another peer is rejected
+	forged = offer
+	require.NoError(t, signRedirect(target.Host, &forged, peer.AddrInfo{ID: client.Host.ID(), Addrs: client.Host.Addrs()}))
+	_, err = VerifyRedirect(client.Host, forged)
+	require.ErrorIs(t, err, ErrInvalidRedirect)
+}
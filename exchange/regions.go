@@ -165,6 +165,16 @@ func ParseRegions(list []string) []Region {
 	return regions
 }
 
+// RegionName returns the name of a preset region for the given code or "Custom" for user defined regions
+func RegionName(code RegionCode) string {
+	for name, r := range Regions {
+		if r.Code == code {
+			return name
+		}
+	}
+	return "Custom"
+}
+
 // RegionFromTopic formats a topic string into a Region struct
 func RegionFromTopic(topic string) Region {
 	_, name := path.Split(topic)
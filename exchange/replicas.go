@@ -0,0 +1,239 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplicaSet keeps track of the peers holding a ref we published so we can maintain its replication factor
+type ReplicaSet struct {
+	PayloadCID cid.Cid
+	RF         int
+	Holders    []peer.ID
+}
+
+// has returns whether a peer holds a replica
+func (rs *ReplicaSet) has(p peer.ID) bool {
+	for _, h := range rs.Holders {
+		if h == p {
+			return true
+		}
+	}
+	return false
+}
+
+// remove forgets the replica of a peer and returns whether it held one
+func (rs *ReplicaSet) remove(p peer.ID) bool {
+	for i, h := range rs.Holders {
+		if h == p {
+			rs.Holders = append(rs.Holders[:i], rs.Holders[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// copy returns a copy of the replica set safe to use without holding the lock
+func (rs *ReplicaSet) copy() ReplicaSet {
+	c := *rs
+	c.Holders = append([]peer.ID(nil), rs.Holders...)
+	return c
+}
+
+// replicaStore persists our replica sets so we keep maintaining them after a restart
+type replicaStore struct {
+	ds datastore.Batching
+}
+
+func newReplicaStore(ds datastore.Batching) *replicaStore {
+	return &replicaStore{
+		ds: namespace.Wrap(ds, datastore.NewKey("/replicas")),
+	}
+}
+
+func (rs *replicaStore) put(set ReplicaSet) error {
+	b, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return rs.ds.Put(datastore.NewKey(set.PayloadCID.String()), b)
+}
+
+func (rs *replicaStore) remove(root cid.Cid) error {
+	return rs.ds.Delete(datastore.NewKey(root.String()))
+}
+
+func (rs *replicaStore) list() ([]ReplicaSet, error) {
+	res, err := rs.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var sets []ReplicaSet
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var set ReplicaSet
+		if err := json.Unmarshal(r.Value, &set); err != nil {
+			log.Error().Err(err).Str("key", r.Key).Msg("invalid replica set")
+			continue
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// loadReplicas restores the replica sets persisted before we restarted
+func (r *Replication) loadReplicas() error {
+	sets, err := r.rstore.list()
+	if err != nil {
+		return err
+	}
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	for i := range sets {
+		set := sets[i]
+		r.replicas[set.PayloadCID] = &set
+	}
+	return nil
+}
+
+// saveReplicas persists a replica set, it must be called with the lock held
+func (r *Replication) saveReplicas(set *ReplicaSet) {
+	if err := r.rstore.put(*set); err != nil {
+		log.Error().Err(err).Str("root", set.PayloadCID.String()).Msg("failed to persist replica set")
+	}
+}
+
+// trackReplicas records the replication factor we want for a ref. Replacing replicas dispatches with a lower
+// factor which doesn't change the one we wanted.
+func (r *Replication) trackReplicas(root cid.Cid, rf int) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	set, ok := r.replicas[root]
+	if !ok {
+		set = &ReplicaSet{PayloadCID: root}
+		r.replicas[root] = set
+	}
+	if rf > set.RF {
+		set.RF = rf
+		r.saveReplicas(set)
+	}
+}
+
+// addReplica records a peer holds a replica of a ref we track
+func (r *Replication) addReplica(root cid.Cid, p peer.ID) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	set, ok := r.replicas[root]
+	if !ok || set.has(p) {
+		return
+	}
+	set.Holders = append(set.Holders, p)
+	r.saveReplicas(set)
+}
+
+// removeReplica forgets the replica of a peer and returns whether it held one
+func (r *Replication) removeReplica(root cid.Cid, p peer.ID) bool {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	set, ok := r.replicas[root]
+	if !ok || !set.remove(p) {
+		return false
+	}
+	r.saveReplicas(set)
+	return true
+}
+
+// forgetHolder removes a peer from all our replica sets and returns the roots it held
+func (r *Replication) forgetHolder(p peer.ID) []cid.Cid {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	var roots []cid.Cid
+	for root, set := range r.replicas {
+		if set.remove(p) {
+			r.saveReplicas(set)
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// dropReplicas stops tracking the replicas of a ref
+func (r *Replication) dropReplicas(root cid.Cid) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	if _, ok := r.replicas[root]; !ok {
+		return
+	}
+	delete(r.replicas, root)
+	if err := r.rstore.remove(root); err != nil {
+		log.Error().Err(err).Str("root", root.String()).Msg("failed to remove replica set")
+	}
+}
+
+// Replicas returns the peers holding a ref we published and the replication factor we maintain for it
+func (r *Replication) Replicas(root cid.Cid) (ReplicaSet, bool) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	set, ok := r.replicas[root]
+	if !ok {
+		return ReplicaSet{}, false
+	}
+	return set.copy(), true
+}
+
+// replicaSets returns a copy of all our replica sets
+func (r *Replication) replicaSets() []ReplicaSet {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	sets := make([]ReplicaSet, 0, len(r.replicas))
+	for _, set := range r.replicas {
+		sets = append(sets, set.copy())
+	}
+	return sets
+}
+
+// restoreReplicas dispatches a ref we published to other peers in our regions if it is below its replication
+// factor after losing the replica of the given peer. Subscribers are notified so the publisher knows about it.
+// It returns the number of replicas requested.
+func (r *Replication) restoreReplicas(root cid.Cid, lost peer.ID) int {
+	set, ok := r.Replicas(root)
+	if !ok {
+		return 0
+	}
+	missing := set.RF - len(set.Holders)
+	if missing <= 0 {
+		return 0
+	}
+	// We can only dispatch the content if we still hold it
+	ref, err := r.idx.PeekRef(root)
+	if err != nil {
+		return 0
+	}
+	r.publish(ReplicationEventReplicaLost, ReplicationState{
+		PayloadCID: root,
+		Peer:       lost,
+		Size:       uint64(ref.PayloadSize),
+		Message:    fmt.Sprintf("%d of %d replicas left", len(set.Holders), set.RF),
+	})
+	opts := DefaultDispatchOptions
+	opts.RF = missing
+	opts.FromBlockstore = true
+	opts.Exclude = append(set.Holders, lost)
+	opts.Private = ref.Private
+	if _, err := r.Dispatch(root, uint64(ref.PayloadSize), opts); err != nil {
+		log.Error().Err(err).Str("root", root.String()).Msg("failed to replace replicas")
+		return 0
+	}
+	log.Info().Str("peer", lost.String()).Str("root", root.String()).Int("replicas", missing).Msg("replacing replicas")
+	return missing
+}
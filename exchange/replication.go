@@ -2,14 +2,17 @@ package exchange
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync/storeutil"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
@@ -24,25 +27,34 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/payments"
 	sel "github.com/myelnet/pop/selectors"
+	"github.com/myelnet/pop/wallet"
 	"github.com/rs/zerolog/log"
+	cbg "github.com/whyrusleeping/cbor-gen"
 )
 
 //go:generate cbor-gen-for Request
 
-// PopRequestProtocolID is the protocol for requesting caches to store new content
-const PopRequestProtocolID = protocol.ID("/myel/pop/request/1.0")
+// ErrNoDispatchItems is returned when dispatching an empty batch
+var ErrNoDispatchItems = errors.New("no roots to dispatch")
+
+// PopRequestProtocolID is the latest version of the protocol for requesting caches to store new content
+const PopRequestProtocolID = PopRequestProtocolV2
 
 // Request describes the content to pull
 type Request struct {
 	Method     Method
 	PayloadCID cid.Cid
 	Size       uint64
+	// Token authorizes the recipient of a dispatch to pull the content from us
+	Token *PullToken
 }
 
-// Type defines Request as a datatransfer voucher for pulling the data from the request
+// Type defines Request as a datatransfer voucher for pulling the data from the request.
+// 1.0 caches use RequestV1 under the original identifier.
 func (Request) Type() datatransfer.TypeIdentifier {
-	return "ReplicationRequestVoucher"
+	return "ReplicationRequestVoucher/1.1"
 }
 
 // Method is the replication request method
@@ -53,6 +65,8 @@ const (
 	Dispatch Method = iota
 	// FetchIndex is a request from one content provider to another to retrieve their index
 	FetchIndex
+	// Pull is a request from a trusted peer to retrieve any of our content without payment
+	Pull
 )
 
 // IndexEvt is emitted when a new index is loaded in the replication service
@@ -65,20 +79,42 @@ type RequestStream struct {
 	p   peer.ID
 	rw  mux.MuxedStream
 	buf *bufio.Reader
+	// proto is the version of the request protocol negotiated with the other peer
+	proto protocol.ID
 }
 
 // ReadRequest reads and decodes a CBOR encoded Request message from a stream buffer
 func (rs *RequestStream) ReadRequest() (Request, error) {
-	var m Request
-	if err := m.UnmarshalCBOR(rs.buf); err != nil {
-		return Request{}, err
-	}
-	return m, nil
+	return DecodeRequestVersion(rs.buf, rs.proto)
 }
 
 // WriteRequest encodes and writes a Request message to a stream
 func (rs *RequestStream) WriteRequest(m Request) error {
-	return cborutil.WriteCborRPC(rs.rw, &m)
+	return writeRequest(rs.rw, rs.proto, m)
+}
+
+// DispatchExtras are sent along with a dispatch request by version 2.0 of the request protocol
+type DispatchExtras struct {
+	// Incentive is offered for holding the content if any
+	Incentive *Incentive
+	// Private content is not listed on the public index of the caches
+	Private bool
+}
+
+// ReadDispatch reads a Request message and the extras sent along with it if any
+func (rs *RequestStream) ReadDispatch() (Request, DispatchExtras, error) {
+	return DecodeDispatch(rs.buf, rs.proto)
+}
+
+// WriteDispatch encodes and writes a Request message with its extras. The extras are dropped if the
+// other peer speaks a version of the protocol which cannot carry them.
+func (rs *RequestStream) WriteDispatch(m Request, ext DispatchExtras) error {
+	return writeDispatch(rs.rw, rs.proto, m, ext)
+}
+
+// Protocol returns the version of the request protocol negotiated with the other peer
+func (rs *RequestStream) Protocol() protocol.ID {
+	return rs.proto
 }
 
 // Close the stream
@@ -111,17 +147,74 @@ type Replication struct {
 	indexRcvd chan struct{}
 	interval  time.Duration
 	rtv       RoutedRetriever
-
-	pmu   sync.Mutex
-	pulls map[cid.Cid]*peer.Set
+	rqv       *RequestValidator
+	sup       *utils.Supervisor
+	// stallTimeout is the duration without progress after which we close a transfer channel
+	stallTimeout time.Duration
+	// catalog remembers which peers hold the content we dispatched or found in their index
+	catalog *Catalog
+	// trust is the allowlist of peers who may pull our content for free
+	trust *Allowlist
+	// power pauses background replication when the host app asks us to save power
+	power powerState
+	// subscribers listen to the progress of our dispatches
+	subscribers *pubsub.PubSub
+	// pulls persists the dispatch pulls in progress so they can be restarted
+	pulls *pullStore
+	// verify checks the content we pull is complete before adding it to our index
+	verify bool
+	// summary keeps track of the content recently queried or dispatched in our regions
+	summary *RegionSummary
+	// pay and wallet send and receive incentive payments, incentives are ignored if either is nil
+	pay    payments.Manager
+	wallet wallet.Driver
+	// offered persists the incentives we offered to the peers who pulled our dispatches
+	offered *incentiveStore
+	// claims persists the incentives we are owed for the content dispatched to us
+	claims *incentiveStore
+	// tallies persists the mutual debts we net with frequent peers instead of paying each incentive
+	tallies *tallyStore
+	// nettingInterval is the interval at which we settle our tallies, we pay every incentive right away if not positive
+	nettingInterval time.Duration
+	// sched limits the transfers we serve at once for our dispatches
+	sched *DispatchScheduler
+	// members keeps track of the schemes we joined and of the peers who joined ours
+	members *Membership
+	// strikes blocks the peers sending us too many invalid messages
+	strikes *Strikes
+	// revocations are the pull tokens of cancelled dispatches
+	revocations *tokenRevocations
+	// legacy holds the pull tokens issued to 1.0 caches which cannot carry them
+	legacy *legacyTokens
+	// limits enforces the byte limit of the pull tokens during transfers
+	limits *pullLimits
+	// auditInterval is the interval at which we audit the caches holding our content, 0 disables audits
+	auditInterval time.Duration
+	// rep scores the caches failing our audits
+	rep *Reputation
+
+	rmu sync.Mutex
+	// replicas are the peers holding the content we dispatched so we can replace the replicas we lose
+	replicas map[cid.Cid]*ReplicaSet
+	rstore   *replicaStore
 
 	smu    sync.Mutex
 	stores map[cid.Cid]*multistore.Store
+	// storeIDs keeps the ID of each store in use so they aren't reaped
+	storeIDs map[cid.Cid]multistore.StoreID
 }
 
 // NewReplication starts the exchange replication management system
 func NewReplication(h host.Host, idx *Index, dt datatransfer.Manager, rtv RoutedRetriever, opts Options) (*Replication, error) {
 	pm := NewPeerMgr(h, idx, opts.Regions)
+	pm.sup = opts.Supervisor
+	trust := NewAllowlist(opts.TrustedPeers, opts.FleetKey)
+	pm.trust = trust
+	var strikes *Strikes
+	if opts.Gater != nil {
+		strikes = NewStrikes(h, opts.Gater, trust, idx.ds)
+	}
+	pm.strikes = strikes
 	r := &Replication{
 		h:         h,
 		pm:        pm,
@@ -132,22 +225,69 @@ func NewReplication(h host.Host, idx *Index, dt datatransfer.Manager, rtv Routed
 		ms:        opts.MultiStore,
 		bs:        opts.Blockstore,
 		interval:  opts.ReplInterval,
-		reqProtos: []protocol.ID{PopRequestProtocolID},
-		pulls:     make(map[cid.Cid]*peer.Set),
+		reqProtos: RequestProtocols,
 		indexRcvd: make(chan struct{}),
 		stores:    make(map[cid.Cid]*multistore.Store),
+		storeIDs:  make(map[cid.Cid]multistore.StoreID),
+		sup:       opts.Supervisor,
+
+		stallTimeout: opts.StallTimeout,
+		catalog:      NewCatalog(CatalogTTL),
+		trust:        trust,
+		strikes:      strikes,
+		revocations:  newTokenRevocations(idx.ds),
+		limits:       newPullLimits(),
+		legacy:       newLegacyTokens(),
+		subscribers:  pubsub.New(replicationDispatcher),
+		pulls:        newPullStore(idx.ds),
+		verify:       opts.VerifyTransfers,
+		summary:      NewRegionSummary(SummaryWindow),
+		wallet:       opts.Wallet,
+		offered:      newIncentiveStore(idx.ds, "/incentives/offered"),
+		claims:       newIncentiveStore(idx.ds, "/incentives/claims"),
+		tallies:      newTallyStore(idx.ds),
+		sched:        NewDispatchScheduler(opts.MaxDispatchTransfers),
+		members:      NewMembership(),
+		replicas:     make(map[cid.Cid]*ReplicaSet),
+		rstore:       newReplicaStore(idx.ds),
+
+		auditInterval:   opts.AuditInterval,
+		nettingInterval: opts.NettingInterval,
 	}
-	h.SetStreamHandler(PopRequestProtocolID, r.handleRequest)
+	// let subscribers know when content leaves our index
+	WithDropFunc(r.refDropped)(idx)
+	r.rqv = NewRequestValidator(idx, pm, opts.DispatchPolicy)
+	for _, proto := range RequestProtocols {
+		h.SetStreamHandler(proto, r.handleRequest)
+	}
+	h.SetStreamHandler(AuditProtocolID, r.handleAudit)
+	h.SetStreamHandler(IncentiveProtocolID, r.handleIncentive)
+	h.SetStreamHandler(TallyProtocolID, r.handleTally)
+	h.SetStreamHandler(TallySettleProtocolID, r.handleSettlement)
 
 	err := r.dt.RegisterVoucherType(&Request{}, r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register voucher type: %v", err)
 	}
+	err = r.dt.RegisterVoucherType(&RequestV1{}, &legacyValidator{r})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register legacy voucher type: %v", err)
+	}
+
+	err = r.dt.RegisterRevalidator(&Request{}, r.limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register revalidator: %v", err)
+	}
 
-	err = r.dt.RegisterTransportConfigurer(&Request{}, TransportConfigurer(r.idx, r, h.ID()))
+	configurer := TransportConfigurer(r.idx, r, h.ID(), r.bs)
+	err = r.dt.RegisterTransportConfigurer(&Request{}, configurer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register transport configurer: %v", err)
 	}
+	err = r.dt.RegisterTransportConfigurer(&RequestV1{}, configurer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register legacy transport configurer: %v", err)
+	}
 
 	emitter, err := h.EventBus().Emitter(new(IndexEvt))
 	if err != nil {
@@ -167,72 +307,159 @@ func (r *Replication) Start(ctx context.Context) error {
 	// Any time we receive a new index, check if any refs should be added to our supply
 	// if interval is 0 the feature is deactivated
 	if r.interval > 0 {
-		go r.refreshIndex(ctx)
-		go r.pumpIndexes(ctx, sub)
+		r.sup.Go(ctx, "refresh-index", r.refreshIndex)
+		r.sup.Go(ctx, "pump-indexes", func(ctx context.Context) {
+			r.pumpIndexes(ctx, sub)
+		})
 	}
 	if err := r.pm.Run(ctx); err != nil {
 		return err
 	}
+	// Resume the dispatch pulls interrupted when we stopped or when the dispatching peer went offline
+	r.restartOnConnect(ctx)
+	go r.restartPulls(ctx, "")
+	if err := r.loadReplicas(); err != nil {
+		log.Error().Err(err).Msg("failed to load replica sets")
+	}
+	if r.payable() {
+		r.sup.Go(ctx, "incentive-claims", r.claimIncentives)
+		if r.nettingInterval > 0 {
+			r.sup.Go(ctx, "tally-settlements", r.settleTallies)
+		}
+	}
+	if r.auditInterval > 0 {
+		r.sup.Go(ctx, "audits", r.runAudits)
+	}
+	byes, err := r.h.EventBus().Subscribe(new(GoodbyeEvt), eventbus.BufSize(16))
+	if err != nil {
+		return err
+	}
+	r.sup.Go(ctx, "goodbyes", func(ctx context.Context) {
+		for {
+			select {
+			case evt := <-byes.Out():
+				r.replaceReplicas(evt.(GoodbyeEvt).Peer)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
 	return nil
 }
 
+// Leave tells the peers in our regions we are shutting down so they can replace the content we hold
+func (r *Replication) Leave(ctx context.Context) {
+	r.pm.SayGoodbye(ctx, "shutting down")
+}
+
+// replaceReplicas dispatches the content we dispatched to a peer who left to other peers in our regions
+// if it is now below its replication factor
+func (r *Replication) replaceReplicas(p peer.ID) {
+	r.members.Remove(p)
+	r.catalog.Forget(p)
+	for _, root := range r.forgetHolder(p) {
+		r.restoreReplicas(root, p)
+	}
+}
+
 // pumpIndexes iterates over a subscription to new Hey msg received when connecting with other provider peers
 // it keeps index roots into a queue and iteratively fetches them. We could potentially fetch them in parallel
 // but we ideally don't want this to be a burden on the node resources so we take it easy
 func (r *Replication) pumpIndexes(ctx context.Context, sub event.Subscription) {
 	var q []HeyEvt
 	var fetchDone chan fetchResult
+	// paused are the latest greetings of each peer received while saving power
+	var paused []HeyEvt
+	// resumed is closed once we stop saving power if we have paused greetings
+	var resumed <-chan struct{}
+	fetch := func(hevt HeyEvt) {
+		done := make(chan fetchResult, 1)
+		fetchDone = done
+		go func() {
+			err := r.fetchIndex(ctx, hevt)
+			done <- fetchResult{*hevt.IndexRoot, hevt.Peer, err}
+		}()
+	}
+	enqueue := func(hevt HeyEvt) {
+		if fetchDone == nil {
+			fetch(hevt)
+			return
+		}
+		q = append(q, hevt)
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case evt := <-sub.Out():
 			hevt := evt.(HeyEvt)
-			if hevt.IndexRoot != nil {
-				if fetchDone == nil {
-					fetchDone = make(chan fetchResult, 1)
-					go func() {
-						err := r.fetchIndex(ctx, hevt)
-						fetchDone <- fetchResult{*hevt.IndexRoot, err}
-					}()
-					continue
+			if hevt.IndexRoot == nil {
+				continue
+			}
+			// Fetching indexes is only useful for replication which is paused so we wait until it resumes
+			if r.power.on() {
+				paused = withHey(paused, hevt)
+				if resumed == nil {
+					resumed = r.power.resume()
 				}
-				q = append(q, hevt)
+				continue
 			}
-			// We can probably ignore errors
+			enqueue(hevt)
+		case <-resumed:
+			resumed = nil
+			for _, hevt := range paused {
+				enqueue(hevt)
+			}
+			paused = nil
 		case res := <-fetchDone:
+			fetchDone = nil
+			// We can probably ignore errors
 			if res.err == nil {
-				go func(rt cid.Cid) {
+				// Replicating the content of the peer's index makes us part of its scheme
+				r.members.Join(res.peer, r.peerRegions(res.peer))
+				go func(rt cid.Cid, p peer.ID) {
 					store := r.GetStore(rt)
 					err := r.idx.LoadInterest(rt, cbor.NewCborStore(store.Bstore))
 					if err != nil {
 						log.Error().Err(err).Msg("failed to load interest")
 						return
 					}
-				}(res.root)
+					if err := r.catalogIndex(rt, p, cbor.NewCborStore(store.Bstore)); err != nil {
+						log.Error().Err(err).Msg("failed to catalog index")
+					}
+				}(res.root, res.peer)
 			}
 
 			if len(q) > 0 {
-				fetchDone = make(chan fetchResult, 1)
-				go func(hvt HeyEvt) {
-					err := r.fetchIndex(ctx, hvt)
-					fetchDone <- fetchResult{*hvt.IndexRoot, err}
-				}(q[0])
+				fetch(q[0])
 				q = q[1:]
 			}
+		}
+	}
+}
 
+// withHey adds a greeting to the list replacing any older greeting from the same peer
+func withHey(heys []HeyEvt, hevt HeyEvt) []HeyEvt {
+	for i, h := range heys {
+		if h.Peer == hevt.Peer {
+			heys[i] = hevt
+			return heys
 		}
 	}
+	return append(heys, hevt)
 }
 
 // refreshIndex is a long running process that regularly inspects received indexes
-// and if usage is high enough retrieves the content at market price
+// and if usage is high enough pulls the content from our fleet or retrieves it at market price
 func (r *Replication) refreshIndex(ctx context.Context) {
 	ticker := time.NewTicker(r.interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
+			if r.power.on() {
+				continue
+			}
 			refs, err := r.idx.Interesting()
 			if err != nil || len(refs) == 0 {
 				continue
@@ -240,8 +467,11 @@ func (r *Replication) refreshIndex(ctx context.Context) {
 			log.Info().Str("hostId", r.h.ID().String()).Int("refs", len(refs)).Msg("tick")
 
 			for ref := range refs {
-				// let's get it
-				err := r.rtv.FindAndRetrieve(ctx, ref.PayloadCID)
+				// let's get it, for free if our fleet has it
+				err := r.pullFromFleet(ctx, ref.PayloadCID)
+				if err != nil {
+					err = r.rtv.FindAndRetrieve(ctx, ref.PayloadCID)
+				}
 				if err != nil {
 					continue
 				}
@@ -263,12 +493,74 @@ func (r *Replication) refreshIndex(ctx context.Context) {
 	}
 }
 
-// fetchResult associates the root of the index fetched and a possible error
+// fetchResult associates the root of the index fetched, the peer it belongs to and a possible error
 type fetchResult struct {
 	root cid.Cid
+	peer peer.ID
 	err  error
 }
 
+// catalogIndex records all the content in the index of a peer so we can redirect queries to them
+func (r *Replication) catalogIndex(root cid.Cid, p peer.ID, store cbor.IpldStore) error {
+	nd, err := r.idx.LoadRoot(root, store)
+	if err != nil {
+		return err
+	}
+	return nd.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
+		ref := new(DataRef)
+		if err := ref.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			return err
+		}
+		// private content is never served to other peers so we don't redirect anyone to it
+		if ref.Private {
+			return nil
+		}
+		r.catalog.Add(ref.PayloadCID, p)
+		return nil
+	})
+}
+
+// Catalog returns the catalog of content held by other peers
+func (r *Replication) Catalog() *Catalog {
+	return r.catalog
+}
+
+// Scheduler returns the scheduler of our dispatch transfers
+func (r *Replication) Scheduler() *DispatchScheduler {
+	return r.sched
+}
+
+// Summary returns the summary of content recently seen in our regions
+func (r *Replication) Summary() *RegionSummary {
+	return r.summary
+}
+
+// Membership returns the replication schemes we joined and the peers who joined ours
+func (r *Replication) Membership() *Membership {
+	return r.members
+}
+
+// peerRegions returns the regions a peer advertised if we know about it
+func (r *Replication) peerRegions(p peer.ID) []RegionCode {
+	if pr, ok := r.pm.Peer(p); ok {
+		return pr.Regions
+	}
+	return nil
+}
+
+// observeDispatch records a dispatch request in the summary of each region we share with the peer
+func (r *Replication) observeDispatch(p peer.ID, req Request) {
+	pr, ok := r.pm.Peer(p)
+	if !ok {
+		return
+	}
+	for _, rc := range pr.Regions {
+		if rg, ok := r.pm.regions[rc]; ok {
+			r.summary.ObserveDispatch(rg.Name, req.PayloadCID, req.Size)
+		}
+	}
+}
+
 // fetchIndex handles the data transfer for retrieving the index of a given peer announced in a Hey
 // msg. It blocks until the transfer is completed or fails.
 func (r *Replication) fetchIndex(ctx context.Context, hvt HeyEvt) error {
@@ -282,7 +574,7 @@ func (r *Replication) fetchIndex(ctx context.Context, hvt HeyEvt) error {
 		return err
 	}
 
-	chid, err := r.dt.OpenPullDataChannel(ctx, hvt.Peer, &req, rcid, sel.Hamt())
+	chid, err := r.dt.OpenPullDataChannel(ctx, hvt.Peer, voucherFor(req, r.legacyPeer(hvt.Peer)), rcid, sel.Hamt())
 	if err != nil {
 		return err
 	}
@@ -303,15 +595,117 @@ func (r *Replication) fetchIndex(ctx context.Context, hvt HeyEvt) error {
 	}
 }
 
+// Pull retrieves the content for the given root from a peer of our fleet or with a token the peer issued us
+// into the store with the given ID without payment. It blocks until the transfer is completed or fails.
+func (r *Replication) Pull(ctx context.Context, p peer.ID, root cid.Cid, sid multistore.StoreID, tok *PullToken) error {
+	req := Request{
+		Method:     Pull,
+		PayloadCID: root,
+		Token:      tok,
+	}
+
+	if err := r.AddStore(root, sid); err != nil {
+		return err
+	}
+	defer r.RmStore(root)
+
+	chid, err := r.dt.OpenPullDataChannel(ctx, p, voucherFor(req, r.legacyPeer(p)), root, sel.All())
+	if err != nil {
+		return err
+	}
+
+	for {
+		state, err := r.dt.ChannelState(ctx, chid)
+		if err != nil {
+			return err
+		}
+		switch state.Status() {
+		case datatransfer.Failed:
+			return fmt.Errorf("data transfer failed: %s", state.Message())
+		case datatransfer.Cancelled:
+			return fmt.Errorf("data transfer cancelled: %s", state.Message())
+		case datatransfer.Completed:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// ErrNoFleetProvider is returned when no peer of our fleet is known to hold some content
+var ErrNoFleetProvider = errors.New("no fleet peer holds the content")
+
+// pullFromFleet pulls content for free from the peers of our fleet our catalog knows hold it
+func (r *Replication) pullFromFleet(ctx context.Context, root cid.Cid) error {
+	for _, p := range r.catalog.Providers(root) {
+		if !r.trust.Trusted(p) {
+			continue
+		}
+		err := r.pullRef(ctx, p, root)
+		if err == nil {
+			return nil
+		}
+		log.Debug().Err(err).Str("peer", p.String()).Str("root", root.String()).Msg("failed to pull from fleet")
+	}
+	return ErrNoFleetProvider
+}
+
+// pullRef pulls the content for a root from a peer and adds it to our index once verified
+func (r *Replication) pullRef(ctx context.Context, p peer.ID, root cid.Cid) error {
+	sid := r.ms.Next()
+	defer func() {
+		if err := r.ms.Delete(sid); err != nil {
+			log.Error().Err(err).Msg("error when deleting store")
+		}
+	}()
+	if err := r.Pull(ctx, p, root, sid, nil); err != nil {
+		return err
+	}
+	store, err := r.ms.Get(sid)
+	if err != nil {
+		return err
+	}
+	if err := r.verifyTransfer(ctx, p, root, sel.All(), store.Bstore, 0); err != nil {
+		return err
+	}
+	stat, err := utils.Stat(ctx, store, root, sel.All())
+	if err != nil {
+		return err
+	}
+	keys, err := utils.MapLoadableKeys(ctx, root, store.Loader)
+	if err != nil {
+		log.Debug().Err(err).Msg("error when loading keys")
+	}
+	// blocks are migrated first so the index can count the ones the ref links to
+	if err := utils.MigrateBlocks(ctx, store.Bstore, r.bs); err != nil {
+		return err
+	}
+	return r.idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: int64(stat.Size),
+		Keys:        keys.AsBytes(),
+	})
+}
+
+// Trusted returns whether a peer may pull our content for free
+func (r *Replication) Trusted(p peer.ID) bool {
+	return r.trust.Trusted(p)
+}
+
 // AddStore assigns a store for a given root cid and store ID
 func (r *Replication) AddStore(k cid.Cid, sid multistore.StoreID) error {
+	// hold the lock while creating the store so it cannot be reaped before we track it
+	r.smu.Lock()
+	defer r.smu.Unlock()
 	store, err := r.ms.Get(sid)
 	if err != nil {
 		return err
 	}
-	r.smu.Lock()
 	r.stores[k] = store
-	r.smu.Unlock()
+	r.storeIDs[k] = sid
 	return nil
 }
 
@@ -327,6 +721,27 @@ func (r *Replication) RmStore(k cid.Cid) {
 	r.smu.Lock()
 	defer r.smu.Unlock()
 	delete(r.stores, k)
+	delete(r.storeIDs, k)
+}
+
+// StoreIDs returns the IDs of the stores currently used for transfers including the ones
+// of interrupted pulls waiting to be restarted
+func (r *Replication) StoreIDs() []multistore.StoreID {
+	r.smu.Lock()
+	ids := make([]multistore.StoreID, 0, len(r.storeIDs))
+	for _, id := range r.storeIDs {
+		ids = append(ids, id)
+	}
+	r.smu.Unlock()
+
+	pulls, err := r.pulls.list()
+	if err != nil {
+		log.Error().Err(err).Msg("error when listing pending pulls")
+	}
+	for _, pp := range pulls {
+		ids = append(ids, pp.StoreID)
+	}
+	return ids
 }
 
 // balanceIndex checks if any content in the interest list is more popular than content in the supply
@@ -339,25 +754,31 @@ func (r *Replication) NewRequestStream(dest peer.ID) (*RequestStream, error) {
 		return nil, err
 	}
 	buf := bufio.NewReaderSize(s, 16)
-	return &RequestStream{p: dest, rw: s, buf: buf}, nil
+	return &RequestStream{p: dest, rw: s, buf: buf, proto: s.Protocol()}, nil
 }
 
 func (r *Replication) handleRequest(s network.Stream) {
+	defer r.sup.Recover("request-handler")
 	p := s.Conn().RemotePeer()
 	buffered := bufio.NewReaderSize(s, 16)
-	rs := &RequestStream{p, s, buffered}
+	rs := &RequestStream{p, s, buffered, s.Protocol()}
 	defer rs.Close()
-	req, err := rs.ReadRequest()
+	req, ext, err := rs.ReadDispatch()
 	if err != nil {
 		log.Error().Err(err).Msg("error when reading stream request")
+		r.strikes.Record(p, err)
 		return
 	}
+	inc := ext.Incentive
+	// We cannot redeem an incentive without payments
+	if !r.payable() {
+		inc = nil
+	}
 
 	// Only the dispatch method is streamed directly at this time
 	switch req.Method {
 	case Dispatch:
-		// TODO: validate request
-
+		r.observeDispatch(p, req)
 		// Check if we may already have this content
 		// TODO: create RefExists method
 		_, err := r.idx.GetRef(req.PayloadCID)
@@ -365,64 +786,154 @@ func (r *Replication) handleRequest(s network.Stream) {
 			return
 		}
 
+		// We don't take new content while saving power
+		if r.power.on() {
+			return
+		}
+
+		if err := r.rqv.ValidateWithIncentive(p, req, inc); err != nil {
+			log.Debug().Err(err).Str("peer", p.String()).Msg("invalid dispatch request")
+			return
+		}
+
 		// Create a new store to receive our new blocks
 		// It will be automatically picked up in the TransportConfigurer
 		sid := r.ms.Next()
 		if err := r.AddStore(req.PayloadCID, sid); err != nil {
+			r.rqv.Release(p, req)
 			log.Error().Err(err).Msg("error when creating new store")
 			return
 		}
 
 		ctx := context.Background()
-		chid, err := r.dt.OpenPullDataChannel(ctx, p, &req, req.PayloadCID, sel.All())
+		chid, err := r.dt.OpenPullDataChannel(ctx, p, voucherFor(req, rs.proto == PopRequestProtocolV1), req.PayloadCID, sel.All())
 		if err != nil {
+			r.rqv.Release(p, req)
 			log.Error().Err(err).Msg("error when opening channel data channel")
 			return
 		}
 
-		for {
-			state, err := r.dt.ChannelState(ctx, chid)
-			if err != nil {
-				log.Error().Err(err).Msg("error when fetching channel state")
+		pp := pendingPull{
+			Peer:      p,
+			Request:   req,
+			StoreID:   sid,
+			Channel:   chid,
+			Incentive: inc,
+			Private:   ext.Private,
+		}
+		// Remember the pull so we can resume it if we or the dispatching peer go offline
+		if err := r.pulls.put(pp); err != nil {
+			log.Error().Err(err).Msg("error when persisting pull")
+		}
+		if !r.pulls.activate(req.PayloadCID) {
+			return
+		}
+		r.watchPull(ctx, pp)
+	}
+}
+
+// watchPull waits for a dispatch pull to complete and adds the content to our index
+func (r *Replication) watchPull(ctx context.Context, pp pendingPull) {
+	defer r.pulls.deactivate(pp.Request.PayloadCID)
+	p, req, sid, chid := pp.Peer, pp.Request, pp.StoreID, pp.Channel
+	// cleanup removes everything related to the pull once it is over
+	cleanup := func() {
+		r.RmStore(req.PayloadCID)
+		if err := r.ms.Delete(sid); err != nil {
+			log.Error().Err(err).Msg("error when deleting store")
+		}
+		if err := r.pulls.remove(req.PayloadCID); err != nil {
+			log.Error().Err(err).Msg("error when removing pull")
+		}
+	}
+
+	stall := newStallDetector(r.stallTimeout)
+	var received uint64
+	for {
+		state, err := r.dt.ChannelState(ctx, chid)
+		if err != nil {
+			log.Error().Err(err).Msg("error when fetching channel state")
+			return
+		}
+
+		if state.Received() > received {
+			received = state.Received()
+			stall.Progress()
+		} else if r.stallTimeout > 0 && stall.Stalled() {
+			// If the dispatching peer went offline we keep what we received and restart the pull
+			// once they reconnect
+			if r.h.Network().Connectedness(p) != network.Connected {
+				log.Info().Str("peer", p.String()).Str("root", req.PayloadCID.String()).Msg("dispatch pull interrupted")
 				return
 			}
+			// The dispatching peer will try with someone else
+			log.Error().Str("peer", p.String()).Str("root", req.PayloadCID.String()).Msg("dispatch transfer stalled")
+			if err := r.dt.CloseDataTransferChannel(ctx, chid); err != nil {
+				log.Error().Err(err).Msg("error when closing stalled channel")
+			}
+			r.rqv.Release(p, req)
+			cleanup()
+			return
+		}
 
-			switch state.Status() {
-			case datatransfer.Failed, datatransfer.Cancelled:
-				err = r.idx.DropRef(state.BaseCID())
-				if err != nil {
-					log.Error().Err(err).Msg("error when droping ref")
-				}
-				return
+		switch state.Status() {
+		case datatransfer.Failed, datatransfer.Cancelled:
+			err = r.idx.DropRef(state.BaseCID())
+			if err != nil {
+				log.Error().Err(err).Msg("error when droping ref")
+			}
+			r.rqv.Release(p, req)
+			cleanup()
+			return
 
-			case datatransfer.Completed:
-				store := r.GetStore(req.PayloadCID)
+		case datatransfer.Completed:
+			store := r.GetStore(req.PayloadCID)
 
-				keys, err := utils.MapLoadableKeys(ctx, req.PayloadCID, store.Loader)
-				if err != nil {
-					log.Debug().Err(err).Msg("error when loading keys")
-				}
+			if err := r.verifyTransfer(ctx, p, req.PayloadCID, sel.All(), store.Bstore, req.Size); err != nil {
+				log.Error().Err(err).Str("peer", p.String()).Str("root", req.PayloadCID.String()).Msg("dispatch pull failed verification")
+				r.rqv.Release(p, req)
+				cleanup()
+				return
+			}
 
-				ref := &DataRef{
-					PayloadCID:  req.PayloadCID,
-					PayloadSize: int64(req.Size),
-					Keys:        keys.AsBytes(),
-				}
+			keys, err := utils.MapLoadableKeys(ctx, req.PayloadCID, store.Loader)
+			if err != nil {
+				log.Debug().Err(err).Msg("error when loading keys")
+			}
 
-				err = r.idx.SetRef(ref)
-				if err != nil {
-					log.Error().Err(err).Msg("error when setting ref")
-				}
+			ref := &DataRef{
+				PayloadCID:  req.PayloadCID,
+				PayloadSize: int64(req.Size),
+				Keys:        keys.AsBytes(),
+				Private:     pp.Private,
+			}
 
-				if err := utils.MigrateBlocks(ctx, store.Bstore, r.bs); err != nil {
-					log.Error().Err(err).Msg("error when migrating blocks")
-				}
+			// blocks are migrated first so the index can count the ones the ref links to
+			if err := utils.MigrateBlocks(ctx, store.Bstore, r.bs); err != nil {
+				log.Error().Err(err).Msg("error when migrating blocks")
+			}
 
-				if err := r.ms.Delete(sid); err != nil {
-					log.Error().Err(err).Msg("error when deleting store")
+			err = r.idx.SetRef(ref)
+			if err != nil {
+				log.Error().Err(err).Msg("error when setting ref")
+			}
+			r.members.Join(p, r.peerRegions(p))
+
+			// The payment can be claimed once we held the content long enough
+			if pp.Incentive != nil {
+				err := r.claims.put(pendingIncentive{
+					Peer:       p,
+					PayloadCID: req.PayloadCID,
+					Incentive:  *pp.Incentive,
+					Since:      time.Now(),
+				})
+				if err != nil {
+					log.Error().Err(err).Msg("error when persisting incentive claim")
 				}
-				return
 			}
+
+			cleanup()
+			return
 		}
 	}
 }
@@ -439,6 +950,26 @@ type DispatchOptions struct {
 	BackoffAttemps int
 	RF             int
 	StoreID        multistore.StoreID
+	// Timeout is how long we keep waiting for the transfers still in progress once all the attempts are done.
+	// If 0 it is derived from the content size.
+	Timeout time.Duration
+	// Peers restricts the dispatch to the given peers instead of selecting them in our regions
+	Peers []peer.ID
+	// FromBlockstore serves content already committed to our main blockstore instead of the store StoreID
+	FromBlockstore bool
+	// Incentive is offered to the peers who pull the content and hold it for the incentive duration
+	Incentive *Incentive
+	// Private content is not listed on the public index of the caches. Caches speaking a version of the
+	// request protocol older than 2.0 don't receive the flag.
+	Private bool
+	// Payer is the address paying the incentive, defaults to our wallet default address
+	Payer address.Address
+	// Exclude are peers we don't send requests to, i.e. because they already hold the content
+	Exclude []peer.ID
+	// Regions are the regions to dispatch to in order of priority. We only send requests to peers in
+	// a region once the peers in the previous regions are not enough or didn't respond.
+	// Defaults to our regions all treated equally.
+	Regions []Region
 }
 
 // DefaultDispatchOptions provides useful defaults
@@ -449,125 +980,620 @@ var DefaultDispatchOptions = DispatchOptions{
 	RF:             6,
 }
 
+// DispatchHandle follows the progress of a dispatch and lets the caller abort it
+type DispatchHandle struct {
+	out    chan PRecord
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Out returns the channel receiving the peers who stored the content. It is closed once the dispatch is over.
+func (h *DispatchHandle) Out() <-chan PRecord {
+	return h.out
+}
+
+// Cancel stops sending requests, closes the transfers in progress and revokes the pull tokens issued
+// for the dispatch. It returns once the dispatch is over.
+func (h *DispatchHandle) Cancel() {
+	h.cancel()
+	<-h.done
+}
+
+// DispatchItem is a root to dispatch along with others in a single batch
+type DispatchItem struct {
+	Root cid.Cid
+	Size uint64
+	// StoreID is the store holding the content unless the batch is dispatched from our blockstore
+	StoreID multistore.StoreID
+}
+
+// dispatchState tracks the confirmations for a single root of a batch
+type dispatchState struct {
+	req Request
+	// confirmed is the number of peers who stored the content so far
+	confirmed int
+}
+
 // Dispatch to the network until we have propagated the content to enough peers
-func (r *Replication) Dispatch(root cid.Cid, size uint64, opt DispatchOptions) (chan PRecord, error) {
-	if err := r.AddStore(root, opt.StoreID); err != nil {
-		return nil, err
+func (r *Replication) Dispatch(root cid.Cid, size uint64, opt DispatchOptions) (*DispatchHandle, error) {
+	return r.DispatchBatch([]DispatchItem{{Root: root, Size: size, StoreID: opt.StoreID}}, opt)
+}
+
+// DispatchBatch propagates several roots to the network in a single operation. Each peer we select receives
+// a request for every root still below the replication factor so the batch shares the same peer selection
+// and backoff loop. The handle receives a record for each peer storing each root and is closed once all the
+// roots reached the replication factor or we gave up.
+func (r *Replication) DispatchBatch(items []DispatchItem, opt DispatchOptions) (*DispatchHandle, error) {
+	if len(items) == 0 {
+		return nil, ErrNoDispatchItems
+	}
+	states := make(map[cid.Cid]*dispatchState, len(items))
+	// keep the order of the items to send the requests in
+	var roots []cid.Cid
+	var total uint64
+	for _, it := range items {
+		if _, ok := states[it.Root]; ok {
+			continue
+		}
+		// Without a store the TransportConfigurer loads the content from our main blockstore
+		if !opt.FromBlockstore {
+			if err := r.AddStore(it.Root, it.StoreID); err != nil {
+				return nil, err
+			}
+		}
+		// Remember the replication factor so we can replace the replicas we lose
+		r.trackReplicas(it.Root, opt.RF)
+
+		states[it.Root] = &dispatchState{
+			req: Request{
+				Method:     Dispatch,
+				PayloadCID: it.Root,
+				Size:       it.Size,
+			},
+		}
+		roots = append(roots, it.Root)
+		total += it.Size
 	}
 
-	req := Request{
-		Method:     Dispatch,
-		PayloadCID: root,
-		Size:       size,
+	resChan := make(chan PRecord, opt.RF*len(roots))
+	out := make(chan PRecord, opt.RF*len(roots))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	// keep track of the progress of each transfer so we can move on to other peers if one hangs
+	var cmu sync.Mutex
+	channels := make(map[datatransfer.ChannelID]*stallDetector)
+	// the roots for which each peer received our incentive with the request
+	incentivized := make(map[PRecord]bool)
+	// slots records when we sent requests to each peer holding a scheduler slot until its transfers are over
+	slots := make(map[peer.ID]time.Time)
+	// pulling are the peers holding a slot who started pulling the content
+	pulling := make(map[peer.ID]bool)
+	// transfers counts the transfers in progress with each peer
+	transfers := make(map[peer.ID]int)
+	// requested are all the peers we sent a pull token to
+	requested := make(map[peer.ID]bool)
+	// started records when each transfer started to measure its duration
+	started := make(map[datatransfer.ChannelID]time.Time)
+	// release frees the slot held by a peer, it must be called with the lock held
+	release := func(p peer.ID) {
+		if _, ok := slots[p]; ok {
+			delete(slots, p)
+			delete(pulling, p)
+			r.sched.Release(1)
+		}
+	}
+	// rcv are the peers we already sent requests to and don't select again
+	rcv := make(map[peer.ID]bool)
+	for _, p := range opt.Exclude {
+		rcv[p] = true
+	}
+	// releaseIdle frees the slots of the peers who didn't start pulling after we sent them a request
+	// so we may select them again once they catch up
+	releaseIdle := func() {
+		cmu.Lock()
+		defer cmu.Unlock()
+		for p := range slots {
+			if !pulling[p] {
+				release(p)
+				delete(rcv, p)
+			}
+		}
+	}
+	// dropInactive frees the slot of a peer who stopped answering our heartbeats before pulling and
+	// returns whether it did
+	dropInactive := func(evt interface{}) bool {
+		status := evt.(PeerStatusEvt)
+		if status.Active {
+			return false
+		}
+		cmu.Lock()
+		defer cmu.Unlock()
+		if _, ok := slots[status.Peer]; !ok || pulling[status.Peer] {
+			return false
+		}
+		release(status.Peer)
+		delete(rcv, status.Peer)
+		return true
+	}
+	// We don't wait for the peers who become unresponsive
+	var statuses <-chan interface{}
+	statusSub, err := r.h.EventBus().Subscribe(new(PeerStatusEvt), eventbus.BufSize(16))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to subscribe to peer status events")
+	} else {
+		statuses = statusSub.Out()
 	}
-	resChan := make(chan PRecord, opt.RF)
-	out := make(chan PRecord, opt.RF)
 	// listen for datatransfer events to identify the peers who pulled the content
 	unsub := r.dt.SubscribeToEvents(func(event datatransfer.Event, chState datatransfer.ChannelState) {
 		root := chState.BaseCID()
-		if root != req.PayloadCID {
+		state, ok := states[root]
+		if !ok {
 			return
 		}
+		size := state.req.Size
+
+		rstate := ReplicationState{
+			PayloadCID: root,
+			Peer:       chState.Recipient(),
+			Size:       size,
+		}
+		cmu.Lock()
+		accepted := false
+		switch chState.Status() {
+		case datatransfer.Failed, datatransfer.Cancelled, datatransfer.Completed:
+			chid := chState.ChannelID()
+			if _, ok := channels[chid]; ok {
+				transfers[chState.Recipient()]--
+			}
+			delete(channels, chid)
+			if t, ok := started[chid]; ok && chState.Status() == datatransfer.Completed {
+				r.sched.ObserveTransfer(size, time.Since(t))
+			}
+			delete(started, chid)
+			// The slot is held until the peer is done with all the roots it pulls
+			if transfers[chState.Recipient()] <= 0 {
+				delete(transfers, chState.Recipient())
+				release(chState.Recipient())
+			}
+		default:
+			if s, ok := channels[chState.ChannelID()]; ok {
+				s.Progress()
+			} else {
+				channels[chState.ChannelID()] = newStallDetector(r.stallTimeout)
+				started[chState.ChannelID()] = time.Now()
+				transfers[chState.Recipient()]++
+				if sent, ok := slots[chState.Recipient()]; ok && !pulling[chState.Recipient()] {
+					r.sched.ObserveLatency(time.Since(sent))
+					pulling[chState.Recipient()] = true
+				}
+				accepted = true
+			}
+		}
+		cmu.Unlock()
+
+		if accepted {
+			r.publish(ReplicationEventPeerAccepted, rstate)
+		}
 
 		if chState.Status() == datatransfer.Failed || chState.Status() == datatransfer.Cancelled {
 			log.Error().Str("root", root.String()).Msg("transfer failed for content")
+			rstate.Message = chState.Message()
+			r.publish(ReplicationEventTransferFailed, rstate)
 		}
 
 		if chState.Status() == datatransfer.Completed {
 			// The recipient is the provider who received our content
-			rec := chState.Recipient()
-			resChan <- PRecord{
-				Provider:   rec,
+			rec := PRecord{
+				Provider:   chState.Recipient(),
 				PayloadCID: root,
 			}
+			r.catalog.Add(root, rec.Provider)
+			r.addReplica(root, rec.Provider)
+			r.members.Add(rec.Provider, r.peerRegions(rec.Provider))
+			cmu.Lock()
+			offered := incentivized[rec]
+			cmu.Unlock()
+			if offered {
+				// Remember the offer so we can pay the peer when it claims it
+				err := r.offered.put(pendingIncentive{
+					Peer:       rec.Provider,
+					PayloadCID: root,
+					Incentive:  *opt.Incentive,
+					Payer:      opt.Payer,
+					Since:      time.Now(),
+				})
+				if err != nil {
+					log.Error().Err(err).Msg("error when persisting incentive offer")
+				}
+			}
+			r.publish(ReplicationEventTransferCompleted, rstate)
+			resChan <- rec
 		}
 	})
 	go func() {
 		defer func() {
+			cancelled := ctx.Err() != nil
+			cancel()
 			unsub()
+			if statusSub != nil {
+				statusSub.Close()
+			}
+			// Free the slots of the transfers we stop waiting for
+			cmu.Lock()
+			for p := range slots {
+				release(p)
+			}
+			var chids []datatransfer.ChannelID
+			for chid := range channels {
+				chids = append(chids, chid)
+			}
+			var peers []peer.ID
+			for p := range requested {
+				peers = append(peers, p)
+			}
+			cmu.Unlock()
+			if cancelled {
+				for _, root := range roots {
+					r.abortDispatch(root, states[root].req.Size, peers)
+				}
+				r.closeChannels(chids)
+			}
 			close(out)
-
+			close(done)
 		}()
-		// The peers we already sent requests to
-		rcv := make(map[peer.ID]bool)
 		// Set the parameters for backing off after each try
 		b := backoff.Backoff{
 			Min: opt.BackoffMin,
 			Max: 60 * time.Minute,
 			// Factor: 2 (default)
 		}
-		// The number of confirmations we received so far
-		n := 0
+		// tier is the number of priority regions we select peers in
+		tier := 1
+		// spill extends the selection to the next priority region and returns whether there was one
+		spill := func() bool {
+			if tier >= len(opt.Regions) {
+				return false
+			}
+			tier++
+			log.Debug().Str("region", opt.Regions[tier-1].Name).Msg("dispatch spilling into next region")
+			return true
+		}
+		// confirm forwards a record to the caller and returns whether all the roots reached the replication factor
+		confirm := func(rec PRecord) bool {
+			out <- rec
+			states[rec.PayloadCID].confirmed++
+			for _, s := range states {
+				if s.confirmed < opt.RF {
+					return false
+				}
+			}
+			return true
+		}
+		// fewest is the lowest number of confirmations among the roots of the batch
+		fewest := func() int {
+			n := opt.RF
+			for _, s := range states {
+				if s.confirmed < n {
+					n = s.confirmed
+				}
+			}
+			return n
+		}
+		// Periodically check if any transfer is hung
+		var stallCheck <-chan time.Time
+		if r.stallTimeout > 0 {
+			ticker := time.NewTicker(r.stallTimeout / 4)
+			defer ticker.Stop()
+			stallCheck = ticker.C
+		}
+		inFlight := func() bool {
+			cmu.Lock()
+			defer cmu.Unlock()
+			return len(channels) > 0
+		}
 
 	requests:
 		for {
 			// Give up after 6 attempts. Maybe should make this customizable for servers that can afford it
 			if int(b.Attempt()) > opt.BackoffAttemps {
-				return
+				// Peers may still be pulling large content so we don't stop until their transfers are over
+				timeout := opt.Timeout
+				if timeout == 0 {
+					timeout = r.sched.Timeout(total)
+				}
+				deadline := time.NewTimer(timeout)
+				defer deadline.Stop()
+				for inFlight() {
+					select {
+					case <-deadline.C:
+						return
+					case <-ctx.Done():
+						return
+					case <-stallCheck:
+						r.closeStalled(&cmu, channels)
+					case rec := <-resChan:
+						if confirm(rec) {
+							return
+						}
+					}
+				}
+				// forward the confirmations sent by the last transfers to complete
+				for {
+					select {
+					case rec := <-resChan:
+						if confirm(rec) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+			// The peers still holding a slot may confirm so we only send the requests we are missing
+			// and only as many as the scheduler lets us serve at once
+			released := r.sched.Released()
+			cmu.Lock()
+			want := opt.RF - fewest() - len(slots)
+			cmu.Unlock()
+			granted := r.sched.Reserve(want)
+			if granted == 0 {
+				// Wait for a slot to free up without counting an attempt
+				timer := time.NewTimer(r.sched.Delay(opt.BackoffMin))
+				select {
+				case <-released:
+				case <-timer.C:
+					releaseIdle()
+				case <-stallCheck:
+					r.closeStalled(&cmu, channels)
+				case evt := <-statuses:
+					dropInactive(evt)
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case rec := <-resChan:
+					if confirm(rec) {
+						timer.Stop()
+						return
+					}
+				}
+				timer.Stop()
+				continue requests
 			}
 			// Select the providers we want to send to minus those we already confirmed
 			// received the requests
-			providers := r.pm.Peers(opt.RF-n, r.rgs, rcv)
+			providers := r.selectProviders(granted, total, opt, r.dispatchRegions(opt, tier), rcv)
+			// Move on to the next regions if we ran out of peers in the current ones
+			for len(providers) < granted && spill() {
+				providers = append(providers, r.selectProviders(granted-len(providers), total, opt,
+					r.dispatchRegions(opt, tier), withPeers(rcv, providers))...)
+			}
+			r.sched.Release(granted - len(providers))
 
-			// Authorize the transfer
+			cmu.Lock()
+			now := time.Now()
 			for _, p := range providers {
-				r.AuthorizePull(req.PayloadCID, p)
 				rcv[p] = true
+				slots[p] = now
+				requested[p] = true
 			}
+			cmu.Unlock()
 			if len(providers) > 0 {
-				// sendAllRequests
-				r.sendAllRequests(req, providers)
+				// Only ask for the roots we still need more replicas of
+				for _, root := range roots {
+					s := states[root]
+					if s.confirmed >= opt.RF {
+						continue
+					}
+					offered := r.sendAllRequests(s.req, providers, DispatchExtras{Incentive: opt.Incentive, Private: opt.Private})
+					cmu.Lock()
+					for _, p := range offered {
+						incentivized[PRecord{Provider: p, PayloadCID: root}] = true
+					}
+					cmu.Unlock()
+				}
 			}
 
-			delay := b.Duration()
+			// Slow peers get more time to start pulling before we try others
+			delay := r.sched.Delay(b.Duration())
 			timer := time.NewTimer(delay)
 			for {
 				select {
 				case <-timer.C:
+					releaseIdle()
+					// Not enough peers responded in our priority regions
+					spill()
 					continue requests
 
-				case r := <-resChan:
+				case <-stallCheck:
+					// Close hung channels and send requests to other peers right away
+					if closed := r.closeStalled(&cmu, channels); closed > 0 {
+						timer.Stop()
+						continue requests
+					}
+
+				case evt := <-statuses:
+					// Send a request to another peer right away
+					if dropInactive(evt) {
+						timer.Stop()
+						continue requests
+					}
+
+				case <-ctx.Done():
+					timer.Stop()
+					return
+
+				case rec := <-resChan:
 					// forward the confirmations to the Response channel
-					out <- r
-					// increment our results count
-					n++
-					if n == opt.RF {
+					if confirm(rec) {
 						return
 					}
 				}
 			}
 		}
 	}()
-	return out, nil
+	return &DispatchHandle{out: out, cancel: cancel, done: done}, nil
 }
 
-func (r *Replication) sendAllRequests(req Request, peers []peer.ID) {
+// abortDispatch revokes the pull tokens issued to the peers we sent requests to for a cancelled dispatch
+// so they cannot start pulling later
+func (r *Replication) abortDispatch(root cid.Cid, size uint64, peers []peer.ID) {
 	for _, p := range peers {
+		r.revocations.revoke(root, p)
+	}
+	log.Info().Str("root", root.String()).Msg("dispatch cancelled")
+	r.publish(ReplicationEventDispatchCancelled, ReplicationState{
+		PayloadCID: root,
+		Size:       size,
+	})
+}
+
+// closeChannels closes the transfers still in progress for a cancelled dispatch
+func (r *Replication) closeChannels(chids []datatransfer.ChannelID) {
+	for _, chid := range chids {
+		if err := r.dt.CloseDataTransferChannel(context.TODO(), chid); err != nil {
+			log.Error().Err(err).Msg("error when closing cancelled channel")
+		}
+	}
+}
+
+// dispatchRegions returns the regions to select peers in when the dispatch reached the given number of
+// priority regions
+func (r *Replication) dispatchRegions(opt DispatchOptions, tier int) []Region {
+	if len(opt.Regions) == 0 {
+		return r.rgs
+	}
+	if tier > len(opt.Regions) {
+		tier = len(opt.Regions)
+	}
+	return opt.Regions[:tier]
+}
+
+// withPeers returns a copy of the ignore set including the given peers
+func withPeers(ignore map[peer.ID]bool, peers []peer.ID) map[peer.ID]bool {
+	set := make(map[peer.ID]bool, len(ignore)+len(peers))
+	for p := range ignore {
+		set[p] = true
+	}
+	for _, p := range peers {
+		set[p] = true
+	}
+	return set
+}
+
+// selectProviders returns up to n peers to send a dispatch request to among the peers listed in the options
+// or in the given regions. We skip peers in ignore and peers who told us they don't have enough space for the content.
+func (r *Replication) selectProviders(n int, size uint64, opt DispatchOptions, rl []Region, ignore map[peer.ID]bool) []peer.ID {
+	var providers []peer.ID
+	if len(opt.Peers) > 0 {
+		for _, p := range opt.Peers {
+			if !ignore[p] && len(providers) < n {
+				providers = append(providers, p)
+			}
+		}
+		return providers
+	}
+	return r.pm.FilterPeers(n, rl, ignore, func(_ peer.ID, p Peer) bool {
+		return p.Capacity >= size
+	})
+}
+
+// DispatchCandidate is a peer selected to receive a dispatch
+type DispatchCandidate struct {
+	Peer peer.ID
+	// Info is what we know about the peer. It is empty for peers listed in the options we never greeted.
+	Info Peer
+}
+
+// Candidates runs the peer selection of a dispatch without sending any request so operators can check
+// which peers would receive the content. Peers who don't answer a dispatch are replaced by others
+// so the actual recipients may differ.
+func (r *Replication) Candidates(size uint64, opt DispatchOptions) []DispatchCandidate {
+	ignore := make(map[peer.ID]bool)
+	for _, p := range opt.Exclude {
+		ignore[p] = true
+	}
+	// Peers are selected in the priority regions first
+	providers := r.selectProviders(opt.RF, size, opt, r.dispatchRegions(opt, len(opt.Regions)), ignore)
+	candidates := make([]DispatchCandidate, len(providers))
+	for i, p := range providers {
+		info, _ := r.pm.Peer(p)
+		candidates[i] = DispatchCandidate{Peer: p, Info: info}
+	}
+	return candidates
+}
+
+// closeStalled closes the channels which made no progress during the stall timeout and returns how many were closed
+func (r *Replication) closeStalled(mu *sync.Mutex, channels map[datatransfer.ChannelID]*stallDetector) int {
+	mu.Lock()
+	var stalled []datatransfer.ChannelID
+	for chid, s := range channels {
+		if s.Stalled() {
+			stalled = append(stalled, chid)
+			delete(channels, chid)
+		}
+	}
+	mu.Unlock()
+	for _, chid := range stalled {
+		log.Error().Str("peer", chid.Initiator.String()).Msg("dispatch transfer stalled")
+		if err := r.dt.CloseDataTransferChannel(context.TODO(), chid); err != nil {
+			log.Error().Err(err).Msg("error when closing stalled channel")
+		}
+	}
+	return len(stalled)
+}
+
+// sendAllRequests sends the request to each peer with the extras if any and returns the peers who
+// received the incentive
+func (r *Replication) sendAllRequests(req Request, peers []peer.ID, ext DispatchExtras) []peer.ID {
+	var offered []peer.ID
+	for _, p := range peers {
+		// Each peer gets a token authorizing it to pull the content from us
+		tok, err := r.IssuePullToken(req.PayloadCID, p, PullTokenTTL, req.Size)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to issue pull token")
+			continue
+		}
+		preq := req
+		preq.Token = &tok
 		stream, err := r.NewRequestStream(p)
 		if err != nil {
 			continue
 		}
-		err = stream.WriteRequest(req)
+		err = stream.WriteDispatch(preq, ext)
 		stream.Close()
 		if err != nil {
 			continue
 		}
+		// 1.0 caches don't receive the token so we hold it until they pull
+		if stream.Protocol() == PopRequestProtocolV1 {
+			r.legacy.put(tok)
+		}
+		if ext.Incentive != nil && stream.Protocol() == PopRequestProtocolV2 {
+			offered = append(offered, p)
+		}
+		r.publish(ReplicationEventRequestSent, ReplicationState{
+			PayloadCID: req.PayloadCID,
+			Peer:       p,
+			Size:       req.Size,
+		})
 	}
+	return offered
 }
 
-// AuthorizePull adds a peer to a set giving authorization to pull content without payment
-// We assume that this authorizes the peer to pull as many links from the root CID as they can
-// It runs on the client side to authorize caches
-func (r *Replication) AuthorizePull(k cid.Cid, p peer.ID) {
-	r.pmu.Lock()
-	defer r.pmu.Unlock()
-	if set, ok := r.pulls[k]; ok {
-		set.Add(p)
-		return
+// PeersPerRegion returns the number of connected peers in each region by region name
+func (r *Replication) PeersPerRegion() map[string]int {
+	counts := make(map[string]int)
+	for code, n := range r.pm.RegionCounts() {
+		counts[RegionName(code)] += n
 	}
-	set := peer.NewSet()
-	set.Add(p)
-	r.pulls[k] = set
+	return counts
+}
+
+// IssuePullToken authorizes a peer to pull some content from us without payment until the token expires.
+// The token can be handed out of band and is passed back to us in the transfer voucher. maxBytes limits
+// the size of the content which can be pulled, 0 means no limit.
+func (r *Replication) IssuePullToken(k cid.Cid, p peer.ID, ttl time.Duration, maxBytes uint64) (PullToken, error) {
+	return IssuePullToken(r.h, k, p, ttl, maxBytes)
 }
 
 // ValidatePush returns a stubbed result for a push validation
@@ -597,18 +1623,34 @@ func (r *Replication) ValidatePull(
 	// TODO: For now fetching someone's index it authorized by default
 	// we need some permission system
 	if request.Method == FetchIndex {
+		r.members.Add(receiver, r.peerRegions(receiver))
+		return nil, nil
+	}
+	// Peers from our fleet may pull anything we have
+	if request.Method == Pull && r.trust.Trusted(receiver) {
+		if _, err := r.idx.PeekRef(baseCid); err != nil {
+			return nil, fmt.Errorf("unknown CID")
+		}
 		return nil, nil
 	}
 
-	r.pmu.Lock()
-	defer r.pmu.Unlock()
-	set, ok := r.pulls[baseCid]
-	if !ok {
+	// Anyone else needs a token we issued
+	tok := request.Token
+	if err := VerifyPullToken(r.h, tok, baseCid, receiver); err != nil {
+		return nil, fmt.Errorf("not authorized: %w", err)
+	}
+	if r.revocations.revoked(tok) {
+		return nil, fmt.Errorf("not authorized: %w", ErrTokenRevoked)
+	}
+	// Content being dispatched may not be indexed yet but has a store
+	ref, err := r.idx.PeekRef(baseCid)
+	if err != nil && r.GetStore(baseCid) == nil {
 		return nil, fmt.Errorf("unknown CID")
 	}
-	if !set.Contains(receiver) {
-		return nil, fmt.Errorf("not authorized")
+	if tok.MaxBytes > 0 && ref != nil && uint64(ref.PayloadSize) > tok.MaxBytes {
+		return nil, fmt.Errorf("not authorized: content larger than %d bytes", tok.MaxBytes)
 	}
+	r.limits.track(chid, tok)
 	return nil, nil
 }
 
@@ -624,12 +1666,12 @@ type IdxStoreGetter interface {
 }
 
 // TransportConfigurer configurers the graphsync transport to use a custom blockstore per content
-func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID) datatransfer.TransportConfigurer {
+func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID, bs blockstore.Blockstore) datatransfer.TransportConfigurer {
 	return func(channelID datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport) {
 		warn := func(err error) {
 			log.Error().Err(err).Msg("attempting to configure data store")
 		}
-		request, ok := voucher.(*Request)
+		request, ok := requestVoucher(voucher)
 		if !ok {
 			return
 		}
@@ -639,10 +1681,28 @@ func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID) datatransf
 		}
 		// When initiating both FetchIndex and Dispatch transfers we've already assigned a store
 		// with the root CID so we just need to get it
-		if (request.Method == FetchIndex && channelID.Initiator == pid) || request.Method == Dispatch {
+		if ((request.Method == FetchIndex || request.Method == Pull) && channelID.Initiator == pid) ||
+			request.Method == Dispatch {
 			// When we're fetching a new index we store it in a new store
 			store := isg.GetStore(request.PayloadCID)
-			err := gsTransport.UseStore(channelID, store.Loader, store.Storer)
+			if store != nil {
+				err := gsTransport.UseStore(channelID, store.Loader, store.Storer)
+				if err != nil {
+					warn(err)
+				}
+				return
+			}
+			// A token may still be used after the dispatch is over or we restarted
+			// in which case the content is in our main blockstore
+			if channelID.Initiator == pid {
+				return
+			}
+		}
+		// A peer is pulling our content, it is loaded from the main blockstore
+		if request.Method == Pull || request.Method == Dispatch {
+			loader := storeutil.LoaderForBlockstore(bs)
+			storer := storeutil.StorerForBlockstore(bs)
+			err := gsTransport.UseStore(channelID, loader, storer)
 			if err != nil {
 				warn(err)
 			}
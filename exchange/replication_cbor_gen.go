@@ -16,7 +16,7 @@ var _ = xerrors.Errorf
 var _ = cid.Undef
 var _ = sort.Sort
 
-var lengthBufRequest = []byte{131}
+var lengthBufRequest = []byte{132}
 
 func (t *Request) MarshalCBOR(w io.Writer) error {
 	if t == nil {
@@ -47,6 +47,11 @@ func (t *Request) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.Token (exchange.PullToken) (struct)
+	if err := t.Token.MarshalCBOR(w); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -64,7 +69,7 @@ func (t *Request) UnmarshalCBOR(r io.Reader) error {
 		return fmt.Errorf("cbor input should be of type array")
 	}
 
-	if extra != 3 {
+	if extra != 4 {
 		return fmt.Errorf("cbor input had wrong number of fields")
 	}
 
@@ -107,6 +112,25 @@ func (t *Request) UnmarshalCBOR(r io.Reader) error {
 		}
 		t.Size = uint64(extra)
 
+	}
+	// t.Token (exchange.PullToken) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return err
+			}
+			t.Token = new(PullToken)
+			if err := t.Token.UnmarshalCBOR(br); err != nil {
+				return xerrors.Errorf("unmarshaling t.Token pointer: %w", err)
+			}
+		}
+
 	}
 	return nil
 }
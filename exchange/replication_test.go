@@ -168,7 +168,7 @@ func TestReplication(t *testing.T) {
 	optsD.StoreID = storeIDD
 	resD, err := rD.Dispatch(rootCidD, uint64(256000), optsD)
 	require.NoError(t, err)
-	for r := range resD {
+	for r := range resD.Out() {
 		switch r.Provider {
 		case names["C"], names["E"], names["F"]:
 		default:
@@ -194,7 +194,7 @@ func TestReplication(t *testing.T) {
 	optsF.StoreID = storeIDF
 	resF, err := rF.Dispatch(rootCidF, uint64(256000), optsF)
 	require.NoError(t, err)
-	for r := range resF {
+	for r := range resF.Out() {
 		switch r.Provider {
 		case names["E"], names["D"], names["C"], names["B"]:
 		default:
@@ -246,7 +246,7 @@ func TestReplication(t *testing.T) {
 	optsB.StoreID = storeIDB
 	resB, err := rB.Dispatch(rootCidB, uint64(256000), optsB)
 	require.NoError(t, err)
-	for r := range resB {
+	for r := range resB.Out() {
 		switch r.Provider {
 		case names["C"], names["F"], names["G"], names["A"]:
 		default:
@@ -293,7 +293,7 @@ func TestReplication(t *testing.T) {
 	optsH.StoreID = storeIDH
 	resH, err := rH.Dispatch(rootCidH, uint64(256000), optsH)
 	require.NoError(t, err)
-	for r := range resH {
+	for r := range resH.Out() {
 		switch r.Provider {
 		case names["A"], names["B"], names["G"]:
 		default:
@@ -386,7 +386,7 @@ func TestConcurrentReplication(t *testing.T) {
 					opts.StoreID = storeID
 					res, err := repls[i].Dispatch(rootCid, uint64(128000), opts)
 					require.NoError(t, err)
-					for range res {
+					for range res.Out() {
 					}
 					// Must migrate dispatched content to global store afterwards
 					store, err := nodes[i].Ms.Get(storeID)
@@ -540,7 +540,7 @@ func TestMultiDispatchStreams(t *testing.T) {
 	require.NoError(t, err)
 
 	var recs []PRecord
-	for rec := range res {
+	for rec := range res.Out() {
 		recs = append(recs, rec)
 	}
 	require.Equal(t, len(recs), 6)
@@ -553,6 +553,104 @@ func TestMultiDispatchStreams(t *testing.T) {
 
 }
 
+func TestDispatchBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+	t.Cleanup(func() {
+		err := n1.Dt.Stop(ctx)
+		require.NoError(t, err)
+	})
+
+	var items []DispatchItem
+	files := make(map[cid.Cid][]byte)
+	for i := 0; i < 2; i++ {
+		fname := n1.CreateRandomFile(t, 256000)
+		root, storeID, origBytes := n1.LoadFileToNewStore(ctx, t, fname)
+		rootCid := root.(cidlink.Link).Cid
+		items = append(items, DispatchItem{Root: rootCid, Size: uint64(len(origBytes)), StoreID: storeID})
+		files[rootCid] = origBytes
+	}
+
+	regions := []Region{global}
+	opts := Options{Regions: regions, MultiStore: n1.Ms, Blockstore: n1.Bs}
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+	hn, err := NewReplication(n1.Host, idx, n1.Dt, NewMockRetriever(n1.Dt, idx), opts)
+	require.NoError(t, err)
+	sub, err := hn.h.EventBus().Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+	require.NoError(t, hn.Start(ctx))
+
+	_, err = hn.DispatchBatch(nil, DefaultDispatchOptions)
+	require.ErrorIs(t, err, ErrNoDispatchItems)
+
+	tnds := make(map[peer.ID]*testutil.TestNode)
+	idxs := make(map[peer.ID]*Index)
+	for i := 0; i < 3; i++ {
+		tnode := testutil.NewTestNode(mn, t)
+		tnode.SetupDataTransfer(ctx, t)
+		t.Cleanup(func() {
+			err := tnode.Dt.Stop(ctx)
+			require.NoError(t, err)
+		})
+		idx, err := NewIndex(tnode.Ds, tnode.Bs)
+		require.NoError(t, err)
+		opts := Options{Regions: regions, MultiStore: tnode.Ms, Blockstore: tnode.Bs}
+		hn1, err := NewReplication(tnode.Host, idx, tnode.Dt, NewMockRetriever(tnode.Dt, idx), opts)
+		require.NoError(t, err)
+		require.NoError(t, hn1.Start(ctx))
+		tnds[tnode.Host.ID()] = tnode
+		idxs[tnode.Host.ID()] = idx
+	}
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-sub.Out():
+		case <-ctx.Done():
+			t.Fatal("all peers didn't get in the peermgr")
+		}
+	}
+
+	dopts := DefaultDispatchOptions
+	dopts.RF = 2
+	dopts.Private = true
+	res, err := hn.DispatchBatch(items, dopts)
+	require.NoError(t, err)
+
+	// Each root is stored by RF peers
+	counts := make(map[cid.Cid]int)
+	var recs []PRecord
+	for rec := range res.Out() {
+		counts[rec.PayloadCID]++
+		recs = append(recs, rec)
+	}
+	require.Equal(t, 2, len(counts))
+	for _, it := range items {
+		require.Equal(t, 2, counts[it.Root])
+	}
+
+	time.Sleep(time.Second)
+	for _, r := range recs {
+		p := tnds[r.Provider]
+		p.VerifyFileTransferred(ctx, t, p.DAG, r.PayloadCID, files[r.PayloadCID])
+
+		// The caches keep the content off their public index
+		ref, err := idxs[r.Provider].PeekRef(r.PayloadCID)
+		require.NoError(t, err)
+		require.True(t, ref.Private)
+	}
+	require.Equal(t, 0, hn.Scheduler().InUse())
+}
+
 // In some rare cases where our node isn't connected to any peer we should still
 // be able to fail gracefully
 func TestSendDispatchNoPeers(t *testing.T) {
@@ -594,7 +692,7 @@ func TestSendDispatchNoPeers(t *testing.T) {
 	}
 	res, err := supply.Dispatch(rootCid, uint64(len(origBytes)), options)
 	require.NoError(t, err)
-	for range res {
+	for range res.Out() {
 	}
 }
 
@@ -729,7 +827,7 @@ func TestSendDispatchDiffRegions(t *testing.T) {
 	require.NoError(t, err)
 
 	var recipients []PRecord
-	for rec := range res {
+	for rec := range res.Out() {
 		recipients = append(recipients, rec)
 	}
 	for _, p := range recipients {
@@ -805,3 +903,252 @@ func TestPeerMgr(t *testing.T) {
 	peers4 := repl.pm.Peers(0, regions, ignore)
 	require.Equal(t, 0, len(peers4))
 }
+
+func TestDispatchCandidates(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+	opts := Options{Regions: []Region{global}, MultiStore: n1.Ms, Blockstore: n1.Bs}
+	supply, err := NewReplication(n1.Host, idx, n1.Dt, NewMockRetriever(n1.Dt, idx), opts)
+	require.NoError(t, err)
+
+	small := testutil.NewTestNode(mn, t).Host.ID()
+	big1 := testutil.NewTestNode(mn, t).Host.ID()
+	big2 := testutil.NewTestNode(mn, t).Host.ID()
+	full := testutil.NewTestNode(mn, t).Host.ID()
+	supply.pm.handleHey(small, Hey{Regions: []RegionCode{GlobalRegion}, Capacity: 100})
+	supply.pm.handleHey(full, Hey{Regions: []RegionCode{GlobalRegion}, Capacity: 0})
+	supply.pm.handleHey(big1, Hey{Regions: []RegionCode{GlobalRegion}, Capacity: 10000})
+	supply.pm.handleHey(big2, Hey{Regions: []RegionCode{GlobalRegion}, Capacity: 10000})
+
+	// Peers without enough space are not candidates
+	candidates := supply.Candidates(1000, DispatchOptions{RF: 4})
+	require.Len(t, candidates, 2)
+	for _, c := range candidates {
+		require.NotEqual(t, small, c.Peer)
+		require.NotEqual(t, full, c.Peer)
+		require.Equal(t, uint64(10000), c.Info.Capacity)
+	}
+
+	candidates = supply.Candidates(1000, DispatchOptions{RF: 3, Exclude: []peer.ID{big1}})
+	require.Len(t, candidates, 1)
+	require.Equal(t, big2, candidates[0].Peer)
+
+	// Explicit peers are used as is
+	candidates = supply.Candidates(1000, DispatchOptions{RF: 3, Peers: []peer.ID{small}})
+	require.Len(t, candidates, 1)
+	require.Equal(t, small, candidates[0].Peer)
+}
+
+func TestDispatchRegionPriority(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+	opts := Options{Regions: []Region{europe, northAmerica}, MultiStore: n1.Ms, Blockstore: n1.Bs}
+	supply, err := NewReplication(n1.Host, idx, n1.Dt, NewMockRetriever(n1.Dt, idx), opts)
+	require.NoError(t, err)
+
+	eu := testutil.NewTestNode(mn, t).Host.ID()
+	na1 := testutil.NewTestNode(mn, t).Host.ID()
+	na2 := testutil.NewTestNode(mn, t).Host.ID()
+	both := testutil.NewTestNode(mn, t).Host.ID()
+	supply.pm.handleHey(eu, Hey{Regions: []RegionCode{EuropeRegion}, Capacity: 10000})
+	supply.pm.handleHey(na1, Hey{Regions: []RegionCode{NorthAmericaRegion}, Capacity: 10000})
+	supply.pm.handleHey(na2, Hey{Regions: []RegionCode{NorthAmericaRegion}, Capacity: 10000})
+	supply.pm.handleHey(both, Hey{Regions: []RegionCode{EuropeRegion, NorthAmericaRegion}, Capacity: 10000})
+
+	// Only the primary region is used at first
+	primary := supply.selectProviders(3, 1000, DispatchOptions{}, supply.dispatchRegions(DispatchOptions{
+		Regions: []Region{europe, northAmerica},
+	}, 1), nil)
+	require.ElementsMatch(t, []peer.ID{eu, both}, primary)
+
+	// Peers in the primary region are selected before spilling into the secondary region
+	candidates := supply.Candidates(1000, DispatchOptions{RF: 3, Regions: []Region{europe, northAmerica}})
+	require.Len(t, candidates, 3)
+	require.ElementsMatch(t, []peer.ID{eu, both}, []peer.ID{candidates[0].Peer, candidates[1].Peer})
+	require.Contains(t, []peer.ID{na1, na2}, candidates[2].Peer)
+
+	// Peers in multiple regions are only selected once
+	candidates = supply.Candidates(1000, DispatchOptions{RF: 4, Regions: []Region{northAmerica, europe}})
+	require.Len(t, candidates, 4)
+	require.ElementsMatch(t, []peer.ID{na1, na2, both}, []peer.ID{candidates[0].Peer, candidates[1].Peer, candidates[2].Peer})
+	require.Equal(t, eu, candidates[3].Peer)
+}
+
+func TestDispatchCancel(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+	// n2 is not linked so our requests never reach it
+	n2 := testutil.NewTestNode(mn, t)
+
+	fname := n1.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := n1.LoadFileToNewStore(ctx, t, fname)
+	rootCid := link.(cidlink.Link).Cid
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+	opts := Options{Regions: []Region{global}, MultiStore: n1.Ms, Blockstore: n1.Bs}
+	supply, err := NewReplication(n1.Host, idx, n1.Dt, NewMockRetriever(n1.Dt, idx), opts)
+	require.NoError(t, err)
+	require.NoError(t, supply.Start(ctx))
+
+	cancelled := make(chan ReplicationState, 1)
+	unsub := supply.SubscribeToEvents(func(event ReplicationEvent, state ReplicationState) {
+		if event == ReplicationEventDispatchCancelled {
+			cancelled <- state
+		}
+	})
+	defer unsub()
+
+	// A token issued before the dispatch is cancelled
+	tok, err := supply.IssuePullToken(rootCid, n2.Host.ID(), PullTokenTTL, 0)
+	require.NoError(t, err)
+
+	res, err := supply.Dispatch(rootCid, uint64(len(origBytes)), DispatchOptions{
+		BackoffMin:     time.Minute,
+		BackoffAttemps: 4,
+		RF:             1,
+		StoreID:        storeID,
+		Peers:          []peer.ID{n2.Host.ID()},
+	})
+	require.NoError(t, err)
+
+	// Wait for the request to be sent
+	time.Sleep(200 * time.Millisecond)
+
+	res.Cancel()
+	_, ok := <-res.Out()
+	require.False(t, ok)
+
+	state := <-cancelled
+	require.Equal(t, rootCid, state.PayloadCID)
+	require.Equal(t, 0, supply.Scheduler().InUse())
+
+	req := &Request{Method: Dispatch, PayloadCID: rootCid, Size: uint64(len(origBytes)), Token: &tok}
+	_, err = supply.ValidatePull(false, datatransfer.ChannelID{}, n2.Host.ID(), req, rootCid, nil)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	// Tokens for content we don't have are useless
+	unknown := blockGen.Next().Cid()
+	utok, err := supply.IssuePullToken(unknown, n2.Host.ID(), PullTokenTTL, 1024)
+	require.NoError(t, err)
+	req = &Request{Method: Pull, PayloadCID: unknown, Size: 1024, Token: &utok}
+	_, err = supply.ValidatePull(false, datatransfer.ChannelID{}, n2.Host.ID(), req, unknown, nil)
+	require.Error(t, err)
+}
+
+func TestPullFromFleet(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n2.SetupDataTransfer(ctx, t)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	fname := n1.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := n1.LoadFileToNewStore(ctx, t, fname)
+	rootCid := link.(cidlink.Link).Cid
+	store, err := n1.Ms.Get(storeID)
+	require.NoError(t, err)
+	require.NoError(t, utils.MigrateBlocks(ctx, store.Bstore, n1.Bs))
+
+	newReplication := func(n *testutil.TestNode, trusted peer.ID) *Replication {
+		idx, err := NewIndex(n.Ds, n.Bs)
+		require.NoError(t, err)
+		opts := Options{
+			Regions:      []Region{global},
+			MultiStore:   n.Ms,
+			Blockstore:   n.Bs,
+			TrustedPeers: []peer.ID{trusted},
+		}
+		r, err := NewReplication(n.Host, idx, n.Dt, NewMockRetriever(n.Dt, idx), opts)
+		require.NoError(t, err)
+		require.NoError(t, r.Start(ctx))
+		return r
+	}
+	r1 := newReplication(n1, n2.Host.ID())
+	r2 := newReplication(n2, n1.Host.ID())
+	require.NoError(t, r1.idx.SetRef(&DataRef{
+		PayloadCID:  rootCid,
+		PayloadSize: int64(len(origBytes)),
+	}))
+
+	// We only pull from fleet peers we know hold the content
+	require.ErrorIs(t, r2.pullFromFleet(ctx, rootCid), ErrNoFleetProvider)
+
+	r2.Catalog().Add(rootCid, n1.Host.ID())
+	require.NoError(t, r2.pullFromFleet(ctx, rootCid))
+
+	ref, err := r2.idx.PeekRef(rootCid)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, ref.PayloadSize, int64(len(origBytes)))
+	n2.VerifyFileTransferred(ctx, t, n2.DAG, rootCid, origBytes)
+}
+
+func TestReplicaSets(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n1.SetupDataTransfer(ctx, t)
+
+	idx, err := NewIndex(n1.Ds, n1.Bs)
+	require.NoError(t, err)
+	opts := Options{Regions: []Region{global}, MultiStore: n1.Ms, Blockstore: n1.Bs}
+	supply, err := NewReplication(n1.Host, idx, n1.Dt, NewMockRetriever(n1.Dt, idx), opts)
+	require.NoError(t, err)
+
+	root := blockGen.Next().Cid()
+	p1 := testutil.NewTestNode(mn, t).Host.ID()
+	p2 := testutil.NewTestNode(mn, t).Host.ID()
+
+	// Only the refs we track record their holders
+	supply.addReplica(root, p1)
+	_, ok := supply.Replicas(root)
+	require.False(t, ok)
+
+	supply.trackReplicas(root, 3)
+	supply.trackReplicas(root, 1)
+	supply.addReplica(root, p1)
+	supply.addReplica(root, p2)
+	supply.addReplica(root, p2)
+	set, ok := supply.Replicas(root)
+	require.True(t, ok)
+	require.Equal(t, 3, set.RF)
+	require.Equal(t, []peer.ID{p1, p2}, set.Holders)
+
+	require.Equal(t, []cid.Cid{root}, supply.forgetHolder(p1))
+	require.False(t, supply.removeReplica(root, p1))
+
+	// The sets are restored after a restart
+	restarted := &Replication{
+		replicas: make(map[cid.Cid]*ReplicaSet),
+		rstore:   newReplicaStore(idx.ds),
+	}
+	require.NoError(t, restarted.loadReplicas())
+	set, ok = restarted.Replicas(root)
+	require.True(t, ok)
+	require.Equal(t, 3, set.RF)
+	require.Equal(t, []peer.ID{p2}, set.Holders)
+
+	restarted.dropReplicas(root)
+	sets, err := restarted.rstore.list()
+	require.NoError(t, err)
+	require.Len(t, sets, 0)
+}
@@ -0,0 +1,150 @@
+package exchange
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// PeerStats records the outcome of our past transfers with a peer
+type PeerStats struct {
+	Successes int
+	Failures  int
+	// Bytes is the total amount of bytes received from this peer in successful transfers
+	Bytes uint64
+	// Duration is the total time spent on successful transfers
+	Duration time.Duration
+}
+
+// Throughput returns the average bytes per second received from the peer
+func (ps PeerStats) Throughput() float64 {
+	if ps.Duration <= 0 {
+		return 0
+	}
+	return float64(ps.Bytes) / ps.Duration.Seconds()
+}
+
+// Reputation scores peers based on the transfers we executed with them
+type Reputation struct {
+	mu    sync.Mutex
+	stats map[peer.ID]PeerStats
+}
+
+// NewReputation creates a new Reputation instance
+func NewReputation() *Reputation {
+	return &Reputation{
+		stats: make(map[peer.ID]PeerStats),
+	}
+}
+
+// RecordSuccess adds a successful transfer of a given size and duration to the peer stats
+func (r *Reputation) RecordSuccess(p peer.ID, size uint64, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[p]
+	s.Successes++
+	s.Bytes += size
+	s.Duration += d
+	r.stats[p] = s
+}
+
+// RecordFailure adds a failed transfer to the peer stats
+func (r *Reputation) RecordFailure(p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[p]
+	s.Failures++
+	r.stats[p] = s
+}
+
+// Stats returns the stats we have for a given peer
+func (r *Reputation) Stats(p peer.ID) PeerStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats[p]
+}
+
+// Score returns a value between 0 and 1 for a given peer. Half of the score is the success rate and
+// the other half is the throughput relative to the fastest peer we know. Unknown peers get a neutral score.
+func (r *Reputation) Score(p peer.ID) float64 {
+	return r.score(p, 0.5)
+}
+
+// score computes the peer score using hint as the relative throughput if we never
+// completed a transfer with this peer
+func (r *Reputation) score(p peer.ID, hint float64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[p]
+	// smooth the success rate so a single transfer doesn't make or break a peer
+	rate := float64(s.Successes+1) / float64(s.Successes+s.Failures+2)
+
+	var max float64
+	for _, ps := range r.stats {
+		max = math.Max(max, ps.Throughput())
+	}
+	speed := hint
+	if max > 0 && s.Successes > 0 {
+		speed = s.Throughput() / max
+	}
+	return (rate + speed) / 2
+}
+
+// loadWeight is how much the load advertised by a provider lowers its offer ranking
+const loadWeight = 0.1
+
+// rankOffers sorts offers by reputation of the provider minus the price relative to the most expensive offer.
+// priceWeight controls how much the price matters compared to the reputation. The throughput and load advertised
+// in the offers are used as hints for providers we have no history with and to break ties between busy providers.
+func (r *Reputation) rankOffers(offers []deal.Offer, priceWeight float64) {
+	maxPrice := big.Zero()
+	var maxThroughput, maxLoad uint64
+	for _, of := range offers {
+		if of.MinPricePerByte.GreaterThan(maxPrice) {
+			maxPrice = of.MinPricePerByte
+		}
+		if of.Throughput > maxThroughput {
+			maxThroughput = of.Throughput
+		}
+		if of.Load > maxLoad {
+			maxLoad = of.Load
+		}
+	}
+	values := make([]float64, len(offers))
+	for i, of := range offers {
+		hint := 0.5
+		if maxThroughput > 0 {
+			hint = float64(of.Throughput) / float64(maxThroughput)
+		}
+		var score float64
+		if info, err := of.AddrInfo(); err == nil {
+			score = r.score(info.ID, hint)
+		}
+		if maxLoad > 0 {
+			score -= loadWeight * float64(of.Load) / float64(maxLoad)
+		}
+		if !maxPrice.IsZero() {
+			// keep 6 decimals of precision on the relative price
+			rel := big.Div(big.Mul(of.MinPricePerByte, big.NewInt(1e6)), maxPrice)
+			score -= priceWeight * float64(rel.Int64()) / 1e6
+		}
+		values[i] = score
+	}
+	idx := make([]int, len(offers))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return values[idx[i]] > values[idx[j]]
+	})
+	sorted := make([]deal.Offer, len(offers))
+	for i, k := range idx {
+		sorted[i] = offers[k]
+	}
+	copy(offers, sorted)
+}
@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReputation(t *testing.T) {
+	newOffer := func(price int64) (peer.ID, deal.Offer) {
+		p := test.RandPeerIDFatal(t)
+		addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/41504")},
+		})
+		require.NoError(t, err)
+		return p, deal.Offer{
+			MinPricePerByte: abi.NewTokenAmount(price),
+			PeerAddr:        addrs[0].Bytes(),
+		}
+	}
+
+	rep := NewReputation()
+
+	fast, fastOffer := newOffer(2)
+	slow, slowOffer := newOffer(1)
+	flaky, flakyOffer := newOffer(1)
+	_, newcomerOffer := newOffer(1)
+
+	rep.RecordSuccess(fast, 1000, time.Second)
+	rep.RecordSuccess(fast, 1000, time.Second)
+	rep.RecordSuccess(slow, 100, time.Second)
+	rep.RecordFailure(flaky)
+	rep.RecordFailure(flaky)
+
+	require.Equal(t, 2, rep.Stats(fast).Successes)
+	require.Equal(t, float64(1000), rep.Stats(fast).Throughput())
+	require.Greater(t, rep.Score(fast), rep.Score(slow))
+	require.Greater(t, rep.Score(slow), rep.Score(flaky))
+
+	offers := []deal.Offer{flakyOffer, newcomerOffer, slowOffer, fastOffer}
+	// ignoring the price the most reliable and fastest peer comes first
+	rep.rankOffers(offers, 0)
+	require.Equal(t, fastOffer, offers[0])
+	require.Equal(t, flakyOffer, offers[3])
+
+	// if the price matters a lot the cheaper peers go first
+	rep.rankOffers(offers, 10)
+	require.Equal(t, fastOffer, offers[3])
+}
+
+func TestReputationHints(t *testing.T) {
+	newOffer := func(throughput, load uint64) deal.Offer {
+		p := test.RandPeerIDFatal(t)
+		addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/41504")},
+		})
+		require.NoError(t, err)
+		return deal.Offer{
+			MinPricePerByte: abi.NewTokenAmount(1),
+			PeerAddr:        addrs[0].Bytes(),
+			Throughput:      throughput,
+			Load:            load,
+		}
+	}
+
+	rep := NewReputation()
+
+	fast := newOffer(1000, 0)
+	slow := newOffer(100, 0)
+	busy := newOffer(1000, 10)
+
+	// without history the advertised throughput and load decide
+	offers := []deal.Offer{slow, busy, fast}
+	rep.rankOffers(offers, 0)
+	require.Equal(t, fast, offers[0])
+
+	// same with the default strategy as the price is the same
+	offers = []deal.Offer{slow, busy, fast}
+	sortOffers(offers)
+	require.Equal(t, []deal.Offer{fast, slow, busy}, offers)
+}
@@ -0,0 +1,161 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// pendingPull is a dispatch pull in progress. It is persisted so the transfer can be restarted
+// after a disconnection or a restart instead of pulling the content all over again.
+type pendingPull struct {
+	Peer    peer.ID
+	Request Request
+	StoreID multistore.StoreID
+	Channel datatransfer.ChannelID
+	// Incentive is the payment offered for holding the content if any
+	Incentive *Incentive
+	// Private content is not listed on our public index
+	Private bool
+}
+
+// pullStore persists the dispatch pulls in progress and keeps track of the ones we are watching
+type pullStore struct {
+	ds datastore.Batching
+
+	mu sync.Mutex
+	// active pulls are closed once the content is migrated to our blockstore and indexed
+	active map[cid.Cid]chan struct{}
+}
+
+func newPullStore(ds datastore.Batching) *pullStore {
+	return &pullStore{
+		ds:     namespace.Wrap(ds, datastore.NewKey("/pulls")),
+		active: make(map[cid.Cid]chan struct{}),
+	}
+}
+
+func (ps *pullStore) put(pp pendingPull) error {
+	b, err := json.Marshal(pp)
+	if err != nil {
+		return err
+	}
+	return ps.ds.Put(datastore.NewKey(pp.Request.PayloadCID.String()), b)
+}
+
+func (ps *pullStore) remove(root cid.Cid) error {
+	return ps.ds.Delete(datastore.NewKey(root.String()))
+}
+
+func (ps *pullStore) list() ([]pendingPull, error) {
+	res, err := ps.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var pulls []pendingPull
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var pp pendingPull
+		if err := json.Unmarshal(r.Value, &pp); err != nil {
+			log.Error().Err(err).Str("key", r.Key).Msg("invalid pending pull")
+			continue
+		}
+		pulls = append(pulls, pp)
+	}
+	return pulls, nil
+}
+
+// activate returns true if nobody is watching the pull for the given root yet
+func (ps *pullStore) activate(root cid.Cid) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.active[root]; ok {
+		return false
+	}
+	ps.active[root] = make(chan struct{})
+	return true
+}
+
+func (ps *pullStore) deactivate(root cid.Cid) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if done, ok := ps.active[root]; ok {
+		close(done)
+		delete(ps.active, root)
+	}
+}
+
+// done returns a channel closed once the pull we are watching for the given root is over
+// or nil if we are not pulling it
+func (ps *pullStore) done(root cid.Cid) <-chan struct{} {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.active[root]
+}
+
+// restartPulls resumes the interrupted dispatch pulls from the given peer or from any peer if empty
+func (r *Replication) restartPulls(ctx context.Context, from peer.ID) {
+	pulls, err := r.pulls.list()
+	if err != nil {
+		log.Error().Err(err).Msg("error when listing pending pulls")
+		return
+	}
+	for _, pp := range pulls {
+		if from != "" && pp.Peer != from {
+			continue
+		}
+		// Pulls from peers who are offline are restarted when they reconnect
+		if r.h.Network().Connectedness(pp.Peer) != network.Connected {
+			continue
+		}
+		root := pp.Request.PayloadCID
+		if !r.pulls.activate(root) {
+			continue
+		}
+		if err := r.AddStore(root, pp.StoreID); err != nil {
+			log.Error().Err(err).Msg("error when loading store")
+			r.pulls.deactivate(root)
+			continue
+		}
+		if err := r.dt.RestartDataTransferChannel(ctx, pp.Channel); err != nil {
+			// The channel is gone or the dispatching peer doesn't want to resume it
+			log.Error().Err(err).Str("root", root.String()).Msg("error when restarting pull")
+			r.RmStore(root)
+			if err := r.ms.Delete(pp.StoreID); err != nil {
+				log.Error().Err(err).Msg("error when deleting store")
+			}
+			if err := r.pulls.remove(root); err != nil {
+				log.Error().Err(err).Msg("error when removing pull")
+			}
+			r.pulls.deactivate(root)
+			continue
+		}
+		log.Info().Str("peer", pp.Peer.String()).Str("root", root.String()).Msg("restarted dispatch pull")
+		go r.watchPull(ctx, pp)
+	}
+}
+
+// restartOnConnect resumes the pulls interrupted by a peer going offline when they reconnect
+func (r *Replication) restartOnConnect(ctx context.Context) {
+	r.h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			go func() {
+				defer r.sup.Recover("restart-pulls")
+				r.restartPulls(ctx, c.RemotePeer())
+			}()
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullStore(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ps := newPullStore(ds)
+
+	root := blockGen.Next().Cid()
+	tok := PullToken{PayloadCID: root, Peer: n2.Host.ID(), Expiry: 10, Signature: []byte("sig")}
+	pp := pendingPull{
+		Peer:    n1.Host.ID(),
+		Request: Request{Method: Dispatch, PayloadCID: root, Size: 1024, Token: &tok},
+		StoreID: 3,
+		Channel: datatransfer.ChannelID{Initiator: n2.Host.ID(), Responder: n1.Host.ID(), ID: 7},
+	}
+	require.NoError(t, ps.put(pp))
+
+	// pulls survive a restart
+	pulls, err := newPullStore(ds).list()
+	require.NoError(t, err)
+	require.Equal(t, []pendingPull{pp}, pulls)
+
+	// the store of a pending pull is kept until the pull is done
+	r := &Replication{pulls: ps}
+	require.Contains(t, r.StoreIDs(), pp.StoreID)
+
+	// only one routine watches a pull at a time
+	require.Nil(t, ps.done(root))
+	require.True(t, ps.activate(root))
+	require.False(t, ps.activate(root))
+
+	// audits wait until the pull is over
+	done := ps.done(root)
+	require.NotNil(t, done)
+	ps.deactivate(root)
+	<-done
+	require.Nil(t, ps.done(root))
+	require.True(t, ps.activate(root))
+
+	require.NoError(t, ps.remove(root))
+	pulls, err = ps.list()
+	require.NoError(t, err)
+	require.Len(t, pulls, 0)
+}
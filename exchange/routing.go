@@ -22,6 +22,7 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	pb "github.com/libp2p/go-libp2p-pubsub/pb"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/internal/utils"
 	"github.com/myelnet/pop/retrieval/deal"
 	"github.com/rs/zerolog/log"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -81,14 +82,7 @@ type QueryStream struct {
 
 // ReadQuery reads and decodes a CBOR encoded Query from a stream buffer.
 func (qs *QueryStream) ReadQuery() (deal.Query, error) {
-	var q deal.Query
-
-	if err := q.UnmarshalCBOR(qs.buf); err != nil {
-		return deal.Query{}, err
-
-	}
-
-	return q, nil
+	return DecodeQuery(qs.buf)
 }
 
 // WriteQuery encodes and writes a CBOR Query message to a stream.
@@ -166,6 +160,9 @@ type GossipRouting struct {
 	regions        []Region
 	rmu            sync.Mutex
 	receiveOffer   ReceiveOffer
+	relayedOffer   ReceiveOffer
+	// sup recovers from panics in our handlers and restarts the query pumps
+	sup *utils.Supervisor
 }
 
 // NewGossipRouting creates a new GossipRouting service
@@ -183,24 +180,22 @@ func NewGossipRouting(h host.Host, ps *pubsub.PubSub, meta MessageTracker, rgs [
 	return routing
 }
 
-// StartProviding opens up our gossip subscription and sets our stream handler
-func (gr *GossipRouting) StartProviding(ctx context.Context, fn ResponseFunc) error {
-	// The PopQueryProtocolID handler expects offer messages from peers who received a gossip query
-	gr.h.SetStreamHandler(PopQueryProtocolID, gr.handleOffer)
-
+// handleQueries sets a stream handler answering the queries peers send us directly
+func handleQueries(ctx context.Context, h host.Host, sup *utils.Supervisor, r Region, fn ResponseFunc) {
 	// The FilQueryProtocolID handler expects query messages
-	gr.h.SetStreamHandler(FilQueryProtocolID, func(s network.Stream) {
+	h.SetStreamHandler(FilQueryProtocolID, func(s network.Stream) {
+		defer sup.Recover("query-handler")
 		buffered := bufio.NewReaderSize(s, 16)
 		defer s.Close()
 
 		receivedFrom := s.Conn().RemotePeer()
 
-		m := new(deal.Query)
-		if err := m.UnmarshalCBOR(buffered); err != nil {
+		m, err := DecodeQuery(buffered)
+		if err != nil {
+			log.Debug().Err(err).Str("peer", receivedFrom.String()).Msg("invalid query")
 			return
 		}
-		// supports single region only
-		offer, err := fn(ctx, receivedFrom, gr.regions[0], *m)
+		offer, err := fn(ctx, receivedFrom, r, m)
 		if err != nil {
 			return
 		}
@@ -212,6 +207,60 @@ func (gr *GossipRouting) StartProviding(ctx context.Context, fn ResponseFunc) er
 			log.Error().Err(err).Msg("writing query response")
 		}
 	})
+}
+
+// queryProvider asks a provider directly for retrieval conditions
+func queryProvider(h host.Host, p peer.AddrInfo, root cid.Cid, sel ipld.Node) (deal.Offer, error) {
+	params, err := deal.NewQueryParams(sel)
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	m := deal.Query{
+		PayloadCID:  root,
+		QueryParams: params,
+	}
+
+	s, err := OpenStream(context.Background(), h, p.ID, []protocol.ID{FilQueryProtocolID})
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	stream := &QueryStream{p: p.ID, rw: s, buf: bufio.NewReaderSize(s, 16)}
+	defer stream.Close()
+
+	err = stream.WriteQuery(m)
+	if err != nil {
+		return deal.Offer{}, err
+	}
+
+	res, err := stream.ReadQueryResponse()
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(&p)
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	return deal.Offer{
+		PeerAddr:                   addrs[0].Bytes(),
+		PayloadCID:                 root,
+		Size:                       res.Size,
+		PaymentAddress:             res.PaymentAddress,
+		MinPricePerByte:            res.MinPricePerByte,
+		MaxPaymentInterval:         res.MaxPaymentInterval,
+		MaxPaymentIntervalIncrease: res.MaxPaymentIntervalIncrease,
+		UnsealPrice:                res.UnsealPrice,
+		RedirectAddr:               res.RedirectAddr,
+		RedirectSig:                res.RedirectSig,
+	}, nil
+}
+
+// StartProviding opens up our gossip subscription and sets our stream handler
+func (gr *GossipRouting) StartProviding(ctx context.Context, fn ResponseFunc) error {
+	// The PopQueryProtocolID handler expects offer messages from peers who received a gossip query
+	gr.h.SetStreamHandler(PopQueryProtocolID, gr.handleOffer)
+
+	// supports single region only
+	handleQueries(ctx, gr.h, gr.sup, gr.regions[0], fn)
 
 	for i, r := range gr.regions {
 		top, err := gr.ps.Join(fmt.Sprintf("%s/%s", PopQueryProtocolID, r.Name))
@@ -223,7 +272,9 @@ func (gr *GossipRouting) StartProviding(ctx context.Context, fn ResponseFunc) er
 		if err != nil {
 			return err
 		}
-		go gr.pump(ctx, sub, fn)
+		gr.sup.Go(ctx, "query-pump", func(ctx context.Context) {
+			gr.pump(ctx, sub, fn)
+		})
 	}
 
 	return nil
@@ -239,11 +290,11 @@ func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, fn
 		if msg.ReceivedFrom == gr.h.ID() {
 			continue
 		}
-		m := new(deal.Query)
-		if err := m.UnmarshalCBOR(bytes.NewReader(msg.Data)); err != nil {
+		m, err := DecodeQuery(bytes.NewReader(msg.Data))
+		if err != nil {
 			continue
 		}
-		offer, err := fn(ctx, msg.ReceivedFrom, r, *m)
+		offer, err := fn(ctx, msg.ReceivedFrom, r, m)
 		if err != nil {
 			continue
 		}
@@ -271,41 +322,7 @@ func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, fn
 
 // QueryProvider asks a provider directly for retrieval conditions
 func (gr *GossipRouting) QueryProvider(p peer.AddrInfo, root cid.Cid, sel ipld.Node) (deal.Offer, error) {
-	params, err := deal.NewQueryParams(sel)
-	if err != nil {
-		return deal.Offer{}, err
-	}
-	m := deal.Query{
-		PayloadCID:  root,
-		QueryParams: params,
-	}
-
-	stream, err := gr.NewQueryStream(p.ID, []protocol.ID{FilQueryProtocolID})
-	defer stream.Close()
-
-	err = stream.WriteQuery(m)
-	if err != nil {
-		return deal.Offer{}, err
-	}
-
-	res, err := stream.ReadQueryResponse()
-	if err != nil {
-		return deal.Offer{}, err
-	}
-	addrs, err := peer.AddrInfoToP2pAddrs(&p)
-	if err != nil {
-		return deal.Offer{}, err
-	}
-	return deal.Offer{
-		PeerAddr:                   addrs[0].Bytes(),
-		PayloadCID:                 root,
-		Size:                       res.Size,
-		PaymentAddress:             res.PaymentAddress,
-		MinPricePerByte:            res.MinPricePerByte,
-		MaxPaymentInterval:         res.MaxPaymentInterval,
-		MaxPaymentIntervalIncrease: res.MaxPaymentIntervalIncrease,
-		UnsealPrice:                res.UnsealPrice,
-	}, nil
+	return queryProvider(gr.h, p, root, sel)
 }
 
 // Query asks the gossip network of providers if anyone can provide the blocks we're looking for
@@ -343,6 +360,13 @@ func (gr *GossipRouting) SetReceiver(fn ReceiveOffer) {
 	gr.rmu.Unlock()
 }
 
+// SetRelayObserver sets a callback to observe the offers we forward back to other peers
+func (gr *GossipRouting) SetRelayObserver(fn ReceiveOffer) {
+	gr.rmu.Lock()
+	gr.relayedOffer = fn
+	gr.rmu.Unlock()
+}
+
 // NewQueryStream creates a new query stream using the provided peer.ID to handle the Query protocols
 func (gr *GossipRouting) NewQueryStream(dest peer.ID, protos []protocol.ID) (*QueryStream, error) {
 	s, err := OpenStream(context.Background(), gr.h, dest, protos)
@@ -357,6 +381,7 @@ func (gr *GossipRouting) NewQueryStream(dest peer.ID, protos []protocol.ID) (*Qu
 // any query we published. If we did publish it means we are expecting responses so we read the offer
 // and send it to the receiver if not and we have a sender for the message reference we forward it back.
 func (gr *GossipRouting) handleOffer(s network.Stream) {
+	defer gr.sup.Recover("offer-handler")
 	buffered := bufio.NewReaderSize(s, 16)
 	defer s.Close()
 
@@ -374,6 +399,16 @@ func (gr *GossipRouting) handleOffer(s network.Stream) {
 			log.Error().Err(err).Msg("failed to find message recipient")
 			return
 		}
+		gr.rmu.Lock()
+		relayed := gr.relayedOffer
+		gr.rmu.Unlock()
+		if relayed != nil {
+			var offer deal.Offer
+			// decode a copy so the original bytes are forwarded untouched
+			if err := offer.UnmarshalCBOR(bytes.NewReader(buf.Bytes())); err == nil {
+				relayed(offer)
+			}
+		}
 		w, err := OpenStream(context.Background(), gr.h, to, gr.queryProtocols)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to open stream")
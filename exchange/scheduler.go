@@ -0,0 +1,135 @@
+package exchange
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxDispatchTransfers is the number of dispatch transfers we serve at once unless specified otherwise
+const DefaultMaxDispatchTransfers = 3
+
+// LowPowerMaxDispatchTransfers is the number of dispatch transfers served at once in low power mode
+const LowPowerMaxDispatchTransfers = 1
+
+// measureWeight is the weight of the latest measurement in the moving averages of the scheduler
+const measureWeight = 0.3
+
+// DispatchScheduler limits the transfers we serve at once across all our dispatches so a large commit
+// doesn't saturate our uplink. Requests are sent to peers as slots free up and the time we wait for them
+// is derived from the transfers we observed instead of a fixed backoff.
+type DispatchScheduler struct {
+	max int
+
+	mu   sync.Mutex
+	used int
+	// released is closed and replaced every time a slot is released
+	released chan struct{}
+	// throughput is the moving average of the throughput in bytes per second of our dispatch transfers
+	throughput float64
+	// latency is the moving average of the time peers take to start pulling after we sent a request
+	latency time.Duration
+}
+
+// NewDispatchScheduler creates a scheduler serving at most max transfers at once. 0 means no limit.
+func NewDispatchScheduler(max int) *DispatchScheduler {
+	return &DispatchScheduler{
+		max:      max,
+		released: make(chan struct{}),
+	}
+}
+
+// Reserve returns how many of the n slots requested are granted
+func (s *DispatchScheduler) Reserve(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && s.used+n > s.max {
+		n = s.max - s.used
+	}
+	if n < 0 {
+		n = 0
+	}
+	s.used += n
+	return n
+}
+
+// Release frees n slots and wakes up the dispatches waiting for one
+func (s *DispatchScheduler) Release(n int) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	if s.used < 0 {
+		s.used = 0
+	}
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// Released returns a channel closed the next time a slot is released
+func (s *DispatchScheduler) Released() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.released
+}
+
+// InUse returns the number of slots currently reserved
+func (s *DispatchScheduler) InUse() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// ObserveLatency records how long a peer took to start pulling after we sent a request
+func (s *DispatchScheduler) ObserveLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency == 0 {
+		s.latency = d
+		return
+	}
+	s.latency = time.Duration(measureWeight*float64(d) + (1-measureWeight)*float64(s.latency))
+}
+
+// ObserveTransfer records a dispatch transfer of the given size which took d to complete
+func (s *DispatchScheduler) ObserveTransfer(size uint64, d time.Duration) {
+	if size == 0 || d <= 0 {
+		return
+	}
+	tp := float64(size) / d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.throughput == 0 {
+		s.throughput = tp
+		return
+	}
+	s.throughput = measureWeight*tp + (1-measureWeight)*s.throughput
+}
+
+// Throughput returns the average throughput in bytes per second of our dispatch transfers or 0 if unknown
+func (s *DispatchScheduler) Throughput() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throughput
+}
+
+// Delay returns how long to wait for the peers we sent requests to before trying others. Slow peers get
+// twice the latency we usually observe to start pulling, min is used if it is longer.
+func (s *DispatchScheduler) Delay(min time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := 2 * s.latency; d > min {
+		return d
+	}
+	return min
+}
+
+// Timeout returns how long to wait for the transfers of content of the given size to complete based on
+// the throughput we observed
+func (s *DispatchScheduler) Timeout(size uint64) time.Duration {
+	return TransferTimeout(size, s.Throughput())
+}
@@ -0,0 +1,60 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchScheduler(t *testing.T) {
+	s := NewDispatchScheduler(3)
+
+	require.Equal(t, 2, s.Reserve(2))
+	// only the remaining slot is granted
+	require.Equal(t, 1, s.Reserve(4))
+	require.Equal(t, 0, s.Reserve(1))
+	require.Equal(t, 3, s.InUse())
+
+	released := s.Released()
+	select {
+	case <-released:
+		t.Fatal("no slot was released")
+	default:
+	}
+	s.Release(2)
+	select {
+	case <-released:
+	default:
+		t.Fatal("waiters should be notified")
+	}
+	require.Equal(t, 1, s.InUse())
+	require.Equal(t, 2, s.Reserve(2))
+
+	// no limit
+	s = NewDispatchScheduler(0)
+	require.Equal(t, 100, s.Reserve(100))
+	require.Equal(t, 0, s.Reserve(-1))
+}
+
+func TestDispatchSchedulerTiming(t *testing.T) {
+	s := NewDispatchScheduler(0)
+
+	// without measurements we use the defaults
+	require.Equal(t, time.Second, s.Delay(time.Second))
+	require.Equal(t, TransferTimeout(1<<30, 0), s.Timeout(1<<30))
+
+	// peers are slow to start pulling
+	s.ObserveLatency(2 * time.Second)
+	require.Equal(t, 4*time.Second, s.Delay(time.Second))
+	require.Equal(t, 10*time.Second, s.Delay(10*time.Second))
+
+	// transfers are faster than our default estimate
+	s.ObserveTransfer(1<<30, 100*time.Second)
+	require.Equal(t, float64(1<<30)/100, s.Throughput())
+	require.Less(t, int64(s.Timeout(1<<30)), int64(TransferTimeout(1<<30, 0)))
+
+	s.ObserveTransfer(1<<30, 200*time.Second)
+	require.Less(t, s.Throughput(), float64(1<<30)/100)
+	require.Greater(t, s.Throughput(), float64(1<<30)/200)
+}
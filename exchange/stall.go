@@ -0,0 +1,101 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// DefaultStallTimeout is the duration without any progress after which a transfer is considered stalled
+const DefaultStallTimeout = 30 * time.Second
+
+// ErrTransferStalled is returned when a transfer made no progress during the stall timeout
+var ErrTransferStalled = errors.New("transfer stalled")
+
+// stallDetector keeps track of the last time a transfer made progress so a silently hung channel
+// doesn't hold an operation until its global timeout
+type stallDetector struct {
+	timeout time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	// paused transfers are waiting on us rather than on the provider
+	paused bool
+}
+
+func newStallDetector(timeout time.Duration) *stallDetector {
+	return &stallDetector{
+		timeout: timeout,
+		last:    time.Now(),
+	}
+}
+
+// Progress records that the transfer is still moving
+func (s *stallDetector) Progress() {
+	s.mu.Lock()
+	s.last = time.Now()
+	s.mu.Unlock()
+}
+
+// Pause stops counting the time without progress, i.e. while we top up the payment channel
+func (s *stallDetector) Pause() {
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+}
+
+// Resume counts the time without progress again from now
+func (s *stallDetector) Resume() {
+	s.mu.Lock()
+	s.paused = false
+	s.last = time.Now()
+	s.mu.Unlock()
+}
+
+// Stalled returns whether the transfer made no progress for longer than the timeout
+func (s *stallDetector) Stalled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.paused && time.Since(s.last) > s.timeout
+}
+
+// Watch returns a channel which is closed once the transfer is stalled. The watch stops when the context is done.
+// A timeout of 0 or less disables stall detection and the channel is never closed.
+func (s *stallDetector) Watch(ctx context.Context) <-chan struct{} {
+	stalled := make(chan struct{})
+	if s.timeout <= 0 {
+		return stalled
+	}
+	go func() {
+		ticker := time.NewTicker(s.timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.Stalled() {
+					close(stalled)
+					return
+				}
+			}
+		}
+	}()
+	return stalled
+}
+
+// waitingForFunds returns whether a retrieval is waiting for our payment channel to be created or funded
+// on chain, or for us to top it up, rather than for the provider
+func waitingForFunds(status deal.Status) bool {
+	switch status {
+	case deal.StatusPaymentChannelCreating,
+		deal.StatusPaymentChannelAddingInitialFunds,
+		deal.StatusPaymentChannelAddingFunds,
+		deal.StatusInsufficientFunds:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStallDetector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newStallDetector(40 * time.Millisecond)
+	stalled := s.Watch(ctx)
+
+	// keep making progress for longer than the timeout
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.Progress()
+		select {
+		case <-stalled:
+			t.Fatal("should not be stalled while making progress")
+		default:
+		}
+	}
+
+	select {
+	case <-stalled:
+	case <-time.After(time.Second):
+		t.Fatal("should detect the stall")
+	}
+	require.True(t, s.Stalled())
+
+	// a paused transfer is not stalled until it resumes
+	s = newStallDetector(40 * time.Millisecond)
+	s.Pause()
+	time.Sleep(60 * time.Millisecond)
+	require.False(t, s.Stalled())
+	s.Resume()
+	require.False(t, s.Stalled())
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, s.Stalled())
+
+	// a timeout of 0 disables detection
+	s = newStallDetector(0)
+	select {
+	case <-s.Watch(ctx):
+		t.Fatal("should never be stalled")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWaitingForFunds(t *testing.T) {
+	require.True(t, waitingForFunds(deal.StatusPaymentChannelCreating))
+	require.True(t, waitingForFunds(deal.StatusPaymentChannelAddingFunds))
+	require.True(t, waitingForFunds(deal.StatusInsufficientFunds))
+	require.False(t, waitingForFunds(deal.StatusOngoing))
+	require.False(t, waitingForFunds(deal.StatusWaitForAcceptance))
+}
@@ -0,0 +1,142 @@
+package exchange
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxInvalidMsgs is the number of invalid messages a peer can send us within StrikeWindow before it is blocked
+const MaxInvalidMsgs = 10
+
+// StrikeWindow is the period over which we count the invalid messages sent by a peer
+const StrikeWindow = 10 * time.Minute
+
+// BlockTTL is how long a peer blocked for sending invalid messages stays blocked
+const BlockTTL = 24 * time.Hour
+
+// PeerGater blocks connections with peers. It is implemented by the libp2p BasicConnectionGater.
+type PeerGater interface {
+	BlockPeer(peer.ID) error
+	UnblockPeer(peer.ID) error
+}
+
+type strikeCount struct {
+	n     int
+	since time.Time
+}
+
+// Strikes counts the invalid messages sent by each peer and blocks the ones sending too many of them.
+// Trusted peers are never blocked. A nil Strikes does nothing.
+type Strikes struct {
+	h     host.Host
+	gate  PeerGater
+	trust *Allowlist
+	// ds persists when the blocks expire so they are lifted after a restart too
+	ds  datastore.Batching
+	ttl time.Duration
+
+	mu     sync.Mutex
+	counts map[peer.ID]*strikeCount
+}
+
+// NewStrikes creates a new Strikes instance blocking peers with the given gater for BlockTTL
+func NewStrikes(h host.Host, gate PeerGater, trust *Allowlist, ds datastore.Batching) *Strikes {
+	s := &Strikes{
+		h:      h,
+		gate:   gate,
+		trust:  trust,
+		ds:     namespace.Wrap(ds, datastore.NewKey("/strikes")),
+		ttl:    BlockTTL,
+		counts: make(map[peer.ID]*strikeCount),
+	}
+	s.restoreBlocks()
+	return s
+}
+
+// restoreBlocks schedules the expiry of the blocks set before a restart
+func (s *Strikes) restoreBlocks() {
+	res, err := s.ds.Query(query.Query{})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list blocked peers")
+		return
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			log.Error().Err(r.Error).Msg("failed to list blocked peers")
+			return
+		}
+		p, err := peer.Decode(datastore.NewKey(r.Key).Name())
+		if err != nil {
+			continue
+		}
+		var until time.Time
+		if err := until.UnmarshalBinary(r.Value); err != nil {
+			continue
+		}
+		s.unblockAfter(p, time.Until(until))
+	}
+}
+
+// unblockAfter lifts the block of a peer once the given duration elapsed
+func (s *Strikes) unblockAfter(p peer.ID, d time.Duration) {
+	time.AfterFunc(d, func() {
+		if err := s.gate.UnblockPeer(p); err != nil {
+			log.Error().Err(err).Str("peer", p.String()).Msg("failed to unblock peer")
+			return
+		}
+		if err := s.ds.Delete(datastore.NewKey(p.String())); err != nil {
+			log.Error().Err(err).Str("peer", p.String()).Msg("failed to remove block expiry")
+		}
+	})
+}
+
+// Record counts a strike against a peer if the error is caused by an invalid message. It returns true if
+// the peer was blocked as a result.
+func (s *Strikes) Record(p peer.ID, err error) bool {
+	if s == nil || !errors.Is(err, ErrInvalidMsg) || s.trust.Trusted(p) {
+		return false
+	}
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.counts[p]
+	if !ok || now.Sub(c.since) > StrikeWindow {
+		c = &strikeCount{since: now}
+		s.counts[p] = c
+	}
+	c.n++
+	block := c.n >= MaxInvalidMsgs
+	if block {
+		delete(s.counts, p)
+	}
+	s.mu.Unlock()
+
+	if !block {
+		return false
+	}
+	if err := s.gate.BlockPeer(p); err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to block peer")
+		return false
+	}
+	log.Info().Str("peer", p.String()).Msg("blocked peer sending invalid messages")
+	until, err := time.Now().Add(s.ttl).MarshalBinary()
+	if err == nil {
+		err = s.ds.Put(datastore.NewKey(p.String()), until)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to persist block expiry")
+	}
+	s.unblockAfter(p, s.ttl)
+	if err := s.h.Network().ClosePeer(p); err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to close peer connections")
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type mockGater struct {
+	mu      sync.Mutex
+	blocked map[peer.ID]bool
+}
+
+func (g *mockGater) BlockPeer(p peer.ID) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.blocked[p] = true
+	return nil
+}
+
+func (g *mockGater) UnblockPeer(p peer.ID) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.blocked, p)
+	return nil
+}
+
+func (g *mockGater) isBlocked(p peer.ID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.blocked[p]
+}
+
+func TestStrikes(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+
+	gater := &mockGater{blocked: make(map[peer.ID]bool)}
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	s := NewStrikes(n1.Host, gater, NewAllowlist([]peer.ID{n3.Host.ID()}, ""), ds)
+
+	invalid := fmt.Errorf("%w: negative price", ErrInvalidMsg)
+	for i := 0; i < MaxInvalidMsgs-1; i++ {
+		require.False(t, s.Record(n2.Host.ID(), invalid))
+		// Network errors don't count
+		require.False(t, s.Record(n2.Host.ID(), io.EOF))
+		// Trusted peers are never blocked
+		require.False(t, s.Record(n3.Host.ID(), invalid))
+	}
+	require.False(t, gater.isBlocked(n2.Host.ID()))
+
+	require.True(t, s.Record(n2.Host.ID(), invalid))
+	require.True(t, gater.isBlocked(n2.Host.ID()))
+	require.False(t, s.Record(n3.Host.ID(), invalid))
+	require.False(t, gater.isBlocked(n3.Host.ID()))
+
+	// Blocks are lifted after their TTL, including the ones set before a restart
+	key := datastore.NewKey("/strikes/" + n2.Host.ID().String())
+	has, err := ds.Has(key)
+	require.NoError(t, err)
+	require.True(t, has)
+	expired, err := time.Now().Add(-time.Minute).MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, ds.Put(key, expired))
+
+	s = NewStrikes(n1.Host, gater, NewAllowlist(nil, ""), ds)
+	require.Eventually(t, func() bool { return !gater.isBlocked(n2.Host.ID()) }, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		has, err := ds.Has(key)
+		return err == nil && !has
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// A nil Strikes does nothing
+	var ns *Strikes
+	require.False(t, ns.Record(n2.Host.ID(), invalid))
+}
@@ -0,0 +1,128 @@
+package exchange
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// SummaryWindow is how long content observed in our regions remains in the summary
+const SummaryWindow = 24 * time.Hour
+
+// maxSummaryEntries is the number of CIDs kept per region, the least recently seen are forgotten first
+const maxSummaryEntries = 1024
+
+// RegionContent is a CID recently queried or dispatched in one of our regions
+type RegionContent struct {
+	PayloadCID cid.Cid
+	Region     string
+	// Queries is the number of queries we received for the content
+	Queries uint64
+	// Dispatches is the number of times the content was dispatched to us
+	Dispatches uint64
+	// Size is the size of the content if a dispatch told us about it
+	Size     uint64
+	LastSeen time.Time
+}
+
+// Score ranks content by how much demand we observed
+func (rc RegionContent) Score() uint64 {
+	return rc.Queries + rc.Dispatches
+}
+
+// RegionSummary is a rolling summary of the content observed in our regions. Operators can use it to pick
+// popular content to cache before it is dispatched to them.
+type RegionSummary struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	regions map[string]map[cid.Cid]*RegionContent
+}
+
+// NewRegionSummary creates a summary forgetting content not seen during the given window
+func NewRegionSummary(window time.Duration) *RegionSummary {
+	return &RegionSummary{
+		window:  window,
+		regions: make(map[string]map[cid.Cid]*RegionContent),
+	}
+}
+
+func (s *RegionSummary) entry(region string, root cid.Cid) *RegionContent {
+	entries, ok := s.regions[region]
+	if !ok {
+		entries = make(map[cid.Cid]*RegionContent)
+		s.regions[region] = entries
+	}
+	rc, ok := entries[root]
+	if !ok {
+		if len(entries) >= maxSummaryEntries {
+			s.forgetOldest(entries)
+		}
+		rc = &RegionContent{PayloadCID: root, Region: region}
+		entries[root] = rc
+	}
+	rc.LastSeen = time.Now()
+	return rc
+}
+
+func (s *RegionSummary) forgetOldest(entries map[cid.Cid]*RegionContent) {
+	var oldest *RegionContent
+	for _, rc := range entries {
+		if oldest == nil || rc.LastSeen.Before(oldest.LastSeen) {
+			oldest = rc
+		}
+	}
+	if oldest != nil {
+		delete(entries, oldest.PayloadCID)
+	}
+}
+
+// ObserveQuery records a query for the given content in a region
+func (s *RegionSummary) ObserveQuery(region string, root cid.Cid) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(region, root).Queries++
+}
+
+// ObserveDispatch records content of the given size dispatched to us in a region
+func (s *RegionSummary) ObserveDispatch(region string, root cid.Cid, size uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rc := s.entry(region, root)
+	rc.Dispatches++
+	rc.Size = size
+}
+
+// List returns the content observed in a region or in all regions if empty, most popular first
+func (s *RegionSummary) List(region string) []RegionContent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var list []RegionContent
+	for name, entries := range s.regions {
+		for k, rc := range entries {
+			if s.window > 0 && now.Sub(rc.LastSeen) > s.window {
+				delete(entries, k)
+				continue
+			}
+			if region == "" || region == name {
+				list = append(list, *rc)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score() == list[j].Score() {
+			return list[i].LastSeen.After(list[j].LastSeen)
+		}
+		return list[i].Score() > list[j].Score()
+	})
+	return list
+}
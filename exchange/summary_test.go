@@ -0,0 +1,39 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionSummary(t *testing.T) {
+	s := NewRegionSummary(time.Hour)
+
+	popular := blockGen.Next().Cid()
+	dispatched := blockGen.Next().Cid()
+	elsewhere := blockGen.Next().Cid()
+
+	s.ObserveQuery("Europe", popular)
+	s.ObserveQuery("Europe", popular)
+	s.ObserveQuery("Europe", popular)
+	s.ObserveDispatch("Europe", dispatched, 1024)
+	s.ObserveQuery("Asia", elsewhere)
+
+	list := s.List("Europe")
+	require.Len(t, list, 2)
+	require.Equal(t, popular, list[0].PayloadCID)
+	require.Equal(t, uint64(3), list[0].Queries)
+	require.Equal(t, dispatched, list[1].PayloadCID)
+	require.Equal(t, uint64(1), list[1].Dispatches)
+	require.Equal(t, uint64(1024), list[1].Size)
+
+	require.Len(t, s.List(""), 3)
+
+	// content we haven't seen during the window is forgotten
+	s.mu.Lock()
+	s.regions["Asia"][elsewhere].LastSeen = time.Now().Add(-2 * time.Hour)
+	s.mu.Unlock()
+	require.Len(t, s.List("Asia"), 0)
+	require.Len(t, s.List(""), 2)
+}
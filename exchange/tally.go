@@ -0,0 +1,408 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate cbor-gen-for TallyBalance TallySettlement
+
+// TallyProtocolID is the protocol for claiming an incentive from a frequent peer without a payment.
+// The amount is added to the tally of our mutual debts instead.
+const TallyProtocolID = protocol.ID("/myel/pop/tally/1.0")
+
+// TallySettleProtocolID is the protocol for paying the difference of the mutual debts with a frequent peer
+const TallySettleProtocolID = protocol.ID("/myel/pop/tally-settle/1.0")
+
+// DefaultNettingInterval is the interval at which we settle the difference of our mutual debts with frequent peers
+const DefaultNettingInterval = 24 * time.Hour
+
+// NettingMinExchanges is the number of incentives we must have both paid to and received from a peer
+// before we tally the next ones instead of paying them
+const NettingMinExchanges = 3
+
+// ErrTallyRejected is returned when a peer refuses to tally an incentive or a settlement
+var ErrTallyRejected = errors.New("tally rejected")
+
+// TallyBalance is the state of the mutual debts of two peers from the point of view of the sender
+type TallyBalance struct {
+	// Owed is what the sender owes the other peer
+	Owed abi.TokenAmount
+	// Due is what the other peer owes the sender
+	Due abi.TokenAmount
+}
+
+// TallySettlement pays the difference of the mutual debts of two peers with a single voucher.
+// Owed and Due are the debts being settled from the point of view of the payer.
+type TallySettlement struct {
+	Owed    abi.TokenAmount
+	Due     abi.TokenAmount
+	Channel address.Address
+	Voucher *paych.SignedVoucher
+}
+
+// tally is what we owe a peer and what the peer owes us for the incentives we didn't pay right away
+type tally struct {
+	Peer peer.ID
+	// Recipient is the address the peer claims its incentives with
+	Recipient address.Address
+	Owed      abi.TokenAmount
+	Due       abi.TokenAmount
+	// Paid and Received count the incentives we paid to and received from the peer in any form
+	Paid     uint64
+	Received uint64
+	// Payer is the address paying the incentives we tallied, undefined if we use our default address
+	Payer address.Address
+}
+
+// net is the amount we must pay the peer to settle our debts, it is negative if the peer owes us
+func (t tally) net() abi.TokenAmount {
+	return big.Sub(t.Owed, t.Due)
+}
+
+// frequent returns whether we exchanged enough incentives with the peer in both directions to net our debts
+func (t tally) frequent() bool {
+	return t.Paid >= NettingMinExchanges && t.Received >= NettingMinExchanges
+}
+
+// tallyStore persists the tally of each peer we exchange incentives with
+type tallyStore struct {
+	mu sync.Mutex
+	ds datastore.Batching
+}
+
+func newTallyStore(ds datastore.Batching) *tallyStore {
+	return &tallyStore{
+		ds: namespace.Wrap(ds, datastore.NewKey("/tallies")),
+	}
+}
+
+func (ts *tallyStore) getUnlocked(p peer.ID) (tally, error) {
+	t := tally{Peer: p, Owed: big.Zero(), Due: big.Zero()}
+	b, err := ts.ds.Get(datastore.NewKey(p.String()))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(b, &t)
+	return t, err
+}
+
+// get returns the tally of a peer, empty if we never exchanged incentives with it
+func (ts *tallyStore) get(p peer.ID) (tally, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.getUnlocked(p)
+}
+
+// update applies a change to the tally of a peer and returns the updated tally
+func (ts *tallyStore) update(p peer.ID, fn func(t *tally)) (tally, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t, err := ts.getUnlocked(p)
+	if err != nil {
+		return t, err
+	}
+	fn(&t)
+	b, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	return t, ts.ds.Put(datastore.NewKey(p.String()), b)
+}
+
+func (ts *tallyStore) list() ([]tally, error) {
+	res, err := ts.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var tallies []tally
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var t tally
+		if err := json.Unmarshal(r.Value, &t); err != nil {
+			log.Error().Err(err).Str("key", r.Key).Msg("invalid tally")
+			continue
+		}
+		tallies = append(tallies, t)
+	}
+	return tallies, nil
+}
+
+// countIncentive records an incentive paid to or received from a peer
+func (r *Replication) countIncentive(p peer.ID, paid bool) {
+	_, err := r.tallies.update(p, func(t *tally) {
+		if paid {
+			t.Paid++
+			return
+		}
+		t.Received++
+	})
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to count incentive")
+	}
+}
+
+// tallied returns whether we net our debts with a peer instead of paying each incentive
+func (r *Replication) tallied(p peer.ID) bool {
+	if r.nettingInterval <= 0 || !r.payable() {
+		return false
+	}
+	protos, err := r.h.Peerstore().SupportsProtocols(p, string(TallyProtocolID))
+	if err != nil || len(protos) == 0 {
+		return false
+	}
+	t, err := r.tallies.get(p)
+	return err == nil && t.frequent()
+}
+
+// handleTally adds the incentive claimed by a frequent peer to what we owe it
+func (r *Replication) handleTally(s network.Stream) {
+	defer r.sup.Recover("tally-handler")
+	defer s.Close()
+	p := s.Conn().RemotePeer()
+
+	if r.nettingInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), IncentiveTimeout)
+	defer cancel()
+	s.SetDeadline(time.Now().Add(IncentiveTimeout))
+
+	var claim IncentiveClaim
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &claim); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("invalid tally claim")
+		r.strikes.Record(p, err)
+		return
+	}
+	pi, err := r.acceptClaim(ctx, p, claim)
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Str("root", claim.PayloadCID.String()).Msg("incentive not tallied")
+		return
+	}
+	t, err := r.tallies.update(p, func(t *tally) {
+		t.Recipient = claim.Recipient
+		t.Payer = pi.Payer
+		t.Owed = big.Add(t.Owed, pi.Incentive.Amount)
+		t.Paid++
+	})
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to tally incentive")
+		r.restoreClaim(pi)
+		return
+	}
+	if err := cborutil.WriteCborRPC(s, &TallyBalance{Owed: t.Owed, Due: t.Due}); err != nil {
+		log.Error().Err(err).Msg("failed to write tally balance")
+		// The peer doesn't know we tallied the incentive so it can claim it again
+		_, err := r.tallies.update(p, func(t *tally) {
+			t.Owed = big.Sub(t.Owed, pi.Incentive.Amount)
+			t.Paid--
+		})
+		if err != nil {
+			log.Error().Err(err).Str("peer", p.String()).Msg("failed to revert tallied incentive")
+			return
+		}
+		r.restoreClaim(pi)
+	}
+}
+
+// tallyIncentive claims an incentive from a frequent peer who adds it to what it owes us
+func (r *Replication) tallyIncentive(ctx context.Context, pi pendingIncentive) error {
+	s, err := r.h.NewStream(ctx, pi.Peer, TallyProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	claim := IncentiveClaim{
+		PayloadCID: pi.PayloadCID,
+		Recipient:  r.wallet.DefaultAddress(),
+	}
+	if err := cborutil.WriteCborRPC(s, &claim); err != nil {
+		return err
+	}
+	var bal TallyBalance
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &bal); err != nil {
+		return fmt.Errorf("%w: %v", ErrTallyRejected, err)
+	}
+	t, err := r.tallies.update(pi.Peer, func(t *tally) {
+		t.Due = big.Add(t.Due, pi.Incentive.Amount)
+		t.Received++
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.claims.remove(pi.PayloadCID, pi.Peer); err != nil {
+		return err
+	}
+	// The peer's view of its debt should match ours, the difference is only settled based on its own view
+	if bal.Owed.LessThan(t.Due) {
+		log.Warn().Str("peer", pi.Peer.String()).Str("owed", bal.Owed.String()).Str("due", t.Due.String()).Msg("peer owes us less than we tallied")
+	}
+	log.Info().Str("peer", pi.Peer.String()).Str("root", pi.PayloadCID.String()).Msg("tallied incentive")
+	return nil
+}
+
+// settleTallies regularly pays the difference of our mutual debts with the peers we owe more than they owe us
+func (r *Replication) settleTallies(ctx context.Context) {
+	ticker := time.NewTicker(r.nettingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tallies, err := r.tallies.list()
+			if err != nil {
+				log.Error().Err(err).Msg("error when listing tallies")
+				continue
+			}
+			for _, t := range tallies {
+				if t.net().Sign() <= 0 || r.h.Network().Connectedness(t.Peer) != network.Connected {
+					continue
+				}
+				if err := r.settleTally(ctx, t); err != nil {
+					log.Error().Err(err).Str("peer", t.Peer.String()).Msg("failed to settle tally")
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// settleTally pays a peer the difference between what we owe it and what it owes us with a single voucher
+func (r *Replication) settleTally(ctx context.Context, t tally) error {
+	amt := t.net()
+	from := t.Payer
+	if from == address.Undef {
+		from = r.wallet.DefaultAddress()
+	}
+	pay, err := r.createVoucher(ctx, from, t.Recipient, amt)
+	if err != nil {
+		return err
+	}
+	if err := r.sendSettlement(ctx, t, pay); err != nil {
+		// The voucher was not accepted so it must not be counted as spent for the next settlement
+		if err := r.pay.RevokeVoucher(ctx, pay.Channel, pay.Voucher); err != nil {
+			log.Error().Err(err).Str("channel", pay.Channel.String()).Msg("failed to revoke undelivered voucher")
+		}
+		return err
+	}
+	// Incentives tallied since we read the tally remain for the next settlement
+	_, err = r.tallies.update(t.Peer, func(nt *tally) {
+		nt.Owed = big.Sub(nt.Owed, t.Owed)
+		nt.Due = big.Sub(nt.Due, t.Due)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Str("peer", t.Peer.String()).Str("amount", amt.String()).Msg("settled tally")
+	return nil
+}
+
+// sendSettlement sends a settlement voucher to a peer and waits for the peer to acknowledge it
+func (r *Replication) sendSettlement(ctx context.Context, t tally, pay IncentivePayment) error {
+	s, err := r.h.NewStream(ctx, t.Peer, TallySettleProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	settlement := TallySettlement{
+		Owed:    t.Owed,
+		Due:     t.Due,
+		Channel: pay.Channel,
+		Voucher: pay.Voucher,
+	}
+	if err := cborutil.WriteCborRPC(s, &settlement); err != nil {
+		return err
+	}
+	var bal TallyBalance
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &bal); err != nil {
+		return fmt.Errorf("%w: %v", ErrTallyRejected, err)
+	}
+	return nil
+}
+
+// handleSettlement redeems the voucher paying the difference of our mutual debts with a peer who owed us more
+func (r *Replication) handleSettlement(s network.Stream) {
+	defer r.sup.Recover("tally-settlement-handler")
+	defer s.Close()
+	p := s.Conn().RemotePeer()
+
+	if !r.payable() {
+		return
+	}
+	var settlement TallySettlement
+	if err := decodeMsg(bufio.NewReaderSize(s, 16), MaxIncentiveSize, &settlement); err != nil {
+		log.Debug().Err(err).Str("peer", p.String()).Msg("invalid tally settlement")
+		r.strikes.Record(p, err)
+		return
+	}
+	if err := r.acceptSettlement(context.Background(), p, settlement); err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("tally settlement rejected")
+		return
+	}
+	t, err := r.tallies.get(p)
+	if err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to read tally")
+		return
+	}
+	if err := cborutil.WriteCborRPC(s, &TallyBalance{Owed: t.Owed, Due: t.Due}); err != nil {
+		log.Error().Err(err).Msg("failed to write tally balance")
+	}
+}
+
+// acceptSettlement checks a settlement only covers debts we tallied and that the voucher pays the difference
+// then clears the settled debts
+func (r *Replication) acceptSettlement(ctx context.Context, p peer.ID, settlement TallySettlement) error {
+	t, err := r.tallies.get(p)
+	if err != nil {
+		return err
+	}
+	// What the peer owes is due to us and the other way around
+	if settlement.Owed.GreaterThan(t.Due) || settlement.Due.GreaterThan(t.Owed) {
+		return fmt.Errorf("%w: settling more than tallied", ErrTallyRejected)
+	}
+	amt := big.Sub(settlement.Owed, settlement.Due)
+	if amt.Sign() <= 0 || settlement.Voucher == nil {
+		return fmt.Errorf("%w: nothing to pay", ErrTallyRejected)
+	}
+	// Verifies the voucher pays at least the difference
+	if _, err := r.pay.AddVoucherInbound(ctx, settlement.Channel, settlement.Voucher, nil, amt); err != nil {
+		return fmt.Errorf("%w: %v", ErrTallyRejected, err)
+	}
+	_, err = r.tallies.update(p, func(t *tally) {
+		t.Due = big.Sub(t.Due, settlement.Owed)
+		t.Owed = big.Sub(t.Owed, settlement.Due)
+	})
+	if err != nil {
+		return err
+	}
+	// The voucher is redeemed with the others at the next checkpoint
+	if err := r.pay.Checkpoint(ctx, settlement.Channel); err != nil {
+		log.Error().Err(err).Str("channel", settlement.Channel.String()).Msg("failed to redeem settlement voucher")
+	}
+	log.Info().Str("peer", p.String()).Str("amount", amt.String()).Msg("received tally settlement")
+	return nil
+}
@@ -0,0 +1,126 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTallyStore(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ts := newTallyStore(ds)
+
+	// peers we never exchanged with have an empty tally
+	tl, err := ts.get(n1.Host.ID())
+	require.NoError(t, err)
+	net := tl.net()
+	require.True(t, net.IsZero())
+	require.False(t, tl.frequent())
+
+	for i := 0; i < NettingMinExchanges; i++ {
+		_, err = ts.update(n1.Host.ID(), func(t *tally) {
+			t.Owed = big.Add(t.Owed, big.NewInt(100))
+			t.Paid++
+		})
+		require.NoError(t, err)
+	}
+	tl, err = ts.update(n1.Host.ID(), func(t *tally) {
+		t.Due = big.Add(t.Due, big.NewInt(120))
+		t.Received += NettingMinExchanges
+	})
+	require.NoError(t, err)
+	require.True(t, tl.frequent())
+	require.Equal(t, big.NewInt(180), tl.net())
+
+	_, err = ts.update(n2.Host.ID(), func(t *tally) {
+		t.Due = big.Add(t.Due, big.NewInt(50))
+		t.Received++
+	})
+	require.NoError(t, err)
+
+	// tallies survive a restart
+	list, err := newTallyStore(ds).list()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	tl, err = newTallyStore(ds).get(n2.Host.ID())
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(-50), tl.net())
+	require.False(t, tl.frequent())
+}
+
+func TestAcceptSettlement(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	n1 := testutil.NewTestNode(mn, t)
+
+	r := &Replication{tallies: newTallyStore(dss.MutexWrap(datastore.NewMapDatastore()))}
+	p := n1.Host.ID()
+
+	_, err := r.tallies.update(p, func(t *tally) {
+		t.Owed = big.NewInt(30)
+		t.Due = big.NewInt(100)
+	})
+	require.NoError(t, err)
+
+	ch, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	// A peer cannot settle more than we tallied
+	err = r.acceptSettlement(context.Background(), p, TallySettlement{
+		Owed:    big.NewInt(150),
+		Due:     big.NewInt(30),
+		Channel: ch,
+		Voucher: &paych.SignedVoucher{Amount: big.NewInt(120)},
+	})
+	require.ErrorIs(t, err, ErrTallyRejected)
+
+	// A peer who owes us less than we owe it has nothing to pay
+	err = r.acceptSettlement(context.Background(), p, TallySettlement{
+		Owed:    big.NewInt(20),
+		Due:     big.NewInt(30),
+		Channel: ch,
+	})
+	require.ErrorIs(t, err, ErrTallyRejected)
+
+	// The debts are unchanged
+	tl, err := r.tallies.get(p)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(30), tl.Owed)
+	require.Equal(t, big.NewInt(100), tl.Due)
+}
+
+func TestTallyEncoding(t *testing.T) {
+	bal := TallyBalance{Owed: big.NewInt(30), Due: big.NewInt(100)}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, bal.MarshalCBOR(buf))
+	var decBal TallyBalance
+	require.NoError(t, decodeMsg(buf, MaxIncentiveSize, &decBal))
+	require.Equal(t, bal, decBal)
+
+	ch, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	settlement := TallySettlement{
+		Owed:    big.NewInt(100),
+		Due:     big.NewInt(30),
+		Channel: ch,
+	}
+	buf = new(bytes.Buffer)
+	require.NoError(t, settlement.MarshalCBOR(buf))
+	var dec TallySettlement
+	require.NoError(t, decodeMsg(buf, MaxIncentiveSize, &dec))
+	require.Equal(t, settlement, dec)
+}
@@ -0,0 +1,29 @@
+package exchange
+
+import (
+	"time"
+)
+
+// MinTransferTimeout is the shortest time we give any transfer so tiny fetches still have time
+// to open a channel and exchange payment messages
+const MinTransferTimeout = time.Minute
+
+// DefaultThroughput is the conservative throughput in bytes per second we assume when we have no
+// measurement for the peers we are transferring with
+const DefaultThroughput = 256 * 1024
+
+// transferMargin multiplies the expected transfer duration to absorb throughput variations
+const transferMargin = 3
+
+// TransferTimeout derives how long a transfer of a given size may take from the throughput
+// in bytes per second measured with the peer. DefaultThroughput is used if the throughput is unknown.
+func TransferTimeout(size uint64, throughput float64) time.Duration {
+	if throughput <= 0 {
+		throughput = DefaultThroughput
+	}
+	d := time.Duration(float64(size) / throughput * float64(time.Second) * transferMargin)
+	if d < MinTransferTimeout {
+		return MinTransferTimeout
+	}
+	return d
+}
@@ -0,0 +1,19 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferTimeout(t *testing.T) {
+	// small content gets the minimum
+	require.Equal(t, MinTransferTimeout, TransferTimeout(1024, 0))
+
+	// 1GiB at 1MiB/s with the safety margin
+	require.Equal(t, 1024*time.Second*transferMargin, TransferTimeout(1<<30, 1<<20))
+
+	// unknown throughput falls back to the default
+	require.Equal(t, TransferTimeout(1<<30, DefaultThroughput), TransferTimeout(1<<30, 0))
+}
@@ -0,0 +1,295 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+//go:generate cbor-gen-for PullToken
+
+// PullTokenTTL is how long the tokens we issue when dispatching content remain valid
+const PullTokenTTL = 24 * time.Hour
+
+// ErrInvalidToken is returned when a pull token was not issued by us or doesn't match the transfer
+var ErrInvalidToken = errors.New("invalid pull token")
+
+// ErrTokenExpired is returned when a pull token is used after its expiry
+var ErrTokenExpired = errors.New("pull token expired")
+
+// ErrTokenRevoked is returned when a pull token was issued for a dispatch which was cancelled
+var ErrTokenRevoked = errors.New("pull token revoked")
+
+// PullToken is a capability issued by a content provider authorizing a peer to pull some content
+// without payment. Since it is signed and self contained it can be handed out of band and remains
+// valid across restarts until it expires.
+type PullToken struct {
+	PayloadCID cid.Cid
+	Peer       peer.ID
+	// Expiry is the unix time in seconds after which the token cannot be used
+	Expiry uint64
+	// MaxBytes is the maximum size of the content which can be pulled with this token, 0 means no limit
+	MaxBytes uint64
+	// Signature of all the other fields by the issuer
+	Signature []byte
+}
+
+// payload returns the bytes signed by the issuer
+func (t PullToken) payload() ([]byte, error) {
+	t.Signature = nil
+	buf := new(bytes.Buffer)
+	if err := t.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IssuePullToken creates a token signed with our host key authorizing a peer to pull some content from us
+func IssuePullToken(h host.Host, root cid.Cid, p peer.ID, ttl time.Duration, maxBytes uint64) (PullToken, error) {
+	tok := PullToken{
+		PayloadCID: root,
+		Peer:       p,
+		Expiry:     uint64(time.Now().Add(ttl).Unix()),
+		MaxBytes:   maxBytes,
+	}
+	key := h.Peerstore().PrivKey(h.ID())
+	if key == nil {
+		return tok, fmt.Errorf("no private key for host %s", h.ID())
+	}
+	data, err := tok.payload()
+	if err != nil {
+		return tok, err
+	}
+	tok.Signature, err = key.Sign(data)
+	return tok, err
+}
+
+// VerifyPullToken checks a token was issued by our host for the given content and peer and is not expired
+func VerifyPullToken(h host.Host, tok *PullToken, root cid.Cid, p peer.ID) error {
+	if tok == nil {
+		return fmt.Errorf("%w: missing token", ErrInvalidToken)
+	}
+	if !tok.PayloadCID.Equals(root) {
+		return fmt.Errorf("%w: issued for %s", ErrInvalidToken, tok.PayloadCID)
+	}
+	if tok.Peer != p {
+		return fmt.Errorf("%w: issued to %s", ErrInvalidToken, tok.Peer)
+	}
+	if time.Now().Unix() > int64(tok.Expiry) {
+		return ErrTokenExpired
+	}
+	pub := h.Peerstore().PubKey(h.ID())
+	if pub == nil {
+		return fmt.Errorf("no public key for host %s", h.ID())
+	}
+	data, err := tok.payload()
+	if err != nil {
+		return err
+	}
+	ok, err := pub.Verify(data, tok.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	return nil
+}
+
+// revocation is the latest expiry of the revoked tokens for a content and peer
+type revocation struct {
+	PayloadCID cid.Cid
+	Peer       peer.ID
+	Until      uint64
+}
+
+// tokenRevocations keeps track of the pull tokens we revoked until they expire. Tokens are revoked by content
+// and peer so we don't need to remember each token we issued. Revocations are persisted so the tokens of
+// cancelled dispatches remain invalid after a restart.
+type tokenRevocations struct {
+	ds datastore.Batching
+
+	mu sync.Mutex
+	// entries are the revocations of each content and peer
+	entries map[string]revocation
+}
+
+func newTokenRevocations(ds datastore.Batching) *tokenRevocations {
+	tr := &tokenRevocations{
+		ds:      namespace.Wrap(ds, datastore.NewKey("/revocations")),
+		entries: make(map[string]revocation),
+	}
+	if err := tr.load(); err != nil {
+		log.Error().Err(err).Msg("failed to load token revocations")
+	}
+	return tr
+}
+
+func revocationKey(root cid.Cid, p peer.ID) string {
+	return root.KeyString() + string(p)
+}
+
+func revocationDsKey(root cid.Cid, p peer.ID) datastore.Key {
+	return datastore.NewKey(root.String()).ChildString(p.String())
+}
+
+// load reads the persisted revocations, the ones of tokens which expired are deleted
+func (tr *tokenRevocations) load() error {
+	res, err := tr.ds.Query(query.Query{})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var rv revocation
+		if err := json.Unmarshal(e.Value, &rv); err != nil {
+			log.Error().Err(err).Str("key", e.Key).Msg("invalid token revocation")
+			continue
+		}
+		tr.entries[revocationKey(rv.PayloadCID, rv.Peer)] = rv
+	}
+	tr.expire(time.Now())
+	return nil
+}
+
+// revoke invalidates all the tokens issued with PullTokenTTL for the given content and peer so far.
+// Tokens issued later remain valid.
+func (tr *tokenRevocations) revoke(root cid.Cid, p peer.ID) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	now := time.Now()
+	tr.expire(now)
+	rv := revocation{PayloadCID: root, Peer: p, Until: uint64(now.Add(PullTokenTTL).Unix())}
+	tr.entries[revocationKey(root, p)] = rv
+	b, err := json.Marshal(rv)
+	if err == nil {
+		err = tr.ds.Put(revocationDsKey(root, p), b)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("root", root.String()).Str("peer", p.String()).Msg("failed to persist token revocation")
+	}
+}
+
+// expire forgets the revocations of tokens which expired anyway
+func (tr *tokenRevocations) expire(now time.Time) {
+	for k, rv := range tr.entries {
+		if int64(rv.Until) >= now.Unix() {
+			continue
+		}
+		delete(tr.entries, k)
+		if err := tr.ds.Delete(revocationDsKey(rv.PayloadCID, rv.Peer)); err != nil {
+			log.Error().Err(err).Str("root", rv.PayloadCID.String()).Msg("failed to delete expired token revocation")
+		}
+	}
+}
+
+// revoked returns whether a token was revoked
+func (tr *tokenRevocations) revoked(tok *PullToken) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	rv, ok := tr.entries[revocationKey(tok.PayloadCID, tok.Peer)]
+	return ok && tok.Expiry <= rv.Until
+}
+
+// ErrTokenLimitExceeded is returned when a transfer sends more bytes than its pull token allows
+var ErrTokenLimitExceeded = errors.New("pull token byte limit exceeded")
+
+// tokenLimitOverhead is the fraction of MaxBytes sent on top of it before interrupting a transfer as the limit
+// is usually the size of the file while we send the blocks of its DAG
+const tokenLimitOverhead = 8
+
+// tokenLimitMinOverhead is the minimum number of bytes sent on top of MaxBytes for small content
+const tokenLimitMinOverhead = 4096
+
+type pullLimit struct {
+	max    uint64
+	sent   uint64
+	expiry uint64
+}
+
+// pullLimits is a data transfer revalidator interrupting the transfers authorized by a pull token once
+// they sent more than the token's MaxBytes. The size of the content isn't always known when we validate
+// the pull so the limit is enforced as the blocks are sent.
+type pullLimits struct {
+	mu       sync.Mutex
+	channels map[datatransfer.ChannelID]*pullLimit
+}
+
+func newPullLimits() *pullLimits {
+	return &pullLimits{
+		channels: make(map[datatransfer.ChannelID]*pullLimit),
+	}
+}
+
+// track limits the bytes sent on a channel to the token's MaxBytes if any
+func (pl *pullLimits) track(chid datatransfer.ChannelID, tok *PullToken) {
+	if tok.MaxBytes == 0 {
+		return
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	// Forget the channels which never completed once their token expired
+	now := uint64(time.Now().Unix())
+	for k, l := range pl.channels {
+		if l.expiry < now {
+			delete(pl.channels, k)
+		}
+	}
+	overhead := tok.MaxBytes / tokenLimitOverhead
+	if overhead < tokenLimitMinOverhead {
+		overhead = tokenLimitMinOverhead
+	}
+	pl.channels[chid] = &pullLimit{
+		max:    tok.MaxBytes + overhead,
+		expiry: tok.Expiry,
+	}
+}
+
+// Revalidate is not used as we never pause the transfers
+func (pl *pullLimits) Revalidate(chid datatransfer.ChannelID, voucher datatransfer.Voucher) (datatransfer.VoucherResult, error) {
+	return nil, nil
+}
+
+// OnPullDataSent terminates the transfer if it exceeds the limit of its token
+func (pl *pullLimits) OnPullDataSent(chid datatransfer.ChannelID, additionalBytesSent uint64) (bool, datatransfer.VoucherResult, error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	l, ok := pl.channels[chid]
+	if !ok {
+		return false, nil, nil
+	}
+	l.sent += additionalBytesSent
+	if l.sent > l.max {
+		delete(pl.channels, chid)
+		return true, nil, fmt.Errorf("%w: sent %d bytes out of %d", ErrTokenLimitExceeded, l.sent, l.max)
+	}
+	return true, nil, nil
+}
+
+// OnPushDataReceived is not used as we never accept pushes
+func (pl *pullLimits) OnPushDataReceived(chid datatransfer.ChannelID, additionalBytesReceived uint64) (bool, datatransfer.VoucherResult, error) {
+	return false, nil, nil
+}
+
+// OnComplete stops tracking the channel
+func (pl *pullLimits) OnComplete(chid datatransfer.ChannelID) (bool, datatransfer.VoucherResult, error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if _, ok := pl.channels[chid]; !ok {
+		return false, nil, nil
+	}
+	delete(pl.channels, chid)
+	return true, nil, nil
+}
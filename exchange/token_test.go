@@ -0,0 +1,132 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullToken(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	issuer := testutil.NewTestNode(mn, t)
+	holder := testutil.NewTestNode(mn, t)
+	other := testutil.NewTestNode(mn, t)
+
+	root := blockGen.Next().Cid()
+	tok, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), time.Hour, 1024)
+	require.NoError(t, err)
+	require.NoError(t, VerifyPullToken(issuer.Host, &tok, root, holder.Host.ID()))
+
+	// tokens survive a round trip in the request voucher
+	var buf bytes.Buffer
+	req := Request{Method: Dispatch, PayloadCID: root, Size: 1024, Token: &tok}
+	require.NoError(t, req.MarshalCBOR(&buf))
+	var dec Request
+	require.NoError(t, dec.UnmarshalCBOR(&buf))
+	require.NoError(t, VerifyPullToken(issuer.Host, dec.Token, root, holder.Host.ID()))
+
+	// requests without a token still encode
+	buf.Reset()
+	req.Token = nil
+	require.NoError(t, req.MarshalCBOR(&buf))
+	require.NoError(t, dec.UnmarshalCBOR(&buf))
+	require.Nil(t, dec.Token)
+
+	require.ErrorIs(t, VerifyPullToken(issuer.Host, nil, root, holder.Host.ID()), ErrInvalidToken)
+	// only the peer it was issued to can use it
+	require.ErrorIs(t, VerifyPullToken(issuer.Host, &tok, root, other.Host.ID()), ErrInvalidToken)
+	// only for the content it was issued for
+	require.ErrorIs(t, VerifyPullToken(issuer.Host, &tok, blockGen.Next().Cid(), holder.Host.ID()), ErrInvalidToken)
+	// only with the provider who issued it
+	require.ErrorIs(t, VerifyPullToken(other.Host, &tok, root, holder.Host.ID()), ErrInvalidToken)
+
+	// tampering invalidates the signature
+	forged := tok
+	forged.MaxBytes = 0
+	require.ErrorIs(t, VerifyPullToken(issuer.Host, &forged, root, holder.Host.ID()), ErrInvalidToken)
+
+	expired, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), -time.Minute, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, VerifyPullToken(issuer.Host, &expired, root, holder.Host.ID()), ErrTokenExpired)
+}
+
+func TestTokenRevocations(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	issuer := testutil.NewTestNode(mn, t)
+	holder := testutil.NewTestNode(mn, t)
+	other := testutil.NewTestNode(mn, t)
+
+	root := blockGen.Next().Cid()
+	tok, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), PullTokenTTL, 0)
+	require.NoError(t, err)
+	otok, err := IssuePullToken(issuer.Host, root, other.Host.ID(), PullTokenTTL, 0)
+	require.NoError(t, err)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	tr := newTokenRevocations(ds)
+	require.False(t, tr.revoked(&tok))
+
+	tr.revoke(root, holder.Host.ID())
+	require.True(t, tr.revoked(&tok))
+	require.False(t, tr.revoked(&otok))
+
+	// Revocations survive a restart
+	tr = newTokenRevocations(ds)
+	require.True(t, tr.revoked(&tok))
+	require.False(t, tr.revoked(&otok))
+
+	// Tokens issued after the revocation remain valid
+	later, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), PullTokenTTL+time.Minute, 0)
+	require.NoError(t, err)
+	require.False(t, tr.revoked(&later))
+}
+
+func TestPullLimits(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	issuer := testutil.NewTestNode(mn, t)
+	holder := testutil.NewTestNode(mn, t)
+
+	root := blockGen.Next().Cid()
+	tok, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), time.Hour, 64000)
+	require.NoError(t, err)
+	unlimited, err := IssuePullToken(issuer.Host, root, holder.Host.ID(), time.Hour, 0)
+	require.NoError(t, err)
+
+	pl := newPullLimits()
+	chid := datatransfer.ChannelID{Initiator: holder.Host.ID(), Responder: issuer.Host.ID(), ID: 1}
+	other := datatransfer.ChannelID{Initiator: holder.Host.ID(), Responder: issuer.Host.ID(), ID: 2}
+	pl.track(chid, &tok)
+	pl.track(other, &unlimited)
+
+	// Channels without limit are left to the other revalidators
+	handled, _, err := pl.OnPullDataSent(other, 1<<30)
+	require.NoError(t, err)
+	require.False(t, handled)
+
+	// The DAG encoding may add a little to the size of the content
+	handled, _, err = pl.OnPullDataSent(chid, 64000)
+	require.NoError(t, err)
+	require.True(t, handled)
+	_, _, err = pl.OnPullDataSent(chid, 4000)
+	require.NoError(t, err)
+
+	_, _, err = pl.OnPullDataSent(chid, 64000)
+	require.ErrorIs(t, err, ErrTokenLimitExceeded)
+
+	// Completed channels are not tracked anymore
+	pl.track(chid, &tok)
+	handled, _, err = pl.OnComplete(chid)
+	require.NoError(t, err)
+	require.True(t, handled)
+	handled, _, err = pl.OnPullDataSent(chid, 1<<30)
+	require.NoError(t, err)
+	require.False(t, handled)
+}
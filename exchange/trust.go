@@ -0,0 +1,88 @@
+package exchange
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Allowlist keeps track of the peers we trust to pull any of our content for free. Peers are trusted either
+// because they were explicitly listed or because they proved they know the fleet key shared by an operator.
+type Allowlist struct {
+	key []byte
+
+	mu      sync.Mutex
+	peers   map[peer.ID]struct{}
+	members map[peer.ID]struct{}
+}
+
+// NewAllowlist creates a new Allowlist from a list of peers and an optional fleet key
+func NewAllowlist(peers []peer.ID, key string) *Allowlist {
+	al := &Allowlist{
+		peers:   make(map[peer.ID]struct{}, len(peers)),
+		members: make(map[peer.ID]struct{}),
+	}
+	if key != "" {
+		al.key = []byte(key)
+	}
+	for _, p := range peers {
+		al.peers[p] = struct{}{}
+	}
+	return al
+}
+
+// Trusted returns whether a peer may pull our content without payment
+func (al *Allowlist) Trusted(p peer.ID) bool {
+	if al == nil {
+		return false
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if _, ok := al.peers[p]; ok {
+		return true
+	}
+	_, ok := al.members[p]
+	return ok
+}
+
+// Proof returns the proof a peer knows the fleet key or nil if we don't have one
+func (al *Allowlist) Proof(p peer.ID) []byte {
+	if al == nil || al.key == nil {
+		return nil
+	}
+	return FleetProof(al.key, p)
+}
+
+// Verify checks the proof sent by a peer and adds it to the fleet members if it is valid
+func (al *Allowlist) Verify(p peer.ID, proof []byte) bool {
+	if al == nil || al.key == nil || len(proof) == 0 {
+		return false
+	}
+	if !hmac.Equal(proof, FleetProof(al.key, p)) {
+		return false
+	}
+	al.mu.Lock()
+	al.members[p] = struct{}{}
+	al.mu.Unlock()
+	return true
+}
+
+// Forget removes a peer from the fleet members, it stays trusted if it was explicitly listed
+func (al *Allowlist) Forget(p peer.ID) {
+	if al == nil {
+		return
+	}
+	al.mu.Lock()
+	delete(al.members, p)
+	al.mu.Unlock()
+}
+
+// FleetProof is the HMAC of a peer ID with the fleet key. Since it is bound to the peer ID it cannot be
+// replayed by another peer even if it is sent in the clear.
+func FleetProof(key []byte, p peer.ID) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(p))
+	return mac.Sum(nil)
+}
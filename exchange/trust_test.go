@@ -0,0 +1,57 @@
+package exchange
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowlist(t *testing.T) {
+	listed := peer.ID("listed")
+	member := peer.ID("member")
+	stranger := peer.ID("stranger")
+
+	al := NewAllowlist([]peer.ID{listed}, "fleet secret")
+	require.True(t, al.Trusted(listed))
+	require.False(t, al.Trusted(member))
+
+	// a peer knowing the key is trusted once it proved it
+	require.True(t, al.Verify(member, FleetProof([]byte("fleet secret"), member)))
+	require.True(t, al.Trusted(member))
+
+	// proofs cannot be replayed by another peer or made with the wrong key
+	require.False(t, al.Verify(stranger, FleetProof([]byte("fleet secret"), member)))
+	require.False(t, al.Verify(stranger, FleetProof([]byte("guess"), stranger)))
+	require.False(t, al.Trusted(stranger))
+
+	al.Forget(member)
+	al.Forget(listed)
+	require.False(t, al.Trusted(member))
+	require.True(t, al.Trusted(listed))
+
+	// without a key nobody can join
+	al = NewAllowlist(nil, "")
+	require.Nil(t, al.Proof(member))
+	require.False(t, al.Verify(member, FleetProof(nil, member)))
+
+	// a nil allowlist trusts nobody
+	var nal *Allowlist
+	require.False(t, nal.Trusted(listed))
+}
+
+func TestHeyFleetProof(t *testing.T) {
+	h := Hey{
+		Regions:    []RegionCode{GlobalRegion},
+		PPB:        big.Zero(),
+		FleetProof: FleetProof([]byte("fleet secret"), peer.ID("member")),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, h.MarshalCBOR(&buf))
+
+	var dec Hey
+	require.NoError(t, dec.UnmarshalCBOR(&buf))
+	require.Equal(t, h.FleetProof, dec.FleetProof)
+}
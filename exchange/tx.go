@@ -5,14 +5,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync/storeutil"
@@ -23,8 +27,11 @@ import (
 	"github.com/ipfs/go-merkledag"
 	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/libp2p/go-libp2p-core/host"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/myelnet/pop/filecoin"
@@ -43,6 +50,12 @@ const DefaultHashFunction = uint64(mh.BLAKE2B_MIN + 31)
 // ErrNoStrategy is returned when we try querying content without a read strategy
 var ErrNoStrategy = errors.New("no strategy")
 
+// ErrTxNotEmpty is returned when amending a transaction which already has staged entries
+var ErrTxNotEmpty = errors.New("transaction already has entries")
+
+// ErrEntryNotFound is returned when a key is not in the transaction entries
+var ErrEntryNotFound = errors.New("entry not found")
+
 // Entry represents a link to an item in the DAG map
 type Entry struct {
 	// Key is string name of the entry
@@ -76,13 +89,17 @@ type Tx struct {
 	// entries is the cached reference to values used during the session
 	entries map[string]Entry
 	// disco is the discovery mechanism for finding content offers
-	rou *GossipRouting
+	rou Routing
 	// retriever manages the state of the transfer once we have a good offer
 	retriever *retrieval.Client
 	// index is the exchange content index
 	index *Index
 	// repl is the replication module
 	repl *Replication
+	// rep records the outcome of our transfers to score providers
+	rep *Reputation
+	// offers caches the offers we successfully executed so we can skip discovery next time
+	offers *OfferCache
 	// clientAddr is the address that will be used to make any payment for retrieving the content
 	clientAddr address.Address
 	// root is the root cid of the dag we are retrieving during this session
@@ -93,6 +110,12 @@ type Tx struct {
 	chunkSize int64
 	// cacheRF is the cache replication factor used when committing to storage
 	cacheRF int
+	// incentive is offered to the caches holding the content we commit if any
+	incentive *Incentive
+	// private content is not listed on the public index of the caches we dispatch it to
+	private bool
+	// regions are the regions to dispatch to in order of priority if any
+	regions []Region
 	// sel is the selector used to select specific nodes only to retrieve. if not provided we select
 	// all the nodes by default
 	sel ipld.Node
@@ -112,7 +135,18 @@ type Tx struct {
 	// if it's nil we don't need confirmation
 	triage chan DealSelection
 	// dispatching is a stream of peer confirmations when dispatching updates
-	dispatching chan PRecord
+	dispatching <-chan PRecord
+	// release lets the exchange know the store of this transaction is no longer in use
+	release func()
+	// stallTimeout is the duration without progress after which we give up on a transfer and try the next offer
+	stallTimeout time.Duration
+	// h is our host, used to verify the redirects providers send us
+	h host.Host
+	// redirected keeps track of the peers we were redirected to so we only query them once
+	rmu        sync.Mutex
+	redirected map[peer.ID]struct{}
+	// base is the root of the DataRef this transaction is amending if any
+	base cid.Cid
 	// committed indicates whether this transaction was committed or not
 	committed bool
 	// Err exposes any error reported by the session during use
@@ -129,7 +163,7 @@ func WithStrategy(strategy SelectionStrategy) TxOption {
 	return func(tx *Tx) {
 		tx.worker = strategy(tx)
 		tx.worker.Start()
-		tx.rou.SetReceiver(tx.worker.PushBack)
+		tx.rou.SetReceiver(tx.receiveOffer)
 	}
 }
 
@@ -148,6 +182,14 @@ func WithTriage() TxOption {
 	}
 }
 
+// WithStallTimeout sets the duration without progress after which a retrieval is cancelled
+// and the next offer is tried. A duration of 0 disables stall detection.
+func WithStallTimeout(d time.Duration) TxOption {
+	return func(tx *Tx) {
+		tx.stallTimeout = d
+	}
+}
+
 // SetCacheRF sets the cache replication factor before committing
 // we don't set it as an option as the value may only be known when committing
 // Setting a replication factor of 0 will not trigger any network requests when committing
@@ -155,6 +197,22 @@ func (tx *Tx) SetCacheRF(rf int) {
 	tx.cacheRF = rf
 }
 
+// SetIncentive sets a payment offered to the caches who hold the content for the incentive duration
+func (tx *Tx) SetIncentive(inc *Incentive) {
+	tx.incentive = inc
+}
+
+// SetPrivate keeps the content we commit off the public index of our node and of the caches we dispatch it to
+func (tx *Tx) SetPrivate(private bool) {
+	tx.private = private
+}
+
+// SetRegions sets the regions to dispatch to in order of priority. Caches in a region are only requested
+// once the caches in the previous regions are not enough to reach the replication factor.
+func (tx *Tx) SetRegions(regions []Region) {
+	tx.regions = regions
+}
+
 // Put a DAG for a given key in the transaction
 func (tx *Tx) Put(key string, value cid.Cid, size int64) error {
 	tx.entries[key] = Entry{
@@ -165,6 +223,66 @@ func (tx *Tx) Put(key string, value cid.Cid, size int64) error {
 	return tx.buildRoot()
 }
 
+// Remove an entry from the transaction
+func (tx *Tx) Remove(key string) error {
+	if _, ok := tx.entries[key]; !ok {
+		return fmt.Errorf("%s: %w", key, ErrEntryNotFound)
+	}
+	delete(tx.entries, key)
+	return tx.buildRoot()
+}
+
+// Amend reopens a committed DataRef so entries can be added or removed before committing again.
+// The new root links to the same blocks for all the unchanged entries so only the new content is added.
+func (tx *Tx) Amend(ref *DataRef) error {
+	if len(tx.entries) > 0 {
+		return ErrTxNotEmpty
+	}
+	// Entries are loaded from the current root so we restore it if we cannot amend the ref
+	prev := tx.root
+	tx.root = ref.PayloadCID
+	entries, err := tx.Entries()
+	if err != nil {
+		tx.root = prev
+		return fmt.Errorf("failed to load entries: %w", err)
+	}
+	for _, e := range entries {
+		tx.entries[e.Key] = e
+	}
+	tx.base = ref.PayloadCID
+	if err := tx.buildRoot(); err != nil {
+		tx.root = prev
+		tx.base = cid.Undef
+		tx.entries = make(map[string]Entry)
+		return err
+	}
+	return nil
+}
+
+// Base returns the root of the DataRef this transaction is amending or cid.Undef
+func (tx *Tx) Base() cid.Cid {
+	return tx.base
+}
+
+// loadBaseBlocks copies the blocks of entries inherited from the amended DataRef into the
+// transaction store so the whole DAG can be served when dispatching
+func (tx *Tx) loadBaseBlocks() error {
+	for _, e := range tx.entries {
+		has, err := tx.store.Bstore.Has(e.Value)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		err = utils.MigrateSelectBlocks(tx.ctx, tx.bs, tx.store.Bstore, e.Value, selectors.All())
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", e.Key, err)
+		}
+	}
+	return nil
+}
+
 // Status represents our staged values
 type Status map[string]Entry
 
@@ -312,6 +430,7 @@ func (tx *Tx) Ref() *DataRef {
 		PayloadCID:  tx.root,
 		PayloadSize: tx.size,
 		Keys:        keys,
+		Private:     tx.private,
 	}
 }
 
@@ -323,23 +442,47 @@ func (tx *Tx) Commit() error {
 
 	tx.committed = true
 
-	opts := DefaultDispatchOptions
 	if tx.cacheRF > 0 {
-		opts.RF = tx.cacheRF
-		opts.StoreID = tx.storeID
-		var err error
-		tx.dispatching, err = tx.repl.Dispatch(tx.root, uint64(tx.size), opts)
+		if tx.base.Defined() {
+			if err := tx.loadBaseBlocks(); err != nil {
+				return err
+			}
+		}
+		h, err := tx.repl.Dispatch(tx.root, uint64(tx.size), tx.dispatchOptions())
 		if err != nil {
 			return err
 		}
+		tx.dispatching = h.Out()
 	} else {
 		// Do not block WatchDispatch
-		tx.dispatching = make(chan PRecord)
-		close(tx.dispatching)
+		dispatching := make(chan PRecord)
+		close(dispatching)
+		tx.dispatching = dispatching
 	}
 	return nil
 }
 
+// dispatchOptions returns the options used to dispatch the transaction to caches
+func (tx *Tx) dispatchOptions() DispatchOptions {
+	opts := DefaultDispatchOptions
+	opts.RF = tx.cacheRF
+	opts.StoreID = tx.storeID
+	opts.Incentive = tx.incentive
+	opts.Private = tx.private
+	opts.Payer = tx.clientAddr
+	opts.Regions = tx.regions
+	return opts
+}
+
+// Candidates returns the caches the transaction would be dispatched to if committed now without
+// sending them any request
+func (tx *Tx) Candidates() []DispatchCandidate {
+	if tx.cacheRF <= 0 {
+		return nil
+	}
+	return tx.repl.Candidates(uint64(tx.size), tx.dispatchOptions())
+}
+
 func (tx *Tx) getUnixDAG(k cid.Cid, DAG ipldformat.DAGService) (files.Node, error) {
 	dn, err := DAG.Get(tx.ctx, k)
 	if err != nil {
@@ -349,8 +492,51 @@ func (tx *Tx) getUnixDAG(k cid.Cid, DAG ipldformat.DAGService) (files.Node, erro
 
 }
 
-// GetFile retrieves a file associated with the given key from the cache
+// GetFile retrieves a file associated with the given key from the cache. The key can be followed by
+// the path of a file nested in a directory entry, i.e. key/path/to/file.ext. Keys may contain slashes
+// too, i.e. objects ingested from nested prefixes, so the longest key matching the path is used.
 func (tx *Tx) GetFile(k string) (files.Node, error) {
+	segs := strings.Split(strings.Trim(k, "/"), "/")
+	for i := len(segs); i > 1; i-- {
+		if f, err := tx.getEntryFile(strings.Join(segs[:i], "/")); err == nil {
+			return resolvePath(f, segs[i:])
+		}
+	}
+	f, err := tx.getEntryFile(segs[0])
+	if err != nil {
+		return nil, err
+	}
+	return resolvePath(f, segs[1:])
+}
+
+// resolvePath walks down a directory tree following the given path segments
+func resolvePath(f files.Node, segs []string) (files.Node, error) {
+	for i, seg := range segs {
+		dir, ok := f.(files.Directory)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a directory", path.Join(segs[:i]...))
+		}
+		var next files.Node
+		it := dir.Entries()
+		for it.Next() {
+			if it.Name() == seg {
+				next = it.Node()
+				break
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: %w", path.Join(segs[:i+1]...), ErrEntryNotFound)
+		}
+		f = next
+	}
+	return f, nil
+}
+
+// getEntryFile retrieves the file or directory of a given entry
+func (tx *Tx) getEntryFile(k string) (files.Node, error) {
 	// If the key is in our cached entries we can use the current DAG
 	if e, ok := tx.entries[k]; ok {
 		return tx.getUnixDAG(e.Value, tx.store.DAG)
@@ -647,10 +833,66 @@ func (tx *Tx) Query(sel ipld.Node) error {
 	return ErrNoStrategy
 }
 
-// QueryOffer allows querying directly from a given peer
+// QueryOffer allows querying directly from a given peer. If the peer redirects us to another peer holding
+// the content we query that peer instead.
 func (tx *Tx) QueryOffer(info peer.AddrInfo, sel ipld.Node) (deal.Offer, error) {
 	tx.sel = sel
-	return tx.rou.QueryProvider(info, tx.root, sel)
+	offer, err := tx.rou.QueryProvider(info, tx.root, sel)
+	if err != nil || !offer.IsRedirect() {
+		return offer, err
+	}
+	return tx.followRedirect(offer)
+}
+
+// receiveOffer passes the offers from the routing service to the worker and follows redirects
+// to the peers holding the content in the background
+func (tx *Tx) receiveOffer(offer deal.Offer) {
+	if !offer.IsRedirect() {
+		tx.worker.PushBack(offer)
+		return
+	}
+	go func() {
+		of, err := tx.followRedirect(offer)
+		if err != nil {
+			log.Debug().Err(err).Str("root", tx.root.String()).Msg("not following redirect")
+			return
+		}
+		tx.worker.PushBack(of)
+	}()
+}
+
+// followRedirect verifies a redirect and queries the peer it points to. We only follow a single hop
+// and query each peer once per transaction so providers cannot bounce us around.
+func (tx *Tx) followRedirect(offer deal.Offer) (deal.Offer, error) {
+	if tx.h == nil {
+		return deal.Offer{}, ErrInvalidRedirect
+	}
+	if !offer.PayloadCID.Equals(tx.root) {
+		return deal.Offer{}, fmt.Errorf("%w: unexpected root %s", ErrInvalidRedirect, offer.PayloadCID)
+	}
+	to, err := VerifyRedirect(tx.h, offer)
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	tx.rmu.Lock()
+	if tx.redirected == nil {
+		tx.redirected = make(map[peer.ID]struct{})
+	}
+	_, seen := tx.redirected[to.ID]
+	tx.redirected[to.ID] = struct{}{}
+	tx.rmu.Unlock()
+	if seen {
+		return deal.Offer{}, fmt.Errorf("already redirected to %s", to.ID)
+	}
+	tx.rou.AddAddrs(to.ID, to.Addrs)
+	of, err := tx.rou.QueryProvider(*to, tx.root, tx.sel)
+	if err != nil {
+		return deal.Offer{}, err
+	}
+	if of.IsRedirect() {
+		return deal.Offer{}, fmt.Errorf("%w: %s redirected us again", ErrInvalidRedirect, to.ID)
+	}
+	return of, nil
 }
 
 // ApplyOffer allows executing a transaction based on an existing offer without querying the routing service
@@ -663,35 +905,64 @@ func (tx *Tx) ApplyOffer(offer deal.Offer) {
 // Execute starts a retrieval operation for a given offer and returns the deal ID for that operation
 func (tx *Tx) Execute(of deal.Offer, p DealExecParams) TxResult {
 	result := make(chan TxResult, 1)
-	tx.unsub = tx.retriever.SubscribeToEvents(func(event client.Event, state deal.ClientState) {
+	// The retriever may still send events for deals from previous offers so we only listen to
+	// the deal we started. Results are kept until we know the deal ID in case the deal ends right away.
+	var mu sync.Mutex
+	var dealID *deal.ID
+	results := make(map[deal.ID]TxResult)
+	// any event on our deal means the provider is still responsive
+	stall := newStallDetector(tx.stallTimeout)
+	unsub := tx.retriever.SubscribeToEvents(func(event client.Event, state deal.ClientState) {
+		mu.Lock()
+		if dealID != nil && state.ID == *dealID {
+			// The provider waits for our payment while we are out of funds or waiting for the chain to create
+			// or fund our channel. The retriever cancels the deal itself if the channel isn't topped up within
+			// the grace period.
+			if waitingForFunds(state.Status) {
+				stall.Pause()
+			} else {
+				stall.Resume()
+			}
+		}
+		mu.Unlock()
+
+		var res TxResult
 		switch state.Status {
 		case deal.StatusCompleted:
 			payCh := address.Undef
 			if state.PaymentInfo != nil {
 				payCh = state.PaymentInfo.PayCh
 			}
-			select {
-			case result <- TxResult{
+			res = TxResult{
 				Size:  state.TotalReceived,
 				Spent: state.FundsSpent,
 				PayCh: payCh,
-			}:
-			default:
 			}
-			return
 		case deal.StatusCancelled, deal.StatusErrored:
-			select {
-			case result <- TxResult{
+			res = TxResult{
 				Err: errors.New(deal.Statuses[state.Status]),
-			}:
-			default:
 			}
+		default:
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if dealID == nil {
+			results[state.ID] = res
+			return
+		}
+		if state.ID != *dealID {
 			return
 		}
+		select {
+		case result <- res:
+		default:
+		}
 	})
 
 	info, err := of.AddrInfo()
 	if err != nil {
+		unsub()
 		return TxResult{
 			Err: err,
 		}
@@ -699,6 +970,8 @@ func (tx *Tx) Execute(of deal.Offer, p DealExecParams) TxResult {
 	if p.Selector == nil {
 		p.Selector = tx.sel
 	}
+	// If a previous offer failed mid-transfer we only request the entries we haven't received yet
+	p.Selector = tx.resumeSelector(p.Selector)
 	// Make sure our provider is in our peerstore
 	tx.rou.AddAddrs(info.ID, info.Addrs)
 	params, err := deal.NewParams(
@@ -710,6 +983,7 @@ func (tx *Tx) Execute(of deal.Offer, p DealExecParams) TxResult {
 		of.UnsealPrice,
 	)
 	if err != nil {
+		unsub()
 		return TxResult{
 			Err: err,
 		}
@@ -726,21 +1000,68 @@ func (tx *Tx) Execute(of deal.Offer, p DealExecParams) TxResult {
 		&tx.storeID,
 	)
 	if err != nil {
+		unsub()
 		return TxResult{
 			Err: err,
 		}
 	}
+	mu.Lock()
+	dealID = &id
+	if res, ok := results[id]; ok {
+		result <- res
+	}
+	mu.Unlock()
+	tx.unsub = unsub
+	start := time.Now()
 	tx.ongoing <- DealRef{
 		ID:    id,
 		Offer: of,
 	}
+	wctx, cancel := context.WithCancel(tx.ctx)
+	defer cancel()
+	stall.Progress()
+	stalled := stall.Watch(wctx)
 	select {
+	case <-stalled:
+		// The channel may be hung without ever failing so we cancel the deal ourselves
+		// and let the worker fall back to the next offer
+		if err := tx.retriever.CancelDeal(id); err != nil {
+			log.Error().Err(err).Msg("failed to cancel stalled deal")
+		}
+		unsub()
+		tx.unsub = nil
+		if tx.rep != nil {
+			tx.rep.RecordFailure(info.ID)
+		}
+		if tx.offers != nil {
+			tx.offers.Invalidate(tx.root)
+		}
+		return TxResult{
+			Err: fmt.Errorf("%w: no progress from %s in %s", ErrTransferStalled, info.ID, tx.stallTimeout),
+		}
 	case res := <-result:
 		if res.Err == nil {
 			tx.committed = true
 		}
-		// For now we just return the error and assume the transfer is failed
-		// we do have access to the status in order to try and restart the deal or something else
+		// The session worker falls back to the next offer if the transfer failed so we stop
+		// listening to this deal
+		unsub()
+		tx.unsub = nil
+		if tx.rep != nil {
+			if res.Err != nil {
+				tx.rep.RecordFailure(info.ID)
+			} else {
+				tx.rep.RecordSuccess(info.ID, res.Size, time.Since(start))
+			}
+		}
+		if tx.offers != nil {
+			if res.Err != nil {
+				tx.offers.Invalidate(tx.root)
+			} else if selectsAll(tx.sel) {
+				// an offer for some entries may not be valid for the rest of the DAG
+				tx.offers.Set(tx.root, of)
+			}
+		}
 		return res
 	case <-tx.ctx.Done():
 		return TxResult{
@@ -749,6 +1070,71 @@ func (tx *Tx) Execute(of deal.Offer, p DealExecParams) TxResult {
 	}
 }
 
+// selectsAll returns whether a selector retrieves the entire DAG
+func selectsAll(s ipld.Node) bool {
+	if s == nil {
+		return false
+	}
+	var a, b bytes.Buffer
+	if err := dagcbor.Encoder(s, &a); err != nil {
+		return false
+	}
+	if err := dagcbor.Encoder(selectors.All(), &b); err != nil {
+		return false
+	}
+	return bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// resumeSelector returns a selector for the entries which are still incomplete in the tx store.
+// If we haven't received the root yet or all the selected entries are incomplete, the selector is returned as is.
+func (tx *Tx) resumeSelector(sel ipld.Node) ipld.Node {
+	if has, err := tx.store.Bstore.Has(tx.root); err != nil || !has {
+		return sel
+	}
+	s, err := selector.ParseSelector(sel)
+	if err != nil {
+		return sel
+	}
+	// Interests returns nil if the selector explores all the entries
+	var selected map[string]bool
+	if interests := s.Interests(); interests != nil {
+		selected = make(map[string]bool, len(interests))
+		for _, ps := range interests {
+			selected[ps.String()] = true
+		}
+	}
+	keys, err := utils.MapLoadableKeys(tx.ctx, tx.root, tx.store.Loader)
+	if err != nil {
+		return sel
+	}
+	missing, err := utils.MapMissingKeys(tx.ctx, tx.root, tx.store.Loader)
+	if err != nil {
+		return sel
+	}
+	var total int
+	var incomplete []string
+	for _, k := range append(keys, missing...) {
+		if selected != nil && !selected[k] {
+			continue
+		}
+		total++
+		root, err := tx.RootFor(k)
+		if err != nil {
+			return sel
+		}
+		// If we can't walk the whole DAG some blocks are missing
+		err = utils.WalkDAG(tx.ctx, root, tx.store.Bstore, selectors.All(), func(blocks.Block) error { return nil })
+		if err != nil {
+			incomplete = append(incomplete, k)
+		}
+	}
+	if len(incomplete) == 0 || len(incomplete) == total {
+		return sel
+	}
+	log.Info().Strs("keys", incomplete).Msg("resuming transfer")
+	return selectors.Keys(incomplete...)
+}
+
 // Confirm takes an offer and blocks to wait for user confirmation before returning true or false
 func (tx *Tx) Confirm(of deal.Offer) DealExecParams {
 	if tx.triage != nil {
@@ -812,6 +1198,9 @@ func (tx *Tx) Close() error {
 		tx.unsub()
 	}
 	err := tx.dumpStore()
+	if tx.release != nil {
+		tx.release()
+	}
 	if err != nil {
 		return err
 	}
@@ -819,7 +1208,7 @@ func (tx *Tx) Close() error {
 	return tx.Err
 }
 
-// SetAddress to use for funding the retriebal
+// SetAddress to use for funding the retrieval and paying the incentive offered to caches if any
 func (tx *Tx) SetAddress(addr address.Address) {
 	tx.clientAddr = addr
 }
@@ -841,6 +1230,12 @@ func (tx *Tx) dumpStore() error {
 		if err != nil {
 			return err
 		}
+		// the ref may have been set before the blocks were in the blockstore
+		if tx.index != nil {
+			if err := tx.index.TrackBlocks(tx.root); err != nil {
+				return err
+			}
+		}
 	}
 
 	return tx.ms.Delete(tx.storeID)
@@ -871,7 +1266,8 @@ type SelectionStrategy func(OfferExecutor) OfferWorker
 // We offer a useful presets
 
 // SelectFirst executes the first offer received and buffers other offers during the
-// duration of the transfer. If the transfer hard fails it tries continuing with the following offer and so on.
+// duration of the transfer. If the transfer hard fails it tries continuing with the next offer in the order they were
+// received and so on.
 func SelectFirst(oe OfferExecutor) OfferWorker {
 	return sessionWorker{
 		executor:      oe,
@@ -897,6 +1293,7 @@ func SelectCheapest(after int, t time.Duration) func(OfferExecutor) OfferWorker
 			numThreshold:  after,
 			timeThreshold: t,
 			priceCeiling:  abi.NewTokenAmount(-1),
+			ordered:       true,
 		}
 	}
 }
@@ -917,6 +1314,27 @@ func SelectFirstLowerThan(amount abi.TokenAmount) func(oe OfferExecutor) OfferWo
 	}
 }
 
+// SelectByReputation waits for a given amount of offers or delay whichever comes first and selects the best offer
+// based on the provider reputation and price. A priceWeight of 0 ignores the price while a priceWeight of 1 values the
+// price as much as the reputation. If the transfer fails it falls back to the next best offer.
+func SelectByReputation(rep *Reputation, priceWeight float64, after int, t time.Duration) func(OfferExecutor) OfferWorker {
+	return func(oe OfferExecutor) OfferWorker {
+		return sessionWorker{
+			executor:      oe,
+			offersFront:   make(chan deal.Offer),
+			offersBack:    make(chan deal.Offer),
+			closing:       make(chan chan []deal.Offer, 1),
+			numThreshold:  after,
+			timeThreshold: t,
+			priceCeiling:  abi.NewTokenAmount(-1),
+			ordered:       true,
+			rank: func(offers []deal.Offer) {
+				rep.rankOffers(offers, priceWeight)
+			},
+		}
+	}
+}
+
 type sessionWorker struct {
 	executor    OfferExecutor
 	offersFront chan deal.Offer
@@ -930,6 +1348,22 @@ type sessionWorker struct {
 	timeThreshold time.Duration
 	// priceCeiling is the price over which we are ignoring an offer for this session
 	priceCeiling abi.TokenAmount
+	// ordered strategies sort the queued offers before selecting one, otherwise offers are
+	// executed in the order they were received
+	ordered bool
+	// rank sorts the offers from best to worst. Cheapest first if nil.
+	rank func([]deal.Offer)
+}
+
+func (s sessionWorker) sort(offers []deal.Offer) {
+	if !s.ordered {
+		return
+	}
+	if s.rank != nil {
+		s.rank(offers)
+		return
+	}
+	sortOffers(offers)
 }
 
 func (s sessionWorker) exec(offer deal.Offer, result chan TxResult) {
@@ -960,6 +1394,19 @@ func (s sessionWorker) Start() {
 		// Offers are queued in this slice
 		// TODO: replace with "container/list"
 		var q []deal.Offer
+		// front is the number of offers pushed to the front of the queue, they are executed
+		// before any other and never sorted
+		var front int
+		// next pops the offer to execute from the queue
+		next := func() deal.Offer {
+			s.sort(q[front:])
+			of := q[0]
+			q = q[1:]
+			if front > 0 {
+				front--
+			}
+			return of
+		}
 		var execDone chan TxResult
 		for {
 			select {
@@ -984,9 +1431,7 @@ func (s sessionWorker) Start() {
 				// If after this one we've reached the threshold let's execute the cheapest offer
 				if len(q) == s.numThreshold {
 					execDone = make(chan TxResult, 1)
-					sortOffers(q)
-					go s.exec(q[0], execDone)
-					q = q[1:]
+					go s.exec(next(), execDone)
 				}
 			case of := <-s.offersFront:
 				if execDone == nil {
@@ -996,22 +1441,22 @@ func (s sessionWorker) Start() {
 				}
 
 				q = append([]deal.Offer{of}, q...)
+				front++
 			case <-delay:
 				// We may already be executing if we've reached another threshold
 				if execDone != nil {
 					continue
 				}
 				execDone = make(chan TxResult, 1)
-				sortOffers(q)
-				go s.exec(q[0], execDone)
-				q = q[1:]
+				go s.exec(next(), execDone)
 			case res := <-execDone:
 				// If the execution returns an error we assume it is not fixable
-				// and automatically try the next offer
+				// and automatically fall back to the next best offer. Blocks received so far
+				// stay in the session store so we only retrieve what is missing.
 				if res.Err != nil && len(q) > 0 {
+					log.Error().Err(res.Err).Msg("transfer failed, falling back to next offer")
 					execDone = make(chan TxResult, 1)
-					go s.exec(q[0], execDone)
-					q = q[1:]
+					go s.exec(next(), execDone)
 					continue
 				}
 				if res.Err == nil || len(q) == 0 {
@@ -1045,9 +1490,18 @@ func (s sessionWorker) PushFront(offer deal.Offer) {
 	s.offersFront <- offer
 }
 
+// sortOffers sorts offers from cheapest to most expensive. Offers with the same price are sorted
+// by the load and throughput their provider advertised.
 func sortOffers(offers []deal.Offer) {
-	sort.Slice(offers, func(i, j int) bool {
-		return offers[i].MinPricePerByte.LessThan(offers[j].MinPricePerByte)
+	sort.SliceStable(offers, func(i, j int) bool {
+		a, b := offers[i], offers[j]
+		if !a.MinPricePerByte.Equals(b.MinPricePerByte) {
+			return a.MinPricePerByte.LessThan(b.MinPricePerByte)
+		}
+		if a.Load != b.Load {
+			return a.Load < b.Load
+		}
+		return a.Throughput > b.Throughput
 	})
 }
 
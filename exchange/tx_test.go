@@ -16,6 +16,7 @@ import (
 	files "github.com/ipfs/go-ipfs-files"
 	"github.com/ipfs/go-path"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
 	"github.com/libp2p/go-libp2p-core/host"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/myelnet/pop/internal/testutil"
@@ -116,6 +117,53 @@ loop:
 	require.False(t, has)
 }
 
+func TestCommitDropGC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath:     n.DTTmpDir,
+		ReplInterval: -1,
+	})
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+	tx := exch.Tx(ctx)
+	var blks []cid.Cid
+	for _, p := range filepaths {
+		link, bytes := n.LoadFileToStore(ctx, t, tx.Store(), p)
+		rootCid := link.(cidlink.Link).Cid
+		blks = append(blks, rootCid)
+		require.NoError(t, tx.Put(KeyFromPath(p), rootCid, int64(len(bytes))))
+	}
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	blks = append(blks, root)
+
+	// the ref is set before closing the tx moves the content to the blockstore
+	require.NoError(t, exch.Index().SetRef(tx.Ref()))
+	require.NoError(t, tx.Close())
+
+	bs := exch.Index().Bstore()
+	for _, c := range blks {
+		has, err := bs.Has(c)
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+
+	require.NoError(t, exch.Index().DropRef(root))
+	require.NoError(t, exch.Index().GC())
+
+	for _, c := range blks {
+		has, err := bs.Has(c)
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+}
+
 func genTestFiles(t *testing.T) (map[string]string, []string) {
 	dir := t.TempDir()
 
@@ -498,3 +546,173 @@ loop:
 	require.NoError(t, err)
 	require.Equal(t, uint64(cid.Raw), eroot.Type())
 }
+
+func TestTxResumeSelector(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	opts := Options{
+		RepoPath: n1.DTTmpDir,
+	}
+	pn, err := New(ctx, n1.Host, n1.Ds, opts)
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+
+	tx := pn.Tx(ctx)
+	tx.SetCacheRF(0)
+	for _, p := range filepaths {
+		link, bytes := n1.LoadFileToStore(ctx, t, tx.Store(), p)
+		rootCid := link.(cidlink.Link).Cid
+		require.NoError(t, tx.Put(KeyFromPath(p), rootCid, int64(len(bytes))))
+	}
+	require.NoError(t, tx.Commit())
+
+	// Nothing is missing so we keep the same selector
+	all := sel.All()
+	require.Equal(t, all, tx.resumeSelector(all))
+
+	// Remove an entry from the store as if the transfer failed before we received it
+	missing := KeyFromPath(filepaths[0])
+	eroot, err := tx.RootFor(missing)
+	require.NoError(t, err)
+	require.NoError(t, tx.Store().Bstore.DeleteBlock(eroot))
+
+	s, err := selector.ParseSelector(tx.resumeSelector(all))
+	require.NoError(t, err)
+	require.Len(t, s.Interests(), 1)
+	require.Equal(t, missing, s.Interests()[0].String())
+
+	// If the only selected entry is missing we keep the same selector
+	key := sel.Key(missing)
+	require.Equal(t, key, tx.resumeSelector(key))
+}
+
+func TestTxAmend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	opts := Options{
+		RepoPath: n1.DTTmpDir,
+	}
+	pn, err := New(ctx, n1.Host, n1.Ds, opts)
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+
+	tx := pn.Tx(ctx)
+	tx.SetCacheRF(0)
+	for _, p := range filepaths {
+		link, bytes := n1.LoadFileToStore(ctx, t, tx.Store(), p)
+		rootCid := link.(cidlink.Link).Cid
+		require.NoError(t, tx.Put(KeyFromPath(p), rootCid, int64(len(bytes))))
+	}
+	require.NoError(t, tx.Commit())
+	ref := tx.Ref()
+	// read the entry before the ref is indexed as the blocks are only migrated when closing
+	unchanged, err := tx.RootFor(KeyFromPath(filepaths[1]))
+	require.NoError(t, err)
+	require.NoError(t, pn.Index().SetRef(ref))
+	require.NoError(t, tx.Close())
+
+	// Reopen the ref to add a new file and remove an existing one
+	atx := pn.Tx(ctx)
+	atx.SetCacheRF(0)
+	// the transaction is unchanged if the ref cannot be amended
+	emptyRoot := atx.Root()
+	require.Error(t, atx.Amend(&DataRef{PayloadCID: blockGen.Next().Cid()}))
+	require.Equal(t, emptyRoot, atx.Root())
+	require.Equal(t, cid.Undef, atx.Base())
+
+	require.NoError(t, atx.Amend(ref))
+	require.Equal(t, ref.PayloadCID, atx.Base())
+	require.ErrorIs(t, atx.Amend(ref), ErrTxNotEmpty)
+
+	removed := KeyFromPath(filepaths[0])
+	require.NoError(t, atx.Remove(removed))
+	require.ErrorIs(t, atx.Remove(removed), ErrEntryNotFound)
+
+	fname := n1.CreateRandomFile(t, 56000)
+	link, bytes := n1.LoadFileToStore(ctx, t, atx.Store(), fname)
+	added := KeyFromPath(fname)
+	require.NoError(t, atx.Put(added, link.(cidlink.Link).Cid, int64(len(bytes))))
+
+	// Unchanged entries were not added to the new store
+	has, err := atx.Store().Bstore.Has(unchanged)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.NoError(t, atx.Commit())
+	nref := atx.Ref()
+	require.NoError(t, pn.Index().SetRef(nref))
+	require.NoError(t, atx.Close())
+	require.NotEqual(t, ref.PayloadCID, nref.PayloadCID)
+
+	// The new version shares the unchanged blocks with the previous one
+	ntx := pn.Tx(ctx, WithRoot(nref.PayloadCID))
+	entries, err := ntx.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, len(filepaths))
+	for _, e := range entries {
+		require.NotEqual(t, removed, e.Key)
+	}
+	root, err := ntx.RootFor(KeyFromPath(filepaths[1]))
+	require.NoError(t, err)
+	require.Equal(t, unchanged, root)
+	_, err = ntx.RootFor(added)
+	require.NoError(t, err)
+}
+
+func TestCheckPartialRef(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n1 := testutil.NewTestNode(mn, t)
+	pn, err := New(ctx, n1.Host, n1.Ds, Options{RepoPath: n1.DTTmpDir, ReplInterval: -1})
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+
+	tx := pn.Tx(ctx)
+	tx.SetCacheRF(0)
+	for _, p := range filepaths {
+		link, bytes := n1.LoadFileToStore(ctx, t, tx.Store(), p)
+		require.NoError(t, tx.Put(KeyFromPath(p), link.(cidlink.Link).Cid, int64(len(bytes))))
+	}
+	require.NoError(t, tx.Commit())
+	ref := tx.Ref()
+	missing := KeyFromPath(filepaths[0])
+	mroot, err := tx.RootFor(missing)
+	require.NoError(t, err)
+	held := KeyFromPath(filepaths[1])
+	hroot, err := tx.RootFor(held)
+	require.NoError(t, err)
+	require.NoError(t, tx.Close())
+
+	// We only hold some of the keys of the ref
+	var keys [][]byte
+	for _, k := range ref.Keys {
+		if string(k) != missing {
+			keys = append(keys, k)
+		}
+	}
+	ref.Keys = keys
+	require.NoError(t, pn.Index().SetRef(ref))
+	require.NoError(t, pn.Index().Bstore().DeleteBlock(mroot))
+
+	report, err := pn.Index().Check(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Checked)
+	require.Len(t, report.Dropped, 0)
+
+	// A block of the keys we hold is missing
+	require.NoError(t, pn.Index().Bstore().DeleteBlock(hroot))
+	report, err = pn.Index().Check(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{ref.PayloadCID}, report.Dropped)
+}
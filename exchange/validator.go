@@ -0,0 +1,137 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrRequestRejected is returned when a dispatch request doesn't comply with our policy
+var ErrRequestRejected = errors.New("request rejected")
+
+// DispatchPolicy sets the rules for accepting content dispatched to us by other peers
+type DispatchPolicy struct {
+	// MaxSize is the largest content size in bytes we accept to cache. 0 means no limit.
+	MaxSize uint64
+	// PeerQuota is the maximum amount of bytes a single peer can dispatch to us. 0 means no limit.
+	PeerQuota uint64
+	// QuotaWindow is the period after which the bytes counted against a peer quota are forgotten.
+	// Defaults to DefaultQuotaWindow.
+	QuotaWindow time.Duration
+	// MinIncentive is the lowest price per byte of an incentive for which we lift our size limit to
+	// IncentiveMaxSize. If nil incentives don't change our limits.
+	MinIncentive big.Int
+	// IncentiveMaxSize is the largest content size in bytes we accept to cache with an incentive paying at
+	// least MinIncentive. 0 means no limit.
+	IncentiveMaxSize uint64
+}
+
+// DefaultQuotaWindow is how long we count the bytes dispatched by a peer against its quota
+const DefaultQuotaWindow = 24 * time.Hour
+
+// peerCount is the amount of bytes a peer dispatched to us since the start of its window
+type peerCount struct {
+	size  uint64
+	start time.Time
+}
+
+// RequestValidator checks incoming dispatch requests before we start pulling any content
+type RequestValidator struct {
+	idx    *Index
+	pm     *PeerMgr
+	policy DispatchPolicy
+
+	mu       sync.Mutex
+	received map[peer.ID]*peerCount
+}
+
+// NewRequestValidator creates a new RequestValidator instance
+func NewRequestValidator(idx *Index, pm *PeerMgr, policy DispatchPolicy) *RequestValidator {
+	if policy.QuotaWindow == 0 {
+		policy.QuotaWindow = DefaultQuotaWindow
+	}
+	return &RequestValidator{
+		idx:      idx,
+		pm:       pm,
+		policy:   policy,
+		received: make(map[peer.ID]*peerCount),
+	}
+}
+
+// Validate returns an error if the request should be rejected. If the request is valid, the size
+// is counted against the peer quota until released.
+func (v *RequestValidator) Validate(p peer.ID, req Request) error {
+	return v.ValidateWithIncentive(p, req, nil)
+}
+
+// ValidateWithIncentive validates a request offering an incentive which may lift our size limit
+func (v *RequestValidator) ValidateWithIncentive(p peer.ID, req Request, inc *Incentive) error {
+	if req.Method != Dispatch {
+		return fmt.Errorf("%w: unsupported method %d", ErrRequestRejected, req.Method)
+	}
+	maxSize := v.policy.MaxSize
+	if v.Incentivized(req, inc) {
+		maxSize = v.policy.IncentiveMaxSize
+	}
+	if maxSize > 0 && req.Size > maxSize {
+		return fmt.Errorf("%w: size %d over max size %d", ErrRequestRejected, req.Size, maxSize)
+	}
+	if v.idx.Bounded() && req.Size > v.idx.Available() {
+		return fmt.Errorf("%w: not enough capacity for %d bytes", ErrRequestRejected, req.Size)
+	}
+	// We only keep track of peers who share one of our regions
+	if _, ok := v.pm.Peer(p); !ok {
+		return fmt.Errorf("%w: peer %s is not in our regions", ErrRequestRejected, p)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	v.prune(now)
+	c, ok := v.received[p]
+	if !ok {
+		c = &peerCount{start: now}
+	}
+	if v.policy.PeerQuota > 0 && c.size+req.Size > v.policy.PeerQuota {
+		return fmt.Errorf("%w: peer %s is over quota", ErrRequestRejected, p)
+	}
+	c.size += req.Size
+	v.received[p] = c
+	return nil
+}
+
+// prune forgets the counts of peers whose window expired. The caller must hold the lock.
+func (v *RequestValidator) prune(now time.Time) {
+	for p, c := range v.received {
+		if now.Sub(c.start) >= v.policy.QuotaWindow {
+			delete(v.received, p)
+		}
+	}
+}
+
+// Incentivized returns whether an incentive pays enough for the request to lift our size limit
+func (v *RequestValidator) Incentivized(req Request, inc *Incentive) bool {
+	if inc == nil || v.policy.MinIncentive.Int == nil || inc.Amount.Int == nil {
+		return false
+	}
+	return inc.Amount.GreaterThanEqual(big.Mul(v.policy.MinIncentive, big.NewIntUnsigned(req.Size)))
+}
+
+// Release removes the size of a request from the peer quota, for example if the transfer failed
+func (v *RequestValidator) Release(p peer.ID, req Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.received[p]
+	if !ok {
+		return
+	}
+	if c.size <= req.Size {
+		delete(v.received, p)
+		return
+	}
+	c.size -= req.Size
+}
@@ -0,0 +1,150 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestValidator(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+
+	idx, err := NewIndex(n1.Ds, n1.Bs, WithBounds(10000, 8000))
+	require.NoError(t, err)
+	pm := NewPeerMgr(n1.Host, idx, []Region{global})
+	pm.handleHey(n2.Host.ID(), Hey{
+		Regions: []RegionCode{GlobalRegion},
+	})
+
+	v := NewRequestValidator(idx, pm, DispatchPolicy{
+		MaxSize:   5000,
+		PeerQuota: 6000,
+	})
+
+	req := func(size uint64) Request {
+		return Request{
+			Method:     Dispatch,
+			PayloadCID: bgen.Next().Cid(),
+			Size:       size,
+		}
+	}
+
+	testCases := []struct {
+		name string
+		req  Request
+		err  bool
+	}{
+		{"accepted", req(4000), false},
+		{"over max size", req(5001), true},
+		{"over quota", req(3000), true},
+		{"under quota", req(2000), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.Validate(n2.Host.ID(), tc.req)
+			if tc.err {
+				require.True(t, errors.Is(err, ErrRequestRejected))
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// releasing a request frees up the quota
+	v.Release(n2.Host.ID(), req(4000))
+	require.NoError(t, v.Validate(n2.Host.ID(), req(3000)))
+
+	// unknown peers are rejected
+	require.Error(t, v.Validate(n3.Host.ID(), req(100)))
+
+	// not enough capacity left
+	big := NewRequestValidator(idx, pm, DispatchPolicy{})
+	require.Error(t, big.Validate(n2.Host.ID(), req(20000)))
+
+	// quotas are reset once the window expires
+	windowed := NewRequestValidator(idx, pm, DispatchPolicy{
+		PeerQuota:   3000,
+		QuotaWindow: 50 * time.Millisecond,
+	})
+	require.NoError(t, windowed.Validate(n2.Host.ID(), req(3000)))
+	require.Error(t, windowed.Validate(n2.Host.ID(), req(1000)))
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, windowed.Validate(n2.Host.ID(), req(1000)))
+	require.Error(t, windowed.Validate(n2.Host.ID(), req(3000)))
+
+	// expired counts are dropped instead of piling up
+	time.Sleep(60 * time.Millisecond)
+	windowed.mu.Lock()
+	windowed.prune(time.Now())
+	require.Len(t, windowed.received, 0)
+	windowed.mu.Unlock()
+}
+
+func TestRequestValidatorIncentive(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+
+	idx, err := NewIndex(n1.Ds, n1.Bs, WithBounds(100000, 80000))
+	require.NoError(t, err)
+	pm := NewPeerMgr(n1.Host, idx, []Region{global})
+	pm.handleHey(n2.Host.ID(), Hey{
+		Regions: []RegionCode{GlobalRegion},
+	})
+
+	v := NewRequestValidator(idx, pm, DispatchPolicy{
+		MaxSize:          5000,
+		MinIncentive:     big.NewInt(2),
+		IncentiveMaxSize: 50000,
+	})
+
+	req := func(size uint64) Request {
+		return Request{
+			Method:     Dispatch,
+			PayloadCID: bgen.Next().Cid(),
+			Size:       size,
+		}
+	}
+
+	testCases := []struct {
+		name string
+		req  Request
+		inc  *Incentive
+		err  bool
+	}{
+		{"no incentive", req(10000), nil, true},
+		{"incentive too low", req(10000), &Incentive{Amount: big.NewInt(19999)}, true},
+		{"incentivized", req(10000), &Incentive{Amount: big.NewInt(20000)}, false},
+		{"over incentive max size", req(60000), &Incentive{Amount: big.NewInt(200000)}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.ValidateWithIncentive(n2.Host.ID(), tc.req, tc.inc)
+			if tc.err {
+				require.True(t, errors.Is(err, ErrRequestRejected))
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// incentives don't change the limits of a policy without a minimum
+	nomin := NewRequestValidator(idx, pm, DispatchPolicy{MaxSize: 5000})
+	require.Error(t, nomin.ValidateWithIncentive(n2.Host.ID(), req(10000), &Incentive{Amount: big.NewInt(1000000)}))
+}
@@ -0,0 +1,61 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/internal/utils"
+)
+
+// ErrCorruptContent is returned when the DAG we received is missing blocks or blocks don't match their CID
+var ErrCorruptContent = errors.New("corrupt content")
+
+// VerifyDAG walks the DAG selected for the given root and checks every block is present and matches its CID.
+// The declared size is the size of the content so the blocks must hold at least as many bytes.
+func VerifyDAG(ctx context.Context, root cid.Cid, s ipld.Node, bs blockstore.Blockstore, size uint64) error {
+	var total uint64
+	err := utils.WalkDAG(ctx, root, bs, s, func(blk blocks.Block) error {
+		c, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil {
+			return err
+		}
+		if !c.Equals(blk.Cid()) {
+			return fmt.Errorf("block %s does not match its CID", blk.Cid())
+		}
+		total += uint64(len(blk.RawData()))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptContent, err)
+	}
+	if total < size {
+		return fmt.Errorf("%w: received %d bytes out of %d", ErrCorruptContent, total, size)
+	}
+	return nil
+}
+
+// verifyTransfer checks the content received for a root if verification is enabled and lets subscribers know
+// about the result
+func (r *Replication) verifyTransfer(ctx context.Context, p peer.ID, root cid.Cid, s ipld.Node, bs blockstore.Blockstore, size uint64) error {
+	if !r.verify {
+		return nil
+	}
+	state := ReplicationState{
+		PayloadCID: root,
+		Peer:       p,
+		Size:       size,
+	}
+	if err := VerifyDAG(ctx, root, s, bs, size); err != nil {
+		state.Message = err.Error()
+		r.publish(ReplicationEventVerificationFailed, state)
+		return err
+	}
+	r.publish(ReplicationEventVerified, state)
+	return nil
+}
@@ -0,0 +1,53 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDAG(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+	tn := testutil.NewTestNode(mn, t)
+
+	fname := tn.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := tn.LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	store, err := tn.Ms.Get(storeID)
+	require.NoError(t, err)
+
+	require.NoError(t, VerifyDAG(ctx, root, sel.All(), store.Bstore, uint64(len(origBytes))))
+
+	// more content than the blocks hold
+	require.ErrorIs(t, VerifyDAG(ctx, root, sel.All(), store.Bstore, uint64(len(origBytes))*2), ErrCorruptContent)
+
+	keys, err := store.Bstore.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var leaf blocks.Block
+	for k := range keys {
+		if !k.Equals(root) {
+			leaf, err = store.Bstore.Get(k)
+			require.NoError(t, err)
+			break
+		}
+	}
+	require.NotNil(t, leaf)
+
+	// a block doesn't match its CID
+	corrupt, err := blocks.NewBlockWithCid([]byte("corrupt"), leaf.Cid())
+	require.NoError(t, err)
+	require.NoError(t, store.Bstore.DeleteBlock(leaf.Cid()))
+	require.NoError(t, store.Bstore.Put(corrupt))
+	require.ErrorIs(t, VerifyDAG(ctx, root, sel.All(), store.Bstore, 0), ErrCorruptContent)
+
+	// a block is missing
+	require.NoError(t, store.Bstore.DeleteBlock(leaf.Cid()))
+	require.ErrorIs(t, VerifyDAG(ctx, root, sel.All(), store.Bstore, 0), ErrCorruptContent)
+}
@@ -0,0 +1,323 @@
+package exchange
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+//go:generate cbor-gen-for RequestV1 HeyV1
+
+// Versions of the hey protocol. Peers greet each other with the latest version they both speak.
+const (
+	// HeyProtocolV1 only greets with the regions and the index root
+	HeyProtocolV1 = protocol.ID("/myel/pop/hey/1.0")
+	// HeyProtocol adds the capabilities of the peer
+	HeyProtocol = protocol.ID("/myel/pop/hey/1.1")
+)
+
+// HeyProtocols are the versions of the hey protocol we speak from most to least preferred
+var HeyProtocols = []protocol.ID{
+	HeyProtocol,
+	HeyProtocolV1,
+}
+
+// HeyV1 is a Hey as encoded in version 1.0 of the hey protocol
+type HeyV1 struct {
+	Regions   []RegionCode
+	IndexRoot *cid.Cid
+}
+
+// Hey upgrades a 1.0 greeting. The peer advertises no capability so its capacity is unknown.
+func (h HeyV1) Hey() Hey {
+	return Hey{
+		Regions:   h.Regions,
+		IndexRoot: h.IndexRoot,
+		// peers greeting us with an older protocol don't advertise their capacity
+		Capacity: math.MaxUint64,
+	}
+}
+
+// writeHey encodes a greeting in the format of the given protocol version
+func writeHey(w io.Writer, proto protocol.ID, h Hey) error {
+	if proto == HeyProtocolV1 {
+		legacy := HeyV1{
+			Regions:   h.Regions,
+			IndexRoot: h.IndexRoot,
+		}
+		return cborutil.WriteCborRPC(w, &legacy)
+	}
+	return cborutil.WriteCborRPC(w, &h)
+}
+
+// Versions of the request protocol. Both sides of a request stream negotiate the latest version they
+// speak so old and new caches keep exchanging requests during upgrades.
+const (
+	// PopRequestProtocolV1 encodes requests without a pull token
+	PopRequestProtocolV1 = protocol.ID("/myel/pop/request/1.0")
+	// PopRequestProtocolV11 adds the pull token authorizing the recipient of a dispatch
+	PopRequestProtocolV11 = protocol.ID("/myel/pop/request/1.1")
+	// PopRequestProtocolV2 lets the decoder skip trailing fields it doesn't know about so new fields
+	// can be appended to requests without another protocol version
+	PopRequestProtocolV2 = protocol.ID("/myel/pop/request/2.0")
+)
+
+// RequestProtocols are the versions of the request protocol we speak from most to least preferred
+var RequestProtocols = []protocol.ID{
+	PopRequestProtocolV2,
+	PopRequestProtocolV11,
+	PopRequestProtocolV1,
+}
+
+// RequestV1 is a Request as encoded in version 1.0 of the request protocol.
+// It is also the data transfer voucher of 1.0 caches.
+type RequestV1 struct {
+	Method     Method
+	PayloadCID cid.Cid
+	Size       uint64
+}
+
+// Type is the voucher identifier 1.0 caches registered for requests
+func (RequestV1) Type() datatransfer.TypeIdentifier {
+	return "ReplicationRequestVoucher"
+}
+
+// Request upgrades a 1.0 request. It has no token so recipients of a dispatch can only pull the content
+// if we trust them.
+func (r RequestV1) Request() Request {
+	return Request{
+		Method:     r.Method,
+		PayloadCID: r.PayloadCID,
+		Size:       r.Size,
+	}
+}
+
+// voucherFor returns the voucher to pull the content of a request with. 1.0 caches only know RequestV1.
+func voucherFor(req Request, legacy bool) datatransfer.Voucher {
+	if legacy {
+		return &RequestV1{
+			Method:     req.Method,
+			PayloadCID: req.PayloadCID,
+			Size:       req.Size,
+		}
+	}
+	return &req
+}
+
+// requestVoucher upgrades a voucher of any version to a Request
+func requestVoucher(voucher datatransfer.Voucher) (*Request, bool) {
+	switch v := voucher.(type) {
+	case *Request:
+		return v, true
+	case *RequestV1:
+		req := v.Request()
+		return &req, true
+	default:
+		return nil, false
+	}
+}
+
+// legacyPeer returns whether a peer greeted us as a 1.0 cache which only speaks the first request protocol
+func (r *Replication) legacyPeer(p peer.ID) bool {
+	info, ok := r.pm.Peer(p)
+	return ok && !info.Supports(string(PopRequestProtocolV11))
+}
+
+// legacyTokens holds the pull tokens we issued to 1.0 caches until they expire. These caches cannot carry
+// a token in their vouchers so we look it up by content and peer when they pull.
+type legacyTokens struct {
+	mu     sync.Mutex
+	tokens map[string]PullToken
+}
+
+func newLegacyTokens() *legacyTokens {
+	return &legacyTokens{
+		tokens: make(map[string]PullToken),
+	}
+}
+
+func (lt *legacyTokens) put(tok PullToken) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	now := time.Now().Unix()
+	for k, t := range lt.tokens {
+		if int64(t.Expiry) < now {
+			delete(lt.tokens, k)
+		}
+	}
+	lt.tokens[revocationKey(tok.PayloadCID, tok.Peer)] = tok
+}
+
+func (lt *legacyTokens) get(root cid.Cid, p peer.ID) (*PullToken, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	tok, ok := lt.tokens[revocationKey(root, p)]
+	if !ok {
+		return nil, false
+	}
+	return &tok, true
+}
+
+// legacyValidator validates the pulls of 1.0 caches with the token we issued them when dispatching
+type legacyValidator struct {
+	r *Replication
+}
+
+// ValidatePush rejects all pushes like the current validator
+func (v *legacyValidator) ValidatePush(
+	isRestart bool,
+	chid datatransfer.ChannelID,
+	sender peer.ID,
+	voucher datatransfer.Voucher,
+	baseCid cid.Cid,
+	selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, fmt.Errorf("no pushed accepted")
+}
+
+// ValidatePull attaches the token we hold for the cache to its request before validating it
+func (v *legacyValidator) ValidatePull(
+	isRestart bool,
+	chid datatransfer.ChannelID,
+	receiver peer.ID,
+	voucher datatransfer.Voucher,
+	baseCid cid.Cid,
+	selector ipld.Node) (datatransfer.VoucherResult, error) {
+	legacy, ok := voucher.(*RequestV1)
+	if !ok {
+		return nil, fmt.Errorf("bad voucher")
+	}
+	req := legacy.Request()
+	if tok, ok := v.r.legacy.get(baseCid, receiver); ok && req.Method == Dispatch {
+		req.Token = tok
+	}
+	return v.r.ValidatePull(isRestart, chid, receiver, &req, baseCid, selector)
+}
+
+// requestFields is the number of Request fields we know about
+const requestFields = 4
+
+// extensibleRequest decodes requests with more fields than we know about. Fields are only ever appended
+// to a request so the ones we know come first and the rest is skipped.
+type extensibleRequest struct {
+	Request
+	// Incentive is the first field appended to requests in version 2.0
+	Incentive *Incentive
+	// Private is appended after the incentive
+	Private bool
+}
+
+func (t *extensibleRequest) UnmarshalCBOR(r io.Reader) error {
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajArray {
+		return fmt.Errorf("cbor input should be of type array")
+	}
+	if extra < requestFields {
+		return fmt.Errorf("cbor input had wrong number of fields")
+	}
+	// Decode the known fields as a regular request
+	var req Request
+	if err := req.UnmarshalCBOR(io.MultiReader(bytes.NewReader(lengthBufRequest), br)); err != nil {
+		return err
+	}
+	var inc *Incentive
+	if extra > requestFields {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return err
+			}
+			inc = new(Incentive)
+			if err := inc.UnmarshalCBOR(br); err != nil {
+				return fmt.Errorf("unmarshaling incentive: %w", err)
+			}
+		}
+	}
+	var private bool
+	if extra > requestFields+1 {
+		maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajOther {
+			return fmt.Errorf("booleans must be major type 7")
+		}
+		switch extra {
+		case 20:
+			private = false
+		case 21:
+			private = true
+		default:
+			return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+		}
+	}
+	for i := uint64(requestFields + 2); i < extra; i++ {
+		var skip cbg.Deferred
+		if err := skip.UnmarshalCBOR(br); err != nil {
+			return err
+		}
+	}
+	*t = extensibleRequest{Request: req, Incentive: inc, Private: private}
+	return nil
+}
+
+// writeRequest encodes a request in the format of the given protocol version
+func writeRequest(w io.Writer, proto protocol.ID, req Request) error {
+	if proto == PopRequestProtocolV1 {
+		legacy := RequestV1{
+			Method:     req.Method,
+			PayloadCID: req.PayloadCID,
+			Size:       req.Size,
+		}
+		return cborutil.WriteCborRPC(w, &legacy)
+	}
+	return cborutil.WriteCborRPC(w, &req)
+}
+
+// writeDispatch encodes a request with the extras for the recipient if any. Only version 2.0
+// can carry the extras, older versions receive the request alone.
+func writeDispatch(w io.Writer, proto protocol.ID, req Request, ext DispatchExtras) error {
+	if (ext.Incentive == nil && !ext.Private) || proto != PopRequestProtocolV2 {
+		return writeRequest(w, proto, req)
+	}
+	buf := new(bytes.Buffer)
+	if err := req.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	fields := uint64(requestFields + 1)
+	if ext.Private {
+		fields++
+	}
+	if err := cbg.WriteMajorTypeHeader(w, cbg.MajArray, fields); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()[len(lengthBufRequest):]); err != nil {
+		return err
+	}
+	if err := ext.Incentive.MarshalCBOR(w); err != nil {
+		return err
+	}
+	if ext.Private {
+		return cbg.WriteBool(w, true)
+	}
+	return nil
+}
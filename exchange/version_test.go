@@ -0,0 +1,268 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-state-types/big"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestRequestVersions(t *testing.T) {
+	root := blockGen.Next().Cid()
+	tok := PullToken{PayloadCID: root, Expiry: 10, Signature: []byte("sig")}
+	req := Request{Method: Dispatch, PayloadCID: root, Size: 100, Token: &tok}
+
+	for _, proto := range RequestProtocols {
+		t.Run(string(proto), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			require.NoError(t, writeRequest(buf, proto, req))
+			dec, err := DecodeRequestVersion(buf, proto)
+			require.NoError(t, err)
+			if proto == PopRequestProtocolV1 {
+				// 1.0 has no token
+				require.Equal(t, Request{Method: Dispatch, PayloadCID: root, Size: 100}, dec)
+				return
+			}
+			require.Equal(t, req, dec)
+		})
+	}
+
+	// 2.0 skips the fields appended by newer versions after the incentive and private flag
+	buf := new(bytes.Buffer)
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajArray, requestFields+3))
+	enc := new(bytes.Buffer)
+	require.NoError(t, req.MarshalCBOR(enc))
+	buf.Write(enc.Bytes()[len(lengthBufRequest):])
+	buf.Write(cbg.CborNull)
+	buf.Write(cbg.CborBoolFalse)
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajTextString, 3))
+	buf.WriteString("new")
+
+	dec, err := DecodeRequestVersion(bytes.NewReader(buf.Bytes()), PopRequestProtocolV2)
+	require.NoError(t, err)
+	require.Equal(t, req, dec)
+
+	// 1.1 is strict
+	_, err = DecodeRequestVersion(bytes.NewReader(buf.Bytes()), PopRequestProtocolV11)
+	require.ErrorIs(t, err, ErrInvalidMsg)
+}
+
+func TestDispatchIncentive(t *testing.T) {
+	root := blockGen.Next().Cid()
+	req := Request{Method: Dispatch, PayloadCID: root, Size: 100}
+	inc := &Incentive{Amount: big.NewInt(1000), Hold: 3600}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, writeDispatch(buf, PopRequestProtocolV2, req, DispatchExtras{Incentive: inc}))
+	dec, ext, err := DecodeDispatch(bytes.NewReader(buf.Bytes()), PopRequestProtocolV2)
+	require.NoError(t, err)
+	require.Equal(t, req, dec)
+	require.Equal(t, inc, ext.Incentive)
+	require.False(t, ext.Private)
+
+	// Older versions receive the request without the extras
+	for _, proto := range []protocol.ID{PopRequestProtocolV11, PopRequestProtocolV1} {
+		buf := new(bytes.Buffer)
+		require.NoError(t, writeDispatch(buf, proto, req, DispatchExtras{Incentive: inc, Private: true}))
+		dec, ext, err := DecodeDispatch(buf, proto)
+		require.NoError(t, err)
+		require.Equal(t, req, dec)
+		require.Nil(t, ext.Incentive)
+		require.False(t, ext.Private)
+	}
+
+	// An incentive must pay something
+	buf = new(bytes.Buffer)
+	require.NoError(t, writeDispatch(buf, PopRequestProtocolV2, req, DispatchExtras{Incentive: &Incentive{Amount: big.Zero()}}))
+	_, _, err = DecodeDispatch(buf, PopRequestProtocolV2)
+	require.ErrorIs(t, err, ErrInvalidMsg)
+}
+
+func TestDispatchPrivate(t *testing.T) {
+	root := blockGen.Next().Cid()
+	req := Request{Method: Dispatch, PayloadCID: root, Size: 100}
+
+	// Private content can be dispatched without incentive
+	buf := new(bytes.Buffer)
+	require.NoError(t, writeDispatch(buf, PopRequestProtocolV2, req, DispatchExtras{Private: true}))
+	dec, ext, err := DecodeDispatch(bytes.NewReader(buf.Bytes()), PopRequestProtocolV2)
+	require.NoError(t, err)
+	require.Equal(t, req, dec)
+	require.Nil(t, ext.Incentive)
+	require.True(t, ext.Private)
+
+	inc := &Incentive{Amount: big.NewInt(1000), Hold: 3600}
+	buf.Reset()
+	require.NoError(t, writeDispatch(buf, PopRequestProtocolV2, req, DispatchExtras{Incentive: inc, Private: true}))
+	_, ext, err = DecodeDispatch(bytes.NewReader(buf.Bytes()), PopRequestProtocolV2)
+	require.NoError(t, err)
+	require.Equal(t, inc, ext.Incentive)
+	require.True(t, ext.Private)
+}
+
+func TestRequestProtocolNegotiation(t *testing.T) {
+	mn := mocknet.New(context.Background())
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	// n2 only speaks the first version of the protocol
+	received := make(chan Request, 1)
+	n2.Host.SetStreamHandler(PopRequestProtocolV1, func(s network.Stream) {
+		defer s.Close()
+		req, err := DecodeRequestVersion(s, s.Protocol())
+		require.NoError(t, err)
+		received <- req
+	})
+
+	s, err := OpenStream(context.Background(), n1.Host, n2.Host.ID(), RequestProtocols)
+	require.NoError(t, err)
+	require.Equal(t, PopRequestProtocolV1, s.Protocol())
+
+	root := blockGen.Next().Cid()
+	rs := &RequestStream{p: n2.Host.ID(), rw: s, proto: s.Protocol()}
+	require.NoError(t, rs.WriteRequest(Request{Method: Dispatch, PayloadCID: root, Size: 10}))
+
+	select {
+	case req := <-received:
+		require.Equal(t, root, req.PayloadCID)
+		require.Nil(t, req.Token)
+	case <-time.After(time.Second):
+		t.Fatal("request not received")
+	}
+}
+
+func TestHeyVersions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	idx, err := NewIndex(n1.Ds, n1.Bs, WithBounds(1000, 800))
+	require.NoError(t, err)
+
+	p1 := NewPeerMgr(n1.Host, idx, []Region{global})
+	require.NoError(t, p1.Run(ctx))
+
+	// n2 only speaks 1.0
+	heys := make(chan HeyV1, 1)
+	n2.Host.SetStreamHandler(HeyProtocolV1, func(s network.Stream) {
+		defer s.Close()
+		var h HeyV1
+		require.NoError(t, h.UnmarshalCBOR(s))
+		heys <- h
+	})
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	select {
+	case h := <-heys:
+		require.Equal(t, []RegionCode{GlobalRegion}, h.Regions)
+	case <-ctx.Done():
+		t.Fatal("did not receive 1.0 hey")
+	}
+
+	s, err := n2.Host.NewStream(ctx, n1.Host.ID(), HeyProtocolV1)
+	require.NoError(t, err)
+	require.NoError(t, writeHey(s, s.Protocol(), Hey{Regions: []RegionCode{GlobalRegion}, Capacity: 10}))
+
+	require.Eventually(t, func() bool {
+		_, ok := p1.Peer(n2.Host.ID())
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	info, _ := p1.Peer(n2.Host.ID())
+	require.Equal(t, uint64(math.MaxUint64), info.Capacity)
+
+	// A peer which didn't advertise its capacity can still be selected
+	r := &Replication{pm: p1}
+	require.Equal(t, []peer.ID{n2.Host.ID()}, r.selectProviders(1, 1000, DispatchOptions{}, []Region{global}, nil))
+
+	for _, proto := range HeyProtocols {
+		buf := new(bytes.Buffer)
+		require.NoError(t, writeHey(buf, proto, p1.getHey()))
+		dec, err := DecodeHeyVersion(buf, proto)
+		require.NoError(t, err)
+		if proto == HeyProtocolV1 {
+			require.Equal(t, uint64(math.MaxUint64), dec.Capacity)
+			continue
+		}
+		require.Equal(t, uint64(1000), dec.Capacity)
+	}
+}
+
+func TestLegacyDispatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	setupNode := func() (*testutil.TestNode, *Replication) {
+		n := testutil.NewTestNode(mn, t)
+		n.SetupDataTransfer(ctx, t)
+		idx, err := NewIndex(n.Ds, n.Bs)
+		require.NoError(t, err)
+		opts := Options{Regions: []Region{global}, MultiStore: n.Ms, Blockstore: n.Bs}
+		r, err := NewReplication(n.Host, idx, n.Dt, NewMockRetriever(n.Dt, idx), opts)
+		require.NoError(t, err)
+		require.NoError(t, r.Start(ctx))
+		return n, r
+	}
+
+	n1, supply := setupNode()
+	// n2 is a 1.0 cache receiving requests without tokens and pulling with the legacy voucher
+	n2, cache := setupNode()
+	n2.Host.RemoveStreamHandler(PopRequestProtocolV2)
+	n2.Host.RemoveStreamHandler(PopRequestProtocolV11)
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	// the cache only accepts requests from peers it exchanged a hey with
+	require.Eventually(t, func() bool {
+		_, ok := cache.pm.Peer(n1.Host.ID())
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	fname := n1.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := n1.LoadFileToNewStore(ctx, t, fname)
+	rootCid := link.(cidlink.Link).Cid
+
+	res, err := supply.Dispatch(rootCid, uint64(len(origBytes)), DispatchOptions{
+		BackoffMin:     time.Second,
+		BackoffAttemps: 4,
+		RF:             1,
+		StoreID:        storeID,
+		Peers:          []peer.ID{n2.Host.ID()},
+	})
+	require.NoError(t, err)
+
+	select {
+	case rec := <-res.Out():
+		require.Equal(t, n2.Host.ID(), rec.Provider)
+	case <-ctx.Done():
+		t.Fatal("1.0 cache did not pull the content")
+	}
+	require.Eventually(t, func() bool {
+		_, err := cache.idx.GetRef(rootCid)
+		return err == nil
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// Without the token we hold for it the cache cannot pull
+	req := &RequestV1{Method: Dispatch, PayloadCID: rootCid, Size: uint64(len(origBytes))}
+	_, err = (&legacyValidator{supply}).ValidatePull(false, datatransfer.ChannelID{}, n1.Host.ID(), req, rootCid, nil)
+	require.Error(t, err)
+}
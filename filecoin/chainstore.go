@@ -0,0 +1,140 @@
+package filecoin
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// DefaultChainCacheSize is the maximum number of bytes of chain blocks the light client keeps in memory
+const DefaultChainCacheSize = 64 << 20
+
+// chainStore is an in memory blockstore holding at most a given number of bytes of chain blocks.
+// The least recently used blocks are evicted first, the light client fetches them again over
+// bitswap if it ever needs them so we never persist chain data.
+type chainStore struct {
+	mu     sync.Mutex
+	max    int
+	size   int
+	lru    *list.List
+	blocks map[string]*list.Element
+}
+
+var _ blockstore.Blockstore = (*chainStore)(nil)
+
+func newChainStore(max int) *chainStore {
+	return &chainStore{
+		max:    max,
+		lru:    list.New(),
+		blocks: make(map[string]*list.Element),
+	}
+}
+
+func (s *chainStore) DeleteBlock(c cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.blocks[string(c.Hash())]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+func (s *chainStore) Has(c cid.Cid) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.blocks[string(c.Hash())]
+	return ok, nil
+}
+
+func (s *chainStore) Get(c cid.Cid) (block.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.blocks[string(c.Hash())]
+	if !ok {
+		return nil, blockstore.ErrNotFound
+	}
+	s.lru.MoveToFront(e)
+	blk := e.Value.(block.Block)
+	// the same content may be requested with a different codec
+	if !blk.Cid().Equals(c) {
+		return block.NewBlockWithCid(blk.RawData(), c)
+	}
+	return blk, nil
+}
+
+func (s *chainStore) GetSize(c cid.Cid) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.blocks[string(c.Hash())]
+	if !ok {
+		return -1, blockstore.ErrNotFound
+	}
+	return len(e.Value.(block.Block).RawData()), nil
+}
+
+func (s *chainStore) Put(blk block.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(blk)
+	return nil
+}
+
+func (s *chainStore) PutMany(blks []block.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, blk := range blks {
+		s.put(blk)
+	}
+	return nil
+}
+
+func (s *chainStore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	s.mu.Lock()
+	keys := make([]cid.Cid, 0, len(s.blocks))
+	for _, e := range s.blocks {
+		keys = append(keys, e.Value.(block.Block).Cid())
+	}
+	s.mu.Unlock()
+
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		for _, k := range keys {
+			select {
+			case out <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// HashOnRead is a no-op as blocks never leave memory
+func (s *chainStore) HashOnRead(enabled bool) {}
+
+// put adds a block and evicts the least recently used ones until we're back under our limit.
+// Must hold the lock.
+func (s *chainStore) put(blk block.Block) {
+	k := string(blk.Cid().Hash())
+	if e, ok := s.blocks[k]; ok {
+		s.lru.MoveToFront(e)
+		return
+	}
+	s.blocks[k] = s.lru.PushFront(blk)
+	s.size += len(blk.RawData())
+	for s.size > s.max && s.lru.Len() > 1 {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove a block from the store. Must hold the lock.
+func (s *chainStore) remove(e *list.Element) {
+	blk := s.lru.Remove(e).(block.Block)
+	delete(s.blocks, string(blk.Cid().Hash()))
+	s.size -= len(blk.RawData())
+}
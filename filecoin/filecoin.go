@@ -19,6 +19,7 @@ type API interface {
 	StateGetActor(context.Context, address.Address, TipSetKey) (*Actor, error)
 	MpoolPush(context.Context, *SignedMessage) (cid.Cid, error)
 	StateWaitMsg(context.Context, cid.Cid, uint64) (*MsgLookup, error)
+	StateSearchMsg(context.Context, cid.Cid) (*MsgLookup, error)
 	StateAccountKey(context.Context, address.Address, TipSetKey) (address.Address, error)
 	StateLookupID(context.Context, address.Address, TipSetKey) (address.Address, error)
 	StateReadState(context.Context, address.Address, TipSetKey) (*ActorState, error)
@@ -27,6 +28,8 @@ type API interface {
 	StateDealProviderCollateralBounds(context.Context, abi.PaddedPieceSize, bool, TipSetKey) (DealCollateralBounds, error)
 	StateMinerInfo(context.Context, address.Address, TipSetKey) (MinerInfo, error)
 	StateMinerProvingDeadline(context.Context, address.Address, TipSetKey) (*dline.Info, error)
+	StateMarketStorageDeal(context.Context, abi.DealID, TipSetKey) (*MarketDeal, error)
+	StateVerifiedClientStatus(context.Context, address.Address, TipSetKey) (*abi.StoragePower, error)
 	StateCall(context.Context, *Message, TipSetKey) (*InvocResult, error)
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
 	ChainGetMessage(context.Context, cid.Cid) (*Message, error)
@@ -0,0 +1,824 @@
+package filecoin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	amt "github.com/filecoin-project/go-amt-ipld/v2"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/account"
+	init4 "github.com/filecoin-project/specs-actors/v4/actors/builtin/init"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	"github.com/filecoin-project/specs-actors/v4/actors/states"
+	"github.com/filecoin-project/specs-actors/v4/actors/util/adt"
+	"github.com/ipfs/go-bitswap"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/rs/zerolog/log"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// MainnetName is the name of the Filecoin mainnet used to derive the gossip topics
+const MainnetName = "testnetnet"
+
+// LightLookback is the number of epochs we walk back from the head when searching for a message
+const LightLookback = 120
+
+// lightFinality is how many epochs of tipsets we keep in memory behind the head
+const lightFinality = 900
+
+// ErrNotSupported is returned by the light client for queries it cannot answer without a full node
+var ErrNotSupported = errors.New("not supported by the light client")
+
+// lightValidateTimeout is how long we wait for the parents and state of a gossiped block to validate it
+const lightValidateTimeout = 30 * time.Second
+
+// lightGasPremium is the premium per unit of gas we offer, the minimum accepted by the message pools
+var lightGasPremium = abi.NewTokenAmount(100000)
+
+// lightFeeCapFactor multiplies the base fee so the fee cap still covers it after 20 full blocks
+const lightFeeCapFactor = 11
+
+// ErrNoChainHead is returned when the light client hasn't received any block yet
+var ErrNoChainHead = errors.New("no chain head received yet")
+
+// ErrNoTrustedPeers is returned when starting the light client without any peer to receive blocks from
+var ErrNoTrustedPeers = errors.New("light client needs trusted peers")
+
+// ErrInvalidBlock is returned when a gossiped block is not signed by its miner or doesn't extend our chain
+var ErrInvalidBlock = errors.New("invalid block")
+
+// BLSVerifier verifies BLS signatures
+type BLSVerifier interface {
+	Verify(sig []byte, a address.Address, msg []byte) error
+}
+
+// LightOptions configures the chain the light client follows and who it trusts
+type LightOptions struct {
+	// Network is the name of the Filecoin network to follow. Defaults to mainnet.
+	Network string
+	// Trusted are the Filecoin nodes we accept blocks from. They validate consensus before relaying blocks.
+	Trusted []peer.ID
+	// Checkpoint is a tipset the first head we accept must descend from
+	Checkpoint TipSetKey
+	// Verifier checks the block signatures against the worker keys of their miners
+	Verifier BLSVerifier
+	// CacheSize is the maximum number of bytes of chain blocks kept in memory. Defaults to DefaultChainCacheSize.
+	CacheSize int
+}
+
+// BlocksTopic is the gossip topic new blocks are announced on
+func BlocksTopic(network string) string {
+	return "/fil/blocks/" + network
+}
+
+// MessagesTopic is the gossip topic messages are broadcast on
+func MessagesTopic(network string) string {
+	return "/fil/msgs/" + network
+}
+
+// LightAPI follows the chain heads gossiped by Filecoin peers and fetches the blocks it needs
+// to answer state queries over bitswap. It does not validate consensus itself so it only accepts blocks
+// relayed by trusted peers, signed by the worker of their miner and extending the chain it follows.
+// Only a handful of queries are supported, the others return ErrNotSupported.
+type LightAPI struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	bserv      blockservice.BlockService
+	blocks     *pubsub.Topic
+	msgs       *pubsub.Topic
+	trusted    map[peer.ID]struct{}
+	checkpoint TipSetKey
+	verifier   BLSVerifier
+
+	mu      sync.Mutex
+	head    *TipSet
+	headCh  chan struct{}
+	pending map[string][]*BlockHeader
+	// tipsets only holds tipsets assembled from the blocks we accepted
+	tipsets map[TipSetKey]*TipSet
+}
+
+// NewLightAPI joins the chain gossip topics of the network and starts following the heads relayed by trusted peers
+func NewLightAPI(ctx context.Context, h host.Host, ps *pubsub.PubSub, opts LightOptions) (*LightAPI, error) {
+	if len(opts.Trusted) == 0 {
+		return nil, ErrNoTrustedPeers
+	}
+	if opts.Verifier == nil {
+		return nil, errors.New("light client needs a BLS verifier")
+	}
+	netName := opts.Network
+	if netName == "" {
+		netName = MainnetName
+	}
+	cacheSize := opts.CacheSize
+	if cacheSize == 0 {
+		cacheSize = DefaultChainCacheSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	// chain blocks can always be fetched again so we only keep the most recently used ones in memory
+	bs := newChainStore(cacheSize)
+	// Filecoin nodes serve chain data over bitswap with a dedicated protocol prefix
+	bsn := bsnet.NewFromIpfsHost(h, routinghelpers.Null{}, bsnet.Prefix("/chain"))
+	exch := bitswap.New(ctx, bsn, bs)
+	bserv := blockservice.New(bs, exch)
+
+	l := &LightAPI{
+		ctx:        ctx,
+		cancel:     cancel,
+		bserv:      bserv,
+		trusted:    make(map[peer.ID]struct{}, len(opts.Trusted)),
+		checkpoint: opts.Checkpoint,
+		verifier:   opts.Verifier,
+		headCh:     make(chan struct{}),
+		pending:    make(map[string][]*BlockHeader),
+		tipsets:    make(map[TipSetKey]*TipSet),
+	}
+	for _, p := range opts.Trusted {
+		l.trusted[p] = struct{}{}
+	}
+
+	btopic := BlocksTopic(netName)
+	// Only deliver and relay the blocks we validated
+	err := ps.RegisterTopicValidator(btopic, l.validate, pubsub.WithValidatorTimeout(lightValidateTimeout))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	l.blocks, err = ps.Join(btopic)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	l.msgs, err = ps.Join(MessagesTopic(netName))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sub, err := l.blocks.Subscribe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go l.follow(sub)
+	return l, nil
+}
+
+// follow reads the blocks gossiped on the network and assembles them into tipsets
+func (l *LightAPI) follow(sub *pubsub.Subscription) {
+	defer sub.Cancel()
+	for {
+		msg, err := sub.Next(l.ctx)
+		if err != nil {
+			return
+		}
+		bm, ok := msg.ValidatorData.(*BlockMsg)
+		if !ok {
+			continue
+		}
+		// keep the header around so we don't need to fetch it again when walking the chain
+		if blk, err := bm.Header.ToStorageBlock(); err == nil {
+			if err := l.bserv.AddBlock(blk); err != nil {
+				log.Error().Err(err).Msg("failed to store block header")
+			}
+		}
+		l.addBlock(bm.Header)
+	}
+}
+
+// validate only accepts the blocks relayed by a trusted peer which pass checkBlock
+func (l *LightAPI) validate(ctx context.Context, p peer.ID, msg *pubsub.Message) bool {
+	if _, ok := l.trusted[p]; !ok {
+		return false
+	}
+	bm := new(BlockMsg)
+	if err := bm.UnmarshalCBOR(bytes.NewReader(msg.GetData())); err != nil || bm.Header == nil {
+		return false
+	}
+	if err := l.checkBlock(ctx, bm.Header); err != nil {
+		log.Debug().Err(err).Str("block", bm.Header.Cid().String()).Str("peer", p.String()).Msg("rejected block")
+		return false
+	}
+	msg.ValidatorData = bm
+	return true
+}
+
+// checkBlock verifies a header extends the chain we follow and is signed by the worker of its miner
+func (l *LightAPI) checkBlock(ctx context.Context, bh *BlockHeader) error {
+	if bh.BlockSig == nil || bh.BlockSig.Type != crypto.SigTypeBLS {
+		return fmt.Errorf("%w: missing BLS signature", ErrInvalidBlock)
+	}
+	parents, err := l.loadTipSet(ctx, NewTipSetKey(bh.Parents...))
+	if err != nil {
+		return fmt.Errorf("failed to load parents: %w", err)
+	}
+	if bh.Height <= parents.Height() {
+		return fmt.Errorf("%w: height %d is not above its parents at %d", ErrInvalidBlock, bh.Height, parents.Height())
+	}
+	if !bh.ParentWeight.GreaterThan(parents.blks[0].ParentWeight) {
+		return fmt.Errorf("%w: weight %s is not above its parents", ErrInvalidBlock, bh.ParentWeight)
+	}
+	if err := l.checkLinked(ctx, parents); err != nil {
+		return err
+	}
+	// The state committed by the block itself cannot be trusted before executing the parents so we read
+	// the worker key from the state of the parents. Worker changes only take effect after a long delay.
+	tree, err := l.stateTree(ctx, parents.Key())
+	if err != nil {
+		return fmt.Errorf("failed to load parent state: %w", err)
+	}
+	worker, err := l.minerWorker(ctx, tree, bh.Miner)
+	if err != nil {
+		return fmt.Errorf("failed to load miner worker: %w", err)
+	}
+	sb, err := bh.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if err := l.verifier.Verify(bh.BlockSig.Data, worker, sb); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	return nil
+}
+
+// checkLinked walks back from a tipset until it reaches one we accepted, or the checkpoint
+// if we have no head yet. Without checkpoint the first head is only vouched for by the trusted peers.
+func (l *LightAPI) checkLinked(ctx context.Context, ts *TipSet) error {
+	l.mu.Lock()
+	head := l.head
+	l.mu.Unlock()
+
+	var min abi.ChainEpoch
+	switch {
+	case head != nil:
+		min = head.Height() - lightFinality
+	case l.checkpoint != EmptyTSK:
+		cp, err := l.loadTipSet(ctx, l.checkpoint)
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		min = cp.Height()
+	default:
+		return nil
+	}
+	for {
+		if ts.Key() == l.checkpoint {
+			return nil
+		}
+		l.mu.Lock()
+		_, ok := l.tipsets[ts.Key()]
+		l.mu.Unlock()
+		if ok {
+			return nil
+		}
+		if ts.Height() <= min {
+			return fmt.Errorf("%w: does not extend the chain we follow", ErrInvalidBlock)
+		}
+		var err error
+		ts, err = l.loadTipSet(ctx, NewTipSetKey(ts.blks[0].Parents...))
+		if err != nil {
+			return fmt.Errorf("failed to load ancestor: %w", err)
+		}
+	}
+}
+
+// addBlock groups headers with the same height and parents and updates the head if the
+// resulting tipset is heavier than the current one
+func (l *LightAPI) addBlock(bh *BlockHeader) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.head != nil && bh.Height+lightFinality < l.head.Height() {
+		return
+	}
+
+	key := fmt.Sprintf("%d/%x", bh.Height, NewTipSetKey(bh.Parents...).Bytes())
+	for _, b := range l.pending[key] {
+		if b.Cid() == bh.Cid() {
+			return
+		}
+	}
+	blks := append(l.pending[key], bh)
+	l.pending[key] = blks
+
+	ts, err := NewTipSet(append([]*BlockHeader{}, blks...))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to assemble tipset")
+		return
+	}
+	l.tipsets[ts.Key()] = ts
+
+	if l.head != nil && !heavier(ts, l.head) {
+		return
+	}
+	l.head = ts
+	close(l.headCh)
+	l.headCh = make(chan struct{})
+
+	l.prune()
+}
+
+// heavier compares tipsets by weight then height then number of blocks
+func heavier(a, b *TipSet) bool {
+	wa, wb := a.blks[0].ParentWeight, b.blks[0].ParentWeight
+	if !wa.Equals(wb) {
+		return wa.GreaterThan(wb)
+	}
+	if a.Height() != b.Height() {
+		return a.Height() > b.Height()
+	}
+	return len(a.blks) > len(b.blks)
+}
+
+// prune drops the tipsets we won't need anymore, must be called with the lock held
+func (l *LightAPI) prune() {
+	min := l.head.Height() - lightFinality
+	for k, ts := range l.tipsets {
+		if ts.Height() < min {
+			delete(l.tipsets, k)
+		}
+	}
+	for k, blks := range l.pending {
+		if blks[0].Height < min {
+			delete(l.pending, k)
+		}
+	}
+}
+
+// loadTipSet returns the head for an empty key or fetches the headers of the given key.
+// Fetched tipsets are not cached since they may not extend the chain we follow.
+func (l *LightAPI) loadTipSet(ctx context.Context, tsk TipSetKey) (*TipSet, error) {
+	l.mu.Lock()
+	if tsk == EmptyTSK {
+		defer l.mu.Unlock()
+		if l.head == nil {
+			return nil, ErrNoChainHead
+		}
+		return l.head, nil
+	}
+	ts, ok := l.tipsets[tsk]
+	l.mu.Unlock()
+	if ok {
+		return ts, nil
+	}
+
+	cids := tsk.Cids()
+	blks := make([]*BlockHeader, len(cids))
+	for i, c := range cids {
+		var bh BlockHeader
+		if err := l.readObj(ctx, c, &bh); err != nil {
+			return nil, fmt.Errorf("failed to load block header %s: %w", c, err)
+		}
+		blks[i] = &bh
+	}
+	return NewTipSet(blks)
+}
+
+func (l *LightAPI) ipldStore(ctx context.Context) cbor.IpldStore {
+	return cbor.NewCborStore(&chainBlockstore{ctx, l.bserv})
+}
+
+func (l *LightAPI) readObj(ctx context.Context, c cid.Cid, out cbg.CBORUnmarshaler) error {
+	blk, err := l.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return err
+	}
+	return out.UnmarshalCBOR(bytes.NewReader(blk.RawData()))
+}
+
+// stateTree loads the state tree the given tipset was built on
+func (l *LightAPI) stateTree(ctx context.Context, tsk TipSetKey) (*states.Tree, error) {
+	ts, err := l.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	var root StateRoot
+	if err := l.readObj(ctx, ts.blks[0].ParentStateRoot, &root); err != nil {
+		return nil, fmt.Errorf("failed to load state root: %w", err)
+	}
+	if root.Version == 0 {
+		return nil, ErrNotSupported
+	}
+	return states.LoadTree(adt.WrapStore(ctx, l.ipldStore(ctx)), root.Actors)
+}
+
+// lookupID resolves a non ID address with the init actor
+func (l *LightAPI) lookupID(tree *states.Tree, addr address.Address) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+	act, found, err := tree.GetActor(builtin.InitActorAddr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !found {
+		return address.Undef, fmt.Errorf("init actor not found")
+	}
+	var st init4.State
+	if err := tree.Store.Get(tree.Store.Context(), act.Head, &st); err != nil {
+		return address.Undef, err
+	}
+	id, found, err := st.ResolveAddress(tree.Store, addr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !found {
+		return address.Undef, fmt.Errorf("actor not found: %s", addr)
+	}
+	return id, nil
+}
+
+// accountKey resolves the public key address of an account actor
+func (l *LightAPI) accountKey(ctx context.Context, tree *states.Tree, addr address.Address) (address.Address, error) {
+	if addr.Protocol() == address.BLS || addr.Protocol() == address.SECP256K1 {
+		return addr, nil
+	}
+	act, err := l.loadActor(tree, addr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if act.Code != builtin.AccountActorCodeID {
+		return address.Undef, fmt.Errorf("%s is not an account actor", addr)
+	}
+	var st account.State
+	if err := tree.Store.Get(ctx, act.Head, &st); err != nil {
+		return address.Undef, err
+	}
+	return st.Address, nil
+}
+
+// minerWorker resolves the key of the worker signing the blocks of a miner
+func (l *LightAPI) minerWorker(ctx context.Context, tree *states.Tree, addr address.Address) (address.Address, error) {
+	act, err := l.loadActor(tree, addr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if act.Code != builtin.StorageMinerActorCodeID {
+		return address.Undef, fmt.Errorf("%s is not a miner actor", addr)
+	}
+	var st miner.State
+	if err := tree.Store.Get(ctx, act.Head, &st); err != nil {
+		return address.Undef, err
+	}
+	info, err := st.GetInfo(tree.Store)
+	if err != nil {
+		return address.Undef, err
+	}
+	return l.accountKey(ctx, tree, info.Worker)
+}
+
+func (l *LightAPI) loadActor(tree *states.Tree, addr address.Address) (*states.Actor, error) {
+	id, err := l.lookupID(tree, addr)
+	if err != nil {
+		return nil, err
+	}
+	act, found, err := tree.GetActor(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("actor not found: %s", addr)
+	}
+	return act, nil
+}
+
+func (l *LightAPI) getActor(ctx context.Context, addr address.Address, tsk TipSetKey) (*states.Tree, *states.Actor, error) {
+	tree, err := l.stateTree(ctx, tsk)
+	if err != nil {
+		return nil, nil, err
+	}
+	act, err := l.loadActor(tree, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, act, nil
+}
+
+// ChainHead returns the heaviest tipset we have received
+func (l *LightAPI) ChainHead(ctx context.Context) (*TipSet, error) {
+	return l.loadTipSet(ctx, EmptyTSK)
+}
+
+// StateGetActor loads an actor from the parent state of the given tipset
+func (l *LightAPI) StateGetActor(ctx context.Context, addr address.Address, tsk TipSetKey) (*Actor, error) {
+	_, act, err := l.getActor(ctx, addr, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return &Actor{
+		Code:    act.Code,
+		Head:    act.Head,
+		Nonce:   act.CallSeqNum,
+		Balance: act.Balance,
+	}, nil
+}
+
+// StateLookupID returns the ID address of the given address
+func (l *LightAPI) StateLookupID(ctx context.Context, addr address.Address, tsk TipSetKey) (address.Address, error) {
+	tree, err := l.stateTree(ctx, tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+	return l.lookupID(tree, addr)
+}
+
+// StateAccountKey returns the public key address of an account actor
+func (l *LightAPI) StateAccountKey(ctx context.Context, addr address.Address, tsk TipSetKey) (address.Address, error) {
+	if addr.Protocol() == address.BLS || addr.Protocol() == address.SECP256K1 {
+		return addr, nil
+	}
+	tree, err := l.stateTree(ctx, tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+	return l.accountKey(ctx, tree, addr)
+}
+
+// StateReadState only decodes the state of account and payment channel actors
+func (l *LightAPI) StateReadState(ctx context.Context, addr address.Address, tsk TipSetKey) (*ActorState, error) {
+	tree, act, err := l.getActor(ctx, addr, tsk)
+	if err != nil {
+		return nil, err
+	}
+	var st cbg.CBORUnmarshaler
+	switch act.Code {
+	case builtin.AccountActorCodeID:
+		st = new(account.State)
+	case builtin.PaymentChannelActorCodeID:
+		st = new(paych.State)
+	default:
+		return nil, ErrNotSupported
+	}
+	if err := tree.Store.Get(ctx, act.Head, st); err != nil {
+		return nil, err
+	}
+	return &ActorState{
+		Balance: act.Balance,
+		State:   st,
+	}, nil
+}
+
+// StateSearchMsg looks for a message in the last LightLookback epochs
+func (l *LightAPI) StateSearchMsg(ctx context.Context, c cid.Cid) (*MsgLookup, error) {
+	child, err := l.loadTipSet(ctx, EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	min := child.Height() - LightLookback
+	for child.Height() > min && child.Height() > 0 {
+		ts, err := l.loadTipSet(ctx, NewTipSetKey(child.blks[0].Parents...))
+		if err != nil {
+			return nil, err
+		}
+		idx, found, err := l.messageIndex(ctx, ts, c)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			// receipts of the messages in a tipset are committed by its child
+			rcpts, err := amt.LoadAMT(ctx, l.ipldStore(ctx), child.blks[0].ParentMessageReceipts)
+			if err != nil {
+				return nil, err
+			}
+			var rct MessageReceipt
+			if err := rcpts.Get(ctx, idx, &rct); err != nil {
+				return nil, err
+			}
+			return &MsgLookup{
+				Message: c,
+				Receipt: rct,
+				TipSet:  ts.Key(),
+				Height:  ts.Height(),
+			}, nil
+		}
+		child = ts
+	}
+	return nil, nil
+}
+
+// messageIndex returns the execution index of a message in the given tipset. Messages included in
+// multiple blocks are only executed once so the index accounts for duplicates.
+func (l *LightAPI) messageIndex(ctx context.Context, ts *TipSet, c cid.Cid) (uint64, bool, error) {
+	store := l.ipldStore(ctx)
+	seen := make(map[cid.Cid]struct{})
+	var idx uint64
+	for _, bh := range ts.blks {
+		var meta TxMeta
+		if err := l.readObj(ctx, bh.Messages, &meta); err != nil {
+			return 0, false, err
+		}
+		for _, root := range []cid.Cid{meta.BlsMessages, meta.SecpkMessages} {
+			a, err := amt.LoadAMT(ctx, store, root)
+			if err != nil {
+				return 0, false, err
+			}
+			var found bool
+			err = a.ForEach(ctx, func(_ uint64, v *cbg.Deferred) error {
+				if found {
+					return nil
+				}
+				mc, err := cbg.ReadCid(bytes.NewReader(v.Raw))
+				if err != nil {
+					return err
+				}
+				if _, ok := seen[mc]; ok {
+					return nil
+				}
+				seen[mc] = struct{}{}
+				if mc == c {
+					found = true
+					return nil
+				}
+				idx++
+				return nil
+			})
+			if err != nil {
+				return 0, false, err
+			}
+			if found {
+				return idx, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// StateWaitMsg waits until a message is found on chain with the given number of confirmations
+func (l *LightAPI) StateWaitMsg(ctx context.Context, c cid.Cid, confidence uint64) (*MsgLookup, error) {
+	for {
+		l.mu.Lock()
+		head, headCh := l.head, l.headCh
+		l.mu.Unlock()
+
+		if head != nil {
+			lkp, err := l.StateSearchMsg(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			if lkp != nil && head.Height() >= lkp.Height+abi.ChainEpoch(confidence) {
+				return lkp, nil
+			}
+		}
+
+		select {
+		case <-headCh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ChainReadObj fetches a raw block from the network
+func (l *LightAPI) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	blk, err := l.bserv.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return blk.RawData(), nil
+}
+
+// ChainGetMessage fetches a message whether it is signed or not
+func (l *LightAPI) ChainGetMessage(ctx context.Context, c cid.Cid) (*Message, error) {
+	raw, err := l.ChainReadObj(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := msg.UnmarshalCBOR(bytes.NewReader(raw)); err == nil {
+		return &msg, nil
+	}
+	var smsg SignedMessage
+	if err := smsg.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return &smsg.Message, nil
+}
+
+// MpoolPush broadcasts a signed message to the network
+func (l *LightAPI) MpoolPush(ctx context.Context, smsg *SignedMessage) (cid.Cid, error) {
+	buf, err := smsg.Serialize()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := l.msgs.Publish(ctx, buf); err != nil {
+		return cid.Undef, err
+	}
+	return smsg.Cid(), nil
+}
+
+// GasEstimateMessageGas fills the gas fields left empty. The light client cannot execute messages so the gas
+// limit is a generous bound for the kind of message and the fee cap a multiple of the latest base fee.
+// Overestimating the limit only burns part of the excess.
+func (l *LightAPI) GasEstimateMessageGas(ctx context.Context, msg *Message, spec *MessageSendSpec, tsk TipSetKey) (*Message, error) {
+	ts, err := l.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	if msg.GasLimit == 0 {
+		msg.GasLimit = lightGasLimit(msg)
+	}
+	if msg.GasPremium.Nil() || msg.GasPremium.IsZero() {
+		msg.GasPremium = lightGasPremium
+	}
+	if msg.GasFeeCap.Nil() || msg.GasFeeCap.IsZero() {
+		msg.GasFeeCap = big.Add(big.Mul(ts.blks[0].ParentBaseFee, big.NewInt(lightFeeCapFactor)), msg.GasPremium)
+	}
+	// never pay more than the max fee of the spec
+	if spec != nil && !spec.MaxFee.Nil() && !spec.MaxFee.IsZero() {
+		maxFeeCap := big.Div(spec.MaxFee, big.NewInt(msg.GasLimit))
+		if msg.GasFeeCap.GreaterThan(maxFeeCap) {
+			msg.GasFeeCap = maxFeeCap
+		}
+		if msg.GasPremium.GreaterThan(msg.GasFeeCap) {
+			msg.GasPremium = msg.GasFeeCap
+		}
+	}
+	return msg, nil
+}
+
+// lightGasLimit bounds the gas used by the messages we send: transfers which may create the account
+// of the recipient, payment channel creations through the init actor and payment channel updates
+func lightGasLimit(msg *Message) int64 {
+	switch {
+	case msg.Method == builtin.MethodSend:
+		return 5000000
+	case msg.To == builtin.InitActorAddr && msg.Method == builtin.MethodsInit.Exec:
+		return 50000000
+	default:
+		return 25000000
+	}
+}
+
+func (l *LightAPI) StateNetworkVersion(context.Context, TipSetKey) (network.Version, error) {
+	return 0, ErrNotSupported
+}
+
+func (l *LightAPI) StateMarketBalance(context.Context, address.Address, TipSetKey) (MarketBalance, error) {
+	return MarketBalance{}, ErrNotSupported
+}
+
+func (l *LightAPI) StateDealProviderCollateralBounds(context.Context, abi.PaddedPieceSize, bool, TipSetKey) (DealCollateralBounds, error) {
+	return DealCollateralBounds{}, ErrNotSupported
+}
+
+func (l *LightAPI) StateMinerInfo(context.Context, address.Address, TipSetKey) (MinerInfo, error) {
+	return MinerInfo{}, ErrNotSupported
+}
+
+func (l *LightAPI) StateMinerProvingDeadline(context.Context, address.Address, TipSetKey) (*dline.Info, error) {
+	return nil, ErrNotSupported
+}
+
+func (l *LightAPI) StateMarketStorageDeal(context.Context, abi.DealID, TipSetKey) (*MarketDeal, error) {
+	return nil, ErrNotSupported
+}
+
+func (l *LightAPI) StateVerifiedClientStatus(context.Context, address.Address, TipSetKey) (*abi.StoragePower, error) {
+	return nil, ErrNotSupported
+}
+
+func (l *LightAPI) StateCall(context.Context, *Message, TipSetKey) (*InvocResult, error) {
+	return nil, ErrNotSupported
+}
+
+// Close stops following the chain
+func (l *LightAPI) Close() {
+	l.cancel()
+	l.blocks.Close()
+	l.msgs.Close()
+}
+
+// chainBlockstore adapts the blockservice so the ipld store can fetch missing blocks from the network
+type chainBlockstore struct {
+	ctx   context.Context
+	bserv blockservice.BlockService
+}
+
+func (bs *chainBlockstore) Get(c cid.Cid) (block.Block, error) {
+	return bs.bserv.GetBlock(bs.ctx, c)
+}
+
+func (bs *chainBlockstore) Put(blk block.Block) error {
+	return bs.bserv.AddBlock(blk)
+}
+
+var _ API = (*LightAPI)(nil)
@@ -0,0 +1,223 @@
+package filecoin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLightCBOR(t *testing.T) {
+	c := testBlockHeader().Parents[0]
+
+	bm := &BlockMsg{
+		Header:        testBlockHeader(),
+		BlsMessages:   []cid.Cid{c},
+		SecpkMessages: []cid.Cid{c, c},
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, bm.MarshalCBOR(buf))
+	var obm BlockMsg
+	require.NoError(t, obm.UnmarshalCBOR(buf))
+	require.Equal(t, bm.Header.Cid(), obm.Header.Cid())
+	require.Equal(t, bm.SecpkMessages, obm.SecpkMessages)
+
+	root := &StateRoot{Version: 3, Actors: c, Info: c}
+	buf.Reset()
+	require.NoError(t, root.MarshalCBOR(buf))
+	var oroot StateRoot
+	require.NoError(t, oroot.UnmarshalCBOR(buf))
+	require.Equal(t, *root, oroot)
+
+	meta := &TxMeta{BlsMessages: c, SecpkMessages: c}
+	buf.Reset()
+	require.NoError(t, meta.MarshalCBOR(buf))
+	var ometa TxMeta
+	require.NoError(t, ometa.UnmarshalCBOR(buf))
+	require.Equal(t, *meta, ometa)
+
+	rct := &MessageReceipt{ExitCode: exitcode.ErrForbidden, Return: []byte("ret"), GasUsed: 1234}
+	buf.Reset()
+	require.NoError(t, rct.MarshalCBOR(buf))
+	var orct MessageReceipt
+	require.NoError(t, orct.UnmarshalCBOR(buf))
+	require.Equal(t, *rct, orct)
+}
+
+func TestLightHead(t *testing.T) {
+	l := &LightAPI{
+		headCh:  make(chan struct{}),
+		pending: make(map[string][]*BlockHeader),
+		tipsets: make(map[TipSetKey]*TipSet),
+	}
+
+	b1 := testBlockHeader()
+	l.addBlock(b1)
+	require.Equal(t, NewTipSetKey(b1.Cid()), l.head.Key())
+
+	// A second block with the same parents joins the tipset
+	headCh := l.headCh
+	b2 := testBlockHeader()
+	b2.Ticket = &Ticket{VRFProof: []byte("vrf proof1111111vrf proof1111111")}
+	l.addBlock(b2)
+	require.Equal(t, 2, len(l.head.blks))
+	select {
+	case <-headCh:
+	default:
+		t.Fatal("head change was not notified")
+	}
+
+	// Receiving the same block again is a noop
+	l.addBlock(b2)
+	require.Equal(t, 2, len(l.head.blks))
+
+	// A lighter fork doesn't replace the head
+	fork := testBlockHeader()
+	fork.Height++
+	fork.Parents = []cid.Cid{b1.Cid()}
+	fork.ParentWeight = NewInt(1)
+	l.addBlock(fork)
+	require.Equal(t, 2, len(l.head.blks))
+
+	// The child of the head does
+	child := testBlockHeader()
+	child.Height++
+	child.Parents = l.head.Key().Cids()
+	child.ParentWeight = NewInt(123125126213)
+	l.addBlock(child)
+	require.Equal(t, NewTipSetKey(child.Cid()), l.head.Key())
+	require.Equal(t, 4, len(l.tipsets))
+}
+
+func TestLightCheckBlock(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	trusted := peer.ID("trusted")
+	l := &LightAPI{
+		bserv:   blockservice.New(bs, offline.Exchange(bs)),
+		trusted: map[peer.ID]struct{}{trusted: {}},
+		headCh:  make(chan struct{}),
+		pending: make(map[string][]*BlockHeader),
+		tipsets: make(map[TipSetKey]*TipSet),
+	}
+
+	header := func(height abi.ChainEpoch, weight uint64, parents ...cid.Cid) *BlockHeader {
+		bh := testBlockHeader()
+		bh.Height = height
+		bh.ParentWeight = NewInt(weight)
+		if len(parents) > 0 {
+			bh.Parents = parents
+		}
+		blk, err := bh.ToStorageBlock()
+		require.NoError(t, err)
+		require.NoError(t, bs.Put(blk))
+		return bh
+	}
+	tipset := func(bh *BlockHeader) *TipSet {
+		ts, err := NewTipSet([]*BlockHeader{bh})
+		require.NoError(t, err)
+		return ts
+	}
+
+	checkpoint := header(10, 100)
+	parent := header(11, 110, checkpoint.Cid())
+	other := header(10, 101)
+	fork := header(11, 111, other.Cid())
+	l.checkpoint = NewTipSetKey(checkpoint.Cid())
+
+	// The first head must descend from the checkpoint
+	require.NoError(t, l.checkLinked(ctx, tipset(parent)))
+	require.True(t, errors.Is(l.checkLinked(ctx, tipset(fork)), ErrInvalidBlock))
+
+	// Blocks must be signed
+	unsigned := header(12, 120, parent.Cid())
+	unsigned.BlockSig = nil
+	require.True(t, errors.Is(l.checkBlock(ctx, unsigned), ErrInvalidBlock))
+
+	// Blocks must be above their parents
+	require.True(t, errors.Is(l.checkBlock(ctx, header(11, 120, parent.Cid())), ErrInvalidBlock))
+	require.True(t, errors.Is(l.checkBlock(ctx, header(12, 110, parent.Cid())), ErrInvalidBlock))
+
+	// Blocks with parents we cannot fetch are rejected
+	require.Error(t, l.checkBlock(ctx, header(12, 120, testBlockHeader().Cid())))
+
+	// Once we have a head, accepted tipsets link new blocks to the chain
+	l.checkpoint = EmptyTSK
+	l.addBlock(parent)
+	require.NoError(t, l.checkLinked(ctx, tipset(parent)))
+
+	// Blocks are only accepted from trusted peers
+	buf := new(bytes.Buffer)
+	require.NoError(t, (&BlockMsg{Header: header(12, 120, parent.Cid())}).MarshalCBOR(buf))
+	msg := &pubsub.Message{Message: &pb.Message{Data: buf.Bytes()}}
+	require.False(t, l.validate(ctx, peer.ID("stranger"), msg))
+	// The parent state of the block cannot be loaded so the signature cannot be verified
+	require.False(t, l.validate(ctx, trusted, msg))
+	require.Nil(t, msg.ValidatorData)
+}
+
+func TestLightGasEstimate(t *testing.T) {
+	ctx := context.Background()
+	l := &LightAPI{
+		headCh:  make(chan struct{}),
+		pending: make(map[string][]*BlockHeader),
+		tipsets: make(map[TipSetKey]*TipSet),
+	}
+	bh := testBlockHeader()
+	bh.ParentBaseFee = NewInt(100)
+	l.addBlock(bh)
+
+	msg, err := l.GasEstimateMessageGas(ctx, &Message{Method: 0}, nil, EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, int64(5000000), msg.GasLimit)
+	require.True(t, msg.GasPremium.Equals(lightGasPremium))
+	require.True(t, msg.GasFeeCap.Equals(abi.NewTokenAmount(1100+100000)))
+
+	// The fee cap never exceeds the max fee
+	msg, err = l.GasEstimateMessageGas(ctx, &Message{Method: 2, GasLimit: 1000}, &MessageSendSpec{MaxFee: abi.NewTokenAmount(50000)}, EmptyTSK)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), msg.GasLimit)
+	require.True(t, msg.GasFeeCap.Equals(abi.NewTokenAmount(50)))
+	require.True(t, msg.GasPremium.Equals(abi.NewTokenAmount(50)))
+}
+
+func TestChainStoreEviction(t *testing.T) {
+	s := newChainStore(250)
+	var blks []blocks.Block
+	for i := 0; i < 3; i++ {
+		blk := blocks.NewBlock(bytes.Repeat([]byte{byte(i)}, 100))
+		blks = append(blks, blk)
+		require.NoError(t, s.Put(blk))
+	}
+	// the first block was evicted to stay under the limit
+	has, err := s.Has(blks[0].Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = s.Get(blks[0].Cid())
+	require.ErrorIs(t, err, blockstore.ErrNotFound)
+
+	// reading a block keeps it around
+	_, err = s.Get(blks[1].Cid())
+	require.NoError(t, err)
+	require.NoError(t, s.Put(blocks.NewBlock(bytes.Repeat([]byte{3}, 100))))
+	has, err = s.Has(blks[1].Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = s.Has(blks[2].Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+}
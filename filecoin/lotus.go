@@ -20,6 +20,7 @@ type LotusAPI struct {
 		StateGetActor                     func(context.Context, address.Address, TipSetKey) (*Actor, error)
 		MpoolPush                         func(context.Context, *SignedMessage) (cid.Cid, error)
 		StateWaitMsg                      func(context.Context, cid.Cid, uint64) (*MsgLookup, error)
+		StateSearchMsg                    func(context.Context, cid.Cid) (*MsgLookup, error)
 		StateAccountKey                   func(context.Context, address.Address, TipSetKey) (address.Address, error)
 		StateLookupID                     func(context.Context, address.Address, TipSetKey) (address.Address, error)
 		StateReadState                    func(context.Context, address.Address, TipSetKey) (*ActorState, error)
@@ -28,6 +29,8 @@ type LotusAPI struct {
 		StateDealProviderCollateralBounds func(context.Context, abi.PaddedPieceSize, bool, TipSetKey) (DealCollateralBounds, error)
 		StateMinerInfo                    func(context.Context, address.Address, TipSetKey) (MinerInfo, error)
 		StateMinerProvingDeadline         func(context.Context, address.Address, TipSetKey) (*dline.Info, error)
+		StateMarketStorageDeal            func(context.Context, abi.DealID, TipSetKey) (*MarketDeal, error)
+		StateVerifiedClientStatus         func(context.Context, address.Address, TipSetKey) (*abi.StoragePower, error)
 		StateCall                         func(context.Context, *Message, TipSetKey) (*InvocResult, error)
 		ChainReadObj                      func(context.Context, cid.Cid) ([]byte, error)
 		ChainGetMessage                   func(context.Context, cid.Cid) (*Message, error)
@@ -72,6 +75,10 @@ func (a *LotusAPI) StateWaitMsg(ctx context.Context, c cid.Cid, conf uint64) (*M
 	return a.Methods.StateWaitMsg(ctx, c, conf)
 }
 
+func (a *LotusAPI) StateSearchMsg(ctx context.Context, c cid.Cid) (*MsgLookup, error) {
+	return a.Methods.StateSearchMsg(ctx, c)
+}
+
 func (a *LotusAPI) StateAccountKey(ctx context.Context, addr address.Address, tsk TipSetKey) (address.Address, error) {
 	return a.Methods.StateAccountKey(ctx, addr, tsk)
 }
@@ -104,6 +111,14 @@ func (a *LotusAPI) StateMinerProvingDeadline(ctx context.Context, addr address.A
 	return a.Methods.StateMinerProvingDeadline(ctx, addr, tsk)
 }
 
+func (a *LotusAPI) StateMarketStorageDeal(ctx context.Context, id abi.DealID, tsk TipSetKey) (*MarketDeal, error) {
+	return a.Methods.StateMarketStorageDeal(ctx, id, tsk)
+}
+
+func (a *LotusAPI) StateVerifiedClientStatus(ctx context.Context, addr address.Address, tsk TipSetKey) (*abi.StoragePower, error) {
+	return a.Methods.StateVerifiedClientStatus(ctx, addr, tsk)
+}
+
 func (a *LotusAPI) StateCall(ctx context.Context, msg *Message, tsk TipSetKey) (*InvocResult, error) {
 	return a.Methods.StateCall(ctx, msg, tsk)
 }
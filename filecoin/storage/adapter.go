@@ -57,29 +57,23 @@ func (a *Adapter) AddFunds(ctx context.Context, addr address.Address, amount abi
 		log.Error().Msg("GasEstimateMessageGas failed")
 		return cid.Undef, err
 	}
-	act, err := a.fAPI.StateGetActor(ctx, msg.From, fil.EmptyTSK)
-	if err != nil {
-		log.Error().Msg("StateGetActor failed")
+	// The wallet tracks the nonces of our other messages which may still be in the pool
+	if err := a.wallet.Nonces().Assign(ctx, a.fAPI, msg); err != nil {
+		log.Error().Msg("assigning nonce failed")
 		return cid.Undef, err
 	}
-	msg.Nonce = act.Nonce
-	mbl, err := msg.ToStorageBlock()
+	smsg, err := wallet.SignMessage(ctx, a.wallet, msg)
 	if err != nil {
-		log.Error().Msg("ToStorageBlock failed")
+		log.Error().Msg("wallet.SignMessage failed")
+		a.wallet.Nonces().Release(msg)
 		return cid.Undef, err
 	}
-	sig, err := a.wallet.Sign(ctx, msg.From, mbl.Cid().Bytes())
+	log.Info().Msg("MpoolPush")
+	c, err := a.fAPI.MpoolPush(ctx, smsg)
 	if err != nil {
-		log.Error().Msg("wallet.Sign failed")
-		return cid.Undef, err
+		a.wallet.Nonces().Release(msg)
 	}
-
-	smsg := &fil.SignedMessage{
-		Message:   *msg,
-		Signature: *sig,
-	}
-	log.Info().Msg("MpoolPush")
-	return a.fAPI.MpoolPush(ctx, smsg)
+	return c, err
 }
 
 // GetBalance returns locked/unlocked for a storage participant.
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// AggregateEntry locates the blocks of a payload packed in an aggregated piece
+type AggregateEntry struct {
+	Root cid.Cid
+	// Offset is the position in bytes of the first block of the payload in the CAR
+	Offset uint64
+	// Size is the number of bytes of the payload in the CAR. Blocks shared with a payload packed before
+	// are only written once.
+	Size uint64
+}
+
+// Aggregate is a single storage piece packing multiple payloads so small commits don't each pay for a deal
+type Aggregate struct {
+	// Root links to the roots of all the payloads
+	Root    cid.Cid
+	Entries []AggregateEntry
+	// Ref is the data reference to propose offline deals for the piece
+	Ref *storagemarket.DataRef
+}
+
+// Entry returns the entry for a payload root if it is in the aggregate
+func (a *Aggregate) Entry(root cid.Cid) (AggregateEntry, bool) {
+	for _, e := range a.Entries {
+		if e.Root == root {
+			return e, true
+		}
+	}
+	return AggregateEntry{}, false
+}
+
+// countWriter counts the bytes written so far
+type countWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// AggregatePieces packs the DAGs of the given roots in a single CAR written to out and computes the piece
+// commitment. The CAR root is a node linking to every payload root so the piece is a valid DAG.
+func AggregatePieces(ctx context.Context, dag ipldformat.DAGService, roots []cid.Cid, out io.Writer) (*Aggregate, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no payload to aggregate")
+	}
+	aroot := &merkledag.ProtoNode{}
+	for _, r := range roots {
+		if err := aroot.AddRawLink(r.String(), &ipldformat.Link{Cid: r}); err != nil {
+			return nil, err
+		}
+	}
+	if err := dag.Add(ctx, aroot); err != nil {
+		return nil, err
+	}
+
+	wr := &writer.Writer{}
+	bw := bufio.NewWriterSize(wr, int(writer.CommPBuf))
+	cw := &countWriter{w: io.MultiWriter(bw, out)}
+
+	err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{aroot.Cid()}, Version: 1}, cw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write car header: %w", err)
+	}
+
+	seen := cid.NewSet()
+	var write func(ipldformat.Node) error
+	write = func(nd ipldformat.Node) error {
+		if !seen.Visit(nd.Cid()) {
+			return nil
+		}
+		if err := carutil.LdWrite(cw, nd.Cid().Bytes(), nd.RawData()); err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			if seen.Has(l.Cid) {
+				continue
+			}
+			child, err := dag.Get(ctx, l.Cid)
+			if err != nil {
+				return err
+			}
+			if err := write(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The aggregate root only is written first then each payload after the other
+	seen.Add(aroot.Cid())
+	if err := carutil.LdWrite(cw, aroot.Cid().Bytes(), aroot.RawData()); err != nil {
+		return nil, err
+	}
+	agg := &Aggregate{Root: aroot.Cid()}
+	for _, r := range roots {
+		nd, err := dag.Get(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payload %s: %w", r, err)
+		}
+		start := cw.n
+		if err := write(nd); err != nil {
+			return nil, fmt.Errorf("failed to write payload %s: %w", r, err)
+		}
+		agg.Entries = append(agg.Entries, AggregateEntry{
+			Root:   r,
+			Offset: start,
+			Size:   cw.n - start,
+		})
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	sum, err := wr.Sum()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute commP: %w", err)
+	}
+	agg.Ref = &storagemarket.DataRef{
+		TransferType: storagemarket.TTManual,
+		Root:         agg.Root,
+		PieceCid:     &sum.PieceCID,
+		PieceSize:    sum.PieceSize.Unpadded(),
+	}
+	return agg, nil
+}
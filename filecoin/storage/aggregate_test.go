@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatePieces(t *testing.T) {
+	ctx := context.Background()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	shared := merkledag.NodeWithData(bytes.Repeat([]byte("shared"), 100))
+	leaf1 := merkledag.NodeWithData(bytes.Repeat([]byte("one"), 100))
+	leaf2 := merkledag.NodeWithData(bytes.Repeat([]byte("two"), 100))
+	root1 := merkledag.NodeWithData([]byte("root1"))
+	require.NoError(t, root1.AddNodeLink("shared", shared))
+	require.NoError(t, root1.AddNodeLink("leaf", leaf1))
+	root2 := merkledag.NodeWithData([]byte("root2"))
+	require.NoError(t, root2.AddNodeLink("shared", shared))
+	require.NoError(t, root2.AddNodeLink("leaf", leaf2))
+	require.NoError(t, dag.AddMany(ctx, []ipldformat.Node{shared, leaf1, leaf2, root1, root2}))
+
+	var buf bytes.Buffer
+	agg, err := AggregatePieces(ctx, dag, []cid.Cid{root1.Cid(), root2.Cid()}, &buf)
+	require.NoError(t, err)
+	require.Equal(t, storagemarket.TTManual, agg.Ref.TransferType)
+	require.Equal(t, agg.Root, agg.Ref.Root)
+	require.NotNil(t, agg.Ref.PieceCid)
+	require.Len(t, agg.Entries, 2)
+
+	e1, ok := agg.Entry(root1.Cid())
+	require.True(t, ok)
+	e2, ok := agg.Entry(root2.Cid())
+	require.True(t, ok)
+	require.Equal(t, e1.Offset+e1.Size, e2.Offset)
+	// The shared block is only written with the first payload
+	require.Less(t, e2.Size, e1.Size)
+	require.Equal(t, uint64(buf.Len()), e2.Offset+e2.Size)
+
+	// The payload can be read back at its offset
+	data := buf.Bytes()
+	c, _, _, err := carutil.ReadNode(bufio.NewReader(bytes.NewReader(data[e2.Offset:])))
+	require.NoError(t, err)
+	require.Equal(t, root2.Cid(), c)
+
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{agg.Root}, cr.Header.Roots)
+	blk, err := cr.Next()
+	require.NoError(t, err)
+	require.Equal(t, agg.Root, blk.Cid())
+	var count int
+	for {
+		_, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		count++
+	}
+	require.Equal(t, 5, count)
+
+	// The tracker finds the aggregate a payload was packed in
+	dt := NewDealTracker(&Storage{}, ds)
+	require.NoError(t, dt.TrackAggregate(agg))
+	aroot, entry, err := dt.AggregateOf(root2.Cid())
+	require.NoError(t, err)
+	require.Equal(t, agg.Root, aroot)
+	require.Equal(t, e2, entry)
+	_, _, err = dt.AggregateOf(leaf1.Cid())
+	require.Equal(t, datastore.ErrNotFound, err)
+}
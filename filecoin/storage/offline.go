@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+)
+
+// GeneratePiece writes the DAG of the given root as a CAR to out and computes its piece commitment (CommP)
+// so we can propose offline deals for it. The returned DataRef tells the miners the data will be delivered
+// out-of-band.
+func GeneratePiece(ctx context.Context, dag ipldformat.DAGService, root cid.Cid, out io.Writer) (*storagemarket.DataRef, error) {
+	wr := &writer.Writer{}
+	bw := bufio.NewWriterSize(wr, int(writer.CommPBuf))
+
+	err := car.WriteCar(ctx, dag, []cid.Cid{root}, io.MultiWriter(bw, out))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write car: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	sum, err := wr.Sum()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute commP: %w", err)
+	}
+
+	return &storagemarket.DataRef{
+		TransferType: storagemarket.TTManual,
+		Root:         root,
+		PieceCid:     &sum.PieceCID,
+		PieceSize:    sum.PieceSize.Unpadded(),
+	}, nil
+}
+
+// ImportCommand returns the command a miner runs to import the CAR of an offline deal once they received it
+func ImportCommand(proposal cid.Cid, carPath string) string {
+	return fmt.Sprintf("lotus-miner storage-deals import-data %s %s", proposal, carPath)
+}
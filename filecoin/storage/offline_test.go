@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePiece(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	leaf := merkledag.NodeWithData(bytes.Repeat([]byte("pop"), 1000))
+	root := merkledag.NodeWithData([]byte("root"))
+	require.NoError(t, root.AddNodeLink("leaf", leaf))
+	require.NoError(t, dag.AddMany(ctx, []ipldformat.Node{leaf, root}))
+
+	var buf bytes.Buffer
+	ref, err := GeneratePiece(ctx, dag, root.Cid(), &buf)
+	require.NoError(t, err)
+	require.Equal(t, storagemarket.TTManual, ref.TransferType)
+	require.Equal(t, root.Cid(), ref.Root)
+	require.NotNil(t, ref.PieceCid)
+	// The piece is padded to a power of 2
+	require.GreaterOrEqual(t, uint64(ref.PieceSize.Padded()), uint64(buf.Len()))
+
+	// The same DAG always gives the same piece
+	ref2, err := GeneratePiece(ctx, dag, root.Cid(), &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, *ref.PieceCid, *ref2.PieceCid)
+
+	cr, err := car.NewCarReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, root.Cid(), cr.Header.Roots[0])
+}
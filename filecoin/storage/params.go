@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/market"
+)
+
+// DefaultStartBuffer is how long after the proposal a deal starts if no start epoch is given.
+// It leaves enough time for the miners to receive the data and seal it.
+const DefaultStartBuffer = 49 * time.Hour
+
+// ErrDealDuration is returned when the duration of a deal is outside of the bounds enforced by the market actor
+var ErrDealDuration = errors.New("deal duration out of bounds")
+
+// DealParams tune the deals we propose to trade cost against retrievability. Zero values are the defaults.
+type DealParams struct {
+	// FastRetrieval asks the miners to keep an unsealed copy so retrievals don't need to wait for unsealing
+	FastRetrieval bool
+	// StartBuffer is how long after the proposal the deal starts. Defaults to DefaultStartBuffer.
+	StartBuffer time.Duration
+	// StartEpoch is the exact epoch the deal starts at, overrides StartBuffer
+	StartEpoch abi.ChainEpoch
+	// CollateralMultiplier multiplies the minimum collateral the miners lock and lose if the deal is slashed,
+	// capped to the maximum accepted by the market. Defaults to the minimum.
+	CollateralMultiplier uint64
+}
+
+// startEpoch returns the epoch a deal proposed at the given height starts at
+func (dp DealParams) startEpoch(height abi.ChainEpoch) abi.ChainEpoch {
+	if dp.StartEpoch > 0 {
+		return dp.StartEpoch
+	}
+	buffer := dp.StartBuffer
+	if buffer == 0 {
+		buffer = DefaultStartBuffer
+	}
+	return height + calcEpochs(buffer)
+}
+
+// collateral returns the provider collateral to ask for given the bounds of the market
+func (dp DealParams) collateral(min, max abi.TokenAmount) abi.TokenAmount {
+	if dp.CollateralMultiplier <= 1 {
+		return min
+	}
+	c := big.Mul(min, big.NewIntUnsigned(dp.CollateralMultiplier))
+	if c.GreaterThan(max) {
+		return max
+	}
+	return c
+}
+
+// checkDuration verifies a deal storing a piece of the given size for the given number of epochs
+// would be accepted by the market actor
+func checkDuration(size abi.PaddedPieceSize, epochs abi.ChainEpoch) error {
+	min, max := market.DealDurationBounds(size)
+	if epochs < min || epochs > max {
+		return fmt.Errorf("%w: %d epochs not in [%d, %d]", ErrDealDuration, epochs, min, max)
+	}
+	return nil
+}
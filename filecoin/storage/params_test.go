@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealParams(t *testing.T) {
+	// 49h at 30s per epoch
+	require.Equal(t, abi.ChainEpoch(1000+5880), DealParams{}.startEpoch(1000))
+	require.Equal(t, abi.ChainEpoch(1000+120), DealParams{StartBuffer: time.Hour}.startEpoch(1000))
+	require.Equal(t, abi.ChainEpoch(5000), DealParams{StartBuffer: time.Hour, StartEpoch: 5000}.startEpoch(1000))
+
+	min, max := abi.NewTokenAmount(10), abi.NewTokenAmount(25)
+	require.True(t, DealParams{}.collateral(min, max).Equals(min))
+	require.True(t, DealParams{CollateralMultiplier: 2}.collateral(min, max).Equals(abi.NewTokenAmount(20)))
+	require.True(t, DealParams{CollateralMultiplier: 3}.collateral(min, max).Equals(max))
+
+	size := abi.PaddedPieceSize(1 << 20)
+	require.NoError(t, checkDuration(size, calcEpochs(180*24*time.Hour)))
+	require.True(t, errors.Is(checkDuration(size, calcEpochs(24*time.Hour)), ErrDealDuration))
+	require.True(t, errors.Is(checkDuration(size, calcEpochs(600*24*time.Hour)), ErrDealDuration))
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/rs/zerolog/log"
+)
+
+// MinerPolicy decides whether a miner may be selected to store our content. It returns an error explaining
+// why the miner is rejected. selected are the miners picked so far so a policy can spread deals across them.
+type MinerPolicy func(ctx context.Context, m Miner, selected []Miner) error
+
+// MinReputation rejects miners with a reputation score lower than the given one (0-100)
+func MinReputation(score float64) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		if m.Score < score {
+			return fmt.Errorf("reputation score %.0f lower than %.0f", m.Score, score)
+		}
+		return nil
+	}
+}
+
+// MaxPrice rejects miners asking more than the given price per GiB per epoch
+func MaxPrice(price abi.TokenAmount, verified bool) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		p := m.Ask.Price
+		if verified {
+			p = m.Ask.VerifiedPrice
+		}
+		if price.LessThan(p) {
+			return fmt.Errorf("price %s higher than %s", p, price)
+		}
+		return nil
+	}
+}
+
+// InRegions rejects miners outside of the given regions
+func InRegions(regions ...string) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		for _, r := range regions {
+			if m.Region == r {
+				return nil
+			}
+		}
+		return fmt.Errorf("region %q not in %v", m.Region, regions)
+	}
+}
+
+// MaxLatency rejects miners which took longer than the given duration to answer our ask request
+func MaxLatency(d time.Duration) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		if m.Latency > d {
+			return fmt.Errorf("latency %s higher than %s", m.Latency, d)
+		}
+		return nil
+	}
+}
+
+// MinSectorLifetime rejects miners whose sectors cannot live as long as the given duration
+// so our deals don't end up in sectors expiring before them
+func MinSectorLifetime(d time.Duration) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		lifetime, err := maxSectorLifetime(m.WindowPoStProofType)
+		if err != nil {
+			return err
+		}
+		if lifetime < calcEpochs(d) {
+			return fmt.Errorf("max sector lifetime of %d epochs shorter than %d", lifetime, calcEpochs(d))
+		}
+		return nil
+	}
+}
+
+// ExcludeMiners rejects the given miners, for example because they already store a replica
+func ExcludeMiners(addrs ...address.Address) MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		for _, a := range addrs {
+			if m.Info.Address == a {
+				return fmt.Errorf("miner is excluded")
+			}
+		}
+		return nil
+	}
+}
+
+// DistinctOwners rejects miners controlled by the same owner as a miner already selected so our replicas
+// don't all depend on a single operator
+func DistinctOwners() MinerPolicy {
+	return func(ctx context.Context, m Miner, selected []Miner) error {
+		for _, s := range selected {
+			if s.Owner == m.Owner {
+				return fmt.Errorf("owner %s already stores a replica with %s", m.Owner, s.Info.Address)
+			}
+		}
+		return nil
+	}
+}
+
+// ApplyPolicies returns the first rf miners accepted by all the policies
+func ApplyPolicies(ctx context.Context, miners []Miner, rf int, policies ...MinerPolicy) []Miner {
+	var sel []Miner
+	for _, m := range miners {
+		if rf > 0 && len(sel) == rf {
+			break
+		}
+		if acceptMiner(ctx, m, sel, policies) {
+			sel = append(sel, m)
+		}
+	}
+	return sel
+}
+
+func acceptMiner(ctx context.Context, m Miner, selected []Miner, policies []MinerPolicy) bool {
+	for _, p := range policies {
+		if err := p(ctx, m, selected); err != nil {
+			log.Debug().Err(err).Str("miner", m.Info.Address.String()).Msg("miner rejected")
+			return false
+		}
+	}
+	return true
+}
+
+// maxSectorLifetime finds the maximum lifetime of the sectors sealed by a miner with the given window PoSt proof
+func maxSectorLifetime(pt abi.RegisteredPoStProof) (abi.ChainEpoch, error) {
+	for _, sp := range []abi.RegisteredSealProof{
+		abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+		abi.RegisteredSealProof_StackedDrg8MiBV1_1,
+		abi.RegisteredSealProof_StackedDrg512MiBV1_1,
+		abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		abi.RegisteredSealProof_StackedDrg64GiBV1_1,
+	} {
+		wpt, err := sp.RegisteredWindowPoStProof()
+		if err != nil || wpt != pt {
+			continue
+		}
+		return builtin.SealProofSectorMaximumLifetime(sp, network.Version12)
+	}
+	return 0, fmt.Errorf("unknown proof type %d", pt)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinerPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	newMiner := func(id, owner uint64, price uint64, region string, score float64, latency time.Duration) Miner {
+		addr, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+		oaddr, err := address.NewIDAddress(owner)
+		require.NoError(t, err)
+		return Miner{
+			Ask: &storagemarket.StorageAsk{
+				Price:         fil.NewInt(price),
+				VerifiedPrice: fil.NewInt(0),
+			},
+			Info:                &storagemarket.StorageProviderInfo{Address: addr},
+			WindowPoStProofType: abi.RegisteredPoStProof_StackedDrgWindow32GiBV1,
+			Owner:               oaddr,
+			Region:              region,
+			Score:               score,
+			Latency:             latency,
+		}
+	}
+
+	miners := []Miner{
+		newMiner(1001, 100, 10, "Europe", 90, time.Second),
+		newMiner(1002, 100, 10, "Europe", 95, time.Second),
+		newMiner(1003, 101, 500, "Europe", 99, time.Second),
+		newMiner(1004, 102, 10, "Asia", 80, time.Second),
+		newMiner(1005, 103, 10, "Europe", 40, time.Second),
+		newMiner(1006, 104, 10, "Europe", 85, 5*time.Second),
+		newMiner(1007, 105, 10, "NorthAmerica", 85, time.Second),
+	}
+	addrs := func(ms []Miner) []uint64 {
+		var ids []uint64
+		for _, m := range ms {
+			id, err := address.IDFromAddress(m.Info.Address)
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	sel := ApplyPolicies(ctx, miners, 0,
+		MinReputation(50),
+		MaxPrice(fil.NewInt(100), false),
+		InRegions("Europe", "Asia"),
+		MaxLatency(2*time.Second),
+		DistinctOwners(),
+	)
+	require.Equal(t, []uint64{1001, 1004}, addrs(sel))
+
+	// Verified deals only care about the verified price
+	sel = ApplyPolicies(ctx, miners, 2, MaxPrice(fil.NewInt(0), true), DistinctOwners())
+	require.Equal(t, []uint64{1001, 1003}, addrs(sel))
+
+	excl, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	sel = ApplyPolicies(ctx, miners, 2, ExcludeMiners(excl))
+	require.Equal(t, []uint64{1002, 1003}, addrs(sel))
+
+	sel = ApplyPolicies(ctx, miners, 0, MinSectorLifetime(100*24*time.Hour))
+	require.Len(t, sel, len(miners))
+
+	sel = ApplyPolicies(ctx, miners, 0, MinSectorLifetime(10*365*24*time.Hour))
+	require.Len(t, sel, 0)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// ProposalOutcome is how a miner answered a deal proposal
+type ProposalOutcome string
+
+const (
+	// ProposalAccepted was accepted by the miner
+	ProposalAccepted ProposalOutcome = "accepted"
+	// ProposalRejected was rejected by the miner
+	ProposalRejected ProposalOutcome = "rejected"
+	// ProposalErrored was answered with an error by the miner
+	ProposalErrored ProposalOutcome = "error"
+	// ProposalFailed never reached the miner or we could not read the answer
+	ProposalFailed ProposalOutcome = "failed"
+)
+
+// ProposalRecord is a deal proposal we sent to a miner and how it answered
+type ProposalRecord struct {
+	// ProposalCid is undefined if the miner never answered
+	ProposalCid cid.Cid
+	Root        cid.Cid
+	Miner       address.Address
+	Client      address.Address
+	// Price is the price per epoch we offered
+	Price      abi.TokenAmount
+	PieceCid   cid.Cid
+	PieceSize  abi.PaddedPieceSize
+	StartEpoch abi.ChainEpoch
+	EndEpoch   abi.ChainEpoch
+	Verified   bool
+	Offline    bool
+	Outcome    ProposalOutcome
+	// Message is the reason given by the miner or the error we got sending the proposal
+	Message string
+	Created time.Time
+}
+
+// ProposalFilter selects the proposals returned by ProposalLog.List. Zero values match everything.
+type ProposalFilter struct {
+	Miner   address.Address
+	Root    cid.Cid
+	Outcome ProposalOutcome
+}
+
+func (f ProposalFilter) match(rec ProposalRecord) bool {
+	if f.Miner != address.Undef && rec.Miner != f.Miner {
+		return false
+	}
+	if f.Root.Defined() && rec.Root != f.Root {
+		return false
+	}
+	if f.Outcome != "" && rec.Outcome != f.Outcome {
+		return false
+	}
+	return true
+}
+
+// MinerProposalStats counts the outcomes of the proposals sent to a miner
+type MinerProposalStats struct {
+	Miner    address.Address
+	Accepted int
+	Rejected int
+	Errored  int
+	Failed   int
+}
+
+// Total number of proposals sent to the miner
+func (s MinerProposalStats) Total() int {
+	return s.Accepted + s.Rejected + s.Errored + s.Failed
+}
+
+// RejectRate is the fraction of proposals the miner did not accept
+func (s MinerProposalStats) RejectRate() float64 {
+	if s.Total() == 0 {
+		return 0
+	}
+	return float64(s.Total()-s.Accepted) / float64(s.Total())
+}
+
+// ProposalLog persists every deal proposal we send so users can audit why deals failed
+type ProposalLog struct {
+	ds datastore.Batching
+}
+
+// NewProposalLog creates a new log persisting proposals in the given datastore
+func NewProposalLog(ds datastore.Batching) *ProposalLog {
+	return &ProposalLog{
+		ds: namespace.Wrap(ds, datastore.NewKey("/proposals")),
+	}
+}
+
+// Record a proposal in the log
+func (pl *ProposalLog) Record(rec ProposalRecord) error {
+	if rec.Created.IsZero() {
+		rec.Created = time.Now()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	// keys sort by creation time and the same miner cannot get 2 proposals at the same time
+	key := datastore.NewKey(fmt.Sprintf("%020d-%s", rec.Created.UnixNano(), rec.Miner))
+	return pl.ds.Put(key, data)
+}
+
+// List the proposals matching the filter, most recent first
+func (pl *ProposalLog) List(f ProposalFilter) ([]ProposalRecord, error) {
+	res, err := pl.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ProposalRecord, 0, len(entries))
+	for _, e := range entries {
+		var rec ProposalRecord
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			return nil, err
+		}
+		if f.match(rec) {
+			list = append(list, rec)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+// MinerStats aggregates the outcomes of the proposals per miner, the miners rejecting
+// our deals the most come first
+func (pl *ProposalLog) MinerStats() ([]MinerProposalStats, error) {
+	list, err := pl.List(ProposalFilter{})
+	if err != nil {
+		return nil, err
+	}
+	byMiner := make(map[address.Address]*MinerProposalStats)
+	for _, rec := range list {
+		st, ok := byMiner[rec.Miner]
+		if !ok {
+			st = &MinerProposalStats{Miner: rec.Miner}
+			byMiner[rec.Miner] = st
+		}
+		switch rec.Outcome {
+		case ProposalAccepted:
+			st.Accepted++
+		case ProposalRejected:
+			st.Rejected++
+		case ProposalErrored:
+			st.Errored++
+		default:
+			st.Failed++
+		}
+	}
+	stats := make([]MinerProposalStats, 0, len(byMiner))
+	for _, st := range byMiner {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].RejectRate() == stats[j].RejectRate() {
+			return stats[i].Total() > stats[j].Total()
+		}
+		return stats[i].RejectRate() > stats[j].RejectRate()
+	})
+	return stats, nil
+}
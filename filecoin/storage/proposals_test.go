@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProposalLog(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	pl := NewProposalLog(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	good, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	picky, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	root1 := bgen.Next().Cid()
+	root2 := bgen.Next().Cid()
+
+	now := time.Now()
+	recs := []ProposalRecord{
+		{Root: root1, Miner: good, Price: abi.NewTokenAmount(10), Outcome: ProposalAccepted, Created: now.Add(-3 * time.Minute)},
+		{Root: root1, Miner: picky, Price: abi.NewTokenAmount(10), Outcome: ProposalRejected, Message: "price too low", Created: now.Add(-2 * time.Minute)},
+		{Root: root2, Miner: picky, Price: abi.NewTokenAmount(20), Outcome: ProposalFailed, Message: "failed to open deal stream", Created: now.Add(-time.Minute)},
+		{Root: root2, Miner: good, Price: abi.NewTokenAmount(20), Outcome: ProposalAccepted, Created: now},
+	}
+	for _, rec := range recs {
+		require.NoError(t, pl.Record(rec))
+	}
+
+	list, err := pl.List(ProposalFilter{})
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	require.Equal(t, root2, list[0].Root)
+	require.Equal(t, good, list[0].Miner)
+	require.True(t, list[0].Price.Equals(abi.NewTokenAmount(20)))
+
+	list, err = pl.List(ProposalFilter{Miner: picky})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, ProposalFailed, list[0].Outcome)
+	require.Equal(t, "price too low", list[1].Message)
+
+	list, err = pl.List(ProposalFilter{Root: root1, Outcome: ProposalAccepted})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, good, list[0].Miner)
+
+	stats, err := pl.MinerStats()
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	require.Equal(t, picky, stats[0].Miner)
+	require.Equal(t, 1, stats[0].Rejected)
+	require.Equal(t, 1, stats[0].Failed)
+	require.Equal(t, 1.0, stats[0].RejectRate())
+	require.Equal(t, good, stats[1].Miner)
+	require.Equal(t, 2, stats[1].Accepted)
+	require.Equal(t, 0.0, stats[1].RejectRate())
+}
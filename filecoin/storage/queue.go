@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/jpillora/backoff"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoDealAccepted is returned when the miner of a queued job did not accept our proposal
+var ErrNoDealAccepted = errors.New("no deal accepted")
+
+// ErrDealRejected is returned when the miner of a queued job rejected our proposal. The same proposal would
+// be rejected again so the job is not retried.
+var ErrDealRejected = errors.New("deal rejected")
+
+// JobStatus is the stage a queued deal is at
+type JobStatus string
+
+const (
+	// JobQueued is waiting for its turn
+	JobQueued JobStatus = "queued"
+	// JobRetrying failed at least once and is waiting for its next attempt
+	JobRetrying JobStatus = "retrying"
+	// JobDone was accepted by the miner and is now tracked by the DealTracker
+	JobDone JobStatus = "done"
+	// JobFailed ran out of attempts or was rejected by the miner
+	JobFailed JobStatus = "failed"
+)
+
+// DealJob is a storage deal waiting to be proposed to a miner
+type DealJob struct {
+	ID     string
+	Root   cid.Cid
+	Miner  address.Address
+	Client address.Address
+	// Price is the price per GiB per epoch we offer, the ask of the miner if nil
+	Price     *abi.TokenAmount
+	PieceCid  cid.Cid
+	PieceSize abi.UnpaddedPieceSize
+	Duration  time.Duration
+	Verified  bool
+	DealParams
+	Status   JobStatus
+	Attempts int
+	// Err is the error of the last attempt
+	Err string
+	// Proposal is the proposal accepted by the miner once done
+	Proposal    cid.Cid
+	NextAttempt time.Time
+	Created     time.Time
+	Updated     time.Time
+}
+
+// QueueOptions configures how fast the queue proposes deals
+type QueueOptions struct {
+	// Interval is the minimum time between 2 proposals
+	Interval time.Duration
+	// MinerInterval is the minimum time between 2 proposals to the same miner
+	MinerInterval time.Duration
+	// BackoffMin is the delay before retrying a job the first time, doubling on each attempt
+	BackoffMin time.Duration
+	// BackoffMax is the longest delay between 2 attempts
+	BackoffMax time.Duration
+	// MaxAttempts is the number of attempts after which a job fails
+	MaxAttempts int
+}
+
+// DefaultQueueOptions spreads the deals enough to weather mempool and gas spikes
+var DefaultQueueOptions = QueueOptions{
+	Interval:      time.Minute,
+	MinerInterval: 10 * time.Minute,
+	BackoffMin:    5 * time.Minute,
+	BackoffMax:    6 * time.Hour,
+	MaxAttempts:   8,
+}
+
+// dealStorer proposes deals to a miner
+type dealStorer interface {
+	LoadMiner(ctx context.Context, addr address.Address) (Miner, error)
+	Store(ctx context.Context, p Params) (*Receipt, error)
+}
+
+// DealQueue persists the deals to propose and runs them one at a time, rate limited and spread across miners.
+// Failed jobs are retried with an exponential backoff.
+type DealQueue struct {
+	s    dealStorer
+	dt   *DealTracker
+	ds   datastore.Batching
+	opts QueueOptions
+	// kick wakes up the scheduler when a job is added
+	kick chan struct{}
+
+	mu        sync.Mutex
+	last      time.Time
+	lastMiner map[address.Address]time.Time
+}
+
+// NewDealQueue creates a new queue persisting jobs in the given datastore. Accepted deals are passed to the tracker.
+func NewDealQueue(s *Storage, dt *DealTracker, ds datastore.Batching, opts QueueOptions) *DealQueue {
+	return &DealQueue{
+		s:         s,
+		dt:        dt,
+		ds:        namespace.Wrap(ds, datastore.NewKey("/queue")),
+		opts:      opts,
+		kick:      make(chan struct{}, 1),
+		lastMiner: make(map[address.Address]time.Time),
+	}
+}
+
+// Enqueue adds a job to the queue
+func (q *DealQueue) Enqueue(job DealJob) (DealJob, error) {
+	now := time.Now()
+	job.ID = fmt.Sprintf("%020d-%s", now.UnixNano(), job.Miner)
+	job.Status = JobQueued
+	job.Created = now
+	job.Updated = now
+	job.NextAttempt = now
+	if err := q.put(job); err != nil {
+		return job, err
+	}
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// List all the jobs, oldest first
+func (q *DealQueue) List() ([]DealJob, error) {
+	res, err := q.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]DealJob, 0, len(entries))
+	for _, e := range entries {
+		var job DealJob
+		if err := json.Unmarshal(e.Value, &job); err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+// Run proposes the jobs as they are due until the context is cancelled
+func (q *DealQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.opts.Interval)
+	defer ticker.Stop()
+	for {
+		for q.Next(ctx) {
+		}
+		select {
+		case <-ticker.C:
+		case <-q.kick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Next runs the oldest job which is due and whose miner isn't cooling down.
+// It returns false if no job could run.
+func (q *DealQueue) Next(ctx context.Context) bool {
+	q.mu.Lock()
+	now := time.Now()
+	if now.Sub(q.last) < q.opts.Interval {
+		q.mu.Unlock()
+		return false
+	}
+	list, err := q.List()
+	if err != nil {
+		q.mu.Unlock()
+		log.Error().Err(err).Msg("failed to list deal jobs")
+		return false
+	}
+	var job *DealJob
+	for i, j := range list {
+		if j.Status != JobQueued && j.Status != JobRetrying {
+			continue
+		}
+		if j.NextAttempt.After(now) {
+			continue
+		}
+		if now.Sub(q.lastMiner[j.Miner]) < q.opts.MinerInterval {
+			continue
+		}
+		job = &list[i]
+		break
+	}
+	if job == nil {
+		q.mu.Unlock()
+		return false
+	}
+	q.last = now
+	q.lastMiner[job.Miner] = now
+	q.mu.Unlock()
+
+	q.run(ctx, *job)
+	return true
+}
+
+// run proposes the deal of a job and reschedules it if it failed
+func (q *DealQueue) run(ctx context.Context, job DealJob) {
+	job.Attempts++
+	proposal, err := q.propose(ctx, job)
+	job.Updated = time.Now()
+	switch {
+	case proposal.Defined():
+		// The deal was accepted so we never propose it again even if we failed to fund it
+		job.Status = JobDone
+		job.Proposal = proposal
+		job.Err = ""
+		if err != nil {
+			job.Err = err.Error()
+			log.Error().Err(err).Str("job", job.ID).Msg("failed to fund accepted deal")
+		}
+	default:
+		job.Err = err.Error()
+		if job.Attempts >= q.opts.MaxAttempts || errors.Is(err, ErrDealRejected) {
+			job.Status = JobFailed
+		} else {
+			b := &backoff.Backoff{
+				Min:    q.opts.BackoffMin,
+				Max:    q.opts.BackoffMax,
+				Factor: 2,
+				Jitter: true,
+			}
+			job.Status = JobRetrying
+			job.NextAttempt = job.Updated.Add(b.ForAttempt(float64(job.Attempts - 1)))
+		}
+		log.Debug().Err(err).Str("job", job.ID).Int("attempts", job.Attempts).Msg("deal job failed")
+	}
+	if err := q.put(job); err != nil {
+		log.Error().Err(err).Str("job", job.ID).Msg("failed to update deal job")
+	}
+}
+
+// propose returns the proposal accepted by the miner along with the error if we then failed to fund it
+func (q *DealQueue) propose(ctx context.Context, job DealJob) (cid.Cid, error) {
+	miner, err := q.s.LoadMiner(ctx, job.Miner)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to load miner: %w", err)
+	}
+	if job.Price != nil {
+		// copy the ask so we only change the price we offer
+		ask := *miner.Ask
+		ask.Price = *job.Price
+		ask.VerifiedPrice = *job.Price
+		miner.Ask = &ask
+	}
+	pieceCid := job.PieceCid
+	receipt, err := q.s.Store(ctx, Params{
+		Payload: &storagemarket.DataRef{
+			TransferType: storagemarket.TTGraphsync,
+			Root:         job.Root,
+			PieceCid:     &pieceCid,
+			PieceSize:    job.PieceSize,
+		},
+		Duration:   job.Duration,
+		Address:    job.Client,
+		Miners:     []Miner{miner},
+		Verified:   job.Verified,
+		DealParams: job.DealParams,
+	})
+	if receipt == nil || len(receipt.Deals) == 0 {
+		if err != nil {
+			return cid.Undef, err
+		}
+		if len(receipt.Rejected) > 0 {
+			return cid.Undef, fmt.Errorf("%w: %s", ErrDealRejected, receipt.Rejected[0].Message)
+		}
+		return cid.Undef, ErrNoDealAccepted
+	}
+	// Accepted deals are tracked before anything else so a failure to fund them doesn't lose them
+	if q.dt != nil {
+		if terr := q.dt.Track(receipt.Deals...); terr != nil {
+			return cid.Undef, terr
+		}
+	}
+	return receipt.Deals[0].ProposalCid, err
+}
+
+func (q *DealQueue) put(job DealJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.ds.Put(datastore.NewKey(job.ID), data)
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStorer struct {
+	bgen *blocksutil.BlockGenerator
+	fail map[address.Address]int
+	// reject makes the miners reject our proposals and unfunded fails to fund the accepted ones
+	reject   map[address.Address]bool
+	unfunded map[address.Address]bool
+	stored   []Params
+	// miners are returned by LoadMiners if our policies accept them
+	miners []address.Address
+}
+
+func (f *fakeStorer) LoadMiners(ctx context.Context, msp MinerSelectionParams) ([]Miner, error) {
+	var sel []Miner
+	for _, addr := range f.miners {
+		m, _ := f.LoadMiner(ctx, addr)
+		if acceptMiner(ctx, m, sel, msp.Policies) {
+			sel = append(sel, m)
+		}
+		if len(sel) == msp.RF {
+			break
+		}
+	}
+	return sel, nil
+}
+
+func (f *fakeStorer) LoadMiner(ctx context.Context, addr address.Address) (Miner, error) {
+	return Miner{
+		Ask:  &storagemarket.StorageAsk{Miner: addr, Price: abi.NewTokenAmount(100)},
+		Info: &storagemarket.StorageProviderInfo{Address: addr},
+	}, nil
+}
+
+func (f *fakeStorer) Store(ctx context.Context, p Params) (*Receipt, error) {
+	m := p.Miners[0].Info.Address
+	if f.fail[m] > 0 {
+		f.fail[m]--
+		return nil, errors.New("gas spike")
+	}
+	f.stored = append(f.stored, p)
+	if f.reject[m] {
+		return &Receipt{
+			Rejected: []ProposalRecord{{Miner: m, Outcome: ProposalRejected, Message: "price too low"}},
+		}, nil
+	}
+	price := dealEpochPrice(p.Miners[0].Ask.Price, uint64(p.Payload.PieceSize.Padded()))
+	receipt := &Receipt{
+		Deals: []DealRecord{{ProposalCid: f.bgen.Next().Cid(), Miner: m}},
+		Total: big.Mul(price, big.NewInt(int64(calcEpochs(p.Duration)))),
+	}
+	if f.unfunded[m] {
+		return receipt, errors.New("failed to add funds: gas spike")
+	}
+	return receipt, nil
+}
+
+func TestDealQueue(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	m1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	fs := &fakeStorer{bgen: &bgen, fail: map[address.Address]int{m2: 1}}
+	q := &DealQueue{
+		s:  fs,
+		ds: dssync.MutexWrap(datastore.NewMapDatastore()),
+		opts: QueueOptions{
+			MinerInterval: time.Hour,
+			BackoffMin:    time.Hour,
+			BackoffMax:    time.Hour,
+			MaxAttempts:   2,
+		},
+		kick:      make(chan struct{}, 1),
+		lastMiner: make(map[address.Address]time.Time),
+	}
+
+	root := bgen.Next().Cid()
+	price := abi.NewTokenAmount(42)
+	_, err = q.Enqueue(DealJob{Root: root, Miner: m1, PieceCid: root, Price: &price})
+	require.NoError(t, err)
+	_, err = q.Enqueue(DealJob{Root: root, Miner: m1, PieceCid: root})
+	require.NoError(t, err)
+	_, err = q.Enqueue(DealJob{Root: root, Miner: m2, PieceCid: root})
+	require.NoError(t, err)
+
+	// The second job for m1 waits for the miner to cool down
+	require.True(t, q.Next(ctx))
+	require.True(t, q.Next(ctx))
+	require.False(t, q.Next(ctx))
+
+	require.Len(t, fs.stored, 1)
+	require.True(t, fs.stored[0].Miners[0].Ask.Price.Equals(price))
+
+	jobs, err := q.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	require.Equal(t, JobDone, jobs[0].Status)
+	require.True(t, jobs[0].Proposal.Defined())
+	require.Equal(t, JobQueued, jobs[1].Status)
+	require.Equal(t, JobRetrying, jobs[2].Status)
+	require.Equal(t, "gas spike", jobs[2].Err)
+	require.True(t, jobs[2].NextAttempt.After(time.Now()))
+
+	// Once the miners cooled down and the backoff expired the jobs run again
+	q.lastMiner = make(map[address.Address]time.Time)
+	jobs[2].NextAttempt = time.Now()
+	require.NoError(t, q.put(jobs[2]))
+
+	require.True(t, q.Next(ctx))
+	require.True(t, q.Next(ctx))
+
+	jobs, err = q.List()
+	require.NoError(t, err)
+	for _, j := range jobs {
+		require.Equal(t, JobDone, j.Status)
+	}
+	require.Equal(t, 2, jobs[2].Attempts)
+	require.Len(t, fs.stored, 3)
+}
+
+func TestDealQueueRejected(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	m1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	fs := &fakeStorer{
+		bgen:     &bgen,
+		reject:   map[address.Address]bool{m1: true},
+		unfunded: map[address.Address]bool{m2: true},
+	}
+	dt := &DealTracker{
+		ds:          dssync.MutexWrap(datastore.NewMapDatastore()),
+		subscribers: pubsub.New(dealDispatcher),
+	}
+	q := &DealQueue{
+		s:         fs,
+		dt:        dt,
+		ds:        dssync.MutexWrap(datastore.NewMapDatastore()),
+		opts:      QueueOptions{MaxAttempts: 8},
+		kick:      make(chan struct{}, 1),
+		lastMiner: make(map[address.Address]time.Time),
+	}
+
+	root := bgen.Next().Cid()
+	_, err = q.Enqueue(DealJob{Root: root, Miner: m1, PieceCid: root})
+	require.NoError(t, err)
+	_, err = q.Enqueue(DealJob{Root: root, Miner: m2, PieceCid: root})
+	require.NoError(t, err)
+
+	require.True(t, q.Next(ctx))
+	require.True(t, q.Next(ctx))
+	require.False(t, q.Next(ctx))
+
+	jobs, err := q.List()
+	require.NoError(t, err)
+	// Rejected proposals are not retried as is
+	require.Equal(t, JobFailed, jobs[0].Status)
+	require.Contains(t, jobs[0].Err, "price too low")
+	// Accepted proposals are tracked even if we failed to fund them so they aren't proposed again
+	require.Equal(t, JobDone, jobs[1].Status)
+	require.NotEqual(t, "", jobs[1].Err)
+	rec, err := dt.Get(jobs[1].Proposal)
+	require.NoError(t, err)
+	require.Equal(t, m2, rec.Miner)
+	require.Len(t, fs.stored, 2)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/market"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	fil "github.com/myelnet/pop/filecoin"
+)
+
+// ErrRepairBudget is returned when a replacement deal would cost more than what is left of the repair budget
+var ErrRepairBudget = errors.New("repair budget exceeded")
+
+// ErrOfflineRepair is returned when repairing an offline deal as its data must be delivered to a new miner manually
+var ErrOfflineRepair = errors.New("offline deals cannot be repaired automatically")
+
+// ErrDealExpired is returned when repairing a deal which would have ended by now anyway
+var ErrDealExpired = errors.New("deal already expired")
+
+// NeedsRepair returns whether a deal was slashed or terminated after it was published and wasn't replaced yet
+func NeedsRepair(rec DealRecord) bool {
+	return rec.Status == DealError && rec.DealID != 0 && !rec.ReplacedBy.Defined()
+}
+
+// replacementStorer selects the replacement miners and proposes them the deals
+type replacementStorer interface {
+	LoadMiners(ctx context.Context, msp MinerSelectionParams) ([]Miner, error)
+	Store(ctx context.Context, p Params) (*Receipt, error)
+}
+
+// Repairer restores the replication factor of our content by making replacement deals with different miners
+// when deals are lost. It never spends more than its budget over the lifetime of the repo.
+type Repairer struct {
+	s      replacementStorer
+	api    fil.API
+	dt     *DealTracker
+	ds     datastore.Batching
+	budget abi.TokenAmount
+	// msp selects the replacement miners, the replication factor is ignored
+	msp MinerSelectionParams
+
+	mu sync.Mutex
+}
+
+// NewRepairer creates a new Repairer spending at most budget on replacement deals
+func NewRepairer(s *Storage, dt *DealTracker, ds datastore.Batching, budget abi.TokenAmount, msp MinerSelectionParams) *Repairer {
+	return &Repairer{
+		s:      s,
+		api:    s.fAPI,
+		dt:     dt,
+		ds:     namespace.Wrap(ds, datastore.NewKey("/repair")),
+		budget: budget,
+		msp:    msp,
+	}
+}
+
+var spentKey = datastore.NewKey("/spent")
+
+// Spent returns the funds committed to replacement deals so far
+func (r *Repairer) Spent() (abi.TokenAmount, error) {
+	data, err := r.ds.Get(spentKey)
+	if err == datastore.ErrNotFound {
+		return big.Zero(), nil
+	}
+	if err != nil {
+		return big.Zero(), err
+	}
+	var spent abi.TokenAmount
+	err = json.Unmarshal(data, &spent)
+	return spent, err
+}
+
+func (r *Repairer) setSpent(spent abi.TokenAmount) error {
+	data, err := json.Marshal(spent)
+	if err != nil {
+		return err
+	}
+	return r.ds.Put(spentKey, data)
+}
+
+// Repair makes a replacement deal for the lost one with a miner which doesn't store the content yet.
+// The replacement ends at the same epoch as the lost deal unless it would be shorter than the minimum
+// duration accepted by the market.
+func (r *Repairer) Repair(ctx context.Context, rec DealRecord) (*DealRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rec.Offline {
+		return nil, ErrOfflineRepair
+	}
+	head, err := r.api.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	duration := rec.EndEpoch - head.Height()
+	if duration <= 0 {
+		return nil, ErrDealExpired
+	}
+	if min, _ := market.DealDurationBounds(rec.PieceSize); duration < min {
+		duration = min
+	}
+
+	// Miners still storing a replica or which lost one cannot be selected
+	list, err := r.dt.List()
+	if err != nil {
+		return nil, err
+	}
+	exclude := []address.Address{rec.Miner}
+	for _, d := range list {
+		if d.Root == rec.Root && d.Status != DealError {
+			exclude = append(exclude, d.Miner)
+		}
+	}
+	msp := r.msp
+	msp.RF = 1
+	msp.Verified = rec.Verified
+	msp.Policies = append([]MinerPolicy{ExcludeMiners(exclude...)}, r.msp.Policies...)
+	miners, err := r.s.LoadMiners(ctx, msp)
+	if err != nil {
+		return nil, err
+	}
+	if len(miners) == 0 {
+		return nil, errors.New("no replacement miner available")
+	}
+
+	// Estimate what the proposal will cost for the size of the piece before committing any funds
+	price := miners[0].Ask.Price
+	if rec.Verified {
+		price = miners[0].Ask.VerifiedPrice
+	}
+	prop := market.DealProposal{
+		PieceSize:            rec.PieceSize,
+		StartEpoch:           head.Height(),
+		EndEpoch:             head.Height() + duration,
+		StoragePricePerEpoch: dealEpochPrice(price, uint64(rec.PieceSize)),
+		ClientCollateral:     big.Zero(),
+	}
+	cost := prop.ClientBalanceRequirement()
+	spent, err := r.Spent()
+	if err != nil {
+		return nil, err
+	}
+	if big.Add(spent, cost).GreaterThan(r.budget) {
+		return nil, fmt.Errorf("%w: replacement costs %s, %s left", ErrRepairBudget, cost, big.Sub(r.budget, spent))
+	}
+
+	params := NewParams(rec.Root, time.Duration(duration)*builtin.EpochDurationSeconds*time.Second, rec.Client, miners, rec.Verified)
+	params.Payload.PieceCid = &rec.PieceCid
+	params.Payload.PieceSize = rec.PieceSize.Unpadded()
+	receipt, err := r.s.Store(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(receipt.Deals) == 0 {
+		return nil, fmt.Errorf("replacement deal rejected by %s", miners[0].Info.Address)
+	}
+	// The accepted proposal is what we actually pay
+	if receipt.Total.Int != nil {
+		cost = receipt.Total
+	}
+	if err := r.setSpent(big.Add(spent, cost)); err != nil {
+		return nil, err
+	}
+	repl := receipt.Deals[0]
+	if err := r.dt.Track(repl); err != nil {
+		return nil, err
+	}
+	rec.ReplacedBy = repl.ProposalCid
+	rec.Updated = time.Now()
+	if err := r.dt.put(rec); err != nil {
+		return nil, err
+	}
+	return &repl, nil
+}
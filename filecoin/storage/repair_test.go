@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/market"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealRepair(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	rec := DealRecord{
+		ProposalCid: bgen.Next().Cid(),
+		Root:        bgen.Next().Cid(),
+		Miner:       miner,
+		Status:      DealActive,
+		DealID:      12,
+	}
+	require.False(t, NeedsRepair(rec))
+	rec.Status = DealError
+	require.True(t, NeedsRepair(rec))
+	// Proposals which were never published are not repaired
+	unpublished := rec
+	unpublished.DealID = 0
+	require.False(t, NeedsRepair(unpublished))
+	replaced := rec
+	replaced.ReplacedBy = bgen.Next().Cid()
+	require.False(t, NeedsRepair(replaced))
+
+	api := fil.NewMockLotusAPI()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	s := &Storage{fAPI: api}
+	r := NewRepairer(s, NewDealTracker(s, ds), ds, abi.NewTokenAmount(1000), MinerSelectionParams{})
+
+	spent, err := r.Spent()
+	require.NoError(t, err)
+	require.True(t, spent.IsZero())
+	require.NoError(t, r.setSpent(abi.NewTokenAmount(400)))
+	spent, err = r.Spent()
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(400), spent)
+
+	offline := rec
+	offline.Offline = true
+	_, err = r.Repair(ctx, offline)
+	require.True(t, errors.Is(err, ErrOfflineRepair))
+
+	// The mock chain head is way past the end of the deal
+	rec.EndEpoch = 100
+	_, err = r.Repair(ctx, rec)
+	require.True(t, errors.Is(err, ErrDealExpired))
+}
+
+func TestDealRepairReplace(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	m1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	m3, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	api := fil.NewMockLotusAPI()
+	head, err := api.ChainHead(ctx)
+	require.NoError(t, err)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	dt := NewDealTracker(&Storage{fAPI: api}, ds)
+	fs := &fakeStorer{bgen: &bgen, miners: []address.Address{m1, m2, m3}}
+
+	// The lost deal only had a few epochs left
+	root := bgen.Next().Cid()
+	lost := DealRecord{
+		ProposalCid: bgen.Next().Cid(),
+		Root:        root,
+		Miner:       m1,
+		DealID:      12,
+		Status:      DealError,
+		EndEpoch:    head.Height() + 100,
+		PieceCid:    bgen.Next().Cid(),
+		PieceSize:   abi.PaddedPieceSize(4 << 30),
+	}
+	require.NoError(t, dt.Track(lost, DealRecord{
+		ProposalCid: bgen.Next().Cid(),
+		Root:        root,
+		Miner:       m2,
+		DealID:      13,
+		Status:      DealActive,
+	}))
+
+	// The miners ask 100 per GiB so the 4GiB piece costs 400 per epoch for at least the minimum duration
+	min, _ := market.DealDurationBounds(lost.PieceSize)
+	cost := big.Mul(big.NewInt(400), big.NewInt(int64(min)))
+
+	r := &Repairer{s: fs, api: api, dt: dt, ds: ds, budget: big.Add(cost, big.NewInt(1))}
+	repl, err := r.Repair(ctx, lost)
+	require.NoError(t, err)
+	// The miners storing or which lost a replica are not selected
+	require.Equal(t, m3, repl.Miner)
+	require.Len(t, fs.stored, 1)
+	require.Equal(t, min, calcEpochs(fs.stored[0].Duration))
+	require.Equal(t, lost.PieceSize, fs.stored[0].Payload.PieceSize.Padded())
+
+	spent, err := r.Spent()
+	require.NoError(t, err)
+	require.Equal(t, cost, spent)
+
+	rec, err := dt.Get(lost.ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, repl.ProposalCid, rec.ReplacedBy)
+	require.False(t, NeedsRepair(rec))
+
+	// What is left of the budget cannot pay for another replacement
+	other := lost
+	other.ProposalCid = bgen.Next().Cid()
+	other.Root = bgen.Next().Cid()
+	_, err = r.Repair(ctx, other)
+	require.ErrorIs(t, err, ErrRepairBudget)
+	require.Len(t, fs.stored, 1)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
@@ -32,8 +33,6 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const dealStartBufferHours uint64 = 49
-
 // BlockDelaySecs is the time elapsed between each block
 const BlockDelaySecs = uint64(builtin.EpochDurationSeconds)
 
@@ -44,8 +43,9 @@ type StoreIDGetter interface {
 
 // MinerDetails represents a miner from the json encoded result
 type MinerDetails struct {
-	Address string `json:"address"`
-	Region  string `json:"region"`
+	Address string      `json:"address"`
+	Region  string      `json:"region"`
+	Score   json.Number `json:"score"`
 }
 
 // MinerPagination is data about Filrep API pagination
@@ -101,6 +101,8 @@ type Storage struct {
 	adapter *Adapter
 	fAPI    fil.API
 	mf      MinerFinder
+	// proposals records every proposal we send if set
+	proposals *ProposalLog
 }
 
 // New creates a new storage client instance
@@ -128,6 +130,20 @@ func New(
 	}, nil
 }
 
+// SetProposalLog records every deal proposal sent by Store in the given log
+func (s *Storage) SetProposalLog(pl *ProposalLog) {
+	s.proposals = pl
+}
+
+func (s *Storage) logProposal(rec ProposalRecord) {
+	if s.proposals == nil {
+		return
+	}
+	if err := s.proposals.Record(rec); err != nil {
+		log.Error().Err(err).Msg("failed to record deal proposal")
+	}
+}
+
 // PeerInfo resolves a Filecoin address to find the peer info and add to our address book
 func (s *Storage) PeerInfo(ctx context.Context, addr address.Address) (*peer.AddrInfo, error) {
 	miner, err := s.fAPI.StateMinerInfo(ctx, addr, filecoin.EmptyTSK)
@@ -160,6 +176,12 @@ type Miner struct {
 	Ask                 *storagemarket.StorageAsk
 	Info                *storagemarket.StorageProviderInfo
 	WindowPoStProofType abi.RegisteredPoStProof
+	Owner               address.Address
+	Region              string
+	// Score is the reputation of the miner between 0 and 100
+	Score float64
+	// Latency is the time the miner took to answer our ask request
+	Latency time.Duration
 }
 
 // MinerSelectionParams defines the criterias for selecting a list of miners
@@ -167,6 +189,10 @@ type MinerSelectionParams struct {
 	MaxPrice uint64
 	RF       int
 	Region   string
+	// Verified compares the max price with the verified price of the miners
+	Verified bool
+	// Policies must all accept a miner for it to be selected
+	Policies []MinerPolicy
 }
 
 // GetAsk requests and verifies a signed ask from a given miner
@@ -204,10 +230,50 @@ func (s *Storage) GetAsk(ctx context.Context, info storagemarket.StorageProvider
 	return out.Ask.Ask, nil
 }
 
+// LoadMiner gets the info and the current ask of a given miner
+func (s *Storage) LoadMiner(ctx context.Context, addr address.Address) (Miner, error) {
+	mi, err := s.fAPI.StateMinerInfo(ctx, addr, fil.EmptyTSK)
+	if err != nil {
+		return Miner{}, err
+	}
+	// PeerId is often nil which causes panics down the road
+	if mi.PeerId == nil {
+		return Miner{}, fmt.Errorf("no peer id available")
+	}
+	info := NewStorageProviderInfo(addr, mi.Worker, mi.SectorSize, *mi.PeerId, mi.Multiaddrs)
+
+	ai := peer.AddrInfo{
+		ID:    info.PeerID,
+		Addrs: info.Addrs,
+	}
+	// We need to connect directly with the peer to ping them
+	err = s.host.Connect(ctx, ai)
+	if err != nil {
+		return Miner{}, err
+	}
+
+	start := time.Now()
+	ask, err := s.GetAsk(ctx, info)
+	if err != nil {
+		return Miner{}, err
+	}
+
+	return Miner{
+		Ask:                 ask,
+		Info:                &info,
+		WindowPoStProofType: mi.WindowPoStProofType,
+		Owner:               mi.Owner,
+		Latency:             time.Since(start),
+	}, nil
+}
+
 // LoadMiners selects a set of miners to queue storage deals with
 func (s *Storage) LoadMiners(ctx context.Context, msp MinerSelectionParams) ([]Miner, error) {
 	var sel []Miner
 
+	// Any miner requesting more than our price ceiling is ignored
+	policies := append([]MinerPolicy{MaxPrice(fil.NewInt(msp.MaxPrice), msp.Verified)}, msp.Policies...)
+
 	limit := msp.RF
 	offset := 0
 
@@ -228,41 +294,19 @@ func (s *Storage) LoadMiners(ctx context.Context, msp MinerSelectionParams) ([]M
 				continue
 			}
 
-			mi, err := s.fAPI.StateMinerInfo(ctx, a, fil.EmptyTSK)
+			miner, err := s.LoadMiner(ctx, a)
 			if err != nil {
 				continue
 			}
-			// PeerId is often nil which causes panics down the road
-			if mi.PeerId == nil {
-				continue
-			}
-			info := NewStorageProviderInfo(a, mi.Worker, mi.SectorSize, *mi.PeerId, mi.Multiaddrs)
-
-			ai := peer.AddrInfo{
-				ID:    info.PeerID,
-				Addrs: info.Addrs,
+			miner.Region = m.Region
+			if score, err := m.Score.Float64(); err == nil {
+				miner.Score = score
 			}
-			// We need to connect directly with the peer to ping them
-			err = s.host.Connect(ctx, ai)
-			if err != nil {
+			if !acceptMiner(ctx, miner, sel, policies) {
 				continue
 			}
 
-			ask, err := s.GetAsk(ctx, info)
-			if err != nil {
-				continue
-			}
-
-			// Any miner requesting more than our price ceiling is ignored
-			if fil.NewInt(msp.MaxPrice).LessThan(ask.Price) {
-				continue
-			}
-
-			sel = append(sel, Miner{
-				Ask:                 ask,
-				Info:                &info,
-				WindowPoStProofType: mi.WindowPoStProofType,
-			})
+			sel = append(sel, miner)
 			if len(sel) == msp.RF {
 				return sel, nil
 			}
@@ -284,9 +328,8 @@ type StartDealParams struct {
 	Miner             Miner
 	EpochPrice        fil.BigInt
 	MinBlocksDuration uint64
-	DealStartEpoch    abi.ChainEpoch
-	FastRetrieval     bool
 	VerifiedDeal      bool
+	DealParams
 }
 
 // ProposeDeal starts a new storage deal with a Filecoin storage miner
@@ -296,18 +339,16 @@ func (s *Storage) ProposeDeal(ctx context.Context, params StartDealParams) (*mar
 		return nil, nil, fmt.Errorf("failed getting miner's deadline info: %w", err)
 	}
 
-	dealStart := params.DealStartEpoch
+	dealStart := params.StartEpoch
 	if dealStart <= 0 { // unset, or explicitly 'epoch undefine'
 		ts, err := s.fAPI.ChainHead(ctx)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed getting chain height: %w", err)
 		}
-
-		blocksPerHour := 60 * 60 / BlockDelaySecs
-		dealStart = ts.Height() + abi.ChainEpoch(dealStartBufferHours*blocksPerHour) // TODO: Get this from storage ask
+		dealStart = params.startEpoch(ts.Height())
 	}
 
-	pcMin, _, err := s.adapter.DealProviderCollateralBounds(ctx, params.Data.PieceSize.Padded(), params.VerifiedDeal)
+	pcMin, pcMax, err := s.adapter.DealProviderCollateralBounds(ctx, params.Data.PieceSize.Padded(), params.VerifiedDeal)
 	if err != nil {
 		return nil, nil, fmt.Errorf("computing deal provider collateral bound failed: %w", err)
 	}
@@ -325,7 +366,7 @@ func (s *Storage) ProposeDeal(ctx context.Context, params StartDealParams) (*mar
 		StartEpoch:           dealStart,
 		EndEpoch:             calcDealExpiration(params.MinBlocksDuration, md, dealStart),
 		StoragePricePerEpoch: params.EpochPrice,
-		ProviderCollateral:   pcMin,
+		ProviderCollateral:   params.collateral(pcMin, pcMax),
 		ClientCollateral:     big.Zero(),
 		VerifiedDeal:         params.VerifiedDeal,
 	}
@@ -341,7 +382,7 @@ func (s *Storage) ProposeDeal(ctx context.Context, params StartDealParams) (*mar
 	}
 
 	if err := dealStream.WriteDealProposal(network.Proposal{
-		FastRetrieval: true,
+		FastRetrieval: params.FastRetrieval,
 		DealProposal:  signedProposal,
 		Piece:         params.Data,
 	}); err != nil {
@@ -355,6 +396,54 @@ func (s *Storage) ProposeDeal(ctx context.Context, params StartDealParams) (*mar
 	return &dealProposal, &res, nil
 }
 
+// DealStatus asks a miner for the state of a deal we proposed. The request is signed by the client address
+// so only the client can query it.
+func (s *Storage) DealStatus(ctx context.Context, miner address.Address, client address.Address, proposal cid.Cid) (*storagemarket.ProviderDealState, error) {
+	mi, err := s.fAPI.StateMinerInfo(ctx, miner, fil.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get miner info: %w", err)
+	}
+	pi, err := s.PeerInfo(ctx, miner)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.host.Connect(ctx, *pi); err != nil {
+		return nil, fmt.Errorf("failed to connect with miner: %w", err)
+	}
+
+	buf, err := cborutil.Dump(&proposal)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.adapter.SignBytes(ctx, client, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign status request: %w", err)
+	}
+
+	stream, err := s.net.NewDealStatusStream(ctx, pi.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deal status stream: %w", err)
+	}
+	defer stream.Close()
+
+	if err := stream.WriteDealStatusRequest(network.DealStatusRequest{Proposal: proposal, Signature: *sig}); err != nil {
+		return nil, fmt.Errorf("failed to send deal status request: %w", err)
+	}
+	res, origBytes, err := stream.ReadDealStatusResponse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deal status response: %w", err)
+	}
+
+	valid, err := s.adapter.VerifySignature(ctx, res.Signature, mi.Worker, origBytes, shared.TipSetToken{})
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("miner signature invalid")
+	}
+	return &res.DealState, nil
+}
+
 // QuoteParams is the params to calculate the storage quote with.
 type QuoteParams struct {
 	PieceSize uint64
@@ -363,6 +452,8 @@ type QuoteParams struct {
 	MaxPrice  uint64
 	Region    string
 	Verified  bool
+	// Policies select which miners are quoted in addition to the max price and region
+	Policies []MinerPolicy
 }
 
 // Quote is an estimate of who can store given content and for how much
@@ -379,6 +470,8 @@ func (s *Storage) GetMarketQuote(ctx context.Context, params QuoteParams) (*Quot
 		RF:       params.RF,
 		MaxPrice: params.MaxPrice,
 		Region:   params.Region,
+		Verified: params.Verified,
+		Policies: params.Policies,
 	})
 	if err != nil {
 		return nil, err
@@ -387,8 +480,6 @@ func (s *Storage) GetMarketQuote(ctx context.Context, params QuoteParams) (*Quot
 		return nil, errors.New("no miners fit those parameters")
 	}
 
-	gib := fil.NewInt(1 << 30)
-
 	epochs := calcEpochs(params.Duration)
 
 	prices := make(map[address.Address]fil.FIL)
@@ -403,7 +494,7 @@ func (s *Storage) GetMarketQuote(ctx context.Context, params QuoteParams) (*Quot
 		if uint64(m.Ask.MinPieceSize) > minPieceSize {
 			minPieceSize = uint64(m.Ask.MinPieceSize)
 		}
-		epochPrice := fil.BigDiv(fil.BigMul(p, fil.NewInt(params.PieceSize)), gib)
+		epochPrice := dealEpochPrice(p, params.PieceSize)
 		prices[m.Info.Address] = fil.FIL(fil.BigMul(epochPrice, fil.NewInt(uint64(epochs))))
 	}
 
@@ -420,7 +511,9 @@ type Params struct {
 	Duration time.Duration
 	Address  address.Address
 	Miners   []Miner
+	// Verified makes verified deals using the datacap of the address
 	Verified bool
+	DealParams
 }
 
 // NewParams creates a new Params struct for storage
@@ -434,6 +527,9 @@ func NewParams(root cid.Cid, dur time.Duration, w address.Address, mnrs []Miner,
 		Address:  w,
 		Miners:   mnrs,
 		Verified: verified,
+		DealParams: DealParams{
+			FastRetrieval: true,
+		},
 	}
 }
 
@@ -441,10 +537,17 @@ func NewParams(root cid.Cid, dur time.Duration, w address.Address, mnrs []Miner,
 type Receipt struct {
 	Miners   []address.Address
 	DealRefs []cid.Cid
+	// Deals are the proposals accepted by the miners which can be passed to a DealTracker
+	Deals []DealRecord
+	// Rejected are the proposals the miners rejected
+	Rejected []ProposalRecord
+	// Total is the balance the client needs in the market to pay for the accepted proposals
+	Total abi.TokenAmount
 }
 
 // Store is the main storage operation which automatically stores content for a given CID
-// with the best conditions available
+// with the best conditions available. If the miners accepted our proposals but we fail to fund them
+// the receipt is returned with the error so the accepted deals can still be tracked.
 func (s *Storage) Store(ctx context.Context, p Params) (*Receipt, error) {
 	var ma []address.Address
 	info := make(map[address.Address]*storagemarket.StorageProviderInfo, len(p.Miners))
@@ -452,27 +555,64 @@ func (s *Storage) Store(ctx context.Context, p Params) (*Receipt, error) {
 		ma = append(ma, m.Info.Address)
 		info[m.Info.Address] = m.Info
 	}
+	// Verified deals use the datacap of the client address for each replica
+	if p.Verified {
+		if err := s.checkDataCap(ctx, p.Address, p.Payload.PieceSize.Padded(), len(p.Miners)); err != nil {
+			return nil, err
+		}
+	}
 	balance, err := s.adapter.GetBalance(ctx, p.Address)
 	if err != nil {
 		return nil, err
 	}
 	epochs := calcEpochs(p.Duration)
+	if err := checkDuration(p.Payload.PieceSize.Padded(), epochs); err != nil {
+		return nil, err
+	}
 	proposals := make(map[peer.ID]*market.DealProposal)
+	receipt := &Receipt{
+		Miners: ma,
+	}
 	total := abi.NewTokenAmount(0)
 	for _, m := range p.Miners {
+		price := m.Ask.Price
+		if p.Verified {
+			price = m.Ask.VerifiedPrice
+		}
 		prop, resp, err := s.ProposeDeal(ctx, StartDealParams{
 			Data:              p.Payload,
 			Wallet:            p.Address,
 			Miner:             m,
-			EpochPrice:        m.Ask.Price,
+			EpochPrice:        dealEpochPrice(price, uint64(p.Payload.PieceSize.Padded())),
 			MinBlocksDuration: uint64(epochs),
-			DealStartEpoch:    -1,
-			FastRetrieval:     false,
 			VerifiedDeal:      p.Verified,
+			DealParams:        p.DealParams,
 		})
+		rec := ProposalRecord{
+			Root:     p.Payload.Root,
+			Miner:    m.Info.Address,
+			Client:   p.Address,
+			Price:    price,
+			Verified: p.Verified,
+			Offline:  p.Payload.TransferType == storagemarket.TTManual,
+			Outcome:  ProposalErrored,
+			Created:  time.Now(),
+		}
+		if p.Payload.PieceCid != nil {
+			rec.PieceCid = *p.Payload.PieceCid
+		}
 		if err != nil {
+			rec.Outcome = ProposalFailed
+			rec.Message = err.Error()
+			s.logProposal(rec)
 			return nil, err
 		}
+		rec.ProposalCid = resp.Response.Proposal
+		rec.Message = resp.Response.Message
+		rec.PieceSize = prop.PieceSize
+		rec.StartEpoch = prop.StartEpoch
+		rec.EndEpoch = prop.EndEpoch
+
 		switch resp.Response.State {
 		case storagemarket.StorageDealError:
 			log.Error().Str("address", m.Info.Address.String()).
@@ -483,28 +623,55 @@ func (s *Storage) Store(ctx context.Context, p Params) (*Receipt, error) {
 			log.Error().Str("address", m.Info.Address.String()).
 				Str("responseMessage", resp.Response.Message).
 				Msg("ProposalRejected")
+			rec.Outcome = ProposalRejected
+			receipt.Rejected = append(receipt.Rejected, rec)
 
 		case storagemarket.StorageDealWaitingForData, storagemarket.StorageDealProposalAccepted:
 			log.Info().Msg("ProposalAccepted")
+			rec.Outcome = ProposalAccepted
 
 			proposals[m.Info.PeerID] = prop
 			total = fil.BigAdd(prop.ClientBalanceRequirement(), total)
+
+			receipt.DealRefs = append(receipt.DealRefs, resp.Response.Proposal)
+			receipt.Deals = append(receipt.Deals, DealRecord{
+				ProposalCid: resp.Response.Proposal,
+				Root:        p.Payload.Root,
+				Miner:       m.Info.Address,
+				Client:      p.Address,
+				Status:      DealProposed,
+				StartEpoch:  prop.StartEpoch,
+				EndEpoch:    prop.EndEpoch,
+				PieceCid:    prop.PieceCID,
+				PieceSize:   prop.PieceSize,
+				Offline:     p.Payload.TransferType == storagemarket.TTManual,
+				Verified:    p.Verified,
+				Created:     time.Now(),
+			})
 		}
+		s.logProposal(rec)
 	}
 
+	receipt.Total = total
+
 	// Not 100% sure about the math here but it seems we have funds available already we should only
 	// need to topup with what we need for this transfer
 	if balance.Available.LessThan(total) {
 		msgcid, err := s.adapter.AddFunds(ctx, p.Address, fil.BigSub(total, balance.Available))
 		if err != nil {
-			return nil, fmt.Errorf("failed to add funds: %w", err)
+			return receipt, fmt.Errorf("failed to add funds: %w", err)
 		}
 		_, err = s.fAPI.StateWaitMsg(ctx, msgcid, uint64(5))
 		if err != nil {
-			return nil, fmt.Errorf("failed to confirm message on chain: %w", err)
+			return receipt, fmt.Errorf("failed to confirm message on chain: %w", err)
 		}
 	}
 
+	// The data of offline deals is delivered out-of-band and imported by the miners
+	if p.Payload.TransferType == storagemarket.TTManual {
+		return receipt, nil
+	}
+
 	for pid, prop := range proposals {
 		nd, err := cborutil.AsIpld(prop)
 		if err != nil {
@@ -522,9 +689,7 @@ func (s *Storage) Store(ctx context.Context, p Params) (*Receipt, error) {
 		// TODO: handle events
 	}
 
-	return &Receipt{
-		Miners: ma,
-	}, nil
+	return receipt, nil
 }
 
 func calcDealExpiration(minDuration uint64, md *dline.Info, startEpoch abi.ChainEpoch) abi.ChainEpoch {
@@ -535,6 +700,11 @@ func calcDealExpiration(minDuration uint64, md *dline.Info, startEpoch abi.Chain
 	return minExp + md.WPoStProvingPeriod - (minExp % md.WPoStProvingPeriod) + (md.PeriodStart % md.WPoStProvingPeriod) - 1
 }
 
+// dealEpochPrice is what a deal storing a piece of the given size pays per epoch for an ask price per GiB
+func dealEpochPrice(askPrice abi.TokenAmount, size uint64) abi.TokenAmount {
+	return fil.BigDiv(fil.BigMul(askPrice, fil.NewInt(size)), fil.NewInt(1<<30))
+}
+
 func calcEpochs(t time.Duration) abi.ChainEpoch {
 	return abi.ChainEpoch(t / (time.Duration(uint64(builtin.EpochDurationSeconds)) * time.Second))
 }
@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultDealPollInterval is how often we check the state of the deals we are tracking
+const DefaultDealPollInterval = 10 * time.Minute
+
+// DealStatus is the stage a storage deal is at
+type DealStatus string
+
+const (
+	// DealProposed was accepted by the miner which is waiting for the data
+	DealProposed DealStatus = "proposed"
+	// DealPublished is published on chain and waiting to be sealed
+	DealPublished DealStatus = "published"
+	// DealSealing is being sealed in a sector
+	DealSealing DealStatus = "sealing"
+	// DealActive is in a proven sector
+	DealActive DealStatus = "active"
+	// DealError failed or was slashed
+	DealError DealStatus = "error"
+)
+
+// Final returns whether the deal won't change status anymore
+func (s DealStatus) Final() bool {
+	return s == DealActive || s == DealError
+}
+
+// DealRecord is a storage deal we proposed and track until it is active
+type DealRecord struct {
+	ProposalCid cid.Cid
+	Root        cid.Cid
+	Miner       address.Address
+	Client      address.Address
+	DealID      abi.DealID
+	Status      DealStatus
+	// Message is the latest message from the miner, explaining the error if any
+	Message    string
+	StartEpoch abi.ChainEpoch
+	EndEpoch   abi.ChainEpoch
+	PieceCid   cid.Cid
+	PieceSize  abi.PaddedPieceSize
+	// Offline deals have their data delivered out-of-band
+	Offline  bool
+	Verified bool
+	// ReplacedBy is the proposal of the deal made to restore the replica if this one was lost
+	ReplacedBy cid.Cid
+	Created    time.Time
+	Updated    time.Time
+}
+
+// DealSubscriber is a callback registered to listen for deal status transitions
+type DealSubscriber func(rec DealRecord)
+
+// Unsubscribe is a function that unsubscribes a subscriber
+type Unsubscribe func()
+
+func dealDispatcher(evt pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	rec, ok := evt.(DealRecord)
+	if !ok {
+		return fmt.Errorf("wrong type of event")
+	}
+	cb, ok := subscriberFn.(DealSubscriber)
+	if !ok {
+		return fmt.Errorf("wrong type of subscriber")
+	}
+	cb(rec)
+	return nil
+}
+
+// dealStatusGetter asks a miner for the state of a deal
+type dealStatusGetter interface {
+	DealStatus(ctx context.Context, miner address.Address, client address.Address, proposal cid.Cid) (*storagemarket.ProviderDealState, error)
+}
+
+// DealTracker persists the deals we proposed and polls the miners and the chain until they are active or failed
+type DealTracker struct {
+	s           dealStatusGetter
+	api         fil.API
+	ds          datastore.Batching
+	aggs        datastore.Batching // aggs maps the payloads packed in an aggregate to the aggregate root
+	subscribers *pubsub.PubSub
+}
+
+// NewDealTracker creates a new tracker persisting deals in the given datastore
+func NewDealTracker(s *Storage, ds datastore.Batching) *DealTracker {
+	return &DealTracker{
+		s:           s,
+		api:         s.fAPI,
+		ds:          namespace.Wrap(ds, datastore.NewKey("/deals")),
+		aggs:        namespace.Wrap(ds, datastore.NewKey("/aggregates")),
+		subscribers: pubsub.New(dealDispatcher),
+	}
+}
+
+// SubscribeToEvents listens to the status transitions of the deals we track
+func (dt *DealTracker) SubscribeToEvents(subscriber DealSubscriber) Unsubscribe {
+	return Unsubscribe(dt.subscribers.Subscribe(subscriber))
+}
+
+// Track the deals of a storage receipt
+func (dt *DealTracker) Track(recs ...DealRecord) error {
+	for _, rec := range recs {
+		if rec.Status == "" {
+			rec.Status = DealProposed
+		}
+		if rec.Created.IsZero() {
+			rec.Created = time.Now()
+		}
+		rec.Updated = rec.Created
+		if err := dt.put(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get a deal we track
+func (dt *DealTracker) Get(proposal cid.Cid) (DealRecord, error) {
+	data, err := dt.ds.Get(datastore.NewKey(proposal.String()))
+	if err != nil {
+		return DealRecord{}, err
+	}
+	var rec DealRecord
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// List all the deals we track, most recent first
+func (dt *DealTracker) List() ([]DealRecord, error) {
+	res, err := dt.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]DealRecord, 0, len(entries))
+	for _, e := range entries {
+		var rec DealRecord
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+// aggregateRecord is the location of a payload in an aggregate
+type aggregateRecord struct {
+	Aggregate cid.Cid
+	Entry     AggregateEntry
+}
+
+// TrackAggregate indexes the payloads of an aggregate so we can find the deals storing them
+func (dt *DealTracker) TrackAggregate(agg *Aggregate) error {
+	for _, e := range agg.Entries {
+		data, err := json.Marshal(aggregateRecord{Aggregate: agg.Root, Entry: e})
+		if err != nil {
+			return err
+		}
+		if err := dt.aggs.Put(datastore.NewKey(e.Root.String()), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AggregateOf returns the root of the aggregate a payload was packed in and where to find it
+func (dt *DealTracker) AggregateOf(root cid.Cid) (cid.Cid, AggregateEntry, error) {
+	data, err := dt.aggs.Get(datastore.NewKey(root.String()))
+	if err != nil {
+		return cid.Undef, AggregateEntry{}, err
+	}
+	var rec aggregateRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return cid.Undef, AggregateEntry{}, err
+	}
+	return rec.Aggregate, rec.Entry, nil
+}
+
+// Retrievable returns the active deals storing the given root which miners can serve retrievals for.
+// It includes the deals for the aggregate the root was packed in if any.
+func (dt *DealTracker) Retrievable(root cid.Cid) ([]DealRecord, error) {
+	list, err := dt.List()
+	if err != nil {
+		return nil, err
+	}
+	agg, _, err := dt.AggregateOf(root)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	var recs []DealRecord
+	for _, rec := range list {
+		if (rec.Root == root || (agg.Defined() && rec.Root == agg)) && rec.Status == DealActive {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, nil
+}
+
+// Run polls the state of the deals at the given interval until the context is cancelled
+func (dt *DealTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			dt.Poll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Poll updates the state of all the deals which aren't active or failed yet. Active deals are still
+// checked on chain until they end as they can be slashed at any time.
+func (dt *DealTracker) Poll(ctx context.Context) {
+	list, err := dt.List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list deals")
+		return
+	}
+	var height abi.ChainEpoch
+	if head, err := dt.api.ChainHead(ctx); err == nil {
+		height = head.Height()
+	} else {
+		log.Debug().Err(err).Msg("failed to get chain head")
+	}
+	for _, rec := range list {
+		var err error
+		switch {
+		case rec.Status == DealActive:
+			if height == 0 || rec.EndEpoch <= height {
+				continue
+			}
+			err = dt.checkActive(ctx, rec)
+		case rec.Status.Final():
+			continue
+		default:
+			err = dt.update(ctx, rec)
+			if height > 0 && rec.StartEpoch > 0 && rec.StartEpoch < height {
+				err = dt.expire(rec.ProposalCid)
+			}
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("proposal", rec.ProposalCid.String()).Msg("failed to update deal")
+		}
+	}
+}
+
+// expire gives up on a deal the miner still hasn't published after its start epoch so we don't poll it
+// forever. The deal cannot be published anymore once it should have started.
+func (dt *DealTracker) expire(proposal cid.Cid) error {
+	rec, err := dt.Get(proposal)
+	if err != nil {
+		return err
+	}
+	if rec.DealID != 0 || rec.Status.Final() {
+		return nil
+	}
+	rec.Status = DealError
+	rec.Message = fmt.Sprintf("not published before its start epoch %d", rec.StartEpoch)
+	rec.Updated = time.Now()
+	if err := dt.put(rec); err != nil {
+		return err
+	}
+	_ = dt.subscribers.Publish(rec)
+	return nil
+}
+
+// checkActive looks up an active deal on chain and marks it as failed if it was slashed
+func (dt *DealTracker) checkActive(ctx context.Context, rec DealRecord) error {
+	md, err := dt.api.StateMarketStorageDeal(ctx, rec.DealID, fil.EmptyTSK)
+	if err != nil {
+		return err
+	}
+	if md.State.SlashEpoch <= 0 {
+		return nil
+	}
+	rec.Status = DealError
+	rec.Message = fmt.Sprintf("slashed at epoch %d", md.State.SlashEpoch)
+	rec.Updated = time.Now()
+	if err := dt.put(rec); err != nil {
+		return err
+	}
+	_ = dt.subscribers.Publish(rec)
+	return nil
+}
+
+// update asks the miner for the state of the deal until it is published then checks it on chain
+func (dt *DealTracker) update(ctx context.Context, rec DealRecord) error {
+	next := rec
+
+	st, err := dt.s.DealStatus(ctx, rec.Miner, rec.Client, rec.ProposalCid)
+	if err != nil && rec.DealID == 0 {
+		// We cannot know more until the miner answers
+		return err
+	}
+	if st != nil {
+		next.Message = st.Message
+		if st.DealID != 0 {
+			next.DealID = st.DealID
+		}
+		switch st.State {
+		case storagemarket.StorageDealError,
+			storagemarket.StorageDealFailing,
+			storagemarket.StorageDealProposalRejected,
+			storagemarket.StorageDealRejecting,
+			storagemarket.StorageDealProposalNotFound,
+			storagemarket.StorageDealSlashed,
+			storagemarket.StorageDealExpired:
+			next.Status = DealError
+		case storagemarket.StorageDealStaged,
+			storagemarket.StorageDealAwaitingPreCommit,
+			storagemarket.StorageDealSealing,
+			storagemarket.StorageDealFinalizing:
+			next.Status = DealSealing
+		case storagemarket.StorageDealActive:
+			next.Status = DealActive
+		default:
+			if next.DealID != 0 {
+				next.Status = DealPublished
+			}
+		}
+	}
+
+	// The chain is the source of truth once the deal is published
+	if next.DealID != 0 && next.Status != DealError {
+		md, err := dt.api.StateMarketStorageDeal(ctx, next.DealID, fil.EmptyTSK)
+		if err == nil {
+			switch {
+			case md.State.SlashEpoch > 0:
+				next.Status = DealError
+				next.Message = fmt.Sprintf("slashed at epoch %d", md.State.SlashEpoch)
+			case md.State.SectorStartEpoch > 0:
+				next.Status = DealActive
+			case next.Status == DealProposed:
+				next.Status = DealPublished
+			}
+			next.StartEpoch = md.Proposal.StartEpoch
+			next.EndEpoch = md.Proposal.EndEpoch
+		}
+	}
+
+	if next.Status == rec.Status && next.Message == rec.Message && next.DealID == rec.DealID {
+		return nil
+	}
+	next.Updated = time.Now()
+	if err := dt.put(next); err != nil {
+		return err
+	}
+	if next.Status != rec.Status {
+		_ = dt.subscribers.Publish(next)
+	}
+	return nil
+}
+
+func (dt *DealTracker) put(rec DealRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return dt.ds.Put(datastore.NewKey(rec.ProposalCid.String()), data)
+}
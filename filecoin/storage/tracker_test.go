@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDealStatus struct {
+	states map[cid.Cid]*storagemarket.ProviderDealState
+}
+
+func (f *fakeDealStatus) DealStatus(ctx context.Context, miner address.Address, client address.Address, proposal cid.Cid) (*storagemarket.ProviderDealState, error) {
+	st, ok := f.states[proposal]
+	if !ok {
+		return nil, errors.New("miner unreachable")
+	}
+	return st, nil
+}
+
+func TestDealTracker(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+
+	api := fil.NewMockLotusAPI()
+	fds := &fakeDealStatus{states: make(map[cid.Cid]*storagemarket.ProviderDealState)}
+	dt := &DealTracker{
+		s:           fds,
+		api:         api,
+		ds:          dssync.MutexWrap(datastore.NewMapDatastore()),
+		subscribers: pubsub.New(dealDispatcher),
+	}
+
+	var events []DealRecord
+	dt.SubscribeToEvents(func(rec DealRecord) {
+		events = append(events, rec)
+	})
+
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	good := bgen.Next().Cid()
+	bad := bgen.Next().Cid()
+	require.NoError(t, dt.Track(
+		DealRecord{ProposalCid: good, Root: bgen.Next().Cid(), Miner: miner, Client: client},
+		DealRecord{ProposalCid: bad, Root: bgen.Next().Cid(), Miner: miner, Client: client},
+	))
+
+	list, err := dt.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	// The miner doesn't answer yet so nothing changes
+	dt.Poll(ctx)
+	require.Len(t, events, 0)
+
+	fds.states[good] = &storagemarket.ProviderDealState{State: storagemarket.StorageDealPublishing}
+	fds.states[bad] = &storagemarket.ProviderDealState{State: storagemarket.StorageDealFailing, Message: "not enough funds"}
+	dt.Poll(ctx)
+	require.Len(t, events, 1)
+	require.Equal(t, DealError, events[0].Status)
+	require.Equal(t, "not enough funds", events[0].Message)
+
+	// Once published the chain tells us when the deal is active
+	fds.states[good] = &storagemarket.ProviderDealState{State: storagemarket.StorageDealAwaitingPreCommit, DealID: 12}
+	api.SetMarketDeal(12, &fil.MarketDeal{})
+	dt.Poll(ctx)
+	require.Len(t, events, 2)
+	require.Equal(t, DealSealing, events[1].Status)
+	require.Equal(t, abi.DealID(12), events[1].DealID)
+
+	api.SetMarketDeal(12, &fil.MarketDeal{State: fil.DealState{SectorStartEpoch: 100}})
+	dt.Poll(ctx)
+	require.Len(t, events, 3)
+	require.Equal(t, DealActive, events[2].Status)
+
+	// Final deals are not polled anymore
+	dt.Poll(ctx)
+	require.Len(t, events, 3)
+
+	// Active deals are checked on chain until they end
+	head, err := api.ChainHead(ctx)
+	require.NoError(t, err)
+	slashed := bgen.Next().Cid()
+	require.NoError(t, dt.Track(DealRecord{
+		ProposalCid: slashed,
+		Root:        bgen.Next().Cid(),
+		Miner:       miner,
+		Client:      client,
+		DealID:      13,
+		Status:      DealActive,
+		EndEpoch:    head.Height() + 1000,
+	}))
+	api.SetMarketDeal(13, &fil.MarketDeal{State: fil.DealState{SectorStartEpoch: 100}})
+	dt.Poll(ctx)
+	require.Len(t, events, 3)
+
+	api.SetMarketDeal(13, &fil.MarketDeal{State: fil.DealState{SectorStartEpoch: 100, SlashEpoch: head.Height()}})
+	dt.Poll(ctx)
+	require.Len(t, events, 4)
+	require.Equal(t, DealError, events[3].Status)
+	require.True(t, NeedsRepair(events[3]))
+
+	rec, err := dt.Get(good)
+	require.NoError(t, err)
+	require.Equal(t, DealActive, rec.Status)
+	rec, err = dt.Get(bad)
+	require.NoError(t, err)
+	require.Equal(t, DealError, rec.Status)
+
+	// Proposals never published by their start epoch are given up
+	lost := bgen.Next().Cid()
+	require.NoError(t, dt.Track(DealRecord{
+		ProposalCid: lost,
+		Root:        bgen.Next().Cid(),
+		Miner:       miner,
+		Client:      client,
+		Status:      DealProposed,
+		StartEpoch:  head.Height() + 10,
+	}))
+	dt.Poll(ctx)
+	require.Len(t, events, 4)
+
+	rec, err = dt.Get(lost)
+	require.NoError(t, err)
+	rec.StartEpoch = head.Height() - 1
+	require.NoError(t, dt.put(rec))
+	dt.Poll(ctx)
+	require.Len(t, events, 5)
+	require.Equal(t, DealError, events[4].Status)
+
+	// They are not polled anymore
+	dt.Poll(ctx)
+	require.Len(t, events, 5)
+}
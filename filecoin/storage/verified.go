@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	fil "github.com/myelnet/pop/filecoin"
+)
+
+// ErrNotVerifiedClient is returned when making verified deals with an address without datacap
+var ErrNotVerifiedClient = errors.New("address is not a verified client")
+
+// ErrInsufficientDataCap is returned when the datacap left cannot cover all the verified deals
+var ErrInsufficientDataCap = errors.New("insufficient datacap")
+
+// DataCap returns the datacap left for a verified client address. Each verified deal uses as much datacap
+// as the padded size of its piece. ErrNotVerifiedClient is returned if the address was never granted datacap.
+func (s *Storage) DataCap(ctx context.Context, addr address.Address) (abi.StoragePower, error) {
+	dc, err := s.fAPI.StateVerifiedClientStatus(ctx, addr, fil.EmptyTSK)
+	if err != nil {
+		return big.Zero(), err
+	}
+	if dc == nil {
+		return big.Zero(), ErrNotVerifiedClient
+	}
+	return *dc, nil
+}
+
+// checkDataCap makes sure the address has enough datacap to make a verified deal for the piece with each miner
+func (s *Storage) checkDataCap(ctx context.Context, addr address.Address, size abi.PaddedPieceSize, deals int) error {
+	dc, err := s.DataCap(ctx, addr)
+	if err != nil {
+		return err
+	}
+	need := big.Mul(big.NewIntUnsigned(uint64(size)), big.NewInt(int64(deals)))
+	if dc.LessThan(need) {
+		return fmt.Errorf("%w: %s left, %s needed", ErrInsufficientDataCap, fil.SizeStr(dc), fil.SizeStr(need))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataCap(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	s := &Storage{fAPI: api}
+
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	_, err = s.DataCap(ctx, client)
+	require.True(t, errors.Is(err, ErrNotVerifiedClient))
+
+	api.SetDataCap(client, abi.NewStoragePower(1<<30))
+	dc, err := s.DataCap(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, abi.NewStoragePower(1<<30), dc)
+
+	// 2 replicas of 512MiB use all the datacap
+	require.NoError(t, s.checkDataCap(ctx, client, abi.PaddedPieceSize(512<<20), 2))
+	err = s.checkDataCap(ctx, client, abi.PaddedPieceSize(512<<20), 3)
+	require.True(t, errors.Is(err, ErrInsufficientDataCap))
+}
@@ -53,10 +53,17 @@ type MockLotusAPI struct {
 	obj         []byte               // bytes returned when calling ChainReadObj
 	objReader   func(cid.Cid) []byte // bytes to return given a specific cid
 	msgLookup   chan *MsgLookup      // msgLookup to return when calling StateWaitMsg
+	searchMu    sync.Mutex
+	searches    map[cid.Cid]*MsgLookup // lookups returned when calling StateSearchMsg
 	accMu       sync.Mutex
 	accountKeys map[address.Address]address.Address // address returned when calling StateAccountKey
 	lookupID    address.Address                     // address returned when calling StateLookupID
 	invocResult *InvocResult                        // invocResult returned when calling StateCall
+	dealsMu     sync.Mutex
+	deals       map[abi.DealID]*MarketDeal           // deals returned when calling StateMarketStorageDeal
+	datacap     map[address.Address]abi.StoragePower // datacap returned when calling StateVerifiedClientStatus
+	minersMu    sync.Mutex
+	miners      map[address.Address]MinerInfo // info returned when calling StateMinerInfo
 }
 
 func NewMockLotusAPI() *MockLotusAPI {
@@ -66,7 +73,11 @@ func NewMockLotusAPI() *MockLotusAPI {
 	}
 	return &MockLotusAPI{
 		msgLookup:   make(chan *MsgLookup),
+		searches:    make(map[cid.Cid]*MsgLookup),
 		accountKeys: make(map[address.Address]address.Address),
+		deals:       make(map[abi.DealID]*MarketDeal),
+		datacap:     make(map[address.Address]abi.StoragePower),
+		miners:      make(map[address.Address]MinerInfo),
 		head:        head,
 	}
 }
@@ -98,6 +109,13 @@ func (m *MockLotusAPI) StateWaitMsg(ctx context.Context, c cid.Cid, conf uint64)
 	}
 }
 
+// StateSearchMsg returns nil if the message wasn't found on chain
+func (m *MockLotusAPI) StateSearchMsg(ctx context.Context, c cid.Cid) (*MsgLookup, error) {
+	m.searchMu.Lock()
+	defer m.searchMu.Unlock()
+	return m.searches[c], nil
+}
+
 func (m *MockLotusAPI) StateAccountKey(ctx context.Context, addr address.Address, tsk TipSetKey) (address.Address, error) {
 	m.accMu.Lock()
 	defer m.accMu.Unlock()
@@ -131,6 +149,25 @@ func (m *MockLotusAPI) StateMinerProvingDeadline(ctx context.Context, addr addre
 	return nil, nil
 }
 
+func (m *MockLotusAPI) StateMarketStorageDeal(ctx context.Context, id abi.DealID, tsk TipSetKey) (*MarketDeal, error) {
+	m.dealsMu.Lock()
+	defer m.dealsMu.Unlock()
+	if d, ok := m.deals[id]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("deal %d not found", id)
+}
+
+func (m *MockLotusAPI) StateVerifiedClientStatus(ctx context.Context, addr address.Address, tsk TipSetKey) (*abi.StoragePower, error) {
+	m.dealsMu.Lock()
+	defer m.dealsMu.Unlock()
+	if dc, ok := m.datacap[addr]; ok {
+		return &dc, nil
+	}
+	// Lotus returns nil if the address is not a verified client
+	return nil, nil
+}
+
 func (m *MockLotusAPI) StateCall(ctx context.Context, msg *Message, tsk TipSetKey) (*InvocResult, error) {
 	return m.invocResult, nil
 }
@@ -147,7 +184,9 @@ func (m *MockLotusAPI) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, err
 }
 
 func (m *MockLotusAPI) StateMinerInfo(ctx context.Context, addr address.Address, tsk TipSetKey) (MinerInfo, error) {
-	return MinerInfo{}, nil
+	m.minersMu.Lock()
+	defer m.minersMu.Unlock()
+	return m.miners[addr], nil
 }
 
 func (m *MockLotusAPI) Close() {}
@@ -183,6 +222,34 @@ func (m *MockLotusAPI) SetMsgLookup(lkp *MsgLookup) {
 	m.msgLookup <- lkp
 }
 
+// SetSearchResult sets the lookup returned by StateSearchMsg for a message, nil means not found
+func (m *MockLotusAPI) SetSearchResult(c cid.Cid, lkp *MsgLookup) {
+	m.searchMu.Lock()
+	m.searches[c] = lkp
+	m.searchMu.Unlock()
+}
+
+// SetMarketDeal sets the deal returned by StateMarketStorageDeal for a deal ID
+func (m *MockLotusAPI) SetMarketDeal(id abi.DealID, d *MarketDeal) {
+	m.dealsMu.Lock()
+	m.deals[id] = d
+	m.dealsMu.Unlock()
+}
+
+// SetDataCap sets the datacap returned by StateVerifiedClientStatus for a verified client
+func (m *MockLotusAPI) SetDataCap(addr address.Address, dc abi.StoragePower) {
+	m.dealsMu.Lock()
+	m.datacap[addr] = dc
+	m.dealsMu.Unlock()
+}
+
+// SetMinerInfo sets the info returned by StateMinerInfo for a miner
+func (m *MockLotusAPI) SetMinerInfo(addr address.Address, info MinerInfo) {
+	m.minersMu.Lock()
+	m.miners[addr] = info
+	m.minersMu.Unlock()
+}
+
 func (m *MockLotusAPI) SetInvocResult(i *InvocResult) {
 	m.invocResult = i
 }
@@ -14,6 +14,7 @@ import (
 	big2 "github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/market"
 	"github.com/filecoin-project/specs-actors/v4/actors/runtime/proof"
 	block "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -22,7 +23,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-//go:generate cbor-gen-for  Ticket ElectionProof ExpTipSet BeaconEntry BlockHeader Message Actor SignedMessage MessageReceipt
+//go:generate cbor-gen-for  Ticket ElectionProof ExpTipSet BeaconEntry BlockHeader Message Actor SignedMessage MessageReceipt StateRoot TxMeta BlockMsg
 
 // These types are extracted out of lotus to avoid importing the whole project
 
@@ -347,6 +348,13 @@ func (blk *BlockHeader) LastTicket() *Ticket {
 	return blk.Ticket
 }
 
+// SigningBytes returns the bytes the miner signs which is the header without its signature
+func (blk *BlockHeader) SigningBytes() ([]byte, error) {
+	blkcopy := *blk
+	blkcopy.BlockSig = nil
+	return blkcopy.Serialize()
+}
+
 type Message struct {
 	Version uint64
 
@@ -497,6 +505,29 @@ type MessageReceipt struct {
 	GasUsed  int64
 }
 
+// StateRoot is the root of the state tree a block header points to
+type StateRoot struct {
+	// Version is the version of the state tree
+	Version uint64
+	// Actors is the root of the HAMT of all the actors
+	Actors cid.Cid
+	// Info is additional information about the state tree
+	Info cid.Cid
+}
+
+// TxMeta references the AMTs of the messages included in a block
+type TxMeta struct {
+	BlsMessages   cid.Cid
+	SecpkMessages cid.Cid
+}
+
+// BlockMsg is the message a new block is gossiped with
+type BlockMsg struct {
+	Header        *BlockHeader
+	BlsMessages   []cid.Cid
+	SecpkMessages []cid.Cid
+}
+
 type MsgLookup struct {
 	Message   cid.Cid // Can be different than requested, in case it was replaced, but only gas values changed
 	Receipt   MessageReceipt
@@ -620,6 +651,19 @@ type MinerInfo struct {
 	ConsensusFaultElapsed      abi.ChainEpoch
 }
 
+// DealState is the on chain state of a published storage deal
+type DealState struct {
+	SectorStartEpoch abi.ChainEpoch // -1 if not yet included in a proven sector
+	LastUpdatedEpoch abi.ChainEpoch // -1 if the deal state was never updated
+	SlashEpoch       abi.ChainEpoch // -1 if the deal was never slashed
+}
+
+// MarketDeal is a storage deal published in the storage market actor
+type MarketDeal struct {
+	Proposal market.DealProposal
+	State    DealState
+}
+
 // MarketBalance formats the Escrow and Locked balances of an address in the Storage Market
 type MarketBalance struct {
 	Escrow big2.Int
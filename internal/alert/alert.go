@@ -0,0 +1,274 @@
+// Package alert routes critical events to notification services so operators don't have to watch the logs.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Level is the severity of an alert
+type Level int
+
+const (
+	// Info is used to notify that a problem was resolved
+	Info Level = iota
+	// Warning requires attention but the node is still operating normally
+	Warning
+	// Critical requires immediate action from the operator
+	Critical
+)
+
+func (l Level) String() string {
+	switch l {
+	case Info:
+		return "INFO"
+	case Warning:
+		return "WARNING"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Events we may alert about
+const (
+	// EventDiskWatermark is sent when the storage used by the index goes over the watermark
+	EventDiskWatermark = "disk-watermark"
+	// EventReplication is sent when content could not be dispatched to as many caches as requested
+	EventReplication = "replication"
+	// EventFilecoinRPC is sent when the Filecoin RPC cannot be reached
+	EventFilecoinRPC = "filecoin-rpc"
+	// EventSettlement is sent when a payment channel is settling and vouchers must be submitted soon
+	EventSettlement = "settlement-deadline"
+	// EventIntegrity is sent when the repo had to be repaired on startup
+	EventIntegrity = "repo-integrity"
+	// EventDealRepair is sent when a storage deal was lost and we tried to replace it
+	EventDealRepair = "deal-repair"
+)
+
+// DefaultCooldown is the minimum time between two alerts for the same event
+const DefaultCooldown = time.Hour
+
+// Alert is a notification about an event
+type Alert struct {
+	Level   Level
+	Event   string
+	Message string
+	Time    time.Time
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("[%s] %s: %s", a.Level, a.Event, a.Message)
+}
+
+// Sink delivers alerts to a notification service
+type Sink interface {
+	Send(context.Context, Alert) error
+}
+
+// SlackSink posts alerts to a Slack incoming webhook
+type SlackSink struct {
+	url  string
+	http *http.Client
+}
+
+// NewSlackSink creates a new SlackSink posting to the given webhook url
+func NewSlackSink(url string) *SlackSink {
+	return &SlackSink{
+		url:  url,
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send posts the alert as a text message
+func (s *SlackSink) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(map[string]string{"text": a.String()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("slack webhook: %s %s", res.Status, msg)
+	}
+	return nil
+}
+
+// SMTPConfig is the server and addresses to send alerts by email
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+	From string
+	To   []string
+	// Username and Password are used for PLAIN authentication if set
+	Username string
+	Password string
+}
+
+// EmailSink sends alerts by email
+type EmailSink struct {
+	cfg SMTPConfig
+	// send can be replaced in tests
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailSink creates a new EmailSink for the given SMTP server
+func NewEmailSink(cfg SMTPConfig) *EmailSink {
+	return &EmailSink{
+		cfg:  cfg,
+		send: smtp.SendMail,
+	}
+}
+
+// Send emails the alert to all the recipients
+func (s *EmailSink) Send(ctx context.Context, a Alert) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: pop alert: [%s] %s\r\n", a.Level, a.Event)
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "\r\n%s\r\n", a.Message)
+	return s.send(s.cfg.Addr, auth, s.cfg.From, s.cfg.To, msg.Bytes())
+}
+
+// Config selects where alerts are sent
+type Config struct {
+	// SlackWebhook is a Slack incoming webhook url. Disabled if empty.
+	SlackWebhook string
+	// SMTP is the email configuration. Disabled if nil.
+	SMTP *SMTPConfig
+	// MinLevel is the lowest level of alerts to send
+	MinLevel Level
+	// Cooldown is the minimum time between alerts for the same event. Defaults to DefaultCooldown.
+	Cooldown time.Duration
+}
+
+// Notifier sends alerts to all the configured sinks. Alerts for the same event are only sent once per cooldown
+// period until the event is resolved. All alerts are logged even if no sinks are configured.
+type Notifier struct {
+	sinks    []Sink
+	min      Level
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu sync.Mutex
+	// active keeps track of when we last sent an alert for an unresolved event
+	active map[string]time.Time
+}
+
+// New creates a new Notifier with sinks for the given config
+func New(cfg Config) *Notifier {
+	n := &Notifier{
+		min:      cfg.MinLevel,
+		cooldown: cfg.Cooldown,
+		now:      time.Now,
+		active:   make(map[string]time.Time),
+	}
+	if n.cooldown == 0 {
+		n.cooldown = DefaultCooldown
+	}
+	if cfg.SlackWebhook != "" {
+		n.sinks = append(n.sinks, NewSlackSink(cfg.SlackWebhook))
+	}
+	if cfg.SMTP != nil {
+		n.sinks = append(n.sinks, NewEmailSink(*cfg.SMTP))
+	}
+	return n
+}
+
+// AddSink adds a sink to send alerts to
+func (n *Notifier) AddSink(s Sink) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sinks = append(n.sinks, s)
+}
+
+// Notify sends an alert for an event unless we already did during the cooldown period
+func (n *Notifier) Notify(ctx context.Context, level Level, event string, msg string) {
+	if n == nil {
+		return
+	}
+	if level < n.min {
+		log.Info().Str("level", level.String()).Str("event", event).Msg(msg)
+		return
+	}
+	now := n.now()
+	n.mu.Lock()
+	last, ok := n.active[event]
+	if ok && now.Sub(last) < n.cooldown {
+		n.mu.Unlock()
+		return
+	}
+	n.active[event] = now
+	n.mu.Unlock()
+
+	n.send(ctx, Alert{
+		Level:   level,
+		Event:   event,
+		Message: msg,
+		Time:    now,
+	})
+}
+
+// Resolve sends an Info alert if we previously alerted about the event so the next problem is sent right away.
+// Resolutions are sent regardless of the minimum level.
+func (n *Notifier) Resolve(ctx context.Context, event string, msg string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	_, ok := n.active[event]
+	delete(n.active, event)
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	n.send(ctx, Alert{
+		Level:   Info,
+		Event:   event,
+		Message: msg,
+		Time:    n.now(),
+	})
+}
+
+func (n *Notifier) send(ctx context.Context, a Alert) {
+	log.Warn().Str("level", a.Level.String()).Str("event", a.Event).Msg(a.Message)
+	n.mu.Lock()
+	sinks := n.sinks
+	n.mu.Unlock()
+	for _, s := range sinks {
+		if err := s.Send(ctx, a); err != nil {
+			log.Error().Err(err).Str("event", a.Event).Msg("failed to send alert")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testSink struct {
+	alerts []Alert
+}
+
+func (s *testSink) Send(ctx context.Context, a Alert) error {
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+func TestNotifier(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	n := New(Config{MinLevel: Warning, Cooldown: time.Minute})
+	n.now = func() time.Time { return now }
+	sink := &testSink{}
+	n.AddSink(sink)
+
+	n.Notify(ctx, Critical, EventFilecoinRPC, "rpc down")
+	require.Len(t, sink.alerts, 1)
+	require.Equal(t, "[CRITICAL] filecoin-rpc: rpc down", sink.alerts[0].String())
+
+	// Same event during the cooldown is not sent again
+	n.Notify(ctx, Critical, EventFilecoinRPC, "rpc down")
+	require.Len(t, sink.alerts, 1)
+
+	// Other events are sent
+	n.Notify(ctx, Warning, EventDiskWatermark, "disk full")
+	require.Len(t, sink.alerts, 2)
+
+	// Below the minimum level is only logged
+	n.Notify(ctx, Info, EventReplication, "not important")
+	require.Len(t, sink.alerts, 2)
+
+	// Resolving sends a notice and the next failure is sent right away
+	n.Resolve(ctx, EventFilecoinRPC, "rpc back")
+	require.Len(t, sink.alerts, 3)
+	require.Equal(t, Info, sink.alerts[2].Level)
+	n.Resolve(ctx, EventFilecoinRPC, "rpc back")
+	require.Len(t, sink.alerts, 3)
+	n.Notify(ctx, Critical, EventFilecoinRPC, "rpc down")
+	require.Len(t, sink.alerts, 4)
+
+	// After the cooldown the event is sent again
+	now = now.Add(2 * time.Minute)
+	n.Notify(ctx, Warning, EventDiskWatermark, "disk full")
+	require.Len(t, sink.alerts, 5)
+
+	// a nil notifier does nothing
+	var nn *Notifier
+	nn.Notify(ctx, Critical, EventFilecoinRPC, "rpc down")
+	nn.Resolve(ctx, EventFilecoinRPC, "rpc back")
+}
+
+func TestSlackSink(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	s := NewSlackSink(srv.URL)
+	a := Alert{Level: Warning, Event: EventDiskWatermark, Message: "disk full", Time: time.Now()}
+	require.NoError(t, s.Send(context.Background(), a))
+	require.Equal(t, a.String(), got["text"])
+
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer fail.Close()
+	require.Error(t, NewSlackSink(fail.URL).Send(context.Background(), a))
+}
+
+func TestEmailSink(t *testing.T) {
+	s := NewEmailSink(SMTPConfig{
+		Addr:     "smtp.example.com:587",
+		From:     "pop@example.com",
+		To:       []string{"ops@example.com", "oncall@example.com"},
+		Username: "user",
+		Password: "pass",
+	})
+	var msg string
+	s.send = func(addr string, a smtp.Auth, from string, to []string, b []byte) error {
+		require.Equal(t, "smtp.example.com:587", addr)
+		require.NotNil(t, a)
+		require.Equal(t, "pop@example.com", from)
+		require.Len(t, to, 2)
+		msg = string(b)
+		return nil
+	}
+	a := Alert{Level: Critical, Event: EventSettlement, Message: "channel settling", Time: time.Now()}
+	require.NoError(t, s.Send(context.Background(), a))
+	require.True(t, strings.Contains(msg, "Subject: pop alert: [CRITICAL] settlement-deadline\r\n"))
+	require.True(t, strings.HasSuffix(msg, "\r\nchannel settling\r\n"))
+}
@@ -0,0 +1,542 @@
+// Package objstore is a minimal client for S3 compatible object storage. Google Cloud Storage is supported
+// through its S3 compatible XML API using HMAC keys.
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedScheme is returned when parsing a url which is not an object storage url
+var ErrUnsupportedScheme = errors.New("unsupported object storage scheme")
+
+const (
+	// SchemeS3 is the url scheme for Amazon S3 or any S3 compatible storage, i.e. s3://bucket/key
+	SchemeS3 = "s3"
+	// SchemeGCS is the url scheme for Google Cloud Storage, i.e. gs://bucket/key
+	SchemeGCS = "gs"
+)
+
+// unsignedPayload lets us stream uploads without hashing the content first
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Config provides the endpoint and credentials to access a storage service
+type Config struct {
+	// Endpoint is the base url of the service, i.e. https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	// Region is used to sign requests
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// ConfigFromEnv reads the credentials for a given scheme from the environment.
+// S3 uses AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION and AWS_ENDPOINT_URL while
+// GCS uses GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY HMAC keys.
+func ConfigFromEnv(scheme string) (Config, error) {
+	switch scheme {
+	case SchemeS3:
+		cfg := Config{
+			Region:    os.Getenv("AWS_REGION"),
+			Endpoint:  os.Getenv("AWS_ENDPOINT_URL"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+		}
+		return cfg, nil
+	case SchemeGCS:
+		return Config{
+			Region:    "auto",
+			Endpoint:  "https://storage.googleapis.com",
+			AccessKey: os.Getenv("GCS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("GCS_SECRET_ACCESS_KEY"),
+		}, nil
+	default:
+		return Config{}, ErrUnsupportedScheme
+	}
+}
+
+// Location is the address of an object or prefix in a bucket
+type Location struct {
+	Scheme string
+	Bucket string
+	Key    string
+}
+
+// ParseURL parses an object storage url such as s3://bucket/path/to/key
+func ParseURL(s string) (Location, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Location{}, err
+	}
+	if u.Scheme != SchemeS3 && u.Scheme != SchemeGCS {
+		return Location{}, ErrUnsupportedScheme
+	}
+	if u.Host == "" {
+		return Location{}, fmt.Errorf("missing bucket name in %s", s)
+	}
+	return Location{
+		Scheme: u.Scheme,
+		Bucket: u.Host,
+		Key:    strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+// IsURL returns whether a string is an object storage url
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, SchemeS3+"://") || strings.HasPrefix(s, SchemeGCS+"://")
+}
+
+// DefaultPartSize is the size of the parts objects are uploaded in when they are too large for a single request.
+// Objects up to 5GB could be uploaded at once but splitting them lets us send large objects with less memory.
+const DefaultPartSize = 64 << 20
+
+// maxParts is the maximum number of parts of a multipart upload. The part size is increased for larger objects.
+const maxParts = 10000
+
+// Client uploads and downloads objects from a storage service
+type Client struct {
+	cfg  Config
+	http *http.Client
+	// now is used to date the requests and can be replaced in tests
+	now func() time.Time
+	// partSize is the size of each part of a multipart upload. Objects under that size are uploaded at once.
+	partSize int64
+}
+
+// New creates a new Client for the given config
+func New(cfg Config) *Client {
+	return &Client{
+		cfg:      cfg,
+		http:     http.DefaultClient,
+		now:      time.Now,
+		partSize: DefaultPartSize,
+	}
+}
+
+// Put streams an object of a given size to a bucket. Objects larger than the part size are
+// uploaded in multiple parts.
+func (c *Client) Put(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	if size > c.partSize {
+		return c.putMultipart(ctx, bucket, key, r, size)
+	}
+	return c.putObject(ctx, bucket, key, r, size)
+}
+
+// putObject uploads an object in a single request
+func (c *Client) putObject(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(bucket, key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	c.sign(req)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("failed to put %s/%s: %s %s", bucket, key, res.Status, body)
+	}
+	return nil
+}
+
+// initiateResult is the response body of a CreateMultipartUpload request
+type initiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// completedPart is a part of a CompleteMultipartUpload request body
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// completeUpload is the body of a CompleteMultipartUpload request
+type completeUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// completeResult is the response body of a CompleteMultipartUpload request which may be an error
+// even if the status is 200
+type completeResult struct {
+	XMLName xml.Name
+	Code    string
+	Message string
+}
+
+// putMultipart uploads an object in parts of partSize bytes. Only one part is buffered in memory
+// at a time and the upload is aborted if any part fails.
+func (c *Client) putMultipart(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	partSize := c.partSize
+	if parts := (size + partSize - 1) / partSize; parts > maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+
+	var ir initiateResult
+	if err := c.do(ctx, http.MethodPost, c.objectURL(bucket, key)+"?"+canonicalQuery(map[string]string{"uploads": ""}), nil, &ir); err != nil {
+		return fmt.Errorf("failed to start upload of %s/%s: %w", bucket, key, err)
+	}
+
+	uploadURL := func(params map[string]string) string {
+		params["uploadId"] = ir.UploadID
+		return c.objectURL(bucket, key) + "?" + canonicalQuery(params)
+	}
+
+	err := func() error {
+		var cu completeUpload
+		buf := make([]byte, partSize)
+		for n, sent := 1, int64(0); sent < size; n++ {
+			l := partSize
+			if size-sent < l {
+				l = size - sent
+			}
+			if _, err := io.ReadFull(r, buf[:l]); err != nil {
+				return err
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL(map[string]string{"partNumber": strconv.Itoa(n)}), bytes.NewReader(buf[:l]))
+			if err != nil {
+				return err
+			}
+			req.ContentLength = l
+			res, err := c.send(req)
+			if err != nil {
+				return fmt.Errorf("failed to upload part %d: %w", n, err)
+			}
+			res.Body.Close()
+			cu.Parts = append(cu.Parts, completedPart{PartNumber: n, ETag: res.Header.Get("ETag")})
+			sent += l
+		}
+
+		body, err := xml.Marshal(cu)
+		if err != nil {
+			return err
+		}
+		var cr completeResult
+		if err := c.do(ctx, http.MethodPost, uploadURL(map[string]string{}), body, &cr); err != nil {
+			return err
+		}
+		if cr.XMLName.Local == "Error" {
+			return fmt.Errorf("%s: %s", cr.Code, cr.Message)
+		}
+		return nil
+	}()
+	if err != nil {
+		// don't leave the parts we uploaded behind as they are billed until the upload is aborted
+		_ = c.do(ctx, http.MethodDelete, uploadURL(map[string]string{}), nil, nil)
+		return fmt.Errorf("failed to put %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// send signs and executes a request, returning an error if the status isn't successful
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	c.sign(req)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s", res.Status, body)
+	}
+	return res, nil
+}
+
+// do executes a request with an optional body and decodes the xml response in result if not nil
+func (c *Client) do(ctx context.Context, method, u string, body []byte, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	res, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return xml.NewDecoder(res.Body).Decode(result)
+}
+
+// Object describes an object stored in a bucket
+type Object struct {
+	Key  string
+	Size int64
+}
+
+// listResult is the response body of a ListObjectsV2 request
+type listResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns all the objects in a bucket with a key starting with the given prefix
+func (c *Client) List(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		params := map[string]string{
+			"list-type": "2",
+			"prefix":    prefix,
+		}
+		if token != "" {
+			params["continuation-token"] = token
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.bucketURL(bucket)+"?"+canonicalQuery(params), nil)
+		if err != nil {
+			return nil, err
+		}
+		c.sign(req)
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+			res.Body.Close()
+			return nil, fmt.Errorf("failed to list %s/%s: %s %s", bucket, prefix, res.Status, body)
+		}
+		var lr listResult
+		err = xml.NewDecoder(res.Body).Decode(&lr)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range lr.Contents {
+			// skip the placeholder objects some tools create for directories
+			if strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+			objects = append(objects, Object{Key: o.Key, Size: o.Size})
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = lr.NextContinuationToken
+	}
+}
+
+// Get streams the content of an object starting at a given byte offset
+func (c *Client) Get(ctx context.Context, bucket, key string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	c.sign(req)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to get %s/%s: %s %s", bucket, key, res.Status, body)
+	}
+	if offset > 0 && res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to get %s/%s: range requests not supported", bucket, key)
+	}
+	return res.Body, nil
+}
+
+// MaxRetries is the number of times a download is resumed after a failure before giving up
+const MaxRetries = 3
+
+// Open returns a reader for an object which resumes the download where it left off if the
+// connection fails while reading
+func (c *Client) Open(ctx context.Context, bucket, key string) io.ReadCloser {
+	return &resumableReader{
+		ctx:    ctx,
+		c:      c,
+		bucket: bucket,
+		key:    key,
+	}
+}
+
+// resumableReader lazily requests an object and requests the remaining bytes when a read fails
+type resumableReader struct {
+	ctx     context.Context
+	c       *Client
+	bucket  string
+	key     string
+	body    io.ReadCloser
+	offset  int64
+	retries int
+}
+
+func (r *resumableReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			body, err := r.c.Get(r.ctx, r.bucket, r.key, r.offset)
+			if err != nil {
+				return 0, err
+			}
+			r.body = body
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		r.body.Close()
+		r.body = nil
+		if r.retries >= MaxRetries || r.ctx.Err() != nil {
+			return n, err
+		}
+		r.retries++
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *resumableReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// bucketURL uses path style addressing so any bucket name works with custom endpoints
+func (c *Client) bucketURL(bucket string) string {
+	return strings.TrimSuffix(c.cfg.Endpoint, "/") + "/" + bucket
+}
+
+// canonicalQuery encodes query parameters sorted by name as required by the signature
+func canonicalQuery(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	qs := make([]string, len(names))
+	for i, k := range names {
+		qs[i] = uriEncode(k, true) + "=" + uriEncode(params[k], true)
+	}
+	return strings.Join(qs, "&")
+}
+
+// objectURL uses path style addressing so any bucket name works with custom endpoints
+func (c *Client) objectURL(bucket, key string) string {
+	return c.bucketURL(bucket) + "/" + uriEncode(key, false)
+}
+
+// sign adds AWS signature v4 headers to a request
+func (c *Client) sign(req *http.Request) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := strings.Join([]string{date, c.cfg.Region, "s3", "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode escapes every byte except the unreserved characters as required by the signature.
+// Slashes are kept unless encodeSlash is true.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	loc, err := ParseURL("s3://my-bucket/path/to/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, Location{Scheme: SchemeS3, Bucket: "my-bucket", Key: "path/to/file.txt"}, loc)
+
+	loc, err = ParseURL("gs://my-bucket")
+	require.NoError(t, err)
+	require.Equal(t, "", loc.Key)
+
+	_, err = ParseURL("/tmp/file.txt")
+	require.Equal(t, ErrUnsupportedScheme, err)
+
+	require.True(t, IsURL("gs://bucket/key"))
+	require.False(t, IsURL("./bucket/key"))
+}
+
+func TestPut(t *testing.T) {
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/bucket/dir/hello%20world.txt", r.URL.EscapedPath())
+		require.Equal(t, unsignedPayload, r.Header.Get("x-amz-content-sha256"))
+		auth := r.Header.Get("Authorization")
+		require.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/"))
+		require.Contains(t, auth, "/us-east-1/s3/aws4_request")
+		require.Contains(t, auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date")
+
+		var err error
+		got, err = ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+	}))
+	defer srv.Close()
+
+	c := New(Config{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	data := []byte("some content")
+	require.NoError(t, c.Put(context.Background(), "bucket", "dir/hello world.txt", bytes.NewReader(data), int64(len(data))))
+	require.Equal(t, data, got)
+
+	// errors from the service are returned
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer fail.Close()
+	c = New(Config{Endpoint: fail.URL, Region: "us-east-1"})
+	require.Error(t, c.Put(context.Background(), "bucket", "key", bytes.NewReader(data), int64(len(data))))
+}
+
+func TestPutMultipart(t *testing.T) {
+	parts := make(map[string][]byte)
+	var completed, aborted bool
+	failPart := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bucket/large.bin", r.URL.Path)
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Get("uploads") == "" && len(q["uploads"]) == 1:
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut:
+			require.Equal(t, "upload1", q.Get("uploadId"))
+			n := q.Get("partNumber")
+			if n == failPart {
+				http.Error(w, "InternalError", http.StatusInternalServerError)
+				return
+			}
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			parts[n] = b
+			w.Header().Set("ETag", `"etag`+n+`"`)
+		case r.Method == http.MethodPost:
+			require.Equal(t, "upload1", q.Get("uploadId"))
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"etag1"</ETag></Part><Part><PartNumber>2</PartNumber><ETag>"etag2"</ETag></Part><Part><PartNumber>3</PartNumber><ETag>"etag3"</ETag></Part></CompleteMultipartUpload>`, strings.ReplaceAll(string(b), "&#34;", `"`))
+			completed = true
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Key>large.bin</Key></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodDelete:
+			require.Equal(t, "upload1", q.Get("uploadId"))
+			aborted = true
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(Config{Endpoint: srv.URL, Region: "us-east-1"})
+	c.partSize = 4
+	data := []byte("0123456789")
+	require.NoError(t, c.Put(context.Background(), "bucket", "large.bin", bytes.NewReader(data), int64(len(data))))
+	require.True(t, completed)
+	require.False(t, aborted)
+	require.Equal(t, map[string][]byte{"1": []byte("0123"), "2": []byte("4567"), "3": []byte("89")}, parts)
+
+	// a failing part aborts the upload
+	failPart = "2"
+	completed = false
+	require.Error(t, c.Put(context.Background(), "bucket", "large.bin", bytes.NewReader(data), int64(len(data))))
+	require.False(t, completed)
+	require.True(t, aborted)
+}
+
+func TestListGet(t *testing.T) {
+	objects := map[string][]byte{
+		"data/a.txt":     []byte("content a"),
+		"data/sub/b.txt": []byte("content b"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		if r.URL.Path == "/bucket" {
+			require.Equal(t, "2", r.URL.Query().Get("list-type"))
+			require.Equal(t, "data/", r.URL.Query().Get("prefix"))
+			if r.URL.Query().Get("continuation-token") == "" {
+				fmt.Fprint(w, `<ListBucketResult><Contents><Key>data/</Key><Size>0</Size></Contents><Contents><Key>data/a.txt</Key><Size>9</Size></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`)
+				return
+			}
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>data/sub/b.txt</Key><Size>9</Size></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			return
+		}
+		data := objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if rng := r.Header.Get("Range"); rng != "" {
+			var offset int
+			_, err := fmt.Sscanf(rng, "bytes=%d-", &offset)
+			require.NoError(t, err)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[offset:])
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := New(Config{Endpoint: srv.URL, Region: "us-east-1"})
+	list, err := c.List(context.Background(), "bucket", "data/")
+	require.NoError(t, err)
+	require.Equal(t, []Object{{Key: "data/a.txt", Size: 9}, {Key: "data/sub/b.txt", Size: 9}}, list)
+
+	body, err := c.Get(context.Background(), "bucket", "data/sub/b.txt", 8)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "b", string(b))
+
+	r := c.Open(context.Background(), "bucket", "data/a.txt")
+	defer r.Close()
+	b, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, objects["data/a.txt"], b)
+}
+
+// failingBody returns an error after sending a few bytes as if the connection was dropped
+type failingBody struct {
+	r io.Reader
+}
+
+func (f *failingBody) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (f *failingBody) Close() error { return nil }
+
+func TestResumableReader(t *testing.T) {
+	data := []byte("0123456789")
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		offset := 0
+		if rng := r.Header.Get("Range"); rng != "" {
+			_, err := fmt.Sscanf(rng, "bytes=%d-", &offset)
+			require.NoError(t, err)
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data[offset:])
+	}))
+	defer srv.Close()
+
+	c := New(Config{Endpoint: srv.URL, Region: "us-east-1"})
+	r := c.Open(context.Background(), "bucket", "key").(*resumableReader)
+	// the first connection drops after 4 bytes
+	r.body = &failingBody{bytes.NewReader(data[:4])}
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	require.Equal(t, []string{"bytes=4-"}, ranges)
+}
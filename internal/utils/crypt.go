@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrDecrypt is returned when a value cannot be decrypted, usually because the key is wrong
+var ErrDecrypt = errors.New("failed to decrypt value")
+
+// ErrWrongKey is returned when opening an encrypted repo with a different key than the one it was created with
+var ErrWrongKey = errors.New("wrong encryption key")
+
+// ErrRepoEncrypted is returned when opening an encrypted repo without key
+var ErrRepoEncrypted = errors.New("repo is encrypted")
+
+// ErrRepoNotEncrypted is returned when opening a plain text repo with a key
+var ErrRepoNotEncrypted = errors.New("repo is not encrypted")
+
+var (
+	// canaryKey holds a known value encrypted with the repo key
+	canaryKey   = datastore.NewKey("/encryption/canary")
+	canaryValue = []byte("pop encrypted repo")
+	// plainKey marks the repos which are not encrypted
+	plainKey = datastore.NewKey("/encryption/plain")
+)
+
+// saltFile is where we persist the salt used to derive a key from a passphrase
+const saltFile = "salt"
+
+// Cipher encrypts and decrypts values written to disk. It can be implemented by a KMS client
+// for keys that should not leave the KMS.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+	// Overhead is the difference in bytes between a ciphertext and its plaintext
+	Overhead() int
+}
+
+// AESCipher is the default Cipher using AES-GCM with a random nonce prepended to each value
+type AESCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAESCipher creates a new AES-GCM Cipher from a 16, 24 or 32 bytes key
+func NewAESCipher(key []byte) (*AESCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESCipher{aead}, nil
+}
+
+// Encrypt seals the plaintext
+func (c *AESCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens the ciphertext
+func (c *AESCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	ns := c.aead.NonceSize()
+	if len(ciphertext) < ns {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:ns], ciphertext[ns:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Overhead returns the nonce and authentication tag size
+func (c *AESCipher) Overhead() int {
+	return c.aead.NonceSize() + c.aead.Overhead()
+}
+
+// KeyFromPassphrase derives a 32 bytes key from a passphrase and salt
+func KeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// RepoSalt returns the salt persisted in the repo or generates a new one
+func RepoSalt(path string) ([]byte, error) {
+	p := filepath.Join(path, saltFile)
+	salt, err := os.ReadFile(p)
+	if err == nil {
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	salt = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(p, salt, 0600); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// CheckRepoCipher verifies a repo is opened with the key it was created with or without key if it isn't encrypted.
+// New repos are marked as encrypted or not the first time they are opened. Repos created before we marked them
+// are assumed to be in plain text.
+func CheckRepoCipher(ds datastore.Batching, c Cipher) error {
+	enc, err := ds.Get(canaryKey)
+	if err == nil {
+		if c == nil {
+			return ErrRepoEncrypted
+		}
+		v, err := c.Decrypt(enc)
+		if err != nil || !bytes.Equal(v, canaryValue) {
+			return ErrWrongKey
+		}
+		return nil
+	}
+	if err != datastore.ErrNotFound {
+		return err
+	}
+	plain, err := ds.Has(plainKey)
+	if err != nil {
+		return err
+	}
+	if plain {
+		if c != nil {
+			return ErrRepoNotEncrypted
+		}
+		return nil
+	}
+	if c == nil {
+		return ds.Put(plainKey, []byte{1})
+	}
+	// A repo which isn't marked yet but holds values was created in plain text
+	res, err := ds.Query(query.Query{KeysOnly: true, Limit: 1})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrRepoNotEncrypted
+	}
+	enc, err = c.Encrypt(canaryValue)
+	if err != nil {
+		return err
+	}
+	return ds.Put(canaryKey, enc)
+}
+
+// EncryptedDatastore transparently encrypts all values written to the underlying datastore
+// and decrypts them on reads. Keys are left in clear so they can still be queried.
+// Wrapping a datastore which already has unencrypted values will make them unreadable.
+type EncryptedDatastore struct {
+	child  datastore.Batching
+	cipher Cipher
+}
+
+// NewEncryptedDatastore wraps a datastore with the given cipher
+func NewEncryptedDatastore(ds datastore.Batching, c Cipher) *EncryptedDatastore {
+	return &EncryptedDatastore{
+		child:  ds,
+		cipher: c,
+	}
+}
+
+// Put encrypts and stores a value
+func (d *EncryptedDatastore) Put(k datastore.Key, value []byte) error {
+	enc, err := d.cipher.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	return d.child.Put(k, enc)
+}
+
+// Get retrieves and decrypts a value
+func (d *EncryptedDatastore) Get(k datastore.Key) ([]byte, error) {
+	enc, err := d.child.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return d.cipher.Decrypt(enc)
+}
+
+// Has returns whether a value exists for the key
+func (d *EncryptedDatastore) Has(k datastore.Key) (bool, error) {
+	return d.child.Has(k)
+}
+
+// GetSize returns the size of the decrypted value
+func (d *EncryptedDatastore) GetSize(k datastore.Key) (int, error) {
+	size, err := d.child.GetSize(k)
+	if err != nil {
+		return -1, err
+	}
+	return size - d.cipher.Overhead(), nil
+}
+
+// Delete removes a value
+func (d *EncryptedDatastore) Delete(k datastore.Key) error {
+	return d.child.Delete(k)
+}
+
+// Query runs the query against the underlying datastore, values are decrypted before
+// applying any filter or order.
+func (d *EncryptedDatastore) Query(q query.Query) (query.Results, error) {
+	// Filters and orders may depend on values so we apply them after decryption
+	cq := query.Query{
+		Prefix:            q.Prefix,
+		KeysOnly:          q.KeysOnly,
+		ReturnExpirations: q.ReturnExpirations,
+		ReturnsSizes:      q.ReturnsSizes,
+	}
+	res, err := d.child.Query(cq)
+	if err != nil {
+		return nil, err
+	}
+	dec := query.ResultsFromIterator(cq, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := res.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+			if r.Size > 0 {
+				r.Size -= d.cipher.Overhead()
+			}
+			if !cq.KeysOnly {
+				r.Value, r.Error = d.cipher.Decrypt(r.Value)
+				if r.Error != nil {
+					r.Error = fmt.Errorf("%s: %w", r.Key, r.Error)
+				}
+			}
+			return r, true
+		},
+		Close: res.Close,
+	})
+	return query.NaiveQueryApply(q, dec), nil
+}
+
+// Sync flushes the underlying datastore
+func (d *EncryptedDatastore) Sync(prefix datastore.Key) error {
+	return d.child.Sync(prefix)
+}
+
+// Close closes the underlying datastore
+func (d *EncryptedDatastore) Close() error {
+	return d.child.Close()
+}
+
+// Batch returns a batch encrypting values before they are committed
+func (d *EncryptedDatastore) Batch() (datastore.Batch, error) {
+	b, err := d.child.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedBatch{b, d.cipher}, nil
+}
+
+type encryptedBatch struct {
+	child  datastore.Batch
+	cipher Cipher
+}
+
+func (b *encryptedBatch) Put(k datastore.Key, value []byte) error {
+	enc, err := b.cipher.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	return b.child.Put(k, enc)
+}
+
+func (b *encryptedBatch) Delete(k datastore.Key) error {
+	return b.child.Delete(k)
+}
+
+func (b *encryptedBatch) Commit() error {
+	return b.child.Commit()
+}
+
+var _ datastore.Batching = (*EncryptedDatastore)(nil)
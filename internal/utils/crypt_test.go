@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedBlockstore(t *testing.T) {
+	key, err := KeyFromPassphrase("correct horse battery staple", []byte("salt"))
+	require.NoError(t, err)
+	c, err := NewAESCipher(key)
+	require.NoError(t, err)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(NewEncryptedDatastore(ds, c))
+
+	blk := blocks.NewBlock([]byte("some very secret data"))
+	require.NoError(t, bs.Put(blk))
+
+	got, err := bs.Get(blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+
+	size, err := bs.GetSize(blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, len(blk.RawData()), size)
+
+	// the raw datastore should not contain the plaintext
+	raw := blockstore.NewBlockstore(ds)
+	raw.HashOnRead(false)
+	rblk, err := raw.Get(blk.Cid())
+	require.NoError(t, err)
+	require.NotEqual(t, blk.RawData(), rblk.RawData())
+
+	// reading with the wrong key fails
+	key2, err := KeyFromPassphrase("wrong", []byte("salt"))
+	require.NoError(t, err)
+	c2, err := NewAESCipher(key2)
+	require.NoError(t, err)
+	_, err = blockstore.NewBlockstore(NewEncryptedDatastore(ds, c2)).Get(blk.Cid())
+	require.Error(t, err)
+}
+
+func TestCheckRepoCipher(t *testing.T) {
+	newCipher := func(pass string) Cipher {
+		key, err := KeyFromPassphrase(pass, []byte("salt"))
+		require.NoError(t, err)
+		c, err := NewAESCipher(key)
+		require.NoError(t, err)
+		return c
+	}
+	c := newCipher("correct horse battery staple")
+
+	// A new encrypted repo can only be opened with the same key
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, CheckRepoCipher(ds, c))
+	require.NoError(t, CheckRepoCipher(ds, c))
+	require.ErrorIs(t, CheckRepoCipher(ds, newCipher("wrong")), ErrWrongKey)
+	require.ErrorIs(t, CheckRepoCipher(ds, nil), ErrRepoEncrypted)
+
+	// A new plain text repo cannot be opened with a key
+	ds = dss.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, CheckRepoCipher(ds, nil))
+	require.NoError(t, CheckRepoCipher(ds, nil))
+	require.ErrorIs(t, CheckRepoCipher(ds, c), ErrRepoNotEncrypted)
+
+	// Repos created before the check hold plain text values
+	ds = dss.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, ds.Put(datastore.NewKey("/some/value"), []byte("plain")))
+	require.ErrorIs(t, CheckRepoCipher(ds, c), ErrRepoNotEncrypted)
+	require.NoError(t, CheckRepoCipher(ds, nil))
+}
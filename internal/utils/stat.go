@@ -85,7 +85,7 @@ func WalkDAG(
 	// Load the root node
 	err = link.Load(ctx, ipld.LinkContext{}, builder, makeLoader(bs))
 	if err != nil {
-		return fmt.Errorf("unable to load link: %v", err)
+		return fmt.Errorf("unable to load link: %w", err)
 	}
 	nd := builder.Build()
 
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/rs/zerolog/log"
+)
+
+// Supervisor runs long lived goroutines, recovers from any panic and restarts them with backoff
+// so a single bug doesn't silently kill background work. It keeps count of the crashes for each subsystem.
+// A nil Supervisor still recovers panics but doesn't count them.
+type Supervisor struct {
+	// MinBackoff is the delay before restarting a subsystem after its first crash
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between restarts
+	MaxBackoff time.Duration
+
+	mu      sync.Mutex
+	crashes map[string]int
+}
+
+// NewSupervisor creates a new Supervisor with default backoff values
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Minute,
+		crashes:    make(map[string]int),
+	}
+}
+
+// Go runs fn in a new goroutine. If fn panics, the panic is logged with the stack trace and fn is restarted
+// after a backoff delay until the context is done. Supervision ends when fn returns normally.
+func (s *Supervisor) Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	min, max := 100*time.Millisecond, time.Minute
+	if s != nil {
+		min, max = s.MinBackoff, s.MaxBackoff
+	}
+	b := &backoff.Backoff{
+		Min:    min,
+		Max:    max,
+		Factor: 2,
+		Jitter: true,
+	}
+	go func() {
+		for {
+			if !s.run(ctx, name, fn) {
+				return
+			}
+			d := b.Duration()
+			log.Info().Str("subsystem", name).Dur("delay", d).Msg("restarting subsystem")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}()
+}
+
+// run executes fn and returns true if it panicked
+func (s *Supervisor) run(ctx context.Context, name string, fn func(ctx context.Context)) (crashed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.crash(name, r)
+			crashed = true
+		}
+	}()
+	fn(ctx)
+	return false
+}
+
+// Recover must be deferred in short lived goroutines such as stream handlers or event callbacks.
+// It logs and counts a panic without restarting anything.
+func (s *Supervisor) Recover(name string) {
+	if r := recover(); r != nil {
+		s.crash(name, r)
+	}
+}
+
+func (s *Supervisor) crash(name string, r interface{}) {
+	log.Error().
+		Str("subsystem", name).
+		Str("panic", fmt.Sprint(r)).
+		Str("stack", string(debug.Stack())).
+		Msg("recovered from panic")
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crashes[name]++
+}
+
+// Crashes returns the number of panics recovered for each subsystem
+func (s *Supervisor) Crashes() map[string]int {
+	out := make(map[string]int)
+	if s == nil {
+		return out
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.crashes {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupervisor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := NewSupervisor()
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 10 * time.Millisecond
+
+	done := make(chan struct{})
+	runs := 0
+	s.Go(ctx, "flaky", func(ctx context.Context) {
+		runs++
+		if runs < 3 {
+			panic("boom")
+		}
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("subsystem was not restarted")
+	}
+	require.Equal(t, 2, s.Crashes()["flaky"])
+
+	func() {
+		defer s.Recover("handler")
+		var m map[string]int
+		m["nil"]++
+	}()
+	require.Equal(t, 1, s.Crashes()["handler"])
+
+	// a nil supervisor still recovers
+	var ns *Supervisor
+	func() {
+		defer ns.Recover("handler")
+		panic("boom")
+	}()
+	require.Len(t, ns.Crashes(), 0)
+}
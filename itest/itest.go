@@ -0,0 +1,342 @@
+// Package itest runs multi node scenarios against the exchange replication scheme. A Scenario declares the
+// nodes and their regions, the links between them and their latency, then a script of steps writing and
+// dispatching content or adding nodes to the network. Assertions on the number of replicas and on which peers
+// served which are steps as well so integrators can validate their deployments against the same scenarios.
+package itest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/internal/testutil"
+	sel "github.com/myelnet/pop/selectors"
+)
+
+// DefaultTimeout bounds the duration of a scenario unless specified otherwise
+const DefaultTimeout = 30 * time.Second
+
+// DefaultCapacity is the storage capacity of each node unless specified otherwise
+const DefaultCapacity = 2000000
+
+// DefaultReplInterval is the interval at which nodes look for new content to replicate
+const DefaultReplInterval = 2 * time.Second
+
+// NodeSpec declares a node of the network
+type NodeSpec struct {
+	Name string
+	// Regions are the names of the regions the node serves i.e. Europe. Defaults to Global.
+	Regions []string
+	// Capacity is the storage capacity of the node in bytes. Defaults to DefaultCapacity.
+	Capacity uint64
+}
+
+// LinkSpec declares a connection between two nodes
+type LinkSpec struct {
+	A, B string
+	// Latency is added to every message sent on the link
+	Latency time.Duration
+}
+
+// Scenario is a network topology and a script to run against it
+type Scenario struct {
+	Nodes []NodeSpec
+	Links []LinkSpec
+	Steps []Step
+	// Timeout bounds the whole scenario. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// ReplInterval is the interval at which nodes look for new content to replicate.
+	// Defaults to DefaultReplInterval.
+	ReplInterval time.Duration
+}
+
+// Run sets up the scenario topology and executes each step in order, failing the test at the first error
+func Run(t testing.TB, s Scenario) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	n := NewNetwork(ctx, t)
+	if s.ReplInterval != 0 {
+		n.ReplInterval = s.ReplInterval
+	}
+	for _, spec := range s.Nodes {
+		if _, err := n.AddNode(ctx, spec); err != nil {
+			t.Fatalf("failed to add node %s: %v", spec.Name, err)
+		}
+	}
+	for _, l := range s.Links {
+		if err := n.Link(l); err != nil {
+			t.Fatalf("failed to link %s and %s: %v", l.A, l.B, err)
+		}
+	}
+	for i, step := range s.Steps {
+		if err := step.Run(ctx, n); err != nil {
+			t.Fatalf("step %d (%s) failed: %v", i, step.Name, err)
+		}
+	}
+}
+
+// Node is a single peer running the replication scheme
+type Node struct {
+	Name        string
+	Host        host.Host
+	Index       *exchange.Index
+	Replication *exchange.Replication
+
+	tn *testutil.TestNode
+}
+
+// content is a file written by a node during the scenario
+type content struct {
+	root    cid.Cid
+	size    uint64
+	storeID multistore.StoreID
+	bytes   []byte
+}
+
+// Network is a set of nodes connected over a mock network
+type Network struct {
+	// ReplInterval is passed to the nodes added to the network
+	ReplInterval time.Duration
+
+	t  testing.TB
+	mn mocknet.Mocknet
+
+	mu      sync.Mutex
+	nodes   map[string]*Node
+	content map[string]content
+	// served records the peers each node sent content to for each key
+	served map[string]map[string][]string
+}
+
+// NewNetwork creates an empty network
+func NewNetwork(ctx context.Context, t testing.TB) *Network {
+	return &Network{
+		ReplInterval: DefaultReplInterval,
+		t:            t,
+		mn:           mocknet.New(ctx),
+		nodes:        make(map[string]*Node),
+		content:      make(map[string]content),
+		served:       make(map[string]map[string][]string),
+	}
+}
+
+// AddNode creates a new node and starts its replication scheme. It isn't connected to any peer yet.
+func (n *Network) AddNode(ctx context.Context, spec NodeSpec) (*Node, error) {
+	if _, ok := n.Node(spec.Name); ok {
+		return nil, fmt.Errorf("node %s already exists", spec.Name)
+	}
+	regions := []exchange.Region{exchange.Regions["Global"]}
+	if len(spec.Regions) > 0 {
+		regions = make([]exchange.Region, len(spec.Regions))
+		for i, name := range spec.Regions {
+			r, ok := exchange.Regions[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown region %s", name)
+			}
+			regions[i] = r
+		}
+	}
+	capacity := spec.Capacity
+	if capacity == 0 {
+		capacity = DefaultCapacity
+	}
+
+	tn := testutil.NewTestNode(n.mn, n.t)
+	tn.SetupDataTransfer(ctx, n.t)
+	n.t.Cleanup(func() {
+		_ = tn.Dt.Stop(context.Background())
+	})
+	idx, err := exchange.NewIndex(tn.Ds, tn.Bs, exchange.WithBounds(capacity, capacity*9/10))
+	if err != nil {
+		return nil, err
+	}
+	tn.Dt.RegisterVoucherType(&testutil.FakeDTType{}, &testutil.FakeDTValidator{})
+	node := &Node{
+		Name:  spec.Name,
+		Host:  tn.Host,
+		Index: idx,
+		tn:    tn,
+	}
+	node.Replication, err = exchange.NewReplication(
+		tn.Host,
+		idx,
+		tn.Dt,
+		&retriever{n: n, self: node},
+		exchange.Options{
+			Regions:      regions,
+			ReplInterval: n.ReplInterval,
+			MultiStore:   tn.Ms,
+			Blockstore:   tn.Bs,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.Replication.Start(ctx); err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	n.nodes[spec.Name] = node
+	n.mu.Unlock()
+	return node, nil
+}
+
+// Link connects two nodes of the network
+func (n *Network) Link(spec LinkSpec) error {
+	a, ok := n.Node(spec.A)
+	if !ok {
+		return fmt.Errorf("unknown node %s", spec.A)
+	}
+	b, ok := n.Node(spec.B)
+	if !ok {
+		return fmt.Errorf("unknown node %s", spec.B)
+	}
+	l, err := n.mn.LinkPeers(a.Host.ID(), b.Host.ID())
+	if err != nil {
+		return err
+	}
+	l.SetOptions(mocknet.LinkOptions{Latency: spec.Latency})
+	_, err = n.mn.ConnectPeers(a.Host.ID(), b.Host.ID())
+	return err
+}
+
+// Node returns a node by name
+func (n *Network) Node(name string) (*Node, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	node, ok := n.nodes[name]
+	return node, ok
+}
+
+// Nodes returns the names of all the nodes in the network
+func (n *Network) Nodes() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	names := make([]string, 0, len(n.nodes))
+	for name := range n.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Root returns the root CID of the content written under the given key
+func (n *Network) Root(key string) (cid.Cid, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c, ok := n.content[key]
+	return c.root, ok
+}
+
+// Holders returns the names of the nodes with the content for the given key in their index
+func (n *Network) Holders(key string) []string {
+	root, ok := n.Root(key)
+	if !ok {
+		return nil
+	}
+	var holders []string
+	for _, name := range n.Nodes() {
+		node, _ := n.Node(name)
+		if _, err := node.Index.PeekRef(root); err == nil {
+			holders = append(holders, name)
+		}
+	}
+	return holders
+}
+
+// Served returns the names of the nodes the given node sent the content for a key to
+func (n *Network) Served(from, key string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	to := append([]string(nil), n.served[key][from]...)
+	sort.Strings(to)
+	return to
+}
+
+func (n *Network) recordServed(key, from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.served[key] == nil {
+		n.served[key] = make(map[string][]string)
+	}
+	n.served[key][from] = append(n.served[key][from], to)
+}
+
+// keyOf returns the key of the content with the given root
+func (n *Network) keyOf(root cid.Cid) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, c := range n.content {
+		if c.root.Equals(root) {
+			return k
+		}
+	}
+	return root.String()
+}
+
+// retriever fetches content from any connected node holding it in their index
+type retriever struct {
+	n    *Network
+	self *Node
+}
+
+func (r *retriever) FindAndRetrieve(ctx context.Context, root cid.Cid) error {
+	var from *Node
+	var ref *exchange.DataRef
+	for _, name := range r.n.Nodes() {
+		node, _ := r.n.Node(name)
+		if node == r.self || r.self.Host.Network().Connectedness(node.Host.ID()) != network.Connected {
+			continue
+		}
+		if dr, err := node.Index.PeekRef(root); err == nil {
+			from, ref = node, dr
+			break
+		}
+	}
+	if from == nil {
+		return fmt.Errorf("no connected node holds %s", root)
+	}
+	dt := r.self.tn.Dt
+	chid, err := dt.OpenPullDataChannel(ctx, from.Host.ID(), &testutil.FakeDTType{Data: root.String()}, root, sel.All())
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		chState, err := dt.ChannelState(ctx, chid)
+		if err != nil {
+			return err
+		}
+		switch chState.Status() {
+		case datatransfer.Completed:
+			r.n.recordServed(r.n.keyOf(root), from.Name, r.self.Name)
+			return r.self.Index.SetRef(&exchange.DataRef{
+				PayloadCID:  root,
+				PayloadSize: ref.PayloadSize,
+			})
+		case datatransfer.Failed, datatransfer.Cancelled:
+			return fmt.Errorf(chState.Message())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package itest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDispatchScenario(t *testing.T) {
+	// Topology:
+	/*
+	   A -- B -- C -- D
+	        |  \ | \/ |
+	        |   \| /\ |
+	        G    F -- E
+	*/
+	Run(t, Scenario{
+		Nodes: []NodeSpec{{Name: "A"}, {Name: "B"}, {Name: "C"}, {Name: "D"}, {Name: "E"}, {Name: "F"}},
+		Links: []LinkSpec{
+			{A: "A", B: "B"},
+			{A: "B", B: "C", Latency: 10 * time.Millisecond},
+			{A: "C", B: "D"},
+			{A: "D", B: "E"},
+			{A: "C", B: "E"},
+			{A: "D", B: "F"},
+			{A: "E", B: "F"},
+			{A: "C", B: "F"},
+			{A: "B", B: "F"},
+		},
+		Steps: []Step{
+			Sleep(time.Second),
+			Put("D", "d", 256000),
+			Dispatch("D", "d", 3),
+			ExpectServed("D", "d", "C", "E", "F"),
+			ExpectReplicas("d", 4),
+			ExpectContent("E", "d"),
+
+			// G joins and replicates the content from the indexes of its peers
+			Join(NodeSpec{Name: "G"}, LinkSpec{A: "B", B: "G"}, LinkSpec{A: "F", B: "G"}),
+			ExpectHolders("d", "G"),
+			ExpectReplicas("d", 5),
+			ExpectContent("G", "d"),
+		},
+	})
+}
+
+func TestDispatchRegions(t *testing.T) {
+	Run(t, Scenario{
+		Nodes: []NodeSpec{
+			{Name: "client", Regions: []string{"Asia"}},
+			{Name: "asia1", Regions: []string{"Asia"}},
+			{Name: "asia2", Regions: []string{"Asia"}},
+			{Name: "africa", Regions: []string{"Africa"}},
+		},
+		Links: []LinkSpec{
+			{A: "client", B: "asia1"},
+			{A: "client", B: "asia2"},
+			{A: "client", B: "africa"},
+		},
+		Steps: []Step{
+			Sleep(time.Second),
+			Put("client", "file", 128000),
+			// only peers in the same region receive the content even if we ask for more
+			Dispatch("client", "file", 3),
+			ExpectServed("client", "file", "asia1", "asia2"),
+			ExpectReplicas("file", 3),
+		},
+	})
+}
@@ -0,0 +1,229 @@
+package itest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/internal/utils"
+)
+
+// Step is a single action or assertion in a scenario script
+type Step struct {
+	Name string
+	Run  func(ctx context.Context, n *Network) error
+}
+
+// Put writes a random file of the given size on a node and adds it to its index under a key
+// the following steps can refer to
+func Put(node, key string, size int) Step {
+	return Step{
+		Name: fmt.Sprintf("put %s on %s", key, node),
+		Run: func(ctx context.Context, n *Network) error {
+			nd, ok := n.Node(node)
+			if !ok {
+				return fmt.Errorf("unknown node %s", node)
+			}
+			fname := nd.tn.CreateRandomFile(n.t, size)
+			link, storeID, bytes := nd.tn.LoadFileToNewStore(ctx, n.t, fname)
+			root := link.(cidlink.Link).Cid
+			if err := nd.Index.SetRef(&exchange.DataRef{
+				PayloadCID:  root,
+				PayloadSize: int64(size),
+			}); err != nil {
+				return err
+			}
+			n.mu.Lock()
+			n.content[key] = content{root: root, size: uint64(size), storeID: storeID, bytes: bytes}
+			n.mu.Unlock()
+			return nil
+		},
+	}
+}
+
+// Dispatch sends the content for a key to rf peers of the node who wrote it. The content is then moved to
+// the node's main blockstore like a commit would.
+func Dispatch(node, key string, rf int) Step {
+	return Step{
+		Name: fmt.Sprintf("dispatch %s from %s", key, node),
+		Run: func(ctx context.Context, n *Network) error {
+			nd, ok := n.Node(node)
+			if !ok {
+				return fmt.Errorf("unknown node %s", node)
+			}
+			n.mu.Lock()
+			c, ok := n.content[key]
+			n.mu.Unlock()
+			if !ok {
+				return fmt.Errorf("unknown content %s", key)
+			}
+			opts := exchange.DispatchOptions{
+				BackoffMin:     200 * time.Millisecond,
+				BackoffAttemps: 4,
+				RF:             rf,
+				StoreID:        c.storeID,
+			}
+			res, err := nd.Replication.Dispatch(c.root, c.size, opts)
+			if err != nil {
+				return err
+			}
+			for rec := range res.Out() {
+				for _, name := range n.Nodes() {
+					if other, _ := n.Node(name); other.Host.ID() == rec.Provider {
+						n.recordServed(key, node, name)
+					}
+				}
+			}
+			store, err := nd.tn.Ms.Get(c.storeID)
+			if err != nil {
+				return err
+			}
+			if err := utils.MigrateBlocks(ctx, store.Bstore, nd.tn.Bs); err != nil {
+				return err
+			}
+			return nd.tn.Ms.Delete(c.storeID)
+		},
+	}
+}
+
+// Join adds a new node to the network and connects it with the given links
+func Join(spec NodeSpec, links ...LinkSpec) Step {
+	return Step{
+		Name: fmt.Sprintf("join %s", spec.Name),
+		Run: func(ctx context.Context, n *Network) error {
+			if _, err := n.AddNode(ctx, spec); err != nil {
+				return err
+			}
+			return Connect(links...).Run(ctx, n)
+		},
+	}
+}
+
+// Connect links nodes already in the network
+func Connect(links ...LinkSpec) Step {
+	return Step{
+		Name: "connect",
+		Run: func(ctx context.Context, n *Network) error {
+			for _, l := range links {
+				if err := n.Link(l); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// Sleep waits for the given duration i.e. to let peers greet each other
+func Sleep(d time.Duration) Step {
+	return Step{
+		Name: fmt.Sprintf("sleep %s", d),
+		Run: func(ctx context.Context, n *Network) error {
+			select {
+			case <-time.After(d):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// ExpectReplicas waits until exactly count nodes hold the content for a key in their index
+func ExpectReplicas(key string, count int) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %d replicas of %s", count, key),
+		Run: func(ctx context.Context, n *Network) error {
+			return poll(ctx, func() error {
+				if holders := n.Holders(key); len(holders) != count {
+					return fmt.Errorf("%d replicas of %s held by %v", len(holders), key, holders)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// ExpectHolders waits until the given nodes hold the content for a key in their index
+func ExpectHolders(key string, nodes ...string) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %s held by %v", key, nodes),
+		Run: func(ctx context.Context, n *Network) error {
+			return poll(ctx, func() error {
+				held := make(map[string]bool)
+				for _, h := range n.Holders(key) {
+					held[h] = true
+				}
+				for _, name := range nodes {
+					if !held[name] {
+						return fmt.Errorf("%s does not hold %s", name, key)
+					}
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// ExpectServed checks the node sent the content for a key to exactly the given peers
+func ExpectServed(from, key string, to ...string) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %s served %s to %v", from, key, to),
+		Run: func(ctx context.Context, n *Network) error {
+			want := append([]string(nil), to...)
+			sort.Strings(want)
+			return poll(ctx, func() error {
+				got := n.Served(from, key)
+				if len(got) == 0 && len(want) == 0 {
+					return nil
+				}
+				if !reflect.DeepEqual(got, want) {
+					return fmt.Errorf("%s served %s to %v", from, key, got)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// ExpectContent checks a node can read back every byte of the content for a key
+func ExpectContent(node, key string) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %s to read %s", node, key),
+		Run: func(ctx context.Context, n *Network) error {
+			nd, ok := n.Node(node)
+			if !ok {
+				return fmt.Errorf("unknown node %s", node)
+			}
+			n.mu.Lock()
+			c, ok := n.content[key]
+			n.mu.Unlock()
+			if !ok {
+				return fmt.Errorf("unknown content %s", key)
+			}
+			nd.tn.VerifyFileTransferred(ctx, n.t, nd.tn.DAG, c.root, c.bytes)
+			return nil
+		},
+	}
+}
+
+// poll retries the check until it succeeds or the context is done in which case the last error is returned
+func poll(ctx context.Context, check func() error) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
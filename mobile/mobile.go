@@ -0,0 +1,130 @@
+// Package mobile exposes a pop node to iOS and Android apps. It only uses types supported by gomobile
+// and can be bound with:
+//
+//	gomobile bind -target=android github.com/myelnet/pop/mobile
+//	gomobile bind -target=ios github.com/myelnet/pop/mobile
+package mobile
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+)
+
+// Notifier receives the JSON encoded notifications of the node such as the results of each command
+type Notifier interface {
+	OnNotify(msg []byte)
+}
+
+// Config is the configuration of a mobile node
+type Config struct {
+	// RepoPath is where the node stores its data, usually in the app's files directory
+	RepoPath string
+	// BootstrapPeers is a list of peer addresses to connect with separated by commas
+	BootstrapPeers string
+	// Regions is a list of region names separated by commas
+	Regions string
+	// Capacity is the storage space in bytes the node may use to cache content
+	Capacity int64
+	// MaxPPB is the maximum price per byte we accept to pay for retrievals
+	MaxPPB int64
+}
+
+// NewConfig returns a configuration with defaults suited to phones
+func NewConfig(repoPath string) *Config {
+	return &Config{
+		RepoPath: repoPath,
+		Regions:  "Global",
+		Capacity: 1 << 30,
+		MaxPPB:   5,
+	}
+}
+
+// Node is a pop node embedded in an app
+type Node struct {
+	e *node.Embedded
+}
+
+// Start starts a node with a reduced footprint. Notifications are sent to the notifier.
+func Start(cfg *Config, n Notifier) (*Node, error) {
+	opts := node.Options{
+		RepoPath:       cfg.RepoPath,
+		BootstrapPeers: split(cfg.BootstrapPeers),
+		Regions:        split(cfg.Regions),
+		Capacity:       uint64(cfg.Capacity),
+		MaxPPB:         cfg.MaxPPB,
+		Mobile:         true,
+	}
+	e, err := node.Embed(opts, n.OnNotify)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{e: e}, nil
+}
+
+// Send executes a JSON encoded command. The result is sent to the notifier.
+func (n *Node) Send(cmd []byte) error {
+	return n.e.Send(cmd)
+}
+
+// Get retrieves the content for the given CID and writes it to the out path
+func (n *Node) Get(cid string, out string) error {
+	return n.send(node.Command{Get: &node.GetArgs{
+		Cid: cid,
+		Out: out,
+	}})
+}
+
+// Put adds a file to the current transaction
+func (n *Node) Put(path string) error {
+	return n.send(node.Command{Put: &node.PutArgs{
+		Path: path,
+	}})
+}
+
+// Ping sends our node info or pings a peer if an address is given
+func (n *Node) Ping(addr string) error {
+	return n.send(node.Command{Ping: &node.PingArgs{
+		Addr: addr,
+	}})
+}
+
+// Pay sends FIL from the default address of the wallet to the given address
+func (n *Node) Pay(to string, amount string) error {
+	return n.send(node.Command{WalletPay: &node.WalletPayArgs{
+		To:     to,
+		Amount: amount,
+	}})
+}
+
+// SetPowerSaving pauses background replication. Call it when the device switches to battery or
+// a metered connection and again once it is charging on wifi.
+func (n *Node) SetPowerSaving(on bool) {
+	n.e.SetPowerSaving(on)
+}
+
+// Background persists everything kept in memory. Call it when the app moves to the background.
+func (n *Node) Background() error {
+	return n.e.Flush()
+}
+
+// Stop shuts the node down and closes the repo
+func (n *Node) Stop() error {
+	return n.e.Close()
+}
+
+func (n *Node) send(cmd node.Command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return n.e.Send(b)
+}
+
+func split(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
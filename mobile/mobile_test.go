@@ -0,0 +1,107 @@
+package mobile
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/stretchr/testify/require"
+)
+
+// notifier collects the notifications of the node
+type notifier chan node.Notify
+
+func (n notifier) OnNotify(msg []byte) {
+	var notif node.Notify
+	if err := json.Unmarshal(msg, &notif); err != nil {
+		return
+	}
+	// never block the node if the test stopped reading
+	select {
+	case n <- notif:
+	default:
+	}
+}
+
+// next waits for a notification matching the filter
+func (n notifier) next(t *testing.T, match func(node.Notify) bool) node.Notify {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case notif := <-n:
+			if match(notif) {
+				return notif
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for notification")
+		}
+	}
+}
+
+func startNode(t *testing.T) (*Node, notifier) {
+	notifs := make(notifier, 16)
+	nd, err := Start(NewConfig(t.TempDir()), notifs)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, nd.Stop())
+	})
+	return nd, notifs
+}
+
+func TestPay(t *testing.T) {
+	nd, notifs := startNode(t)
+
+	require.NoError(t, nd.Pay("notanaddress", "0.1"))
+	res := notifs.next(t, func(n node.Notify) bool { return n.WalletResult != nil })
+	require.Contains(t, res.WalletResult.Err, "notanaddress")
+}
+
+func TestNewConfig(t *testing.T) {
+	cfg := NewConfig("/data/pop")
+	require.Equal(t, "/data/pop", cfg.RepoPath)
+	require.Equal(t, []string{"Global"}, split(cfg.Regions))
+	require.Nil(t, split(cfg.BootstrapPeers))
+	require.Equal(t, []string{"Europe", "NorthAmerica"}, split("Europe,NorthAmerica"))
+}
+
+func TestSend(t *testing.T) {
+	nd, notifs := startNode(t)
+	isPing := func(n node.Notify) bool { return n.PingResult != nil }
+
+	require.NoError(t, nd.Ping(""))
+	res := notifs.next(t, isPing)
+	require.Equal(t, "", res.PingResult.Err)
+	require.NotEqual(t, "", res.PingResult.ID)
+
+	// raw JSON commands get the same results as the typed methods
+	require.NoError(t, nd.Send([]byte(`{"Ping":{"Addr":""}}`)))
+	raw := notifs.next(t, isPing)
+	require.Equal(t, res.PingResult.ID, raw.PingResult.ID)
+
+	require.Error(t, nd.Send([]byte(`{"Ping":`)))
+	require.Error(t, nd.Send([]byte(`{}`)))
+
+	nd.SetPowerSaving(true)
+	nd.SetPowerSaving(false)
+}
+
+func TestRestart(t *testing.T) {
+	repo := t.TempDir()
+	notifs := make(notifier, 16)
+	isPing := func(n node.Notify) bool { return n.PingResult != nil }
+
+	nd, err := Start(NewConfig(repo), notifs)
+	require.NoError(t, err)
+	require.NoError(t, nd.Ping(""))
+	id := notifs.next(t, isPing).PingResult.ID
+	require.NoError(t, nd.Background())
+	require.NoError(t, nd.Stop())
+
+	// the app restarts the node from the same repo with the same identity
+	nd, err = Start(NewConfig(repo), notifs)
+	require.NoError(t, err)
+	defer nd.Stop()
+	require.NoError(t, nd.Ping(""))
+	require.Equal(t, id, notifs.next(t, isPing).PingResult.ID)
+}
@@ -0,0 +1,322 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/retrieval"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+	"github.com/rs/zerolog/log"
+)
+
+// Accounts can be aggregated by peer or by content root
+const (
+	AccountByPeer = "peer"
+	AccountByCID  = "cid"
+)
+
+// ErrInvalidAccountKind is returned when requesting accounts aggregated by something else than peer or cid
+var ErrInvalidAccountKind = errors.New("accounts can only be listed by peer or cid")
+
+// Account is the total FIL spent on retrievals and earned from serving for a single peer or content root
+type Account struct {
+	Key    string
+	Spent  abi.TokenAmount
+	Earned abi.TokenAmount
+}
+
+// Net returns what we earned minus what we spent
+func (a Account) Net() abi.TokenAmount {
+	return big.Sub(a.Earned, a.Spent)
+}
+
+// Accounting aggregates the funds spent and earned in retrieval deals per peer and per content root
+// so operators can tell whether serving content is profitable. Totals are persisted on every update
+// along with what we already recorded for the deals in progress so a restart doesn't count them twice.
+type Accounting struct {
+	ds datastore.Batching
+
+	mu sync.Mutex
+}
+
+// NewAccounting creates a new Accounting persisting the totals in the given datastore
+func NewAccounting(ds datastore.Batching) *Accounting {
+	return &Accounting{
+		ds: namespace.Wrap(ds, datastore.NewKey("/accounting")),
+	}
+}
+
+// keys of the amounts already recorded for the deals in progress
+func earnedKey(id deal.ProviderDealIdentifier) datastore.Key {
+	return datastore.NewKey("/progress/earned").ChildString(id.String())
+}
+
+func spentKey(id deal.ID) datastore.Key {
+	return datastore.NewKey("/progress/spent").ChildString(id.String())
+}
+
+func accountKey(kind, key string) datastore.Key {
+	return datastore.NewKey(kind).ChildString(key)
+}
+
+func (a *Accounting) get(kind, key string) (Account, error) {
+	b, err := a.ds.Get(accountKey(kind, key))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return Account{Key: key, Spent: big.Zero(), Earned: big.Zero()}, nil
+	}
+	if err != nil {
+		return Account{}, err
+	}
+	var acc Account
+	if err := json.Unmarshal(b, &acc); err != nil {
+		return Account{}, err
+	}
+	return acc, nil
+}
+
+// add increases the totals of the account for the given kind and key. Must hold the lock.
+func (a *Accounting) add(kind, key string, spent, earned abi.TokenAmount) error {
+	acc, err := a.get(kind, key)
+	if err != nil {
+		return err
+	}
+	acc.Spent = big.Add(acc.Spent, spent)
+	acc.Earned = big.Add(acc.Earned, earned)
+	b, err := json.Marshal(acc)
+	if err != nil {
+		return err
+	}
+	return a.ds.Put(accountKey(kind, key), b)
+}
+
+// record adds the amounts to both the peer and the content root accounts. Must hold the lock.
+func (a *Accounting) record(p peer.ID, root cid.Cid, spent, earned abi.TokenAmount) {
+	if err := a.add(AccountByPeer, p.String(), spent, earned); err != nil {
+		log.Error().Err(err).Str("peer", p.String()).Msg("failed to record account")
+	}
+	if !root.Defined() {
+		return
+	}
+	if err := a.add(AccountByCID, root.String(), spent, earned); err != nil {
+		log.Error().Err(err).Str("root", root.String()).Msg("failed to record account")
+	}
+}
+
+// recorded returns the total already recorded for a deal in progress. Must hold the lock.
+func (a *Accounting) recorded(k datastore.Key) abi.TokenAmount {
+	b, err := a.ds.Get(k)
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			log.Error().Err(err).Str("key", k.String()).Msg("failed to get recorded amount")
+		}
+		return big.Zero()
+	}
+	var amt abi.TokenAmount
+	if err := json.Unmarshal(b, &amt); err != nil {
+		return big.Zero()
+	}
+	return amt
+}
+
+// setRecorded persists the total recorded for a deal until it is final. Must hold the lock.
+func (a *Accounting) setRecorded(k datastore.Key, total abi.TokenAmount, final bool) {
+	var err error
+	if final {
+		err = a.ds.Delete(k)
+	} else {
+		var b []byte
+		b, err = json.Marshal(total)
+		if err == nil {
+			err = a.ds.Put(k, b)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", k.String()).Msg("failed to set recorded amount")
+	}
+}
+
+// progress returns the amount added since the last update given the total for a deal
+func progress(prev, total abi.TokenAmount) abi.TokenAmount {
+	if total.Nil() || !total.GreaterThan(prev) {
+		return big.Zero()
+	}
+	return big.Sub(total, prev)
+}
+
+// isFinalStatus returns whether a deal won't be updated anymore
+func isFinalStatus(s deal.Status) bool {
+	switch s {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored:
+		return true
+	default:
+		return false
+	}
+}
+
+// RecordProviderDeal adds the funds received since the last update of a retrieval deal we are providing
+func (a *Accounting) RecordProviderDeal(state deal.ProviderState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := earnedKey(state.Identifier())
+	prev := a.recorded(k)
+	if earned := progress(prev, state.FundsReceived); !earned.IsZero() {
+		a.record(state.Receiver, state.PayloadCID, big.Zero(), earned)
+		prev = state.FundsReceived
+	}
+	a.setRecorded(k, prev, isFinalStatus(state.Status))
+}
+
+// RecordClientDeal adds the funds spent since the last update of a retrieval deal we are a client of
+func (a *Accounting) RecordClientDeal(state deal.ClientState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := spentKey(state.ID)
+	prev := a.recorded(k)
+	if spent := progress(prev, state.FundsSpent); !spent.IsZero() {
+		a.record(state.Sender, state.PayloadCID, spent, big.Zero())
+		prev = state.FundsSpent
+	}
+	a.setRecorded(k, prev, isFinalStatus(state.Status))
+}
+
+// List returns all the accounts of the given kind, most profitable first
+func (a *Accounting) List(kind string) ([]Account, error) {
+	if kind != AccountByPeer && kind != AccountByCID {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAccountKind, kind)
+	}
+	res, err := a.ds.Query(query.Query{Prefix: datastore.NewKey(kind).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var accs []Account
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var acc Account
+		if err := json.Unmarshal(r.Value, &acc); err != nil {
+			return nil, err
+		}
+		accs = append(accs, acc)
+	}
+	sort.Slice(accs, func(i, j int) bool {
+		return accs[i].Net().GreaterThan(accs[j].Net())
+	})
+	return accs, nil
+}
+
+// Earnings sends the funds spent and earned per peer or content root
+func (nd *node) Earnings(ctx context.Context, args *EarningsArgs) {
+	by := args.By
+	if by == "" {
+		by = AccountByPeer
+	}
+	accs, err := nd.accounts.List(by)
+	if err != nil {
+		nd.send(Notify{EarningsResult: &EarningsResult{
+			Err: err.Error(),
+		}})
+		return
+	}
+	spent, earned := big.Zero(), big.Zero()
+	res := &EarningsResult{}
+	for _, acc := range accs {
+		spent = big.Add(spent, acc.Spent)
+		earned = big.Add(earned, acc.Earned)
+		res.Accounts = append(res.Accounts, AccountResult{
+			Key:    acc.Key,
+			Spent:  filecoin.FIL(acc.Spent).Short(),
+			Earned: filecoin.FIL(acc.Earned).Short(),
+			Net:    filecoin.FIL(acc.Net()).Short(),
+		})
+	}
+	res.Spent = filecoin.FIL(spent).Short()
+	res.Earned = filecoin.FIL(earned).Short()
+	res.Net = filecoin.FIL(big.Sub(earned, spent)).Short()
+	nd.send(Notify{EarningsResult: res})
+}
+
+// UsageByWallet aggregates client bandwidth usage by paying wallet
+const UsageByWallet = "wallet"
+
+// Usage sends the bandwidth each client used retrieving from us so operators can enforce
+// fair-use or invoice them. If Reset is set the records for that peer are cleared after being sent.
+func (nd *node) Usage(ctx context.Context, args *UsageArgs) {
+	ut := nd.exch.Retrieval().Provider().Usage()
+	var list []retrieval.Usage
+	var err error
+	switch args.By {
+	case "", AccountByPeer:
+		list, err = ut.List()
+	case UsageByWallet:
+		var byw map[address.Address]retrieval.Usage
+		byw, err = ut.ByWallet()
+		for _, u := range byw {
+			list = append(list, u)
+		}
+	default:
+		err = fmt.Errorf("usage can only be listed by %s or %s", AccountByPeer, UsageByWallet)
+	}
+	if err != nil {
+		nd.send(Notify{UsageResult: &UsageResult{
+			Err: err.Error(),
+		}})
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TotalSent > list[j].TotalSent
+	})
+	res := &UsageResult{}
+	for _, u := range list {
+		rec := UsageRecord{
+			TotalSent:  u.TotalSent,
+			WindowSent: u.WindowSent,
+			Deals:      u.Deals,
+			LastSeen:   u.LastSeen,
+		}
+		if u.Peer != "" {
+			rec.Peer = u.Peer.String()
+		}
+		if u.Wallet != address.Undef {
+			rec.Wallet = u.Wallet.String()
+		}
+		res.Clients = append(res.Clients, rec)
+	}
+	if args.Reset != "" {
+		p, err := peer.Decode(args.Reset)
+		if err == nil {
+			err = ut.Reset(p)
+		}
+		if err != nil {
+			res.Err = fmt.Sprintf("failed to reset usage: %v", err)
+		}
+	}
+	nd.send(Notify{UsageResult: res})
+}
+
+// earningsSubscriber records the funds received in the deals we provide
+func (nd *node) earningsSubscriber(event provider.Event, state deal.ProviderState) {
+	nd.accounts.RecordProviderDeal(state)
+}
+
+// spendingSubscriber records the funds spent in the deals we are a client of
+func (nd *node) spendingSubscriber(event client.Event, state deal.ClientState) {
+	nd.accounts.RecordClientDeal(state)
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin/storage"
+	"github.com/myelnet/pop/internal/alert"
+	"github.com/myelnet/pop/payments"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultDiskWatermark is the fraction of the storage capacity after which we alert the operator
+const DefaultDiskWatermark = 0.9
+
+// alertInterval is how often we check for events to alert about
+const alertInterval = time.Minute
+
+// reapInterval is how often we delete the stores left behind by failed transfers
+const reapInterval = time.Hour
+
+// settlementWarning is how many epochs before an inbound channel settles we alert about it
+const settlementWarning = abi.ChainEpoch(builtin.EpochsInDay)
+
+// monitor periodically checks the state of the node, sends alerts when something needs attention
+// records metrics and reaps orphaned stores
+func (nd *node) monitor(ctx context.Context) {
+	ticker := time.NewTicker(alertInterval)
+	defer ticker.Stop()
+	reap := time.NewTicker(reapInterval)
+	defer reap.Stop()
+	for {
+		select {
+		case <-reap.C:
+			nd.reapStores()
+		case <-ticker.C:
+			nd.checkDisk(ctx)
+			nd.checkFilecoin(ctx)
+			nd.recordStats()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDisk alerts when the storage used is over the watermark
+func (nd *node) checkDisk(ctx context.Context) {
+	used, capacity := nd.exch.Index().Usage()
+	if capacity == 0 {
+		return
+	}
+	watermark := nd.opts.DiskWatermark
+	if watermark == 0 {
+		watermark = DefaultDiskWatermark
+	}
+	ratio := float64(used) / float64(capacity)
+	if ratio >= watermark {
+		nd.alerts.Notify(ctx, alert.Warning, alert.EventDiskWatermark,
+			fmt.Sprintf("storage is %.0f%% full (%d/%d bytes)", ratio*100, used, capacity))
+		return
+	}
+	nd.alerts.Resolve(ctx, alert.EventDiskWatermark, fmt.Sprintf("storage is back to %.0f%% full", ratio*100))
+}
+
+// checkFilecoin alerts when the Filecoin RPC cannot be reached and about inbound payment channels
+// which will settle soon
+func (nd *node) checkFilecoin(ctx context.Context) {
+	api := nd.exch.FilecoinAPI()
+	if api == nil {
+		return
+	}
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		nd.alerts.Notify(ctx, alert.Critical, alert.EventFilecoinRPC,
+			fmt.Sprintf("failed to reach filecoin rpc: %v", err))
+		return
+	}
+	nd.alerts.Resolve(ctx, alert.EventFilecoinRPC, "filecoin rpc is back online")
+
+	pay := nd.exch.Payments()
+	if pay == nil {
+		return
+	}
+	chans, err := pay.ListChannels()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list payment channels")
+		return
+	}
+	var settling []string
+	for _, ch := range chans {
+		info, err := pay.GetChannelInfo(ch)
+		if err != nil {
+			continue
+		}
+		if info.Direction != payments.DirInbound || !info.Settling {
+			continue
+		}
+		if info.SettlingAt-head.Height() <= settlementWarning {
+			settling = append(settling, fmt.Sprintf("%s (epoch %d)", ch, info.SettlingAt))
+		}
+	}
+	if len(settling) > 0 {
+		nd.alerts.Notify(ctx, alert.Critical, alert.EventSettlement,
+			fmt.Sprintf("vouchers must be submitted before inbound channels settle: %s", strings.Join(settling, ", ")))
+		return
+	}
+	nd.alerts.Resolve(ctx, alert.EventSettlement, "no inbound channels settling soon")
+}
+
+// checkReplication alerts when a commit was dispatched to fewer caches than requested
+func (nd *node) checkReplication(ctx context.Context, root string, caches int, rf int) {
+	if rf == 0 || caches >= rf {
+		return
+	}
+	nd.alerts.Notify(ctx, alert.Warning, alert.EventReplication,
+		fmt.Sprintf("%s was only dispatched to %d out of %d caches", root, caches, rf))
+}
+
+// replicationSubscriber alerts the operator when content we published loses a replica and is dispatched
+// to replacement caches
+func (nd *node) replicationSubscriber(event exchange.ReplicationEvent, state exchange.ReplicationState) {
+	if event != exchange.ReplicationEventReplicaLost {
+		return
+	}
+	nd.alerts.Notify(context.TODO(), alert.Warning, alert.EventReplication,
+		fmt.Sprintf("%s lost the replica held by %s (%s), dispatching to new caches", state.PayloadCID, state.Peer, state.Message))
+}
+
+// repairSubscriber replaces the storage deals which are slashed or terminated and alerts the operator
+func (nd *node) repairSubscriber(ctx context.Context) storage.DealSubscriber {
+	return func(rec storage.DealRecord) {
+		if !storage.NeedsRepair(rec) {
+			return
+		}
+		lost := fmt.Sprintf("deal %d storing %s with %s was lost (%s)", rec.DealID, rec.Root, rec.Miner, rec.Message)
+		if nd.repair == nil {
+			nd.alerts.Notify(ctx, alert.Critical, alert.EventDealRepair, lost+", automatic repair is disabled")
+			return
+		}
+		go func() {
+			repl, err := nd.repair.Repair(ctx, rec)
+			if err != nil {
+				nd.alerts.Notify(ctx, alert.Critical, alert.EventDealRepair, fmt.Sprintf("%s, failed to repair: %v", lost, err))
+				return
+			}
+			nd.alerts.Notify(ctx, alert.Warning, alert.EventDealRepair,
+				fmt.Sprintf("%s, proposed a replacement deal to %s", lost, repl.Miner))
+			info := dealInfo(*repl)
+			nd.send(Notify{DealEvent: &info})
+		}()
+	}
+}
@@ -0,0 +1,85 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ErrInvalidBlockTarget is returned when a block target is neither a peer ID nor a CIDR subnet
+var ErrInvalidBlockTarget = errors.New("invalid peer ID or subnet")
+
+// Block adds or removes peers and subnets from the connection gater and returns the resulting lists.
+// The gater persists them in our datastore so they survive restarts.
+func (nd *node) Block(ctx context.Context, args *BlockArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			BlockResult: &BlockResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	for _, t := range args.Targets {
+		var err error
+		if p, perr := peer.Decode(t); perr == nil {
+			if args.Unblock {
+				err = nd.gater.UnblockPeer(p)
+			} else {
+				err = nd.blockPeer(p)
+			}
+		} else if _, ipnet, nerr := net.ParseCIDR(t); nerr == nil {
+			if args.Unblock {
+				err = nd.gater.UnblockSubnet(ipnet)
+			} else {
+				err = nd.blockSubnet(ipnet)
+			}
+		} else {
+			err = ErrInvalidBlockTarget
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	res := &BlockResult{}
+	for _, p := range nd.gater.ListBlockedPeers() {
+		res.Peers = append(res.Peers, p.String())
+	}
+	for _, ipnet := range nd.gater.ListBlockedSubnets() {
+		res.Subnets = append(res.Subnets, ipnet.String())
+	}
+	sort.Strings(res.Peers)
+	sort.Strings(res.Subnets)
+	nd.send(Notify{
+		BlockResult: res,
+	})
+}
+
+// blockPeer blocks a peer and closes the connections we already have with it
+func (nd *node) blockPeer(p peer.ID) error {
+	if err := nd.gater.BlockPeer(p); err != nil {
+		return err
+	}
+	return nd.host.Network().ClosePeer(p)
+}
+
+// blockSubnet blocks a subnet and closes the connections we already have with addresses in it
+func (nd *node) blockSubnet(ipnet *net.IPNet) error {
+	if err := nd.gater.BlockSubnet(ipnet); err != nil {
+		return err
+	}
+	for _, c := range nd.host.Network().Conns() {
+		ip, err := manet.ToIP(c.RemoteMultiaddr())
+		if err != nil {
+			continue
+		}
+		if ipnet.Contains(ip) {
+			c.Close()
+		}
+	}
+	return nil
+}
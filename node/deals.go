@@ -0,0 +1,533 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/filecoin/storage"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoStorage is returned when the node isn't connected to a Filecoin API and cannot make storage deals
+var ErrNoStorage = errors.New("no Filecoin API to make storage deals")
+
+// defaultDealDuration is how long miners store content when no duration is given, the minimum deal duration
+const defaultDealDuration = 180 * 24 * time.Hour
+
+// minerFallbackDelay is how long we wait for a cache to offer the content before querying the storage miners
+const minerFallbackDelay = 5 * time.Second
+
+// DealList sends the storage deals we are tracking, most recent first
+func (nd *node) DealList(ctx context.Context, args *DealListArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{DealResult: &DealResult{Err: err.Error()}})
+	}
+	if nd.deals == nil {
+		sendErr(ErrNoStorage)
+		return
+	}
+	recs, err := nd.deals.List()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &DealResult{}
+	for _, rec := range recs {
+		if args.Status != "" && string(rec.Status) != args.Status {
+			continue
+		}
+		res.Deals = append(res.Deals, dealInfo(rec))
+	}
+	nd.send(Notify{DealResult: res})
+}
+
+// DealProposals sends the deal proposals we sent matching the args, most recent first, along with
+// the outcomes per miner so users can find which miners routinely reject their deals
+func (nd *node) DealProposals(ctx context.Context, args *DealProposalsArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{ProposalResult: &ProposalResult{Err: err.Error()}})
+	}
+	if nd.proposals == nil {
+		sendErr(ErrNoStorage)
+		return
+	}
+	var f storage.ProposalFilter
+	if args.Miner != "" {
+		addr, err := address.NewFromString(args.Miner)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		f.Miner = addr
+	}
+	if args.Ref != "" {
+		root, err := cid.Decode(nd.resolveName(args.Ref))
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		f.Root = root
+	}
+	f.Outcome = storage.ProposalOutcome(args.Outcome)
+
+	recs, err := nd.proposals.List(f)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	stats, err := nd.proposals.MinerStats()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &ProposalResult{}
+	for _, rec := range recs {
+		pi := ProposalInfo{
+			Root:       rec.Root.String(),
+			Miner:      rec.Miner.String(),
+			Price:      filecoin.FIL(rec.Price).Short(),
+			PieceSize:  filecoin.SizeStr(filecoin.NewInt(uint64(rec.PieceSize))),
+			StartEpoch: int64(rec.StartEpoch),
+			EndEpoch:   int64(rec.EndEpoch),
+			Verified:   rec.Verified,
+			Outcome:    string(rec.Outcome),
+			Message:    rec.Message,
+			Created:    rec.Created,
+		}
+		if rec.ProposalCid.Defined() {
+			pi.ProposalCid = rec.ProposalCid.String()
+		}
+		res.Proposals = append(res.Proposals, pi)
+	}
+	for _, st := range stats {
+		if f.Miner != address.Undef && st.Miner != f.Miner {
+			continue
+		}
+		res.Miners = append(res.Miners, MinerProposalInfo{
+			Miner:      st.Miner.String(),
+			Accepted:   st.Accepted,
+			Rejected:   st.Rejected,
+			Errored:    st.Errored,
+			Failed:     st.Failed,
+			RejectRate: st.RejectRate(),
+		})
+	}
+	nd.send(Notify{ProposalResult: res})
+}
+
+// DealPush queues online storage deals for the content. The miners and the prices offered to them are
+// given explicitly or the miners are selected and quoted on the spot so pushes don't depend on any state
+// held by the daemon. The queue spreads the proposals over time and miners and retries the failed ones.
+// Multiple refs are aggregated in a single piece so small commits share the cost of the deals.
+func (nd *node) DealPush(ctx context.Context, args *DealPushArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{DealResult: &DealResult{Err: err.Error()}})
+	}
+	if nd.queue == nil {
+		sendErr(ErrNoStorage)
+		return
+	}
+	if len(args.Prices) > 0 && len(args.Prices) != len(args.Miners) {
+		sendErr(errors.New("expected a price for each miner"))
+		return
+	}
+	roots := make([]cid.Cid, 0, len(args.Refs)+1)
+	for _, r := range append([]string{args.Ref}, args.Refs...) {
+		root, err := cid.Decode(nd.resolveName(r))
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		roots = append(roots, root)
+	}
+
+	var miners []address.Address
+	prices := make([]*abi.TokenAmount, len(args.Miners))
+	for i, m := range args.Miners {
+		addr, err := address.NewFromString(m)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if len(args.Prices) > 0 {
+			price, err := filecoin.ParseFIL(args.Prices[i])
+			if err != nil {
+				sendErr(fmt.Errorf("invalid price for miner %s: %w", addr, err))
+				return
+			}
+			p := abi.TokenAmount(price)
+			prices[i] = &p
+		}
+		miners = append(miners, addr)
+	}
+	dur := args.Duration
+	if dur == 0 {
+		dur = defaultDealDuration
+	}
+	if len(miners) == 0 {
+		if args.MaxPrice == "" {
+			sendErr(errors.New("no miners given and no max price to select them"))
+			return
+		}
+		maxPrice, err := filecoin.ParseFIL(args.MaxPrice)
+		if err != nil {
+			sendErr(fmt.Errorf("invalid max price: %w", err))
+			return
+		}
+		rf := args.RF
+		if rf <= 0 {
+			rf = 1
+		}
+		sel, err := nd.storage.LoadMiners(ctx, storage.MinerSelectionParams{
+			RF:       rf,
+			MaxPrice: abi.TokenAmount(maxPrice).Uint64(),
+			Region:   args.Region,
+			Verified: args.Verified,
+			Policies: dealPolicies(args, dur),
+		})
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if len(sel) == 0 {
+			sendErr(errors.New("no miners fit those parameters"))
+			return
+		}
+		for _, m := range sel {
+			miners = append(miners, m.Info.Address)
+			prices = append(prices, nil)
+		}
+	}
+
+	// miners need the piece commitment in the proposal, the data itself is pushed over graphsync
+	var ref *storagemarket.DataRef
+	var agg *storage.Aggregate
+	var err error
+	if len(roots) == 1 {
+		ref, err = storage.GeneratePiece(ctx, nd.dag, roots[0], io.Discard)
+	} else {
+		agg, err = storage.AggregatePieces(ctx, nd.dag, roots, io.Discard)
+		if agg != nil {
+			ref = agg.Ref
+		}
+	}
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	res := &DealResult{}
+	if agg != nil {
+		if err := nd.deals.TrackAggregate(agg); err != nil {
+			sendErr(err)
+			return
+		}
+		res.Aggregate = agg.Root.String()
+	}
+	for i, m := range miners {
+		job, err := nd.queue.Enqueue(storage.DealJob{
+			Root:      ref.Root,
+			Miner:     m,
+			Client:    nd.exch.Wallet().DefaultAddress(),
+			Price:     prices[i],
+			PieceCid:  *ref.PieceCid,
+			PieceSize: ref.PieceSize,
+			Duration:  dur,
+			Verified:  args.Verified,
+			DealParams: storage.DealParams{
+				FastRetrieval:        args.FastRetrieval,
+				StartBuffer:          args.StartBuffer,
+				StartEpoch:           abi.ChainEpoch(args.StartEpoch),
+				CollateralMultiplier: args.CollateralMultiplier,
+			},
+		})
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		res.Jobs = append(res.Jobs, jobInfo(job))
+	}
+	nd.send(Notify{DealResult: res})
+}
+
+// dealPolicies returns the policies selecting the miners for a deal push in addition to the max price
+func dealPolicies(args *DealPushArgs, dur time.Duration) []storage.MinerPolicy {
+	var policies []storage.MinerPolicy
+	if args.SectorLifetime {
+		policies = append(policies, storage.MinSectorLifetime(dur))
+	}
+	if args.MinReputation > 0 {
+		policies = append(policies, storage.MinReputation(args.MinReputation))
+	}
+	if len(args.Regions) > 0 {
+		policies = append(policies, storage.InRegions(args.Regions...))
+	}
+	if args.MaxLatency > 0 {
+		policies = append(policies, storage.MaxLatency(args.MaxLatency))
+	}
+	if args.DistinctOwners {
+		policies = append(policies, storage.DistinctOwners())
+	}
+	return policies
+}
+
+// DealQueue sends the storage deals waiting to be proposed or retried, oldest first
+func (nd *node) DealQueue(ctx context.Context, args *DealQueueArgs) {
+	if nd.queue == nil {
+		nd.send(Notify{DealResult: &DealResult{Err: ErrNoStorage.Error()}})
+		return
+	}
+	jobs, err := nd.queue.List()
+	if err != nil {
+		nd.send(Notify{DealResult: &DealResult{Err: err.Error()}})
+		return
+	}
+	res := &DealResult{}
+	for _, job := range jobs {
+		if !args.All && (job.Status == storage.JobDone || job.Status == storage.JobFailed) {
+			continue
+		}
+		res.Jobs = append(res.Jobs, jobInfo(job))
+	}
+	nd.send(Notify{DealResult: res})
+}
+
+func jobInfo(job storage.DealJob) DealJobInfo {
+	info := DealJobInfo{
+		ID:          job.ID,
+		Root:        job.Root.String(),
+		Miner:       job.Miner.String(),
+		Status:      string(job.Status),
+		Attempts:    job.Attempts,
+		Err:         job.Err,
+		NextAttempt: job.NextAttempt,
+	}
+	if job.Proposal.Defined() {
+		info.ProposalCid = job.Proposal.String()
+	}
+	return info
+}
+
+// DealOffline writes the content as a CAR and proposes offline deals for its piece to the given miners. The CAR
+// is delivered out-of-band and imported by each miner so very large commits don't need an online transfer.
+// Multiple refs are aggregated in a single piece so small commits share the cost of the deals.
+func (nd *node) DealOffline(ctx context.Context, args *DealOfflineArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{OfflineResult: &OfflineDealResult{Err: err.Error()}})
+	}
+	if nd.storage == nil {
+		sendErr(ErrNoStorage)
+		return
+	}
+	if args.Out == "" {
+		sendErr(errors.New("no output path for the CAR"))
+		return
+	}
+	if len(args.Miners) == 0 {
+		sendErr(errors.New("no miners to propose deals to"))
+		return
+	}
+	if len(args.Refs) == 0 {
+		sendErr(errors.New("no content to store"))
+		return
+	}
+	roots := make([]cid.Cid, 0, len(args.Refs))
+	for _, r := range args.Refs {
+		root, err := cid.Decode(nd.resolveName(r))
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		roots = append(roots, root)
+	}
+
+	miners := make([]storage.Miner, 0, len(args.Miners))
+	for _, m := range args.Miners {
+		addr, err := address.NewFromString(m)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		miner, err := nd.storage.LoadMiner(ctx, addr)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to load miner %s: %w", addr, err))
+			return
+		}
+		miners = append(miners, miner)
+	}
+
+	f, err := os.Create(args.Out)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer f.Close()
+
+	var ref *storagemarket.DataRef
+	var agg *storage.Aggregate
+	if len(roots) == 1 {
+		ref, err = storage.GeneratePiece(ctx, nd.dag, roots[0], f)
+	} else {
+		agg, err = storage.AggregatePieces(ctx, nd.dag, roots, f)
+		if agg != nil {
+			ref = agg.Ref
+		}
+	}
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	dur := args.Duration
+	if dur == 0 {
+		dur = defaultDealDuration
+	}
+	receipt, err := nd.storage.Store(ctx, storage.Params{
+		Payload:  ref,
+		Duration: dur,
+		Address:  nd.exch.Wallet().DefaultAddress(),
+		Miners:   miners,
+		Verified: args.Verified,
+		DealParams: storage.DealParams{
+			FastRetrieval: true,
+		},
+	})
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if len(receipt.Deals) == 0 {
+		sendErr(ErrAllDealsFailed)
+		return
+	}
+	if err := nd.deals.Track(receipt.Deals...); err != nil {
+		sendErr(err)
+		return
+	}
+	if agg != nil {
+		if err := nd.deals.TrackAggregate(agg); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+
+	res := &OfflineDealResult{
+		PieceCID:  ref.PieceCid.String(),
+		PieceSize: filecoin.SizeStr(filecoin.NewInt(uint64(ref.PieceSize.Padded()))),
+		Car:       args.Out,
+	}
+	if agg != nil {
+		res.Aggregate = agg.Root.String()
+		for _, e := range agg.Entries {
+			res.Payloads = append(res.Payloads, PayloadInfo{
+				Root:   e.Root.String(),
+				Offset: e.Offset,
+				Size:   filecoin.SizeStr(filecoin.NewInt(e.Size)),
+			})
+		}
+	}
+	for _, rec := range receipt.Deals {
+		res.Deals = append(res.Deals, dealInfo(rec))
+		res.Imports = append(res.Imports, storage.ImportCommand(rec.ProposalCid, args.Out))
+	}
+	nd.send(Notify{OfflineResult: res})
+}
+
+// dealSubscriber notifies the connected clients whenever a storage deal changes status
+func (nd *node) dealSubscriber(rec storage.DealRecord) {
+	info := dealInfo(rec)
+	nd.send(Notify{DealEvent: &info})
+}
+
+func dealInfo(rec storage.DealRecord) DealInfo {
+	return DealInfo{
+		ProposalCid: rec.ProposalCid.String(),
+		Root:        rec.Root.String(),
+		Miner:       rec.Miner.String(),
+		DealID:      uint64(rec.DealID),
+		Status:      string(rec.Status),
+		Message:     rec.Message,
+		StartEpoch:  int64(rec.StartEpoch),
+		EndEpoch:    int64(rec.EndEpoch),
+		Updated:     rec.Updated,
+	}
+}
+
+// unsealAllowed returns whether we accept to pay the unseal price of an offer given the max price in attoFIL,
+// 0 only accepts free unsealing and a negative max means no limit
+func unsealAllowed(offer deal.Offer, max int64) bool {
+	if max < 0 || offer.UnsealPrice.Int == nil {
+		return true
+	}
+	return !offer.UnsealPrice.GreaterThan(abi.NewTokenAmount(max))
+}
+
+// queryStorageMiners queries the miners with an active deal for the root if no provider offered the content
+// before the fallback delay. Miners may have to unseal the content first so we skip the offers with an unseal
+// price higher than the max set in the args.
+func (nd *node) queryStorageMiners(ctx context.Context, tx *exchange.Tx, root cid.Cid, s ipld.Node, args *GetArgs, triaged <-chan struct{}) {
+	if nd.deals == nil {
+		return
+	}
+	recs, err := nd.deals.Retrievable(root)
+	if err != nil || len(recs) == 0 {
+		return
+	}
+
+	timer := time.NewTimer(minerFallbackDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-triaged:
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	log.Info().Str("root", root.String()).Int("deals", len(recs)).Msg("no cache offered the content, querying storage miners")
+
+	queried := make(map[address.Address]bool)
+	for _, rec := range recs {
+		// The miner set in the args was already queried
+		if queried[rec.Miner] || rec.Miner.String() == args.Miner {
+			continue
+		}
+		queried[rec.Miner] = true
+
+		info, err := nd.filMinerInfo(ctx, rec.Miner)
+		if err != nil {
+			log.Error().Err(err).Str("miner", rec.Miner.String()).Msg("getting miner info")
+			continue
+		}
+		offer, err := tx.QueryOffer(*info, s)
+		if err != nil {
+			log.Error().Err(err).Str("miner", rec.Miner.String()).Msg("querying from miner")
+			continue
+		}
+		if !unsealAllowed(offer, args.MaxUnseal) {
+			log.Info().
+				Str("miner", rec.Miner.String()).
+				Str("unsealPrice", filecoin.FIL(offer.UnsealPrice).Short()).
+				Msg("unseal price too high")
+			continue
+		}
+		select {
+		case <-triaged:
+			return
+		default:
+			tx.ApplyOffer(offer)
+		}
+	}
+}
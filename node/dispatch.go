@@ -0,0 +1,81 @@
+package node
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/exchange"
+)
+
+// Dispatch sends committed refs to caches in our regions in a single batch sharing the same peer selection
+func (nd *node) Dispatch(ctx context.Context, args *DispatchArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			DispatchResult: &DispatchResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	items, opts, err := nd.dispatchBatch(args)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	h, err := nd.exch.R().DispatchBatch(items, opts)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	for {
+		select {
+		case rec, ok := <-h.Out():
+			if !ok {
+				nd.send(Notify{
+					DispatchResult: &DispatchResult{
+						Last: true,
+					},
+				})
+				return
+			}
+			nd.send(Notify{
+				DispatchResult: &DispatchResult{
+					Ref:   rec.PayloadCID.String(),
+					Cache: rec.Provider.String(),
+				},
+			})
+		case <-ctx.Done():
+			h.Cancel()
+			sendErr(ctx.Err())
+			return
+		}
+	}
+}
+
+// dispatchBatch collects the refs to dispatch and the options shared by the batch.
+// The batch is kept off the public index of the caches if any of the refs is private.
+func (nd *node) dispatchBatch(args *DispatchArgs) ([]exchange.DispatchItem, exchange.DispatchOptions, error) {
+	opts := exchange.DefaultDispatchOptions
+	opts.FromBlockstore = true
+	if args.CacheRF > 0 {
+		opts.RF = args.CacheRF
+	}
+	items := make([]exchange.DispatchItem, 0, len(args.Refs))
+	for _, r := range args.Refs {
+		root, err := cid.Parse(r)
+		if err != nil {
+			return nil, opts, err
+		}
+		ref, err := nd.exch.Index().PeekRef(root)
+		if err != nil {
+			return nil, opts, err
+		}
+		if ref.Private {
+			opts.Private = true
+		}
+		items = append(items, exchange.DispatchItem{
+			Root: root,
+			Size: uint64(ref.PayloadSize),
+		})
+	}
+	return items, opts, nil
+}
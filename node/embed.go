@@ -0,0 +1,128 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	badgerds "github.com/ipfs/go-ds-badger"
+	"github.com/libp2p/go-libp2p"
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/p2p/net/conngater"
+	tcp "github.com/libp2p/go-tcp-transport"
+	websocket "github.com/libp2p/go-ws-transport"
+	"github.com/myelnet/pop/build"
+	"github.com/rs/zerolog/log"
+)
+
+// mobileDatastoreOptions reduces the memory badger uses. The value log is not truncated on startup since
+// mobile platforms may not let us shrink memory mapped files, we stop the node cleanly instead.
+func mobileDatastoreOptions(o *badgerds.Options) {
+	o.Truncate = false
+	o.NumMemtables = 1
+	o.NumLevelZeroTables = 1
+	o.NumLevelZeroTablesStall = 2
+	o.MaxTableSize = 8 << 20
+	o.ValueLogFileSize = 16 << 20
+}
+
+// mobileHostOptions configures a host which listens on random ports, keeps few connections and doesn't
+// answer DHT queries so it can run on a phone without draining the battery
+func mobileHostOptions(ctx context.Context, priv ci.PrivKey, gater *conngater.BasicConnectionGater, kad **dht.IpfsDHT) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.Identity(priv),
+		libp2p.ListenAddrStrings(
+			"/ip4/0.0.0.0/tcp/0",
+			"/ip4/0.0.0.0/tcp/0/ws",
+		),
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(websocket.New),
+		libp2p.ConnectionManager(connmgr.NewConnManager(
+			4,              // Lowwater
+			16,             // HighWater,
+			20*time.Second, // GracePeriod
+		)),
+		libp2p.ConnectionGater(gater),
+		libp2p.DisableRelay(),
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			d, err := dht.New(ctx, h, dht.Mode(dht.ModeClient))
+			*kad = d
+			return d, err
+		}),
+		libp2p.UserAgent("pop-mobile-" + build.Version),
+	}
+}
+
+// Embedded is a node running in the process of an app. Commands and notifications are exchanged as JSON
+// messages like with the daemon so bindings only need to pass bytes around.
+type Embedded struct {
+	nd *node
+	cs *CommandServer
+	// ctx is cancelled when the node stops which interrupts the commands in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Embed starts a node in process and sends the JSON encoded notifications to the notify callback.
+// The repo is created if it doesn't exist yet.
+func Embed(opts Options, notify func([]byte)) (*Embedded, error) {
+	if err := os.MkdirAll(opts.RepoPath, 0755); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	opts.CancelFunc = cancel
+	nd, err := New(ctx, opts)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("node.New: %w", err)
+	}
+	cs := NewCommandServer(nd, notify)
+	nd.mu.Lock()
+	nd.notify = cs.send
+	nd.mu.Unlock()
+	return &Embedded{
+		nd:     nd,
+		cs:     cs,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Send executes a JSON encoded Command. Results are sent asynchronously to the notify callback.
+func (e *Embedded) Send(cmd []byte) error {
+	return e.cs.GotMsgBytes(e.ctx, cmd)
+}
+
+// Flush persists everything kept in memory. Apps should call it when moving to the background
+// since the OS may kill them without notice.
+func (e *Embedded) Flush() error {
+	if e.nd.stats != nil {
+		if err := e.nd.stats.Flush(); err != nil {
+			return err
+		}
+	}
+	return e.nd.ds.Sync(datastore.NewKey("/"))
+}
+
+// SetPowerSaving pauses background replication while the device is on battery or a metered connection
+func (e *Embedded) SetPowerSaving(on bool) {
+	e.nd.exch.SetPowerSaving(on)
+}
+
+// Close stops the node and closes the repo. The node cannot be used after.
+func (e *Embedded) Close() error {
+	if err := e.Flush(); err != nil {
+		log.Error().Err(err).Msg("failed to flush repo")
+	}
+	e.cancel()
+	if err := e.nd.host.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close host")
+	}
+	return e.nd.ds.Close()
+}
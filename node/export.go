@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/myelnet/pop/internal/objstore"
+)
+
+// objstoreClient returns a client for the given scheme using the credentials in the node options
+// or from the environment
+func (nd *node) objstoreClient(scheme string) (*objstore.Client, error) {
+	cfg, ok := nd.opts.ObjectStores[scheme]
+	if !ok {
+		var err error
+		cfg, err = objstore.ConfigFromEnv(scheme)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objstore.New(cfg), nil
+}
+
+// writeOut writes a file or directory to a local path or streams it to an object storage url
+// such as s3://bucket/key or gs://bucket/key
+func (nd *node) writeOut(ctx context.Context, f files.Node, name string, out string) error {
+	if !objstore.IsURL(out) {
+		return files.WriteTo(f, out)
+	}
+	loc, err := objstore.ParseURL(out)
+	if err != nil {
+		return err
+	}
+	client, err := nd.objstoreClient(loc.Scheme)
+	if err != nil {
+		return err
+	}
+
+	// Use the entry name if no key or only a prefix is provided
+	key := loc.Key
+	if key == "" || strings.HasSuffix(key, "/") {
+		key = path.Join(key, name)
+	}
+
+	return files.Walk(f, func(fpath string, n files.Node) error {
+		file, ok := n.(files.File)
+		if !ok {
+			// directories are implicit in object storage
+			return nil
+		}
+		size, err := file.Size()
+		if err != nil {
+			return err
+		}
+		k := key
+		if fpath != "" {
+			k = path.Join(key, fpath)
+		}
+		if k == "" {
+			return errors.New("missing object key")
+		}
+		return client.Put(ctx, loc.Bucket, k, file, size)
+	})
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/rs/zerolog/log"
+)
+
+// fundsInterval is how often we check the balance of our addresses, once per epoch
+const fundsInterval = builtin.EpochDurationSeconds * time.Second
+
+// watchFunds notifies the connected clients whenever funds arrive or leave one of our addresses
+func (nd *node) watchFunds(ctx context.Context) {
+	ticker := time.NewTicker(fundsInterval)
+	defer ticker.Stop()
+
+	nd.checkFunds(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			nd.checkFunds(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkFunds compares the balance of our addresses with the one we last saw. The first time we see an
+// address we only record its balance.
+func (nd *node) checkFunds(ctx context.Context) {
+	api := nd.exch.FilecoinAPI()
+	if api == nil {
+		return
+	}
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		// checkFilecoin alerts the operator if the rpc is down
+		return
+	}
+	addrs, err := nd.exch.Wallet().List()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list addresses")
+		return
+	}
+	if nd.balances == nil {
+		nd.balances = make(map[address.Address]abi.TokenAmount)
+	}
+	for _, addr := range addrs {
+		bal := big.Zero()
+		act, err := api.StateGetActor(ctx, addr, head.Key())
+		if err != nil && !strings.Contains(err.Error(), "actor not found") {
+			log.Error().Err(err).Str("addr", addr.String()).Msg("failed to get balance")
+			continue
+		}
+		if act != nil {
+			bal = act.Balance
+		}
+
+		prev, ok := nd.balances[addr]
+		nd.balances[addr] = bal
+		if !ok {
+			continue
+		}
+		diff := big.Sub(bal, prev)
+		if diff.IsZero() {
+			continue
+		}
+
+		res := &FundsEventResult{
+			Address:   addr.String(),
+			Direction: "incoming",
+			Amount:    filecoin.FIL(diff).Short(),
+			Balance:   filecoin.FIL(bal).Short(),
+			Height:    int64(head.Height()),
+		}
+		if diff.LessThan(big.Zero()) {
+			res.Direction = "outgoing"
+			res.Amount = filecoin.FIL(big.Sub(prev, bal)).Short()
+		}
+		nd.send(Notify{FundsEvent: res})
+	}
+}
@@ -0,0 +1,124 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/internal/objstore"
+)
+
+// maxParallelDownloads is the number of objects we download at the same time when ingesting
+// content from object storage
+const maxParallelDownloads = 4
+
+// ingested is the result of chunking a single object into the transaction store
+type ingested struct {
+	key  string
+	root cid.Cid
+	size int64
+	err  error
+}
+
+// ingestKey returns the entry key of an object which is its path relative to the last directory of the
+// ingested prefix so objects with the same name in different directories don't overwrite each other
+func ingestKey(prefix, key string) string {
+	return strings.TrimPrefix(key, prefix[:strings.LastIndex(prefix, "/")+1])
+}
+
+// addObjects streams all the objects under an object storage url prefix into the current transaction.
+// Objects already staged with the same size are skipped so an interrupted ingest can be resumed by running it again.
+// It assumes the caller is holding the tx lock until it returns.
+func (nd *node) addObjects(ctx context.Context, url string, added map[string]bool) error {
+	loc, err := objstore.ParseURL(url)
+	if err != nil {
+		return err
+	}
+	client, err := nd.objstoreClient(loc.Scheme)
+	if err != nil {
+		return err
+	}
+	objects, err := client.List(ctx, loc.Bucket, loc.Key)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no objects found in %s", url)
+	}
+
+	staged, err := nd.tx.Status()
+	if err != nil {
+		return err
+	}
+	var todo []objstore.Object
+	for _, o := range objects {
+		key := ingestKey(loc.Key, o.Key)
+		if e, ok := staged[key]; ok && e.Size == o.Size {
+			added[key] = true
+			continue
+		}
+		todo = append(todo, o)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan objstore.Object)
+	results := make(chan ingested)
+	var wg sync.WaitGroup
+	for i := 0; i < maxParallelDownloads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				r := client.Open(ctx, loc.Bucket, o.Key)
+				root, err := nd.Add(ctx, nd.tx.Store().DAG, r)
+				r.Close()
+				if err != nil {
+					err = fmt.Errorf("failed to add %s: %w", o.Key, err)
+				}
+				results <- ingested{
+					key:  ingestKey(loc.Key, o.Key),
+					root: root,
+					size: o.Size,
+					err:  err,
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, o := range todo {
+			select {
+			case jobs <- o:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Entries are staged as soon as they are ready so a failure doesn't lose the completed downloads
+	var firstErr error
+	for res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+				cancel()
+			}
+			continue
+		}
+		if err := nd.tx.Put(res.key, res.root, res.size); err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+			continue
+		}
+		added[res.key] = true
+	}
+	return firstErr
+}
@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/go-multistore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/internal/alert"
+	"github.com/rs/zerolog/log"
+)
+
+// StoreReport lists the blocks we removed from a multistore store because they were unreadable or corrupted
+type StoreReport struct {
+	StoreID multistore.StoreID
+	Removed int
+}
+
+// RepoReport summarizes the problems found and repaired when checking the repo on startup
+type RepoReport struct {
+	exchange.IntegrityReport
+	Stores []StoreReport
+}
+
+// Repaired returns whether anything had to be repaired
+func (r RepoReport) Repaired() bool {
+	return len(r.Dropped) > 0 || len(r.Stores) > 0
+}
+
+func (r RepoReport) String() string {
+	var parts []string
+	if len(r.Dropped) > 0 {
+		roots := make([]string, len(r.Dropped))
+		for i, c := range r.Dropped {
+			roots[i] = c.String()
+		}
+		parts = append(parts, fmt.Sprintf("dropped %d/%d refs with missing or corrupted blocks: %s",
+			len(r.Dropped), r.Checked, strings.Join(roots, ", ")))
+	}
+	if len(r.Pinned) > 0 {
+		roots := make([]string, len(r.Pinned))
+		for i, c := range r.Pinned {
+			roots[i] = c.String()
+		}
+		parts = append(parts, fmt.Sprintf("%d dropped refs were pinned: %s", len(r.Pinned), strings.Join(roots, ", ")))
+	}
+	for _, s := range r.Stores {
+		parts = append(parts, fmt.Sprintf("removed %d corrupted blocks from store %d", s.Removed, s.StoreID))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// checkStore verifies all the blocks of a multistore store and removes the ones which do not match their CID.
+// Transactions using the store will retrieve the missing blocks again. Blocks which cannot be read are never
+// removed as the whole store may be unreadable, for example with the wrong encryption key.
+func checkStore(ctx context.Context, id multistore.StoreID, store *multistore.Store) (int, error) {
+	kc, err := store.Bstore.AllKeysChan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for k := range kc {
+		blk, err := store.Bstore.Get(k)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			// removed since we listed the keys
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to read block %s: %w", k, err)
+		}
+		err = exchange.VerifyBlock(blk)
+		if err == nil {
+			continue
+		}
+		log.Error().Err(err).Str("cid", k.String()).Uint64("store", uint64(id)).Msg("removing corrupted block")
+		if err := store.Bstore.DeleteBlock(k); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, ctx.Err()
+}
+
+// checkIntegrity makes sure all the content in the index can be served and repairs the repo otherwise.
+// Refs with missing or corrupted blocks are dropped and corrupted blocks are removed from the transaction stores.
+func (nd *node) checkIntegrity(ctx context.Context) (RepoReport, error) {
+	var report RepoReport
+	var err error
+	report.IntegrityReport, err = nd.exch.Index().Check(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to check index: %w", err)
+	}
+	for _, id := range nd.ms.List() {
+		store, err := nd.ms.Get(id)
+		if err != nil {
+			return report, err
+		}
+		removed, err := checkStore(ctx, id, store)
+		if err != nil {
+			return report, fmt.Errorf("failed to check store %d: %w", id, err)
+		}
+		if removed > 0 {
+			report.Stores = append(report.Stores, StoreReport{StoreID: id, Removed: removed})
+		}
+	}
+	if report.Repaired() {
+		// pinned content is lost until the operator adds it again
+		level := alert.Warning
+		if len(report.Pinned) > 0 {
+			level = alert.Critical
+		}
+		nd.alerts.Notify(ctx, level, alert.EventIntegrity, "repaired repo: "+report.String())
+	}
+	return report, nil
+}
+
+// reapStores deletes the stores left behind by failed or interrupted transfers
+func (nd *node) reapStores() {
+	if nd.mfs == nil {
+		return
+	}
+	// Hold the lock so no namespace store is created while we reap
+	nd.mfs.mu.Lock()
+	defer nd.mfs.mu.Unlock()
+	keep, err := nd.mfs.storeIDs()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list namespace stores")
+		return
+	}
+	reaped, err := nd.exch.ReapStores(keep)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reap stores")
+	}
+	if len(reaped) > 0 {
+		log.Info().Int("count", len(reaped)).Msg("reaped orphaned stores")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -43,14 +44,94 @@ type WalletExportArgs struct {
 
 // WalletPayArgs get passed to the WalletPay command
 type WalletPayArgs struct {
-	From   string
+	From   string // From defaults to the default address of the wallet
 	To     string
 	Amount string
 }
 
+// WalletBalanceArgs get passed to the WalletBalance command
+type WalletBalanceArgs struct {
+	Address string // Address defaults to the default address of the wallet
+}
+
+// WalletHistoryArgs get passed to the WalletHistory command
+type WalletHistoryArgs struct {
+	Address string // Address filters the messages sent from it, all messages are listed if empty
+	Limit   int    // Limit is the maximum number of messages to list, 0 lists all of them
+}
+
+// WalletSignArgs get passed to the WalletSign command
+type WalletSignArgs struct {
+	Address string // Address defaults to the default address of the wallet
+	Data    []byte
+}
+
+// WalletVerifyArgs get passed to the WalletVerify command
+type WalletVerifyArgs struct {
+	Address   string
+	Data      []byte
+	Signature []byte
+}
+
+// WalletMsigArgs get passed to the WalletMsig command
+type WalletMsigArgs struct {
+	Op     string // Op is one of propose, fund, approve, cancel or pending
+	Msig   string // Msig is the address of the multisig wallet
+	From   string // From is the signer address in our wallet
+	To     string // To is the recipient of a proposal or the client address to fund the market escrow of
+	Amount string
+	TxnID  int64 // TxnID is the multisig transaction to approve or cancel
+}
+
+// PaychSettleArgs get passed to the PaychSettle command
+type PaychSettleArgs struct {
+	Channel string // Channel is the address of the payment channel to settle
+}
+
+// PaychCollectArgs get passed to the PaychCollect command
+type PaychCollectArgs struct {
+	Channel string // Channel is the address of the payment channel to collect
+}
+
+// PaychTopUpArgs get passed to the PaychTopUp command
+type PaychTopUpArgs struct {
+	Deal   string // Deal is the ID of the retrieval deal waiting for funds
+	Amount string // Amount to add to the channel, defaults to the deal shortfall
+}
+
+// PaychListArgs get passed to the PaychList command
+type PaychListArgs struct{}
+
+// PaychInspectArgs get passed to the PaychInspect command
+type PaychInspectArgs struct {
+	Channel string // Channel is the address of the payment channel to inspect
+}
+
+// PaychExportArgs get passed to the PaychExport command
+type PaychExportArgs struct {
+	Path       string // Path is the file to write the encrypted export to
+	Passphrase string // Passphrase derives the key encrypting the export
+}
+
+// PaychImportArgs get passed to the PaychImport command
+type PaychImportArgs struct {
+	Path       string // Path is the file exported with PaychExport
+	Passphrase string // Passphrase the export was encrypted with
+}
+
 // CommArgs are passed to the Commit command
 type CommArgs struct {
-	CacheRF int // CacheRF is the cache replication factor or number of cache provider will request
+	CacheRF     int               // CacheRF is the cache replication factor or number of cache provider will request
+	Private     bool              // Private refs are not listed on the public index
+	Tags        []string          // Tags are indexed with the commit for searching
+	Description string            // Description is indexed with the commit for searching
+	Labels      map[string]string // Labels are set on the ref
+	Name        string            // Name tags the ref as the latest version of a name
+	Incentive   string            // Incentive is an amount of FIL offered to the caches holding the content
+	Hold        time.Duration     // Hold is how long caches must hold the content to claim the incentive
+	DryRun      bool              // DryRun only returns the caches we would dispatch to without committing
+	Regions     []string          // Regions to dispatch to in order of priority, defaults to our regions
+	From        string            // From is the wallet address paying the incentive, defaults to our default address
 }
 
 // GetArgs get passed to the Get command
@@ -64,25 +145,209 @@ type GetArgs struct {
 	Miner    string `json:"miner,omitempty"`
 	Strategy string `json:"strategy,omitempty"`
 	MaxPPB   int64  `json:"maxPPB,omitempty"`
+	From     string `json:"from,omitempty"` // From is the wallet address paying for the retrieval, defaults to our default address
+	// MaxUnseal is the max price in attoFIL we pay a storage miner found by the fallback to unseal the content,
+	// 0 refuses to pay for unsealing and -1 means no limit. The offer of a miner given explicitly is not capped.
+	MaxUnseal int64 `json:"maxUnseal,omitempty"`
 }
 
 // ListArgs provides params for the List command
 type ListArgs struct {
-	Page int // potential pagination as the amount may be very large
+	Page   int               // potential pagination as the amount may be very large
+	Labels map[string]string // only list refs with all these labels
+	Name   string            // only list the versions of this name from the latest
+}
+
+// LabelArgs provides params for the Label command
+type LabelArgs struct {
+	Cid    string
+	Labels map[string]string // labels with an empty value are removed
+}
+
+// SearchArgs provides params for the Search command
+type SearchArgs struct {
+	Query  string
+	Labels map[string]string // only return refs with all these labels
+}
+
+// ProtectArgs provides params for the Protect command
+type ProtectArgs struct {
+	Peers     []string // Peers to add or remove from the protected set, empty to only list them
+	Unprotect bool     // Unprotect removes the peers from the protected set
+}
+
+// AmendArgs provides params for the Amend command
+type AmendArgs struct {
+	Ref    string   // Ref is the root of the committed ref to reopen
+	Remove []string // Remove are keys to remove from the ref
+}
+
+// StatsArgs provides params for the Stats command
+type StatsArgs struct {
+	Since string // Since is how far back to return daily metrics for i.e. 30d or 12h
+}
+
+// EarningsArgs provides params for the Earnings command
+type EarningsArgs struct {
+	By string // By is how to aggregate accounts, either peer or cid
+}
+
+// UsageArgs provides params for the Usage command
+type UsageArgs struct {
+	By    string // By is how to aggregate usage, either peer or wallet
+	Reset string // Reset clears the usage of the given peer ID once listed, i.e. after invoicing it
+}
+
+// DealListArgs provides params for the DealList command
+type DealListArgs struct {
+	Status string // Status only lists the deals with the given status if not empty
+}
+
+// DealProposalsArgs provides params for the DealProposals command
+type DealProposalsArgs struct {
+	Miner   string // Miner only lists the proposals sent to the given miner if not empty
+	Ref     string // Ref only lists the proposals for the given root CID or name if not empty
+	Outcome string // Outcome only lists the proposals with the given outcome if not empty
+}
+
+// DealPushArgs provides params for the DealPush command
+type DealPushArgs struct {
+	Ref string // Ref is the root CID or name of the content to store
+	// Refs are more roots or names packed in a single piece with Ref so small commits share the cost of the deals
+	Refs     []string
+	Miners   []string      // Miners are the addresses of the miners to propose deals to, selected with the other args if empty
+	Prices   []string      // Prices are the FIL per GiB per epoch offered to each miner in the same order, their ask if empty
+	RF       int           // RF is the number of miners selected when none are given
+	MaxPrice string        // MaxPrice is the highest FIL per GiB per epoch a selected miner may ask
+	Region   string        // Region selects miners in the given region when none are given
+	Duration time.Duration // Duration is how long the miners must store the content
+	Verified bool          // Verified makes verified deals using the datacap of our default address
+	// FastRetrieval asks the miners to keep an unsealed copy so retrievals don't wait for unsealing
+	FastRetrieval bool
+	// StartBuffer is how long after the proposal the deals start, StartEpoch sets an exact epoch instead
+	StartBuffer time.Duration
+	StartEpoch  int64
+	// CollateralMultiplier multiplies the minimum collateral the miners lose if a deal is slashed
+	CollateralMultiplier uint64
+	// MinReputation is the lowest reputation score (0-100) of a selected miner
+	MinReputation float64
+	// Regions restricts the selected miners to the given regions
+	Regions []string
+	// MaxLatency is the longest a selected miner may take to answer our ask request
+	MaxLatency time.Duration
+	// DistinctOwners selects miners controlled by different owners so the replicas don't depend on one operator
+	DistinctOwners bool
+	// SectorLifetime only selects miners whose sectors can live as long as the deals
+	SectorLifetime bool
+}
+
+// DealQueueArgs provides params for the DealQueue command
+type DealQueueArgs struct {
+	All bool // All includes the jobs which are done or failed
+}
+
+// DealOfflineArgs provides params for the DealOffline command
+type DealOfflineArgs struct {
+	Refs     []string      // Refs are the root CIDs or names of the content to store, packed in a single piece
+	Miners   []string      // Miners are the addresses of the miners we deliver the CAR to
+	Duration time.Duration // Duration is how long the miners must store the content
+	Out      string        // Out is the path where the CAR is written
+	Verified bool          // Verified makes verified deals using the datacap of our default address
+}
+
+// Mutable files operations
+const (
+	FilesWrite   = "write"
+	FilesMv      = "mv"
+	FilesRm      = "rm"
+	FilesLs      = "ls"
+	FilesPublish = "publish"
+)
+
+// FilesArgs provides params for the Files command
+type FilesArgs struct {
+	Op     string // Op is one of write, mv, rm, ls or publish
+	Path   string // Path is the mutable path i.e. /namespace/dir/file.txt
+	Dst    string // Dst is the destination path when moving a file
+	Source string // Source is the local file to write at Path
+}
+
+// MoveArgs provides params for the Move command
+type MoveArgs struct {
+	Ref string // Ref is the root of the committed ref to move
+	To  string // To is the peer ID or address of the peer to move the ref to
+}
+
+// RegionArgs provides params for the Region command
+type RegionArgs struct {
+	Region string // Region filters the content seen in a single region
+	Limit  int    // Limit is the maximum number of entries to return
+}
+
+// SchemeArgs provides params for the Scheme command
+type SchemeArgs struct{}
+
+// BlockArgs provides params for the Block command
+type BlockArgs struct {
+	Targets []string // Targets are peer IDs or CIDR subnets to block or unblock, empty to only list them
+	Unblock bool     // Unblock allows connections with the targets again
+}
+
+// PinArgs provides params for the Pin command
+type PinArgs struct {
+	Cids  []string // Cids are the roots of the refs to pin or unpin, empty to only list the pinned refs
+	Unpin bool     // Unpin lets the refs be evicted again
+}
+
+// DispatchArgs provides params for the Dispatch command
+type DispatchArgs struct {
+	Refs    []string // Refs are the roots of committed refs to dispatch in a single batch
+	CacheRF int      // CacheRF is the number of cache providers to dispatch each ref to
 }
 
 // Command is a message sent from a client to the daemon
 type Command struct {
-	Off          *OffArgs
-	Ping         *PingArgs
-	Put          *PutArgs
-	Status       *StatusArgs
-	WalletList   *WalletListArgs
-	WalletExport *WalletExportArgs
-	WalletPay    *WalletPayArgs
-	Commit       *CommArgs
-	Get          *GetArgs
-	List         *ListArgs
+	Off           *OffArgs
+	Ping          *PingArgs
+	Put           *PutArgs
+	Status        *StatusArgs
+	WalletList    *WalletListArgs
+	WalletExport  *WalletExportArgs
+	WalletPay     *WalletPayArgs
+	WalletMsig    *WalletMsigArgs
+	WalletBalance *WalletBalanceArgs
+	WalletHistory *WalletHistoryArgs
+	WalletSign    *WalletSignArgs
+	WalletVerify  *WalletVerifyArgs
+	PaychSettle   *PaychSettleArgs
+	PaychCollect  *PaychCollectArgs
+	PaychTopUp    *PaychTopUpArgs
+	PaychList     *PaychListArgs
+	PaychInspect  *PaychInspectArgs
+	PaychExport   *PaychExportArgs
+	PaychImport   *PaychImportArgs
+	Commit        *CommArgs
+	Get           *GetArgs
+	List          *ListArgs
+	Search        *SearchArgs
+	Label         *LabelArgs
+	Protect       *ProtectArgs
+	Amend         *AmendArgs
+	Stats         *StatsArgs
+	Earnings      *EarningsArgs
+	Usage         *UsageArgs
+	Files         *FilesArgs
+	Move          *MoveArgs
+	Region        *RegionArgs
+	Scheme        *SchemeArgs
+	Block         *BlockArgs
+	Pin           *PinArgs
+	Dispatch      *DispatchArgs
+	DealList      *DealListArgs
+	DealOffline   *DealOfflineArgs
+	DealProposals *DealProposalsArgs
+	DealPush      *DealPushArgs
+	DealQueue     *DealQueueArgs
 }
 
 // OffResult
@@ -94,7 +359,8 @@ type PingResult struct {
 	Addrs          []string // Addresses the host is listening on
 	Peers          []string // Peers currently connected to the node (local daemon only)
 	LatencySeconds float64
-	Version        string // The Version the node is running
+	Version        string         // The Version the node is running
+	Crashes        map[string]int // Panics recovered in each background routine (local daemon only)
 	Err            string
 }
 
@@ -116,10 +382,115 @@ type StatusResult struct {
 	Err     string
 }
 
-// WalletResult returns the output of every WalletList/WalletExport/WalletPay requests
+// WalletResult returns the output of every WalletList/WalletExport/WalletPay/WalletMsig/WalletBalance requests
 type WalletResult struct {
 	Err       string
 	Addresses []string
+	// Balance is the amount available on chain
+	Balance string
+	// Locked is the amount held in our outbound payment channels until they are collected
+	Locked string
+	// Pending is the amount added to payment channels waiting to be confirmed
+	Pending string
+	// DataCap is the size of verified deals the address can still make, empty if it isn't a verified client
+	DataCap string
+	// Proposals are the multisig transactions proposed, approved or pending
+	Proposals []MsigProposalInfo
+	// History are the messages we pushed on chain, most recent first
+	History []TxInfo
+	// Signature is the serialized signature of the data we signed
+	Signature []byte
+	// Valid is whether the data we verified was signed by the address
+	Valid bool
+}
+
+// TxInfo describes a message we pushed on chain
+type TxInfo struct {
+	Cid     string
+	From    string
+	To      string
+	Amount  string
+	Kind    string
+	Status  string
+	GasUsed int64
+	// Fee is the FIL paid for the gas used
+	Fee string
+	// ReplacedBy is the message executed instead if it was replaced
+	ReplacedBy string
+	Height     int64
+	Time       time.Time
+}
+
+// MsigProposalInfo describes a multisig transaction waiting for approvals
+type MsigProposalInfo struct {
+	Msig      string
+	TxnID     int64
+	To        string
+	Amount    string
+	Method    uint64
+	Proposer  string
+	Approvals int
+	Applied   bool
+}
+
+// PaychResult returns the output of the PaychSettle/PaychCollect/PaychTopUp/PaychList/PaychInspect/PaychExport/PaychImport requests
+type PaychResult struct {
+	Channel string
+	// SettlingAt is the epoch after which a settling channel can be collected
+	SettlingAt int64
+	Settling   bool
+	// Channels are the channels we listed or inspected
+	Channels []PaychInfo
+	// Path is the file a store was exported to or imported from
+	Path string
+	// Imported is the number of channels added by an import
+	Imported int
+	Err      string
+}
+
+// PaychEventResult is sent to connected clients for each payment event so applications can reconcile payments
+type PaychEventResult struct {
+	Event      string
+	Channel    string
+	Direction  string
+	Amount     string
+	Lane       uint64
+	MsgCid     string
+	SettlingAt int64
+	Err        string
+}
+
+// FundsEventResult is sent to connected clients when the balance of one of our addresses changes on chain
+type FundsEventResult struct {
+	Address   string
+	Direction string // Direction is incoming if funds arrived or outgoing if they left
+	Amount    string
+	Balance   string
+	Height    int64
+}
+
+// PaychInfo describes a payment channel
+type PaychInfo struct {
+	Channel    string
+	Direction  string // Direction is inbound if we are the recipient of the channel
+	Control    string // Control is our address
+	Target     string // Target is the address of the other party
+	Amount     string // Amount is the amount added to the channel
+	Pending    string // Pending is the amount awaiting confirmation
+	Redeemed   string // Redeemed is the amount of the vouchers for outbound channels
+	Settling   bool
+	SettlingAt int64
+	Vouchers   int
+	// PendingMsgs and Lanes are only set when inspecting a channel
+	PendingMsgs []string
+	Lanes       []LaneResult
+}
+
+// LaneResult is the state of a payment channel lane
+type LaneResult struct {
+	Lane     uint64
+	Nonce    uint64
+	Redeemed string
 }
 
 // CommResult is feedback on the push operation
@@ -128,6 +499,21 @@ type CommResult struct {
 	Caches []string
 	Size   string
 	Err    string
+	// Progress describes a step of the dispatch to caching peers
+	Progress string
+	// Candidates are the caches we would dispatch to in a dry run
+	Candidates []CandidateResult
+	// DryRun is true when the result only previews the dispatch
+	DryRun bool
+}
+
+// CandidateResult is a cache selected to receive a dispatch
+type CandidateResult struct {
+	Peer     string
+	Regions  []string
+	Capacity string // Capacity is the space available for new content or "unlimited"
+	Latency  time.Duration
+	Score    float64 // Score is the reputation of the peer between 0 and 1
 }
 
 // GetResult gives us feedback on the result of the Get request
@@ -144,28 +530,298 @@ type GetResult struct {
 	DiscLatSeconds  float64 `json:"discLatSeconds,omitempty"`
 	TransLatSeconds float64 `json:"tansLatSeconds,omitempty"`
 	Local           bool    `json:"local,omitempty"`
-	Err             string  `json:"error,omitempty"`
+	// Shortfall is set when the deal is paused until the payment channel is topped up
+	Shortfall string `json:"shortfall,omitempty"`
+	// Paused is the reason given by the provider when it pauses the transfer until it receives more funds
+	Paused string `json:"paused,omitempty"`
+	Err    string `json:"error,omitempty"`
 }
 
 // ListResult contains the result for a single item of the list
 type ListResult struct {
-	Root string
-	Freq int64
-	Size int64
-	Last bool
+	Root   string
+	Freq   int64
+	Size   int64
+	Labels map[string]string
+	Name   string
+	Last   bool
+	Err    string
+	// Queries and Retrievals count the demand we served for this ref
+	Queries    int64
+	Retrievals int64
+	// Pinned refs are never evicted
+	Pinned bool
+}
+
+// LabelResult is feedback on the Label command
+type LabelResult struct {
+	Labels map[string]string
+	Err    string
+}
+
+// SearchResult contains a single commit matching a search query
+type SearchResult struct {
+	Root        string
+	Keys        []string
+	Tags        []string
+	Description string
+	Size        int64
+	Last        bool
+	Err         string
+}
+
+// ProtectResult returns all the peers protected from connection pruning
+type ProtectResult struct {
+	Peers []string
+	Err   string
+}
+
+// AmendResult returns the entries staged after reopening a ref
+type AmendResult struct {
+	RootCid string
+	Keys    []string
+	Err     string
+}
+
+// DayStatsResult contains the metrics for a single day
+type DayStatsResult struct {
+	Day         string
+	BytesServed uint64
+	Earnings    string
+	HitRate     float64
+	Queries     uint64
+	Peers       map[string]int
+}
+
+// StatsResult returns the daily metrics for the requested period
+type StatsResult struct {
+	Days []DayStatsResult
 	Err  string
 }
 
+// AccountResult contains the funds spent and earned for a single peer or content root
+type AccountResult struct {
+	Key    string
+	Spent  string
+	Earned string
+	Net    string
+}
+
+// EarningsResult returns the accounts and the totals spent and earned across all of them
+type EarningsResult struct {
+	Accounts []AccountResult
+	Spent    string
+	Earned   string
+	Net      string
+	Err      string
+}
+
+// UsageRecord is the bandwidth a client used retrieving from us
+type UsageRecord struct {
+	Peer       string // empty when aggregated by wallet
+	Wallet     string // empty if the client never paid
+	TotalSent  uint64
+	WindowSent uint64
+	Deals      uint64
+	LastSeen   time.Time
+}
+
+// UsageResult returns the bandwidth used by each client, heaviest first
+type UsageResult struct {
+	Clients []UsageRecord
+	Err     string
+}
+
+// DealInfo describes a storage deal we proposed
+type DealInfo struct {
+	ProposalCid string
+	Root        string
+	Miner       string
+	DealID      uint64
+	Status      string
+	Message     string
+	StartEpoch  int64
+	EndEpoch    int64
+	Updated     time.Time
+}
+
+// DealJobInfo describes a storage deal waiting in the queue
+type DealJobInfo struct {
+	ID          string
+	Root        string
+	Miner       string
+	Status      string
+	Attempts    int
+	Err         string
+	ProposalCid string
+	NextAttempt time.Time
+}
+
+// DealResult returns the storage deals we are tracking
+type DealResult struct {
+	Deals []DealInfo
+	// Jobs are the deals waiting in the queue
+	Jobs []DealJobInfo
+	// Aggregate is the root of the piece packing multiple refs
+	Aggregate string `json:"aggregate,omitempty"`
+	Err       string
+}
+
+// ProposalInfo describes a deal proposal sent to a miner and its outcome
+type ProposalInfo struct {
+	ProposalCid string
+	Root        string
+	Miner       string
+	Price       string
+	PieceSize   string
+	StartEpoch  int64
+	EndEpoch    int64
+	Verified    bool
+	Outcome     string
+	Message     string
+	Created     time.Time
+}
+
+// MinerProposalInfo summarizes the outcomes of the proposals sent to a miner
+type MinerProposalInfo struct {
+	Miner      string
+	Accepted   int
+	Rejected   int
+	Errored    int
+	Failed     int
+	RejectRate float64
+}
+
+// ProposalResult returns the deal proposals we sent and how often each miner rejected them
+type ProposalResult struct {
+	Proposals []ProposalInfo
+	Miners    []MinerProposalInfo
+	Err       string
+}
+
+// PayloadInfo locates a payload packed in an aggregated piece
+type PayloadInfo struct {
+	Root   string
+	Offset uint64
+	Size   string
+}
+
+// OfflineDealResult returns the piece generated for offline deals and the deals proposed for it
+type OfflineDealResult struct {
+	PieceCID  string
+	PieceSize string
+	Car       string
+	// Aggregate is the root of the piece when multiple refs were packed in it
+	Aggregate string
+	Payloads  []PayloadInfo
+	Deals     []DealInfo
+	// Imports are the commands each miner runs to import the CAR once they received it
+	Imports []string
+	Err     string
+}
+
+// FilesResult returns the root of a namespace after a mutable files operation
+type FilesResult struct {
+	Root    string
+	Entries []string // Entries listed or published
+	Err     string
+}
+
+// MoveResult returns the receipt of the peer we moved a ref to
+type MoveResult struct {
+	Ref    string
+	Peer   string
+	Size   int64
+	Status string // Status describes the step of the move in progress
+	Err    string
+}
+
+// RegionContentResult is a ref recently seen in one of our regions
+type RegionContentResult struct {
+	Ref        string
+	Region     string
+	Queries    uint64
+	Dispatches uint64
+	Size       int64
+	LastSeen   time.Time
+	Cached     bool // Cached is true if we already hold the content
+}
+
+// RegionResult returns the content recently queried or dispatched in our regions
+type RegionResult struct {
+	Entries []RegionContentResult
+	Err     string
+}
+
+// SchemeMemberResult is a peer we share a replication scheme with
+type SchemeMemberResult struct {
+	Peer       string
+	Regions    []string
+	Joined     time.Time
+	LastActive time.Time
+	Connected  bool
+}
+
+// SchemeResult returns the replication schemes we joined and the peers who joined ours
+type SchemeResult struct {
+	Joined  []SchemeMemberResult // Joined are the peers whose scheme we joined
+	Members []SchemeMemberResult // Members are the peers who joined our scheme
+	Err     string
+}
+
+// BlockResult returns all the peers and subnets blocked from connecting with us
+type BlockResult struct {
+	Peers   []string
+	Subnets []string
+	Err     string
+}
+
+// PinResult returns the roots of all the pinned refs
+type PinResult struct {
+	Roots []string
+	Err   string
+}
+
+// DispatchResult is sent for each cache storing a ref of the batch
+type DispatchResult struct {
+	Ref   string
+	Cache string
+	Last  bool // Last is true once the batch is over
+	Err   string
+}
+
 // Notify is a message sent from the daemon to the client
 type Notify struct {
-	OffResult    *OffResult
-	PingResult   *PingResult
-	PutResult    *PutResult
-	StatusResult *StatusResult
-	WalletResult *WalletResult
-	CommResult   *CommResult
-	GetResult    *GetResult
-	ListResult   *ListResult
+	OffResult      *OffResult
+	PingResult     *PingResult
+	PutResult      *PutResult
+	StatusResult   *StatusResult
+	WalletResult   *WalletResult
+	PaychResult    *PaychResult
+	CommResult     *CommResult
+	GetResult      *GetResult
+	ListResult     *ListResult
+	SearchResult   *SearchResult
+	LabelResult    *LabelResult
+	ProtectResult  *ProtectResult
+	AmendResult    *AmendResult
+	StatsResult    *StatsResult
+	EarningsResult *EarningsResult
+	UsageResult    *UsageResult
+	FilesResult    *FilesResult
+	MoveResult     *MoveResult
+	RegionResult   *RegionResult
+	SchemeResult   *SchemeResult
+	BlockResult    *BlockResult
+	PinResult      *PinResult
+	DispatchResult *DispatchResult
+	PaychEvent     *PaychEventResult
+	FundsEvent     *FundsEventResult
+	DealResult     *DealResult
+	OfflineResult  *OfflineDealResult
+	ProposalResult *ProposalResult
+	// DealEvent is sent whenever a storage deal changes status
+	DealEvent *DealInfo
 }
 
 // CommandServer receives commands on the daemon side and executes them
@@ -221,6 +877,54 @@ func (cs *CommandServer) GotMsg(ctx context.Context, cmd *Command) error {
 		cs.n.WalletPay(ctx, c)
 		return nil
 	}
+	if c := cmd.WalletBalance; c != nil {
+		go cs.n.WalletBalance(ctx, c)
+		return nil
+	}
+	if c := cmd.WalletHistory; c != nil {
+		go cs.n.WalletHistory(ctx, c)
+		return nil
+	}
+	if c := cmd.WalletSign; c != nil {
+		cs.n.WalletSign(ctx, c)
+		return nil
+	}
+	if c := cmd.WalletVerify; c != nil {
+		cs.n.WalletVerify(ctx, c)
+		return nil
+	}
+	if c := cmd.WalletMsig; c != nil {
+		go cs.n.WalletMsig(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychSettle; c != nil {
+		go cs.n.PaychSettle(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychTopUp; c != nil {
+		go cs.n.PaychTopUp(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychCollect; c != nil {
+		go cs.n.PaychCollect(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychList; c != nil {
+		cs.n.PaychList(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychInspect; c != nil {
+		go cs.n.PaychInspect(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychExport; c != nil {
+		go cs.n.PaychExport(ctx, c)
+		return nil
+	}
+	if c := cmd.PaychImport; c != nil {
+		go cs.n.PaychImport(ctx, c)
+		return nil
+	}
 	if c := cmd.Commit; c != nil {
 		// push requests are usually quite long so we don't block the thread so users
 		// can start a new transaction while their previous commit is uploading for example
@@ -236,6 +940,83 @@ func (cs *CommandServer) GotMsg(ctx context.Context, cmd *Command) error {
 		go cs.n.List(ctx, c)
 		return nil
 	}
+	if c := cmd.Search; c != nil {
+		go cs.n.Search(ctx, c)
+		return nil
+	}
+	if c := cmd.Label; c != nil {
+		cs.n.Label(ctx, c)
+		return nil
+	}
+	if c := cmd.Protect; c != nil {
+		cs.n.Protect(ctx, c)
+		return nil
+	}
+	if c := cmd.Amend; c != nil {
+		cs.n.Amend(ctx, c)
+		return nil
+	}
+	if c := cmd.Stats; c != nil {
+		cs.n.Stats(ctx, c)
+		return nil
+	}
+	if c := cmd.Earnings; c != nil {
+		cs.n.Earnings(ctx, c)
+		return nil
+	}
+	if c := cmd.Usage; c != nil {
+		cs.n.Usage(ctx, c)
+		return nil
+	}
+	if c := cmd.Files; c != nil {
+		cs.n.Files(ctx, c)
+		return nil
+	}
+	if c := cmd.Move; c != nil {
+		// Moves last as long as the transfer so we don't block other commands
+		go cs.n.Move(ctx, c)
+		return nil
+	}
+	if c := cmd.Region; c != nil {
+		cs.n.Region(ctx, c)
+		return nil
+	}
+	if c := cmd.Scheme; c != nil {
+		cs.n.Scheme(ctx, c)
+		return nil
+	}
+	if c := cmd.Block; c != nil {
+		cs.n.Block(ctx, c)
+		return nil
+	}
+	if c := cmd.Pin; c != nil {
+		cs.n.Pin(ctx, c)
+		return nil
+	}
+	if c := cmd.Dispatch; c != nil {
+		go cs.n.Dispatch(ctx, c)
+		return nil
+	}
+	if c := cmd.DealList; c != nil {
+		cs.n.DealList(ctx, c)
+		return nil
+	}
+	if c := cmd.DealOffline; c != nil {
+		go cs.n.DealOffline(ctx, c)
+		return nil
+	}
+	if c := cmd.DealProposals; c != nil {
+		cs.n.DealProposals(ctx, c)
+		return nil
+	}
+	if c := cmd.DealPush; c != nil {
+		go cs.n.DealPush(ctx, c)
+		return nil
+	}
+	if c := cmd.DealQueue; c != nil {
+		cs.n.DealQueue(ctx, c)
+		return nil
+	}
 	return fmt.Errorf("CommandServer: no command specified")
 }
 
@@ -318,6 +1099,26 @@ func (cc *CommandClient) WalletPay(args *WalletPayArgs) {
 	cc.send(Command{WalletPay: args})
 }
 
+func (cc *CommandClient) WalletBalance(args *WalletBalanceArgs) {
+	cc.send(Command{WalletBalance: args})
+}
+
+func (cc *CommandClient) WalletHistory(args *WalletHistoryArgs) {
+	cc.send(Command{WalletHistory: args})
+}
+
+func (cc *CommandClient) WalletSign(args *WalletSignArgs) {
+	cc.send(Command{WalletSign: args})
+}
+
+func (cc *CommandClient) WalletVerify(args *WalletVerifyArgs) {
+	cc.send(Command{WalletVerify: args})
+}
+
+func (cc *CommandClient) WalletMsig(args *WalletMsigArgs) {
+	cc.send(Command{WalletMsig: args})
+}
+
 func (cc *CommandClient) Commit(args *CommArgs) {
 	cc.send(Command{Commit: args})
 }
@@ -330,6 +1131,110 @@ func (cc *CommandClient) List(args *ListArgs) {
 	cc.send(Command{List: args})
 }
 
+func (cc *CommandClient) Search(args *SearchArgs) {
+	cc.send(Command{Search: args})
+}
+
+func (cc *CommandClient) Label(args *LabelArgs) {
+	cc.send(Command{Label: args})
+}
+
+func (cc *CommandClient) Protect(args *ProtectArgs) {
+	cc.send(Command{Protect: args})
+}
+
+func (cc *CommandClient) Amend(args *AmendArgs) {
+	cc.send(Command{Amend: args})
+}
+
+func (cc *CommandClient) Stats(args *StatsArgs) {
+	cc.send(Command{Stats: args})
+}
+
+func (cc *CommandClient) Earnings(args *EarningsArgs) {
+	cc.send(Command{Earnings: args})
+}
+
+func (cc *CommandClient) Usage(args *UsageArgs) {
+	cc.send(Command{Usage: args})
+}
+
+func (cc *CommandClient) Files(args *FilesArgs) {
+	cc.send(Command{Files: args})
+}
+
+func (cc *CommandClient) Move(args *MoveArgs) {
+	cc.send(Command{Move: args})
+}
+
+func (cc *CommandClient) Region(args *RegionArgs) {
+	cc.send(Command{Region: args})
+}
+
+func (cc *CommandClient) Scheme(args *SchemeArgs) {
+	cc.send(Command{Scheme: args})
+}
+
+func (cc *CommandClient) Block(args *BlockArgs) {
+	cc.send(Command{Block: args})
+}
+
+func (cc *CommandClient) DealList(args *DealListArgs) {
+	cc.send(Command{DealList: args})
+}
+
+func (cc *CommandClient) DealOffline(args *DealOfflineArgs) {
+	cc.send(Command{DealOffline: args})
+}
+
+func (cc *CommandClient) DealProposals(args *DealProposalsArgs) {
+	cc.send(Command{DealProposals: args})
+}
+
+func (cc *CommandClient) DealPush(args *DealPushArgs) {
+	cc.send(Command{DealPush: args})
+}
+
+func (cc *CommandClient) DealQueue(args *DealQueueArgs) {
+	cc.send(Command{DealQueue: args})
+}
+
+func (cc *CommandClient) PaychSettle(args *PaychSettleArgs) {
+	cc.send(Command{PaychSettle: args})
+}
+
+func (cc *CommandClient) PaychTopUp(args *PaychTopUpArgs) {
+	cc.send(Command{PaychTopUp: args})
+}
+
+func (cc *CommandClient) PaychCollect(args *PaychCollectArgs) {
+	cc.send(Command{PaychCollect: args})
+}
+
+func (cc *CommandClient) PaychList(args *PaychListArgs) {
+	cc.send(Command{PaychList: args})
+}
+
+func (cc *CommandClient) PaychInspect(args *PaychInspectArgs) {
+	cc.send(Command{PaychInspect: args})
+}
+
+func (cc *CommandClient) PaychExport(args *PaychExportArgs) {
+	cc.send(Command{PaychExport: args})
+}
+
+func (cc *CommandClient) PaychImport(args *PaychImportArgs) {
+	cc.send(Command{PaychImport: args})
+}
+
+func (cc *CommandClient) Pin(args *PinArgs) {
+	cc.send(Command{Pin: args})
+}
+
+func (cc *CommandClient) Dispatch(args *DispatchArgs) {
+	cc.send(Command{Dispatch: args})
+}
+
 func (cc *CommandClient) SetNotifyCallback(fn func(Notify)) {
 	cc.notify = fn
 }
@@ -0,0 +1,527 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/internal/utils"
+	sel "github.com/myelnet/pop/selectors"
+)
+
+// ErrInvalidPath is returned when a mutable file path doesn't include a namespace and a file name
+var ErrInvalidPath = errors.New("invalid path")
+
+// ErrPathNotFound is returned when no file or directory exists at the given path
+var ErrPathNotFound = errors.New("path not found")
+
+// ErrNamespaceNotFound is returned when operating on a namespace which was never written to
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+// namespaceRecord is persisted for each namespace to reload its root after a restart
+type namespaceRecord struct {
+	Root    cid.Cid
+	StoreID multistore.StoreID
+}
+
+// MFS is a set of mutable namespaces. Each namespace is a UnixFS directory tree with a rolling root CID
+// updated on every write. Blocks are kept in a dedicated store for each namespace so they are not
+// garbage collected with the index until the namespace is published in a transaction.
+type MFS struct {
+	ds datastore.Batching
+	ms *multistore.MultiStore
+
+	mu sync.Mutex
+}
+
+// NewMFS creates a new MFS persisting the namespace roots in the given datastore
+func NewMFS(ds datastore.Batching, ms *multistore.MultiStore) *MFS {
+	return &MFS{
+		ds: namespace.Wrap(ds, datastore.NewKey("/mfs")),
+		ms: ms,
+	}
+}
+
+// splitPath returns the namespace and path segments of a path formatted as /namespace/dir/file
+func splitPath(p string) (string, []string, error) {
+	p = strings.Trim(gopath.Clean("/"+p), "/")
+	if p == "" {
+		return "", nil, fmt.Errorf("%w: missing namespace", ErrInvalidPath)
+	}
+	segs := strings.Split(p, "/")
+	return segs[0], segs[1:], nil
+}
+
+func (m *MFS) record(ns string) (namespaceRecord, error) {
+	var rec namespaceRecord
+	b, err := m.ds.Get(datastore.NewKey(ns))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return rec, fmt.Errorf("%s: %w", ns, ErrNamespaceNotFound)
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(b, &rec)
+	return rec, err
+}
+
+// load returns the store and root directory of a namespace. A new namespace is created if it doesn't exist
+// and create is true.
+func (m *MFS) load(ctx context.Context, ns string, create bool) (namespaceRecord, *multistore.Store, uio.Directory, error) {
+	rec, err := m.record(ns)
+	if errors.Is(err, ErrNamespaceNotFound) && create {
+		rec.StoreID = m.ms.Next()
+		store, err := m.ms.Get(rec.StoreID)
+		if err != nil {
+			return rec, nil, nil, err
+		}
+		dir, err := newDirectory(store.DAG)
+		return rec, store, dir, err
+	}
+	if err != nil {
+		return rec, nil, nil, err
+	}
+	store, err := m.ms.Get(rec.StoreID)
+	if err != nil {
+		return rec, nil, nil, err
+	}
+	rn, err := store.DAG.Get(ctx, rec.Root)
+	if err != nil {
+		return rec, nil, nil, err
+	}
+	dir, err := uio.NewDirectoryFromNode(store.DAG, rn)
+	return rec, store, dir, err
+}
+
+// save persists the new root of a namespace
+func (m *MFS) save(ctx context.Context, ns string, rec namespaceRecord, dag ipldformat.DAGService, dir uio.Directory) (cid.Cid, error) {
+	dn, err := dir.GetNode()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := dag.Add(ctx, dn); err != nil {
+		return cid.Undef, err
+	}
+	rec.Root = dn.Cid()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return rec.Root, m.ds.Put(datastore.NewKey(ns), b)
+}
+
+func newDirectory(dag ipldformat.DAGService) (uio.Directory, error) {
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	prefix.MhType = exchange.DefaultHashFunction
+	dir := uio.NewDirectory(dag)
+	dir.SetCidBuilder(prefix)
+	return dir, nil
+}
+
+// setLink links the child node at the given path under the directory, creating any missing directory on the way.
+// A nil child removes the link instead.
+func setLink(ctx context.Context, dag ipldformat.DAGService, dir uio.Directory, segs []string, child ipldformat.Node) error {
+	name := segs[0]
+	if len(segs) == 1 {
+		if child == nil {
+			err := dir.RemoveChild(ctx, name)
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, merkledag.ErrLinkNotFound) {
+				return fmt.Errorf("%s: %w", name, ErrPathNotFound)
+			}
+			return err
+		}
+		return dir.AddChild(ctx, name, child)
+	}
+	var sub uio.Directory
+	sn, err := dir.Find(ctx, name)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && child != nil:
+		sub, err = newDirectory(dag)
+		if err != nil {
+			return err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%s: %w", name, ErrPathNotFound)
+	case err != nil:
+		return err
+	default:
+		sub, err = uio.NewDirectoryFromNode(dag, sn)
+		if err != nil {
+			return fmt.Errorf("%s is not a directory: %w", name, err)
+		}
+	}
+	if err := setLink(ctx, dag, sub, segs[1:], child); err != nil {
+		return err
+	}
+	sn, err = sub.GetNode()
+	if err != nil {
+		return err
+	}
+	if err := dag.Add(ctx, sn); err != nil {
+		return err
+	}
+	return dir.AddChild(ctx, name, sn)
+}
+
+// find returns the node at the given path under the directory
+func find(ctx context.Context, dag ipldformat.DAGService, dir uio.Directory, segs []string) (ipldformat.Node, error) {
+	n, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segs {
+		d, err := uio.NewDirectoryFromNode(dag, n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s, ErrPathNotFound)
+		}
+		n, err = d.Find(ctx, s)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", s, ErrPathNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// Root returns the current root of a namespace
+func (m *MFS) Root(ns string) (cid.Cid, error) {
+	rec, err := m.record(ns)
+	return rec.Root, err
+}
+
+// Store returns the store holding the blocks of a namespace
+func (m *MFS) Store(ns string) (*multistore.Store, error) {
+	rec, err := m.record(ns)
+	if err != nil {
+		return nil, err
+	}
+	return m.ms.Get(rec.StoreID)
+}
+
+// Namespaces returns the names of all the namespaces
+func (m *MFS) Namespaces() ([]string, error) {
+	res, err := m.ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var names []string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		names = append(names, datastore.RawKey(r.Key).BaseNamespace())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// storeIDs returns the IDs of the stores holding the namespaces. Must hold the lock.
+func (m *MFS) storeIDs() ([]multistore.StoreID, error) {
+	res, err := m.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var ids []multistore.StoreID
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var rec namespaceRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			return nil, err
+		}
+		ids = append(ids, rec.StoreID)
+	}
+	return ids, nil
+}
+
+// Write links the DAG created by the add function at the given path and returns the new root of the namespace.
+// Any existing file at the same path is replaced.
+func (m *MFS) Write(ctx context.Context, p string, add func(ipldformat.DAGService) (cid.Cid, error)) (cid.Cid, error) {
+	ns, segs, err := splitPath(p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(segs) == 0 {
+		return cid.Undef, fmt.Errorf("%w: missing file name", ErrInvalidPath)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, store, dir, err := m.load(ctx, ns, true)
+	if err != nil {
+		return cid.Undef, err
+	}
+	fc, err := add(store.DAG)
+	if err != nil {
+		return cid.Undef, err
+	}
+	fn, err := store.DAG.Get(ctx, fc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := setLink(ctx, store.DAG, dir, segs, fn); err != nil {
+		return cid.Undef, err
+	}
+	return m.save(ctx, ns, rec, store.DAG, dir)
+}
+
+// Rm removes the file or directory at the given path and returns the new root of the namespace.
+// Removing the namespace itself deletes it.
+func (m *MFS) Rm(ctx context.Context, p string) (cid.Cid, error) {
+	ns, segs, err := splitPath(p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, store, dir, err := m.load(ctx, ns, false)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(segs) == 0 {
+		if err := m.ms.Delete(rec.StoreID); err != nil {
+			return cid.Undef, err
+		}
+		return cid.Undef, m.ds.Delete(datastore.NewKey(ns))
+	}
+	if err := setLink(ctx, store.DAG, dir, segs, nil); err != nil {
+		return cid.Undef, err
+	}
+	return m.save(ctx, ns, rec, store.DAG, dir)
+}
+
+// Mv moves a file or directory to a new path in the same namespace and returns the new root of the namespace
+func (m *MFS) Mv(ctx context.Context, src, dst string) (cid.Cid, error) {
+	ns, ssegs, err := splitPath(src)
+	if err != nil {
+		return cid.Undef, err
+	}
+	dns, dsegs, err := splitPath(dst)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if ns != dns {
+		return cid.Undef, fmt.Errorf("%w: cannot move across namespaces", ErrInvalidPath)
+	}
+	if len(ssegs) == 0 || len(dsegs) == 0 {
+		return cid.Undef, fmt.Errorf("%w: missing file name", ErrInvalidPath)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, store, dir, err := m.load(ctx, ns, false)
+	if err != nil {
+		return cid.Undef, err
+	}
+	n, err := find(ctx, store.DAG, dir, ssegs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := setLink(ctx, store.DAG, dir, ssegs, nil); err != nil {
+		return cid.Undef, err
+	}
+	if err := setLink(ctx, store.DAG, dir, dsegs, n); err != nil {
+		return cid.Undef, err
+	}
+	return m.save(ctx, ns, rec, store.DAG, dir)
+}
+
+// Ls returns the names of the entries in the directory at the given path. Directories end with a slash.
+func (m *MFS) Ls(ctx context.Context, p string) ([]string, error) {
+	ns, segs, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, store, dir, err := m.load(ctx, ns, false)
+	if err != nil {
+		return nil, err
+	}
+	n, err := find(ctx, store.DAG, dir, segs)
+	if err != nil {
+		return nil, err
+	}
+	d, err := uio.NewDirectoryFromNode(store.DAG, n)
+	if err != nil {
+		// not a directory so we only list the file itself
+		return []string{segs[len(segs)-1]}, nil
+	}
+	var names []string
+	err = d.ForEachLink(ctx, func(l *ipldformat.Link) error {
+		name := l.Name
+		ln, err := l.GetNode(ctx, store.DAG)
+		if err != nil {
+			return err
+		}
+		if _, err := uio.NewDirectoryFromNode(store.DAG, ln); err == nil {
+			name += "/"
+		}
+		names = append(names, name)
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// Write adds a file at the given mutable path i.e. /namespace/dir/file.txt and returns the new root of the namespace
+func (nd *node) Write(ctx context.Context, p string, r io.Reader) (cid.Cid, error) {
+	return nd.mfs.Write(ctx, p, func(dag ipldformat.DAGService) (cid.Cid, error) {
+		return nd.Add(ctx, dag, r)
+	})
+}
+
+// Mv moves a file or directory to a new mutable path in the same namespace
+func (nd *node) Mv(ctx context.Context, src, dst string) (cid.Cid, error) {
+	return nd.mfs.Mv(ctx, src, dst)
+}
+
+// Rm removes a file or directory from a namespace
+func (nd *node) Rm(ctx context.Context, p string) (cid.Cid, error) {
+	return nd.mfs.Rm(ctx, p)
+}
+
+// Publish stages a snapshot of the namespace in the current transaction. Each top level entry of the namespace
+// is put as a transaction entry so the snapshot can be committed like any other content.
+func (nd *node) Publish(ctx context.Context, ns string) ([]string, error) {
+	store, err := nd.mfs.Store(ns)
+	if err != nil {
+		return nil, err
+	}
+	root, err := nd.mfs.Root(ns)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := store.DAG.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := uio.NewDirectoryFromNode(store.DAG, rn)
+	if err != nil {
+		return nil, err
+	}
+
+	nd.txmu.Lock()
+	defer nd.txmu.Unlock()
+	if nd.tx == nil {
+		nd.tx = nd.exch.Tx(ctx)
+	}
+
+	var keys []string
+	err = dir.ForEachLink(ctx, func(l *ipldformat.Link) error {
+		err := utils.MigrateSelectBlocks(ctx, store.Bstore, nd.tx.Store().Bstore, l.Cid, sel.All())
+		if err != nil {
+			return err
+		}
+		ln, err := l.GetNode(ctx, store.DAG)
+		if err != nil {
+			return err
+		}
+		size, err := ln.Size()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, l.Name)
+		return nd.tx.Put(l.Name, l.Cid, int64(size))
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// Files executes a mutable files operation
+func (nd *node) Files(ctx context.Context, args *FilesArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{FilesResult: &FilesResult{
+			Err: err.Error(),
+		}})
+	}
+	var root cid.Cid
+	var entries []string
+	var err error
+	switch args.Op {
+	case FilesWrite:
+		var f files.Node
+		var fstat os.FileInfo
+		fstat, err = os.Stat(args.Source)
+		if err != nil {
+			break
+		}
+		f, err = files.NewSerialFile(args.Source, false, fstat)
+		if err != nil {
+			break
+		}
+		file, ok := f.(files.File)
+		if !ok {
+			err = fmt.Errorf("%s is not a file", args.Source)
+			break
+		}
+		root, err = nd.Write(ctx, args.Path, file)
+		file.Close()
+	case FilesMv:
+		root, err = nd.Mv(ctx, args.Path, args.Dst)
+	case FilesRm:
+		root, err = nd.Rm(ctx, args.Path)
+	case FilesLs:
+		if strings.Trim(args.Path, "/") == "" {
+			entries, err = nd.mfs.Namespaces()
+			break
+		}
+		entries, err = nd.mfs.Ls(ctx, args.Path)
+		if err == nil {
+			ns, _, _ := splitPath(args.Path)
+			root, err = nd.mfs.Root(ns)
+		}
+	case FilesPublish:
+		var ns string
+		ns, _, err = splitPath(args.Path)
+		if err != nil {
+			break
+		}
+		entries, err = nd.Publish(ctx, ns)
+		if err == nil {
+			nd.txmu.Lock()
+			root = nd.tx.Root()
+			nd.txmu.Unlock()
+		}
+	default:
+		err = fmt.Errorf("unknown files operation %q", args.Op)
+	}
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &FilesResult{Entries: entries}
+	if root.Defined() {
+		res.Root = root.String()
+	}
+	nd.send(Notify{FilesResult: res})
+}
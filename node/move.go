@@ -0,0 +1,82 @@
+package node
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/internal/utils"
+)
+
+// Move sends a committed ref to another peer and removes our copy once the peer signed a receipt
+// and passed an audit, so the content remains available during the move
+func (nd *node) Move(ctx context.Context, args *MoveArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			MoveResult: &MoveResult{
+				Ref: args.Ref,
+				Err: err.Error(),
+			},
+		})
+	}
+	root, err := cid.Parse(args.Ref)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	var to peer.ID
+	// An address lets us connect to peers we never met
+	if strings.HasPrefix(args.To, "/") {
+		info, err := utils.AddrStringToAddrInfo(args.To)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if err := nd.host.Connect(ctx, *info); err != nil {
+			sendErr(err)
+			return
+		}
+		to = info.ID
+	} else {
+		to, err = peer.Decode(args.To)
+		if err != nil {
+			sendErr(ErrInvalidPeer)
+			return
+		}
+	}
+	if to == nd.host.ID() {
+		sendErr(ErrInvalidPeer)
+		return
+	}
+
+	nd.send(Notify{
+		MoveResult: &MoveResult{
+			Ref:    args.Ref,
+			Peer:   to.String(),
+			Status: "dispatching",
+		},
+	})
+	rc, err := nd.exch.R().Move(ctx, root, to)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	if err := nd.exch.Index().DropRef(root); err != nil {
+		sendErr(err)
+		return
+	}
+	if err := nd.exch.Index().GC(); err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		MoveResult: &MoveResult{
+			Ref:    args.Ref,
+			Peer:   to.String(),
+			Size:   int64(rc.Size),
+			Status: "moved",
+		},
+	})
+}
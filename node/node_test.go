@@ -3,31 +3,44 @@ package node
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	"github.com/filecoin-project/specs-actors/v4/support/mock"
 	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
 	"github.com/ipfs/go-cid"
 	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	files "github.com/ipfs/go-ipfs-files"
 	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/conngater"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/myelnet/pop/exchange"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/filecoin/storage"
+	"github.com/myelnet/pop/internal/objstore"
 	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/retrieval/deal"
 	"github.com/myelnet/pop/wallet"
 	"github.com/stretchr/testify/require"
 )
@@ -43,7 +56,15 @@ func newTestNode(ctx context.Context, mn mocknet.Mocknet, t *testing.T) *node {
 	nd.ms = tn.Ms
 	nd.dag = tn.DAG
 	nd.host = tn.Host
-	nd.omg = NewOfferMgr()
+	nd.si = NewSearchIndex(nd.ds)
+	nd.mfs = NewMFS(nd.ds, nd.ms)
+	nd.ps, err = NewProtectSet(nd.host, nd.ds)
+	require.NoError(t, err)
+	nd.gater, err = conngater.NewBasicConnectionGater(nd.ds)
+	require.NoError(t, err)
+	nd.stats, err = NewStats(nd.ds)
+	require.NoError(t, err)
+	nd.accounts = NewAccounting(nd.ds)
 	opts := exchange.Options{
 		Blockstore:  nd.bs,
 		MultiStore:  nd.ms,
@@ -53,6 +74,7 @@ func newTestNode(ctx context.Context, mn mocknet.Mocknet, t *testing.T) *node {
 	opts.Wallet = wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(opts.FilecoinAPI), wallet.WithBLSSig(bls{}))
 	nd.exch, err = exchange.New(ctx, nd.host, nd.ds, opts)
 	require.NoError(t, err)
+	nd.exch.Index().OnDrop(nd.si.refDropped)
 
 	return nd
 }
@@ -127,6 +149,68 @@ func TestPut(t *testing.T) {
 	}
 }
 
+func TestPutObjects(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+
+	objects := map[string][]byte{
+		"data/a.txt":     make([]byte, 64000),
+		"data/b.txt":     make([]byte, 128000),
+		"data/sub/a.txt": make([]byte, 32000),
+	}
+	for _, data := range objects {
+		rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	}
+	gets := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bucket" {
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>data/a.txt</Key><Size>64000</Size></Contents><Contents><Key>data/b.txt</Key><Size>128000</Size></Contents><Contents><Key>data/sub/a.txt</Key><Size>32000</Size></Contents></ListBucketResult>`)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		gets <- key
+		w.Write(objects[key])
+	}))
+	defer srv.Close()
+
+	cn.opts.ObjectStores = map[string]objstore.Config{
+		objstore.SchemeS3: {Endpoint: srv.URL, Region: "us-east-1"},
+	}
+
+	added := make(chan *PutResult, 3)
+	cn.notify = func(n Notify) {
+		require.Equal(t, "", n.PutResult.Err)
+		added <- n.PutResult
+	}
+	cn.Put(ctx, &PutArgs{Path: "s3://bucket/data/"})
+	for i := 0; i < 3; i++ {
+		<-added
+	}
+	require.Len(t, gets, 3)
+
+	// objects are keyed by their path relative to the prefix
+	status, err := cn.tx.Status()
+	require.NoError(t, err)
+	require.Equal(t, int64(64000), status["a.txt"].Size)
+	require.Equal(t, int64(128000), status["b.txt"].Size)
+	require.Equal(t, int64(32000), status["sub/a.txt"].Size)
+
+	f, err := cn.tx.GetFile("sub/a.txt")
+	require.NoError(t, err)
+	size, err := f.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(32000), size)
+
+	// running it again doesn't download the objects already staged
+	cn.Put(ctx, &PutArgs{Path: "s3://bucket/data/"})
+	for i := 0; i < 3; i++ {
+		<-added
+	}
+	require.Len(t, gets, 3)
+}
+
 // Put shouldn't race as it's protected with a mutex
 func TestPutRace(t *testing.T) {
 	ctx := context.Background()
@@ -240,6 +324,65 @@ func TestPutGet(t *testing.T) {
 	require.Equal(t, data2, newb)
 }
 
+func TestPutGetNested(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+
+	// site/index.html and site/assets/img/logo.png
+	dir := t.TempDir()
+	site := filepath.Join(dir, "site")
+	require.NoError(t, os.MkdirAll(filepath.Join(site, "assets", "img"), 0755))
+	index := []byte("<html></html>")
+	require.NoError(t, os.WriteFile(filepath.Join(site, "index.html"), index, 0666))
+	logo := make([]byte, 256000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(logo)
+	require.NoError(t, os.WriteFile(filepath.Join(site, "assets", "img", "logo.png"), logo, 0666))
+
+	added := make(chan *PutResult, 2)
+	cn.notify = func(n Notify) {
+		require.Equal(t, "", n.PutResult.Err)
+		added <- n.PutResult
+	}
+	cn.Put(ctx, &PutArgs{Path: site})
+	<-added
+	pr := <-added
+
+	// nested directories are not flattened
+	status, err := cn.tx.Status()
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+	require.Equal(t, int64(len(logo)), status["assets"].Size)
+
+	loc := make(chan bool, 1)
+	cn.notify = func(n Notify) {
+		require.Equal(t, "", n.GetResult.Err)
+		loc <- n.GetResult.Local
+	}
+	newp := filepath.Join(dir, "logo.png")
+	cn.Get(ctx, &GetArgs{
+		Cid:      fmt.Sprintf("/%s/assets/img/logo.png", pr.RootCid),
+		Out:      newp,
+		Strategy: "SelectFirst",
+		Timeout:  1,
+	})
+	<-loc
+
+	b, err := os.ReadFile(newp)
+	require.NoError(t, err)
+	require.Equal(t, logo, b)
+
+	// the whole tree can be exported too
+	assets, err := cn.tx.GetFile("assets")
+	require.NoError(t, err)
+	out := filepath.Join(dir, "assets")
+	require.NoError(t, cn.writeOut(ctx, assets, "assets", out))
+	b, err = os.ReadFile(filepath.Join(out, "img", "logo.png"))
+	require.NoError(t, err)
+	require.Equal(t, logo, b)
+}
+
 func TestCommit(t *testing.T) {
 	var err error
 	ctx := context.Background()
@@ -252,6 +395,7 @@ func TestCommit(t *testing.T) {
 	cn.ms = tn.Ms
 	cn.dag = tn.DAG
 	cn.host = tn.Host
+	cn.si = NewSearchIndex(cn.ds)
 	opts := exchange.Options{
 		Blockstore:  cn.bs,
 		MultiStore:  cn.ms,
@@ -294,6 +438,10 @@ func TestCommit(t *testing.T) {
 	committed := make(chan []string, 3)
 	cn.notify = func(n Notify) {
 		require.Equal(t, n.CommResult.Err, "")
+		// skip the progress of the dispatch
+		if n.CommResult.Progress != "" {
+			return
+		}
 		committed <- n.CommResult.Caches
 	}
 	cn.Commit(ctx, &CommArgs{
@@ -331,6 +479,10 @@ func TestCommit(t *testing.T) {
 	committed = make(chan []string, 3)
 	cn.notify = func(n Notify) {
 		require.Equal(t, n.CommResult.Err, "")
+		// skip the progress of the dispatch
+		if n.CommResult.Progress != "" {
+			return
+		}
 		committed <- n.CommResult.Caches
 	}
 	cn.Commit(ctx, &CommArgs{
@@ -433,6 +585,249 @@ func TestGet(t *testing.T) {
 	require.EqualValues(t, data, dataout)
 }
 
+func TestMinerFallback(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 20*time.Second)
+	defer cancel()
+	mn := mocknet.New(bgCtx)
+
+	// The provider plays the storage miner holding the content
+	pn := newTestNode(bgCtx, mn, t)
+	cn := newTestNode(bgCtx, mn, t)
+
+	// The peers are not connected so no cache answers the gossip query
+	require.NoError(t, mn.LinkAll())
+
+	data := make([]byte, 128000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	p := filepath.Join(t.TempDir(), "data1")
+	require.NoError(t, os.WriteFile(p, data, 0666))
+
+	added := make(chan string, 1)
+	pn.notify = func(n Notify) {
+		require.Equal(t, n.PutResult.Err, "")
+		added <- n.PutResult.Cid
+	}
+	pn.Put(ctx, &PutArgs{
+		Path:      p,
+		ChunkSize: 1024,
+	})
+	<-added
+
+	ref, err := pn.getRef("")
+	require.NoError(t, err)
+	committed := make(chan struct{}, 1)
+	pn.notify = func(n Notify) {
+		require.Equal(t, n.CommResult.Err, "")
+		committed <- struct{}{}
+	}
+	pn.Commit(ctx, &CommArgs{
+		CacheRF: 0,
+	})
+	<-committed
+
+	miner := tutils.NewIDAddr(t, 1000)
+	pid := pn.host.ID()
+	var maddrs []abi.Multiaddrs
+	for _, a := range pn.host.Addrs() {
+		maddrs = append(maddrs, a.Bytes())
+	}
+	api := cn.exch.FilecoinAPI().(*filecoin.MockLotusAPI)
+	api.SetMinerInfo(miner, filecoin.MinerInfo{
+		PeerId:     &pid,
+		Multiaddrs: maddrs,
+	})
+
+	st, err := storage.New(cn.host, cn.exch.DataTransfer(), cn.exch.Wallet(), api)
+	require.NoError(t, err)
+	cn.deals = storage.NewDealTracker(st, cn.ds)
+	blockGen := blocksutil.NewBlockGenerator()
+	require.NoError(t, cn.deals.Track(storage.DealRecord{
+		ProposalCid: blockGen.Next().Cid(),
+		Root:        ref.PayloadCID,
+		Miner:       miner,
+		Status:      storage.DealActive,
+	}))
+
+	got := make(chan GetResult, 1)
+	cn.notify = func(n Notify) {
+		require.Equal(t, n.GetResult.Err, "")
+		if n.GetResult.Status == "Completed" {
+			got <- *n.GetResult
+		}
+	}
+	cn.Get(ctx, &GetArgs{
+		Cid:      fmt.Sprintf("/%s/data1", ref.PayloadCID),
+		Strategy: "SelectFirst",
+		Timeout:  1,
+	})
+	select {
+	case <-got:
+	case <-ctx.Done():
+		t.Fatal("failed to retrieve from the storage miner")
+	}
+}
+
+func TestDealPush(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(bgCtx)
+
+	cn := newTestNode(bgCtx, mn, t)
+
+	commit := func(name string) *exchange.DataRef {
+		data := make([]byte, 128000)
+		rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+		p := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(p, data, 0666))
+
+		added := make(chan string, 1)
+		cn.notify = func(n Notify) {
+			require.Equal(t, n.PutResult.Err, "")
+			added <- n.PutResult.Cid
+		}
+		cn.Put(ctx, &PutArgs{
+			Path:      p,
+			ChunkSize: 1024,
+		})
+		<-added
+
+		ref, err := cn.getRef("")
+		require.NoError(t, err)
+		committed := make(chan struct{}, 1)
+		cn.notify = func(n Notify) {
+			require.Equal(t, n.CommResult.Err, "")
+			committed <- struct{}{}
+		}
+		cn.Commit(ctx, &CommArgs{
+			CacheRF: 0,
+		})
+		<-committed
+		return ref
+	}
+	ref := commit("data1")
+
+	api := cn.exch.FilecoinAPI().(*filecoin.MockLotusAPI)
+	st, err := storage.New(cn.host, cn.exch.DataTransfer(), cn.exch.Wallet(), api)
+	require.NoError(t, err)
+	cn.storage = st
+	cn.deals = storage.NewDealTracker(st, cn.ds)
+	// the queue is not running so the jobs stay queued
+	cn.queue = storage.NewDealQueue(st, cn.deals, cn.ds, storage.DefaultQueueOptions)
+
+	results := make(chan *DealResult, 1)
+	cn.notify = func(n Notify) {
+		results <- n.DealResult
+	}
+
+	m1 := tutils.NewIDAddr(t, 1001)
+	m2 := tutils.NewIDAddr(t, 1002)
+	cn.DealPush(ctx, &DealPushArgs{
+		Ref:    ref.PayloadCID.String(),
+		Miners: []string{m1.String(), m2.String()},
+		Prices: []string{"0.0000001FIL", "0.0000002FIL"},
+	})
+	res := <-results
+	require.Equal(t, "", res.Err)
+	require.Len(t, res.Jobs, 2)
+
+	// without prices the miners are offered their ask
+	m3 := tutils.NewIDAddr(t, 1003)
+	cn.DealPush(ctx, &DealPushArgs{
+		Ref:    ref.PayloadCID.String(),
+		Miners: []string{m3.String()},
+	})
+	res = <-results
+	require.Equal(t, "", res.Err)
+	require.Len(t, res.Jobs, 1)
+
+	jobs, err := cn.queue.List()
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	prices := make(map[address.Address]*abi.TokenAmount)
+	for _, job := range jobs {
+		require.Equal(t, ref.PayloadCID, job.Root)
+		require.Equal(t, storage.JobQueued, job.Status)
+		prices[job.Miner] = job.Price
+	}
+	for m, fil := range map[address.Address]string{m1: "0.0000001FIL", m2: "0.0000002FIL"} {
+		expected, err := filecoin.ParseFIL(fil)
+		require.NoError(t, err)
+		require.NotNil(t, prices[m])
+		require.True(t, prices[m].Equals(abi.TokenAmount(expected)))
+	}
+	require.Nil(t, prices[m3])
+
+	// prices must match the miners
+	cn.DealPush(ctx, &DealPushArgs{
+		Ref:    ref.PayloadCID.String(),
+		Miners: []string{m1.String(), m2.String()},
+		Prices: []string{"0.0000001FIL"},
+	})
+	res = <-results
+	require.NotEqual(t, "", res.Err)
+
+	// the max price is parsed in FIL like the prices
+	cn.DealPush(ctx, &DealPushArgs{
+		Ref:      ref.PayloadCID.String(),
+		MaxPrice: "1USD",
+	})
+	res = <-results
+	require.NotEqual(t, "", res.Err)
+
+	// multiple refs are packed in a single piece
+	ref2 := commit("data2")
+	cn.notify = func(n Notify) {
+		results <- n.DealResult
+	}
+	cn.DealPush(ctx, &DealPushArgs{
+		Ref:    ref.PayloadCID.String(),
+		Refs:   []string{ref2.PayloadCID.String()},
+		Miners: []string{m1.String()},
+	})
+	res = <-results
+	require.Equal(t, "", res.Err)
+	require.Len(t, res.Jobs, 1)
+	require.NotEqual(t, "", res.Aggregate)
+	require.Equal(t, res.Aggregate, res.Jobs[0].Root)
+
+	aroot, err := cid.Decode(res.Aggregate)
+	require.NoError(t, err)
+	for _, r := range []cid.Cid{ref.PayloadCID, ref2.PayloadCID} {
+		root, _, err := cn.deals.AggregateOf(r)
+		require.NoError(t, err)
+		require.Equal(t, aroot, root)
+	}
+}
+
+func TestDealPolicies(t *testing.T) {
+	require.Len(t, dealPolicies(&DealPushArgs{}, time.Hour), 0)
+	require.Len(t, dealPolicies(&DealPushArgs{
+		MinReputation:  50,
+		Regions:        []string{"Europe"},
+		MaxLatency:     time.Second,
+		DistinctOwners: true,
+		SectorLifetime: true,
+	}, time.Hour), 5)
+}
+
+func TestUnsealAllowed(t *testing.T) {
+	free := deal.Offer{UnsealPrice: abi.NewTokenAmount(0)}
+	paid := deal.Offer{UnsealPrice: abi.NewTokenAmount(1000)}
+
+	// 0 refuses to pay for unsealing
+	require.True(t, unsealAllowed(free, 0))
+	require.True(t, unsealAllowed(deal.Offer{}, 0))
+	require.False(t, unsealAllowed(paid, 0))
+
+	require.False(t, unsealAllowed(paid, 999))
+	require.True(t, unsealAllowed(paid, 1000))
+	require.True(t, unsealAllowed(paid, -1))
+}
+
 func TestList(t *testing.T) {
 	blockGen := blocksutil.NewBlockGenerator()
 	ctx := context.Background()
@@ -459,6 +854,411 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestDispatchPrivate(t *testing.T) {
+	blockGen := blocksutil.NewBlockGenerator()
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+
+	pub := blockGen.Next().Cid()
+	require.NoError(t, cn.exch.Index().SetRef(&exchange.DataRef{
+		PayloadCID:  pub,
+		PayloadSize: 100,
+	}))
+	priv := blockGen.Next().Cid()
+	require.NoError(t, cn.exch.Index().SetRef(&exchange.DataRef{
+		PayloadCID:  priv,
+		PayloadSize: 200,
+		Private:     true,
+	}))
+
+	items, opts, err := cn.dispatchBatch(&DispatchArgs{Refs: []string{pub.String()}, CacheRF: 3})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, 3, opts.RF)
+	require.False(t, opts.Private)
+
+	// a single private ref keeps the whole batch off the public index of the caches
+	items, opts, err = cn.dispatchBatch(&DispatchArgs{Refs: []string{pub.String(), priv.String()}})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, uint64(200), items[1].Size)
+	require.True(t, opts.Private)
+}
+
+func TestPublicIndex(t *testing.T) {
+	blockGen := blocksutil.NewBlockGenerator()
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+	cn.opts.Regions = []string{"Global"}
+
+	for i := 0; i < 160; i++ {
+		require.NoError(t, cn.exch.Index().SetRef(&exchange.DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 100,
+			// every third ref is private
+			Private: i%3 == 0,
+		}))
+	}
+
+	s := &server{node: cn}
+	h := s.publicIndexHandler(2)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var idx PublicIndex
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&idx))
+	require.Len(t, idx.Refs, 100)
+	require.False(t, idx.Last)
+	require.Equal(t, "0", idx.Refs[0].Prices["Global"])
+
+	req = httptest.NewRequest(http.MethodGet, "/?page=1", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	idx = PublicIndex{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&idx))
+	require.Len(t, idx.Refs, 6)
+	require.True(t, idx.Last)
+
+	// we're over the rate limit now
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestSignerServer(t *testing.T) {
+	ctx := context.Background()
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore())
+
+	// we never serve our keys without authenticating clients
+	_, err := newSignerServer(w, Options{SignerAddr: ":0"})
+	require.Equal(t, ErrSignerTLS, err)
+	_, err = newSignerServer(w, Options{SignerAddr: ":0", SignerTLS: &tls.Config{}})
+	require.Equal(t, ErrSignerTLS, err)
+
+	conf := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+	// nothing to sign with
+	_, err = newSignerServer(w, Options{SignerAddr: ":0", SignerTLS: conf})
+	require.Error(t, err)
+
+	_, err = w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+	srv, err := newSignerServer(w, Options{SignerAddr: ":0", SignerTLS: conf})
+	require.NoError(t, err)
+	require.Equal(t, conf, srv.TLSConfig)
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+
+	now := time.Now()
+	require.NoError(t, cn.si.Put(Manifest{
+		Root:        "root1",
+		Keys:        []string{"logo.png", "index.html"},
+		Tags:        []string{"website"},
+		Description: "Landing page assets",
+		Created:     now,
+	}))
+	require.NoError(t, cn.si.Put(Manifest{
+		Root:    "root2",
+		Keys:    []string{"logo.svg"},
+		Tags:    []string{"branding"},
+		Created: now.Add(time.Second),
+	}))
+
+	list, err := cn.si.Search("LOGO")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	// most recent first when scores are equal
+	require.Equal(t, "root2", list[0].Root)
+
+	list, err = cn.si.Search("logo landing")
+	require.NoError(t, err)
+	require.Equal(t, "root1", list[0].Root)
+
+	out := make(chan *SearchResult, 2)
+	cn.notify = func(n Notify) {
+		out <- n.SearchResult
+	}
+	cn.Search(ctx, &SearchArgs{Query: "nothing"})
+	res := <-out
+	require.Equal(t, ErrNoMatch.Error(), res.Err)
+
+	cn.Search(ctx, &SearchArgs{Query: "branding"})
+	res = <-out
+	require.Equal(t, "root2", res.Root)
+	require.True(t, res.Last)
+
+	// manifests are removed with the content they describe
+	root := testutil.CreateRandomBlock(t, cn.bs).Cid()
+	require.NoError(t, cn.si.Put(Manifest{
+		Root:    root.String(),
+		Tags:    []string{"ephemeral"},
+		Created: now,
+	}))
+	require.NoError(t, cn.exch.Index().SetRef(&exchange.DataRef{
+		PayloadCID:  root,
+		PayloadSize: 100,
+	}))
+	require.NoError(t, cn.exch.Index().DropRef(root))
+	list, err = cn.si.Search("ephemeral")
+	require.NoError(t, err)
+	require.Len(t, list, 0)
+}
+
+func TestProtect(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+	pn1 := newTestNode(ctx, mn, t)
+	pn2 := newTestNode(ctx, mn, t)
+
+	out := make(chan *ProtectResult, 1)
+	cn.notify = func(n Notify) {
+		out <- n.ProtectResult
+	}
+
+	cn.Protect(ctx, &ProtectArgs{Peers: []string{pn1.host.ID().String(), pn2.host.ID().String()}})
+	res := <-out
+	require.Equal(t, "", res.Err)
+	require.Len(t, res.Peers, 2)
+
+	cn.Protect(ctx, &ProtectArgs{Peers: []string{pn1.host.ID().String()}, Unprotect: true})
+	res = <-out
+	require.Equal(t, []string{pn2.host.ID().String()}, res.Peers)
+
+	cn.Protect(ctx, &ProtectArgs{Peers: []string{"notapeer"}})
+	res = <-out
+	require.Equal(t, ErrInvalidPeer.Error(), res.Err)
+
+	// the protected set is reloaded from the datastore
+	ps, err := NewProtectSet(cn.host, cn.ds)
+	require.NoError(t, err)
+	peers, err := ps.List()
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{pn2.host.ID()}, peers)
+}
+
+func TestBlock(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+	pn1 := newTestNode(ctx, mn, t)
+	pn2 := newTestNode(ctx, mn, t)
+
+	out := make(chan *BlockResult, 1)
+	cn.notify = func(n Notify) {
+		out <- n.BlockResult
+	}
+
+	cn.Block(ctx, &BlockArgs{Targets: []string{pn1.host.ID().String(), pn2.host.ID().String(), "10.0.0.0/8"}})
+	res := <-out
+	require.Equal(t, "", res.Err)
+	require.Len(t, res.Peers, 2)
+	require.Equal(t, []string{"10.0.0.0/8"}, res.Subnets)
+
+	cn.Block(ctx, &BlockArgs{Targets: []string{pn1.host.ID().String(), "10.0.0.0/8"}, Unblock: true})
+	res = <-out
+	require.Equal(t, []string{pn2.host.ID().String()}, res.Peers)
+	require.Len(t, res.Subnets, 0)
+
+	cn.Block(ctx, &BlockArgs{Targets: []string{"notapeer"}})
+	res = <-out
+	require.Equal(t, ErrInvalidBlockTarget.Error(), res.Err)
+
+	// the lists are reloaded from the datastore
+	gater, err := conngater.NewBasicConnectionGater(cn.ds)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{pn2.host.ID()}, gater.ListBlockedPeers())
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	now := time.Now()
+	nd.stats.now = func() time.Time { return now }
+
+	// a deal is recorded as it progresses and only counted once
+	state := deal.ProviderState{Receiver: peer.ID("client"), FundsReceived: abi.NewTokenAmount(100)}
+	state.ID = deal.ID(1)
+	state.TotalSent = 1000
+	nd.stats.RecordDeal(state)
+	state.TotalSent = 3000
+	state.FundsReceived = abi.NewTokenAmount(300)
+	state.Status = deal.StatusCompleted
+	nd.stats.RecordDeal(state)
+
+	nd.stats.RecordQueries(3, 1)
+	nd.stats.RecordPeers(map[string]int{"Global": 2, "Europe": 1})
+	nd.stats.RecordPeers(map[string]int{"Global": 1})
+
+	// the next day starts a new bucket
+	now = now.Add(24 * time.Hour)
+	nd.stats.RecordQueries(4, 1)
+
+	days, err := nd.stats.Since(now.Add(-30 * 24 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, days, 2)
+	require.Equal(t, now.Add(-24*time.Hour).UTC().Format(dayFormat), days[0].Day)
+	require.Equal(t, uint64(3000), days[0].BytesServed)
+	require.Equal(t, abi.NewTokenAmount(300), days[0].Earnings)
+	require.Equal(t, 0.75, days[0].HitRate())
+	require.Equal(t, map[string]int{"Global": 2, "Europe": 1}, days[0].Peers)
+	require.Equal(t, uint64(1), days[1].CacheHits)
+	require.Equal(t, uint64(0), days[1].CacheMisses)
+
+	days, err = nd.stats.Since(now)
+	require.NoError(t, err)
+	require.Len(t, days, 1)
+
+	// buckets are reloaded from the datastore
+	s, err := NewStats(nd.ds)
+	require.NoError(t, err)
+	s.now = nd.stats.now
+	days, err = s.Since(now.Add(-30 * 24 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, days, 2)
+
+	d, err := ParseSince("30d")
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, d)
+	d, err = ParseSince("12h")
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Hour, d)
+	_, err = ParseSince("ad")
+	require.ErrorIs(t, err, ErrInvalidSince)
+
+	// a node without stats reports it instead of crashing
+	nd.stats = nil
+	out := make(chan *StatsResult, 1)
+	nd.notify = func(n Notify) {
+		out <- n.StatsResult
+	}
+	nd.Stats(ctx, &StatsArgs{})
+	require.Equal(t, ErrNoStats.Error(), (<-out).Err)
+}
+
+func TestAccounting(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	blockGen := blocksutil.NewBlockGenerator()
+	root := blockGen.Next().Cid()
+
+	// earnings are recorded as the deal progresses and only counted once
+	ps := deal.ProviderState{Receiver: peer.ID("client"), FundsReceived: abi.NewTokenAmount(100)}
+	ps.ID = deal.ID(1)
+	ps.PayloadCID = root
+	nd.accounts.RecordProviderDeal(ps)
+	// the amount already recorded survives a restart
+	nd.accounts = NewAccounting(nd.ds)
+	nd.accounts.RecordProviderDeal(ps)
+	ps.FundsReceived = abi.NewTokenAmount(300)
+	ps.Status = deal.StatusCompleted
+	nd.accounts.RecordProviderDeal(ps)
+
+	// spending for the same content from another provider
+	cs := deal.ClientState{Sender: peer.ID("provider"), FundsSpent: abi.NewTokenAmount(50)}
+	cs.ID = deal.ID(1)
+	cs.PayloadCID = root
+	nd.accounts.RecordClientDeal(cs)
+	cs.Status = deal.StatusCompleted
+	nd.accounts.RecordClientDeal(cs)
+
+	accs, err := nd.accounts.List(AccountByPeer)
+	require.NoError(t, err)
+	require.Len(t, accs, 2)
+	require.Equal(t, peer.ID("client").String(), accs[0].Key)
+	require.Equal(t, abi.NewTokenAmount(300), accs[0].Earned)
+	require.Equal(t, abi.NewTokenAmount(50), accs[1].Spent)
+	require.Equal(t, abi.NewTokenAmount(-50), accs[1].Net())
+
+	accs, err = nd.accounts.List(AccountByCID)
+	require.NoError(t, err)
+	require.Len(t, accs, 1)
+	require.Equal(t, root.String(), accs[0].Key)
+	require.Equal(t, abi.NewTokenAmount(250), accs[0].Net())
+
+	_, err = nd.accounts.List("day")
+	require.ErrorIs(t, err, ErrInvalidAccountKind)
+}
+
+func TestMFS(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	_, err := nd.Write(ctx, "/blog", strings.NewReader("no file name"))
+	require.ErrorIs(t, err, ErrInvalidPath)
+
+	r1, err := nd.Write(ctx, "/blog/posts/hello.md", strings.NewReader("hello"))
+	require.NoError(t, err)
+	r2, err := nd.Write(ctx, "/blog/index.html", strings.NewReader("<html></html>"))
+	require.NoError(t, err)
+	require.NotEqual(t, r1, r2)
+
+	entries, err := nd.mfs.Ls(ctx, "/blog")
+	require.NoError(t, err)
+	require.Equal(t, []string{"index.html", "posts/"}, entries)
+
+	r3, err := nd.Mv(ctx, "/blog/posts/hello.md", "/blog/archive/2021/hello.md")
+	require.NoError(t, err)
+	require.NotEqual(t, r2, r3)
+	entries, err = nd.mfs.Ls(ctx, "/blog/archive/2021")
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello.md"}, entries)
+
+	_, err = nd.Rm(ctx, "/blog/posts/hello.md")
+	require.ErrorIs(t, err, ErrPathNotFound)
+	_, err = nd.Rm(ctx, "/blog/posts")
+	require.NoError(t, err)
+	_, err = nd.Mv(ctx, "/blog/index.html", "/other/index.html")
+	require.ErrorIs(t, err, ErrInvalidPath)
+
+	// the root survives a restart
+	root, err := nd.mfs.Root("blog")
+	require.NoError(t, err)
+	m := NewMFS(nd.ds, nd.ms)
+	root2, err := m.Root("blog")
+	require.NoError(t, err)
+	require.Equal(t, root, root2)
+	names, err := m.Namespaces()
+	require.NoError(t, err)
+	require.Equal(t, []string{"blog"}, names)
+
+	// publishing a snapshot stages the top level entries in the transaction
+	keys, err := nd.Publish(ctx, "blog")
+	require.NoError(t, err)
+	require.Equal(t, []string{"archive", "index.html"}, keys)
+	f, err := nd.tx.GetFile("archive/2021/hello.md")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	_, err = nd.Rm(ctx, "/blog")
+	require.NoError(t, err)
+	_, err = nd.mfs.Root("blog")
+	require.ErrorIs(t, err, ErrNamespaceNotFound)
+}
+
 // Commit 2 different files into a single transaction and then retrieve (Get)
 // the files individually with 2 separate operations. Both Get operations are on the
 // same transaction (ref) and based on the same root CID but retrieve 2 different files.
@@ -572,6 +1372,143 @@ func TestImportKey(t *testing.T) {
 	require.Equal(t, expected, n.exch.Wallet().DefaultAddress())
 }
 
+func TestWalletAddress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+	n := newTestNode(ctx, mn, t)
+
+	addr, err := n.exch.Wallet().NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	from, err := n.walletAddress(addr.String())
+	require.NoError(t, err)
+	require.Equal(t, addr, from)
+
+	// We cannot pay from an address we don't hold the key for
+	_, err = n.walletAddress("f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq")
+	require.Error(t, err)
+
+	_, err = n.walletAddress("notanaddress")
+	require.Error(t, err)
+}
+
+func TestWalletPay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+	n := newTestNode(ctx, mn, t)
+
+	// the exchange creates the default address
+	def := n.exch.Wallet().DefaultAddress()
+	other, err := n.exch.Wallet().NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	api := n.exch.FilecoinAPI().(*filecoin.MockLotusAPI)
+	api.SetActor(&filecoin.Actor{
+		Nonce:   1,
+		Balance: big.NewInt(1000000000000000000),
+	})
+	blockGen := blocksutil.NewBlockGenerator()
+	lookup := func(code exitcode.ExitCode) *filecoin.MsgLookup {
+		return &filecoin.MsgLookup{
+			Message: blockGen.Next().Cid(),
+			Receipt: filecoin.MessageReceipt{ExitCode: code},
+		}
+	}
+
+	results := make(chan *WalletResult, 1)
+	n.notify = func(n Notify) {
+		results <- n.WalletResult
+	}
+	to := tutils.NewIDAddr(t, 1001)
+
+	// pays from the default address when none is given
+	go n.WalletPay(ctx, &WalletPayArgs{
+		To:     to.String(),
+		Amount: "0.1",
+	})
+	api.SetMsgLookup(lookup(0))
+	res := <-results
+	require.Equal(t, "", res.Err)
+	require.Equal(t, []string{def.String()}, res.Addresses)
+
+	go n.WalletPay(ctx, &WalletPayArgs{
+		From:   other.String(),
+		To:     to.String(),
+		Amount: "0.1",
+	})
+	api.SetMsgLookup(lookup(0))
+	res = <-results
+	require.Equal(t, "", res.Err)
+	require.Equal(t, []string{other.String()}, res.Addresses)
+
+	// the message failed on chain
+	go n.WalletPay(ctx, &WalletPayArgs{
+		To:     to.String(),
+		Amount: "0.1",
+	})
+	api.SetMsgLookup(lookup(1))
+	res = <-results
+	require.NotEqual(t, "", res.Err)
+
+	// we cannot pay from an address we don't hold the key for
+	n.WalletPay(ctx, &WalletPayArgs{
+		From:   "f1xcgnjvnqtt6mvjkdz5z3a4fcq4sxbzlxkg4a4pq",
+		To:     to.String(),
+		Amount: "0.1",
+	})
+	res = <-results
+	require.NotEqual(t, "", res.Err)
+
+	n.WalletPay(ctx, &WalletPayArgs{
+		To:     "notanaddress",
+		Amount: "0.1",
+	})
+	res = <-results
+	require.NotEqual(t, "", res.Err)
+}
+
+func TestFundsEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+	n := newTestNode(ctx, mn, t)
+
+	addr := n.exch.Wallet().DefaultAddress()
+
+	api := n.exch.FilecoinAPI().(*filecoin.MockLotusAPI)
+	api.SetActor(&filecoin.Actor{Balance: filecoin.NewInt(1000)})
+
+	var events []*FundsEventResult
+	n.notify = func(n Notify) {
+		require.NotNil(t, n.FundsEvent)
+		events = append(events, n.FundsEvent)
+	}
+
+	// The first check only records the current balance
+	n.checkFunds(ctx)
+	require.Len(t, events, 0)
+
+	api.SetActor(&filecoin.Actor{Balance: filecoin.NewInt(1500)})
+	n.checkFunds(ctx)
+	require.Len(t, events, 1)
+	require.Equal(t, addr.String(), events[0].Address)
+	require.Equal(t, "incoming", events[0].Direction)
+	require.Equal(t, filecoin.FIL(filecoin.NewInt(500)).Short(), events[0].Amount)
+	require.Equal(t, filecoin.FIL(filecoin.NewInt(1500)).Short(), events[0].Balance)
+
+	// Nothing changed
+	n.checkFunds(ctx)
+	require.Len(t, events, 1)
+
+	api.SetActor(&filecoin.Actor{Balance: filecoin.NewInt(200)})
+	n.checkFunds(ctx)
+	require.Len(t, events, 2)
+	require.Equal(t, "outgoing", events[1].Direction)
+	require.Equal(t, filecoin.FIL(filecoin.NewInt(1300)).Short(), events[1].Amount)
+}
+
 // Preload is a full integration test for gradually retrieving a DAG paid with a single
 // payment channel
 func TestPreload(t *testing.T) {
@@ -597,6 +1534,8 @@ func TestPreload(t *testing.T) {
 		RepoPath:    t.TempDir(),
 		Regions:     []exchange.Region{region},
 		FilecoinAPI: pfapi,
+		// submit the vouchers after every transfer so we can settle the channel
+		CheckpointAmount: filecoin.NewInt(1),
 	}
 	popts.Wallet = wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(popts.FilecoinAPI))
 	pn.exch, err = exchange.New(ctx, pn.host, pn.ds, popts)
@@ -610,7 +1549,6 @@ func TestPreload(t *testing.T) {
 	cn.ms = tn2.Ms
 	cn.dag = tn2.DAG
 	cn.host = tn2.Host
-	cn.omg = NewOfferMgr()
 	cfapi := filecoin.NewMockLotusAPI()
 	copts := exchange.Options{
 		Blockstore:  cn.bs,
@@ -755,7 +1693,7 @@ loop:
 	require.Equal(t, 1, len(channels))
 
 	// We retrieved all the keys so the offer should be removed
-	_, err = cn.omg.GetOffer(root)
+	_, err = cn.exch.Offers().Get(root)
 	require.Error(t, err)
 
 	// update the actor state so the manager can settle things
@@ -786,6 +1724,8 @@ func TestLoadKey(t *testing.T) {
 		RepoPath:    t.TempDir(),
 		Regions:     []exchange.Region{region},
 		FilecoinAPI: pfapi,
+		// submit the vouchers after every transfer so we can settle the channel
+		CheckpointAmount: filecoin.NewInt(1),
 	}
 	popts.Wallet = wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(popts.FilecoinAPI))
 	pn.exch, err = exchange.New(ctx, pn.host, pn.ds, popts)
@@ -799,7 +1739,6 @@ func TestLoadKey(t *testing.T) {
 	cn.ms = tn2.Ms
 	cn.dag = tn2.DAG
 	cn.host = tn2.Host
-	cn.omg = NewOfferMgr()
 	cfapi := filecoin.NewMockLotusAPI()
 	copts := exchange.Options{
 		Blockstore:  cn.bs,
@@ -930,6 +1869,8 @@ func TestLoadAll(t *testing.T) {
 		RepoPath:    t.TempDir(),
 		Regions:     []exchange.Region{region},
 		FilecoinAPI: pfapi,
+		// submit the vouchers after every transfer so we can settle the channel
+		CheckpointAmount: filecoin.NewInt(1),
 	}
 	popts.Wallet = wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(popts.FilecoinAPI))
 	pn.exch, err = exchange.New(ctx, pn.host, pn.ds, popts)
@@ -943,7 +1884,6 @@ func TestLoadAll(t *testing.T) {
 	cn.ms = tn2.Ms
 	cn.dag = tn2.DAG
 	cn.host = tn2.Host
-	cn.omg = NewOfferMgr()
 	cfapi := filecoin.NewMockLotusAPI()
 	copts := exchange.Options{
 		Blockstore:  cn.bs,
@@ -1149,3 +2089,33 @@ func prepChannel(t *testing.T, from, to address.Address, c, p *filecoin.MockLotu
 
 	return chAddr, collect
 }
+
+func TestReapStores(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	// a store left behind by a failed transfer
+	orphan := nd.ms.Next()
+	_, err := nd.ms.Get(orphan)
+	require.NoError(t, err)
+
+	// stores still in use
+	tx := nd.exch.Tx(ctx)
+	defer tx.Close()
+	_, err = nd.Write(ctx, "/blog/index.html", strings.NewReader("<html></html>"))
+	require.NoError(t, err)
+	rec, err := nd.mfs.record("blog")
+	require.NoError(t, err)
+
+	nd.reapStores()
+
+	stores := make(map[multistore.StoreID]bool)
+	for _, id := range nd.ms.List() {
+		stores[id] = true
+	}
+	require.False(t, stores[orphan])
+	require.True(t, stores[tx.StoreID()])
+	require.True(t, stores[rec.StoreID])
+}
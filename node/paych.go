@@ -0,0 +1,303 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/payments"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// PaychSettle redeems our best vouchers for an inbound channel and settles the channel so its funds
+// can be collected once the settlement window elapsed
+func (nd *node) PaychSettle(ctx context.Context, args *PaychSettleArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Channel: args.Channel,
+				Err:     err.Error(),
+			},
+		})
+	}
+	addr, err := address.NewFromString(args.Channel)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to decode address %s : %v", args.Channel, err))
+		return
+	}
+	pay := nd.exch.Payments()
+	ci, err := pay.GetChannelInfo(addr)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	// The vouchers we received are lost if they are not submitted before the channel is collected
+	// so we don't settle until they all made it on chain
+	if ci.Direction == payments.DirInbound {
+		err := pay.SubmitAllVouchers(ctx, addr)
+		if err != nil && !errors.Is(err, payments.ErrNoVouchers) {
+			sendErr(fmt.Errorf("failed to submit vouchers before settling: %w", err))
+			return
+		}
+	}
+	if err := pay.Settle(ctx, addr); err != nil {
+		sendErr(err)
+		return
+	}
+	nd.sendPaychResult(addr)
+}
+
+// PaychTopUp adds funds to the payment channel of a retrieval deal paused for lack of funds so it can resume
+func (nd *node) PaychTopUp(ctx context.Context, args *PaychTopUpArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	id, err := strconv.ParseUint(args.Deal, 10, 64)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to parse deal ID %s : %v", args.Deal, err))
+		return
+	}
+	amt := filecoin.NewInt(0)
+	if args.Amount != "" {
+		f, err := filecoin.ParseFIL(args.Amount)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to parse amount %s : %v", args.Amount, err))
+			return
+		}
+		amt = filecoin.BigInt(f)
+	}
+	if err := nd.exch.Retrieval().Client().TopUp(ctx, deal.ID(id), amt); err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		PaychResult: &PaychResult{},
+	})
+}
+
+// PaychCollect sends the funds of a settled channel to their owners
+func (nd *node) PaychCollect(ctx context.Context, args *PaychCollectArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Channel: args.Channel,
+				Err:     err.Error(),
+			},
+		})
+	}
+	addr, err := address.NewFromString(args.Channel)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to decode address %s : %v", args.Channel, err))
+		return
+	}
+	if err := nd.exch.Payments().Collect(ctx, addr); err != nil {
+		sendErr(err)
+		return
+	}
+	nd.sendPaychResult(addr)
+}
+
+// sendPaychResult notifies the settlement state of a channel
+func (nd *node) sendPaychResult(addr address.Address) {
+	ci, err := nd.exch.Payments().GetChannelInfo(addr)
+	if err != nil {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Channel: addr.String(),
+				Err:     err.Error(),
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		PaychResult: &PaychResult{
+			Channel:    addr.String(),
+			SettlingAt: int64(ci.SettlingAt),
+			Settling:   ci.Settling,
+		},
+	})
+}
+
+// PaychList returns all the payment channels we track
+func (nd *node) PaychList(ctx context.Context, args *PaychListArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	pay := nd.exch.Payments()
+	chans, err := pay.ListChannels()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &PaychResult{}
+	for _, ch := range chans {
+		ci, err := pay.GetChannelInfo(ch)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		res.Channels = append(res.Channels, paychInfo(ci))
+	}
+	nd.send(Notify{
+		PaychResult: res,
+	})
+}
+
+// PaychExport writes our payment channels and vouchers to an encrypted file so they can be imported on
+// another machine
+func (nd *node) PaychExport(ctx context.Context, args *PaychExportArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Path: args.Path,
+				Err:  err.Error(),
+			},
+		})
+	}
+	f, err := os.OpenFile(args.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer f.Close()
+	if err := nd.exch.Payments().Export(f, args.Passphrase); err != nil {
+		sendErr(fmt.Errorf("failed to export payment channels: %w", err))
+		return
+	}
+	nd.send(Notify{
+		PaychResult: &PaychResult{Path: args.Path},
+	})
+}
+
+// PaychImport adds the payment channels and vouchers of an export to our store
+func (nd *node) PaychImport(ctx context.Context, args *PaychImportArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Path: args.Path,
+				Err:  err.Error(),
+			},
+		})
+	}
+	f, err := os.Open(args.Path)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer f.Close()
+	n, err := nd.exch.Payments().Import(f, args.Passphrase)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to import payment channels: %w", err))
+		return
+	}
+	nd.send(Notify{
+		PaychResult: &PaychResult{Path: args.Path, Imported: n},
+	})
+}
+
+// PaychInspect returns the details of a payment channel including its pending messages and lanes
+func (nd *node) PaychInspect(ctx context.Context, args *PaychInspectArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PaychResult: &PaychResult{
+				Channel: args.Channel,
+				Err:     err.Error(),
+			},
+		})
+	}
+	addr, err := address.NewFromString(args.Channel)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to decode address %s : %v", args.Channel, err))
+		return
+	}
+	sum, err := nd.exch.Payments().InspectChannel(ctx, addr)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	info := paychInfo(sum.Info)
+	if sum.Funds != nil {
+		info.Amount = filecoin.FIL(sum.Funds.ConfirmedAmt).Short()
+		info.Pending = filecoin.FIL(sum.Funds.PendingAmt).Short()
+		info.Redeemed = filecoin.FIL(sum.Funds.VoucherRedeemedAmt).Short()
+	}
+	for _, mcid := range sum.PendingMsgs {
+		info.PendingMsgs = append(info.PendingMsgs, mcid.String())
+	}
+	for _, l := range sum.Lanes {
+		info.Lanes = append(info.Lanes, LaneResult{
+			Lane:     l.Lane,
+			Nonce:    l.Nonce,
+			Redeemed: filecoin.FIL(l.Redeemed).Short(),
+		})
+	}
+	nd.send(Notify{
+		PaychResult: &PaychResult{
+			Channel:    args.Channel,
+			Settling:   sum.Info.Settling,
+			SettlingAt: int64(sum.Info.SettlingAt),
+			Channels:   []PaychInfo{info},
+		},
+	})
+}
+
+// paychInfo converts the info we store about a channel into a result for the client
+func paychInfo(ci *payments.ChannelInfo) PaychInfo {
+	info := PaychInfo{
+		Direction:  "outbound",
+		Control:    ci.Control.String(),
+		Target:     ci.Target.String(),
+		Settling:   ci.Settling,
+		SettlingAt: int64(ci.SettlingAt),
+		Vouchers:   len(ci.Vouchers),
+	}
+	if ci.Channel != nil {
+		info.Channel = ci.Channel.String()
+	}
+	if ci.Direction == payments.DirInbound {
+		info.Direction = "inbound"
+	}
+	if !ci.Amount.Nil() {
+		info.Amount = filecoin.FIL(ci.Amount).Short()
+	}
+	if !ci.PendingAmount.Nil() {
+		info.Pending = filecoin.FIL(ci.PendingAmount).Short()
+	}
+	return info
+}
+
+// paychSubscriber forwards payment events to the connected clients
+func (nd *node) paychSubscriber(event payments.Event, state payments.EventState) {
+	res := &PaychEventResult{
+		Event:      event.String(),
+		Channel:    state.Channel.String(),
+		Direction:  "outbound",
+		Lane:       state.Lane,
+		SettlingAt: int64(state.SettlingAt),
+	}
+	if state.Direction == payments.DirInbound {
+		res.Direction = "inbound"
+	}
+	if !state.Amount.Nil() {
+		res.Amount = filecoin.FIL(state.Amount).Short()
+	}
+	if state.MsgCid.Defined() {
+		res.MsgCid = state.MsgCid.String()
+	}
+	if state.Err != nil {
+		res.Err = state.Err.Error()
+	}
+	nd.send(Notify{PaychEvent: res})
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Pin protects refs from eviction or lets them be evicted again and returns the roots of all the pinned refs
+func (nd *node) Pin(ctx context.Context, args *PinArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			PinResult: &PinResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	idx := nd.exch.Index()
+	for _, c := range args.Cids {
+		root, err := cid.Parse(c)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if args.Unpin {
+			err = idx.Unpin(root)
+		} else {
+			err = idx.Pin(root)
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	refs, err := idx.ListRefs()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &PinResult{}
+	for _, ref := range refs {
+		if ref.Pinned {
+			res.Roots = append(res.Roots, ref.PayloadCID.String())
+		}
+	}
+	sort.Strings(res.Roots)
+	nd.send(Notify{
+		PinResult: res,
+	})
+}
@@ -2,12 +2,16 @@ package node
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	gopath "path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +23,7 @@ import (
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	badgerds "github.com/ipfs/go-ds-badger"
 	"github.com/ipfs/go-graphsync/storeutil"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
@@ -32,6 +37,7 @@ import (
 	"github.com/ipfs/go-path"
 	"github.com/ipfs/go-unixfs/importer/balanced"
 	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p"
 	connmgr "github.com/libp2p/go-libp2p-connmgr"
@@ -39,6 +45,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/p2p/net/conngater"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	tcp "github.com/libp2p/go-tcp-transport"
@@ -47,7 +54,12 @@ import (
 	"github.com/myelnet/pop/build"
 	"github.com/myelnet/pop/exchange"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/filecoin/storage"
+	"github.com/myelnet/pop/internal/alert"
+	"github.com/myelnet/pop/internal/objstore"
 	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/payments"
+	"github.com/myelnet/pop/retrieval"
 	"github.com/myelnet/pop/retrieval/client"
 	"github.com/myelnet/pop/retrieval/deal"
 	sel "github.com/myelnet/pop/selectors"
@@ -76,6 +88,21 @@ var ErrQuoteNotFound = errors.New("quote not found")
 // ErrInvalidPeer is returned when trying to ping a peer with invalid peer ID or address
 var ErrInvalidPeer = errors.New("invalid peer ID or address")
 
+// ErrRepoCorrupted is returned when the datastore cannot be opened even after truncating the corrupted data
+var ErrRepoCorrupted = errors.New("repo corrupted")
+
+// ErrTransferTimeout is returned when a retrieval takes longer than its timeout
+var ErrTransferTimeout = errors.New("transfer timed out")
+
+// ErrSignerTLS is returned when serving the signing service without requiring verified client certificates
+var ErrSignerTLS = errors.New("signing service requires verified client certificates")
+
+// ErrNoMatch is returned when a search query doesn't match any commit
+var ErrNoMatch = errors.New("no match found")
+
+// ErrSeedNotEncrypted is returned when deriving our keys from a recovery phrase without a cipher to persist it
+var ErrSeedNotEncrypted = errors.New("a recovery phrase can only be persisted with encryption")
+
 // Options determines configurations for the IPFS node
 type Options struct {
 	// RepoPath is the file system path to use to persist our datastore
@@ -88,8 +115,25 @@ type Options struct {
 	FilEndpoint string
 	// FilToken is the authorization token to access the filecoin api
 	FilToken string
+	// FilLight follows the chain heads over libp2p instead of trusting a remote filecoin api.
+	// Only the state queries and gas estimates needed for payments are supported. Ignored if FilEndpoint is set.
+	FilLight bool
+	// FilPeers are the addresses of the Filecoin nodes the light client trusts to relay valid blocks. Required with FilLight.
+	FilPeers []string
+	// FilCheckpoint is a tipset the light client only accepts a chain from if it descends from it
+	FilCheckpoint []cid.Cid
+	// FilNetwork is the name of the Filecoin network to follow. Defaults to mainnet.
+	FilNetwork string
 	// PrivKey is a hex encoded private key to use for default address
 	PrivKey string
+	// Mnemonic is a recovery phrase to restore the addresses derived from it
+	Mnemonic string
+	// RecoverCount is the number of addresses to restore from Mnemonic. Without a filecoin API we cannot
+	// tell which addresses were used so it must cover all of them. Defaults to 1.
+	RecoverCount int
+	// Seed is a new recovery phrase to derive our keys from, the caller must have shown it to the operator.
+	// It is persisted encrypted with Cipher which is required so new keys can be recovered after a restart.
+	Seed string
 	// MaxPPB is the maximum price per byte
 	MaxPPB int64
 	// Regions is a list of regions a provider chooses to support.
@@ -97,10 +141,75 @@ type Options struct {
 	Regions []string
 	// Capacity is the maximum storage capacity dedicated to the exchange
 	Capacity uint64
+	// EvictionPolicy is the name of the policy deciding which content is evicted first once we reach capacity:
+	// lfu, lru, largest or ttl. Defaults to lfu.
+	EvictionPolicy string
+	// EvictLabels restricts eviction to refs with all the given labels, i.e. tier=best-effort.
+	// Default is any ref can be evicted.
+	EvictLabels map[string]string
+	// Discovery is how we find providers for the content we retrieve: gossip, dht or indexer. Defaults to gossip.
+	Discovery string
+	// IndexerURL is the endpoint of the network indexer used with the indexer discovery
+	IndexerURL string
+	// TrustedPeers is a list of peer IDs allowed to pull any of our content without payment
+	TrustedPeers []string
+	// FleetKey is a secret shared by the nodes of an operator, they may pull each other's content for free
+	FleetKey string
 	// ReplInterval defines how often the node attempts to find new content from connected peers
 	ReplInterval time.Duration
 	// CancelFunc is used for gracefully shutting down the node
 	CancelFunc context.CancelFunc
+	// Cipher encrypts all the blocks written to disk if provided
+	Cipher utils.Cipher
+	// PublicIndexAddr is the address to serve a read only list of our public refs on.
+	// The endpoint is disabled if empty.
+	PublicIndexAddr string
+	// PublicIndexRateLimit is the number of requests per minute a single client can send to the public index
+	PublicIndexRateLimit int
+	// ObjectStores provides the credentials for exporting content to object storage for each url scheme (s3, gs).
+	// Credentials are read from the environment if not set.
+	ObjectStores map[string]objstore.Config
+	// Alerts configures where to send alerts about events requiring the operator's attention
+	Alerts alert.Config
+	// DiskWatermark is the fraction of the capacity after which we alert that storage is running out.
+	// Defaults to DefaultDiskWatermark.
+	DiskWatermark float64
+	// Mobile runs a reduced footprint node suited to be embedded in phone apps. It uses less memory,
+	// doesn't listen on fixed ports and runs in LowPower mode.
+	Mobile bool
+	// LowPower limits concurrent transfers, batches announcements and only uses the DHT as a client
+	// for devices such as a Raspberry Pi
+	LowPower bool
+	// VerifyTransfers checks the content we receive is complete and matches its root before serving it
+	VerifyTransfers bool
+	// CacheBudget is the storage space in bytes used to cache popular content we relay queries for.
+	// Default is 0 which disables opportunistic caching.
+	CacheBudget uint64
+	// MaxGas is the most we pay in fees for a payment channel message, more expensive operations fail
+	// and are retried later. Default is no limit.
+	MaxGas abi.TokenAmount
+	// LedgerAccounts is the number of addresses derived from a connected Ledger device. Messages sent from them
+	// such as channel settlements or storage deal funding are signed on the device. Default is 0 which disables it.
+	LedgerAccounts int
+	// RemoteSigner delegates signing to an external service holding keys shared by a fleet of nodes.
+	// Its first address becomes the default address.
+	RemoteSigner *wallet.RemoteSigner
+	// SignerAddr is the address to serve the keys of our wallet on as the signing service of a fleet of nodes.
+	// The service is disabled if empty.
+	SignerAddr string
+	// SignerTLS must require and verify the client certificates of the nodes using the signing service
+	SignerTLS *tls.Config
+	// SignerAllowed are the addresses the signing service signs with. Defaults to our default address.
+	SignerAllowed []address.Address
+	// RepairBudget is the most we spend on replacement deals when storage deals are slashed or terminated.
+	// Default is 0 which disables automatic repair, the operator is only alerted.
+	RepairBudget abi.TokenAmount
+	// Quota limits the bandwidth each client can use when retrieving content from us.
+	// Default is no limit.
+	Quota retrieval.Quota
+	// DispatchPolicy sets the rules for accepting content dispatched to us by other peers.
+	// Default accepts any content we have capacity for.
+	DispatchPolicy exchange.DispatchPolicy
 }
 
 type node struct {
@@ -111,7 +220,34 @@ type node struct {
 	is   cbor.IpldStore
 	dag  ipldformat.DAGService
 	exch *exchange.Exchange
-	omg  *OfferMgr
+	si   *SearchIndex
+	ps   *ProtectSet
+	// gater blocks connections with the peers and subnets listed by the operator
+	gater *conngater.BasicConnectionGater
+	// alerts notifies the operator about critical events. nil is a valid notifier which does nothing.
+	alerts *alert.Notifier
+	// stats persists daily metrics about the node
+	stats *Stats
+	// accounts aggregates the funds spent and earned per peer and content root
+	accounts *Accounting
+	// mfs holds the mutable files namespaces
+	mfs *MFS
+	// msig proposes and approves transactions of the multisig wallets we are a signer of
+	msig *wallet.Multisig
+	// history records the messages we push on chain
+	history *wallet.History
+	// balances are the last balances we saw for our addresses
+	balances map[address.Address]abi.TokenAmount
+	// storage proposes storage deals to Filecoin miners
+	storage *storage.Storage
+	// deals tracks the storage deals we proposed until they are active
+	deals *storage.DealTracker
+	// proposals logs every deal proposal we send and how miners answered
+	proposals *storage.ProposalLog
+	// queue spreads the deals we push over time and miners and retries the failed ones
+	queue *storage.DealQueue
+	// repair replaces the storage deals we lose
+	repair *storage.Repairer
 
 	// opts keeps all the node params set when starting the node
 	opts Options
@@ -137,18 +273,34 @@ func New(ctx context.Context, opts Options) (*node, error) {
 	dsopts := badgerds.DefaultOptions
 	dsopts.SyncWrites = false
 	dsopts.Truncate = true
+	if opts.Mobile {
+		mobileDatastoreOptions(&dsopts)
+	}
 
 	nd.ds, err = badgerds.NewDatastore(filepath.Join(opts.RepoPath, "datastore"), &dsopts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrRepoCorrupted, err)
 	}
 
-	nd.ms, err = multistore.NewMultiDstore(nd.ds)
+	// make sure we can read the repo before writing anything to it
+	if err := utils.CheckRepoCipher(nd.ds, opts.Cipher); err != nil {
+		return nil, fmt.Errorf("failed to open repo: %w", err)
+	}
+
+	// blocks are encrypted at rest if a cipher is provided
+	var bds datastore.Batching = nd.ds
+	if opts.Cipher != nil {
+		bds = utils.NewEncryptedDatastore(nd.ds, opts.Cipher)
+	}
+
+	nd.ms, err = multistore.NewMultiDstore(bds)
 	if err != nil {
 		return nil, err
 	}
 
-	nd.bs = blockstore.NewBlockstore(nd.ds)
+	nd.bs = blockstore.NewBlockstore(bds)
+	// never serve blocks which were corrupted on disk
+	nd.bs.HashOnRead(true)
 
 	nd.dag = merkledag.NewDAGService(blockservice.New(nd.bs, offline.Exchange(nd.bs)))
 
@@ -161,13 +313,14 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		return nil, err
 	}
 
-	gater, err := conngater.NewBasicConnectionGater(nd.ds)
+	nd.gater, err = conngater.NewBasicConnectionGater(nd.ds)
 	if err != nil {
 		return nil, err
 	}
 
-	nd.host, err = libp2p.New(
-		ctx,
+	// keep a reference to the DHT in case we use it for discovery
+	var kad *dht.IpfsDHT
+	hopts := []libp2p.Option{
 		libp2p.Identity(priv),
 		libp2p.ListenAddrStrings(
 			"/ip4/0.0.0.0/tcp/41504",
@@ -181,18 +334,29 @@ func New(ctx context.Context, opts Options) (*node, error) {
 			60,             // HighWater,
 			20*time.Second, // GracePeriod
 		)),
-		libp2p.ConnectionGater(gater),
+		libp2p.ConnectionGater(nd.gater),
 		libp2p.DisableRelay(),
 		// Attempt to open ports using uPNP for NATed hosts.
 		libp2p.NATPortMap(),
 		libp2p.EnableNATService(),
 		// Let this host use the DHT to find other hosts
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			return dht.New(ctx, h)
+			var dopts []dht.Option
+			// Answering DHT queries for the whole network is too much work for small devices
+			if opts.LowPower {
+				dopts = append(dopts, dht.Mode(dht.ModeClient))
+			}
+			d, err := dht.New(ctx, h, dopts...)
+			kad = d
+			return d, err
 		}),
 		// user-agent is sent along the identify protocol
-		libp2p.UserAgent("pop-"+build.Version),
-	)
+		libp2p.UserAgent("pop-" + build.Version),
+	}
+	if opts.Mobile {
+		hopts = mobileHostOptions(ctx, priv, nd.gater, &kad)
+	}
+	nd.host, err = libp2p.New(ctx, hopts...)
 	if err != nil {
 		return nil, err
 	}
@@ -200,6 +364,19 @@ func New(ctx context.Context, opts Options) (*node, error) {
 	// Convert region names to region structs
 	regions := exchange.ParseRegions(opts.Regions)
 
+	policy, err := exchange.ParseEvictionPolicy(opts.EvictionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := make([]peer.ID, len(opts.TrustedPeers))
+	for i, tp := range opts.TrustedPeers {
+		trusted[i], err = peer.Decode(tp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted peer %s: %w", tp, err)
+		}
+	}
+
 	eopts := exchange.Options{
 		Blockstore:          nd.bs,
 		MultiStore:          nd.ms,
@@ -208,16 +385,63 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		FilecoinRPCHeader: http.Header{
 			"Authorization": []string{opts.FilToken},
 		},
-		Regions:      regions,
-		Capacity:     opts.Capacity,
-		ReplInterval: opts.ReplInterval,
+		Regions:        regions,
+		Capacity:       opts.Capacity,
+		EvictionPolicy: policy,
+		EvictLabels:    opts.EvictLabels,
+		ReplInterval:   opts.ReplInterval,
+		Discovery:      exchange.Discovery(opts.Discovery),
+		IndexerURL:     opts.IndexerURL,
+		TrustedPeers:   trusted,
+		FleetKey:       opts.FleetKey,
+		Gater:          nd.gater,
+		LowPower:       opts.LowPower || opts.Mobile,
+
+		VerifyTransfers: opts.VerifyTransfers,
+		CachePolicy:     exchange.CachePolicy{Budget: opts.CacheBudget},
+		GasPolicy:       payments.GasPolicy{MaxGas: opts.MaxGas},
+		Quota:           opts.Quota,
+		DispatchPolicy:  opts.DispatchPolicy,
+	}
+	if kad != nil {
+		eopts.ContentRouting = kad
 	}
 
+	nd.history = wallet.NewHistory(nd.ds)
 	if eopts.FilecoinRPCEndpoint != "" {
 		eopts.FilecoinAPI, err = filecoin.NewLotusRPC(ctx, eopts.FilecoinRPCEndpoint, eopts.FilecoinRPCHeader)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to connect with Lotus RPC")
+		} else {
+			// Record every message we push whether for deals, payment channels or transfers
+			eopts.FilecoinAPI = nd.history.API(eopts.FilecoinAPI)
+		}
+	} else if opts.FilLight {
+		// the exchange and the light client share the same gossip router
+		eopts.GossipTracer = exchange.NewGossipTracer()
+		eopts.PubSub, err = pubsub.NewGossipSub(ctx, nd.host, pubsub.WithEventTracer(eopts.GossipTracer))
+		if err != nil {
+			return nil, err
 		}
+		var trusted []peer.ID
+		for _, s := range opts.FilPeers {
+			info, err := utils.AddrStringToAddrInfo(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filecoin peer %s: %w", s, err)
+			}
+			trusted = append(trusted, info.ID)
+		}
+		light, err := filecoin.NewLightAPI(ctx, nd.host, eopts.PubSub, filecoin.LightOptions{
+			Network:    opts.FilNetwork,
+			Trusted:    trusted,
+			Checkpoint: filecoin.NewTipSetKey(opts.FilCheckpoint...),
+			Verifier:   bls{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		eopts.FilecoinAPI = nd.history.API(light)
+		go utils.Bootstrap(ctx, nd.host, opts.FilPeers)
 	}
 
 	eopts.Wallet = wallet.NewFromKeystore(
@@ -225,6 +449,61 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		wallet.WithFilAPI(eopts.FilecoinAPI),
 		wallet.WithBLSSig(bls{}),
 	)
+	// the recovery phrase is never written to disk in plain text
+	var sds datastore.Batching
+	if opts.Cipher != nil {
+		sds = namespace.Wrap(bds, datastore.NewKey("/seed"))
+	}
+	migrated, err := wallet.MigrateHDSeed(nd.ds, sds)
+	if err != nil {
+		return nil, err
+	}
+	if migrated && sds == nil {
+		log.Warn().Msg("removed plain text recovery phrase from repo, start with encryption to persist it")
+	}
+	if opts.Seed != "" && sds == nil {
+		return nil, ErrSeedNotEncrypted
+	}
+	hw, err := wallet.NewHDWallet(eopts.Wallet, sds, wallet.WithHDFilAPI(eopts.FilecoinAPI))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Mnemonic != "" {
+		count := opts.RecoverCount
+		if count < 1 {
+			count = 1
+		}
+		addrs, err := hw.Recover(ctx, opts.Mnemonic, count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore wallet: %w", err)
+		}
+		for _, a := range addrs {
+			fmt.Printf("==> Restored FIL address: %s\n", a)
+		}
+	} else if opts.Seed != "" {
+		// New repos derive their keys from a recovery phrase so they can be restored after a disk loss
+		if err := hw.Seed(opts.Seed); err != nil {
+			return nil, err
+		}
+	}
+	eopts.Wallet = hw
+	if opts.RemoteSigner != nil {
+		rw, err := wallet.NewFromRemote(ctx, eopts.Wallet, opts.RemoteSigner, wallet.WithRemoteFilAPI(eopts.FilecoinAPI), wallet.WithRemoteDefault())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect with remote signer: %w", err)
+		}
+		eopts.Wallet = rw
+	}
+	if opts.LedgerAccounts > 0 {
+		lw, err := wallet.NewFromLedger(eopts.Wallet, opts.LedgerAccounts, wallet.WithLedgerFilAPI(eopts.FilecoinAPI))
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range lw.LedgerAddresses() {
+			fmt.Printf("==> Loaded Ledger FIL address: %s\n", a)
+		}
+		eopts.Wallet = lw
+	}
 
 	var addr address.Address
 	if eopts.Wallet.DefaultAddress() == address.Undef && opts.PrivKey == "" {
@@ -240,7 +519,16 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		return nil, err
 	}
 
-	nd.omg = NewOfferMgr()
+	nd.si = NewSearchIndex(nd.ds)
+	// Content we no longer have shouldn't show up in search results
+	nd.exch.Index().OnDrop(nd.si.refDropped)
+	nd.msig = wallet.NewMultisig(nd.exch.Wallet(), eopts.FilecoinAPI, nd.ds)
+	nd.mfs = NewMFS(nd.ds, nd.ms)
+
+	nd.ps, err = NewProtectSet(nd.host, nd.ds)
+	if err != nil {
+		return nil, err
+	}
 
 	if opts.PrivKey != "" {
 		err = nd.importPrivateKey(ctx, opts.PrivKey)
@@ -258,9 +546,59 @@ func New(ctx context.Context, opts Options) (*node, error) {
 
 	nd.cancelFunc = opts.CancelFunc
 
+	nd.stats, err = NewStats(nd.ds)
+	if err != nil {
+		return nil, err
+	}
+	nd.exch.Retrieval().Provider().SubscribeToEvents(nd.statsSubscriber)
+
+	nd.accounts = NewAccounting(nd.ds)
+	nd.exch.Retrieval().Provider().SubscribeToEvents(nd.earningsSubscriber)
+	nd.exch.Retrieval().Client().SubscribeToEvents(nd.spendingSubscriber)
+	nd.exch.Payments().SubscribeToEvents(nd.paychSubscriber)
+
+	nd.alerts = alert.New(opts.Alerts)
+	nd.exch.R().SubscribeToEvents(nd.replicationSubscriber)
+	go nd.monitor(ctx)
+	go nd.watchFunds(ctx)
+
+	if eopts.FilecoinAPI != nil {
+		nd.storage, err = storage.New(nd.host, nd.exch.DataTransfer(), nd.exch.Wallet(), eopts.FilecoinAPI)
+		if err != nil {
+			return nil, err
+		}
+		nd.proposals = storage.NewProposalLog(nd.ds)
+		nd.storage.SetProposalLog(nd.proposals)
+		nd.deals = storage.NewDealTracker(nd.storage, nd.ds)
+		nd.deals.SubscribeToEvents(nd.dealSubscriber)
+		if opts.RepairBudget.Int != nil && opts.RepairBudget.GreaterThan(big.Zero()) {
+			// The cost of each replacement for the size of its piece is checked against the budget
+			// so we don't cap the ask price of the miners
+			nd.repair = storage.NewRepairer(nd.storage, nd.deals, nd.ds, opts.RepairBudget, storage.MinerSelectionParams{
+				MaxPrice: math.MaxUint64,
+			})
+		}
+		nd.deals.SubscribeToEvents(nd.repairSubscriber(ctx))
+		go nd.deals.Run(ctx, storage.DefaultDealPollInterval)
+		nd.queue = storage.NewDealQueue(nd.storage, nd.deals, nd.ds, storage.DefaultQueueOptions)
+		go nd.queue.Run(ctx)
+	}
+
 	// start connecting with peers
 	go utils.Bootstrap(ctx, nd.host, opts.BootstrapPeers)
 
+	// delete the stores of transfers which didn't complete before the last shutdown
+	nd.reapStores()
+
+	// drop the content we cannot serve entirely before cleaning up the blocks it leaves behind
+	report, err := nd.checkIntegrity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if report.Repaired() {
+		log.Warn().Str("report", report.String()).Msg("repaired repo")
+	}
+
 	// remove unwanted blocks that might be in the blockstore but are removed from the index
 	err = nd.exch.Index().CleanBlockStore(ctx)
 	if err != nil {
@@ -288,6 +626,8 @@ func (nd *node) Off(ctx context.Context) {
 	nd.send(Notify{OffResult: &OffResult{}})
 	fmt.Println("==> Shut down pop daemon")
 
+	// Let our peers replace the content we hold before we go
+	nd.exch.R().Leave(ctx)
 	nd.cancelFunc()
 }
 
@@ -314,6 +654,7 @@ func (nd *node) Ping(ctx context.Context, who string) {
 			Addrs:   addrs,
 			Peers:   pstr,
 			Version: build.Version,
+			Crashes: nd.exch.Crashes(),
 		}})
 		return
 	}
@@ -418,23 +759,31 @@ func (nd *node) Put(ctx context.Context, args *PutArgs) {
 		nd.tx = nd.exch.Tx(ctx)
 	}
 
-	fstat, err := os.Stat(args.Path)
-	if err != nil {
-		sendErr(err)
-		return
-	}
+	added := make(map[string]bool)
+	if objstore.IsURL(args.Path) {
+		err := nd.addObjects(ctx, args.Path, added)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	} else {
+		fstat, err := os.Stat(args.Path)
+		if err != nil {
+			sendErr(err)
+			return
+		}
 
-	fnd, err := files.NewSerialFile(args.Path, false, fstat)
-	if err != nil {
-		sendErr(err)
-		return
-	}
+		fnd, err := files.NewSerialFile(args.Path, false, fstat)
+		if err != nil {
+			sendErr(err)
+			return
+		}
 
-	added := make(map[string]bool)
-	err = nd.addRecursive(ctx, args.Path, fnd, added)
-	if err != nil {
-		sendErr(err)
-		return
+		err = nd.addRecursive(ctx, args.Path, fnd, added)
+		if err != nil {
+			sendErr(err)
+			return
+		}
 	}
 
 	entries, err := nd.tx.Status()
@@ -507,13 +856,76 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 		return
 	}
 	nd.tx.SetCacheRF(args.CacheRF)
+	nd.tx.SetPrivate(args.Private)
+	if len(args.Regions) > 0 {
+		nd.tx.SetRegions(exchange.ParseRegions(args.Regions))
+	}
+	if args.From != "" {
+		from, err := nd.walletAddress(args.From)
+		if err != nil {
+			nd.txmu.Unlock()
+			sendErr(err)
+			return
+		}
+		nd.tx.SetAddress(from)
+	}
+	if args.Incentive != "" {
+		amt, err := filecoin.ParseFIL(args.Incentive)
+		if err != nil {
+			nd.txmu.Unlock()
+			sendErr(fmt.Errorf("invalid incentive: %w", err))
+			return
+		}
+		nd.tx.SetIncentive(&exchange.Incentive{
+			Amount: abi.TokenAmount(amt),
+			Hold:   uint64(args.Hold / time.Second),
+		})
+	}
+	if args.DryRun {
+		candidates := nd.tx.Candidates()
+		nd.txmu.Unlock()
+		nd.send(Notify{CommResult: &CommResult{
+			Candidates: nd.candidateResults(candidates),
+			DryRun:     true,
+		}})
+		return
+	}
+	// report the progress of the dispatch until the commit returns
+	root := nd.tx.Root()
+	unsub := nd.exch.R().SubscribeToEvents(func(event exchange.ReplicationEvent, state exchange.ReplicationState) {
+		if state.PayloadCID != root || event == exchange.ReplicationEventRefDropped {
+			return
+		}
+		msg := fmt.Sprintf("%s %s", event, state.Peer)
+		if state.Message != "" {
+			msg += ": " + state.Message
+		}
+		nd.send(Notify{
+			CommResult: &CommResult{
+				Progress: msg,
+			},
+		})
+	})
+	defer unsub()
 	err := nd.tx.Commit()
 	if err != nil {
 		sendErr(err)
 		return
 	}
 	ref := nd.tx.Ref()
+	ref.Labels = args.Labels
+	ref.Name = args.Name
+	// An amended ref or a new version of a name is linked to the previous version
+	if base := nd.tx.Base(); base.Defined() {
+		ref.Parent = &base
+	} else if args.Name != "" {
+		if latest, err := nd.exch.Index().Latest(args.Name); err == nil {
+			ref.Parent = &latest.PayloadCID
+		}
+	}
+	caches := 0
 	nd.tx.WatchDispatch(func(r exchange.PRecord) {
+		caches++
 		nd.send(Notify{
 			CommResult: &CommResult{
 				Caches: []string{
@@ -522,11 +934,28 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 			},
 		})
 	})
+	nd.checkReplication(ctx, ref.PayloadCID.String(), caches, args.CacheRF)
 	if err := nd.exch.Index().SetRef(ref); err != nil {
 		sendErr(err)
 		return
 	}
 
+	keys := make([]string, len(ref.Keys))
+	for i, k := range ref.Keys {
+		keys[i] = string(k)
+	}
+	err = nd.si.Put(Manifest{
+		Root:        ref.PayloadCID.String(),
+		Keys:        keys,
+		Tags:        args.Tags,
+		Description: args.Description,
+		Size:        ref.PayloadSize,
+		Created:     time.Now(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to index commit manifest")
+	}
+
 	nd.tx.Close()
 	nd.tx = nil
 	nd.txmu.Unlock()
@@ -544,6 +973,29 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 	}})
 }
 
+// candidateResults formats the dispatch candidates with their reputation score
+func (nd *node) candidateResults(candidates []exchange.DispatchCandidate) []CandidateResult {
+	res := make([]CandidateResult, len(candidates))
+	for i, c := range candidates {
+		regions := make([]string, len(c.Info.Regions))
+		for j, code := range c.Info.Regions {
+			regions[j] = exchange.RegionName(code)
+		}
+		capacity := "unlimited"
+		if c.Info.Capacity < math.MaxUint64 {
+			capacity = filecoin.SizeStr(filecoin.NewInt(c.Info.Capacity))
+		}
+		res[i] = CandidateResult{
+			Peer:     c.Peer.String(),
+			Regions:  regions,
+			Capacity: capacity,
+			Latency:  c.Info.Latency,
+			Score:    nd.exch.Reputation().Score(c.Peer),
+		}
+	}
+	return res
+}
+
 // Get sends a request for content with the given arguments. It also sends feedback to any open cli
 // connections
 func (nd *node) Get(ctx context.Context, args *GetArgs) {
@@ -553,6 +1005,7 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 				Err: err.Error(),
 			}})
 	}
+	args.Cid = nd.resolveName(args.Cid)
 	p := path.FromString(args.Cid)
 	// /<cid>/path/file.ext => cid, ["path", file.ext"]
 	root, segs, err := path.SplitAbsPath(p)
@@ -572,13 +1025,14 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 	// Check if we're trying to get from an ongoing transaction
 	nd.txmu.Lock()
 	if nd.tx != nil && nd.tx.Root() == root {
+		defer nd.txmu.Unlock()
 		if args.Out != "" {
-			f, err := nd.tx.GetFile(segs[0])
+			f, err := nd.tx.GetFile(gopath.Join(segs...))
 			if err != nil {
 				sendErr(err)
 				return
 			}
-			err = files.WriteTo(f, args.Out)
+			err = nd.writeOut(ctx, f, segs[len(segs)-1], args.Out)
 			if err != nil {
 				sendErr(err)
 				return
@@ -593,7 +1047,7 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 	}
 	nd.txmu.Unlock()
 
-	// Only support a single segment for now
+	// The first segment is the entry key and the rest the path to a file nested in a directory entry
 	args.Key = segs[0]
 
 	// Check our supply if we may already have it from a different tx
@@ -622,12 +1076,12 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 	}
 
 	if args.Out != "" {
-		f, err := tx.GetFile(args.Key)
+		f, err := tx.GetFile(gopath.Join(segs...))
 		if err != nil {
 			sendErr(err)
 			return
 		}
-		err = files.WriteTo(f, args.Out)
+		err = nd.writeOut(ctx, f, segs[len(segs)-1], args.Out)
 		if err != nil {
 			sendErr(err)
 			return
@@ -673,6 +1127,8 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 				strategy = exchange.SelectCheapest(5, 4*time.Second)
 			case "SelectFirstLowerThan":
 				strategy = exchange.SelectFirstLowerThan(abi.NewTokenAmount(args.MaxPPB))
+			case "SelectByReputation":
+				strategy = exchange.SelectByReputation(nd.exch.Reputation(), 0.5, 5, 4*time.Second)
 			default:
 				sendErr(errors.New("unknown strategy"))
 			}
@@ -681,16 +1137,46 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 			strategy = exchange.SelectFirstLowerThan(abi.NewTokenAmount(args.MaxPPB))
 		}
 
+		// We only forward the events of the deal we started once the caller knows its ID
+		var mu sync.Mutex
+		var dealID *deal.ID
+		// The transfer deadline only starts once our payment channel is ready so waiting for the chain
+		// to create or fund it doesn't count against the transfer
+		var timeout time.Duration
+		var deadline *time.Timer
+		expired := make(chan struct{})
+		defer func() {
+			mu.Lock()
+			if deadline != nil {
+				deadline.Stop()
+			}
+			mu.Unlock()
+		}()
 		unsub := nd.exch.Retrieval().Client().SubscribeToEvents(
 			func(event client.Event, state deal.ClientState) {
-				if state.PayloadCID == root {
-					select {
-					case results <- GetResult{
+				mu.Lock()
+				ours := dealID != nil && state.ID == *dealID
+				if ours && deadline == nil && !fundingDeal(state.Status) {
+					deadline = time.AfterFunc(timeout, func() { close(expired) })
+				}
+				mu.Unlock()
+				if ours {
+					res := GetResult{
 						TotalFunds:    filecoin.FIL(state.TotalFunds).Short(),
 						TotalSpent:    filecoin.FIL(state.FundsSpent).Short(),
 						Status:        deal.Statuses[state.Status],
 						TotalReceived: int64(state.TotalReceived),
-					}:
+					}
+					if state.Status == deal.StatusInsufficientFunds {
+						res.DealID = state.ID.String()
+						res.Shortfall = filecoin.FIL(state.VoucherShortfall).Short()
+					}
+					if event == client.EventProviderFundsShortfall {
+						res.DealID = state.ID.String()
+						res.Paused = state.Message
+					}
+					select {
+					case results <- res:
 					default:
 					}
 				}
@@ -705,6 +1191,15 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 		tx := nd.exch.Tx(ctx, exchange.WithRoot(root), exchange.WithStrategy(strategy), exchange.WithTriage())
 		defer tx.Close()
 
+		if args.From != "" {
+			from, err := nd.walletAddress(args.From)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+			tx.SetAddress(from)
+		}
+
 		var s ipld.Node
 		switch args.Key {
 		// If we're looking to retrieve entries, we still ask for the price for everything
@@ -715,7 +1210,20 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 		}
 
 		// If we already have an offer we can skip routing queries
-		offer, err := nd.omg.GetOffer(root)
+		offer, err := nd.exch.Offers().Get(root)
+		if err == nil {
+			// Make sure the provider still has the content
+			var info *peer.AddrInfo
+			info, err = offer.AddrInfo()
+			if err == nil {
+				offer, err = tx.QueryOffer(*info, s)
+			}
+			if err != nil {
+				// fallback to a regular query
+				log.Error().Err(err).Msg("querying cached offer")
+				nd.exch.Offers().Invalidate(root)
+			}
+		}
 		if err != nil {
 
 			err = tx.Query(s)
@@ -743,10 +1251,15 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 				}
 			}
 
+			// If no cache offers the content we fall back to the storage miners we made deals with
+			triaged := make(chan struct{})
+			go nd.queryStorageMiners(ctx, tx, root, s, args, triaged)
+
 			log.Info().Msg("waiting for triage")
 
 			// The selection comes back for ALL the content
 			selection, err := tx.Triage()
+			close(triaged)
 			if err != nil {
 				sendErr(err)
 				return
@@ -779,20 +1292,7 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 			// retrieving everything
 			selection.Exec(exchange.DealSel(s), exchange.DealFunds(funds))
 		} else {
-			info, err := offer.AddrInfo()
-			if err != nil {
-				sendErr(err)
-				// TODO: fallback to regular query?
-				return
-			}
-
 			// Will be selected automatically in the strategy
-			offer, err := tx.QueryOffer(*info, s)
-			if err != nil {
-				// TODO: fallback to regular query?
-				sendErr(err)
-				return
-			}
 			tx.ApplyOffer(offer)
 
 			results <- GetResult{
@@ -814,6 +1314,17 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 		now := time.Now()
 		discDuration := now.Sub(start)
 
+		// Unless the user provides one, the timeout depends on the content size and how fast the provider
+		// transferred content to us in the past
+		timeout = time.Duration(args.Timeout) * time.Minute
+		if timeout <= 0 {
+			var throughput float64
+			if info, err := offer.AddrInfo(); err == nil {
+				throughput = nd.exch.Reputation().Stats(info.ID).Throughput()
+			}
+			timeout = exchange.TransferTimeout(offer.Size, throughput)
+		}
+
 		var dref exchange.DealRef
 		select {
 		case dref = <-tx.Ongoing():
@@ -827,6 +1338,9 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 		results <- GetResult{
 			DealID: dref.ID.String(),
 		}
+		mu.Lock()
+		dealID = &dref.ID
+		mu.Unlock()
 
 		select {
 		case res := <-tx.Done():
@@ -836,13 +1350,9 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 				return
 			}
 
-			if args.Key == "" {
-				// transfer was successful so we keep the offer around
-				// we were just retrieving the index
-				err := nd.omg.SetOffer(root, offer)
-				if err != nil {
-					log.Error().Err(err).Msg("setting offer")
-				}
+			if err := nd.exch.VerifyTransfer(ctx, root, s, tx.Store().Bstore, res.Size); err != nil {
+				sendErr(err)
+				return
 			}
 
 			ref := tx.Ref()
@@ -888,13 +1398,13 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 				// TODO: when blocks are properly deduplicated we can check if paych
 				// available funds are 0.
 				if len(mk) == 0 || remain.IsZero() {
-					err := nd.omg.RemoveOffer(root)
-					if err != nil {
-						log.Error().Err(err).Msg("removing offer")
-					}
+					nd.exch.Offers().Invalidate(root)
 				}
 			}
 			return
+		case <-expired:
+			sendErr(fmt.Errorf("%w after %s", ErrTransferTimeout, timeout))
+			return
 		case <-ctx.Done():
 			return
 		}
@@ -904,9 +1414,44 @@ func (nd *node) Load(ctx context.Context, args *GetArgs) (chan GetResult, error)
 	return results, nil
 }
 
+// fundingDeal returns whether a retrieval deal is still waiting for its payment channel to be ready
+func fundingDeal(status deal.Status) bool {
+	switch status {
+	case deal.StatusNew,
+		deal.StatusWaitForAcceptance,
+		deal.StatusAccepted,
+		deal.StatusPaymentChannelCreating,
+		deal.StatusPaymentChannelAddingInitialFunds,
+		deal.StatusPaymentChannelAllocatingLane:
+		return true
+	}
+	return false
+}
+
+// resolveName replaces a ref name at the start of a path with the root of its latest version.
+// The path is returned unchanged if it starts with a CID or no ref has this name.
+func (nd *node) resolveName(p string) string {
+	segs := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if _, err := cid.Decode(segs[0]); err == nil {
+		return p
+	}
+	ref, err := nd.exch.Index().Latest(segs[0])
+	if err != nil {
+		return p
+	}
+	segs[0] = ref.PayloadCID.String()
+	return strings.Join(segs, "/")
+}
+
 // List returns all the roots for the content stored by this node
 func (nd *node) List(ctx context.Context, args *ListArgs) {
-	list, err := nd.exch.Index().ListRefs()
+	var refs []*exchange.DataRef
+	var err error
+	if args.Name != "" {
+		refs, err = nd.exch.Index().Versions(args.Name)
+	} else {
+		refs, err = nd.exch.Index().ListRefs()
+	}
 	if err != nil {
 		nd.send(Notify{
 			ListResult: &ListResult{
@@ -915,6 +1460,12 @@ func (nd *node) List(ctx context.Context, args *ListArgs) {
 		})
 		return
 	}
+	var list []*exchange.DataRef
+	for _, ref := range refs {
+		if ref.HasLabels(args.Labels) {
+			list = append(list, ref)
+		}
+	}
 	if len(list) == 0 {
 		nd.send(Notify{
 			ListResult: &ListResult{
@@ -926,15 +1477,107 @@ func (nd *node) List(ctx context.Context, args *ListArgs) {
 	for i, ref := range list {
 		nd.send(Notify{
 			ListResult: &ListResult{
-				Root: ref.PayloadCID.String(),
-				Size: ref.PayloadSize,
-				Freq: ref.Freq,
-				Last: i == len(list)-1,
+				Root:   ref.PayloadCID.String(),
+				Size:   ref.PayloadSize,
+				Freq:   ref.Freq,
+				Labels: ref.Labels,
+				Name:   ref.Name,
+				Last:   i == len(list)-1,
+
+				Queries:    ref.Queries,
+				Retrievals: ref.Retrievals,
+				Pinned:     ref.Pinned,
 			},
 		})
 	}
 }
 
+// Label sets labels on a ref in our index
+func (nd *node) Label(ctx context.Context, args *LabelArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			LabelResult: &LabelResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	root, err := cid.Parse(args.Cid)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	idx := nd.exch.Index()
+	if err := idx.LabelRef(root, args.Labels); err != nil {
+		sendErr(err)
+		return
+	}
+	ref, err := idx.PeekRef(root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		LabelResult: &LabelResult{
+			Labels: ref.Labels,
+		},
+	})
+}
+
+// Amend reopens a committed ref in the current transaction so entries can be added or removed
+// before committing a new version
+func (nd *node) Amend(ctx context.Context, args *AmendArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			AmendResult: &AmendResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	root, err := cid.Parse(args.Ref)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	ref, err := nd.exch.Index().PeekRef(root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	nd.txmu.Lock()
+	defer nd.txmu.Unlock()
+	if nd.tx == nil {
+		nd.tx = nd.exch.Tx(ctx)
+	}
+	if err := nd.tx.Amend(ref); err != nil {
+		sendErr(err)
+		return
+	}
+	for _, k := range args.Remove {
+		if err := nd.tx.Remove(k); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+
+	entries, err := nd.tx.Status()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	nd.send(Notify{
+		AmendResult: &AmendResult{
+			RootCid: nd.tx.Root().String(),
+			Keys:    keys,
+		},
+	})
+}
+
 // Add a buffer into the given DAG. These DAGs can eventually be put into transactions.
 func (nd *node) Add(ctx context.Context, dag ipldformat.DAGService, buf io.Reader) (cid.Cid, error) {
 	bufferedDS := ipldformat.NewBufferedDAG(ctx, dag)
@@ -998,39 +1641,116 @@ func (nd *node) getRef(cstr string) (*exchange.DataRef, error) {
 
 // addRecursive adds entire file trees into a single transaction
 // it assumes the caller is holding the tx lock until it returns
-// it currently flattens the keys though we may want to maintain the full keys to keep the structure
+// The content of a directory is added as separate entries while nested directories are added as
+// UnixFS directories so their structure is preserved, i.e. /cid/a/b/c.jpg resolves to the "a" entry.
 func (nd *node) addRecursive(ctx context.Context, name string, file files.Node, added map[string]bool) error {
 	switch f := file.(type) {
 	case files.Directory:
 		it := f.Entries()
 		for it.Next() {
-			err := nd.addRecursive(ctx, it.Name(), it.Node(), added)
+			err := nd.addEntry(ctx, it.Name(), it.Node(), added)
 			if err != nil {
 				return err
 			}
 		}
 		return it.Err()
 	case files.File:
-		froot, err := nd.Add(ctx, nd.tx.Store().DAG, f)
+		return nd.addEntry(ctx, name, f, added)
+	default:
+		return errors.New("unknown file type")
+	}
+}
+
+// addEntry adds a file or directory as a single entry in the transaction
+func (nd *node) addEntry(ctx context.Context, name string, file files.Node, added map[string]bool) error {
+	var froot cid.Cid
+	var size int64
+	var err error
+	switch f := file.(type) {
+	case files.Directory:
+		froot, size, err = nd.AddDir(ctx, nd.tx.Store().DAG, f)
 		if err != nil {
 			return err
 		}
-
-		size, err := file.Size()
+	case files.File:
+		froot, err = nd.Add(ctx, nd.tx.Store().DAG, f)
 		if err != nil {
 			return err
 		}
-
-		key := exchange.KeyFromPath(name)
-		err = nd.tx.Put(key, froot, size)
+		size, err = f.Size()
 		if err != nil {
 			return err
 		}
-		added[key] = true
-		return nil
 	default:
 		return errors.New("unknown file type")
 	}
+
+	key := exchange.KeyFromPath(name)
+	err = nd.tx.Put(key, froot, size)
+	if err != nil {
+		return err
+	}
+	added[key] = true
+	return nil
+}
+
+// AddDir adds a directory tree into the given DAG as a UnixFS directory. It returns the root of the directory
+// and the total size of the files it contains.
+func (nd *node) AddDir(ctx context.Context, dag ipldformat.DAGService, dir files.Directory) (cid.Cid, int64, error) {
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	prefix.MhType = exchange.DefaultHashFunction
+
+	udir := uio.NewDirectory(dag)
+	udir.SetCidBuilder(prefix)
+
+	var size int64
+	it := dir.Entries()
+	for it.Next() {
+		var c cid.Cid
+		switch f := it.Node().(type) {
+		case files.Directory:
+			var s int64
+			c, s, err = nd.AddDir(ctx, dag, f)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			size += s
+		case files.File:
+			c, err = nd.Add(ctx, dag, f)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			s, err := f.Size()
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			size += s
+		default:
+			return cid.Undef, 0, errors.New("unknown file type")
+		}
+		child, err := dag.Get(ctx, c)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		if err := udir.AddChild(ctx, it.Name(), child); err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return cid.Undef, 0, err
+	}
+
+	dn, err := udir.GetNode()
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if err := dag.Add(ctx, dn); err != nil {
+		return cid.Undef, 0, err
+	}
+	return dn.Cid(), size, nil
 }
 
 // connPeers returns a list of connected peer IDs
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// protectTag is the conn manager tag for peers protected by the node operator
+const protectTag = "pop-admin"
+
+// ProtectSet keeps track of the peers protected from connection pruning. Peers are persisted
+// so the protection survives restarts.
+type ProtectSet struct {
+	h  host.Host
+	ds datastore.Batching
+}
+
+// NewProtectSet creates a new ProtectSet and protects all the peers previously persisted
+func NewProtectSet(h host.Host, ds datastore.Batching) (*ProtectSet, error) {
+	ps := &ProtectSet{
+		h:  h,
+		ds: namespace.Wrap(ds, datastore.NewKey("/protected")),
+	}
+	peers, err := ps.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		h.ConnManager().Protect(p, protectTag)
+	}
+	return ps, nil
+}
+
+// Protect prevents the conn manager from trimming connections with the given peer
+func (ps *ProtectSet) Protect(p peer.ID) error {
+	ps.h.ConnManager().Protect(p, protectTag)
+	return ps.ds.Put(datastore.NewKey(p.String()), []byte{})
+}
+
+// Unprotect lets the conn manager trim connections with the given peer again
+func (ps *ProtectSet) Unprotect(p peer.ID) error {
+	ps.h.ConnManager().Unprotect(p, protectTag)
+	return ps.ds.Delete(datastore.NewKey(p.String()))
+}
+
+// List returns all the protected peers
+func (ps *ProtectSet) List() ([]peer.ID, error) {
+	res, err := ps.ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var peers []peer.ID
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		p, err := peer.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i] < peers[j]
+	})
+	return peers, nil
+}
+
+// Protect adds or removes peers from the protected set and returns the resulting set
+func (nd *node) Protect(ctx context.Context, args *ProtectArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			ProtectResult: &ProtectResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	for _, pstr := range args.Peers {
+		p, err := peer.Decode(pstr)
+		if err != nil {
+			sendErr(ErrInvalidPeer)
+			return
+		}
+		if args.Unprotect {
+			err = nd.ps.Unprotect(p)
+		} else {
+			err = nd.ps.Protect(p)
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	peers, err := nd.ps.List()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &ProtectResult{}
+	for _, p := range peers {
+		res.Peers = append(res.Peers, p.String())
+	}
+	nd.send(Notify{
+		ProtectResult: res,
+	})
+}
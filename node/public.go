@@ -0,0 +1,146 @@
+package node
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/myelnet/pop/exchange"
+)
+
+// publicPageSize is the max number of refs returned by the public index in a single page
+const publicPageSize = 100
+
+// defaultPublicRateLimit is the number of requests per minute a single client can make to the public index
+const defaultPublicRateLimit = 60
+
+// PublicRef is a content ref listed on the public index endpoint
+type PublicRef struct {
+	Cid  string `json:"cid"`
+	Size int64  `json:"size"`
+	// Prices maps region names to the minimum price per byte this node charges in attoFIL
+	Prices map[string]string `json:"prices"`
+}
+
+// PublicIndex is the response from the public index endpoint
+type PublicIndex struct {
+	Refs []PublicRef `json:"refs"`
+	Page int         `json:"page"`
+	Last bool        `json:"last"`
+}
+
+// rateLimiter counts requests per client during a fixed window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	counts map[string]int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		start:  time.Now(),
+		counts: make(map[string]int),
+	}
+}
+
+// allow returns false if the client has made too many requests during the current window
+func (rl *rateLimiter) allow(client string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if time.Since(rl.start) > rl.window {
+		rl.start = time.Now()
+		rl.counts = make(map[string]int)
+	}
+	rl.counts[client]++
+	return rl.counts[client] <= rl.limit
+}
+
+// publicIndex lists all the non private refs we serve for a given page
+func (nd *node) publicIndex(page int) (PublicIndex, error) {
+	list, err := nd.exch.Index().ListRefs()
+	if err != nil {
+		return PublicIndex{}, err
+	}
+	prices := make(map[string]string)
+	for _, r := range exchange.ParseRegions(nd.opts.Regions) {
+		prices[r.Name] = r.PPB.String()
+	}
+
+	var refs []PublicRef
+	for _, ref := range list {
+		if ref.Private {
+			continue
+		}
+		refs = append(refs, PublicRef{
+			Cid:    ref.PayloadCID.String(),
+			Size:   ref.PayloadSize,
+			Prices: prices,
+		})
+	}
+
+	// keep a stable order across pages
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Cid < refs[j].Cid
+	})
+
+	idx := PublicIndex{Page: page}
+	start := page * publicPageSize
+	if start >= len(refs) {
+		idx.Last = true
+		return idx, nil
+	}
+	end := start + publicPageSize
+	if end >= len(refs) {
+		end = len(refs)
+		idx.Last = true
+	}
+	idx.Refs = refs[start:end]
+	return idx, nil
+}
+
+// publicIndexHandler serves the read only list of refs this node serves so explorers can index
+// the content available in our regions
+func (s *server) publicIndexHandler(limit int) http.Handler {
+	if limit <= 0 {
+		limit = defaultPublicRateLimit
+	}
+	rl := newRateLimiter(limit, time.Minute)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !rl.allow(host) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		var page int
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, err = strconv.Atoi(p)
+			if err != nil || page < 0 {
+				http.Error(w, "invalid page", http.StatusBadRequest)
+				return
+			}
+		}
+		idx, err := s.node.publicIndex(page)
+		if err != nil {
+			http.Error(w, "failed to list refs", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(idx)
+	})
+}
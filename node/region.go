@@ -0,0 +1,37 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/myelnet/pop/exchange"
+)
+
+// Region lists the content recently queried or dispatched in our regions so operators can choose
+// popular content to cache before it is dispatched to them
+func (nd *node) Region(ctx context.Context, args *RegionArgs) {
+	if _, ok := exchange.Regions[args.Region]; args.Region != "" && !ok {
+		nd.send(Notify{RegionResult: &RegionResult{
+			Err: fmt.Sprintf("unknown region %s", args.Region),
+		}})
+		return
+	}
+	entries := nd.exch.R().Summary().List(args.Region)
+	if args.Limit > 0 && len(entries) > args.Limit {
+		entries = entries[:args.Limit]
+	}
+	res := &RegionResult{}
+	for _, e := range entries {
+		_, err := nd.exch.Index().PeekRef(e.PayloadCID)
+		res.Entries = append(res.Entries, RegionContentResult{
+			Ref:        e.PayloadCID.String(),
+			Region:     e.Region,
+			Queries:    e.Queries,
+			Dispatches: e.Dispatches,
+			Size:       int64(e.Size),
+			LastSeen:   e.LastSeen,
+			Cached:     err == nil,
+		})
+	}
+	nd.send(Notify{RegionResult: res})
+}
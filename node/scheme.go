@@ -0,0 +1,36 @@
+package node
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/myelnet/pop/exchange"
+)
+
+// Scheme lists the replication schemes we joined and the peers who joined ours so operators can
+// understand their position in the replication graph
+func (nd *node) Scheme(ctx context.Context, args *SchemeArgs) {
+	m := nd.exch.R().Membership()
+	nd.send(Notify{SchemeResult: &SchemeResult{
+		Joined:  nd.schemeMembers(m.Joined()),
+		Members: nd.schemeMembers(m.Members()),
+	}})
+}
+
+func (nd *node) schemeMembers(members []exchange.SchemeMember) []SchemeMemberResult {
+	res := make([]SchemeMemberResult, len(members))
+	for i, m := range members {
+		regions := make([]string, len(m.Regions))
+		for j, code := range m.Regions {
+			regions[j] = exchange.RegionName(code)
+		}
+		res[i] = SchemeMemberResult{
+			Peer:       m.Peer.String(),
+			Regions:    regions,
+			Joined:     m.Joined,
+			LastActive: m.LastActive,
+			Connected:  nd.host.Network().Connectedness(m.Peer) == network.Connected,
+		}
+	}
+	return res
+}
@@ -0,0 +1,203 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/myelnet/pop/exchange"
+	"github.com/rs/zerolog/log"
+)
+
+// Manifest records metadata about a committed transaction so we can search for it later
+type Manifest struct {
+	Root        string
+	Keys        []string
+	Tags        []string
+	Description string
+	Size        int64
+	Created     time.Time
+}
+
+// terms returns all the lowercase searchable terms of a manifest
+func (m Manifest) terms() []string {
+	terms := make([]string, 0, len(m.Keys)+len(m.Tags)+1)
+	for _, k := range m.Keys {
+		terms = append(terms, strings.ToLower(k))
+	}
+	for _, t := range m.Tags {
+		terms = append(terms, strings.ToLower(t))
+	}
+	terms = append(terms, strings.Fields(strings.ToLower(m.Description))...)
+	return terms
+}
+
+// score returns how many of the query words match a manifest term
+func (m Manifest) score(words []string) int {
+	terms := m.terms()
+	score := 0
+	for _, w := range words {
+		for _, t := range terms {
+			if strings.Contains(t, w) {
+				score++
+				break
+			}
+		}
+	}
+	return score
+}
+
+// SearchIndex persists manifests for the transactions we committed
+type SearchIndex struct {
+	ds datastore.Batching
+}
+
+// NewSearchIndex creates a new SearchIndex storing manifests in the given datastore
+func NewSearchIndex(ds datastore.Batching) *SearchIndex {
+	return &SearchIndex{
+		ds: namespace.Wrap(ds, datastore.NewKey("/manifests")),
+	}
+}
+
+// Put adds or replaces a manifest in the index
+func (si *SearchIndex) Put(m Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return si.ds.Put(datastore.NewKey(m.Root), b)
+}
+
+// Get returns the manifest for a given root
+func (si *SearchIndex) Get(root string) (Manifest, error) {
+	var m Manifest
+	b, err := si.ds.Get(datastore.NewKey(root))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Delete removes the manifest for a given root if any
+func (si *SearchIndex) Delete(root string) error {
+	err := si.ds.Delete(datastore.NewKey(root))
+	if err == datastore.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// refDropped removes the manifest of a ref evicted or dropped from the exchange index
+func (si *SearchIndex) refDropped(ref *exchange.DataRef) {
+	if err := si.Delete(ref.PayloadCID.String()); err != nil {
+		log.Error().Err(err).Msg("failed to remove commit manifest")
+	}
+}
+
+// List returns all the manifests in the index
+func (si *SearchIndex) List() ([]Manifest, error) {
+	res, err := si.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var list []Manifest
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var m Manifest
+		if err := json.Unmarshal(r.Value, &m); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+// Search returns all the manifests matching at least one word of the query, best matches first
+func (si *SearchIndex) Search(q string) ([]Manifest, error) {
+	words := strings.Fields(strings.ToLower(q))
+	if len(words) == 0 {
+		return nil, nil
+	}
+	list, err := si.List()
+	if err != nil {
+		return nil, err
+	}
+	type match struct {
+		m     Manifest
+		score int
+	}
+	var matches []match
+	for _, m := range list {
+		if s := m.score(words); s > 0 {
+			matches = append(matches, match{m, s})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score == matches[j].score {
+			return matches[i].m.Created.After(matches[j].m.Created)
+		}
+		return matches[i].score > matches[j].score
+	})
+	out := make([]Manifest, len(matches))
+	for i, m := range matches {
+		out[i] = m.m
+	}
+	return out, nil
+}
+
+// Search our commit manifests for the given query
+func (nd *node) Search(ctx context.Context, args *SearchArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			SearchResult: &SearchResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	matches, err := nd.si.Search(args.Query)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	var list []Manifest
+	for _, m := range matches {
+		if len(args.Labels) > 0 {
+			root, err := cid.Parse(m.Root)
+			if err != nil {
+				continue
+			}
+			ref, err := nd.exch.Index().PeekRef(root)
+			if err != nil || !ref.HasLabels(args.Labels) {
+				continue
+			}
+		}
+		list = append(list, m)
+	}
+	if len(list) == 0 {
+		sendErr(ErrNoMatch)
+		return
+	}
+	for i, m := range list {
+		nd.send(Notify{
+			SearchResult: &SearchResult{
+				Root:        m.Root,
+				Keys:        m.Keys,
+				Tags:        m.Tags,
+				Description: m.Description,
+				Size:        m.Size,
+				Last:        i == len(list)-1,
+			},
+		})
+	}
+}
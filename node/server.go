@@ -3,6 +3,7 @@ package node
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +19,7 @@ import (
 
 	"runtime/debug"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/ipfs/go-cid"
@@ -27,9 +29,32 @@ import (
 	"github.com/myelnet/pop/exchange"
 	"github.com/myelnet/pop/internal/utils"
 	sel "github.com/myelnet/pop/selectors"
+	"github.com/myelnet/pop/wallet"
 	"github.com/rs/zerolog/log"
 )
 
+// newSignerServer creates the server of the signing service holding the keys of our wallet for a fleet of nodes
+func newSignerServer(w wallet.Driver, opts Options) (*http.Server, error) {
+	// anyone able to connect could spend our funds
+	if opts.SignerTLS == nil || opts.SignerTLS.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, ErrSignerTLS
+	}
+	allowed := opts.SignerAllowed
+	if len(allowed) == 0 {
+		if def := w.DefaultAddress(); def != address.Undef {
+			allowed = []address.Address{def}
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("no address to sign with")
+	}
+	return &http.Server{
+		Addr:      opts.SignerAddr,
+		Handler:   wallet.NewSignerHandler(w, allowed),
+		TLSConfig: opts.SignerTLS,
+	}, nil
+}
+
 // server listens for connection and controls the node to execute requests
 type server struct {
 	node *node
@@ -117,7 +142,12 @@ func (s *server) localhostHandler() http.Handler {
 			io.WriteString(w, "<html><title>pop</title><body><h1>Hello</h1>This is your Myel pop.")
 			return
 		}
-		ctx, cancel := context.WithTimeout(r.Context(), time.Hour)
+		// Uploads are given enough time for their size, other requests keep a fixed window
+		timeout := time.Hour
+		if r.Method == http.MethodPost && r.ContentLength > 0 {
+			timeout = exchange.TransferTimeout(uint64(r.ContentLength), 0)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
 		defer cancel()
 		r = r.WithContext(ctx)
 
@@ -177,7 +207,7 @@ func (s *server) getHandler(w http.ResponseWriter, r *http.Request) {
 	if !has {
 		// If there is already a payment channel open we can handle it
 		// else the delay for loading a payment channel is not reasonnable for an HTTP request
-		_, err = s.node.omg.GetOffer(root)
+		_, err = s.node.exch.Offers().Get(root)
 		if err != nil {
 			http.Error(w, "content not cached on this node", http.StatusNotFound)
 			return
@@ -370,6 +400,40 @@ func Run(ctx context.Context, opts Options) error {
 
 	http.Handle("/rpc", rpcServer)
 
+	if opts.PublicIndexAddr != "" {
+		pub := &http.Server{
+			Addr:    opts.PublicIndexAddr,
+			Handler: server.publicIndexHandler(opts.PublicIndexRateLimit),
+		}
+		go func() {
+			if err := pub.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("public index server")
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			pub.Close()
+		}()
+		fmt.Printf("==> Serving public index on %s\n", opts.PublicIndexAddr)
+	}
+
+	if opts.SignerAddr != "" {
+		signer, err := newSignerServer(nd.exch.Wallet(), opts)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := signer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("signing service")
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			signer.Close()
+		}()
+		fmt.Printf("==> Serving signing service on %s\n", opts.SignerAddr)
+	}
+
 	b := backoff.Backoff{
 		Min: time.Second,
 		Max: time.Second * 5,
@@ -0,0 +1,338 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+	"github.com/rs/zerolog/log"
+)
+
+// dayFormat is the format of the keys for each daily bucket so they sort chronologically
+const dayFormat = "2006-01-02"
+
+// StatsRetention is how long daily metrics are kept before being pruned
+const StatsRetention = 365 * 24 * time.Hour
+
+// ErrInvalidSince is returned when the period to query stats for cannot be parsed
+var ErrInvalidSince = errors.New("invalid since duration")
+
+// ErrNoStats is returned when the node doesn't record any stats
+var ErrNoStats = errors.New("stats are not recorded")
+
+// DayStats aggregates the metrics of a node over a single day (UTC)
+type DayStats struct {
+	Day         string
+	BytesServed uint64
+	Earnings    abi.TokenAmount
+	CacheHits   uint64
+	CacheMisses uint64
+	// Peers is the highest number of connected peers seen during the day in each region
+	Peers map[string]int
+}
+
+// HitRate returns the fraction of queries for which we had the content
+func (d DayStats) HitRate() float64 {
+	total := d.CacheHits + d.CacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(d.CacheHits) / float64(total)
+}
+
+// dealProgress is what we already recorded for a deal in progress
+type dealProgress struct {
+	sent     uint64
+	received abi.TokenAmount
+}
+
+// Stats persists daily buckets of metrics so operators can see trends without running a monitoring stack.
+// Metrics are accumulated in memory and written to the datastore on Flush.
+type Stats struct {
+	ds  datastore.Batching
+	now func() time.Time
+
+	mu    sync.Mutex
+	today DayStats
+	deals map[deal.ProviderDealIdentifier]dealProgress
+	// last counters we sampled from the exchange since they only increase
+	hits   uint64
+	misses uint64
+}
+
+// NewStats creates a new Stats and loads the current day if it was already persisted
+func NewStats(ds datastore.Batching) (*Stats, error) {
+	s := &Stats{
+		ds:    namespace.Wrap(ds, datastore.NewKey("/stats")),
+		now:   time.Now,
+		deals: make(map[deal.ProviderDealIdentifier]dealProgress),
+	}
+	day := s.now().UTC().Format(dayFormat)
+	today, err := s.get(day)
+	if errors.Is(err, datastore.ErrNotFound) {
+		today = newDayStats(day)
+	} else if err != nil {
+		return nil, err
+	}
+	s.today = today
+	return s, nil
+}
+
+func newDayStats(day string) DayStats {
+	return DayStats{
+		Day:      day,
+		Earnings: big.Zero(),
+		Peers:    make(map[string]int),
+	}
+}
+
+func (s *Stats) get(day string) (DayStats, error) {
+	b, err := s.ds.Get(datastore.NewKey(day))
+	if err != nil {
+		return DayStats{}, err
+	}
+	var d DayStats
+	if err := json.Unmarshal(b, &d); err != nil {
+		return DayStats{}, err
+	}
+	if d.Peers == nil {
+		d.Peers = make(map[string]int)
+	}
+	return d, nil
+}
+
+func (s *Stats) put(d DayStats) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(datastore.NewKey(d.Day), b)
+}
+
+// rollover persists the current bucket and starts a new one if the day changed. Must hold the lock.
+func (s *Stats) rollover() error {
+	day := s.now().UTC().Format(dayFormat)
+	if day == s.today.Day {
+		return nil
+	}
+	if err := s.put(s.today); err != nil {
+		return err
+	}
+	s.today = newDayStats(day)
+	return s.prune()
+}
+
+// prune removes the buckets older than StatsRetention. Must hold the lock.
+func (s *Stats) prune() error {
+	oldest := s.now().Add(-StatsRetention).UTC().Format(dayFormat)
+	res, err := s.ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := datastore.RawKey(e.Key)
+		if k.BaseNamespace() < oldest {
+			if err := s.ds.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RecordDeal adds the bytes sent and funds received since the last update of a retrieval deal we are providing
+func (s *Stats) RecordDeal(state deal.ProviderState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rollover(); err != nil {
+		log.Error().Err(err).Msg("failed to persist stats")
+	}
+	id := state.Identifier()
+	prev, ok := s.deals[id]
+	if !ok {
+		prev.received = big.Zero()
+	}
+	if state.TotalSent > prev.sent {
+		s.today.BytesServed += state.TotalSent - prev.sent
+	}
+	received := state.FundsReceived
+	if received.Nil() {
+		received = big.Zero()
+	}
+	if received.GreaterThan(prev.received) {
+		s.today.Earnings = big.Add(s.today.Earnings, big.Sub(received, prev.received))
+	}
+	switch state.Status {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored:
+		delete(s.deals, id)
+	default:
+		s.deals[id] = dealProgress{sent: state.TotalSent, received: received}
+	}
+}
+
+// RecordQueries updates the cache hits and misses from the total counters of the exchange
+func (s *Stats) RecordQueries(hits, misses uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rollover(); err != nil {
+		log.Error().Err(err).Msg("failed to persist stats")
+	}
+	if hits >= s.hits {
+		s.today.CacheHits += hits - s.hits
+	}
+	if misses >= s.misses {
+		s.today.CacheMisses += misses - s.misses
+	}
+	s.hits, s.misses = hits, misses
+}
+
+// RecordPeers keeps the highest number of peers seen in each region today
+func (s *Stats) RecordPeers(counts map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rollover(); err != nil {
+		log.Error().Err(err).Msg("failed to persist stats")
+	}
+	for r, n := range counts {
+		if n > s.today.Peers[r] {
+			s.today.Peers[r] = n
+		}
+	}
+}
+
+// Flush persists the metrics of the current day
+func (s *Stats) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rollover(); err != nil {
+		return err
+	}
+	return s.put(s.today)
+}
+
+// Since returns the daily metrics from the given time until now, oldest first
+func (s *Stats) Since(t time.Time) ([]DayStats, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	from := t.UTC().Format(dayFormat)
+	res, err := s.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var days []DayStats
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if datastore.RawKey(r.Key).BaseNamespace() < from {
+			continue
+		}
+		var d DayStats
+		if err := json.Unmarshal(r.Value, &d); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day < days[j].Day
+	})
+	return days, nil
+}
+
+// ParseSince parses a duration supporting a "d" suffix for days in addition to the time.Duration units
+func ParseSince(since string) (time.Duration, error) {
+	if strings.HasSuffix(since, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(since, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidSince, since)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidSince, since)
+	}
+	return d, nil
+}
+
+// recordStats samples the metrics of the exchange and persists them
+func (nd *node) recordStats() {
+	if nd.stats == nil {
+		return
+	}
+	nd.stats.RecordQueries(nd.exch.QueryStats())
+	nd.stats.RecordPeers(nd.exch.R().PeersPerRegion())
+	if err := nd.stats.Flush(); err != nil {
+		log.Error().Err(err).Msg("failed to persist stats")
+	}
+}
+
+// Stats sends the daily metrics for the requested period
+func (nd *node) Stats(ctx context.Context, args *StatsArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{StatsResult: &StatsResult{
+			Err: err.Error(),
+		}})
+	}
+	if nd.stats == nil {
+		sendErr(ErrNoStats)
+		return
+	}
+	since := args.Since
+	if since == "" {
+		since = "30d"
+	}
+	d, err := ParseSince(since)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	// take a fresh sample so today's numbers are up to date
+	nd.recordStats()
+	days, err := nd.stats.Since(time.Now().Add(-d))
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &StatsResult{}
+	for _, day := range days {
+		res.Days = append(res.Days, DayStatsResult{
+			Day:         day.Day,
+			BytesServed: day.BytesServed,
+			Earnings:    filecoin.FIL(day.Earnings).Short(),
+			HitRate:     day.HitRate(),
+			Queries:     day.CacheHits + day.CacheMisses,
+			Peers:       day.Peers,
+		})
+	}
+	nd.send(Notify{StatsResult: res})
+}
+
+// statsSubscriber records the progress of the deals we provide
+func (nd *node) statsSubscriber(event provider.Event, state deal.ProviderState) {
+	if nd.stats == nil {
+		return
+	}
+	nd.stats.RecordDeal(state)
+}
@@ -3,11 +3,17 @@ package node
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/filecoin/storage"
 	"github.com/myelnet/pop/wallet"
+	"github.com/rs/zerolog/log"
 )
 
 // WalletList returns a list of all addresses for which we have the private keys
@@ -68,10 +74,15 @@ func (nd *node) WalletPay(ctx context.Context, args *WalletPayArgs) {
 		})
 	}
 
-	from, err := address.NewFromString(args.From)
-	if err != nil {
-		sendErr(fmt.Errorf("failed to decode address %s : %v", args.From, err))
-		return
+	// Pay from the default address if none is given
+	from := nd.exch.Wallet().DefaultAddress()
+	if args.From != "" {
+		var err error
+		from, err = address.NewFromString(args.From)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to decode address %s : %v", args.From, err))
+			return
+		}
 	}
 
 	to, err := address.NewFromString(args.To)
@@ -87,10 +98,279 @@ func (nd *node) WalletPay(ctx context.Context, args *WalletPayArgs) {
 	}
 
 	nd.send(Notify{
-		WalletResult: &WalletResult{},
+		WalletResult: &WalletResult{
+			Addresses: []string{from.String()},
+		},
+	})
+}
+
+// WalletBalance returns the balance of an address in our wallet and the funds it holds in payment channels
+func (nd *node) WalletBalance(ctx context.Context, args *WalletBalanceArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			WalletResult: &WalletResult{
+				Err: err.Error(),
+			},
+		})
+	}
+
+	addr := nd.exch.Wallet().DefaultAddress()
+	if args.Address != "" {
+		var err error
+		addr, err = nd.walletAddress(args.Address)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+	}
+
+	bal, err := nd.exch.Wallet().Balance(ctx, addr)
+	if err != nil {
+		sendErr(fmt.Errorf("failed to get balance: %w", err))
+		return
+	}
+	funds, err := nd.exch.Payments().LockedFunds(addr)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	var datacap string
+	if nd.storage != nil {
+		dc, err := nd.storage.DataCap(ctx, addr)
+		switch {
+		case err == nil:
+			datacap = filecoin.SizeStr(dc)
+		case !errors.Is(err, storage.ErrNotVerifiedClient):
+			log.Error().Err(err).Str("addr", addr.String()).Msg("failed to get datacap")
+		}
+	}
+
+	nd.send(Notify{
+		WalletResult: &WalletResult{
+			Addresses: []string{addr.String()},
+			Balance:   filecoin.FIL(bal).Short(),
+			Locked:    filecoin.FIL(funds.Locked).Short(),
+			Pending:   filecoin.FIL(funds.Pending).Short(),
+			DataCap:   datacap,
+		},
+	})
+}
+
+// WalletHistory lists the messages we pushed on chain with their status
+func (nd *node) WalletHistory(ctx context.Context, args *WalletHistoryArgs) {
+	from := address.Undef
+	if args.Address != "" {
+		var err error
+		from, err = address.NewFromString(args.Address)
+		if err != nil {
+			nd.send(Notify{
+				WalletResult: &WalletResult{
+					Err: fmt.Sprintf("failed to decode address %s : %v", args.Address, err),
+				},
+			})
+			return
+		}
+	}
+	list, err := nd.history.List(from, args.Limit)
+	if err != nil {
+		nd.send(Notify{
+			WalletResult: &WalletResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	res := &WalletResult{}
+	for _, rec := range list {
+		info := TxInfo{
+			Cid:     rec.Cid.String(),
+			From:    rec.From.String(),
+			To:      rec.To.String(),
+			Kind:    rec.Kind,
+			Status:  string(rec.Status),
+			GasUsed: rec.GasUsed,
+			Height:  int64(rec.Height),
+			Time:    rec.PushedAt,
+		}
+		if !rec.Value.Nil() {
+			info.Amount = filecoin.FIL(rec.Value).Short()
+		}
+		if !rec.Fee.Nil() {
+			info.Fee = filecoin.FIL(rec.Fee).Short()
+		}
+		if rec.ReplacedBy.Defined() {
+			info.ReplacedBy = rec.ReplacedBy.String()
+		}
+		res.History = append(res.History, info)
+	}
+	nd.send(Notify{WalletResult: res})
+}
+
+// SignData signs arbitrary data with the key of an address in our wallet or the default address if empty.
+// The signature is serialized with its type and can be checked with VerifyData.
+func (nd *node) SignData(ctx context.Context, addr string, data []byte) ([]byte, error) {
+	from := nd.exch.Wallet().DefaultAddress()
+	if addr != "" {
+		var err error
+		from, err = nd.walletAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sig, err := wallet.SignData(ctx, nd.exch.Wallet(), from, data)
+	if err != nil {
+		return nil, err
+	}
+	return sig.MarshalBinary()
+}
+
+// VerifyData checks data was signed by the given address with SignData
+func (nd *node) VerifyData(ctx context.Context, addr string, data []byte, sig []byte) (bool, error) {
+	signer, err := address.NewFromString(addr)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode address %s : %v", addr, err)
+	}
+	var s crypto.Signature
+	if err := s.UnmarshalBinary(sig); err != nil {
+		return false, fmt.Errorf("failed to decode signature: %v", err)
+	}
+	return wallet.VerifyData(ctx, nd.exch.Wallet(), signer, data, &s)
+}
+
+// WalletSign signs arbitrary data so applications can authenticate users or sign receipts with our keys
+func (nd *node) WalletSign(ctx context.Context, args *WalletSignArgs) {
+	sig, err := nd.SignData(ctx, args.Address, args.Data)
+	if err != nil {
+		nd.send(Notify{
+			WalletResult: &WalletResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		WalletResult: &WalletResult{Signature: sig},
+	})
+}
+
+// WalletVerify checks the signature of arbitrary data signed with WalletSign
+func (nd *node) WalletVerify(ctx context.Context, args *WalletVerifyArgs) {
+	valid, err := nd.VerifyData(ctx, args.Address, args.Data, args.Signature)
+	if err != nil {
+		nd.send(Notify{
+			WalletResult: &WalletResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		WalletResult: &WalletResult{Valid: valid},
 	})
 }
 
+// WalletMsig proposes, approves or cancels transactions of a multisig wallet we are a signer of
+// and lists the pending ones
+func (nd *node) WalletMsig(ctx context.Context, args *WalletMsigArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			WalletResult: &WalletResult{
+				Err: err.Error(),
+			},
+		})
+	}
+
+	msig := address.Undef
+	if args.Msig != "" {
+		var err error
+		msig, err = address.NewFromString(args.Msig)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to decode address %s : %v", args.Msig, err))
+			return
+		}
+	}
+
+	if args.Op == "pending" {
+		list, err := nd.msig.Pending(ctx, msig)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		res := &WalletResult{}
+		for _, p := range list {
+			res.Proposals = append(res.Proposals, msigProposalInfo(p))
+		}
+		nd.send(Notify{WalletResult: res})
+		return
+	}
+
+	if msig == address.Undef {
+		sendErr(errors.New("missing multisig address"))
+		return
+	}
+	from, err := nd.walletAddress(args.From)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	var p *wallet.MsigProposal
+	switch args.Op {
+	case "propose", "fund":
+		to, aerr := address.NewFromString(args.To)
+		if aerr != nil {
+			sendErr(fmt.Errorf("failed to decode address %s : %v", args.To, aerr))
+			return
+		}
+		amt, aerr := filecoin.ParseFIL(args.Amount)
+		if aerr != nil {
+			sendErr(fmt.Errorf("failed to parse amount %s : %v", args.Amount, aerr))
+			return
+		}
+		if args.Op == "fund" {
+			p, err = nd.msig.ProposeMarketFunds(ctx, msig, from, to, abi.TokenAmount(amt))
+		} else {
+			p, err = nd.msig.Propose(ctx, msig, from, to, abi.TokenAmount(amt), abi.MethodNum(0), nil)
+		}
+	case "approve":
+		p, err = nd.msig.Approve(ctx, msig, from, args.TxnID)
+	case "cancel":
+		err = nd.msig.Cancel(ctx, msig, from, args.TxnID)
+	default:
+		err = fmt.Errorf("unknown multisig operation %s", args.Op)
+	}
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	res := &WalletResult{}
+	if p != nil {
+		res.Proposals = []MsigProposalInfo{msigProposalInfo(*p)}
+	}
+	nd.send(Notify{WalletResult: res})
+}
+
+func msigProposalInfo(p wallet.MsigProposal) MsigProposalInfo {
+	info := MsigProposalInfo{
+		Msig:      p.Msig.String(),
+		TxnID:     p.TxnID,
+		Method:    uint64(p.Method),
+		Approvals: len(p.Approvals),
+		Applied:   p.Applied,
+	}
+	if p.To != address.Undef {
+		info.To = p.To.String()
+	}
+	if p.Proposer != address.Undef {
+		info.Proposer = p.Proposer.String()
+	}
+	if !p.Value.Nil() {
+		info.Amount = filecoin.FIL(p.Value).Short()
+	}
+	return info
+}
+
 // importPrivateKey from a hex encoded private key to use as default on the exchange instead of
 // the auto generated one. This is mostly for development and will be reworked into a nicer command
 // eventually
@@ -147,3 +427,21 @@ func (nd *node) exportPrivateKey(ctx context.Context, addr, outputPath string) e
 
 	return nil
 }
+
+// walletAddress parses an address and checks we hold its private key so we can pay from it
+func (nd *node) walletAddress(s string) (address.Address, error) {
+	addr, err := address.NewFromString(s)
+	if err != nil {
+		return address.Undef, fmt.Errorf("invalid address: %w", err)
+	}
+	addrs, err := nd.exch.Wallet().List()
+	if err != nil {
+		return address.Undef, err
+	}
+	for _, a := range addrs {
+		if a == addr {
+			return addr, nil
+		}
+	}
+	return address.Undef, fmt.Errorf("no private key for address %s", addr)
+}
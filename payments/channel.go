@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -39,6 +40,24 @@ type channel struct {
 	lk            *multiLock
 	fundsReqQueue []*fundsReq
 	msgListeners  msgListeners
+	// leases are the lanes currently held by a session mapped to the amount
+	// already redeemed in the lane when the session acquired it
+	leases      map[laneKey]filecoin.BigInt
+	gas         GasPolicy
+	nonces      *nonceTracker
+	states      *stateCache
+	subscribers *pubsub.PubSub
+	// reorgs watches the create and add funds messages we applied until they are final
+	reorgs *reorgTracker
+	// reserved is the amount of funds held by sessions in progress so concurrent sessions
+	// don't count on the same funds
+	resLk    sync.Mutex
+	reserved filecoin.BigInt
+}
+
+type laneKey struct {
+	ch   address.Address
+	lane uint64
 }
 
 // get ensures that a channel exists between the from and to addresses,
@@ -234,7 +253,7 @@ func (ch *channel) filterQueue() {
 
 	// Allow GC of remaining slice elements
 	for rem := i; rem < len(ch.fundsReqQueue); rem++ {
-		ch.fundsReqQueue[i] = nil
+		ch.fundsReqQueue[rem] = nil
 	}
 
 	// Resize slice
@@ -334,40 +353,43 @@ func (ch *channel) create(ctx context.Context, amt filecoin.BigInt) (cid.Cid, er
 }
 
 func (ch *channel) mpoolPush(ctx context.Context, msg *filecoin.Message) (*filecoin.SignedMessage, error) {
-	msg, err := ch.api.GasEstimateMessageGas(ctx, msg, nil, filecoin.EmptyTSK)
-	if err != nil {
-		return nil, err
+	// The node only estimates the fees we leave empty
+	spec := ch.gas.spec(msgKind(msg))
+	if !spec.FeeCap.Nil() {
+		msg.GasFeeCap = spec.FeeCap
 	}
-
-	act, err := ch.api.StateGetActor(ctx, msg.From, filecoin.EmptyTSK)
+	if !spec.Premium.Nil() {
+		msg.GasPremium = spec.Premium
+	}
+	msg, err := ch.api.GasEstimateMessageGas(ctx, msg, spec.sendSpec(), filecoin.EmptyTSK)
 	if err != nil {
 		return nil, err
 	}
-	msg.Nonce = act.Nonce
-	mbl, err := msg.ToStorageBlock()
-	if err != nil {
+	if err := ch.gas.check(msg); err != nil {
 		return nil, err
 	}
 
-	sig, err := ch.wal.Sign(ctx, msg.From, mbl.Cid().Bytes())
-	if err != nil {
+	// The nonce tracker is aware of our messages still in the pool so concurrent messages don't collide
+	if err := ch.nonces.assign(ctx, ch.api, msg); err != nil {
 		return nil, err
 	}
-
-	smsg := &filecoin.SignedMessage{
-		Message:   *msg,
-		Signature: *sig,
+	smsg, err := signMessage(ctx, ch.wal, msg)
+	if err != nil {
+		ch.nonces.release(msg)
+		return nil, err
 	}
 
 	if _, err := ch.api.MpoolPush(ctx, smsg); err != nil {
 		if strings.Contains(err.Error(), "already in mpool, increase GasPremium") {
 			// incGas picks up the suggested gas premium from the error message and tries to push
 			// a new message to the pool with that amount
-			log.Error().Msg("message already in pool, increasing gas")
+			log.Warn().Str("from", msg.From.String()).Uint64("nonce", msg.Nonce).Msg("message already in pool, increasing gas")
 			return ch.increaseGas(ctx, msg, err.Error())
 		}
+		ch.nonces.release(msg)
 		return nil, fmt.Errorf("MpoolPush failed with error: %v", err)
 	}
+	ch.nonces.track(smsg, cid.Undef)
 
 	return smsg, nil
 }
@@ -383,24 +405,21 @@ func (ch *channel) increaseGas(ctx context.Context, msg *filecoin.Message, rec s
 		return nil, err
 	}
 	msg.GasPremium = prem
-	mbl, err := msg.ToStorageBlock()
-	if err != nil {
-		return nil, err
+	if !msg.GasFeeCap.Nil() && msg.GasPremium.GreaterThan(msg.GasFeeCap) {
+		ch.nonces.release(msg)
+		return nil, fmt.Errorf("%w: premium %s is above fee cap %s", ErrGasTooExpensive, prem, msg.GasFeeCap)
 	}
-
-	sig, err := ch.wal.Sign(ctx, msg.From, mbl.Cid().Bytes())
+	smsg, err := signMessage(ctx, ch.wal, msg)
 	if err != nil {
+		ch.nonces.release(msg)
 		return nil, err
 	}
 
-	smsg := &filecoin.SignedMessage{
-		Message:   *msg,
-		Signature: *sig,
-	}
-
 	if _, err := ch.api.MpoolPush(ctx, smsg); err != nil {
+		ch.nonces.release(msg)
 		return nil, fmt.Errorf("MpoolPush failed with error: %v", err)
 	}
+	ch.nonces.track(smsg, cid.Undef)
 	return smsg, nil
 }
 
@@ -414,7 +433,7 @@ func (ch *channel) mutateChannelInfo(channelID string, mutate func(*ChannelInfo)
 	// we record to the store that we're going to send a message, send
 	// the message, and then record that the message was sent.
 	if err != nil {
-		log.Error().Err(err).Msg("error reading channel info from store")
+		log.Error().Err(err).Str("channelID", channelID).Msg("error reading channel info from store")
 		return
 	}
 
@@ -422,7 +441,7 @@ func (ch *channel) mutateChannelInfo(channelID string, mutate func(*ChannelInfo)
 
 	err = ch.store.putChannelInfo(channelInfo)
 	if err != nil {
-		log.Error().Err(err).Msg("error writing channel info to store")
+		log.Error().Err(err).Str("channelID", channelID).Msg("error writing channel info to store")
 	}
 }
 
@@ -437,6 +456,8 @@ func (ch *channel) waitForPaychCreateMsg(channelID string, mcid cid.Cid) {
 func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 	mwait, err := ch.api.StateWaitMsg(ch.ctx, mcid, uint64(5))
 	if err != nil {
+		log.Error().Err(err).Str("channelID", channelID).Str("mcid", mcid.String()).Msg("error waiting for channel creation")
+		ch.publishMsgFailed(mcid, nil, err)
 		return err
 	}
 
@@ -453,7 +474,8 @@ func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 
 		// Exit code 7 means out of gas
 		err := fmt.Errorf("payment channel creation failed (exit code %d)", mwait.Receipt.ExitCode)
-		log.Error().Err(err).Msg("error")
+		log.Error().Err(err).Str("channelID", channelID).Str("mcid", mcid.String()).Msg("channel creation failed")
+		ch.publishMsgFailed(mcid, nil, err)
 		return err
 	}
 
@@ -463,7 +485,7 @@ func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 	var decodedReturn init2.ExecReturn
 	err = decodedReturn.UnmarshalCBOR(bytes.NewReader(mwait.Receipt.Return))
 	if err != nil {
-		log.Error().Err(err).Msg("error decoding Receipt")
+		log.Error().Err(err).Str("channelID", channelID).Str("mcid", mcid.String()).Msg("error decoding receipt")
 		return err
 	}
 
@@ -471,12 +493,27 @@ func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 	defer ch.lk.Unlock()
 
 	// Store robust address of channel
+	var amt filecoin.BigInt
 	ch.mutateChannelInfo(channelID, func(channelInfo *ChannelInfo) {
+		amt = channelInfo.PendingAmount
 		channelInfo.Channel = &decodedReturn.RobustAddress
 		channelInfo.Amount = channelInfo.PendingAmount
 		channelInfo.PendingAmount = filecoin.NewInt(0)
 		channelInfo.CreateMsg = nil
 	})
+	ch.publish(EventChannelCreated, EventState{
+		Channel:   decodedReturn.RobustAddress,
+		Direction: DirOutbound,
+		Amount:    amt,
+		MsgCid:    mcid,
+	})
+	ch.reorgs.track(mcid, confirmedMsg{
+		channelID: channelID,
+		kind:      MsgCreate,
+		amount:    amt,
+		tipset:    mwait.TipSet,
+		height:    mwait.Height,
+	})
 
 	return nil
 }
@@ -490,7 +527,7 @@ func (ch *channel) msgWaitComplete(mcid cid.Cid, err error) {
 	// Save the message result to the store
 	dserr := ch.store.SaveMessageResult(mcid, err)
 	if dserr != nil {
-		log.Error().Err(dserr).Msg("saving message result")
+		log.Error().Err(dserr).Str("mcid", mcid.String()).Msg("saving message result")
 	}
 
 	// Inform listeners that the message has completed
@@ -528,7 +565,7 @@ func (ch *channel) addFunds(ctx context.Context, channelInfo *ChannelInfo, amt f
 	// look up the channel from the message CID
 	err = ch.store.SaveNewMessage(channelInfo.ChannelID, mcid)
 	if err != nil {
-		log.Error().Err(err).Str("mcid", mcid.String()).Msg("saving add funds message CID")
+		log.Error().Err(err).Str("channelID", channelInfo.ChannelID).Str("mcid", mcid.String()).Msg("saving add funds message CID")
 	}
 
 	go ch.waitForAddFundsMsg(channelInfo.ChannelID, mcid)
@@ -545,21 +582,25 @@ func (ch *channel) waitForAddFundsMsg(channelID string, mcid cid.Cid) {
 func (ch *channel) waitAddFundsMsg(channelID string, mcid cid.Cid) error {
 	mwait, err := ch.api.StateWaitMsg(ch.ctx, mcid, uint64(5))
 	if err != nil {
-		log.Error().Err(err).Str("mcid", mcid.String()).Msg("error waiting for chain message")
+		log.Error().Err(err).Str("channelID", channelID).Str("mcid", mcid.String()).Msg("error waiting for add funds message")
+		ch.publishMsgFailed(mcid, nil, err)
 		return err
 	}
 
 	if mwait.Receipt.ExitCode != 0 {
 		err := fmt.Errorf("voucher channel creation failed: adding funds (exit code %d)", mwait.Receipt.ExitCode)
-		log.Error().Err(err).Msg("error")
+		log.Error().Err(err).Str("channelID", channelID).Str("mcid", mcid.String()).Msg("add funds failed")
 
 		ch.lk.Lock()
 		defer ch.lk.Unlock()
 
+		var chAddr *address.Address
 		ch.mutateChannelInfo(channelID, func(channelInfo *ChannelInfo) {
+			chAddr = channelInfo.Channel
 			channelInfo.PendingAmount = filecoin.NewInt(0)
 			channelInfo.AddFundsMsg = nil
 		})
+		ch.publishMsgFailed(mcid, chAddr, err)
 
 		return err
 	}
@@ -568,18 +609,94 @@ func (ch *channel) waitAddFundsMsg(channelID string, mcid cid.Cid) error {
 	defer ch.lk.Unlock()
 
 	// Store updated amount
+	var state EventState
 	ch.mutateChannelInfo(channelID, func(channelInfo *ChannelInfo) {
+		state = EventState{
+			Channel:   *channelInfo.Channel,
+			Direction: DirOutbound,
+			Amount:    channelInfo.PendingAmount,
+			MsgCid:    mcid,
+		}
 		channelInfo.Amount = filecoin.BigAdd(channelInfo.Amount, channelInfo.PendingAmount)
 		channelInfo.PendingAmount = filecoin.NewInt(0)
 		channelInfo.AddFundsMsg = nil
 	})
+	ch.publish(EventFundsAdded, state)
+	ch.reorgs.track(mcid, confirmedMsg{
+		channelID: channelID,
+		kind:      MsgAddFunds,
+		amount:    state.Amount,
+		tipset:    mwait.TipSet,
+		height:    mwait.Height,
+	})
 
 	return nil
 }
 
+// rollbackMsg removes the funds added by a create or add funds message which was reverted by a reorg
+// and waits for the message to be included again. The channel keeps its address as the same create
+// message deploys the actor at the same address.
+func (ch *channel) rollbackMsg(mcid cid.Cid, cm confirmedMsg) {
+	ch.lk.Lock()
+	var state EventState
+	ch.mutateChannelInfo(cm.channelID, func(channelInfo *ChannelInfo) {
+		channelInfo.Amount = filecoin.BigSub(channelInfo.Amount, cm.amount)
+		if channelInfo.Channel != nil {
+			state.Channel = *channelInfo.Channel
+		}
+	})
+	ch.lk.Unlock()
+
+	log.Warn().Str("mcid", mcid.String()).Str("channelID", cm.channelID).Msg("channel message reverted by a reorg")
+	state.Direction = DirOutbound
+	state.Amount = cm.amount
+	state.MsgCid = mcid
+	ch.publish(EventMsgReverted, state)
+
+	go ch.waitForRevertedMsg(mcid, cm)
+}
+
+// waitForRevertedMsg adds back the funds of a reverted message once it is included again. If it fails
+// this time a channel which creation was reverted is removed.
+func (ch *channel) waitForRevertedMsg(mcid cid.Cid, cm confirmedMsg) {
+	mwait, err := ch.api.StateWaitMsg(ch.ctx, mcid, uint64(5))
+	if err != nil {
+		log.Error().Err(err).Str("mcid", mcid.String()).Msg("error waiting for reverted chain message")
+		return
+	}
+
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	if mwait.Receipt.ExitCode != 0 {
+		log.Error().Str("mcid", mcid.String()).Int64("exitCode", int64(mwait.Receipt.ExitCode)).Msg("reverted channel message failed when included again")
+		if cm.kind == MsgCreate {
+			if err := ch.store.RemoveChannel(cm.channelID); err != nil {
+				log.Error().Err(err).Str("channelID", cm.channelID).Msg("failed to remove channel")
+			}
+		}
+		return
+	}
+
+	ch.mutateChannelInfo(cm.channelID, func(channelInfo *ChannelInfo) {
+		channelInfo.Amount = filecoin.BigAdd(channelInfo.Amount, cm.amount)
+	})
+	cm.tipset = mwait.TipSet
+	cm.height = mwait.Height
+	ch.reorgs.track(mcid, cm)
+}
+
 // load the actor state from the lotus chain when we don't have a record of it locally
 func (ch *channel) loadActorState(chAddr address.Address) (ChannelState, error) {
-	actorState, err := ch.api.StateReadState(ch.ctx, chAddr, filecoin.EmptyTSK)
+	return ch.loadActorStateAt(chAddr, filecoin.EmptyTSK)
+}
+
+// loadActorStateAt loads the actor state of a channel at the given tipset
+func (ch *channel) loadActorStateAt(chAddr address.Address, tsk filecoin.TipSetKey) (ChannelState, error) {
+	actorState, err := ch.api.StateReadState(ch.ctx, chAddr, tsk)
+	if err != nil {
+		return nil, err
+	}
 
 	// TODO: this is a hack to cast the types into the proper data model
 	// there's probably a nicer way to do it
@@ -628,7 +745,13 @@ func (ch *channel) createVoucher(ctx context.Context, chAddr address.Address, vo
 	sv.ChannelAddr = chAddr
 
 	// Get the next nonce on the given lane
-	sv.Nonce = ch.nextNonceForLane(ci, voucher.Lane)
+	sv.Nonce = ci.nextNonce(voucher.Lane)
+
+	// Vouchers are cumulative within a lane so if the lane was reused from a previous
+	// session we add the session amount on top of what was already redeemed
+	if base, ok := ch.leases[laneKey{chAddr, voucher.Lane}]; ok {
+		sv.Amount = big.Add(base, sv.Amount)
+	}
 
 	// Sign the voucher
 	vb, err := sv.SigningBytes()
@@ -658,17 +781,43 @@ func (ch *channel) createVoucher(ctx context.Context, chAddr address.Address, vo
 	return &VoucherCreateResult{Voucher: sv, Shortfall: filecoin.NewInt(0)}, nil
 }
 
-func (ch *channel) nextNonceForLane(ci *ChannelInfo, lane uint64) uint64 {
-	var maxnonce uint64
-	for _, v := range ci.Vouchers {
-		if v.Voucher.Lane == lane {
-			if v.Voucher.Nonce > maxnonce {
-				maxnonce = v.Voucher.Nonce
-			}
+// revokeVoucher removes a voucher we created from the store. Vouchers are cumulative within a lane so
+// it must be the latest voucher of its lane.
+func (ch *channel) revokeVoucher(chAddr address.Address, sv *paych.SignedVoucher) error {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	ci, err := ch.store.ByAddress(chAddr)
+	if err != nil {
+		return err
+	}
+	if ci.Direction != DirOutbound {
+		return fmt.Errorf("%w: not our voucher", ErrVoucherNotRevocable)
+	}
+	idx := -1
+	for i, v := range ci.Vouchers {
+		if v.Voucher == nil || v.Voucher.Lane != sv.Lane {
+			continue
+		}
+		if v.Voucher.Nonce > sv.Nonce {
+			return fmt.Errorf("%w: superseded by nonce %d", ErrVoucherNotRevocable, v.Voucher.Nonce)
+		}
+		eq, err := cborutil.Equals(sv, v.Voucher)
+		if err != nil {
+			return err
+		}
+		if eq {
+			idx = i
 		}
 	}
-
-	return maxnonce + 1
+	if idx < 0 {
+		return fmt.Errorf("%w: voucher not found", ErrVoucherNotRevocable)
+	}
+	if ci.Vouchers[idx].Submitted {
+		return fmt.Errorf("%w: already submitted", ErrVoucherNotRevocable)
+	}
+	ci.Vouchers = append(ci.Vouchers[:idx], ci.Vouchers[idx+1:]...)
+	return ch.store.putChannelInfo(ci)
 }
 
 func (ch *channel) addVoucherUnlocked(ctx context.Context, chAddr address.Address, sv *paych.SignedVoucher, minDelta filecoin.BigInt) (filecoin.BigInt, error) {
@@ -685,7 +834,7 @@ func (ch *channel) addVoucherUnlocked(ctx context.Context, chAddr address.Addres
 		}
 		if eq {
 			// Ignore the duplicate voucher.
-			log.Info().Msg("AddVoucher: voucher re-added")
+			log.Debug().Str("channel", chAddr.String()).Uint64("lane", sv.Lane).Msg("voucher re-added")
 			return filecoin.NewInt(0), nil
 		}
 
@@ -737,27 +886,33 @@ func (ch *channel) checkVoucherValidUnlocked(ctx context.Context, chAddr address
 		return nil, ei, fmt.Errorf("voucher ChannelAddr doesn't match channel address, got %s, expected %s", sv.ChannelAddr, chAddr)
 	}
 
-	act, err := ch.api.StateGetActor(ctx, chAddr, filecoin.EmptyTSK)
+	balance, cached, err := ch.states.balance(ctx, ch.api, chAddr)
 	if err != nil {
 		return nil, ei, err
 	}
 
-	// Load payment channel actor state
-	pchState, err := ch.loadActorState(chAddr)
-	if err != nil {
-		return nil, ei, fmt.Errorf("loadActorState: %w", err)
-	}
-
-	// Load channel "From" account actor state
-	f, err := pchState.From()
-	if err != nil {
-		return nil, ei, err
-	}
-
-	from, err := ch.api.StateAccountKey(ctx, f, filecoin.EmptyTSK)
+	// Load payment channel actor state and the key of its creator, from the cache if we already
+	// read them at the current tipset
+	vs, err := ch.states.voucherState(ctx, ch.api, chAddr, func(tsk filecoin.TipSetKey) (voucherState, error) {
+		st, err := ch.loadActorStateAt(chAddr, tsk)
+		if err != nil {
+			return voucherState{}, fmt.Errorf("loadActorState: %w", err)
+		}
+		// Load channel "From" account actor state
+		f, err := st.From()
+		if err != nil {
+			return voucherState{}, err
+		}
+		from, err := ch.api.StateAccountKey(ctx, f, tsk)
+		if err != nil {
+			return voucherState{}, err
+		}
+		return voucherState{state: st, from: from}, nil
+	})
 	if err != nil {
 		return nil, ei, err
 	}
+	pchState, from := vs.state, vs.from
 
 	// verify voucher signature
 	vb, err := sv.SigningBytes()
@@ -826,16 +981,24 @@ func (ch *channel) checkVoucherValidUnlocked(ctx context.Context, chAddr address
 		return nil, ei, fmt.Errorf("totalRedeemedWithVoucher: %w", err)
 	}
 
-	// Total required balance must not exceed actor balance
-	if act.Balance.LessThan(totalRedeemed) {
-		return nil, ei, newErrInsufficientFunds(filecoin.BigSub(totalRedeemed, act.Balance))
+	// Total required balance must not exceed actor balance. The cached balance may be missing
+	// funds added since we read it so we check again on chain before reporting a shortfall.
+	if balance.LessThan(totalRedeemed) && cached {
+		ch.states.invalidate(chAddr)
+		balance, _, err = ch.states.balance(ctx, ch.api, chAddr)
+		if err != nil {
+			return nil, ei, err
+		}
+	}
+	if balance.LessThan(totalRedeemed) {
+		return nil, ei, newErrInsufficientFunds(filecoin.BigSub(totalRedeemed, balance))
 	}
 
 	if len(sv.Merges) != 0 {
 		return nil, ei, fmt.Errorf("dont currently support paych lane merges")
 	}
 
-	return laneStates, act.Balance, nil
+	return laneStates, balance, nil
 }
 
 // Get the total redeemed amount across all lanes, after applying the voucher
@@ -952,6 +1115,129 @@ func (ch *channel) allocateLane(chAddr address.Address) (uint64, error) {
 	return ch.store.AllocateLane(chAddr)
 }
 
+// acquireLane returns the lowest lane no other session is using, allocating a new one
+// only if all existing lanes are busy so the number of lanes stays bounded by the number
+// of concurrent sessions
+func (ch *channel) acquireLane(chAddr address.Address) (uint64, error) {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	ci, err := ch.store.ByAddress(chAddr)
+	if err != nil {
+		return 0, err
+	}
+	lanes := ci.lanes()
+
+	lane := ci.NextLane
+	for l := uint64(0); l < ci.NextLane; l++ {
+		if _, ok := ch.leases[laneKey{chAddr, l}]; !ok {
+			lane = l
+			break
+		}
+	}
+	if lane == ci.NextLane {
+		lane, err = ch.store.AllocateLane(chAddr)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	base := filecoin.NewInt(0)
+	if li, ok := lanes[lane]; ok {
+		base = li.Redeemed
+	}
+	ch.leases[laneKey{chAddr, lane}] = base
+	return lane, nil
+}
+
+// reserveAvailable reserves an amount for a session if the available funds not held by
+// other sessions cover it
+func (ch *channel) reserveAvailable(available, amt filecoin.BigInt) bool {
+	ch.resLk.Lock()
+	defer ch.resLk.Unlock()
+
+	if ch.reserved.Nil() {
+		ch.reserved = filecoin.NewInt(0)
+	}
+	if !filecoin.BigSub(available, ch.reserved).GreaterThan(amt) {
+		return false
+	}
+	ch.reserved = filecoin.BigAdd(ch.reserved, amt)
+	return true
+}
+
+// reserve holds an amount for a session regardless of the funds available
+func (ch *channel) reserve(amt filecoin.BigInt) {
+	ch.resLk.Lock()
+	defer ch.resLk.Unlock()
+
+	if ch.reserved.Nil() {
+		ch.reserved = filecoin.NewInt(0)
+	}
+	ch.reserved = filecoin.BigAdd(ch.reserved, amt)
+}
+
+// release returns an amount held by a session to the available funds
+func (ch *channel) release(amt filecoin.BigInt) {
+	ch.resLk.Lock()
+	defer ch.resLk.Unlock()
+
+	if ch.reserved.Nil() || ch.reserved.LessThan(amt) {
+		ch.reserved = filecoin.NewInt(0)
+		return
+	}
+	ch.reserved = filecoin.BigSub(ch.reserved, amt)
+}
+
+// reservedAmt returns the amount currently held by sessions
+func (ch *channel) reservedAmt() filecoin.BigInt {
+	ch.resLk.Lock()
+	defer ch.resLk.Unlock()
+
+	if ch.reserved.Nil() {
+		return filecoin.NewInt(0)
+	}
+	return ch.reserved
+}
+
+// clearFailedAddFunds resets the pending amount of a channel if the add funds message we were
+// waiting for failed so the next request can add funds again instead of waiting forever
+func (ch *channel) clearFailedAddFunds(channelID string) error {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	ci, err := ch.store.ByChannelID(channelID)
+	if err != nil {
+		return err
+	}
+	if ci.AddFundsMsg == nil {
+		return nil
+	}
+	mi, err := ch.store.GetMessage(*ci.AddFundsMsg)
+	if err != nil {
+		return err
+	}
+	if !mi.Received || mi.Err == "" {
+		return nil
+	}
+	ch.mutateChannelInfo(channelID, func(ci *ChannelInfo) {
+		ci.PendingAmount = filecoin.NewInt(0)
+		ci.AddFundsMsg = nil
+	})
+	if len(ch.fundsReqQueue) > 0 {
+		go ch.processQueue("")
+	}
+	return nil
+}
+
+// releaseLane makes a lane available to the next session
+func (ch *channel) releaseLane(chAddr address.Address, lane uint64) {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	delete(ch.leases, laneKey{chAddr, lane})
+}
+
 func (ch *channel) availableFunds(channelID string) (*AvailableFunds, error) {
 	return ch.processQueue(channelID)
 }
@@ -1006,6 +1292,7 @@ func (ch *channel) currentAvailableFunds(channelID string, queuedAmt filecoin.Bi
 		PendingWaitSentinel: waitSentinel,
 		QueuedAmt:           queuedAmt,
 		VoucherRedeemedAmt:  totalRedeemed,
+		ReservedAmt:         ch.reservedAmt(),
 	}, nil
 }
 
@@ -1064,6 +1351,13 @@ func (ch *channel) submitVoucher(ctx context.Context, chAddr address.Address, sv
 	if err != nil {
 		return cid.Undef, err
 	}
+	ch.publish(EventVoucherSubmitted, EventState{
+		Channel:   chAddr,
+		Direction: ci.Direction,
+		Amount:    sv.Amount,
+		Lane:      sv.Lane,
+		MsgCid:    smsg.Cid(),
+	})
 
 	return smsg.Cid(), nil
 }
@@ -1144,7 +1438,7 @@ func (ch *channel) settle(ctx context.Context, chAddr address.Address) (cid.Cid,
 	ci.Settling = true
 	err = ch.store.putChannelInfo(ci)
 	if err != nil {
-		log.Error().Err(err).Msg("error marking channel as settled")
+		log.Error().Err(err).Str("channelID", ci.ChannelID).Msg("error marking channel as settled")
 	}
 
 	return smgs.Cid(), err
@@ -1178,6 +1472,64 @@ func (ch *channel) collect(ctx context.Context, chAddr address.Address) (cid.Cid
 	return smsg.Cid(), nil
 }
 
+// inspect summarizes the state of a channel. Lanes only reflect the vouchers we stored if the actor state
+// cannot be loaded.
+func (ch *channel) inspect(ctx context.Context, chAddr address.Address) (*ChannelSummary, error) {
+	ci, err := ch.getChannelInfo(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	sum := &ChannelSummary{Info: ci}
+	// Outbound channels are the only ones we keep track of funds for
+	if ci.Direction == DirOutbound {
+		sum.Funds, err = ch.availableFunds(ci.ChannelID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, mcid := range []*cid.Cid{ci.CreateMsg, ci.AddFundsMsg} {
+		if mcid != nil {
+			sum.PendingMsgs = append(sum.PendingMsgs, *mcid)
+		}
+	}
+
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+	var laneStates map[uint64]LaneState
+	state, err := ch.loadActorState(chAddr)
+	if err == nil {
+		laneStates, err = ch.laneState(state, chAddr)
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("channel", chAddr.String()).Msg("failed to load lane states, using local vouchers")
+		laneStates = make(map[uint64]LaneState)
+		for lane, li := range ci.lanes() {
+			laneStates[lane] = &laneState{
+				LaneState: paych.LaneState{
+					Redeemed: li.Redeemed,
+					Nonce:    li.Nonce,
+				},
+			}
+		}
+	}
+	for lane, ls := range laneStates {
+		nonce, err := ls.Nonce()
+		if err != nil {
+			return nil, err
+		}
+		redeemed, err := ls.Redeemed()
+		if err != nil {
+			return nil, err
+		}
+		_, active := ch.leases[laneKey{chAddr, lane}]
+		sum.Lanes = append(sum.Lanes, LaneInfo{Lane: lane, Nonce: nonce, Redeemed: redeemed, Active: active})
+	}
+	sort.Slice(sum.Lanes, func(i, j int) bool {
+		return sum.Lanes[i].Lane < sum.Lanes[j].Lane
+	})
+	return sum, nil
+}
+
 func (ch *channel) getChannelInfo(addr address.Address) (*ChannelInfo, error) {
 	ch.lk.Lock()
 	defer ch.lk.Unlock()
@@ -1206,6 +1558,37 @@ type AvailableFunds struct {
 	// VoucherRedeemedAmt is the amount that is redeemed by vouchers on-chain
 	// and in the local datastore
 	VoucherRedeemedAmt filecoin.BigInt
+	// ReservedAmt is the amount held by retrieval sessions in progress
+	ReservedAmt filecoin.BigInt
+}
+
+// LockedFunds are the funds of an address held in its outbound payment channels
+type LockedFunds struct {
+	// Locked is the amount confirmed on chain which can only be spent in the channels until they are collected
+	Locked filecoin.BigInt
+	// Pending is the amount waiting for create or add funds messages to be confirmed
+	Pending filecoin.BigInt
+}
+
+// LaneInfo is the state of a lane including the vouchers we have not submitted yet
+type LaneInfo struct {
+	Lane uint64
+	// Nonce is the nonce of the latest voucher in the lane
+	Nonce uint64
+	// Redeemed is the amount of the latest voucher in the lane
+	Redeemed filecoin.BigInt
+	// Active is true while a session holds the lane
+	Active bool
+}
+
+// ChannelSummary describes the state of a channel for inspection
+type ChannelSummary struct {
+	Info *ChannelInfo
+	// Funds is only set for outbound channels
+	Funds *AvailableFunds
+	// PendingMsgs are the create or add funds messages waiting for confirmation
+	PendingMsgs []cid.Cid
+	Lanes       []LaneInfo
 }
 
 // VoucherCreateResult is the response to createVoucher method
@@ -1446,6 +1829,8 @@ func (m *mergedFundsReq) onComplete(res *paychFundsRes) {
 			r.onComplete(res)
 		}
 	}
+	// the message was sent so we don't need the context anymore
+	m.cancel()
 }
 
 // sum is the sum of the amounts in all requests in the merge
@@ -1532,7 +1917,7 @@ func (ml *msgListeners) fireMsgComplete(mcid cid.Cid, err error) {
 	e := ml.ps.Publish(msgCompleteEvt{mcid: mcid, err: err})
 	if e != nil {
 		// In theory we shouldn't ever get an error here
-		log.Error().Err(e).Msg("unexpected error publishing message complete")
+		log.Error().Err(e).Str("mcid", mcid.String()).Msg("unexpected error publishing message complete")
 	}
 }
 
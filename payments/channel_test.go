@@ -90,6 +90,7 @@ func TestChannel(t *testing.T) {
 		store:        store,
 		lk:           &multiLock{globalLock: &mgr.lk},
 		msgListeners: newMsgListeners(),
+		nonces:       newNonceTracker(nil),
 	}
 
 	c, err := ch.create(ctx, fil.NewInt(123))
@@ -287,6 +288,7 @@ func TestLoadActorState(t *testing.T) {
 		store:        store,
 		lk:           &multiLock{globalLock: &mgr.lk},
 		msgListeners: newMsgListeners(),
+		nonces:       newNonceTracker(nil),
 	}
 
 	state, err := ch.loadActorState(chAddr)
@@ -297,3 +299,36 @@ func TestLoadActorState(t *testing.T) {
 
 	require.Equal(t, ch.from, from)
 }
+
+func TestGasPolicy(t *testing.T) {
+	mb := message{from: tutils.NewIDAddr(t, 100)}
+	chAddr := tutils.NewIDAddr(t, 101)
+
+	create, err := mb.Create(tutils.NewIDAddr(t, 102), abi.NewTokenAmount(10))
+	require.NoError(t, err)
+	require.Equal(t, MsgCreate, msgKind(create))
+
+	settle, err := mb.Settle(chAddr)
+	require.NoError(t, err)
+	require.Equal(t, MsgSettle, msgKind(settle))
+
+	gp := GasPolicy{
+		Default: GasSpec{FeeCap: abi.NewTokenAmount(100)},
+		Messages: map[MsgKind]GasSpec{
+			MsgSettle: {FeeCap: abi.NewTokenAmount(200)},
+		},
+		MaxGas: abi.NewTokenAmount(1000),
+	}
+	require.EqualValues(t, 200, gp.spec(MsgSettle).FeeCap.Int64())
+	require.EqualValues(t, 100, gp.spec(MsgCreate).FeeCap.Int64())
+
+	settle.GasFeeCap = abi.NewTokenAmount(200)
+	settle.GasLimit = 5
+	require.NoError(t, gp.check(settle))
+
+	settle.GasLimit = 6
+	require.ErrorIs(t, gp.check(settle), ErrGasTooExpensive)
+
+	// No max gas means we pay whatever the estimate is
+	require.NoError(t, GasPolicy{}.check(settle))
+}
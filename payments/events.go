@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// Event is an event emitted during the lifecycle of a payment channel
+type Event uint64
+
+const (
+	// EventChannelCreated is emitted when a channel we created lands on chain
+	EventChannelCreated Event = iota
+	// EventFundsAdded is emitted when funds we added to a channel land on chain
+	EventFundsAdded
+	// EventVoucherReceived is emitted when we store a voucher received on an inbound channel
+	EventVoucherReceived
+	// EventVoucherSubmitted is emitted when we push a message to redeem a voucher on chain
+	EventVoucherSubmitted
+	// EventSettled is emitted when a channel enters its settlement window, whoever settled it
+	EventSettled
+	// EventCollected is emitted when the funds of a settled channel were sent to their owners
+	EventCollected
+	// EventMsgReverted is emitted when a reorg reverted a create or add funds message we had applied
+	// and the funds it added are no longer available until it is included again
+	EventMsgReverted
+	// EventMsgFailed is emitted when one of our channel messages could not be confirmed or failed on chain
+	EventMsgFailed
+)
+
+// Events maps payment event codes to string names
+var Events = map[Event]string{
+	EventChannelCreated:   "ChannelCreated",
+	EventFundsAdded:       "FundsAdded",
+	EventVoucherReceived:  "VoucherReceived",
+	EventVoucherSubmitted: "VoucherSubmitted",
+	EventSettled:          "Settled",
+	EventCollected:        "Collected",
+	EventMsgReverted:      "MsgReverted",
+	EventMsgFailed:        "MsgFailed",
+}
+
+func (e Event) String() string {
+	if name, ok := Events[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("PaymentEvent(%d)", uint64(e))
+}
+
+// EventState describes the channel and amounts a payment event is about
+type EventState struct {
+	Channel   address.Address
+	Direction uint64
+	// Amount is the funds added for ChannelCreated, FundsAdded and MsgReverted events, the value received
+	// for VoucherReceived events and the voucher amount for VoucherSubmitted events
+	Amount filecoin.BigInt
+	Lane   uint64
+	// MsgCid is the message related to the event if any
+	MsgCid cid.Cid
+	// SettlingAt is the epoch after which a settled channel can be collected
+	SettlingAt abi.ChainEpoch
+	// Err is why the message failed for MsgFailed events
+	Err error
+}
+
+// Subscriber is a callback registered to listen for payment events
+type Subscriber func(event Event, state EventState)
+
+// Unsubscribe stops a subscriber from receiving events
+type Unsubscribe func()
+
+type internalEvent struct {
+	evt   Event
+	state EventState
+}
+
+func eventDispatcher(evt pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	ie, ok := evt.(internalEvent)
+	if !ok {
+		return fmt.Errorf("wrong type of event")
+	}
+	cb, ok := subscriberFn.(Subscriber)
+	if !ok {
+		return fmt.Errorf("wrong type of subscriber")
+	}
+	cb(ie.evt, ie.state)
+	return nil
+}
+
+// SubscribeToEvents listens to the payments we send and receive so applications can reconcile them.
+// Events may be published while a channel is locked so subscribers should not call back into the manager.
+func (p *Payments) SubscribeToEvents(subscriber Subscriber) Unsubscribe {
+	return Unsubscribe(p.subscribers.Subscribe(subscriber))
+}
+
+func (p *Payments) publish(evt Event, state EventState) {
+	publishEvent(p.subscribers, evt, state)
+}
+
+func (ch *channel) publish(evt Event, state EventState) {
+	publishEvent(ch.subscribers, evt, state)
+}
+
+// publishMsgFailed publishes the failure of a message we sent to one of our channels
+func (p *Payments) publishMsgFailed(chAddr address.Address, mcid cid.Cid, err error) {
+	state := EventState{
+		Channel: chAddr,
+		MsgCid:  mcid,
+		Err:     err,
+	}
+	if ci, err := p.store.ByAddress(chAddr); err == nil {
+		state.Direction = ci.Direction
+	}
+	p.publish(EventMsgFailed, state)
+}
+
+// publishMsgFailed publishes the failure of an outbound channel message. The channel may be nil if
+// the message was creating it.
+func (ch *channel) publishMsgFailed(mcid cid.Cid, chAddr *address.Address, err error) {
+	state := EventState{
+		Direction: DirOutbound,
+		MsgCid:    mcid,
+		Err:       err,
+	}
+	if chAddr != nil {
+		state.Channel = *chAddr
+	}
+	ch.publish(EventMsgFailed, state)
+}
+
+func publishEvent(ps *pubsub.PubSub, evt Event, state EventState) {
+	if ps == nil {
+		return
+	}
+	_ = ps.Publish(internalEvent{evt, state})
+}
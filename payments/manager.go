@@ -2,8 +2,10 @@ package payments
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -12,10 +14,12 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/internal/utils"
 	"github.com/myelnet/pop/wallet"
 	"github.com/rs/zerolog/log"
 )
@@ -26,12 +30,26 @@ type Manager interface {
 	WaitForChannel(context.Context, cid.Cid) (address.Address, error)
 	ListChannels() ([]address.Address, error)
 	GetChannelInfo(address.Address) (*ChannelInfo, error)
+	InspectChannel(context.Context, address.Address) (*ChannelSummary, error)
 	CreateVoucher(context.Context, address.Address, filecoin.BigInt, uint64) (*VoucherCreateResult, error)
+	RevokeVoucher(context.Context, address.Address, *paych.SignedVoucher) error
 	AllocateLane(context.Context, address.Address) (uint64, error)
+	AcquireLane(context.Context, address.Address) (uint64, error)
+	ReleaseLane(context.Context, address.Address, uint64) error
+	ReleaseFunds(context.Context, address.Address, address.Address, filecoin.BigInt) error
 	AddVoucherInbound(context.Context, address.Address, *paych.SignedVoucher, []byte, filecoin.BigInt) (filecoin.BigInt, error)
+	ListVouchers(context.Context, address.Address) ([]*VoucherInfo, error)
+	CheckVoucherSpendable(context.Context, address.Address, *paych.SignedVoucher, []byte, []byte) (bool, error)
+	SubmitVoucher(context.Context, address.Address, *paych.SignedVoucher, []byte, []byte) (cid.Cid, error)
 	ChannelAvailableFunds(address.Address) (*AvailableFunds, error)
+	LockedFunds(address.Address) (*LockedFunds, error)
 	SubmitAllVouchers(context.Context, address.Address) error
+	Checkpoint(context.Context, address.Address) error
+	Export(io.Writer, string) error
+	Import(io.Reader, string) (int, error)
+	SubscribeToEvents(Subscriber) Unsubscribe
 	Settle(context.Context, address.Address) error
+	Collect(context.Context, address.Address) error
 	StartAutoCollect(context.Context) error
 }
 
@@ -49,23 +67,110 @@ type Payments struct {
 
 	stopmu sync.Mutex
 	stop   chan struct{}
+
+	// checkpointInterval is how many epochs we wait before submitting the vouchers of all inbound channels
+	checkpointInterval abi.ChainEpoch
+	// checkpointAmount is the unsubmitted value of an inbound channel after which Checkpoint submits its vouchers
+	checkpointAmount filecoin.BigInt
+	// gas is the fees policy for all our payment channel messages
+	gas GasPolicy
+	// nonces is shared by all channels and uses the wallet tracker so messages from the same address don't collide
+	nonces *nonceTracker
+	// states caches the balance and actor state of inbound channels at the current tipset
+	// to check vouchers against
+	states *stateCache
+	// subscribers receive the events of all our channels
+	subscribers *pubsub.PubSub
+	// reorgs watches our confirmed channel messages until they are final
+	reorgs *reorgTracker
+
+	smu sync.Mutex
+	// submitting are the settling inbound channels we are submitting vouchers for
+	submitting map[address.Address]struct{}
+}
+
+// DefaultCheckpointInterval is how often we submit the best vouchers of our inbound channels by default
+const DefaultCheckpointInterval = abi.ChainEpoch(builtin.EpochsInDay)
+
+// ErrNoVouchers is returned when submitting the vouchers of a channel we have no spendable vouchers for
+var ErrNoVouchers = errors.New("no vouchers to redeem")
+
+// Option is an optional configuration of the payments manager
+type Option func(p *Payments)
+
+// WithCheckpoints sets how many epochs we wait before submitting the best vouchers of all inbound channels
+// and the unsubmitted value after which a channel is submitted right away. Vouchers are always submitted
+// when a channel settles. An interval or amount of zero disables the related checkpoints.
+func WithCheckpoints(interval abi.ChainEpoch, amount filecoin.BigInt) Option {
+	return func(p *Payments) {
+		p.checkpointInterval = interval
+		p.checkpointAmount = amount
+	}
+}
+
+// WithGasPolicy sets the fees we pay for payment channel messages and the max fee after which
+// operations fail with ErrGasTooExpensive
+func WithGasPolicy(gp GasPolicy) Option {
+	return func(p *Payments) {
+		p.gas = gp
+	}
 }
 
 // New creates a new instance of payments manager
-func New(ctx context.Context, api filecoin.API, w wallet.Driver, ds datastore.Batching, bs cbor.IpldBlockstore) *Payments {
+func New(ctx context.Context, api filecoin.API, w wallet.Driver, ds datastore.Batching, bs cbor.IpldBlockstore, opts ...Option) *Payments {
 	store := NewStore(ds)
-	return &Payments{
-		ctx:      ctx,
-		api:      api,
-		wal:      w,
-		store:    store,
-		actStore: cbor.NewCborStore(bs),
-		channels: make(map[string]*channel),
+	p := &Payments{
+		ctx:                ctx,
+		api:                api,
+		wal:                w,
+		store:              store,
+		actStore:           cbor.NewCborStore(bs),
+		channels:           make(map[string]*channel),
+		checkpointInterval: DefaultCheckpointInterval,
+		checkpointAmount:   filecoin.NewInt(0),
+		nonces:             newNonceTracker(w.Nonces()),
+		states:             newStateCache(),
+		subscribers:        pubsub.New(eventDispatcher),
+		reorgs:             newReorgTracker(),
+		submitting:         make(map[address.Address]struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	if api != nil {
+		if err := p.restartPending(); err != nil {
+			log.Error().Err(err).Msg("failed to restart waiting for pending channel messages")
+		}
+	}
+	return p
+}
+
+// restartPending resumes waiting for the create and add funds messages we sent before the last
+// shutdown so the funds requests queued behind them are processed once they land
+func (p *Payments) restartPending() error {
+	cis, err := p.store.WithPendingAddFunds()
+	if err != nil {
+		return err
+	}
+	for _, ci := range cis {
+		ch, err := p.channelByFromTo(ci.Control, ci.Target)
+		if err != nil {
+			return err
+		}
+		if ci.CreateMsg != nil {
+			go ch.waitForPaychCreateMsg(ci.ChannelID, *ci.CreateMsg)
+			continue
+		}
+		go ch.waitForAddFundsMsg(ci.ChannelID, *ci.AddFundsMsg)
+	}
+	return nil
 }
 
 // GetChannel adds fund to a new channel in a given direction, if one already exists it will update it
-// it does not wait for the message to be confirmed on chain
+// it does not wait for the message to be confirmed on chain. An open channel, or one still pending
+// creation, is reused when its funds not reserved by other sessions cover the amount so sessions with
+// the same provider don't pay gas for a new channel each time. Inbound channels from the provider cannot
+// be reused since funds only flow from the creator of a channel.
 func (p *Payments) GetChannel(ctx context.Context, from, to address.Address, amt filecoin.BigInt) (*ChannelResponse, error) {
 	ch, err := p.channelByFromTo(from, to)
 	if err != nil {
@@ -81,17 +186,27 @@ func (p *Payments) GetChannel(ctx context.Context, from, to address.Address, amt
 		// ConfirmedAmt is the current channel balance
 		// VoucherRedeemedAmt is all the voucher we spent that will be deducted from that balance
 		available := big.Sub(afunds.ConfirmedAmt, afunds.VoucherRedeemedAmt)
-		if available.GreaterThan(amt) {
+		if ci.Channel != nil && ch.reserveAvailable(available, amt) {
 			return &ChannelResponse{
 				Channel:      *ci.Channel,
 				WaitSentinel: cid.Undef,
 			}, nil
 		}
+		// The channel may still be pending creation or have funds on the way which other sessions
+		// don't need so we wait for the same message instead of paying for another one
+		if afunds.PendingWaitSentinel != nil && ch.reserveAvailable(big.Add(available, afunds.PendingAmt), amt) {
+			res := &ChannelResponse{WaitSentinel: *afunds.PendingWaitSentinel}
+			if ci.Channel != nil {
+				res.Channel = *ci.Channel
+			}
+			return res, nil
+		}
 	}
 	addr, pcid, err := ch.get(ctx, amt)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to get or create pay channel from accessor: %v", err)
 	}
+	ch.reserve(amt)
 	return &ChannelResponse{
 		Channel:      addr,
 		WaitSentinel: pcid,
@@ -130,6 +245,16 @@ func (p *Payments) CreateVoucher(ctx context.Context, chAddr address.Address, am
 	return ch.createVoucher(ctx, chAddr, vouch)
 }
 
+// RevokeVoucher forgets a voucher we created but could not deliver so the next voucher of its lane
+// doesn't pay for it. Only the latest voucher of a lane can be revoked.
+func (p *Payments) RevokeVoucher(ctx context.Context, chAddr address.Address, sv *paych.SignedVoucher) error {
+	ch, err := p.channelByAddress(chAddr)
+	if err != nil {
+		return fmt.Errorf("Unable to find channel to revoke voucher for: %v", err)
+	}
+	return ch.revokeVoucher(chAddr, sv)
+}
+
 // AllocateLane creates a new lane for a given channel
 func (p *Payments) AllocateLane(ctx context.Context, chAddr address.Address) (uint64, error) {
 	ch, err := p.channelByAddress(chAddr)
@@ -139,6 +264,47 @@ func (p *Payments) AllocateLane(ctx context.Context, chAddr address.Address) (ui
 	return ch.allocateLane(chAddr)
 }
 
+// ReleaseFunds returns the funds reserved by GetChannel for a session to the available funds once the
+// session is over. If the session failed while funds were being added and the message failed
+// we also clear the pending amount so the next session can add funds again.
+func (p *Payments) ReleaseFunds(ctx context.Context, from, to address.Address, amt filecoin.BigInt) error {
+	ch, err := p.channelByFromTo(from, to)
+	if err != nil {
+		return fmt.Errorf("Unable to find channel to release funds: %v", err)
+	}
+	ch.release(amt)
+
+	ci, err := p.store.OutboundActiveByFromTo(from, to)
+	if err == ErrChannelNotTracked {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ch.clearFailedAddFunds(ci.ChannelID)
+}
+
+// AcquireLane returns a lane for a new session, reusing a lane previous sessions
+// with the same counterparty are done with when possible. Vouchers created in the lane
+// until it is released only need to account for the amount spent during the session.
+func (p *Payments) AcquireLane(ctx context.Context, chAddr address.Address) (uint64, error) {
+	ch, err := p.channelByAddress(chAddr)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find channel to acquire lane: %v", err)
+	}
+	return ch.acquireLane(chAddr)
+}
+
+// ReleaseLane lets other sessions use a lane once a session is over
+func (p *Payments) ReleaseLane(ctx context.Context, chAddr address.Address, lane uint64) error {
+	ch, err := p.channelByAddress(chAddr)
+	if err != nil {
+		return fmt.Errorf("Unable to find channel to release lane: %v", err)
+	}
+	ch.releaseLane(chAddr, lane)
+	return nil
+}
+
 // ChannelAvailableFunds returns the amount a channel can still spend
 func (p *Payments) ChannelAvailableFunds(chAddr address.Address) (*AvailableFunds, error) {
 	ch, err := p.channelByAddress(chAddr)
@@ -154,6 +320,34 @@ func (p *Payments) ChannelAvailableFunds(chAddr address.Address) (*AvailableFund
 	return ch.availableFunds(ci.ChannelID)
 }
 
+// LockedFunds sums the funds of an address held in its outbound channels. Channels which are settling or
+// collected are skipped as their funds go back to us.
+func (p *Payments) LockedFunds(from address.Address) (*LockedFunds, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	cis, err := p.store.OutboundByFrom(from)
+	if err != nil {
+		return nil, err
+	}
+	funds := &LockedFunds{
+		Locked:  filecoin.NewInt(0),
+		Pending: filecoin.NewInt(0),
+	}
+	for _, ci := range cis {
+		if ci.Settling || ci.Collected {
+			continue
+		}
+		if !ci.Amount.Nil() {
+			funds.Locked = big.Add(funds.Locked, ci.Amount)
+		}
+		if !ci.PendingAmount.Nil() {
+			funds.Pending = big.Add(funds.Pending, ci.PendingAmount)
+		}
+	}
+	return funds, nil
+}
+
 // ListChannels we have in the store
 func (p *Payments) ListChannels() ([]address.Address, error) {
 	// Need to take an exclusive lock here so that channel operations can't run
@@ -182,6 +376,16 @@ func (p *Payments) GetChannelInfo(chAddr address.Address) (*ChannelInfo, error)
 	return ch.getChannelInfo(chAddr)
 }
 
+// InspectChannel returns the info we stored about a channel along with its funds, pending messages
+// and the state of each lane
+func (p *Payments) InspectChannel(ctx context.Context, chAddr address.Address) (*ChannelSummary, error) {
+	ch, err := p.channelByAddress(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	return ch.inspect(ctx, chAddr)
+}
+
 // AddVoucherInbound adds a voucher for an inbound channel.
 // If the channel is not in the store, fetches the channel from state (and checks that
 // the channel To address is owned by the wallet).
@@ -195,8 +399,18 @@ func (p *Payments) AddVoucherInbound(ctx context.Context, chAddr address.Address
 		return filecoin.BigInt{}, err
 	}
 	ch.lk.Lock()
-	defer ch.lk.Unlock()
-	return ch.addVoucherUnlocked(ctx, chAddr, sv, minDelta)
+	received, err := ch.addVoucherUnlocked(ctx, chAddr, sv, minDelta)
+	ch.lk.Unlock()
+	if err != nil {
+		return received, err
+	}
+	p.publish(EventVoucherReceived, EventState{
+		Channel:   chAddr,
+		Direction: DirInbound,
+		Amount:    received,
+		Lane:      sv.Lane,
+	})
+	return received, nil
 }
 
 // SubmitVoucher gets a channel from the store and submits a new voucher to the chain
@@ -224,18 +438,31 @@ func (p *Payments) SubmitAllVouchers(ctx context.Context, addr address.Address)
 	}
 	if len(best) == 0 {
 		// If we have no vouchers to redeem it's probably not worth settling
-		return errors.New("no vouchers to redeem")
+		return ErrNoVouchers
 	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(best))
 
+	// Every voucher is submitted even if some fail, we report how many didn't make it on chain
+	var mu sync.Mutex
+	var failed int
+	var lastErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed++
+		lastErr = err
+	}
+
 	// I think all lanes should be merged so we might only get a single message but just in case
 	// we iterate over all the vouchers
 	for _, voucher := range best {
 		mcid, err := ch.submitVoucher(ctx, addr, voucher, nil)
 		if err != nil {
-			log.Error().Err(err).Msg("unable to submit voucher")
+			log.Error().Err(err).Str("channel", addr.String()).Uint64("lane", voucher.Lane).Msg("unable to submit voucher")
+			fail(err)
+			wg.Done()
 			continue
 		}
 		go func(vouch *paych.SignedVoucher, mcid cid.Cid) {
@@ -244,19 +471,65 @@ func (p *Payments) SubmitAllVouchers(ctx context.Context, addr address.Address)
 			if err != nil {
 				log.Error().Err(err).
 					Str("channel", addr.String()).
+					Str("mcid", mcid.String()).
 					Msg("waiting for voucher to submit")
+				p.publishMsgFailed(addr, mcid, err)
+				fail(err)
 				return
 			}
 			if lookup.Receipt.ExitCode != 0 {
-				log.Error().Err(err).
+				log.Error().
 					Str("channel", addr.String()).
+					Str("mcid", mcid.String()).
 					Str("code", lookup.Receipt.ExitCode.String()).
 					Msg("voucher update execution failed")
+				err := fmt.Errorf("voucher update failed with code %d", lookup.Receipt.ExitCode)
+				p.publishMsgFailed(addr, mcid, err)
+				fail(err)
 			}
 		}(voucher, mcid)
 	}
 	// Wait to settle and send the last vouchers then we save the collection epoch
 	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("failed to submit %d of %d vouchers: %w", failed, len(best), lastErr)
+	}
+	return nil
+}
+
+// Checkpoint submits the best vouchers of an inbound channel only once the value we haven't submitted yet
+// reaches the checkpoint amount. Submitting a single voucher per lane for many payments saves on gas, the rest
+// is submitted at the next periodic checkpoint or when the channel settles.
+func (p *Payments) Checkpoint(ctx context.Context, addr address.Address) error {
+	if p.checkpointAmount.Nil() || p.checkpointAmount.IsZero() {
+		return nil
+	}
+	ci, err := p.store.ByAddress(addr)
+	if err != nil {
+		return err
+	}
+	if ci.unsubmitted().LessThan(p.checkpointAmount) {
+		return nil
+	}
+	return p.SubmitAllVouchers(ctx, addr)
+}
+
+// checkpointAll submits the best vouchers of every inbound channel with value we haven't submitted yet
+func (p *Payments) checkpointAll(ctx context.Context) error {
+	chans, err := p.store.findChans(func(ci *ChannelInfo) bool {
+		return ci.Channel != nil && ci.Direction == DirInbound && !ci.Settling && ci.unsubmitted().GreaterThan(big.Zero())
+	}, 0)
+	if err != nil {
+		return err
+	}
+	for _, ci := range chans {
+		addr := *ci.Channel
+		go func() {
+			if err := p.SubmitAllVouchers(ctx, addr); err != nil {
+				log.Error().Err(err).Str("channel", addr.String()).Msg("failed to checkpoint vouchers")
+			}
+		}()
+	}
 	return nil
 }
 
@@ -276,14 +549,18 @@ func (p *Payments) Settle(ctx context.Context, addr address.Address) error {
 	// cancelling the context will timeout the wait function and all our goroutines will return
 	lookup, err := p.api.StateWaitMsg(ctx, mcid, uint64(5))
 	if err != nil {
+		p.publishMsgFailed(addr, mcid, err)
 		return err
 	}
 	if lookup.Receipt.ExitCode != 0 {
-		log.Error().Err(err).
+		log.Error().
 			Str("channel", addr.String()).
+			Str("mcid", mcid.String()).
 			Str("code", lookup.Receipt.ExitCode.String()).
 			Msg("payment execution failed")
-		return fmt.Errorf("payment execution failed")
+		err := fmt.Errorf("payment execution failed")
+		p.publishMsgFailed(addr, mcid, err)
+		return err
 	}
 
 	state, err := ch.loadActorState(addr)
@@ -298,7 +575,128 @@ func (p *Payments) Settle(ctx context.Context, addr address.Address) error {
 	if err != nil {
 		return err
 	}
-	return p.store.SetChannelSettlingAt(ci, ep)
+	if err := p.store.SetChannelSettlingAt(ci, ep); err != nil {
+		return err
+	}
+	p.publish(EventSettled, EventState{
+		Channel:    addr,
+		Direction:  ci.Direction,
+		MsgCid:     mcid,
+		SettlingAt: ep,
+	})
+	return nil
+}
+
+// Collect sends the funds of a channel to their owners once its settlement window elapsed. Inbound channels
+// redeem the vouchers we submitted and return the rest to the sender.
+func (p *Payments) Collect(ctx context.Context, addr address.Address) error {
+	ci, err := p.store.ByAddress(addr)
+	if err != nil {
+		return err
+	}
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+	return p.collectAt(ctx, ci, head.Height())
+}
+
+// collectAt collects a settling channel if its settlement window elapsed at the given epoch
+func (p *Payments) collectAt(ctx context.Context, ci *ChannelInfo, epoch abi.ChainEpoch) error {
+	if !ci.Settling {
+		return ErrChannelNotSettling
+	}
+	if ci.SettlingAt >= epoch {
+		return fmt.Errorf("%w until epoch %d", ErrChannelSettling, ci.SettlingAt)
+	}
+	// Using ByFromTo to avoid another store read
+	ch, err := p.channelByFromTo(ci.Control, ci.Target)
+	if err != nil {
+		return err
+	}
+	mcid, err := ch.collect(ctx, *ci.Channel)
+	if err != nil {
+		return err
+	}
+	lookup, err := p.api.StateWaitMsg(ctx, mcid, uint64(5))
+	if err != nil {
+		err = fmt.Errorf("waiting to collect channel %s: %v", ci.Channel, err)
+		p.publishMsgFailed(*ci.Channel, mcid, err)
+		return err
+	}
+	if lookup.Receipt.ExitCode != 0 {
+		err = fmt.Errorf("collecting channel %s failed with code %d", ci.Channel, lookup.Receipt.ExitCode)
+		p.publishMsgFailed(*ci.Channel, mcid, err)
+		return err
+	}
+	ch.mutateChannelInfo(ci.ChannelID, func(ci *ChannelInfo) {
+		ci.Settling = false
+		ci.Collected = true
+	})
+	p.publish(EventCollected, EventState{
+		Channel:    *ci.Channel,
+		Direction:  ci.Direction,
+		MsgCid:     mcid,
+		SettlingAt: ci.SettlingAt,
+	})
+	return nil
+}
+
+// Export writes all our channels, vouchers and message results to w encrypted with a key derived from
+// the passphrase so they can be imported on another machine
+func (p *Payments) Export(w io.Writer, passphrase string) error {
+	data, err := p.store.Export()
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	c, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := c.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return err
+	}
+	_, err = w.Write(ciphertext)
+	return err
+}
+
+// Import reads an export and adds the channels we don't know about yet to our store. It returns the number
+// of channels added. Channels we already track are left untouched.
+func (p *Payments) Import(r io.Reader, passphrase string) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 32 {
+		return 0, utils.ErrDecrypt
+	}
+	c, err := exportCipher(passphrase, data[:32])
+	if err != nil {
+		return 0, err
+	}
+	plaintext, err := c.Decrypt(data[32:])
+	if err != nil {
+		return 0, err
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.store.Import(plaintext)
+}
+
+func exportCipher(passphrase string, salt []byte) (utils.Cipher, error) {
+	key, err := utils.KeyFromPassphrase(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewAESCipher(key)
 }
 
 // StartAutoCollect is a routine that ticks every epoch and tries to collect settling payment channels
@@ -316,6 +714,7 @@ func (p *Payments) StartAutoCollect(ctx context.Context) error {
 func (p *Payments) collectLoop(ctx context.Context) {
 	p.stopmu.Lock()
 	if p.stop != nil {
+		p.stopmu.Unlock()
 		return // already running
 	}
 	p.stop = make(chan struct{})
@@ -326,17 +725,33 @@ func (p *Payments) collectLoop(ctx context.Context) {
 		return
 	}
 	epoch := head.Height()
+	checkpoint := epoch
+	ticker := time.NewTicker(builtin.EpochDurationSeconds * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.Tick(builtin.EpochDurationSeconds * time.Second):
+		case <-ticker.C:
 			epoch++
-			p.collectForEpoch(ctx, epoch)
+			p.resubmitStuck(ctx)
+			p.checkReorgs(ctx)
+			if err := p.watchSettlements(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to list channels to watch")
+			}
+			if err := p.collectForEpoch(ctx, epoch); err != nil {
+				log.Error().Err(err).Msg("failed to list settling channels")
+			}
+			if p.checkpointInterval > 0 && epoch-checkpoint >= p.checkpointInterval {
+				checkpoint = epoch
+				if err := p.checkpointAll(ctx); err != nil {
+					log.Error().Err(err).Msg("failed to list channels to checkpoint")
+				}
+			}
 
 			// We've prob lost sync with the clock so let's query again just in case
 			head, err := p.api.ChainHead(ctx)
 			// no need to fail the whole routine if the request fails once in a while
 			if err != nil {
-				log.Error().Err(err)
+				log.Error().Err(err).Msg("failed to get chain head")
 				continue
 			}
 			epoch = head.Height()
@@ -349,41 +764,167 @@ func (p *Payments) collectLoop(ctx context.Context) {
 	}
 }
 
-// collectForEpoch tries to collect any channel matching with the epoch
+// resubmitStuck bumps the fees of our messages waiting in the pool for longer than ResubmitTimeout
+// and pushes them again. Messages which would cost more than our gas policy allows are left as is.
+func (p *Payments) resubmitStuck(ctx context.Context) {
+	for c, msg := range p.nonces.stuck(ctx, p.api, time.Now()) {
+		msg := msg
+		bumpFees(&msg)
+		if err := p.gas.check(&msg); err != nil {
+			log.Warn().Err(err).Str("cid", c.String()).Msg("not resubmitting stuck message")
+			continue
+		}
+		smsg, err := signMessage(ctx, p.wal, &msg)
+		if err != nil {
+			log.Error().Err(err).Str("cid", c.String()).Msg("failed to sign replacement message")
+			continue
+		}
+		if _, err := p.api.MpoolPush(ctx, smsg); err != nil {
+			log.Error().Err(err).Str("cid", c.String()).Msg("failed to push replacement message")
+			continue
+		}
+		p.nonces.track(smsg, c)
+		log.Info().Str("cid", c.String()).Str("replacement", smsg.Cid().String()).Msg("resubmitted stuck message with higher fees")
+	}
+}
+
+// checkReorgs rolls back the funds added by our channel messages which a reorg reverted
+func (p *Payments) checkReorgs(ctx context.Context) {
+	// the depth of our messages is measured from the actual head as our epoch counter drifts
+	head, err := p.api.ChainHead(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get chain head")
+		return
+	}
+	for c, cm := range p.reorgs.reverted(ctx, p.api, head.Height()) {
+		ci, err := p.store.ByChannelID(cm.channelID)
+		if err != nil {
+			log.Error().Err(err).Str("channelID", cm.channelID).Msg("failed to load channel of reverted message")
+			continue
+		}
+		ch, err := p.channelByFromTo(ci.Control, ci.Target)
+		if err != nil {
+			log.Error().Err(err).Str("channelID", cm.channelID).Msg("failed to get channel of reverted message")
+			continue
+		}
+		ch.rollbackMsg(c, cm)
+	}
+}
+
+// collectForEpoch tries to collect the channels which settlement window elapsed at the given epoch.
+// A channel failing to collect doesn't prevent collecting the others, it is retried at the next epoch.
 func (p *Payments) collectForEpoch(ctx context.Context, epoch abi.ChainEpoch) error {
 	settling, err := p.store.ListSettlingChannels()
 	if err != nil || len(settling) == 0 {
 		return err
 	}
 	for _, sci := range settling {
-		if sci.SettlingAt < epoch {
-			// Using ByFromTo to avoid another store read
-			ch, err := p.channelByFromTo(sci.Control, sci.Target)
+		sci := sci
+		if sci.Channel == nil || sci.SettlingAt >= epoch {
+			continue
+		}
+		if err := p.collectAt(ctx, &sci, epoch); err != nil {
+			log.Error().Err(err).Str("channel", sci.Channel.String()).Msg("failed to collect channel")
+			continue
+		}
+		log.Info().Str("channel", sci.Channel.String()).Msg("collected payment channel")
+	}
+	return nil
+}
+
+// watchSettlements checks the chain state of the channels we are not settling to detect when the counterparty
+// calls Settle. The channel is then marked as settling so it gets collected. For inbound channels we first submit
+// our best vouchers before the settlement window closes otherwise the funds we earned go back to the sender.
+func (p *Payments) watchSettlements(ctx context.Context) error {
+	chans, err := p.store.findChans(func(ci *ChannelInfo) bool {
+		if ci.Channel == nil {
+			return false
+		}
+		// Channels we collected keep their settlement epoch. Vouchers which failed to submit while settling
+		// i.e. because gas was too expensive are retried.
+		if ci.Settling {
+			return ci.Direction == DirInbound && ci.unsubmitted().GreaterThan(big.Zero())
+		}
+		return ci.SettlingAt == 0
+	}, 0)
+	if err != nil {
+		return err
+	}
+	for _, ci := range chans {
+		addr := *ci.Channel
+		ch, err := p.channelByFromTo(ci.Control, ci.Target)
+		if err != nil {
+			log.Error().Err(err).Str("channel", addr.String()).Msg("failed to load channel")
+			continue
+		}
+		ep := ci.SettlingAt
+		if !ci.Settling {
+			state, err := ch.loadActorState(addr)
 			if err != nil {
-				return err
+				log.Error().Err(err).Str("channel", addr.String()).Msg("failed to load channel state")
+				continue
 			}
-			mcid, err := ch.collect(ctx, *sci.Channel)
-			if err != nil {
-				return err
+			ep, err = state.SettlingAt()
+			if err != nil || ep == 0 {
+				continue
 			}
-			lookup, err := p.api.StateWaitMsg(ctx, mcid, uint64(5))
-			if err != nil {
-				return fmt.Errorf("waiting to collect channel %s: %v", sci.Channel, err)
+			if ci.Direction != DirInbound {
+				p.markSettling(ch, ci, ep)
+				continue
 			}
-			if lookup.Receipt.ExitCode != 0 {
-				return fmt.Errorf("collecting channel %s failed with code %d", sci.Channel, lookup.Receipt.ExitCode)
+		}
+		// Inbound channels are only marked as settling once all our vouchers are on chain so the ones
+		// which failed are submitted again at the next round
+		if !p.startSubmitting(addr) {
+			continue
+		}
+		go func(ci ChannelInfo, ep abi.ChainEpoch) {
+			defer p.doneSubmitting(addr)
+			err := p.SubmitAllVouchers(ctx, addr)
+			if err != nil && !errors.Is(err, ErrNoVouchers) {
+				log.Error().Err(err).Str("channel", addr.String()).Msg("failed to submit vouchers before settlement")
+				return
 			}
-			if lookup.Receipt.ExitCode == 0 {
-				ch.mutateChannelInfo(sci.ChannelID, func(ci *ChannelInfo) {
-					ci.Settling = false
-				})
+			log.Info().Str("channel", addr.String()).Msg("submitted vouchers before settlement")
+			if !ci.Settling {
+				p.markSettling(ch, ci, ep)
 			}
-
-		}
+		}(ci, ep)
 	}
 	return nil
 }
 
+// markSettling records the epoch after which a channel the counterparty settled can be collected
+func (p *Payments) markSettling(ch *channel, ci ChannelInfo, ep abi.ChainEpoch) {
+	ch.mutateChannelInfo(ci.ChannelID, func(ci *ChannelInfo) {
+		ci.Settling = true
+		ci.SettlingAt = ep
+	})
+	log.Warn().Str("channel", ci.Channel.String()).Int64("epoch", int64(ep)).Msg("counterparty is settling payment channel")
+	p.publish(EventSettled, EventState{
+		Channel:    *ci.Channel,
+		Direction:  ci.Direction,
+		SettlingAt: ep,
+	})
+}
+
+// startSubmitting returns false if we are already submitting the vouchers of a settling channel
+func (p *Payments) startSubmitting(addr address.Address) bool {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	if _, ok := p.submitting[addr]; ok {
+		return false
+	}
+	p.submitting[addr] = struct{}{}
+	return true
+}
+
+func (p *Payments) doneSubmitting(addr address.Address) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	delete(p.submitting, addr)
+}
+
 // CheckVoucherSpendable checks if the given voucher is currently spendable
 func (p *Payments) CheckVoucherSpendable(ctx context.Context, addr address.Address, sv *paych.SignedVoucher, secret []byte, proof []byte) (bool, error) {
 	// Voucher can take proofs with a secret allowing to send vouchers securely without giving authorization
@@ -488,6 +1029,9 @@ func (p *Payments) loadStateChannelInfo(chAddr address.Address, dir uint64) (*Ch
 		store:        p.store,
 		lk:           &multiLock{globalLock: &p.lk},
 		msgListeners: newMsgListeners(),
+		states:       p.states,
+		subscribers:  p.subscribers,
+		reorgs:       p.reorgs,
 	}
 	as, err := ch.loadActorState(chAddr)
 	if err != nil {
@@ -589,6 +1133,12 @@ func (p *Payments) addChannelToCache(from address.Address, to address.Address) *
 		store:        p.store,
 		lk:           &multiLock{globalLock: &p.lk},
 		msgListeners: newMsgListeners(),
+		leases:       make(map[laneKey]filecoin.BigInt),
+		gas:          p.gas,
+		nonces:       p.nonces,
+		states:       p.states,
+		subscribers:  p.subscribers,
+		reorgs:       p.reorgs,
 	}
 	// TODO: Use LRU
 	p.channels[key] = ch
@@ -1,7 +1,10 @@
 package payments
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,6 +23,8 @@ import (
 	fil "github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/internal/testutil"
 	"github.com/myelnet/pop/wallet"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,6 +57,17 @@ func TestAddFunds(t *testing.T) {
 
 	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)})
 
+	var evtMu sync.Mutex
+	var events []Event
+	var amounts []int64
+	unsub := mgr.SubscribeToEvents(func(event Event, state EventState) {
+		evtMu.Lock()
+		events = append(events, event)
+		amounts = append(amounts, state.Amount.Int64())
+		evtMu.Unlock()
+	})
+	defer unsub()
+
 	act := &fil.Actor{
 		Code:    blockGen.Next().Cid(),
 		Head:    blockGen.Next().Cid(),
@@ -126,6 +142,97 @@ func TestAddFunds(t *testing.T) {
 		t.Error("Timeout")
 	case <-done:
 	}
+
+	evtMu.Lock()
+	defer evtMu.Unlock()
+	require.Equal(t, []Event{EventChannelCreated, EventFundsAdded}, events)
+	require.Equal(t, []int64{10, 5}, amounts)
+}
+
+// syncBuffer lets us read the logs written from the channel goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestMsgFailed(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	var logs syncBuffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	api := fil.NewMockLotusAPI()
+
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(api))
+
+	addr1, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	addr2, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	api.SetActor(&fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   1,
+		Balance: fil.NewInt(1000),
+	})
+
+	mgr := New(bgCtx, api, w, dssync.MutexWrap(ds.NewMapDatastore()), &mockBlocks{make(map[cid.Cid]block.Block)})
+
+	failed := make(chan EventState, 1)
+	unsub := mgr.SubscribeToEvents(func(event Event, state EventState) {
+		if event == EventMsgFailed {
+			failed <- state
+		}
+	})
+	defer unsub()
+
+	res, err := mgr.GetChannel(ctx, addr1, addr2, big.NewInt(10))
+	require.NoError(t, err)
+
+	ci, err := mgr.store.ByMessageCid(res.WaitSentinel)
+	require.NoError(t, err)
+
+	// Exit code 7 means the message ran out of gas
+	lookup := testutil.FormatMsgLookup(t, tutils.NewIDAddr(t, 101))
+	lookup.Receipt.ExitCode = 7
+	api.SetMsgLookup(lookup)
+
+	_, err = mgr.WaitForChannel(ctx, res.WaitSentinel)
+	require.Error(t, err)
+
+	select {
+	case state := <-failed:
+		require.Equal(t, res.WaitSentinel, state.MsgCid)
+		require.Equal(t, uint64(DirOutbound), state.Direction)
+		require.Error(t, state.Err)
+	case <-ctx.Done():
+		t.Fatal("no failure event")
+	}
+
+	// The failure is logged with the channel and message it is about
+	out := logs.String()
+	require.Contains(t, out, `"channelID":"`+ci.ChannelID+`"`)
+	require.Contains(t, out, `"mcid":"`+res.WaitSentinel.String()+`"`)
+	require.Contains(t, out, "channel creation failed")
 }
 
 // TestPaychAddVoucherAfterAddFunds tests adding a voucher to a channel with
@@ -255,6 +362,43 @@ func TestPaychAddVoucherAfterAddFunds(t *testing.T) {
 	vouchRes, err = mgr.CreateVoucher(ctx, chAddr, excessAmt, 2)
 	require.NoError(t, err)
 	require.NotNil(t, vouchRes.Voucher)
+	excessVoucher := vouchRes.Voucher
+
+	lanes, err := mgr.store.Lanes(chAddr)
+	require.NoError(t, err)
+	require.Len(t, lanes, 2)
+	require.Equal(t, uint64(1), lanes[0].Lane)
+	require.True(t, createAmt.Equals(lanes[0].Redeemed))
+	require.Equal(t, uint64(2), lanes[1].Lane)
+	require.True(t, excessAmt.Equals(lanes[1].Redeemed))
+
+	// Lanes are handed out to sessions lowest first
+	lane, err := mgr.AcquireLane(ctx, chAddr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lane)
+	lane, err = mgr.AcquireLane(ctx, chAddr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), lane)
+
+	// A released lane is reused by the next session
+	require.NoError(t, mgr.ReleaseLane(ctx, chAddr, lane))
+	lane, err = mgr.AcquireLane(ctx, chAddr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), lane)
+
+	// The session amount is added on top of the amount already redeemed in the lane
+	// so the voucher is valid but exceeds the channel balance
+	vouchRes, err = mgr.CreateVoucher(ctx, chAddr, fil.NewInt(2), lane)
+	require.NoError(t, err)
+	require.Nil(t, vouchRes.Voucher)
+	require.Equal(t, fil.NewInt(2), vouchRes.Shortfall)
+
+	// A voucher we could not deliver is revoked so its lane doesn't account for it anymore
+	require.NoError(t, mgr.RevokeVoucher(ctx, chAddr, excessVoucher))
+	lanes, err = mgr.store.Lanes(chAddr)
+	require.NoError(t, err)
+	require.Len(t, lanes, 1)
+	require.ErrorIs(t, mgr.RevokeVoucher(ctx, chAddr, excessVoucher), ErrVoucherNotRevocable)
 }
 
 // TestBestSpendable is on the payee side to test the process of receiving and storing vouchers
@@ -361,6 +505,18 @@ func TestBestSpendable(t *testing.T) {
 	_, err = mgr.AddVoucherInbound(ctx, chAddr, svL2V1, nil, minDelta)
 	require.NoError(t, err)
 
+	// Inspecting the channel returns the latest voucher of each lane
+	sum, err := mgr.InspectChannel(ctx, chAddr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(DirInbound), sum.Info.Direction)
+	require.Len(t, sum.Info.Vouchers, 4)
+	require.Len(t, sum.Lanes, 2)
+	require.Equal(t, uint64(1), sum.Lanes[0].Lane)
+	require.Equal(t, uint64(3), sum.Lanes[0].Nonce)
+	require.EqualValues(t, 3, sum.Lanes[0].Redeemed.Int64())
+	require.Equal(t, uint64(2), sum.Lanes[1].Lane)
+	require.EqualValues(t, 2, sum.Lanes[1].Redeemed.Int64())
+
 	// Return success exit code from calls to check if voucher is spendable
 	api.SetInvocResult(&fil.InvocResult{
 		MsgRct: &fil.MessageReceipt{
@@ -515,6 +671,9 @@ func TestCollectChannel(t *testing.T) {
 		},
 	})
 
+	// Channels cannot be collected before they are settled
+	require.ErrorIs(t, mgr.Collect(ctx, chAddr), ErrChannelNotSettling)
+
 	go func() {
 		require.NoError(t, mgr.SubmitAllVouchers(ctx, chAddr))
 	}()
@@ -567,6 +726,174 @@ func TestCollectChannel(t *testing.T) {
 	settling, err = mgr.store.ListSettlingChannels()
 	require.NoError(t, err)
 	require.Equal(t, 0, len(settling))
+
+	ci, err := mgr.store.ByAddress(chAddr)
+	require.NoError(t, err)
+	require.True(t, ci.Collected)
+}
+
+// TestWatchSettlements is on the payee side when the payer settles the channel without telling us
+func TestWatchSettlements(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+
+	ks := keystore.NewMemKeystore()
+
+	w := wallet.NewFromKeystore(ks, wallet.WithFilAPI(api))
+
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+	to, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	payerAddr := tutils.NewIDAddr(t, 102)
+	payeeAddr := tutils.NewIDAddr(t, 103)
+
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+
+	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)})
+
+	createAmt := big.NewInt(20)
+	act := &fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   0,
+		Balance: createAmt,
+	}
+	api.SetActor(act)
+	chAddr := tutils.NewIDAddr(t, 101)
+
+	initActorAddr := tutils.NewIDAddr(t, 100)
+	hasher := func(data []byte) [32]byte { return [32]byte{} }
+
+	builder := mock.NewBuilder(chAddr).
+		WithBalance(createAmt, abi.NewTokenAmount(0)).
+		WithEpoch(abi.ChainEpoch(1)).
+		WithCaller(initActorAddr, builtin.InitActorCodeID).
+		WithActorType(payeeAddr, builtin.AccountActorCodeID).
+		WithActorType(payerAddr, builtin.AccountActorCodeID).
+		WithHasher(hasher)
+
+	// builder our actor runtime
+	rt := builder.Build(t)
+	params := &paych.ConstructorParams{To: payeeAddr, From: payerAddr}
+	rt.ExpectValidateCallerType(builtin.InitActorCodeID)
+	actor := paych.Actor{}
+	rt.Call(actor.Constructor, params)
+
+	var st paych.State
+	rt.GetState(&st)
+
+	actState := fil.ActorState{
+		Balance: createAmt,
+		State:   st,
+	}
+	// add our actor state to the api so it's queryable
+	api.SetActorState(&actState)
+	// object reader to send a serialized object
+	objReader := func(c cid.Cid) []byte {
+		var bg testutil.BytesGetter
+		rt.StoreGet(c, &bg)
+		return bg.Bytes()
+	}
+	api.SetObjectReader(objReader)
+
+	api.SetAccountKey(payerAddr, from)
+	api.SetAccountKey(payeeAddr, to)
+
+	// Add vouchers to lane 1 with amounts: [1, 2, 3]
+	voucherLane := uint64(1)
+	minDelta := big.NewInt(0)
+	nonce := uint64(1)
+	voucherAmount := big.NewInt(1)
+	svL1V1 := createTestVoucher(t, chAddr, voucherLane, nonce, voucherAmount, from, w)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, svL1V1, nil, minDelta)
+	require.NoError(t, err)
+
+	nonce++
+	voucherAmount = big.NewInt(2)
+	svL1V2 := createTestVoucher(t, chAddr, voucherLane, nonce, voucherAmount, from, w)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, svL1V2, nil, minDelta)
+	require.NoError(t, err)
+
+	nonce++
+	voucherAmount = big.NewInt(3)
+	svL1V3 := createTestVoucher(t, chAddr, voucherLane, nonce, voucherAmount, from, w)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, svL1V3, nil, minDelta)
+	require.NoError(t, err)
+
+	// Add voucher to lane 2 with amounts: [2]
+	voucherLane = uint64(2)
+	nonce = uint64(1)
+	voucherAmount = big.NewInt(2)
+	svL2V1 := createTestVoucher(t, chAddr, voucherLane, nonce, voucherAmount, from, w)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, svL2V1, nil, minDelta)
+	require.NoError(t, err)
+
+	// Return success exit code from calls to check if voucher is spendable
+	api.SetInvocResult(&fil.InvocResult{
+		MsgRct: &fil.MessageReceipt{
+			ExitCode: 0,
+		},
+	})
+
+	// Nothing happens until the payer settles the channel
+	require.NoError(t, mgr.watchSettlements(ctx))
+	settling, err := mgr.store.ListSettlingChannels()
+	require.NoError(t, err)
+	require.Len(t, settling, 0)
+
+	// Vouchers are not submitted before the checkpoint amount is reached
+	mgr.checkpointAmount = big.NewInt(10)
+	require.NoError(t, mgr.Checkpoint(ctx, chAddr))
+	ci, err := mgr.store.ByAddress(chAddr)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, ci.unsubmitted().Int64())
+
+	ep := abi.ChainEpoch(10)
+	rt.SetEpoch(ep)
+
+	rt.GetState(&st)
+	rt.SetCaller(st.From, builtin.AccountActorCodeID)
+	rt.ExpectValidateCallerAddr(st.From, st.To)
+	rt.Call(actor.Settle, nil)
+
+	rt.GetState(&st)
+	actState = fil.ActorState{
+		Balance: createAmt,
+		State:   st,
+	}
+	api.SetActorState(&actState)
+
+	require.NoError(t, mgr.watchSettlements(ctx))
+
+	// Our best vouchers for each lane are submitted before the channel is marked as settling
+	lookup := testutil.FormatMsgLookup(t, chAddr)
+	for i := 0; i < 2; i++ {
+		api.SetMsgLookup(lookup)
+	}
+	require.Eventually(t, func() bool {
+		settling, err = mgr.store.ListSettlingChannels()
+		require.NoError(t, err)
+		return len(settling) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, st.SettlingAt, settling[0].SettlingAt)
+
+	vouchers, err := mgr.ListVouchers(ctx, chAddr)
+	require.NoError(t, err)
+	for _, v := range vouchers {
+		require.True(t, v.Submitted)
+	}
+	ci, err = mgr.store.ByAddress(chAddr)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, ci.unsubmitted().Int64())
+
+	// The channel is already known to be settling so we don't submit anything again
+	require.NoError(t, mgr.watchSettlements(ctx))
 }
 
 func createTestVoucher(t *testing.T, ch address.Address, voucherLane uint64, nonce uint64, voucherAmount big.Int, addr address.Address, w wallet.Driver) *paych.SignedVoucher {
@@ -584,3 +911,159 @@ func createTestVoucher(t *testing.T, ch address.Address, voucherLane uint64, non
 	sv.Signature = sig
 	return sv
 }
+
+func TestReleaseFunds(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(api))
+
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+	to, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+
+	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)})
+
+	ch, err := mgr.channelByFromTo(from, to)
+	require.NoError(t, err)
+
+	// Sessions cannot count on funds already held by another session
+	require.True(t, ch.reserveAvailable(fil.NewInt(10), fil.NewInt(6)))
+	require.False(t, ch.reserveAvailable(fil.NewInt(10), fil.NewInt(6)))
+
+	// Once the first session fails its funds are available again
+	require.NoError(t, mgr.ReleaseFunds(ctx, from, to, fil.NewInt(6)))
+	require.True(t, ch.reserveAvailable(fil.NewInt(10), fil.NewInt(6)))
+	require.NoError(t, mgr.ReleaseFunds(ctx, from, to, fil.NewInt(6)))
+	require.Equal(t, int64(0), ch.reservedAmt().Int64())
+
+	// An add funds message which failed doesn't block the channel after the session is released
+	chAddr := tutils.NewIDAddr(t, 101)
+	createMsg := blockGen.Next().Cid()
+	ci, err := mgr.store.CreateChannel(from, to, createMsg, fil.NewInt(0))
+	require.NoError(t, err)
+	addMsg := blockGen.Next().Cid()
+	ci.Channel = &chAddr
+	ci.CreateMsg = nil
+	ci.Amount = fil.NewInt(10)
+	ci.PendingAmount = fil.NewInt(6)
+	ci.AddFundsMsg = &addMsg
+	require.NoError(t, mgr.store.putChannelInfo(ci))
+	require.NoError(t, mgr.store.SaveNewMessage(ci.ChannelID, addMsg))
+	require.NoError(t, mgr.store.SaveMessageResult(addMsg, errors.New("message not found")))
+
+	require.NoError(t, mgr.ReleaseFunds(ctx, from, to, fil.NewInt(6)))
+
+	ci, err = mgr.store.ByChannelID(ci.ChannelID)
+	require.NoError(t, err)
+	require.Nil(t, ci.AddFundsMsg)
+	require.Equal(t, fil.NewInt(0), ci.PendingAmount)
+	require.Equal(t, fil.NewInt(10), ci.Amount)
+}
+
+func TestRestartPending(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(api))
+
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+	to, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// A create message was sent before the node shut down
+	createMsg := blockGen.Next().Cid()
+	_, err = NewStore(ds).CreateChannel(from, to, createMsg, fil.NewInt(10))
+	require.NoError(t, err)
+
+	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)})
+
+	// The manager waits for it again and unblocks the channel once it lands
+	chAddr := tutils.NewIDAddr(t, 101)
+	api.SetMsgLookup(testutil.FormatMsgLookup(t, chAddr))
+
+	addr, err := mgr.WaitForChannel(ctx, createMsg)
+	require.NoError(t, err)
+	require.Equal(t, chAddr, addr)
+
+	ci, err := mgr.GetChannelInfo(chAddr)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, ci.Amount.Int64())
+	require.Nil(t, ci.CreateMsg)
+}
+
+func TestReusePendingChannel(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(api))
+
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+	to, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+
+	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)})
+
+	api.SetActor(&fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   1,
+		Balance: fil.NewInt(1000),
+	})
+
+	res1, err := mgr.GetChannel(ctx, from, to, big.NewInt(10))
+	require.NoError(t, err)
+	require.Equal(t, address.Undef, res1.Channel)
+
+	// The first session failed before the channel was created
+	require.NoError(t, mgr.ReleaseFunds(ctx, from, to, big.NewInt(10)))
+
+	// The next session waits for the same channel instead of sending another message
+	res2, err := mgr.GetChannel(ctx, from, to, big.NewInt(4))
+	require.NoError(t, err)
+	require.Equal(t, res1.WaitSentinel, res2.WaitSentinel)
+
+	// Remaining pending funds don't cover this one so it waits for the channel to add funds
+	done := make(chan *ChannelResponse)
+	go func() {
+		res3, err := mgr.GetChannel(ctx, from, to, big.NewInt(8))
+		require.NoError(t, err)
+		done <- res3
+	}()
+
+	chAddr := tutils.NewIDAddr(t, 101)
+	api.SetMsgLookup(testutil.FormatMsgLookup(t, chAddr))
+
+	addr, err := mgr.WaitForChannel(ctx, res2.WaitSentinel)
+	require.NoError(t, err)
+	require.Equal(t, chAddr, addr)
+
+	select {
+	case res3 := <-done:
+		require.Equal(t, chAddr, res3.Channel)
+		require.NotEqual(t, res1.WaitSentinel, res3.WaitSentinel)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
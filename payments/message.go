@@ -6,6 +6,7 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
 	init2 "github.com/filecoin-project/specs-actors/v4/actors/builtin/init"
@@ -92,3 +93,89 @@ func SerializeParams(i cbg.CBORMarshaler) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// MsgKind identifies the payment channel operation a message executes
+type MsgKind string
+
+const (
+	// MsgCreate creates a new payment channel
+	MsgCreate MsgKind = "create"
+	// MsgAddFunds adds funds to an existing channel
+	MsgAddFunds MsgKind = "addfunds"
+	// MsgUpdate submits a voucher
+	MsgUpdate MsgKind = "update"
+	// MsgSettle starts settling a channel
+	MsgSettle MsgKind = "settle"
+	// MsgCollect collects a channel after it settled
+	MsgCollect MsgKind = "collect"
+)
+
+// ErrGasTooExpensive is returned when the fee of a message exceeds the max fee of our gas policy.
+// The operation can be retried later when the network is less congested.
+var ErrGasTooExpensive = fmt.Errorf("gas too expensive")
+
+// GasSpec sets the fees of a message. Fields left empty are estimated by the Filecoin node.
+type GasSpec struct {
+	// FeeCap is the maximum price per unit of gas
+	FeeCap abi.TokenAmount
+	// Premium is the price per unit of gas paid to the miner including the message
+	Premium abi.TokenAmount
+	// MaxFee lowers the estimated fee cap so the message never costs more than this amount
+	MaxFee abi.TokenAmount
+}
+
+// GasPolicy configures how much we are willing to pay for payment channel messages
+type GasPolicy struct {
+	// Default applies to all messages without a spec for their kind
+	Default GasSpec
+	// Messages overrides the default spec for each kind of message
+	Messages map[MsgKind]GasSpec
+	// MaxGas is the maximum fee we pay for any message once gas is estimated. Messages costing
+	// more are refused with ErrGasTooExpensive.
+	MaxGas abi.TokenAmount
+}
+
+// spec returns the gas spec for the given kind of message
+func (gp GasPolicy) spec(kind MsgKind) GasSpec {
+	if s, ok := gp.Messages[kind]; ok {
+		return s
+	}
+	return gp.Default
+}
+
+// check returns ErrGasTooExpensive if the estimated message fee exceeds the policy max gas
+func (gp GasPolicy) check(msg *fil.Message) error {
+	if gp.MaxGas.Nil() || gp.MaxGas.IsZero() || msg.GasFeeCap.Nil() {
+		return nil
+	}
+	fee := big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit))
+	if fee.GreaterThan(gp.MaxGas) {
+		return fmt.Errorf("%w: %s message would cost up to %s, max is %s", ErrGasTooExpensive, msgKind(msg), fee, gp.MaxGas)
+	}
+	return nil
+}
+
+// msgKind returns the payment channel operation executed by a message
+func msgKind(msg *fil.Message) MsgKind {
+	if msg.To == builtin.InitActorAddr {
+		return MsgCreate
+	}
+	switch msg.Method {
+	case builtin.MethodSend:
+		return MsgAddFunds
+	case builtin.MethodsPaych.UpdateChannelState:
+		return MsgUpdate
+	case builtin.MethodsPaych.Settle:
+		return MsgSettle
+	case builtin.MethodsPaych.Collect:
+		return MsgCollect
+	}
+	return MsgKind(fmt.Sprintf("method %d", msg.Method))
+}
+
+func (gs GasSpec) sendSpec() *fil.MessageSendSpec {
+	if gs.MaxFee.Nil() || gs.MaxFee.IsZero() {
+		return nil
+	}
+	return &fil.MessageSendSpec{MaxFee: gs.MaxFee}
+}
@@ -0,0 +1,129 @@
+package payments
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/wallet"
+)
+
+// ResubmitTimeout is how long a message can wait in the message pool before we bump its fees and push it again
+const ResubmitTimeout = 10 * builtin.EpochDurationSeconds * time.Second
+
+// replaceFeeRatio is the percentage a replacement message must increase the premium by to be accepted by the pool
+var replaceFeeRatio = big.NewInt(125)
+
+// pendingMsg is a message we pushed to the pool which isn't included on chain yet
+type pendingMsg struct {
+	msg      filecoin.Message
+	pushedAt time.Time
+}
+
+// nonceTracker assigns nonces to the messages we send with the tracker of our wallet so they don't collide
+// with other messages from the same address and keeps track of the messages waiting to be included on chain
+type nonceTracker struct {
+	nonces *wallet.NonceTracker
+
+	mu      sync.Mutex
+	pending map[cid.Cid]*pendingMsg
+}
+
+// newNonceTracker creates a tracker assigning nonces with the given wallet tracker or with its own if nil
+func newNonceTracker(nonces *wallet.NonceTracker) *nonceTracker {
+	if nonces == nil {
+		nonces = wallet.NewNonceTracker()
+	}
+	return &nonceTracker{
+		nonces:  nonces,
+		pending: make(map[cid.Cid]*pendingMsg),
+	}
+}
+
+// assign sets the nonce of a message to the next nonce available for its sender
+func (nt *nonceTracker) assign(ctx context.Context, api filecoin.API, msg *filecoin.Message) error {
+	return nt.nonces.Assign(ctx, api, msg)
+}
+
+// release gives back the nonce of a message which failed to push so the next message reuses it
+func (nt *nonceTracker) release(msg *filecoin.Message) {
+	nt.nonces.Release(msg)
+}
+
+// track records a message we pushed to the pool, replacing the previous version of the message if any
+func (nt *nonceTracker) track(smsg *filecoin.SignedMessage, replaced cid.Cid) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	if replaced != cid.Undef {
+		delete(nt.pending, replaced)
+	}
+	nt.pending[smsg.Cid()] = &pendingMsg{
+		msg:      smsg.Message,
+		pushedAt: time.Now(),
+	}
+}
+
+// stuck returns the messages pending for longer than the timeout and forgets the ones whose nonce
+// was used on chain already
+func (nt *nonceTracker) stuck(ctx context.Context, api filecoin.API, now time.Time) map[cid.Cid]filecoin.Message {
+	nt.mu.Lock()
+	pending := make(map[cid.Cid]pendingMsg, len(nt.pending))
+	for c, pm := range nt.pending {
+		pending[c] = *pm
+	}
+	nt.mu.Unlock()
+
+	nonces := make(map[address.Address]uint64)
+	stuck := make(map[cid.Cid]filecoin.Message)
+	for c, pm := range pending {
+		nonce, ok := nonces[pm.msg.From]
+		if !ok {
+			act, err := api.StateGetActor(ctx, pm.msg.From, filecoin.EmptyTSK)
+			if err != nil {
+				continue
+			}
+			nonce = act.Nonce
+			nonces[pm.msg.From] = nonce
+		}
+		if nonce > pm.msg.Nonce {
+			nt.mu.Lock()
+			delete(nt.pending, c)
+			nt.mu.Unlock()
+			continue
+		}
+		if now.Sub(pm.pushedAt) >= ResubmitTimeout {
+			stuck[c] = pm.msg
+		}
+	}
+	return stuck
+}
+
+// bumpFees increases the premium and fee cap of a message enough for the pool to replace it
+func bumpFees(msg *filecoin.Message) {
+	if msg.GasPremium.Nil() {
+		msg.GasPremium = big.Zero()
+	}
+	if msg.GasFeeCap.Nil() {
+		msg.GasFeeCap = big.Zero()
+	}
+	premium := big.Div(big.Mul(msg.GasPremium, replaceFeeRatio), big.NewInt(100))
+	if !premium.GreaterThan(msg.GasPremium) {
+		premium = big.Add(msg.GasPremium, big.NewInt(1))
+	}
+	msg.GasPremium = premium
+	msg.GasFeeCap = big.Div(big.Mul(msg.GasFeeCap, replaceFeeRatio), big.NewInt(100))
+	if msg.GasFeeCap.LessThan(msg.GasPremium) {
+		msg.GasFeeCap = msg.GasPremium
+	}
+}
+
+// signMessage signs a message with the key of its sender
+func signMessage(ctx context.Context, w wallet.Driver, msg *filecoin.Message) (*filecoin.SignedMessage, error) {
+	return wallet.SignMessage(ctx, w, msg)
+}
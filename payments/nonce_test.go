@@ -0,0 +1,87 @@
+package payments
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
+	"github.com/ipfs/go-cid"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonceTracker(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	api.SetActor(&fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   3,
+		Balance: big.NewInt(10),
+	})
+
+	w := wallet.NewFromKeystore(keystore.NewMemKeystore(), wallet.WithFilAPI(api))
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	newMsg := func() *fil.Message {
+		return &fil.Message{
+			From:       from,
+			To:         tutils.NewIDAddr(t, 101),
+			Value:      big.Zero(),
+			GasLimit:   1000,
+			GasFeeCap:  abi.NewTokenAmount(100),
+			GasPremium: abi.NewTokenAmount(10),
+		}
+	}
+
+	nt := newNonceTracker(nil)
+
+	// Concurrent messages get consecutive nonces even if the actor nonce hasn't changed yet
+	var msgs []*fil.Message
+	for i := 0; i < 3; i++ {
+		msg := newMsg()
+		require.NoError(t, nt.assign(ctx, api, msg))
+		require.Equal(t, uint64(3+i), msg.Nonce)
+		msgs = append(msgs, msg)
+	}
+
+	// A message failing to push gives its nonce back
+	nt.release(msgs[2])
+	msg := newMsg()
+	require.NoError(t, nt.assign(ctx, api, msg))
+	require.Equal(t, uint64(5), msg.Nonce)
+
+	for _, m := range msgs[:2] {
+		smsg, err := signMessage(ctx, w, m)
+		require.NoError(t, err)
+		nt.track(smsg, cid.Undef)
+	}
+
+	now := time.Now()
+	require.Len(t, nt.stuck(ctx, api, now), 0)
+	require.Len(t, nt.stuck(ctx, api, now.Add(ResubmitTimeout)), 2)
+
+	// Once the first message is included on chain we stop tracking it
+	api.SetActor(&fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   4,
+		Balance: big.NewInt(10),
+	})
+	stuck := nt.stuck(ctx, api, now.Add(ResubmitTimeout))
+	require.Len(t, stuck, 1)
+	for _, m := range stuck {
+		require.Equal(t, uint64(4), m.Nonce)
+
+		bumpFees(&m)
+		require.EqualValues(t, 12, m.GasPremium.Int64())
+		require.EqualValues(t, 125, m.GasFeeCap.Int64())
+	}
+}
@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// ReorgFinality is how many epochs after its inclusion we keep checking a confirmed message wasn't
+// reverted by a reorg. Past this depth the chain considers the tipset final.
+const ReorgFinality = abi.ChainEpoch(900)
+
+// confirmedMsg is a create or add funds message we applied to the local channel state once confirmed
+type confirmedMsg struct {
+	channelID string
+	kind      MsgKind
+	// amount is the value the message added to the channel
+	amount filecoin.BigInt
+	tipset filecoin.TipSetKey
+	height abi.ChainEpoch
+}
+
+// reorgTracker keeps the messages confirmed in a tipset which isn't final yet so we can detect
+// when a reorg reverts them. Tracked messages are only kept in memory, the ones confirmed before
+// a restart are not checked again.
+type reorgTracker struct {
+	mu        sync.Mutex
+	confirmed map[cid.Cid]confirmedMsg
+}
+
+func newReorgTracker() *reorgTracker {
+	return &reorgTracker{
+		confirmed: make(map[cid.Cid]confirmedMsg),
+	}
+}
+
+// track starts watching a message confirmed at the given lookup
+func (rt *reorgTracker) track(mcid cid.Cid, cm confirmedMsg) {
+	if rt == nil {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.confirmed[mcid] = cm
+}
+
+// reverted searches the confirmed messages on chain and returns the ones which are no longer
+// included or which failed when executed again in a different tipset. Messages deeper than
+// ReorgFinality are forgotten. A light client only searches the last filecoin.LightLookback epochs
+// so a message we cannot find past that depth isn't considered reverted.
+func (rt *reorgTracker) reverted(ctx context.Context, api filecoin.API, head abi.ChainEpoch) map[cid.Cid]confirmedMsg {
+	if rt == nil {
+		return nil
+	}
+	rt.mu.Lock()
+	confirmed := make(map[cid.Cid]confirmedMsg, len(rt.confirmed))
+	for c, cm := range rt.confirmed {
+		confirmed[c] = cm
+	}
+	rt.mu.Unlock()
+
+	reverted := make(map[cid.Cid]confirmedMsg)
+	for c, cm := range confirmed {
+		if head-cm.height >= ReorgFinality {
+			rt.forget(c)
+			continue
+		}
+		lookup, err := api.StateSearchMsg(ctx, c)
+		if err != nil {
+			// we'll try again at the next epoch
+			continue
+		}
+		if lookup == nil && head-cm.height >= filecoin.LightLookback {
+			continue
+		}
+		if lookup == nil || lookup.Receipt.ExitCode != 0 {
+			rt.forget(c)
+			reverted[c] = cm
+			continue
+		}
+		if lookup.TipSet != cm.tipset {
+			// The message was included again in a different tipset so we keep watching it from there
+			cm.tipset = lookup.TipSet
+			cm.height = lookup.Height
+			rt.track(c, cm)
+		}
+	}
+	return reverted
+}
+
+func (rt *reorgTracker) forget(mcid cid.Cid) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	delete(rt.confirmed, mcid)
+}
@@ -0,0 +1,113 @@
+package payments
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReorgTracker(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	rt := newReorgTracker()
+
+	ts1 := fil.NewTipSetKey(blockGen.Next().Cid())
+	ts2 := fil.NewTipSetKey(blockGen.Next().Cid())
+
+	included := blockGen.Next().Cid()
+	moved := blockGen.Next().Cid()
+	dropped := blockGen.Next().Cid()
+	failed := blockGen.Next().Cid()
+	final := blockGen.Next().Cid()
+	old := blockGen.Next().Cid()
+
+	head := ReorgFinality + 10
+	for _, c := range []cid.Cid{included, moved, dropped, failed} {
+		rt.track(c, confirmedMsg{channelID: c.String(), amount: fil.NewInt(10), tipset: ts1, height: head - 10})
+	}
+	rt.track(final, confirmedMsg{channelID: final.String(), amount: fil.NewInt(10), tipset: ts1, height: 1})
+	// past the lookback of a light client we cannot tell if a message was reverted
+	rt.track(old, confirmedMsg{channelID: old.String(), amount: fil.NewInt(10), tipset: ts1, height: head - fil.LightLookback})
+
+	api.SetSearchResult(included, &fil.MsgLookup{Message: included, TipSet: ts1, Height: head - 10})
+	api.SetSearchResult(moved, &fil.MsgLookup{Message: moved, TipSet: ts2, Height: head - 9})
+	api.SetSearchResult(failed, &fil.MsgLookup{Message: failed, TipSet: ts2, Height: head - 9, Receipt: fil.MessageReceipt{ExitCode: 16}})
+
+	reverted := rt.reverted(ctx, api, head)
+	require.Len(t, reverted, 2)
+	require.Contains(t, reverted, dropped)
+	require.Contains(t, reverted, failed)
+
+	// The message which was included again is watched from its new tipset and the final one is forgotten
+	require.Len(t, rt.confirmed, 3)
+	require.Equal(t, ts2, rt.confirmed[moved].tipset)
+	require.Equal(t, ts1, rt.confirmed[included].tipset)
+	require.Contains(t, rt.confirmed, old)
+
+	// A nil tracker is a no-op
+	var nt *reorgTracker
+	nt.track(included, confirmedMsg{})
+	require.Len(t, nt.reverted(ctx, api, 0), 0)
+}
+
+func TestRollbackRevertedMsg(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+	store := NewStore(dssync.MutexWrap(ds.NewMapDatastore()))
+
+	from := tutils.NewIDAddr(t, 100)
+	to := tutils.NewIDAddr(t, 101)
+	chAddr := tutils.NewIDAddr(t, 102)
+	mcid := blockGen.Next().Cid()
+
+	ci, err := store.CreateChannel(from, to, blockGen.Next().Cid(), fil.NewInt(0))
+	require.NoError(t, err)
+	ci.Channel = &chAddr
+	ci.CreateMsg = nil
+	ci.Amount = fil.NewInt(30)
+	require.NoError(t, store.putChannelInfo(ci))
+
+	mgr := &testMgr{}
+	ch := &channel{
+		from:         from,
+		to:           to,
+		ctx:          ctx,
+		api:          api,
+		store:        store,
+		lk:           &multiLock{globalLock: &mgr.lk},
+		msgListeners: newMsgListeners(),
+		nonces:       newNonceTracker(nil),
+		reorgs:       newReorgTracker(),
+	}
+
+	ch.rollbackMsg(mcid, confirmedMsg{channelID: ci.ChannelID, kind: MsgAddFunds, amount: fil.NewInt(10)})
+
+	ci, err = store.ByChannelID(ci.ChannelID)
+	require.NoError(t, err)
+	require.Equal(t, fil.NewInt(20), ci.Amount)
+
+	// Once the message is included again its funds are available again
+	ts := fil.NewTipSetKey(blockGen.Next().Cid())
+	api.SetMsgLookup(&fil.MsgLookup{Message: mcid, TipSet: ts, Height: 200})
+
+	require.Eventually(t, func() bool {
+		ci, err := store.ByChannelID(ci.ChannelID)
+		return err == nil && ci.Amount.Equals(fil.NewInt(30))
+	}, time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		ch.reorgs.mu.Lock()
+		defer ch.reorgs.mu.Unlock()
+		return ch.reorgs.confirmed[mcid].tipset == ts
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,157 @@
+package payments
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// ChainHeadTTL is how long we assume the chain head hasn't changed before asking the node again
+const ChainHeadTTL = 5 * time.Second
+
+// voucherState is what we read from chain to validate the vouchers of a channel
+type voucherState struct {
+	state ChannelState
+	// from is the account key of the channel creator signing the vouchers
+	from address.Address
+}
+
+// cachedChannel is what we read from chain about a channel at the current head
+type cachedChannel struct {
+	// balance is nil until we read the channel actor
+	balance filecoin.BigInt
+	// voucher is nil until we read the channel state
+	voucher *voucherState
+}
+
+// stateCache keeps the balance and state of the channels we validate vouchers for at the current
+// tipset so providers receiving many small payments don't query the node for every voucher.
+// Entries are dropped as soon as we see a new head.
+type stateCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	head    filecoin.TipSetKey
+	headAt  time.Time
+	entries map[address.Address]*cachedChannel
+}
+
+func newStateCache() *stateCache {
+	return &stateCache{
+		now:     time.Now,
+		entries: make(map[address.Address]*cachedChannel),
+	}
+}
+
+// tipset returns the key of the current head, only asking the node once the last one expired
+func (sc *stateCache) tipset(ctx context.Context, api filecoin.API) (filecoin.TipSetKey, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.headAt.IsZero() && sc.now().Sub(sc.headAt) < ChainHeadTTL {
+		return sc.head, true
+	}
+	ts, err := api.ChainHead(ctx)
+	if err != nil || ts == nil {
+		return filecoin.EmptyTSK, false
+	}
+	if key := ts.Key(); key != sc.head {
+		sc.head = key
+		sc.entries = make(map[address.Address]*cachedChannel)
+	}
+	sc.headAt = sc.now()
+	return sc.head, true
+}
+
+// cached returns the entry of a channel at the given head, creating it if needed.
+// It returns nil if the head moved in the meantime. Must be called with the lock held.
+func (sc *stateCache) cached(tsk filecoin.TipSetKey, chAddr address.Address) *cachedChannel {
+	if sc.head != tsk {
+		return nil
+	}
+	e, ok := sc.entries[chAddr]
+	if !ok {
+		e = &cachedChannel{}
+		sc.entries[chAddr] = e
+	}
+	return e
+}
+
+// balance returns the balance of a channel actor at the current head and whether it came from the cache
+func (sc *stateCache) balance(ctx context.Context, api filecoin.API, chAddr address.Address) (filecoin.BigInt, bool, error) {
+	tsk := filecoin.EmptyTSK
+	if sc != nil {
+		var ok bool
+		if tsk, ok = sc.tipset(ctx, api); ok {
+			var bal filecoin.BigInt
+			sc.mu.Lock()
+			if e := sc.cached(tsk, chAddr); e != nil {
+				bal = e.balance
+			}
+			sc.mu.Unlock()
+			if !bal.Nil() {
+				return bal, true, nil
+			}
+		}
+	}
+	act, err := api.StateGetActor(ctx, chAddr, tsk)
+	if err != nil {
+		return filecoin.EmptyInt, false, err
+	}
+	if sc != nil {
+		sc.mu.Lock()
+		if e := sc.cached(tsk, chAddr); e != nil {
+			e.balance = act.Balance
+		}
+		sc.mu.Unlock()
+	}
+	return act.Balance, false, nil
+}
+
+// voucherState returns the state of a channel at the current head, calling load if we haven't read it yet
+func (sc *stateCache) voucherState(ctx context.Context, api filecoin.API, chAddr address.Address, load func(filecoin.TipSetKey) (voucherState, error)) (voucherState, error) {
+	if sc == nil {
+		return load(filecoin.EmptyTSK)
+	}
+	tsk, ok := sc.tipset(ctx, api)
+	if !ok {
+		return load(filecoin.EmptyTSK)
+	}
+
+	var cached *voucherState
+	sc.mu.Lock()
+	if e := sc.cached(tsk, chAddr); e != nil {
+		cached = e.voucher
+	}
+	sc.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	vs, err := load(tsk)
+	if err != nil {
+		return voucherState{}, err
+	}
+
+	sc.mu.Lock()
+	// the head may have moved while we were loading
+	if e := sc.cached(tsk, chAddr); e != nil {
+		e.voucher = &vs
+	}
+	sc.mu.Unlock()
+	return vs, nil
+}
+
+// invalidate forgets what we read about a channel and checks the head again on the next read
+// so we don't miss funds added in a tipset we haven't seen yet
+func (sc *stateCache) invalidate(chAddr address.Address) {
+	if sc == nil {
+		return
+	}
+	sc.mu.Lock()
+	delete(sc.entries, chAddr)
+	sc.headAt = time.Time{}
+	sc.mu.Unlock()
+}
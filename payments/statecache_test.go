@@ -0,0 +1,116 @@
+package payments
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateCache(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	head, err := api.ChainHead(ctx)
+	require.NoError(t, err)
+
+	chAddr := tutils.NewIDAddr(t, 100)
+	from := tutils.NewIDAddr(t, 101)
+
+	loads := 0
+	load := func(tsk fil.TipSetKey) (voucherState, error) {
+		loads++
+		require.Equal(t, head.Key(), tsk)
+		return voucherState{from: from}, nil
+	}
+
+	now := time.Now()
+	sc := newStateCache()
+	sc.now = func() time.Time { return now }
+
+	// The state is only read once per tipset
+	for i := 0; i < 3; i++ {
+		vs, err := sc.voucherState(ctx, api, chAddr, load)
+		require.NoError(t, err)
+		require.Equal(t, from, vs.from)
+	}
+	require.Equal(t, 1, loads)
+
+	// Checking the head again doesn't drop the state if it didn't change
+	now = now.Add(ChainHeadTTL)
+	_, err = sc.voucherState(ctx, api, chAddr, load)
+	require.NoError(t, err)
+	require.Equal(t, 1, loads)
+
+	// A new head invalidates the state
+	sc.head = fil.NewTipSetKey(blockGen.Next().Cid())
+	now = now.Add(ChainHeadTTL)
+	_, err = sc.voucherState(ctx, api, chAddr, load)
+	require.NoError(t, err)
+	require.Equal(t, 2, loads)
+
+	// A nil cache always reads the latest state
+	var nc *stateCache
+	_, err = nc.voucherState(ctx, api, chAddr, func(tsk fil.TipSetKey) (voucherState, error) {
+		require.Equal(t, fil.EmptyTSK, tsk)
+		return voucherState{}, nil
+	})
+	require.NoError(t, err)
+}
+
+func TestStateCacheBalance(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	setBalance := func(amt int64) {
+		api.SetActor(&fil.Actor{
+			Code:    blockGen.Next().Cid(),
+			Head:    blockGen.Next().Cid(),
+			Balance: big.NewInt(amt),
+		})
+	}
+	setBalance(10)
+
+	chAddr := tutils.NewIDAddr(t, 100)
+	now := time.Now()
+	sc := newStateCache()
+	sc.now = func() time.Time { return now }
+
+	bal, cached, err := sc.balance(ctx, api, chAddr)
+	require.NoError(t, err)
+	require.False(t, cached)
+	require.Equal(t, big.NewInt(10), bal)
+
+	// Reads at the same head don't reach the chain
+	setBalance(20)
+	bal, cached, err = sc.balance(ctx, api, chAddr)
+	require.NoError(t, err)
+	require.True(t, cached)
+	require.Equal(t, big.NewInt(10), bal)
+
+	sc.invalidate(chAddr)
+	bal, cached, err = sc.balance(ctx, api, chAddr)
+	require.NoError(t, err)
+	require.False(t, cached)
+	require.Equal(t, big.NewInt(20), bal)
+
+	// A new head invalidates the balance with the rest of the state
+	setBalance(30)
+	sc.head = fil.NewTipSetKey(blockGen.Next().Cid())
+	now = now.Add(ChainHeadTTL)
+	bal, cached, err = sc.balance(ctx, api, chAddr)
+	require.NoError(t, err)
+	require.False(t, cached)
+	require.Equal(t, big.NewInt(30), bal)
+
+	// A nil cache always reads from the chain
+	var nc *stateCache
+	bal, cached, err = nc.balance(ctx, api, chAddr)
+	require.NoError(t, err)
+	require.False(t, cached)
+	require.Equal(t, big.NewInt(30), bal)
+}
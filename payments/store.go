@@ -2,7 +2,9 @@ package payments
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
@@ -34,6 +36,15 @@ const (
 // ErrChannelNotTracked is returned when we cannot find a channel in our store
 var ErrChannelNotTracked = fmt.Errorf("channel not tracked")
 
+// ErrChannelNotSettling is returned when collecting a channel nobody settled
+var ErrChannelNotSettling = fmt.Errorf("channel not settling")
+
+// ErrChannelSettling is returned when collecting a channel before the end of its settlement window
+var ErrChannelSettling = fmt.Errorf("channel still settling")
+
+// ErrVoucherNotRevocable is returned when revoking a voucher which isn't the latest of its lane or was submitted
+var ErrVoucherNotRevocable = fmt.Errorf("voucher cannot be revoked")
+
 // Store is a datastore for persisting payment channels
 type Store struct {
 	ds datastore.Batching
@@ -164,19 +175,31 @@ func (s *Store) ByChannelID(channelID string) (*ChannelInfo, error) {
 }
 
 // OutboundActiveByFromTo looks for outbound channels that have not been
-// settled, with the given from / to addresses
+// settled or collected, with the given from / to addresses
 func (s *Store) OutboundActiveByFromTo(from address.Address, to address.Address) (*ChannelInfo, error) {
 	return s.findChan(func(ci *ChannelInfo) bool {
 		if ci.Direction != DirOutbound {
 			return false
 		}
-		if ci.Settling {
+		if ci.Settling || ci.Collected {
 			return false
 		}
 		return ci.Control == from && ci.Target == to
 	})
 }
 
+// WithPendingAddFunds is used on startup to find channels for which a
+// create channel or add funds message has been sent, but we haven't seen the
+// result yet
+func (s *Store) WithPendingAddFunds() ([]ChannelInfo, error) {
+	return s.findChans(func(ci *ChannelInfo) bool {
+		if ci.Direction != DirOutbound {
+			return false
+		}
+		return ci.CreateMsg != nil || ci.AddFundsMsg != nil
+	}, 0)
+}
+
 // ByAddress gets the channel that matches the given address
 func (s *Store) ByAddress(addr address.Address) (*ChannelInfo, error) {
 	return s.findChan(func(ci *ChannelInfo) bool {
@@ -194,6 +217,24 @@ func (s *Store) VouchersForPaych(ch address.Address) ([]*VoucherInfo, error) {
 	return ci.Vouchers, nil
 }
 
+// Lanes returns the highest nonce and redeemed amount of every lane in the given channel
+// based on the vouchers we have stored locally
+func (s *Store) Lanes(ch address.Address) ([]LaneInfo, error) {
+	ci, err := s.ByAddress(ch)
+	if err != nil {
+		return nil, err
+	}
+	lanes := ci.lanes()
+	out := make([]LaneInfo, 0, len(lanes))
+	for _, li := range lanes {
+		out = append(out, *li)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Lane < out[j].Lane
+	})
+	return out, nil
+}
+
 // findChan finds a single channel using the given filter.
 // If there isn't a channel that matches the filter, returns ErrChannelNotTracked
 func (s *Store) findChan(filter func(ci *ChannelInfo) bool) (*ChannelInfo, error) {
@@ -302,6 +343,13 @@ func (s *Store) ListChannels() ([]address.Address, error) {
 	return addrs, nil
 }
 
+// OutboundByFrom returns all the outbound channels created from the given address
+func (s *Store) OutboundByFrom(from address.Address) ([]ChannelInfo, error) {
+	return s.findChans(func(ci *ChannelInfo) bool {
+		return ci.Direction == DirOutbound && ci.Control == from
+	}, 0)
+}
+
 // ListSettlingChannels returns the addresses of all channels that need to be collected
 func (s *Store) ListSettlingChannels() ([]ChannelInfo, error) {
 	return s.findChans(func(ci *ChannelInfo) bool {
@@ -329,6 +377,61 @@ func (s *Store) RemoveChannel(channelID string) error {
 	return s.ds.Delete(dskeyForChannel(channelID))
 }
 
+// storeEntry is a raw record of the store in an export
+type storeEntry struct {
+	Key   string
+	Value []byte
+}
+
+// Export serializes all the channel infos with their vouchers and the message results of the store
+func (s *Store) Export() ([]byte, error) {
+	res, err := s.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []storeEntry
+	for {
+		res, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		entries = append(entries, storeEntry{Key: res.Key, Value: res.Value})
+	}
+	return json.Marshal(entries)
+}
+
+// Import adds the records of an export which are not in the store yet and returns how many channels were added
+func (s *Store) Import(data []byte) (int, error) {
+	var entries []storeEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("invalid export: %w", err)
+	}
+	var count int
+	for _, e := range entries {
+		k := datastore.NewKey(e.Key)
+		has, err := s.ds.Has(k)
+		if err != nil {
+			return count, err
+		}
+		// We never overwrite our own records, they may be more recent
+		if has {
+			continue
+		}
+		if err := s.ds.Put(k, e.Value); err != nil {
+			return count, err
+		}
+		if k.List()[0] == dsKeyChannelInfo {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // The datastore key used to identify the channel info
 func dskeyForChannel(channelID string) datastore.Key {
 	return datastore.KeyWithNamespaces([]string{dsKeyChannelInfo, channelID})
@@ -379,6 +482,8 @@ type ChannelInfo struct {
 	Settling bool
 	// SettlingAt is the height at which the channel can be 'collected'
 	SettlingAt abi.ChainEpoch
+	// Collected indicates the funds of the channel were sent to their owners
+	Collected bool
 }
 
 // MsgInfo stores information about a create channel / add funds message
@@ -423,6 +528,62 @@ func (ci *ChannelInfo) infoForVoucher(sv *paych.SignedVoucher) (*VoucherInfo, er
 	return nil, nil
 }
 
+// nextNonce returns the nonce following the highest nonce of the vouchers in the given lane
+func (ci *ChannelInfo) nextNonce(lane uint64) uint64 {
+	var maxnonce uint64
+	for _, v := range ci.Vouchers {
+		if v.Voucher != nil && v.Voucher.Lane == lane && v.Voucher.Nonce > maxnonce {
+			maxnonce = v.Voucher.Nonce
+		}
+	}
+	return maxnonce + 1
+}
+
+// unsubmitted returns the value of the vouchers in all lanes above the vouchers we already submitted
+func (ci *ChannelInfo) unsubmitted() fil.BigInt {
+	best := make(map[uint64]fil.BigInt)
+	submitted := make(map[uint64]fil.BigInt)
+	for _, v := range ci.Vouchers {
+		if v.Voucher == nil {
+			continue
+		}
+		lane, amt := v.Voucher.Lane, v.Voucher.Amount
+		if b, ok := best[lane]; !ok || amt.GreaterThan(b) {
+			best[lane] = amt
+		}
+		if s, ok := submitted[lane]; v.Submitted && (!ok || amt.GreaterThan(s)) {
+			submitted[lane] = amt
+		}
+	}
+	total := fil.NewInt(0)
+	for lane, amt := range best {
+		if s, ok := submitted[lane]; ok {
+			amt = fil.BigSub(amt, s)
+		}
+		total = fil.BigAdd(total, amt)
+	}
+	return total
+}
+
+// lanes returns the state of each lane according to the latest voucher stored in it
+func (ci *ChannelInfo) lanes() map[uint64]*LaneInfo {
+	lanes := make(map[uint64]*LaneInfo)
+	for _, v := range ci.Vouchers {
+		if v.Voucher == nil {
+			continue
+		}
+		if li, ok := lanes[v.Voucher.Lane]; ok && li.Nonce > v.Voucher.Nonce {
+			continue
+		}
+		lanes[v.Voucher.Lane] = &LaneInfo{
+			Lane:     v.Voucher.Lane,
+			Nonce:    v.Voucher.Nonce,
+			Redeemed: v.Voucher.Amount,
+		}
+	}
+	return lanes
+}
+
 func (ci *ChannelInfo) hasVoucher(sv *paych.SignedVoucher) (bool, error) {
 	vi, err := ci.infoForVoucher(sv)
 	return vi != nil, err
@@ -8,6 +8,7 @@ import (
 	"sort"
 
 	address "github.com/filecoin-project/go-address"
+	abi "github.com/filecoin-project/go-state-types/abi"
 	paych "github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	cid "github.com/ipfs/go-cid"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -134,7 +135,7 @@ func (t *VoucherInfo) UnmarshalCBOR(r io.Reader) error {
 	return nil
 }
 
-var lengthBufChannelInfo = []byte{140}
+var lengthBufChannelInfo = []byte{142}
 
 func (t *ChannelInfo) MarshalCBOR(w io.Writer) error {
 	if t == nil {
@@ -238,6 +239,22 @@ func (t *ChannelInfo) MarshalCBOR(w io.Writer) error {
 	if err := cbg.WriteBool(w, t.Settling); err != nil {
 		return err
 	}
+
+	// t.SettlingAt (abi.ChainEpoch) (int64)
+	if t.SettlingAt >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.SettlingAt)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.SettlingAt-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Collected (bool) (bool)
+	if err := cbg.WriteBool(w, t.Collected); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -255,9 +272,11 @@ func (t *ChannelInfo) UnmarshalCBOR(r io.Reader) error {
 		return fmt.Errorf("cbor input should be of type array")
 	}
 
-	if extra != 12 {
+	// Channels stored before we persisted SettlingAt and Collected have fewer fields
+	if extra < 12 || extra > 14 {
 		return fmt.Errorf("cbor input had wrong number of fields")
 	}
+	fields := extra
 
 	// t.ChannelID (string) (string)
 
@@ -442,6 +461,54 @@ func (t *ChannelInfo) UnmarshalCBOR(r io.Reader) error {
 	default:
 		return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
 	}
+	if fields < 13 {
+		return nil
+	}
+	// t.SettlingAt (abi.ChainEpoch) (int64)
+	{
+		maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int64
+		if err != nil {
+			return err
+		}
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return fmt.Errorf("int64 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.SettlingAt = abi.ChainEpoch(extraI)
+	}
+	if fields < 14 {
+		return nil
+	}
+	// t.Collected (bool) (bool)
+
+	maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajOther {
+		return fmt.Errorf("booleans must be major type 7")
+	}
+	switch extra {
+	case 20:
+		t.Collected = false
+	case 21:
+		t.Collected = true
+	default:
+		return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+	}
 	return nil
 }
 
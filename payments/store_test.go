@@ -1,12 +1,16 @@
 package payments
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
+	"github.com/myelnet/pop/internal/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -54,6 +58,12 @@ func TestChannelStore(t *testing.T) {
 	addrs, err = store.ListChannels()
 	require.NoError(t, err)
 	require.Len(t, addrs, 2)
+
+	// Only the channels created from the address are returned
+	cis, err := store.OutboundByFrom(tutils.NewIDAddr(t, 101))
+	require.NoError(t, err)
+	require.Len(t, cis, 1)
+	require.Equal(t, ch, *cis[0].Channel)
 	t0100, err := address.NewIDAddress(100)
 	require.NoError(t, err)
 	t0200, err := address.NewIDAddress(200)
@@ -83,4 +93,138 @@ func TestChannelStore(t *testing.T) {
 	// Allocate next lane for non-existent channel should error
 	_, err = store.AllocateLane(tutils.NewIDAddr(t, 300))
 	require.Equal(t, err, ErrChannelNotTracked)
+
+	// Vouchers are tracked per lane
+	ci, err = store.ByAddress(*ci.Channel)
+	require.NoError(t, err)
+	ci.Vouchers = []*VoucherInfo{
+		{Voucher: &paych.SignedVoucher{ChannelAddr: *ci.Channel, Lane: 0, Nonce: 1, Amount: big.NewInt(10)}},
+		{Voucher: &paych.SignedVoucher{ChannelAddr: *ci.Channel, Lane: 0, Nonce: 2, Amount: big.NewInt(10)}},
+		{Voucher: &paych.SignedVoucher{ChannelAddr: *ci.Channel, Lane: 1, Nonce: 1, Amount: big.NewInt(10)}},
+	}
+	require.NoError(t, store.putChannelInfo(ci))
+
+	ci, err = store.ByAddress(*ci.Channel)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), ci.nextNonce(0))
+	require.Equal(t, uint64(1), ci.nextNonce(2))
+}
+
+func TestExportImport(t *testing.T) {
+	src := &Payments{store: NewStore(sync.MutexWrap(ds.NewMapDatastore()))}
+
+	ch := tutils.NewIDAddr(t, 100)
+	sv := &paych.SignedVoucher{ChannelAddr: ch, Lane: 1, Nonce: 1, Amount: big.NewInt(10)}
+	_, err := src.store.TrackChannel(&ChannelInfo{
+		Channel:   &ch,
+		Control:   tutils.NewIDAddr(t, 101),
+		Target:    tutils.NewIDAddr(t, 102),
+		Direction: DirInbound,
+		Vouchers:  []*VoucherInfo{{Voucher: sv, Proof: []byte{}}},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Export(&buf, "passphrase"))
+
+	dst := &Payments{store: NewStore(sync.MutexWrap(ds.NewMapDatastore()))}
+
+	// The export cannot be read without the passphrase
+	_, err = dst.Import(bytes.NewReader(buf.Bytes()), "wrong")
+	require.ErrorIs(t, err, utils.ErrDecrypt)
+
+	n, err := dst.Import(bytes.NewReader(buf.Bytes()), "passphrase")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	vouchers, err := dst.store.VouchersForPaych(ch)
+	require.NoError(t, err)
+	require.Len(t, vouchers, 1)
+	require.True(t, sv.Amount.Equals(vouchers[0].Voucher.Amount))
+
+	// Channels we already track are not imported again
+	n, err = dst.Import(bytes.NewReader(buf.Bytes()), "passphrase")
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestChannelInfoSettlingAt(t *testing.T) {
+	ch := tutils.NewIDAddr(t, 100)
+	ci := &ChannelInfo{
+		ChannelID:     "1",
+		Channel:       &ch,
+		Control:       tutils.NewIDAddr(t, 101),
+		Target:        tutils.NewIDAddr(t, 102),
+		Amount:        big.Zero(),
+		PendingAmount: big.Zero(),
+		Settling:      true,
+		SettlingAt:    1450,
+		Collected:     true,
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, ci.MarshalCBOR(buf))
+
+	var dec ChannelInfo
+	require.NoError(t, dec.UnmarshalCBOR(bytes.NewReader(buf.Bytes())))
+	require.Equal(t, ci.SettlingAt, dec.SettlingAt)
+	require.True(t, dec.Collected)
+
+	// Channels stored before we persisted the collection still decode
+	buf.Reset()
+	require.NoError(t, ci.MarshalCBOR(buf))
+	legacy := buf.Bytes()
+	legacy[0]--
+	legacy = legacy[:len(legacy)-1]
+
+	dec = ChannelInfo{}
+	require.NoError(t, dec.UnmarshalCBOR(bytes.NewReader(legacy)))
+	require.Equal(t, ci.SettlingAt, dec.SettlingAt)
+	require.False(t, dec.Collected)
+
+	// And so do the ones stored before we persisted the settlement epoch
+	ci.SettlingAt = 0
+	buf.Reset()
+	require.NoError(t, ci.MarshalCBOR(buf))
+	legacy = buf.Bytes()
+	legacy[0] -= 2
+	legacy = legacy[:len(legacy)-2]
+
+	dec = ChannelInfo{}
+	require.NoError(t, dec.UnmarshalCBOR(bytes.NewReader(legacy)))
+	require.True(t, dec.Settling)
+	require.Equal(t, ci.ChannelID, dec.ChannelID)
+}
+
+func TestLockedFunds(t *testing.T) {
+	store := NewStore(sync.MutexWrap(ds.NewMapDatastore()))
+	p := &Payments{store: store}
+
+	from := tutils.NewIDAddr(t, 101)
+	track := func(id uint64, to address.Address, settling, collected bool) {
+		ch := tutils.NewIDAddr(t, id)
+		_, err := store.TrackChannel(&ChannelInfo{
+			Channel:       &ch,
+			Control:       from,
+			Target:        to,
+			Direction:     DirOutbound,
+			Amount:        big.NewInt(100),
+			PendingAmount: big.NewInt(10),
+			Settling:      settling,
+			Collected:     collected,
+		})
+		require.NoError(t, err)
+	}
+	track(100, tutils.NewIDAddr(t, 102), false, false)
+	track(200, tutils.NewIDAddr(t, 103), true, false)
+	track(300, tutils.NewIDAddr(t, 104), false, true)
+
+	// Only the channels we can still spend from hold our funds
+	funds, err := p.LockedFunds(from)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(100), funds.Locked)
+	require.Equal(t, big.NewInt(10), funds.Pending)
+
+	// Collected channels are not reused
+	_, err = store.OutboundActiveByFromTo(from, tutils.NewIDAddr(t, 104))
+	require.ErrorIs(t, err, ErrChannelNotTracked)
 }
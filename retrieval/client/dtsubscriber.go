@@ -37,6 +37,8 @@ func eventFromDealStatus(response *deal.Response) (Event, []interface{}) {
 		return EventComplete, nil
 	case deal.StatusFundsNeeded, deal.StatusOngoing:
 		return EventPaymentRequested, []interface{}{response.PaymentOwed}
+	case deal.StatusInsufficientFunds:
+		return EventProviderFundsShortfall, []interface{}{response.PaymentOwed, response.Message}
 	default:
 		return EventUnknownResponseReceived, nil
 	}
@@ -132,6 +132,17 @@ const (
 	// EventProviderErrored happens when we receive a status in response voucher
 	// telling us something went wrong on the provider side but they don't know what (500)
 	EventProviderErrored
+
+	// EventFundsToppedUp happens when funds were added to the payment channel of a deal waiting for funds
+	EventFundsToppedUp
+
+	// EventFundsGraceExpired happens when the payment channel of a deal waiting for funds wasn't topped up
+	// within the grace period
+	EventFundsGraceExpired
+
+	// EventProviderFundsShortfall happens when the provider paused the transfer because our last voucher
+	// is worth more than the funds of the payment channel on chain
+	EventProviderFundsShortfall
 )
 
 // Events is a human readable map of client event name -> event description
@@ -174,4 +185,7 @@ var Events = map[Event]string{
 	EventCancel:                        "ClientEventCancel",
 	EventWaitForLastBlocks:             "ClientEventWaitForLastBlocks",
 	EventProviderErrored:               "ClientEventProviderErrored",
+	EventFundsToppedUp:                 "ClientEventFundsToppedUp",
+	EventFundsGraceExpired:             "ClientEventFundsGraceExpired",
+	EventProviderFundsShortfall:        "ClientEventProviderFundsShortfall",
 }
@@ -280,6 +280,29 @@ var FSMEvents = fsm.Events{
 		Action(func(ds *deal.ClientState, shortfall abi.TokenAmount) error {
 			return nil
 		}),
+	// The provider could not redeem our last voucher with the funds of the channel on chain so it paused
+	// the transfer. We check the funds again and send the voucher anew once the channel can cover it.
+	fsm.Event(EventProviderFundsShortfall).
+		FromMany(
+			deal.StatusOngoing,
+			deal.StatusFundsNeeded,
+			deal.StatusFundsNeededLastPayment,
+			deal.StatusBlocksComplete,
+			deal.StatusCheckComplete,
+			deal.StatusFinalizing).To(deal.StatusCheckFunds).
+		Action(func(ds *deal.ClientState, paymentOwed abi.TokenAmount, message string) error {
+			// The provider only credited the funds it received before our last voucher
+			ds.FundsSpent = big.Max(big.Sub(ds.FundsSpent, paymentOwed), big.Zero())
+			ds.UnsealFundsPaid = big.Min(ds.FundsSpent, ds.UnsealPrice)
+			if !ds.PricePerByte.IsZero() {
+				ds.BytesPaidFor = big.Div(big.Sub(ds.FundsSpent, ds.UnsealFundsPaid), ds.PricePerByte).Uint64()
+				// the interval is the one our last voucher paid for
+				ds.CurrentInterval = ds.Params.NextInterval(ds.BytesPaidFor)
+			}
+			ds.PaymentRequested = paymentOwed
+			ds.Message = fmt.Sprintf("provider paused the transfer: %s", message)
+			return nil
+		}),
 
 	fsm.Event(EventWriteDealPaymentErrored).
 		FromAny().To(deal.StatusErrored).
@@ -393,6 +416,25 @@ var FSMEvents = fsm.Events{
 
 	// payment channel receives more money, we believe there may be reason to recheck the funds for this channel
 	fsm.Event(EventRecheckFunds).From(deal.StatusInsufficientFunds).To(deal.StatusCheckFunds),
+
+	// the user added funds to the payment channel while the provider waits for our payment
+	fsm.Event(EventFundsToppedUp).
+		From(deal.StatusInsufficientFunds).To(deal.StatusCheckFunds).
+		Action(func(ds *deal.ClientState, amt abi.TokenAmount) error {
+			// The funds are reserved for this deal until it ends
+			ds.TotalFunds = big.Add(ds.TotalFunds, amt)
+			ds.Message = ""
+			return nil
+		}),
+
+	// nobody topped up the payment channel in time so we cancel the deal, the funds already spent remain
+	// accounted for
+	fsm.Event(EventFundsGraceExpired).
+		From(deal.StatusInsufficientFunds).To(deal.StatusCancelling).
+		Action(func(ds *deal.ClientState) error {
+			ds.Message = fmt.Sprintf("payment channel was not topped up in time, shortfall of %s", ds.VoucherShortfall)
+			return nil
+		}),
 }
 
 // FinalityStates are terminal states after which no further events are received
@@ -473,9 +515,10 @@ func WaitPaymentChannelReady(ctx fsm.Context, environment DealEnvironment, ds de
 	return ctx.Trigger(EventPaymentChannelReady, paych)
 }
 
-// AllocateLane allocates a lane for this retrieval operation
+// AllocateLane acquires a lane for this retrieval operation, it is released once the deal
+// reaches a final state so the next retrieval from the same provider can reuse it
 func AllocateLane(ctx fsm.Context, environment DealEnvironment, ds deal.ClientState) error {
-	lane, err := environment.Payments().AllocateLane(ctx.Context(), ds.PaymentInfo.PayCh)
+	lane, err := environment.Payments().AcquireLane(ctx.Context(), ds.PaymentInfo.PayCh)
 	if err != nil {
 		return ctx.Trigger(EventAllocateLaneErrored, err)
 	}
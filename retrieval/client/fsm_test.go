@@ -12,6 +12,7 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-statemachine/fsm"
 	fsmtest "github.com/filecoin-project/go-statemachine/fsm/testutil"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/stretchr/testify/require"
 
@@ -32,6 +33,101 @@ func TestClientFSM(t *testing.T) {
 		require.NoError(t, err)
 		fsmCtx.ReplayEvents(t, dealState)
 	})
+
+	t.Run("topping up resumes a deal out of funds", func(t *testing.T) {
+		dealState := makeClientDealState(deal.StatusInsufficientFunds)
+		dealState.VoucherShortfall = abi.NewTokenAmount(1000)
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		require.NoError(t, fsmCtx.Trigger(EventFundsToppedUp, abi.NewTokenAmount(1000)))
+		fsmCtx.ReplayEvents(t, dealState)
+		require.Equal(t, deal.StatusCheckFunds, dealState.Status)
+		require.Equal(t, abi.NewTokenAmount(4001000), dealState.TotalFunds)
+	})
+
+	t.Run("a deal out of funds is cancelled after the grace period", func(t *testing.T) {
+		dealState := makeClientDealState(deal.StatusInsufficientFunds)
+		dealState.VoucherShortfall = abi.NewTokenAmount(1000)
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		require.NoError(t, fsmCtx.Trigger(EventFundsGraceExpired))
+		fsmCtx.ReplayEvents(t, dealState)
+		require.Equal(t, deal.StatusCancelling, dealState.Status)
+		require.Contains(t, dealState.Message, "not topped up")
+		// the funds already spent remain accounted for
+		require.Equal(t, abi.NewTokenAmount(2500000), dealState.FundsSpent)
+	})
+
+	t.Run("a transfer paused by the provider resumes once the channel has the funds", func(t *testing.T) {
+		// we sent a voucher for the interval ending at 5000 bytes after paying for 3000 bytes
+		dealState := makeClientDealState(deal.StatusOngoing)
+		dealState.PaymentInfo = &deal.PaymentInfo{PayCh: address.TestAddress}
+		dealState.TotalReceived = 5200
+		dealState.BytesPaidFor = 5000
+		dealState.CurrentInterval = 7500
+		dealState.FundsSpent = abi.NewTokenAmount(2500000)
+		dealState.PaymentRequested = big.Zero()
+
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		require.NoError(t, fsmCtx.Trigger(EventProviderFundsShortfall, abi.NewTokenAmount(1000000), "not enough funds in channel"))
+		fsmCtx.ReplayEvents(t, dealState)
+		require.Equal(t, deal.StatusCheckFunds, dealState.Status)
+		require.Contains(t, dealState.Message, "provider paused the transfer")
+		require.Equal(t, abi.NewTokenAmount(1500000), dealState.FundsSpent)
+		require.Equal(t, uint64(3000), dealState.BytesPaidFor)
+		require.Equal(t, uint64(5000), dealState.CurrentInterval)
+
+		pay := &mockFundsManager{funds: &payments.AvailableFunds{
+			ConfirmedAmt:       abi.NewTokenAmount(4000000),
+			VoucherRedeemedAmt: big.Zero(),
+			PendingAmt:         big.Zero(),
+			QueuedAmt:          big.Zero(),
+		}}
+		environment := &mockClientEnvironment{payments: pay}
+		for _, handler := range []func(fsm.Context, DealEnvironment, deal.ClientState) error{
+			CheckFunds,
+			Ongoing,
+			ProcessPaymentRequested,
+			SendFunds,
+		} {
+			fsmCtx = fsmtest.NewTestContext(ctx, eventMachine)
+			require.NoError(t, handler(fsmCtx, environment, *dealState))
+			fsmCtx.ReplayEvents(t, dealState)
+		}
+		// the voucher was sent again
+		require.Equal(t, abi.NewTokenAmount(2500000), pay.voucherAmt)
+		require.Equal(t, deal.StatusOngoing, dealState.Status)
+		require.Equal(t, abi.NewTokenAmount(2500000), dealState.FundsSpent)
+		require.Equal(t, uint64(5000), dealState.BytesPaidFor)
+		require.Equal(t, "", dealState.Message)
+	})
+
+	t.Run("the provider pausing for funds is reported", func(t *testing.T) {
+		evt, args := eventFromDealStatus(&deal.Response{
+			Status:      deal.StatusInsufficientFunds,
+			PaymentOwed: abi.NewTokenAmount(1000),
+			Message:     "not enough funds in channel",
+		})
+		require.Equal(t, EventProviderFundsShortfall, evt)
+		require.Equal(t, []interface{}{abi.NewTokenAmount(1000), "not enough funds in channel"}, args)
+	})
+}
+
+// mockFundsManager only implements the payment methods used to check funds and send vouchers
+type mockFundsManager struct {
+	payments.Manager
+	funds      *payments.AvailableFunds
+	voucherAmt abi.TokenAmount
+}
+
+func (m *mockFundsManager) ChannelAvailableFunds(address.Address) (*payments.AvailableFunds, error) {
+	return m.funds, nil
+}
+
+func (m *mockFundsManager) CreateVoucher(ctx context.Context, ch address.Address, amt abi.TokenAmount, lane uint64) (*payments.VoucherCreateResult, error) {
+	m.voucherAmt = amt
+	return &payments.VoucherCreateResult{
+		Voucher:   &paych.SignedVoucher{ChannelAddr: ch, Lane: lane, Amount: amt},
+		Shortfall: big.Zero(),
+	}, nil
 }
 
 type mockClientEnvironment struct {
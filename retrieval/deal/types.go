@@ -101,6 +101,10 @@ type QueryResponse struct {
 	MaxPaymentIntervalIncrease uint64
 	Message                    string
 	UnsealPrice                abi.TokenAmount
+
+	// RedirectAddr and RedirectSig point to another peer holding the content when the provider doesn't
+	RedirectAddr []byte
+	RedirectSig  []byte
 }
 
 // PieceRetrievalPrice is the total price to retrieve the piece (size * MinPricePerByte + UnsealedPrice)
@@ -120,6 +124,16 @@ type Offer struct {
 	MaxPaymentInterval         uint64
 	MaxPaymentIntervalIncrease uint64
 	UnsealPrice                abi.TokenAmount
+	// Throughput is the transfer rate in bytes per second the provider expects to sustain
+	Throughput uint64
+	// Load is the number of transfers the provider is currently serving
+	Load uint64
+	// FreeTier is set when the provider serves this content without payment
+	FreeTier bool
+	// RedirectAddr is the p2p address of a peer the provider knows holds the content when it doesn't
+	RedirectAddr []byte
+	// RedirectSig is the signature of the PayloadCID and RedirectAddr by the provider
+	RedirectSig []byte
 }
 
 // AddrInfo returns the peer info to connect with the provider of this offer
@@ -127,13 +141,34 @@ func (o Offer) AddrInfo() (*peer.AddrInfo, error) {
 	return utils.AddrBytesToAddrInfo(o.PeerAddr)
 }
 
-// RetrievalPrice is the total price to retrieve the content from this offer
+// IsRedirect returns whether the offer only points to another peer holding the content
+func (o Offer) IsRedirect() bool {
+	return len(o.RedirectAddr) > 0
+}
+
+// RedirectInfo returns the peer info to connect with the peer this offer redirects to
+func (o Offer) RedirectInfo() (*peer.AddrInfo, error) {
+	return utils.AddrBytesToAddrInfo(o.RedirectAddr)
+}
+
+// RetrievalPrice is the total price to retrieve the content from this offer including unsealing it
 func (o Offer) RetrievalPrice() abi.TokenAmount {
-	return big.Mul(o.MinPricePerByte, abi.NewTokenAmount(int64(o.Size)))
+	price := big.Mul(o.MinPricePerByte, abi.NewTokenAmount(int64(o.Size)))
+	if o.UnsealPrice.Int == nil {
+		return price
+	}
+	return big.Add(price, o.UnsealPrice)
 }
 
 // AsQueryResponse retrofits an Offer into a QueryResponse message
 func (o Offer) AsQueryResponse() QueryResponse {
+	if o.IsRedirect() {
+		return QueryResponse{
+			Status:       QueryResponseUnavailable,
+			RedirectAddr: o.RedirectAddr,
+			RedirectSig:  o.RedirectSig,
+		}
+	}
 	return QueryResponse{
 		Status:                     QueryResponseAvailable,
 		Size:                       o.Size,
@@ -263,7 +263,7 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{169}); err != nil {
+	if _, err := w.Write([]byte{171}); err != nil {
 		return err
 	}
 
@@ -419,6 +419,53 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 	if err := t.UnsealPrice.MarshalCBOR(w); err != nil {
 		return err
 	}
+	// t.RedirectAddr ([]uint8) (slice)
+	if len("RedirectAddr") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RedirectAddr\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RedirectAddr"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RedirectAddr")); err != nil {
+		return err
+	}
+
+	if len(t.RedirectAddr) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.RedirectAddr was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.RedirectAddr))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.RedirectAddr[:]); err != nil {
+		return err
+	}
+
+	// t.RedirectSig ([]uint8) (slice)
+	if len("RedirectSig") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RedirectSig\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RedirectSig"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RedirectSig")); err != nil {
+		return err
+	}
+
+	if len(t.RedirectSig) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.RedirectSig was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.RedirectSig))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.RedirectSig[:]); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -571,6 +618,50 @@ func (t *QueryResponse) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.RedirectAddr ([]uint8) (slice)
+		case "RedirectAddr":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.RedirectAddr: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.RedirectAddr = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.RedirectAddr[:]); err != nil {
+				return err
+			}
+			// t.RedirectSig ([]uint8) (slice)
+		case "RedirectSig":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.RedirectSig: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.RedirectSig = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.RedirectSig[:]); err != nil {
+				return err
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -2479,7 +2570,7 @@ func (t *Offer) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{169}); err != nil {
+	if _, err := w.Write([]byte{174}); err != nil {
 		return err
 	}
 
@@ -2643,6 +2734,101 @@ func (t *Offer) MarshalCBOR(w io.Writer) error {
 	if err := t.UnsealPrice.MarshalCBOR(w); err != nil {
 		return err
 	}
+
+	// t.Throughput (uint64) (uint64)
+	if len("Throughput") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Throughput\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Throughput"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Throughput")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Throughput)); err != nil {
+		return err
+	}
+
+	// t.Load (uint64) (uint64)
+	if len("Load") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Load\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Load"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Load")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Load)); err != nil {
+		return err
+	}
+
+	// t.FreeTier (bool) (bool)
+	if len("FreeTier") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"FreeTier\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("FreeTier"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("FreeTier")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.FreeTier); err != nil {
+		return err
+	}
+	// t.RedirectAddr ([]uint8) (slice)
+	if len("RedirectAddr") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RedirectAddr\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RedirectAddr"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RedirectAddr")); err != nil {
+		return err
+	}
+
+	if len(t.RedirectAddr) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.RedirectAddr was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.RedirectAddr))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.RedirectAddr[:]); err != nil {
+		return err
+	}
+
+	// t.RedirectSig ([]uint8) (slice)
+	if len("RedirectSig") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RedirectSig\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RedirectSig"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RedirectSig")); err != nil {
+		return err
+	}
+
+	if len(t.RedirectSig) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.RedirectSig was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.RedirectSig))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.RedirectSig[:]); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -2800,6 +2986,98 @@ func (t *Offer) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.Throughput (uint64) (uint64)
+		case "Throughput":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Throughput = uint64(extra)
+
+			}
+			// t.Load (uint64) (uint64)
+		case "Load":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Load = uint64(extra)
+
+			}
+			// t.FreeTier (bool) (bool)
+		case "FreeTier":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.FreeTier = false
+			case 21:
+				t.FreeTier = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.RedirectAddr ([]uint8) (slice)
+		case "RedirectAddr":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.RedirectAddr: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.RedirectAddr = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.RedirectAddr[:]); err != nil {
+				return err
+			}
+			// t.RedirectSig ([]uint8) (slice)
+		case "RedirectSig":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.RedirectSig: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.RedirectSig = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.RedirectSig[:]); err != nil {
+				return err
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
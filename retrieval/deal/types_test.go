@@ -1,8 +1,12 @@
 package deal
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/filecoin-project/go-state-types/abi"
+	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -43,3 +47,37 @@ func TestIntervals(t *testing.T) {
 		})
 	}
 }
+
+func TestOfferHints(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	offer := Offer{
+		ID:              "1",
+		PayloadCID:      bgen.Next().Cid(),
+		Size:            1024,
+		PaymentAddress:  tutils.NewIDAddr(t, 100),
+		MinPricePerByte: abi.NewTokenAmount(0),
+		UnsealPrice:     abi.NewTokenAmount(0),
+		Throughput:      4096,
+		Load:            3,
+		FreeTier:        true,
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, offer.MarshalCBOR(buf))
+
+	var dec Offer
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.Equal(t, uint64(4096), dec.Throughput)
+	require.Equal(t, uint64(3), dec.Load)
+	require.True(t, dec.FreeTier)
+}
+
+func TestRetrievalPrice(t *testing.T) {
+	offer := Offer{
+		Size:            1024,
+		MinPricePerByte: abi.NewTokenAmount(2),
+	}
+	require.Equal(t, abi.NewTokenAmount(2048), offer.RetrievalPrice())
+
+	offer.UnsealPrice = abi.NewTokenAmount(1000)
+	require.Equal(t, abi.NewTokenAmount(3048), offer.RetrievalPrice())
+}
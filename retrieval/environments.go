@@ -35,7 +35,8 @@ func (pde *providerDealEnvironment) TrackTransfer(ds deal.ProviderState) error {
 
 func (pde *providerDealEnvironment) UntrackTransfer(ds deal.ProviderState) error {
 	pde.p.revalidator.UntrackChannel(ds)
-	return nil
+	// persist the bytes sent during this transfer
+	return pde.p.usage.Flush()
 }
 
 func (pde *providerDealEnvironment) ResumeDataTransfer(ctx context.Context, chid datatransfer.ChannelID) error {
@@ -101,6 +102,9 @@ type providerValidationEnvironment struct {
 
 // CheckDealParams verifies the given deal params are acceptable
 func (pve *providerValidationEnvironment) CheckDealParams(ds deal.ProviderState) error {
+	if err := pve.p.usage.Check(ds.Receiver); err != nil {
+		return err
+	}
 	ask := pve.p.GetAsk(ds.PayloadCID)
 	if ds.PricePerByte.LessThan(ask.MinPricePerByte) {
 		return errors.New("price per byte too low")
@@ -122,6 +126,9 @@ func (pve *providerValidationEnvironment) BeginTracking(pds deal.ProviderState)
 	}
 
 	pve.p.revalidator.TrackChannel(pds)
+	if err := pve.p.usage.AddDeal(pds.Receiver); err != nil {
+		return err
+	}
 	return pve.p.stateMachines.Send(pds.Identifier(), provider.EventOpen)
 }
 
@@ -145,6 +152,14 @@ func (pre *providerRevalidatorEnvironment) Payments() payments.Manager {
 	return pre.p.pay
 }
 
+func (pre *providerRevalidatorEnvironment) Usage() *UsageTracker {
+	return pre.p.usage
+}
+
+func (pre *providerRevalidatorEnvironment) ResumeTransfer(chid datatransfer.ChannelID) error {
+	return pre.p.dataTransfer.ResumeDataTransferChannel(context.TODO(), chid)
+}
+
 func (pre *providerRevalidatorEnvironment) SendEvent(dealID deal.ProviderDealIdentifier, evt provider.Event, args ...interface{}) error {
 	return pre.p.stateMachines.Send(dealID, evt, args...)
 }
@@ -2,6 +2,9 @@ package retrieval
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
@@ -22,6 +25,13 @@ import (
 	"github.com/myelnet/pop/retrieval/provider"
 )
 
+// DefaultFundsGracePeriod is how long a deal waits for its payment channel to be topped up once it runs out
+// of funds before it is cancelled
+const DefaultFundsGracePeriod = 10 * time.Minute
+
+// ErrNotWaitingForFunds is returned when topping up a deal which isn't waiting for funds
+var ErrNotWaitingForFunds = errors.New("deal is not waiting for funds")
+
 // Unsubscribe is a function that unsubscribes a subscriber for either the
 // client or the provider
 type Unsubscribe func()
@@ -61,17 +71,115 @@ type Client struct {
 	subscribers   *pubsub.PubSub
 	counter       *counter
 	pay           payments.Manager
+
+	gmu sync.Mutex
+	// fundsGrace is how long a deal waits for funds before it is cancelled
+	fundsGrace time.Duration
+	// graces are the timers cancelling the deals waiting for funds
+	graces map[deal.ID]*time.Timer
+
+	rmu sync.Mutex
+	// released are the deals which funds were already released as final states may be notified more than once
+	released map[deal.ID]struct{}
 }
 
 func (c *Client) notifySubscribers(eventName fsm.EventName, state fsm.StateType) {
 	evt := eventName.(client.Event)
 	ds := state.(deal.ClientState)
+	c.watchFunds(ds)
+	if isFinalState(ds.Status) && c.release(ds.ID) {
+		if ds.PaymentInfo != nil {
+			if err := c.pay.ReleaseLane(context.TODO(), ds.PaymentInfo.PayCh, ds.PaymentInfo.Lane); err != nil {
+				log.Error().Err(err).Uint64("lane", ds.PaymentInfo.Lane).Msg("failed to release payment lane")
+			}
+		}
+		// funds are reserved as soon as we got a channel, whether it was ready or still waiting for funds
+		if ds.PaymentInfo != nil || ds.WaitMsgCID != nil {
+			if err := c.pay.ReleaseFunds(context.TODO(), ds.ClientWallet, ds.MinerWallet, ds.TotalFunds); err != nil {
+				log.Error().Err(err).Msg("failed to release payment channel funds")
+			}
+		}
+	}
 	_ = c.subscribers.Publish(client.InternalEvent{
 		Evt:   evt,
 		State: ds,
 	})
 }
 
+// release returns true the first time it is called for a given deal
+func (c *Client) release(id deal.ID) bool {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if _, ok := c.released[id]; ok {
+		return false
+	}
+	c.released[id] = struct{}{}
+	return true
+}
+
+// watchFunds starts the grace period of a deal running out of funds and stops it once the deal moves on
+func (c *Client) watchFunds(ds deal.ClientState) {
+	c.gmu.Lock()
+	defer c.gmu.Unlock()
+
+	t, waiting := c.graces[ds.ID]
+	if ds.Status != deal.StatusInsufficientFunds {
+		if waiting {
+			t.Stop()
+			delete(c.graces, ds.ID)
+		}
+		return
+	}
+	if waiting || c.fundsGrace <= 0 {
+		return
+	}
+	id := ds.ID
+	c.graces[id] = time.AfterFunc(c.fundsGrace, func() {
+		c.gmu.Lock()
+		delete(c.graces, id)
+		c.gmu.Unlock()
+		if err := c.stateMachines.Send(id, client.EventFundsGraceExpired); err != nil {
+			log.Error().Err(err).Uint64("deal", uint64(id)).Msg("failed to cancel deal waiting for funds")
+		}
+	})
+}
+
+// SetFundsGracePeriod sets how long deals running out of funds wait for their payment channel to be topped up
+// before they are cancelled. A duration of 0 or less waits until the deal is cancelled manually.
+func (c *Client) SetFundsGracePeriod(d time.Duration) {
+	c.gmu.Lock()
+	c.fundsGrace = d
+	c.gmu.Unlock()
+}
+
+// TopUp adds funds to the payment channel of a deal waiting for funds so it can resume paying the provider.
+// If the amount is zero the shortfall of the deal is added.
+func (c *Client) TopUp(ctx context.Context, id deal.ID, amt abi.TokenAmount) error {
+	var ds deal.ClientState
+	if err := c.stateMachines.Get(id).Get(&ds); err != nil {
+		return err
+	}
+	if ds.Status != deal.StatusInsufficientFunds {
+		return ErrNotWaitingForFunds
+	}
+	if amt.Nil() || amt.IsZero() {
+		amt = ds.VoucherShortfall
+	}
+	if _, err := c.pay.GetChannel(ctx, ds.ClientWallet, ds.MinerWallet, amt); err != nil {
+		return err
+	}
+	return c.stateMachines.Send(id, client.EventFundsToppedUp, amt)
+}
+
+func isFinalState(status deal.Status) bool {
+	for _, s := range client.FinalityStates {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Provider wraps all the provider operations
 type Provider struct {
 	multiStore       *multistore.MultiStore
@@ -82,6 +190,7 @@ type Provider struct {
 	revalidator      *ProviderRevalidator
 	pay              payments.Manager
 	askStore         *AskStore
+	usage            *UsageTracker
 }
 
 // GetAsk returns the current deal parameters this provider accepts for a given content ID
@@ -97,6 +206,21 @@ func (p *Provider) SetAsk(k cid.Cid, ask deal.Offer) {
 	}
 }
 
+// SetQuota sets the bandwidth limits applied to each client
+func (p *Provider) SetQuota(q Quota) {
+	p.usage.SetQuota(q)
+}
+
+// Usage returns the bandwidth accounting for all clients we served
+func (p *Provider) Usage() *UsageTracker {
+	return p.usage
+}
+
+// Load returns the number of transfers this provider is currently serving
+func (p *Provider) Load() uint64 {
+	return uint64(p.revalidator.TrackedChannels())
+}
+
 func (p *Provider) notifySubscribers(eventName fsm.EventName, state fsm.StateType) {
 	evt := eventName.(provider.Event)
 	ds := state.(deal.ProviderState)
@@ -128,6 +252,9 @@ func New(
 		counter:      newCounter(),
 		dataTransfer: dt,
 		pay:          pay,
+		fundsGrace:   DefaultFundsGracePeriod,
+		graces:       make(map[deal.ID]*time.Timer),
+		released:     make(map[deal.ID]struct{}),
 	}
 	c.stateMachines, err = fsm.New(namespace.Wrap(ds, datastore.NewKey("client-v0")), fsm.Parameters{
 		Environment:     &clientDealEnvironment{c},
@@ -149,6 +276,7 @@ func New(
 		askStore: &AskStore{
 			asks: make(map[cid.Cid]deal.Offer),
 		},
+		usage: NewUsageTracker(ds, Quota{}),
 	}
 	p.stateMachines, err = fsm.New(namespace.Wrap(ds, datastore.NewKey("provider-v0")), fsm.Parameters{
 		Environment:     &providerDealEnvironment{p},
@@ -262,6 +390,11 @@ func (c *Client) SubscribeToEvents(subscriber client.Subscriber) Unsubscribe {
 	return Unsubscribe(c.subscribers.Subscribe(subscriber))
 }
 
+// CancelDeal stops a retrieval deal in progress and closes its data transfer channel
+func (c *Client) CancelDeal(id deal.ID) error {
+	return c.stateMachines.Send(id, client.EventCancel)
+}
+
 // TryRestartInsufficientFunds attempts to restart any deals stuck in the insufficient funds state
 // after funds are added to a given payment channel
 func (c *Client) TryRestartInsufficientFunds(chAddr address.Address) error {
@@ -296,6 +429,14 @@ func SettlePaymentChannels(ctx context.Context, pay payments.Manager, pro *Provi
 						log.Error().Err(err).Msg("checking available funds")
 						return
 					}
+					// For now let's assume we'd like to settle things when all the funds have been spent
+					// until then vouchers are only submitted at checkpoints to save on gas
+					if big.Sub(funds.ConfirmedAmt, funds.VoucherRedeemedAmt).GreaterThan(big.Zero()) {
+						if err := pay.Checkpoint(ctx, *state.PayCh); err != nil {
+							log.Error().Err(err).Msg("checkpointing vouchers")
+						}
+						return
+					}
 					// SubmitAllVouchers as one transaction
 					if err := pay.SubmitAllVouchers(ctx, *state.PayCh); err != nil {
 						log.Error().Err(err).Msg("submitting vouchers")
@@ -303,14 +444,11 @@ func SettlePaymentChannels(ctx context.Context, pay payments.Manager, pro *Provi
 					}
 
 					log.Info().Msg("redeemed payment vouchers")
-					// For now let's assume we'd like to settle things when all the funds have been spent
-					if big.Sub(funds.ConfirmedAmt, funds.VoucherRedeemedAmt).LessThanEqual(big.Zero()) {
-						err := pay.Settle(ctx, *state.PayCh)
-						if err != nil {
-							log.Error().Err(err).Msg("settling payment channel")
-						} else {
-							log.Info().Str("addr", state.PayCh.String()).Msg("settled payment channel")
-						}
+					err = pay.Settle(ctx, *state.PayCh)
+					if err != nil {
+						log.Error().Err(err).Msg("settling payment channel")
+					} else {
+						log.Info().Str("addr", state.PayCh.String()).Msg("settled payment channel")
 					}
 				}()
 			}
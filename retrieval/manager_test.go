@@ -3,6 +3,7 @@ package retrieval
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"testing"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	tutils "github.com/filecoin-project/specs-actors/v4/support/testing"
+	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -35,6 +37,7 @@ type mockPayments struct {
 	chResponse *payments.ChannelResponse
 	chAddr     address.Address
 	chFunds    *payments.AvailableFunds
+	released   int
 	lk         sync.Mutex
 }
 
@@ -57,6 +60,10 @@ func (p *mockPayments) GetChannelInfo(addr address.Address) (*payments.ChannelIn
 	return nil, nil
 }
 
+func (p *mockPayments) InspectChannel(ctx context.Context, addr address.Address) (*payments.ChannelSummary, error) {
+	return nil, nil
+}
+
 func (p *mockPayments) CreateVoucher(ctx context.Context, addr address.Address, amt filecoin.BigInt, lane uint64) (*payments.VoucherCreateResult, error) {
 	if amt.GreaterThan(p.chFunds.ConfirmedAmt) {
 		return &payments.VoucherCreateResult{
@@ -85,14 +92,49 @@ func (p *mockPayments) AllocateLane(ctx context.Context, add address.Address) (u
 	return 0, nil
 }
 
+func (p *mockPayments) AcquireLane(ctx context.Context, add address.Address) (uint64, error) {
+	return 0, nil
+}
+
+func (p *mockPayments) ReleaseLane(ctx context.Context, add address.Address, lane uint64) error {
+	return nil
+}
+
+func (p *mockPayments) ReleaseFunds(ctx context.Context, from, to address.Address, amt filecoin.BigInt) error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.released++
+	return nil
+}
+
+func (p *mockPayments) SubscribeToEvents(sub payments.Subscriber) payments.Unsubscribe {
+	return func() {}
+}
+
 func (p *mockPayments) AddVoucherInbound(ctx context.Context, addr address.Address, vouch *paych.SignedVoucher, prrof []byte, expectedAmount filecoin.BigInt) (filecoin.BigInt, error) {
 	return vouch.Amount, nil
 }
 
+func (p *mockPayments) ListVouchers(ctx context.Context, addr address.Address) ([]*payments.VoucherInfo, error) {
+	return nil, nil
+}
+
+func (p *mockPayments) CheckVoucherSpendable(ctx context.Context, addr address.Address, vouch *paych.SignedVoucher, secret []byte, proof []byte) (bool, error) {
+	return true, nil
+}
+
+func (p *mockPayments) SubmitVoucher(ctx context.Context, addr address.Address, vouch *paych.SignedVoucher, secret []byte, proof []byte) (cid.Cid, error) {
+	return cid.Undef, nil
+}
+
 func (p *mockPayments) ChannelAvailableFunds(chAddr address.Address) (*payments.AvailableFunds, error) {
 	return p.chFunds, nil
 }
 
+func (p *mockPayments) LockedFunds(from address.Address) (*payments.LockedFunds, error) {
+	return &payments.LockedFunds{Locked: filecoin.NewInt(0), Pending: filecoin.NewInt(0)}, nil
+}
+
 func (p *mockPayments) SetChannelAvailableFunds(funds payments.AvailableFunds) {
 	p.lk.Lock()
 	defer p.lk.Unlock()
@@ -104,10 +146,30 @@ func (p *mockPayments) Settle(ctx context.Context, addr address.Address) error {
 	return nil
 }
 
+func (p *mockPayments) Collect(ctx context.Context, addr address.Address) error {
+	return nil
+}
+
 func (p *mockPayments) StartAutoCollect(ctx context.Context) error {
 	return nil
 }
 
+func (p *mockPayments) Checkpoint(context.Context, address.Address) error {
+	return nil
+}
+
+func (p *mockPayments) RevokeVoucher(context.Context, address.Address, *paych.SignedVoucher) error {
+	return nil
+}
+
+func (p *mockPayments) Export(io.Writer, string) error {
+	return nil
+}
+
+func (p *mockPayments) Import(io.Reader, string) (int, error) {
+	return 0, nil
+}
+
 func (p *mockPayments) SubmitAllVouchers(context.Context, address.Address) error {
 	return nil
 }
@@ -342,6 +404,30 @@ totalFunds: %s
 	}
 }
 
+func TestReleaseFundsOnce(t *testing.T) {
+	pay := &mockPayments{}
+	c := &Client{
+		subscribers: pubsub.New(client.Dispatcher),
+		pay:         pay,
+		graces:      make(map[deal.ID]*time.Timer),
+		released:    make(map[deal.ID]struct{}),
+	}
+	ds := deal.ClientState{
+		Proposal:    deal.Proposal{ID: deal.ID(1)},
+		Status:      deal.StatusCompleted,
+		PaymentInfo: &deal.PaymentInfo{},
+		TotalFunds:  abi.NewTokenAmount(1000),
+	}
+	// final states can be notified more than once
+	c.notifySubscribers(client.EventComplete, ds)
+	c.notifySubscribers(client.EventComplete, ds)
+	require.Equal(t, 1, pay.released)
+
+	ds.ID = deal.ID(2)
+	c.notifySubscribers(client.EventComplete, ds)
+	require.Equal(t, 2, pay.released)
+}
+
 func addZeroesToAvailableFunds(channelAvailableFunds payments.AvailableFunds) payments.AvailableFunds {
 	if channelAvailableFunds.ConfirmedAmt.Nil() {
 		channelAvailableFunds.ConfirmedAmt = big.Zero()
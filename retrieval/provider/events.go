@@ -67,6 +67,10 @@ const (
 
 	// EventClientCancelled happens when the provider gets a cancel message from the client's data transfer
 	EventClientCancelled
+
+	// EventVoucherShortfall happens when a voucher is worth more than the funds in the payment channel
+	// and the transfer is paused until the client adds funds
+	EventVoucherShortfall
 )
 
 // Events is a human readable map of provider event name -> event description
@@ -89,4 +93,5 @@ var Events = map[Event]string{
 	EventCleanupComplete:        "ProviderEventCleanupComplete",
 	EventMultiStoreError:        "ProviderEventMultiStoreError",
 	EventClientCancelled:        "ProviderEventClientCancelled",
+	EventVoucherShortfall:       "ProviderEventVoucherShortfall",
 }
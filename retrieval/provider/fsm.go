@@ -77,6 +77,12 @@ var FSMEvents = fsm.Events{
 	fsm.Event(EventSaveVoucherFailed).
 		FromMany(deal.StatusFundsNeeded, deal.StatusFundsNeededLastPayment).To(deal.StatusFailing).
 		Action(recordError),
+	fsm.Event(EventVoucherShortfall).
+		FromMany(deal.StatusFundsNeeded, deal.StatusFundsNeededLastPayment).ToJustRecord().
+		Action(func(ds *deal.ProviderState, shortfall abi.TokenAmount) error {
+			ds.Message = fmt.Sprintf("voucher exceeds payment channel funds by %s, waiting for more funds", shortfall)
+			return nil
+		}),
 	fsm.Event(EventPartialPaymentReceived).
 		FromMany(deal.StatusFundsNeeded, deal.StatusFundsNeededLastPayment).ToNoChange().
 		Action(func(ds *deal.ProviderState, fundsReceived abi.TokenAmount, ch address.Address) error {
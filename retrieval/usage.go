@@ -0,0 +1,304 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrQuotaExceeded is returned when a client has retrieved more bytes than allowed for the current window
+var ErrQuotaExceeded = errors.New("client quota exceeded")
+
+// Quota sets fair-use limits applied to each client retrieving content from this provider
+type Quota struct {
+	// MaxBytes is the maximum amount of bytes a single client can retrieve during a window.
+	// 0 means no limit.
+	MaxBytes uint64
+	// Window is the period after which a client's quota is reset. Defaults to 24h if MaxBytes is set.
+	Window time.Duration
+	// MaxRate throttles transfers to a given amount of bytes per second per client.
+	// 0 means no throttling.
+	MaxRate uint64
+}
+
+// Usage is the bandwidth accounting record for a single client
+type Usage struct {
+	Peer peer.ID
+	// Wallet is the address paying for the retrievals if the client ever paid us
+	Wallet address.Address
+	// TotalSent is the total amount of bytes ever served to this client
+	TotalSent uint64
+	// WindowSent is the amount of bytes served during the current quota window
+	WindowSent  uint64
+	WindowStart time.Time
+	// Deals is the number of retrieval deals accepted for this client
+	Deals    uint64
+	LastSeen time.Time
+}
+
+// UsageTracker keeps track of bytes served to each client and enforces quotas.
+// Records are kept in memory while transfers are ongoing and persisted when calling Flush.
+type UsageTracker struct {
+	ds datastore.Batching
+
+	mu    sync.Mutex
+	quota Quota
+	usage map[peer.ID]*Usage
+	// rate is a moving average of the bytes per second sent to each client
+	rate float64
+}
+
+// rateAlpha is the weight of the latest sample in the moving average of the send rate
+const rateAlpha = 0.2
+
+// NewUsageTracker creates a new UsageTracker persisting records in the given datastore
+func NewUsageTracker(ds datastore.Batching, q Quota) *UsageTracker {
+	return &UsageTracker{
+		ds:    namespace.Wrap(ds, datastore.NewKey("/usage")),
+		quota: q.withDefaults(),
+		usage: make(map[peer.ID]*Usage),
+	}
+}
+
+func (q Quota) withDefaults() Quota {
+	if q.MaxBytes > 0 && q.Window == 0 {
+		q.Window = 24 * time.Hour
+	}
+	return q
+}
+
+// SetQuota updates the quota applied to all clients
+func (ut *UsageTracker) SetQuota(q Quota) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.quota = q.withDefaults()
+}
+
+// Quota returns the current quota applied to all clients
+func (ut *UsageTracker) Quota() Quota {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	return ut.quota
+}
+
+// load returns the usage record for the given peer from memory or the datastore.
+// The caller must hold the lock.
+func (ut *UsageTracker) load(p peer.ID) (*Usage, error) {
+	if u, ok := ut.usage[p]; ok {
+		return u, nil
+	}
+	u := &Usage{Peer: p}
+	b, err := ut.ds.Get(datastore.NewKey(p.String()))
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, u); err != nil {
+			return nil, err
+		}
+	}
+	ut.usage[p] = u
+	return u, nil
+}
+
+// resetWindow starts a new window if the current one is expired
+func (ut *UsageTracker) resetWindow(u *Usage, now time.Time) {
+	if ut.quota.Window == 0 {
+		return
+	}
+	if now.Sub(u.WindowStart) >= ut.quota.Window {
+		u.WindowStart = now
+		u.WindowSent = 0
+	}
+}
+
+// Check returns ErrQuotaExceeded if the client cannot start a new retrieval
+func (ut *UsageTracker) Check(p peer.ID) error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, err := ut.load(p)
+	if err != nil {
+		return err
+	}
+	ut.resetWindow(u, time.Now())
+	if ut.quota.MaxBytes > 0 && u.WindowSent >= ut.quota.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// AddDeal increments the number of deals accepted for a client
+func (ut *UsageTracker) AddDeal(p peer.ID) error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, err := ut.load(p)
+	if err != nil {
+		return err
+	}
+	u.Deals++
+	u.LastSeen = time.Now()
+	return nil
+}
+
+// SetWallet attributes the usage of a client to the wallet address paying for its retrievals
+func (ut *UsageTracker) SetWallet(p peer.ID, addr address.Address) error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, err := ut.load(p)
+	if err != nil {
+		return err
+	}
+	u.Wallet = addr
+	return nil
+}
+
+// Record adds bytes sent to a client. It returns how long the transfer should be delayed
+// to respect the rate limit and ErrQuotaExceeded if the client went over quota.
+func (ut *UsageTracker) Record(p peer.ID, n uint64) (time.Duration, error) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, err := ut.load(p)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	ut.resetWindow(u, now)
+	u.TotalSent += n
+	u.WindowSent += n
+	last := u.LastSeen
+	u.LastSeen = now
+
+	if ut.quota.MaxBytes > 0 && u.WindowSent > ut.quota.MaxBytes {
+		return 0, ErrQuotaExceeded
+	}
+
+	if elapsed := now.Sub(last); !last.IsZero() && elapsed > 0 {
+		sample := float64(n) / elapsed.Seconds()
+		if ut.rate == 0 {
+			ut.rate = sample
+		} else {
+			ut.rate = rateAlpha*sample + (1-rateAlpha)*ut.rate
+		}
+	}
+
+	var delay time.Duration
+	if ut.quota.MaxRate > 0 && !last.IsZero() {
+		// time it should have taken to send n bytes at the max rate
+		expected := time.Duration(float64(n) / float64(ut.quota.MaxRate) * float64(time.Second))
+		if elapsed := now.Sub(last); elapsed < expected {
+			delay = expected - elapsed
+			// we're waiting for that long so the next record starts after the delay
+			u.LastSeen = now.Add(delay)
+		}
+	}
+	return delay, nil
+}
+
+// Throughput returns the rate in bytes per second a client can expect when retrieving from us.
+// It is based on the rate we recently sent data at and capped by the quota max rate.
+func (ut *UsageTracker) Throughput() uint64 {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	rate := uint64(ut.rate)
+	if ut.quota.MaxRate > 0 && (rate == 0 || rate > ut.quota.MaxRate) {
+		rate = ut.quota.MaxRate
+	}
+	return rate
+}
+
+// Get returns the usage record for a given client
+func (ut *UsageTracker) Get(p peer.ID) (Usage, error) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, err := ut.load(p)
+	if err != nil {
+		return Usage{}, err
+	}
+	return *u, nil
+}
+
+// List returns usage records for all the clients we ever served
+func (ut *UsageTracker) List() ([]Usage, error) {
+	if err := ut.Flush(); err != nil {
+		return nil, err
+	}
+	res, err := ut.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var list []Usage
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var u Usage
+		if err := json.Unmarshal(r.Value, &u); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// ByWallet returns usage records aggregated by paying wallet. Clients which never paid
+// are grouped under address.Undef.
+func (ut *UsageTracker) ByWallet() (map[address.Address]Usage, error) {
+	list, err := ut.List()
+	if err != nil {
+		return nil, err
+	}
+	byw := make(map[address.Address]Usage)
+	for _, u := range list {
+		w := byw[u.Wallet]
+		w.Wallet = u.Wallet
+		w.TotalSent += u.TotalSent
+		w.WindowSent += u.WindowSent
+		w.Deals += u.Deals
+		if u.LastSeen.After(w.LastSeen) {
+			w.LastSeen = u.LastSeen
+		}
+		byw[u.Wallet] = w
+	}
+	return byw, nil
+}
+
+// Reset clears the usage records for a given client, for example once it has been invoiced
+func (ut *UsageTracker) Reset(p peer.ID) error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	delete(ut.usage, p)
+	return ut.ds.Delete(datastore.NewKey(p.String()))
+}
+
+// Flush persists all the records currently in memory
+func (ut *UsageTracker) Flush() error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	for p, u := range ut.usage {
+		b, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := ut.ds.Put(datastore.NewKey(p.String()), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
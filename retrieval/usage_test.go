@@ -0,0 +1,195 @@
+package retrieval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ptest "github.com/libp2p/go-libp2p-core/test"
+	"github.com/myelnet/pop/payments"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageTracker(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ut := NewUsageTracker(ds, Quota{MaxBytes: 1000})
+
+	// records are persisted as JSON so we need peer IDs that decode
+	p1 := ptest.RandPeerIDFatal(t)
+	p2 := ptest.RandPeerIDFatal(t)
+
+	require.NoError(t, ut.Check(p1))
+	require.NoError(t, ut.AddDeal(p1))
+
+	_, err := ut.Record(p1, 600)
+	require.NoError(t, err)
+	_, err = ut.Record(p2, 200)
+	require.NoError(t, err)
+
+	_, err = ut.Record(p1, 600)
+	require.Equal(t, ErrQuotaExceeded, err)
+	require.Equal(t, ErrQuotaExceeded, ut.Check(p1))
+	require.NoError(t, ut.Check(p2))
+
+	require.NoError(t, ut.Flush())
+
+	// records are loaded back from the datastore
+	ut = NewUsageTracker(ds, Quota{MaxBytes: 1000})
+	u, err := ut.Get(p1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1200), u.TotalSent)
+	require.Equal(t, uint64(1), u.Deals)
+
+	list, err := ut.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	require.NoError(t, ut.Reset(p1))
+	require.NoError(t, ut.Check(p1))
+}
+
+func TestUsageTrackerWindow(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ut := NewUsageTracker(ds, Quota{MaxBytes: 100, Window: 50 * time.Millisecond})
+
+	p := peer.ID("client")
+	_, err := ut.Record(p, 200)
+	require.Equal(t, ErrQuotaExceeded, err)
+	require.Equal(t, ErrQuotaExceeded, ut.Check(p))
+
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, ut.Check(p))
+}
+
+func TestUsageTrackerThrottle(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ut := NewUsageTracker(ds, Quota{MaxRate: 1000})
+
+	p := peer.ID("client")
+	delay, err := ut.Record(p, 1000)
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), delay)
+
+	// sending 1000 more bytes right away should be delayed about a second
+	delay, err = ut.Record(p, 1000)
+	require.NoError(t, err)
+	require.Greater(t, int64(delay), int64(900*time.Millisecond))
+}
+
+func TestUsageTrackerThroughput(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ut := NewUsageTracker(ds, Quota{})
+
+	// no transfers yet so we can't tell
+	require.Equal(t, uint64(0), ut.Throughput())
+
+	p := peer.ID("client")
+	_, err := ut.Record(p, 1000)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = ut.Record(p, 1000)
+	require.NoError(t, err)
+	require.Greater(t, ut.Throughput(), uint64(0))
+
+	// the quota max rate caps the advertised throughput
+	ut.SetQuota(Quota{MaxRate: 10})
+	require.Equal(t, uint64(10), ut.Throughput())
+}
+
+func TestUsageTrackerByWallet(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ut := NewUsageTracker(ds, Quota{})
+
+	w, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	// two peers of the same client paying with the same wallet
+	p1 := ptest.RandPeerIDFatal(t)
+	p2 := ptest.RandPeerIDFatal(t)
+	p3 := ptest.RandPeerIDFatal(t)
+	require.NoError(t, ut.SetWallet(p1, w))
+	require.NoError(t, ut.SetWallet(p2, w))
+
+	_, err = ut.Record(p1, 100)
+	require.NoError(t, err)
+	_, err = ut.Record(p2, 200)
+	require.NoError(t, err)
+	_, err = ut.Record(p3, 50)
+	require.NoError(t, err)
+
+	byw, err := ut.ByWallet()
+	require.NoError(t, err)
+	require.Len(t, byw, 2)
+	require.Equal(t, uint64(300), byw[w].TotalSent)
+	require.Equal(t, uint64(50), byw[address.Undef].TotalSent)
+
+	// the wallet is persisted with the record
+	ut = NewUsageTracker(ds, Quota{})
+	u, err := ut.Get(p1)
+	require.NoError(t, err)
+	require.Equal(t, w, u.Wallet)
+}
+
+type throttleEnv struct {
+	ut      *UsageTracker
+	state   deal.ProviderState
+	resumed chan datatransfer.ChannelID
+}
+
+func (e *throttleEnv) Payments() payments.Manager { return nil }
+func (e *throttleEnv) Usage() *UsageTracker       { return e.ut }
+func (e *throttleEnv) ResumeTransfer(chid datatransfer.ChannelID) error {
+	e.resumed <- chid
+	return nil
+}
+func (e *throttleEnv) SendEvent(dealID deal.ProviderDealIdentifier, evt provider.Event, args ...interface{}) error {
+	return nil
+}
+func (e *throttleEnv) Get(dealID deal.ProviderDealIdentifier) (deal.ProviderState, error) {
+	return e.state, nil
+}
+
+func TestRevalidatorThrottle(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	env := &throttleEnv{
+		ut:      NewUsageTracker(ds, Quota{MaxRate: 10000}),
+		resumed: make(chan datatransfer.ChannelID, 1),
+	}
+	chid := datatransfer.ChannelID{Initiator: peer.ID("client"), Responder: peer.ID("provider"), ID: 1}
+	env.state = deal.ProviderState{
+		ChannelID:     chid,
+		Receiver:      chid.Initiator,
+		FundsReceived: big.Zero(),
+		Proposal: deal.Proposal{
+			ID:     deal.ID(1),
+			Params: deal.Params{PricePerByte: big.Zero()},
+		},
+	}
+	pr := NewProviderRevalidator(env)
+	pr.TrackChannel(env.state)
+
+	handled, _, err := pr.OnPullDataSent(chid, 1000)
+	require.True(t, handled)
+	require.NoError(t, err)
+
+	// going faster than the max rate pauses the channel instead of blocking the callback
+	start := time.Now()
+	handled, _, err = pr.OnPullDataSent(chid, 1000)
+	require.True(t, handled)
+	require.Equal(t, datatransfer.ErrPause, err)
+	require.Less(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	select {
+	case resumed := <-env.resumed:
+		require.Equal(t, chid, resumed)
+	case <-time.After(time.Second):
+		t.Fatal("throttled transfer was never resumed")
+	}
+}
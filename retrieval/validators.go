@@ -3,8 +3,10 @@ package retrieval
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
@@ -18,6 +20,7 @@ import (
 	"github.com/myelnet/pop/retrieval/deal"
 	"github.com/myelnet/pop/retrieval/provider"
 	"github.com/myelnet/pop/selectors"
+	"github.com/rs/zerolog/log"
 )
 
 // TODO: make this nicer
@@ -136,6 +139,8 @@ func (rv *ProviderRequestValidator) acceptDeal(d *deal.ProviderState) (deal.Stat
 // build the logic of revalidation -- essentially, access to the node at statemachines
 type RevalidatorEnvironment interface {
 	Payments() payments.Manager
+	Usage() *UsageTracker
+	ResumeTransfer(chid datatransfer.ChannelID) error
 	SendEvent(dealID deal.ProviderDealIdentifier, evt provider.Event, args ...interface{}) error
 	Get(dealID deal.ProviderDealIdentifier) (deal.ProviderState, error)
 }
@@ -186,6 +191,13 @@ func (pr *ProviderRevalidator) UntrackChannel(d deal.ProviderState) {
 	delete(pr.trackedChannels, d.ChannelID)
 }
 
+// TrackedChannels returns the number of transfers currently tracked by this provider
+func (pr *ProviderRevalidator) TrackedChannels() int {
+	pr.trackedChannelsLk.RLock()
+	defer pr.trackedChannelsLk.RUnlock()
+	return len(pr.trackedChannels)
+}
+
 func (pr *ProviderRevalidator) loadDealState(channel *channelData) error {
 	if !channel.reload {
 		return nil
@@ -243,10 +255,26 @@ func (pr *ProviderRevalidator) processPayment(dealID deal.ProviderDealIdentifier
 
 	// Save voucher
 	received, err := pr.env.Payments().AddVoucherInbound(context.TODO(), payment.PaymentChannel, payment.PaymentVoucher, nil, big.Zero())
+	var ife *payments.ErrInsufficientFunds
+	if errors.As(err, &ife) {
+		// the voucher could not be redeemed at settlement so we stop sending data until
+		// the client covers it with funds on chain
+		_ = pr.env.SendEvent(dealID, provider.EventVoucherShortfall, ife.Shortfall())
+		return &deal.Response{
+			ID:          d.ID,
+			Status:      deal.StatusInsufficientFunds,
+			PaymentOwed: paymentOwed(d, d.FundsReceived),
+			Message:     err.Error(),
+		}, datatransfer.ErrPause
+	}
 	if err != nil {
 		_ = pr.env.SendEvent(dealID, provider.EventSaveVoucherFailed, err)
 		return errorDealResponse(dealID, err), err
 	}
+	// attribute the client usage to the wallet paying for it
+	if ci, err := pr.env.Payments().GetChannelInfo(payment.PaymentChannel); err == nil && ci.Direction == payments.DirInbound {
+		_ = pr.env.Usage().SetWallet(dealID.Receiver, ci.Target)
+	}
 
 	totalPaid := big.Add(d.FundsReceived, received)
 
@@ -310,6 +338,18 @@ func errorDealResponse(dealID deal.ProviderDealIdentifier, err error) *deal.Resp
 // request revalidation or nil to continue uninterrupted,
 // other errors will terminate the request
 func (pr *ProviderRevalidator) OnPullDataSent(chid datatransfer.ChannelID, additionalBytesSent uint64) (bool, datatransfer.VoucherResult, error) {
+	pr.trackedChannelsLk.RLock()
+	_, ok := pr.trackedChannels[chid]
+	pr.trackedChannelsLk.RUnlock()
+	if !ok {
+		return false, nil, nil
+	}
+	// The client is always the initiator of a pull request
+	delay, err := pr.env.Usage().Record(chid.Initiator, additionalBytesSent)
+	if err != nil {
+		return true, nil, err
+	}
+
 	pr.trackedChannelsLk.RLock()
 	defer pr.trackedChannelsLk.RUnlock()
 	channel, ok := pr.trackedChannels[chid]
@@ -317,15 +357,30 @@ func (pr *ProviderRevalidator) OnPullDataSent(chid datatransfer.ChannelID, addit
 		return false, nil, nil
 	}
 
-	err := pr.loadDealState(channel)
+	err = pr.loadDealState(channel)
 	if err != nil {
 		return true, nil, err
 	}
 
 	channel.totalSent += additionalBytesSent
 	if channel.pricePerByte.IsZero() || channel.totalSent < channel.interval {
-		return true, nil, pr.env.SendEvent(channel.dealID, provider.EventBlockSent, channel.totalSent)
-	}
+		if err := pr.env.SendEvent(channel.dealID, provider.EventBlockSent, channel.totalSent); err != nil {
+			return true, nil, err
+		}
+		// throttle the transfer if the client is going faster than its quota allows.
+		// We pause the channel instead of blocking the data transfer callback and resume it
+		// once the delay has passed.
+		if delay > 0 {
+			time.AfterFunc(delay, func() {
+				if err := pr.env.ResumeTransfer(chid); err != nil {
+					log.Error().Err(err).Msg("failed to resume throttled transfer")
+				}
+			})
+			return true, nil, datatransfer.ErrPause
+		}
+		return true, nil, nil
+	}
+	// No need to throttle when requesting a payment as the transfer is paused until the client pays
 
 	paymentOwed := big.Mul(abi.NewTokenAmount(int64(channel.totalSent-channel.totalPaidFor)), channel.pricePerByte)
 	err = pr.env.SendEvent(channel.dealID, provider.EventPaymentRequested, channel.totalSent)
@@ -32,6 +32,18 @@ func Key(key string) ipld.Node {
 		})).Node()
 }
 
+// Keys selects the links and all the children associated with the given keys in a Map
+func Keys(keys ...string) ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	return ssb.ExploreUnion(ssb.Matcher(),
+		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			for _, key := range keys {
+				efsb.Insert(key, ssb.ExploreRecursive(selector.RecursionLimitNone(),
+					ssb.ExploreAll(ssb.ExploreRecursiveEdge())))
+			}
+		})).Node()
+}
+
 // Hamt is used to query a HAMT without following the links in deferred nodes
 func Hamt() ipld.Node {
 	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
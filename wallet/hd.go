@@ -0,0 +1,364 @@
+package wallet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-crypto"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// ErrSeedExists is returned when restoring a recovery phrase different from the one already in the wallet
+var ErrSeedExists = errors.New("wallet already has a different recovery phrase")
+
+// HDGapLimit is the number of consecutive addresses without an actor on chain after which we stop
+// looking for more addresses to restore
+const HDGapLimit = 20
+
+// secpN is the order of the secp256k1 curve
+var secpN, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+
+var (
+	hdMasterKey = []byte("Bitcoin seed")
+	hdNamespace = datastore.NewKey("/hdwallet")
+	mnemonicKey = datastore.NewKey("/mnemonic")
+	nextKey     = datastore.NewKey("/next")
+)
+
+// NewMnemonic generates a random 24 words BIP39 recovery phrase
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(256)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// DeriveKey derives the secp256k1 key at the given index of the Filecoin BIP44 path m/44'/461'/0'/0/index
+// from a recovery phrase. Ledger devices derive their addresses from the same path.
+func DeriveKey(mnemonic string, index uint32) (*KeyInfo, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(normalizeMnemonic(mnemonic), "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery phrase: %w", err)
+	}
+	pk, err := derivePrivate(seed, append(append([]uint32{}, ledgerBasePath...), index))
+	if err != nil {
+		return nil, err
+	}
+	return &KeyInfo{
+		KType:      KTSecp256k1,
+		PrivateKey: pk,
+	}, nil
+}
+
+// derivePrivate runs the BIP32 private key derivation for the given path
+func derivePrivate(seed []byte, path []uint32) ([]byte, error) {
+	I := hmacSHA512(hdMasterKey, seed)
+	key, chain := I[:32], I[32:]
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	for _, i := range path {
+		data := make([]byte, 37)
+		if i >= hdHard {
+			copy(data[1:], key)
+		} else {
+			copy(data, compressPubKey(crypto.PublicKey(key)))
+		}
+		binary.BigEndian.PutUint32(data[33:], i)
+
+		I = hmacSHA512(chain, data)
+		il := new(big.Int).SetBytes(I[:32])
+		if il.Cmp(secpN) >= 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", i)
+		}
+		k := il.Add(il, new(big.Int).SetBytes(key))
+		k.Mod(k, secpN)
+
+		key = k.FillBytes(make([]byte, 32))
+		chain = I[32:]
+		if err := validKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func validKey(key []byte) error {
+	k := new(big.Int).SetBytes(key)
+	if k.Sign() == 0 || k.Cmp(secpN) >= 0 {
+		return errors.New("invalid derived key")
+	}
+	return nil
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	h := hmac.New(sha512.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// compressPubKey converts a 65 bytes uncompressed public key to its 33 bytes compressed form
+func compressPubKey(pubk []byte) []byte {
+	c := make([]byte, 33)
+	c[0] = 2 + pubk[64]&1
+	copy(c[1:], pubk[1:33])
+	return c
+}
+
+func normalizeMnemonic(m string) string {
+	return strings.Join(strings.Fields(strings.ToLower(m)), " ")
+}
+
+// HDWallet derives the new secp256k1 keys of the wrapped wallet from a recovery phrase so all the addresses
+// can be restored from it after losing the keystore. Keys created or imported before the phrase was generated
+// cannot be restored from it. The phrase is only persisted if a datastore is given, it should encrypt its values.
+type HDWallet struct {
+	Driver
+
+	ds   datastore.Batching
+	fAPI fil.API
+
+	mu       sync.Mutex
+	mnemonic string
+	next     uint32
+}
+
+// HDOption is an optional configuration of the HD wallet
+type HDOption func(hw *HDWallet)
+
+// WithHDFilAPI sets the filecoin API client used to find which derived addresses were used when restoring
+func WithHDFilAPI(f fil.API) HDOption {
+	return func(hw *HDWallet) {
+		hw.fAPI = f
+	}
+}
+
+// NewHDWallet wraps a local wallet and loads the recovery phrase persisted in the datastore if any.
+// With a nil datastore the phrase is only kept in memory and new keys are random after a restart.
+func NewHDWallet(local Driver, ds datastore.Batching, opts ...HDOption) (*HDWallet, error) {
+	hw := &HDWallet{
+		Driver: local,
+	}
+	for _, opt := range opts {
+		opt(hw)
+	}
+	if ds == nil {
+		return hw, nil
+	}
+	hw.ds = namespace.Wrap(ds, hdNamespace)
+
+	m, err := hw.ds.Get(mnemonicKey)
+	if err == datastore.ErrNotFound {
+		return hw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hw.mnemonic = string(m)
+
+	n, err := hw.ds.Get(nextKey)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	if len(n) > 0 {
+		next, err := strconv.ParseUint(string(n), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		hw.next = uint32(next)
+	}
+	return hw, nil
+}
+
+// HasSeed returns whether new keys are derived from a recovery phrase
+func (hw *HDWallet) HasSeed() bool {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	return hw.mnemonic != ""
+}
+
+// Generate a new recovery phrase to derive the next keys from. It must be written down by the operator
+// as it is the only way to restore the addresses.
+func (hw *HDWallet) Generate() (string, error) {
+	m, err := NewMnemonic()
+	if err != nil {
+		return "", err
+	}
+	if err := hw.Seed(m); err != nil {
+		return "", err
+	}
+	return m, nil
+}
+
+// Seed sets a new recovery phrase generated by the caller to derive the next keys from. The caller is in charge
+// of showing it to the operator.
+func (hw *HDWallet) Seed(mnemonic string) error {
+	mnemonic = normalizeMnemonic(mnemonic)
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return errors.New("invalid recovery phrase")
+	}
+
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if hw.mnemonic != "" {
+		return ErrSeedExists
+	}
+	return hw.setSeed(mnemonic, 0)
+}
+
+// Recover the addresses derived from a recovery phrase. At least count addresses are restored and if a filecoin
+// API is available, we keep deriving addresses until HDGapLimit consecutive ones were never used on chain.
+// Without API we cannot tell which addresses were used so count must cover all the addresses derived from the phrase.
+// The first address becomes the default one if the wallet has none.
+func (hw *HDWallet) Recover(ctx context.Context, mnemonic string, count int) ([]address.Address, error) {
+	mnemonic = normalizeMnemonic(mnemonic)
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errors.New("invalid recovery phrase")
+	}
+
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if hw.mnemonic != "" && hw.mnemonic != mnemonic {
+		return nil, ErrSeedExists
+	}
+
+	var addrs []address.Address
+	var last uint32
+	gap := 0
+	for i := uint32(0); int(i) < count || (hw.fAPI != nil && gap < HDGapLimit); i++ {
+		ki, err := DeriveKey(mnemonic, i)
+		if err != nil {
+			return nil, err
+		}
+		k, err := NewKeyFromKeyInfo(KeyInfo{KType: ki.KType, PrivateKey: ki.PrivateKey, sig: secp{}})
+		if err != nil {
+			return nil, err
+		}
+
+		used := int(i) < count
+		if !used {
+			act, err := hw.fAPI.StateGetActor(ctx, k.Address, fil.EmptyTSK)
+			if err != nil && !strings.Contains(err.Error(), "actor not found") {
+				return nil, fmt.Errorf("failed to check address %s: %w", k.Address, err)
+			}
+			used = act != nil
+		}
+		if !used {
+			gap++
+			continue
+		}
+		gap = 0
+		last = i + 1
+
+		addr, err := hw.Driver.ImportKey(ctx, ki)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if len(addrs) > 0 && hw.Driver.DefaultAddress() == address.Undef {
+		if err := hw.Driver.SetDefaultAddress(addrs[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	next := hw.next
+	if last > next {
+		next = last
+	}
+	if err := hw.setSeed(mnemonic, next); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// NewKey derives the next secp256k1 key from the recovery phrase. Other key types and wallets without a recovery
+// phrase generate random keys.
+func (hw *HDWallet) NewKey(ctx context.Context, kt KeyType) (address.Address, error) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if kt != KTSecp256k1 || hw.mnemonic == "" {
+		return hw.Driver.NewKey(ctx, kt)
+	}
+
+	ki, err := DeriveKey(hw.mnemonic, hw.next)
+	if err != nil {
+		return address.Undef, err
+	}
+	addr, err := hw.Driver.ImportKey(ctx, ki)
+	if err != nil {
+		return address.Undef, err
+	}
+	if err := hw.setSeed(hw.mnemonic, hw.next+1); err != nil {
+		return address.Undef, err
+	}
+	if hw.Driver.DefaultAddress() == address.Undef {
+		if err := hw.Driver.SetDefaultAddress(addr); err != nil {
+			return address.Undef, fmt.Errorf("failed to set new key as default: %v", err)
+		}
+	}
+	return addr, nil
+}
+
+func (hw *HDWallet) setSeed(mnemonic string, next uint32) error {
+	if hw.ds != nil {
+		if err := hw.ds.Put(mnemonicKey, []byte(mnemonic)); err != nil {
+			return err
+		}
+		if err := hw.ds.Put(nextKey, []byte(strconv.FormatUint(uint64(next), 10))); err != nil {
+			return err
+		}
+	}
+	hw.mnemonic = mnemonic
+	hw.next = next
+	return nil
+}
+
+// MigrateHDSeed moves the recovery phrase persisted in plain text by older versions to the given datastore
+// and removes it from the plain text one. The phrase is only removed if the given datastore is nil.
+// It returns whether a phrase was found.
+func MigrateHDSeed(plain datastore.Batching, to datastore.Batching) (bool, error) {
+	from := namespace.Wrap(plain, hdNamespace)
+	m, err := from.Get(mnemonicKey)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if to != nil {
+		to = namespace.Wrap(to, hdNamespace)
+		if err := to.Put(mnemonicKey, m); err != nil {
+			return true, err
+		}
+		n, err := from.Get(nextKey)
+		if err == nil {
+			err = to.Put(nextKey, n)
+		}
+		if err != nil && err != datastore.ErrNotFound {
+			return true, err
+		}
+	}
+	if err := from.Delete(nextKey); err != nil && err != datastore.ErrNotFound {
+		return true, err
+	}
+	return true, from.Delete(mnemonicKey)
+}
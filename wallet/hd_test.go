@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerivePrivate(t *testing.T) {
+	// BIP32 test vector 1
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		path []uint32
+		key  string
+	}{
+		{[]uint32{}, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{[]uint32{hdHard}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{[]uint32{hdHard, 1}, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{[]uint32{hdHard, 1, hdHard | 2}, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+		{[]uint32{hdHard, 1, hdHard | 2, 2}, "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+	}
+	for _, tc := range testCases {
+		key, err := derivePrivate(seed, tc.path)
+		require.NoError(t, err)
+		require.Equal(t, tc.key, hex.EncodeToString(key))
+	}
+}
+
+func TestHDWallet(t *testing.T) {
+	ctx := context.Background()
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	hw, err := NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), ds)
+	require.NoError(t, err)
+	require.False(t, hw.HasSeed())
+
+	mnemonic, err := hw.Generate()
+	require.NoError(t, err)
+	_, err = hw.Generate()
+	require.ErrorIs(t, err, ErrSeedExists)
+
+	var addrs []address.Address
+	for i := 0; i < 3; i++ {
+		addr, err := hw.NewKey(ctx, KTSecp256k1)
+		require.NoError(t, err)
+		addrs = append(addrs, addr)
+	}
+	require.Equal(t, addrs[0], hw.DefaultAddress())
+
+	// The derivation index survives a restart
+	hw, err = NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), ds)
+	require.NoError(t, err)
+	require.True(t, hw.HasSeed())
+	addr, err := hw.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	require.NotContains(t, addrs, addr)
+	addrs = append(addrs, addr)
+
+	// Restore all the addresses on a new disk
+	api := fil.NewMockLotusAPI()
+	restored, err := NewHDWallet(
+		NewFromKeystore(keystore.NewMemKeystore()),
+		dss.MutexWrap(datastore.NewMapDatastore()),
+		WithHDFilAPI(api),
+	)
+	require.NoError(t, err)
+
+	_, err = restored.Recover(ctx, "not a valid recovery phrase", 1)
+	require.Error(t, err)
+
+	raddrs, err := restored.Recover(ctx, mnemonic, 4)
+	require.NoError(t, err)
+	require.Equal(t, addrs, raddrs)
+	require.Equal(t, addrs[0], restored.DefaultAddress())
+
+	list, err := restored.List()
+	require.NoError(t, err)
+	require.ElementsMatch(t, addrs, list)
+
+	// New keys are derived after the restored ones
+	ki, err := DeriveKey(mnemonic, 4)
+	require.NoError(t, err)
+	ki.sig = secp{}
+	next, err := NewKeyFromKeyInfo(*ki)
+	require.NoError(t, err)
+
+	addr, err = restored.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	require.Equal(t, next.Address, addr)
+
+	other, err := NewMnemonic()
+	require.NoError(t, err)
+	_, err = restored.Recover(ctx, other, 1)
+	require.ErrorIs(t, err, ErrSeedExists)
+}
+
+func TestHDSeedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	mnemonic, err := NewMnemonic()
+	require.NoError(t, err)
+
+	// Without datastore the phrase is only kept in memory
+	hw, err := NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), nil)
+	require.NoError(t, err)
+	require.Error(t, hw.Seed("not a valid recovery phrase"))
+	require.NoError(t, hw.Seed(mnemonic))
+	require.ErrorIs(t, hw.Seed(mnemonic), ErrSeedExists)
+
+	ki, err := DeriveKey(mnemonic, 0)
+	require.NoError(t, err)
+	ki.sig = secp{}
+	first, err := NewKeyFromKeyInfo(*ki)
+	require.NoError(t, err)
+
+	addr, err := hw.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	require.Equal(t, first.Address, addr)
+
+	// A plain text phrase left by older versions is moved to the given store
+	plain := dss.MutexWrap(datastore.NewMapDatastore())
+	old, err := NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), plain)
+	require.NoError(t, err)
+	require.NoError(t, old.Seed(mnemonic))
+
+	secret := dss.MutexWrap(datastore.NewMapDatastore())
+	found, err := MigrateHDSeed(plain, secret)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	old, err = NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), plain)
+	require.NoError(t, err)
+	require.False(t, old.HasSeed())
+
+	hw, err = NewHDWallet(NewFromKeystore(keystore.NewMemKeystore()), secret)
+	require.NoError(t, err)
+	require.True(t, hw.HasSeed())
+
+	// Nothing left to migrate
+	found, err = MigrateHDSeed(plain, nil)
+	require.NoError(t, err)
+	require.False(t, found)
+}
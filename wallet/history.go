@@ -0,0 +1,293 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/rs/zerolog/log"
+)
+
+// TxStatus is the state of a message we pushed
+type TxStatus string
+
+const (
+	// TxPending is waiting to be included on chain
+	TxPending TxStatus = "pending"
+	// TxExecuted was executed successfully
+	TxExecuted TxStatus = "executed"
+	// TxFailed was executed with a non zero exit code
+	TxFailed TxStatus = "failed"
+	// TxReplaced was replaced by a message with the same nonce and different gas values which was executed instead
+	TxReplaced TxStatus = "replaced"
+)
+
+// TxRecord is a message signed and pushed by the node
+type TxRecord struct {
+	Cid      cid.Cid
+	From     address.Address
+	To       address.Address
+	Value    abi.TokenAmount
+	Method   abi.MethodNum
+	Kind     string
+	Status   TxStatus
+	ExitCode int64
+	// GasUsed is the gas consumed by the message once executed
+	GasUsed    int64
+	GasFeeCap  abi.TokenAmount
+	GasPremium abi.TokenAmount
+	// Fee is the amount paid for the gas used at the base fee of the tipset the message was executed in
+	Fee abi.TokenAmount
+	// ReplacedBy is the message executed instead of this one if it was replaced
+	ReplacedBy cid.Cid
+	// Height is the epoch of the tipset the message was executed in
+	Height   abi.ChainEpoch
+	PushedAt time.Time
+}
+
+// History records every message pushed by the node and updates it once the message is executed
+type History struct {
+	ds datastore.Batching
+}
+
+// NewHistory creates a new history persisted in the given datastore
+func NewHistory(ds datastore.Batching) *History {
+	return &History{
+		ds: namespace.Wrap(ds, datastore.NewKey("/txhistory")),
+	}
+}
+
+// API wraps a filecoin API so the messages pushed with it are recorded with their result
+func (h *History) API(api fil.API) fil.API {
+	return &historyAPI{API: api, h: h}
+}
+
+// Record a message we just pushed
+func (h *History) Record(ctx context.Context, api fil.API, smsg *fil.SignedMessage) error {
+	msg := smsg.Message
+	return h.put(&TxRecord{
+		Cid:        smsg.Cid(),
+		From:       msg.From,
+		To:         msg.To,
+		Value:      msg.Value,
+		Method:     msg.Method,
+		Kind:       txKind(ctx, api, &msg),
+		Status:     TxPending,
+		GasFeeCap:  msg.GasFeeCap,
+		GasPremium: msg.GasPremium,
+		PushedAt:   time.Now(),
+	})
+}
+
+// Update a recorded message with the result of its execution. Messages we didn't push are ignored.
+func (h *History) Update(ctx context.Context, api fil.API, mcid cid.Cid, lookup *fil.MsgLookup) error {
+	rec, err := h.Get(mcid)
+	if err == datastore.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rec.Status = TxExecuted
+	if lookup.Receipt.ExitCode != 0 {
+		rec.Status = TxFailed
+	}
+	feeCap, premium := rec.GasFeeCap, rec.GasPremium
+	if lookup.Message.Defined() && !lookup.Message.Equals(mcid) {
+		rec.Status = TxReplaced
+		rec.ReplacedBy = lookup.Message
+		// the replacing message only differs by its gas values
+		if msg, err := api.ChainGetMessage(ctx, lookup.Message); err == nil && msg != nil {
+			feeCap, premium = msg.GasFeeCap, msg.GasPremium
+		}
+	}
+	rec.ExitCode = int64(lookup.Receipt.ExitCode)
+	rec.GasUsed = lookup.Receipt.GasUsed
+	rec.Height = lookup.Height
+	baseFee, err := tipsetBaseFee(ctx, api, lookup.TipSet)
+	if err != nil {
+		log.Error().Err(err).Str("mcid", mcid.String()).Msg("failed to get base fee for message fee")
+	} else {
+		rec.Fee = gasFee(lookup.Receipt.GasUsed, baseFee, feeCap, premium)
+	}
+	return h.put(rec)
+}
+
+// Get a recorded message
+func (h *History) Get(mcid cid.Cid) (*TxRecord, error) {
+	data, err := h.ds.Get(datastore.NewKey(mcid.String()))
+	if err != nil {
+		return nil, err
+	}
+	var rec TxRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// List the recorded messages sent from the given address, or all of them if undefined, most recent first.
+// limit is the maximum number of records to return, 0 returns all of them.
+func (h *History) List(from address.Address, limit int) ([]TxRecord, error) {
+	res, err := h.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]TxRecord, 0, len(entries))
+	for _, e := range entries {
+		var rec TxRecord
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			return nil, err
+		}
+		if from != address.Undef && rec.From != from {
+			continue
+		}
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PushedAt.After(list[j].PushedAt)
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (h *History) put(rec *TxRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return h.ds.Put(datastore.NewKey(rec.Cid.String()), data)
+}
+
+// tipsetBaseFee returns the base fee messages were charged when executed in the given tipset
+func tipsetBaseFee(ctx context.Context, api fil.API, tsk fil.TipSetKey) (abi.TokenAmount, error) {
+	cids := tsk.Cids()
+	if len(cids) == 0 {
+		return abi.TokenAmount{}, fmt.Errorf("no tipset in message lookup")
+	}
+	raw, err := api.ChainReadObj(ctx, cids[0])
+	if err != nil {
+		return abi.TokenAmount{}, err
+	}
+	var bh fil.BlockHeader
+	if err := bh.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return abi.TokenAmount{}, err
+	}
+	return bh.ParentBaseFee, nil
+}
+
+// gasFee is the amount paid for the gas used, the premium is capped so the price never exceeds the fee cap
+func gasFee(gasUsed int64, baseFee, feeCap, premium abi.TokenAmount) abi.TokenAmount {
+	price := baseFee
+	if !premium.Nil() {
+		price = big.Add(baseFee, premium)
+	}
+	if !feeCap.Nil() && price.GreaterThan(feeCap) {
+		price = feeCap
+	}
+	return big.Mul(big.NewInt(gasUsed), price)
+}
+
+// txKind describes what a message is for based on the actor it is sent to
+func txKind(ctx context.Context, api fil.API, msg *fil.Message) string {
+	switch msg.To {
+	case builtin.InitActorAddr:
+		return "paych create"
+	case builtin.StorageMarketActorAddr:
+		switch msg.Method {
+		case builtin.MethodsMarket.AddBalance:
+			return "market add funds"
+		case builtin.MethodsMarket.WithdrawBalance:
+			return "market withdraw"
+		case builtin.MethodsMarket.PublishStorageDeals:
+			return "publish deals"
+		}
+	}
+	var code cid.Cid
+	if act, err := api.StateGetActor(ctx, msg.To, fil.EmptyTSK); err == nil && act != nil {
+		code = act.Code
+	}
+	switch code {
+	case builtin.PaymentChannelActorCodeID:
+		switch msg.Method {
+		case builtin.MethodSend:
+			return "paych add funds"
+		case builtin.MethodsPaych.UpdateChannelState:
+			return "paych update"
+		case builtin.MethodsPaych.Settle:
+			return "paych settle"
+		case builtin.MethodsPaych.Collect:
+			return "paych collect"
+		}
+	case builtin.MultisigActorCodeID:
+		switch msg.Method {
+		case builtin.MethodsMultisig.Propose:
+			return "msig propose"
+		case builtin.MethodsMultisig.Approve:
+			return "msig approve"
+		case builtin.MethodsMultisig.Cancel:
+			return "msig cancel"
+		}
+	}
+	if msg.Method == builtin.MethodSend {
+		return "transfer"
+	}
+	return fmt.Sprintf("method %d", msg.Method)
+}
+
+// historyAPI records the messages pushed through the wrapped API and their execution results
+type historyAPI struct {
+	fil.API
+	h *History
+}
+
+func (a *historyAPI) MpoolPush(ctx context.Context, smsg *fil.SignedMessage) (cid.Cid, error) {
+	c, err := a.API.MpoolPush(ctx, smsg)
+	if err != nil {
+		return c, err
+	}
+	if err := a.h.Record(ctx, a.API, smsg); err != nil {
+		log.Error().Err(err).Str("mcid", c.String()).Msg("failed to record message in history")
+	}
+	return c, nil
+}
+
+func (a *historyAPI) StateSearchMsg(ctx context.Context, c cid.Cid) (*fil.MsgLookup, error) {
+	lookup, err := a.API.StateSearchMsg(ctx, c)
+	if err != nil || lookup == nil {
+		return lookup, err
+	}
+	if err := a.h.Update(ctx, a.API, c, lookup); err != nil {
+		log.Error().Err(err).Str("mcid", c.String()).Msg("failed to update message in history")
+	}
+	return lookup, nil
+}
+
+func (a *historyAPI) StateWaitMsg(ctx context.Context, c cid.Cid, conf uint64) (*fil.MsgLookup, error) {
+	lookup, err := a.API.StateWaitMsg(ctx, c, conf)
+	if err != nil || lookup == nil {
+		return lookup, err
+	}
+	if err := a.h.Update(ctx, a.API, c, lookup); err != nil {
+		log.Error().Err(err).Str("mcid", c.String()).Msg("failed to update message in history")
+	}
+	return lookup, nil
+}
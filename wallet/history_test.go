@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mapi := fil.NewMockLotusAPI()
+	mapi.SetActor(&fil.Actor{Code: builtin.PaymentChannelActorCodeID})
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	h := NewHistory(ds)
+	api := h.API(mapi)
+
+	w := NewFromKeystore(keystore.NewMemKeystore(), WithFilAPI(api))
+	from, err := w.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	to, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	push := func(method uint64) *fil.SignedMessage {
+		smsg, err := SignMessage(ctx, w, &fil.Message{
+			From:       from,
+			To:         to,
+			Value:      big.NewInt(100),
+			Method:     abi.MethodNum(method),
+			GasLimit:   1000,
+			GasFeeCap:  big.NewInt(10),
+			GasPremium: big.NewInt(1),
+		})
+		require.NoError(t, err)
+		_, err = api.MpoolPush(ctx, smsg)
+		require.NoError(t, err)
+		return smsg
+	}
+
+	addFunds := push(uint64(builtin.MethodSend))
+	settle := push(uint64(builtin.MethodsPaych.Settle))
+	collect := push(uint64(builtin.MethodsPaych.Collect))
+
+	// The fees are paid at the base fee of the tipset the messages were executed in
+	bh := &fil.BlockHeader{
+		Miner:                 to,
+		Parents:               []cid.Cid{settle.Cid()},
+		ParentWeight:          big.Zero(),
+		ParentStateRoot:       settle.Cid(),
+		ParentMessageReceipts: settle.Cid(),
+		Messages:              settle.Cid(),
+		ParentBaseFee:         big.NewInt(5),
+	}
+	raw, err := bh.Serialize()
+	require.NoError(t, err)
+	mapi.SetObject(raw)
+	tsk := fil.NewTipSetKey(settle.Cid())
+
+	rec, err := h.Get(settle.Cid())
+	require.NoError(t, err)
+	require.Equal(t, TxPending, rec.Status)
+	require.Equal(t, "paych settle", rec.Kind)
+
+	go mapi.SetMsgLookup(&fil.MsgLookup{Receipt: fil.MessageReceipt{GasUsed: 800}, TipSet: tsk, Height: 120})
+	_, err = api.StateWaitMsg(ctx, addFunds.Cid(), 5)
+	require.NoError(t, err)
+
+	go mapi.SetMsgLookup(&fil.MsgLookup{Receipt: fil.MessageReceipt{ExitCode: 16, GasUsed: 500}, TipSet: tsk, Height: 121})
+	_, err = api.StateWaitMsg(ctx, settle.Cid(), 5)
+	require.NoError(t, err)
+
+	// The collect message was replaced with a gas bump
+	go mapi.SetMsgLookup(&fil.MsgLookup{Message: addFunds.Cid(), Receipt: fil.MessageReceipt{GasUsed: 300}, TipSet: tsk, Height: 122})
+	_, err = api.StateWaitMsg(ctx, collect.Cid(), 5)
+	require.NoError(t, err)
+	rec, err = h.Get(collect.Cid())
+	require.NoError(t, err)
+	require.Equal(t, TxReplaced, rec.Status)
+	require.Equal(t, addFunds.Cid(), rec.ReplacedBy)
+
+	// The history is persisted and most recent first
+	list, err := NewHistory(ds).List(from, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.Equal(t, collect.Cid(), list[0].Cid)
+	require.Equal(t, settle.Cid(), list[1].Cid)
+	require.Equal(t, TxFailed, list[1].Status)
+	require.Equal(t, int64(16), list[1].ExitCode)
+	require.Equal(t, big.NewInt(500*6), list[1].Fee)
+
+	require.Equal(t, "paych add funds", list[2].Kind)
+	require.Equal(t, TxExecuted, list[2].Status)
+	require.Equal(t, int64(800), list[2].GasUsed)
+	require.Equal(t, big.NewInt(800*6), list[2].Fee)
+	require.Equal(t, abi.ChainEpoch(120), list[2].Height)
+
+	// The premium is capped by the fee cap
+	require.Equal(t, big.NewInt(800*10), gasFee(800, big.NewInt(20), big.NewInt(10), big.NewInt(1)))
+
+	list, err = h.List(from, 1)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	list, err = h.List(to, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 0)
+}
@@ -0,0 +1,217 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	fil "github.com/myelnet/pop/filecoin"
+	ledgerfil "github.com/whyrusleeping/ledger-filecoin-go"
+)
+
+// ErrLedgerUnsupported is returned when asking a Ledger device to do something the Filecoin app cannot do.
+// The app only signs chain messages it can display for approval so vouchers and deal proposals must be
+// signed with a local key.
+var ErrLedgerUnsupported = errors.New("operation not supported by Ledger device")
+
+const hdHard = 0x80000000
+
+// ledgerBasePath is the BIP44 derivation path for Filecoin accounts: m/44'/461'/0'/0
+var ledgerBasePath = []uint32{hdHard | 44, hdHard | 461, hdHard, 0}
+
+// LedgerApp is the subset of the Ledger Filecoin app we need to derive addresses and sign messages
+type LedgerApp interface {
+	// PublicKey returns the uncompressed secp256k1 public key for the given derivation path
+	PublicKey(path []uint32) ([]byte, error)
+	// SignMessage signs a CBOR encoded chain message once approved on the device
+	SignMessage(path []uint32, msg []byte) ([]byte, error)
+	Close() error
+}
+
+// OpenLedger connects with the Filecoin app running on the first Ledger device plugged in
+func OpenLedger() (LedgerApp, error) {
+	fl, err := ledgerfil.FindLedgerFilecoinApp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Ledger device: %w", err)
+	}
+	return ledgerDevice{fl}, nil
+}
+
+type ledgerDevice struct {
+	*ledgerfil.LedgerFilecoin
+}
+
+func (d ledgerDevice) PublicKey(path []uint32) ([]byte, error) {
+	pubk, _, _, err := d.GetAddressPubKeySECP256K1(path)
+	return pubk, err
+}
+
+func (d ledgerDevice) SignMessage(path []uint32, msg []byte) ([]byte, error) {
+	sig, err := d.SignSECP256K1(path, msg)
+	if err != nil {
+		return nil, err
+	}
+	return sig.SignatureBytes(), nil
+}
+
+// LedgerWallet signs with the keys of a Ledger device so their private keys never touch the machine.
+// Any other address is handled by the wrapped local wallet.
+type LedgerWallet struct {
+	Driver
+
+	fAPI fil.API
+	open func() (LedgerApp, error)
+
+	mu sync.Mutex
+	// paths are the derivation paths of the addresses held by the device
+	paths map[address.Address][]uint32
+	addrs []address.Address
+}
+
+// LedgerOption is an optional configuration of the Ledger wallet
+type LedgerOption func(lw *LedgerWallet)
+
+// WithLedgerFilAPI sets the filecoin API client used to transfer funds from the device addresses
+func WithLedgerFilAPI(f fil.API) LedgerOption {
+	return func(lw *LedgerWallet) {
+		lw.fAPI = f
+	}
+}
+
+// WithLedgerApp replaces how we connect with the device. Defaults to OpenLedger.
+func WithLedgerApp(open func() (LedgerApp, error)) LedgerOption {
+	return func(lw *LedgerWallet) {
+		lw.open = open
+	}
+}
+
+// NewFromLedger derives the given number of accounts from a connected Ledger device and adds them
+// to the local wallet. The device must be plugged in with the Filecoin app open.
+func NewFromLedger(local Driver, accounts int, opts ...LedgerOption) (*LedgerWallet, error) {
+	lw := &LedgerWallet{
+		Driver: local,
+		open:   OpenLedger,
+		paths:  make(map[address.Address][]uint32),
+	}
+	for _, opt := range opts {
+		opt(lw)
+	}
+
+	app, err := lw.open()
+	if err != nil {
+		return nil, err
+	}
+	defer app.Close()
+
+	for i := 0; i < accounts; i++ {
+		path := append(append([]uint32{}, ledgerBasePath...), uint32(i))
+		pubk, err := app.PublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive account %d: %w", i, err)
+		}
+		addr, err := address.NewSecp256k1Address(pubk)
+		if err != nil {
+			return nil, err
+		}
+		lw.paths[addr] = path
+		lw.addrs = append(lw.addrs, addr)
+	}
+	return lw, nil
+}
+
+// LedgerAddresses returns the addresses held by the device
+func (lw *LedgerWallet) LedgerAddresses() []address.Address {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return append([]address.Address{}, lw.addrs...)
+}
+
+func (lw *LedgerWallet) ledgerPath(addr address.Address) ([]uint32, bool) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	path, ok := lw.paths[addr]
+	return path, ok
+}
+
+// List all the addresses in the local wallet followed by the ones held by the device
+func (lw *LedgerWallet) List() ([]address.Address, error) {
+	list, err := lw.Driver.List()
+	if err != nil {
+		return nil, err
+	}
+	return append(list, lw.LedgerAddresses()...), nil
+}
+
+// SetDefaultAddress only accepts local addresses as the default one must be able to sign vouchers
+func (lw *LedgerWallet) SetDefaultAddress(addr address.Address) error {
+	if _, ok := lw.ledgerPath(addr); ok {
+		return ErrLedgerUnsupported
+	}
+	return lw.Driver.SetDefaultAddress(addr)
+}
+
+// ExportKey fails for device addresses as their private keys cannot leave the device
+func (lw *LedgerWallet) ExportKey(ctx context.Context, addr address.Address) (*KeyInfo, error) {
+	if _, ok := lw.ledgerPath(addr); ok {
+		return nil, ErrLedgerUnsupported
+	}
+	return lw.Driver.ExportKey(ctx, addr)
+}
+
+// Sign arbitrary bytes with a local key. Device addresses can only sign chain messages with SignMessage.
+func (lw *LedgerWallet) Sign(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+	if _, ok := lw.ledgerPath(addr); ok {
+		return nil, ErrLedgerUnsupported
+	}
+	return lw.Driver.Sign(ctx, addr, msg)
+}
+
+// SignMessage asks the device to sign a message sent from one of its addresses. The operator must approve
+// the message on the device.
+func (lw *LedgerWallet) SignMessage(ctx context.Context, msg *fil.Message) (*fil.SignedMessage, error) {
+	path, ok := lw.ledgerPath(msg.From)
+	if !ok {
+		return SignMessage(ctx, lw.Driver, msg)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+
+	app, err := lw.open()
+	if err != nil {
+		return nil, err
+	}
+	defer app.Close()
+
+	sig, err := app.SignMessage(path, buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Ledger failed to sign message: %w", err)
+	}
+
+	return &fil.SignedMessage{
+		Message: *msg,
+		Signature: crypto.Signature{
+			Type: crypto.SigTypeSecp256k1,
+			Data: sig,
+		},
+	}, nil
+}
+
+// Transfer from an address in the local wallet or on the device to any given address
+func (lw *LedgerWallet) Transfer(ctx context.Context, from address.Address, to address.Address, amount string) error {
+	if _, ok := lw.ledgerPath(from); !ok {
+		return lw.Driver.Transfer(ctx, from, to, amount)
+	}
+	if lw.fAPI == nil {
+		return ErrNoAPI
+	}
+	return transfer(ctx, lw.fAPI, lw, from, to, amount)
+}
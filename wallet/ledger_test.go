@@ -0,0 +1,104 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+// mockLedger derives a secp key for each path and signs the messages it receives like the device would
+type mockLedger struct {
+	keys map[uint32][]byte
+}
+
+func (l *mockLedger) key(path []uint32) ([]byte, error) {
+	i := path[len(path)-1]
+	pk, ok := l.keys[i]
+	if !ok {
+		var err error
+		pk, err = secp{}.GenPrivate()
+		if err != nil {
+			return nil, err
+		}
+		l.keys[i] = pk
+	}
+	return pk, nil
+}
+
+func (l *mockLedger) PublicKey(path []uint32) ([]byte, error) {
+	pk, err := l.key(path)
+	if err != nil {
+		return nil, err
+	}
+	return secp{}.ToPublic(pk)
+}
+
+func (l *mockLedger) SignMessage(path []uint32, msg []byte) ([]byte, error) {
+	var m fil.Message
+	if err := m.UnmarshalCBOR(bytes.NewReader(msg)); err != nil {
+		return nil, err
+	}
+	pk, err := l.key(path)
+	if err != nil {
+		return nil, err
+	}
+	return secp{}.Sign(pk, m.Cid().Bytes())
+}
+
+func (l *mockLedger) Close() error {
+	return nil
+}
+
+func TestLedgerWallet(t *testing.T) {
+	ctx := context.Background()
+
+	local := NewFromKeystore(keystore.NewMemKeystore())
+	localAddr, err := local.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+
+	app := &mockLedger{keys: make(map[uint32][]byte)}
+	w, err := NewFromLedger(local, 2, WithLedgerApp(func() (LedgerApp, error) {
+		return app, nil
+	}))
+	require.NoError(t, err)
+
+	ledgerAddrs := w.LedgerAddresses()
+	require.Len(t, ledgerAddrs, 2)
+	require.NotEqual(t, ledgerAddrs[0], ledgerAddrs[1])
+
+	list, err := w.List()
+	require.NoError(t, err)
+	require.Equal(t, append([]address.Address{localAddr}, ledgerAddrs...), list)
+
+	// The default address stays local so it can sign vouchers
+	require.Equal(t, localAddr, w.DefaultAddress())
+	require.ErrorIs(t, w.SetDefaultAddress(ledgerAddrs[0]), ErrLedgerUnsupported)
+
+	_, err = w.ExportKey(ctx, ledgerAddrs[0])
+	require.ErrorIs(t, err, ErrLedgerUnsupported)
+	_, err = w.Sign(ctx, ledgerAddrs[0], []byte("proposal"))
+	require.ErrorIs(t, err, ErrLedgerUnsupported)
+
+	for _, from := range []address.Address{ledgerAddrs[1], localAddr} {
+		msg := &fil.Message{
+			To:         ledgerAddrs[0],
+			From:       from,
+			Value:      big.NewInt(1000),
+			GasLimit:   222,
+			GasFeeCap:  big.NewInt(333),
+			GasPremium: big.NewInt(333),
+		}
+		smsg, err := SignMessage(ctx, w, msg)
+		require.NoError(t, err)
+
+		ok, err := w.Verify(ctx, from, msg.Cid().Bytes(), &smsg.Signature)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
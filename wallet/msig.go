@@ -0,0 +1,321 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/multisig"
+	"github.com/filecoin-project/specs-actors/v4/actors/util/adt"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	fil "github.com/myelnet/pop/filecoin"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrProposalNotFound is returned when a multisig transaction is not one we're tracking
+var ErrProposalNotFound = errors.New("multisig proposal not found")
+
+// MsigProposal is a multisig transaction proposed or approved from our wallet and waiting for more approvals
+type MsigProposal struct {
+	Msig      address.Address
+	TxnID     int64
+	To        address.Address
+	Value     abi.TokenAmount
+	Method    abi.MethodNum
+	Params    []byte
+	Proposer  address.Address
+	Approvals []address.Address
+	// Applied is true once enough signers approved and the transaction was executed
+	Applied bool
+}
+
+// Multisig proposes and approves the transactions of multisig wallets one of our addresses is a signer of.
+// It only tracks the pending proposals sent from this wallet, the ones proposed elsewhere are tracked
+// once we approve them.
+type Multisig struct {
+	w   Driver
+	api fil.API
+	ds  datastore.Batching
+}
+
+// NewMultisig creates a new multisig client signing with the given wallet and persisting pending proposals
+func NewMultisig(w Driver, api fil.API, ds datastore.Batching) *Multisig {
+	return &Multisig{
+		w:   w,
+		api: api,
+		ds:  namespace.Wrap(ds, datastore.NewKey("/msig")),
+	}
+}
+
+// Propose a transaction from the multisig wallet. If the wallet only requires one approval the transaction is
+// executed right away, otherwise it is tracked until other signers approve it.
+func (m *Multisig) Propose(ctx context.Context, msig, from, to address.Address, value abi.TokenAmount, method abi.MethodNum, params []byte) (*MsigProposal, error) {
+	if m.api == nil {
+		return nil, ErrNoAPI
+	}
+	enc, err := serializeParams(&multisig.ProposeParams{
+		To:     to,
+		Value:  value,
+		Method: method,
+		Params: params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := sendMessage(ctx, m.api, m.w, &fil.Message{
+		To:     msig,
+		From:   from,
+		Value:  abi.NewTokenAmount(0),
+		Method: builtin.MethodsMultisig.Propose,
+		Params: enc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ret multisig.ProposeReturn
+	if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+		return nil, fmt.Errorf("failed to decode propose return: %w", err)
+	}
+	if ret.Applied && ret.Code != 0 {
+		return nil, fmt.Errorf("multisig transaction failed (exit code %d)", ret.Code)
+	}
+
+	p := &MsigProposal{
+		Msig:      msig,
+		TxnID:     int64(ret.TxnID),
+		To:        to,
+		Value:     value,
+		Method:    method,
+		Params:    params,
+		Proposer:  from,
+		Approvals: []address.Address{from},
+		Applied:   ret.Applied,
+	}
+	if p.Applied {
+		return p, nil
+	}
+	return p, m.put(p)
+}
+
+// ProposeMarketFunds proposes to add funds from the multisig wallet to the storage market escrow of a client
+// address so it can pay for storage deals
+func (m *Multisig) ProposeMarketFunds(ctx context.Context, msig, from, client address.Address, amount abi.TokenAmount) (*MsigProposal, error) {
+	params, err := serializeParams(&client)
+	if err != nil {
+		return nil, err
+	}
+	return m.Propose(ctx, msig, from, builtin.StorageMarketActorAddr, amount, builtin.MethodsMarket.AddBalance, params)
+}
+
+// Approve a pending transaction of the multisig wallet. The transaction is executed if ours is the last
+// approval required.
+func (m *Multisig) Approve(ctx context.Context, msig, from address.Address, txnID int64) (*MsigProposal, error) {
+	if m.api == nil {
+		return nil, ErrNoAPI
+	}
+	lookup, err := m.sendTxnID(ctx, msig, from, txnID, builtin.MethodsMultisig.Approve)
+	if err != nil {
+		return nil, err
+	}
+	var ret multisig.ApproveReturn
+	if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+		return nil, fmt.Errorf("failed to decode approve return: %w", err)
+	}
+
+	p, err := m.get(msig, txnID)
+	if errors.Is(err, ErrProposalNotFound) {
+		// The proposal was made by another signer so we only know about it from now on
+		p = &MsigProposal{Msig: msig, TxnID: txnID}
+	} else if err != nil {
+		return nil, err
+	}
+	p.Approvals = append(p.Approvals, from)
+	p.Applied = ret.Applied
+
+	if ret.Applied {
+		if err := m.ds.Delete(proposalKey(msig, txnID)); err != nil && err != datastore.ErrNotFound {
+			return nil, err
+		}
+		if ret.Code != 0 {
+			return p, fmt.Errorf("multisig transaction failed (exit code %d)", ret.Code)
+		}
+		return p, nil
+	}
+	return p, m.put(p)
+}
+
+// Cancel a pending transaction we proposed
+func (m *Multisig) Cancel(ctx context.Context, msig, from address.Address, txnID int64) error {
+	if m.api == nil {
+		return ErrNoAPI
+	}
+	if _, err := m.sendTxnID(ctx, msig, from, txnID, builtin.MethodsMultisig.Cancel); err != nil {
+		return err
+	}
+	err := m.ds.Delete(proposalKey(msig, txnID))
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Pending lists the transactions waiting for approvals for the given multisig wallet
+// or for all of them if the address is undefined. Proposals other signers executed or cancelled
+// in the meantime are no longer pending on chain so we stop tracking them.
+func (m *Multisig) Pending(ctx context.Context, msig address.Address) ([]MsigProposal, error) {
+	q := dsq.Query{}
+	if msig != address.Undef {
+		q.Prefix = "/" + msig.String() + "/"
+	}
+	res, err := m.ds.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]MsigProposal, 0, len(entries))
+	for _, e := range entries {
+		var p MsigProposal
+		if err := json.Unmarshal(e.Value, &p); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	if m.api != nil {
+		list, err = m.reconcile(ctx, list)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Msig != list[j].Msig {
+			return list[i].Msig.String() < list[j].Msig.String()
+		}
+		return list[i].TxnID < list[j].TxnID
+	})
+	return list, nil
+}
+
+// reconcile drops the proposals which are no longer in the pending transactions of their multisig actor
+func (m *Multisig) reconcile(ctx context.Context, list []MsigProposal) ([]MsigProposal, error) {
+	store := adt.WrapStore(ctx, cbor.NewCborStore(&chainBlockstore{ctx, m.api}))
+	txns := make(map[address.Address]*adt.Map)
+	pending := list[:0]
+	for _, p := range list {
+		ptx, ok := txns[p.Msig]
+		if !ok {
+			var err error
+			ptx, err = m.pendingTxns(ctx, store, p.Msig)
+			if err != nil {
+				return nil, err
+			}
+			txns[p.Msig] = ptx
+		}
+		var txn multisig.Transaction
+		found, err := ptx.Get(multisig.TxnID(p.TxnID), &txn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load multisig transaction %d: %w", p.TxnID, err)
+		}
+		if found {
+			pending = append(pending, p)
+			continue
+		}
+		if err := m.ds.Delete(proposalKey(p.Msig, p.TxnID)); err != nil && err != datastore.ErrNotFound {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// pendingTxns loads the pending transactions of a multisig actor from the chain
+func (m *Multisig) pendingTxns(ctx context.Context, store adt.Store, msig address.Address) (*adt.Map, error) {
+	act, err := m.api.StateGetActor(ctx, msig, fil.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	var st multisig.State
+	if err := store.Get(ctx, act.Head, &st); err != nil {
+		return nil, fmt.Errorf("failed to load multisig state: %w", err)
+	}
+	return adt.AsMap(store, st.PendingTxns, builtin.DefaultHamtBitwidth)
+}
+
+func (m *Multisig) sendTxnID(ctx context.Context, msig, from address.Address, txnID int64, method abi.MethodNum) (*fil.MsgLookup, error) {
+	enc, err := serializeParams(&multisig.TxnIDParams{ID: multisig.TxnID(txnID)})
+	if err != nil {
+		return nil, err
+	}
+	return sendMessage(ctx, m.api, m.w, &fil.Message{
+		To:     msig,
+		From:   from,
+		Value:  abi.NewTokenAmount(0),
+		Method: method,
+		Params: enc,
+	})
+}
+
+func (m *Multisig) get(msig address.Address, txnID int64) (*MsigProposal, error) {
+	data, err := m.ds.Get(proposalKey(msig, txnID))
+	if err == datastore.ErrNotFound {
+		return nil, ErrProposalNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p MsigProposal
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (m *Multisig) put(p *MsigProposal) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return m.ds.Put(proposalKey(p.Msig, p.TxnID), data)
+}
+
+func proposalKey(msig address.Address, txnID int64) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{msig.String(), fmt.Sprintf("%d", txnID)})
+}
+
+// chainBlockstore reads the blocks of actor states from the chain
+type chainBlockstore struct {
+	ctx context.Context
+	api fil.API
+}
+
+func (bs *chainBlockstore) Get(c cid.Cid) (block.Block, error) {
+	raw, err := bs.api.ChainReadObj(bs.ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return block.NewBlockWithCid(raw, c)
+}
+
+func (bs *chainBlockstore) Put(blk block.Block) error {
+	return fmt.Errorf("cannot write to the chain")
+}
+
+func serializeParams(i cbg.CBORMarshaler) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := i.MarshalCBOR(buf); err != nil {
+		return nil, fmt.Errorf("failed to encode parameter")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/multisig"
+	"github.com/filecoin-project/specs-actors/v4/actors/util/adt"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestMultisig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+	api.SetActor(&fil.Actor{Nonce: 0})
+
+	w := NewFromKeystore(keystore.NewMemKeystore(), WithFilAPI(api))
+	signer, err := w.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	signer2, err := w.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+
+	msig, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	m := NewMultisig(w, api, ds)
+
+	// sets the transactions pending in the multisig actor state on chain
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	store := adt.WrapStore(ctx, cbor.NewCborStore(bs))
+	api.SetObjectReader(func(c cid.Cid) []byte {
+		blk, err := bs.Get(c)
+		require.NoError(t, err)
+		return blk.RawData()
+	})
+	setPending := func(ids ...int64) {
+		ptx, err := adt.MakeEmptyMap(store, builtin.DefaultHamtBitwidth)
+		require.NoError(t, err)
+		for _, id := range ids {
+			require.NoError(t, ptx.Put(multisig.TxnID(id), &multisig.Transaction{To: client, Value: abi.NewTokenAmount(10)}))
+		}
+		root, err := ptx.Root()
+		require.NoError(t, err)
+		head, err := store.Put(ctx, &multisig.State{PendingTxns: root})
+		require.NoError(t, err)
+		api.SetActor(&fil.Actor{Head: head})
+	}
+	setPending(4, 6)
+
+	// releases the message we're waiting for with the given return value
+	setReturn := func(ret cbg.CBORMarshaler) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, ret.MarshalCBOR(buf))
+		go api.SetMsgLookup(&fil.MsgLookup{Receipt: fil.MessageReceipt{Return: buf.Bytes()}})
+	}
+
+	setReturn(&multisig.ProposeReturn{TxnID: 4})
+	p, err := m.ProposeMarketFunds(ctx, msig, signer, client, abi.NewTokenAmount(1000))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), p.TxnID)
+	require.Equal(t, builtin.StorageMarketActorAddr, p.To)
+	require.Equal(t, builtin.MethodsMarket.AddBalance, p.Method)
+	require.False(t, p.Applied)
+
+	// A proposal applied immediately isn't tracked
+	setReturn(&multisig.ProposeReturn{TxnID: 5, Applied: true})
+	_, err = m.Propose(ctx, msig, signer, client, abi.NewTokenAmount(10), builtin.MethodSend, nil)
+	require.NoError(t, err)
+
+	// Pending proposals survive a restart
+	m = NewMultisig(w, api, ds)
+	pending, err := m.Pending(ctx, msig)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, *p, pending[0])
+
+	other, err := address.NewIDAddress(1003)
+	require.NoError(t, err)
+	pending, err = m.Pending(ctx, other)
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+
+	// Approving a proposal made by another signer starts tracking it
+	setReturn(&multisig.ApproveReturn{})
+	_, err = m.Approve(ctx, msig, signer2, 6)
+	require.NoError(t, err)
+
+	pending, err = m.Pending(ctx, address.Undef)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	// Once the last approval executes the transaction it's no longer pending
+	setReturn(&multisig.ApproveReturn{Applied: true})
+	p, err = m.Approve(ctx, msig, signer2, 4)
+	require.NoError(t, err)
+	require.True(t, p.Applied)
+	require.Equal(t, []address.Address{signer, signer2}, p.Approvals)
+
+	setReturn(&multisig.ProposeReturn{TxnID: 7})
+	_, err = m.Propose(ctx, msig, signer, client, abi.NewTokenAmount(10), builtin.MethodSend, nil)
+	require.NoError(t, err)
+
+	go api.SetMsgLookup(&fil.MsgLookup{})
+	require.NoError(t, m.Cancel(ctx, msig, signer, 7))
+
+	pending, err = m.Pending(ctx, msig)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, int64(6), pending[0].TxnID)
+
+	// Another signer executed the last transaction so it's no longer pending
+	setPending()
+	pending, err = m.Pending(ctx, address.Undef)
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+
+	_, err = m.get(msig, 6)
+	require.ErrorIs(t, err, ErrProposalNotFound)
+}
@@ -0,0 +1,78 @@
+package wallet
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	fil "github.com/myelnet/pop/filecoin"
+)
+
+// NonceTracker assigns nonces to the messages sent from our addresses so concurrent messages
+// don't collide. Every component sending messages with the same wallet must share its tracker.
+type NonceTracker struct {
+	mu   sync.Mutex
+	next map[address.Address]uint64
+	// released are the nonces given back below next which the next messages reuse so we don't leave gaps
+	released map[address.Address][]uint64
+}
+
+// NewNonceTracker creates a new empty NonceTracker
+func NewNonceTracker() *NonceTracker {
+	return &NonceTracker{
+		next:     make(map[address.Address]uint64),
+		released: make(map[address.Address][]uint64),
+	}
+}
+
+// Assign sets the nonce of a message to the lowest nonce released by a message which failed to push
+// or else to the actor nonce on chain unless we have pending messages using it already
+func (nt *NonceTracker) Assign(ctx context.Context, api fil.API, msg *fil.Message) error {
+	act, err := api.StateGetActor(ctx, msg.From, fil.EmptyTSK)
+	if err != nil {
+		return err
+	}
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	// Released nonces used on chain in the meantime are gone
+	released := nt.released[msg.From]
+	for len(released) > 0 && released[0] < act.Nonce {
+		released = released[1:]
+	}
+	if len(released) > 0 {
+		msg.Nonce = released[0]
+		nt.released[msg.From] = released[1:]
+		return nil
+	}
+	delete(nt.released, msg.From)
+
+	nonce := act.Nonce
+	if next, ok := nt.next[msg.From]; ok && next > nonce {
+		nonce = next
+	}
+	msg.Nonce = nonce
+	nt.next[msg.From] = nonce + 1
+	return nil
+}
+
+// Release gives back the nonce of a message which failed to push so the next message reuses it
+func (nt *NonceTracker) Release(msg *fil.Message) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	next, ok := nt.next[msg.From]
+	if !ok || msg.Nonce >= next {
+		return
+	}
+	released := append(nt.released[msg.From], msg.Nonce)
+	sort.Slice(released, func(i, j int) bool { return released[i] < released[j] })
+	// Nonces released at the top of the range are simply not assigned again
+	for len(released) > 0 && released[len(released)-1] == next-1 {
+		released = released[:len(released)-1]
+		next--
+	}
+	nt.next[msg.From] = next
+	nt.released[msg.From] = released
+}
@@ -0,0 +1,56 @@
+package wallet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonceTracker(t *testing.T) {
+	ctx := context.Background()
+
+	api := fil.NewMockLotusAPI()
+	api.SetActor(&fil.Actor{Nonce: 3})
+
+	from, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	nt := NewNonceTracker()
+	assign := func() *fil.Message {
+		msg := &fil.Message{From: from}
+		require.NoError(t, nt.Assign(ctx, api, msg))
+		return msg
+	}
+
+	// Messages waiting in the pool get consecutive nonces
+	var msgs []*fil.Message
+	for i := 0; i < 4; i++ {
+		msg := assign()
+		require.Equal(t, uint64(3+i), msg.Nonce)
+		msgs = append(msgs, msg)
+	}
+
+	// A nonce released in the middle is reused by the next message so we don't leave a gap
+	nt.Release(msgs[1])
+	require.Equal(t, uint64(4), assign().Nonce)
+	require.Equal(t, uint64(7), assign().Nonce)
+
+	// Released nonces are reused from the lowest
+	nt.Release(msgs[3])
+	nt.Release(msgs[2])
+	require.Equal(t, uint64(5), assign().Nonce)
+	require.Equal(t, uint64(6), assign().Nonce)
+
+	// Released nonces used on chain in the meantime are skipped
+	nt.Release(msgs[0])
+	api.SetActor(&fil.Actor{Nonce: 5})
+	last := assign()
+	require.Equal(t, uint64(8), last.Nonce)
+
+	// The last nonce is simply assigned again
+	nt.Release(last)
+	require.Equal(t, uint64(8), assign().Nonce)
+}
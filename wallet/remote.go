@@ -0,0 +1,423 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	fil "github.com/myelnet/pop/filecoin"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrRemoteKey is returned when trying to access the private key of an address held by a remote signer
+var ErrRemoteKey = errors.New("private key is held by the remote signer")
+
+// ErrUnsignable is returned by the signing service when asked to sign data it cannot identify
+var ErrUnsignable = errors.New("refusing to sign unknown data")
+
+// signRequest is the body of a request to the signing service. Either Data or Message is set.
+type signRequest struct {
+	Address address.Address
+	// Data must be a SignData envelope, a payment voucher, a deal proposal or a deal status request
+	Data []byte
+	// Message is a chain message the service signs the CID of
+	Message *fil.Message
+}
+
+// RemoteSigner signs with keys held by an external signing service over HTTPS so they are never
+// distributed to the nodes using them
+type RemoteSigner struct {
+	url    string
+	client *http.Client
+}
+
+// NewRemoteSigner creates a client for the signing service at the given url. The TLS config should
+// include a client certificate for the service to authenticate us.
+func NewRemoteSigner(url string, conf *tls.Config) *RemoteSigner {
+	return &RemoteSigner{
+		url: strings.TrimSuffix(url, "/"),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: conf},
+		},
+	}
+}
+
+// LoadSignerTLS loads the client certificate and the CA certificate of the signing service for mutual TLS
+func LoadSignerTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no CA certificate found")
+		}
+	}
+	return conf, nil
+}
+
+// LoadSignerServerTLS loads the certificate of the signing service and the CA certificate the client certificates
+// of the nodes must be signed with. Nodes without a valid client certificate cannot connect.
+func LoadSignerServerTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	ca, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+		MinVersion:   tls.VersionTLS12,
+	}
+	if !conf.ClientCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no client CA certificate found")
+	}
+	return conf, nil
+}
+
+// Addresses lists the addresses the signing service holds keys for
+func (rs *RemoteSigner) Addresses(ctx context.Context) ([]address.Address, error) {
+	var addrs []address.Address
+	if err := rs.do(ctx, http.MethodGet, "/addresses", nil, &addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// Sign asks the signing service to sign the bytes with the key of the given address
+func (rs *RemoteSigner) Sign(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+	var sig crypto.Signature
+	if err := rs.do(ctx, http.MethodPost, "/sign", &signRequest{Address: addr, Data: msg}, &sig); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+// SignMessage asks the signing service to sign a chain message. The whole message is sent so the service
+// can check what it signs.
+func (rs *RemoteSigner) SignMessage(ctx context.Context, msg *fil.Message) (*crypto.Signature, error) {
+	var sig crypto.Signature
+	if err := rs.do(ctx, http.MethodPost, "/sign", &signRequest{Address: msg.From, Message: msg}, &sig); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+func (rs *RemoteSigner) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rs.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := rs.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("remote signer unreachable: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("remote signer error (status %d): %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// isDataEnvelope returns whether the bytes were wrapped by dataEnvelope
+func isDataEnvelope(b []byte) bool {
+	if !bytes.HasPrefix(b, []byte(signedDataPrefix)) {
+		return false
+	}
+	rest := b[len(signedDataPrefix):]
+	// the data may start with digits too so we try every length prefix
+	for i := 1; i <= len(rest) && rest[i-1] >= '0' && rest[i-1] <= '9'; i++ {
+		if l, err := strconv.Atoi(string(rest[:i])); err == nil && l == len(rest)-i {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignable makes sure the bytes we're asked to sign cannot be replayed as the signature of a chain
+// message. Only SignData envelopes, payment vouchers, deal proposals and deal status requests are accepted.
+func checkSignable(b []byte) error {
+	if isDataEnvelope(b) {
+		return nil
+	}
+	var sv paych.SignedVoucher
+	if err := sv.UnmarshalCBOR(bytes.NewReader(b)); err == nil && sv.Signature == nil {
+		if sb, err := sv.SigningBytes(); err == nil && bytes.Equal(sb, b) {
+			return nil
+		}
+	}
+	var prop market.DealProposal
+	if err := prop.UnmarshalCBOR(bytes.NewReader(b)); err == nil {
+		if pb, err := cborutil.Dump(&prop); err == nil && bytes.Equal(pb, b) {
+			return nil
+		}
+	}
+	// deal status requests sign the proposal CID encoded in cbor
+	r := bytes.NewReader(b)
+	if _, err := cbg.ReadCid(r); err == nil && r.Len() == 0 {
+		return nil
+	}
+	return ErrUnsignable
+}
+
+// NewSignerHandler serves the keys of a wallet to remote nodes. It should be served with a TLS config
+// requiring and verifying client certificates so only the nodes of the fleet can request signatures.
+// Only the allowed addresses are served and the service refuses to sign data it cannot identify.
+func NewSignerHandler(w Driver, allowed []address.Address) http.Handler {
+	allow := make(map[address.Address]bool, len(allowed))
+	for _, a := range allowed {
+		allow[a] = true
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/addresses", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := w.List()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		addrs := []address.Address{}
+		for _, a := range list {
+			if allow[a] {
+				addrs = append(addrs, a)
+			}
+		}
+		_ = json.NewEncoder(rw).Encode(addrs)
+	})
+	mux.HandleFunc("/sign", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req signRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !allow[req.Address] {
+			http.Error(rw, fmt.Sprintf("address %s not allowed", req.Address), http.StatusForbidden)
+			return
+		}
+		var sig *crypto.Signature
+		var err error
+		switch {
+		case req.Message != nil:
+			if req.Message.From != req.Address {
+				http.Error(rw, "message sender does not match address", http.StatusBadRequest)
+				return
+			}
+			var smsg *fil.SignedMessage
+			smsg, err = SignMessage(r.Context(), w, req.Message)
+			if err == nil {
+				sig = &smsg.Signature
+			}
+		default:
+			if err := checkSignable(req.Data); err != nil {
+				http.Error(rw, err.Error(), http.StatusForbidden)
+				return
+			}
+			sig, err = w.Sign(r.Context(), req.Address, req.Data)
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(sig)
+	})
+	return mux
+}
+
+// RemoteWallet signs with the keys of a remote signing service. Any other address is handled
+// by the wrapped local wallet.
+type RemoteWallet struct {
+	Driver
+
+	signer *RemoteSigner
+	fAPI   fil.API
+
+	mu     sync.Mutex
+	remote map[address.Address]bool
+	addrs  []address.Address
+	// defaultAddr overrides the local default address if set
+	defaultAddr address.Address
+}
+
+// RemoteOption is an optional configuration of the remote wallet
+type RemoteOption func(rw *RemoteWallet)
+
+// WithRemoteFilAPI sets the filecoin API client used to transfer funds from the remote addresses
+func WithRemoteFilAPI(f fil.API) RemoteOption {
+	return func(rw *RemoteWallet) {
+		rw.fAPI = f
+	}
+}
+
+// WithRemoteDefault uses the first remote address as the default address to pay and receive payments with
+func WithRemoteDefault() RemoteOption {
+	return func(rw *RemoteWallet) {
+		if len(rw.addrs) > 0 {
+			rw.defaultAddr = rw.addrs[0]
+		}
+	}
+}
+
+// NewFromRemote adds the addresses of a remote signing service to the local wallet
+func NewFromRemote(ctx context.Context, local Driver, signer *RemoteSigner, opts ...RemoteOption) (*RemoteWallet, error) {
+	addrs, err := signer.Addresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rw := &RemoteWallet{
+		Driver:      local,
+		signer:      signer,
+		remote:      make(map[address.Address]bool),
+		addrs:       addrs,
+		defaultAddr: address.Undef,
+	}
+	for _, a := range addrs {
+		rw.remote[a] = true
+	}
+	for _, opt := range opts {
+		opt(rw)
+	}
+	return rw, nil
+}
+
+// RemoteAddresses returns the addresses held by the signing service
+func (rw *RemoteWallet) RemoteAddresses() []address.Address {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return append([]address.Address{}, rw.addrs...)
+}
+
+func (rw *RemoteWallet) isRemote(addr address.Address) bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.remote[addr]
+}
+
+// DefaultAddress returns the remote default address if one was set or the local one
+func (rw *RemoteWallet) DefaultAddress() address.Address {
+	rw.mu.Lock()
+	def := rw.defaultAddr
+	rw.mu.Unlock()
+
+	if def != address.Undef {
+		return def
+	}
+	return rw.Driver.DefaultAddress()
+}
+
+// SetDefaultAddress to a local or remote address. A remote default address is only kept until the node restarts.
+func (rw *RemoteWallet) SetDefaultAddress(addr address.Address) error {
+	if rw.isRemote(addr) {
+		rw.mu.Lock()
+		rw.defaultAddr = addr
+		rw.mu.Unlock()
+		return nil
+	}
+	if err := rw.Driver.SetDefaultAddress(addr); err != nil {
+		return err
+	}
+	rw.mu.Lock()
+	rw.defaultAddr = address.Undef
+	rw.mu.Unlock()
+	return nil
+}
+
+// List all the addresses in the local wallet followed by the remote ones
+func (rw *RemoteWallet) List() ([]address.Address, error) {
+	list, err := rw.Driver.List()
+	if err != nil {
+		return nil, err
+	}
+	return append(list, rw.RemoteAddresses()...), nil
+}
+
+// ExportKey fails for remote addresses as their private keys never leave the signing service
+func (rw *RemoteWallet) ExportKey(ctx context.Context, addr address.Address) (*KeyInfo, error) {
+	if rw.isRemote(addr) {
+		return nil, ErrRemoteKey
+	}
+	return rw.Driver.ExportKey(ctx, addr)
+}
+
+// Sign with the signing service if the address is remote or with the local wallet otherwise
+func (rw *RemoteWallet) Sign(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+	if rw.isRemote(addr) {
+		return rw.signer.Sign(ctx, addr, msg)
+	}
+	return rw.Driver.Sign(ctx, addr, msg)
+}
+
+// SignMessage signs a chain message with the signing service if the sender is remote or with the local wallet
+// which may need to see the whole message
+func (rw *RemoteWallet) SignMessage(ctx context.Context, msg *fil.Message) (*fil.SignedMessage, error) {
+	if !rw.isRemote(msg.From) {
+		return SignMessage(ctx, rw.Driver, msg)
+	}
+	sig, err := rw.signer.SignMessage(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return &fil.SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}, nil
+}
+
+// Transfer from a local or remote address to any given address
+func (rw *RemoteWallet) Transfer(ctx context.Context, from address.Address, to address.Address, amount string) error {
+	if !rw.isRemote(from) {
+		return rw.Driver.Transfer(ctx, from, to, amount)
+	}
+	if rw.fAPI == nil {
+		return ErrNoAPI
+	}
+	return transfer(ctx, rw.fAPI, rw, from, to, amount)
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWallet(t *testing.T) {
+	ctx := context.Background()
+
+	// The signing service holds the shared key
+	central := NewFromKeystore(keystore.NewMemKeystore())
+	remoteAddr, err := central.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	// keys which aren't allowed are never served
+	privateAddr, err := central.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+
+	srv := httptest.NewTLSServer(NewSignerHandler(central, []address.Address{remoteAddr}))
+	defer srv.Close()
+
+	local := NewFromKeystore(keystore.NewMemKeystore())
+	localAddr, err := local.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+
+	signer := NewRemoteSigner(srv.URL, srv.Client().Transport.(*http.Transport).TLSClientConfig)
+	w, err := NewFromRemote(ctx, local, signer, WithRemoteDefault())
+	require.NoError(t, err)
+
+	require.Equal(t, []address.Address{remoteAddr}, w.RemoteAddresses())
+	require.Equal(t, remoteAddr, w.DefaultAddress())
+
+	list, err := w.List()
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{localAddr, remoteAddr}, list)
+
+	_, err = w.ExportKey(ctx, remoteAddr)
+	require.ErrorIs(t, err, ErrRemoteKey)
+
+	for _, from := range []address.Address{remoteAddr, localAddr} {
+		msg := &fil.Message{
+			To:         localAddr,
+			From:       from,
+			Value:      big.NewInt(1000),
+			GasLimit:   222,
+			GasFeeCap:  big.NewInt(333),
+			GasPremium: big.NewInt(333),
+		}
+		smsg, err := SignMessage(ctx, w, msg)
+		require.NoError(t, err)
+
+		ok, err := w.Verify(ctx, from, msg.Cid().Bytes(), &smsg.Signature)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	// The service refuses to sign for keys it doesn't hold or doesn't allow
+	_, err = signer.Sign(ctx, localAddr, []byte("data"))
+	require.Error(t, err)
+	_, err = signer.Sign(ctx, privateAddr, dataEnvelope([]byte("data")))
+	require.Error(t, err)
+
+	// Arbitrary bytes such as a message CID are refused so signatures cannot be forged
+	msg := &fil.Message{
+		To:         localAddr,
+		From:       remoteAddr,
+		Value:      big.NewInt(1000),
+		GasLimit:   222,
+		GasFeeCap:  big.NewInt(333),
+		GasPremium: big.NewInt(333),
+	}
+	_, err = signer.Sign(ctx, remoteAddr, msg.Cid().Bytes())
+	require.Error(t, err)
+	_, err = signer.Sign(ctx, remoteAddr, []byte("data"))
+	require.Error(t, err)
+
+	// Domain separated data is signed
+	sig, err := SignData(ctx, w, remoteAddr, []byte("challenge"))
+	require.NoError(t, err)
+	ok, err := VerifyData(ctx, w, remoteAddr, []byte("challenge"), sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Vouchers are decoded before being signed
+	ch, err := address.NewIDAddress(1010)
+	require.NoError(t, err)
+	sv := &paych.SignedVoucher{
+		ChannelAddr: ch,
+		Lane:        1,
+		Nonce:       1,
+		Amount:      big.NewInt(100),
+	}
+	vb, err := sv.SigningBytes()
+	require.NoError(t, err)
+	_, err = w.Sign(ctx, remoteAddr, vb)
+	require.NoError(t, err)
+
+	require.NoError(t, w.SetDefaultAddress(localAddr))
+	require.Equal(t, localAddr, w.DefaultAddress())
+
+	// Clients without a trusted certificate cannot reach the service
+	_, err = NewFromRemote(ctx, local, NewRemoteSigner(srv.URL, nil))
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package wallet
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+)
+
+// signedDataPrefix is prepended to arbitrary data before signing it so the signature can never be replayed
+// as the signature of a chain message, a voucher or a deal proposal
+const signedDataPrefix = "\x19Filecoin Signed Message:\n"
+
+// dataEnvelope wraps arbitrary data with the prefix and its length
+func dataEnvelope(data []byte) []byte {
+	l := strconv.Itoa(len(data))
+	env := make([]byte, 0, len(signedDataPrefix)+len(l)+len(data))
+	env = append(env, signedDataPrefix...)
+	env = append(env, l...)
+	return append(env, data...)
+}
+
+// SignData signs arbitrary data such as an authentication challenge or a receipt with the key of the given address
+func SignData(ctx context.Context, w Driver, addr address.Address, data []byte) (*crypto.Signature, error) {
+	return w.Sign(ctx, addr, dataEnvelope(data))
+}
+
+// VerifyData checks the data was signed with SignData by the given address. The address doesn't need to be
+// in our wallet.
+func VerifyData(ctx context.Context, w Driver, addr address.Address, data []byte, sig *crypto.Signature) (bool, error) {
+	signer, err := SigTypeSig(sig.Type, w.Signers())
+	if err != nil {
+		return false, err
+	}
+	if err := signer.Verify(sig.Data, addr, dataEnvelope(data)); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
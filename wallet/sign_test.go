@@ -0,0 +1,45 @@
+package wallet
+
+import (
+	"context"
+	"testing"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignData(t *testing.T) {
+	ctx := context.Background()
+
+	w := NewFromKeystore(keystore.NewMemKeystore())
+	addr, err := w.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+	other, err := w.NewKey(ctx, KTSecp256k1)
+	require.NoError(t, err)
+
+	data := []byte("receipt for 1024 bytes")
+	sig, err := SignData(ctx, w, addr, data)
+	require.NoError(t, err)
+
+	ok, err := VerifyData(ctx, w, addr, data, sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Any other wallet can verify it
+	ok, err = VerifyData(ctx, NewFromKeystore(keystore.NewMemKeystore()), addr, data, sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = VerifyData(ctx, w, addr, []byte("receipt for 2048 bytes"), sig)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = VerifyData(ctx, w, other, data, sig)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The signature is not valid for the raw data so it cannot be used to authorize a message
+	ok, err = w.Verify(ctx, addr, data, sig)
+	require.Error(t, err)
+	require.False(t, ok)
+}
@@ -189,6 +189,35 @@ type Driver interface {
 	Balance(context.Context, address.Address) (fil.BigInt, error)
 	Transfer(ctx context.Context, from address.Address, to address.Address, amount string) error
 	Signers() map[KeyType]Signer
+	Nonces() *NonceTracker
+}
+
+// MessageSigner is implemented by drivers which need the whole message to sign it instead of only its CID,
+// such as hardware wallets displaying the message for approval
+type MessageSigner interface {
+	SignMessage(context.Context, *fil.Message) (*fil.SignedMessage, error)
+}
+
+// SignMessage signs a chain message with the key of its sender
+func SignMessage(ctx context.Context, w Driver, msg *fil.Message) (*fil.SignedMessage, error) {
+	if ms, ok := w.(MessageSigner); ok {
+		return ms.SignMessage(ctx, msg)
+	}
+
+	mbl, err := msg.ToStorageBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := w.Sign(ctx, msg.From, mbl.Cid().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &fil.SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}, nil
 }
 
 // KeystoreWallet wraps an IPFS keystore
@@ -201,6 +230,9 @@ type KeystoreWallet struct {
 	mu          sync.Mutex
 	keys        map[address.Address]*Key // cache so we don't read from the Keystore too much
 	defaultAddr address.Address
+
+	// nonces is shared by everything sending messages from our addresses
+	nonces *NonceTracker
 }
 
 // Option is an optional configuration of the wallet
@@ -227,6 +259,7 @@ func NewFromKeystore(ks keystore.Keystore, opts ...Option) Driver {
 		keys:        make(map[address.Address]*Key),
 		sigs:        make(map[KeyType]Signer),
 		defaultAddr: address.Undef,
+		nonces:      NewNonceTracker(),
 	}
 	// Add Secp256k1 by default
 	w.sigs[KTSecp256k1] = secp{}
@@ -442,6 +475,11 @@ func (w *KeystoreWallet) getKey(addr address.Address) (*Key, error) {
 	return k, nil
 }
 
+// Nonces returns the tracker assigning the nonces of the messages sent from our addresses
+func (w *KeystoreWallet) Nonces() *NonceTracker {
+	return w.nonces
+}
+
 // Signers returns all the signers registered for this wallet
 func (w *KeystoreWallet) Signers() map[KeyType]Signer {
 	return w.sigs
@@ -482,16 +520,20 @@ func ActSigType(typ KeyType) crypto.SigType {
 
 // ------------------ Filecoin Methods -------------------------
 
-// Balance for a given address
+// Balance for a given address. Addresses which never received funds have no actor on chain yet
+// so their balance is zero.
 func (w *KeystoreWallet) Balance(ctx context.Context, addr address.Address) (fil.BigInt, error) {
 	if w.fAPI == nil {
 		return big.Zero(), ErrNoAPI
 	}
-	state, err := w.fAPI.StateReadState(ctx, addr, fil.EmptyTSK)
+	act, err := w.fAPI.StateGetActor(ctx, addr, fil.EmptyTSK)
 	if err != nil {
+		if strings.Contains(err.Error(), "actor not found") {
+			return big.Zero(), nil
+		}
 		return big.Zero(), err
 	}
-	return state.Balance, nil
+	return act.Balance, nil
 }
 
 // Transfer from an address in our wallet to any given address
@@ -505,7 +547,11 @@ func (w *KeystoreWallet) Transfer(ctx context.Context, from address.Address, to
 	if _, err := w.getKey(from); err != nil {
 		return err
 	}
+	return transfer(ctx, w.fAPI, w, from, to, amount)
+}
 
+// transfer signs a message sending the amount with the given driver and waits for it to be executed on chain
+func transfer(ctx context.Context, api fil.API, w Driver, from address.Address, to address.Address, amount string) error {
 	val, err := fil.ParseFIL(amount)
 	if err != nil {
 		return err
@@ -519,44 +565,40 @@ func (w *KeystoreWallet) Transfer(ctx context.Context, from address.Address, to
 		Method: method,
 	}
 
-	msg, err = w.fAPI.GasEstimateMessageGas(ctx, msg, nil, fil.EmptyTSK)
-	if err != nil {
-		return err
-	}
+	_, err = sendMessage(ctx, api, w, msg)
+	return err
+}
 
-	act, err := w.fAPI.StateGetActor(ctx, msg.From, fil.EmptyTSK)
+// sendMessage estimates gas for a message, signs it with the given driver and waits for it to be executed on chain
+func sendMessage(ctx context.Context, api fil.API, w Driver, msg *fil.Message) (*fil.MsgLookup, error) {
+	msg, err := api.GasEstimateMessageGas(ctx, msg, nil, fil.EmptyTSK)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	msg.Nonce = act.Nonce
 
-	mbl, err := msg.ToStorageBlock()
-	if err != nil {
-		return err
+	if err := w.Nonces().Assign(ctx, api, msg); err != nil {
+		return nil, err
 	}
 
-	sig, err := w.Sign(ctx, msg.From, mbl.Cid().Bytes())
+	smsg, err := SignMessage(ctx, w, msg)
 	if err != nil {
-		return err
-	}
-
-	smsg := &fil.SignedMessage{
-		Message:   *msg,
-		Signature: *sig,
+		w.Nonces().Release(msg)
+		return nil, err
 	}
 
-	if _, err := w.fAPI.MpoolPush(ctx, smsg); err != nil {
-		return fmt.Errorf("MpoolPush failed with error: %v", err)
+	if _, err := api.MpoolPush(ctx, smsg); err != nil {
+		w.Nonces().Release(msg)
+		return nil, fmt.Errorf("MpoolPush failed with error: %v", err)
 	}
 
-	mwait, err := w.fAPI.StateWaitMsg(ctx, smsg.Cid(), uint64(5))
+	mwait, err := api.StateWaitMsg(ctx, smsg.Cid(), uint64(5))
 	if err != nil {
-		return fmt.Errorf("Failed to wait for msg: %s", err)
+		return nil, fmt.Errorf("Failed to wait for msg: %s", err)
 	}
 
 	if mwait.Receipt.ExitCode != 0 {
-		return fmt.Errorf("Tx failed (exit code %d)", mwait.Receipt.ExitCode)
+		return nil, fmt.Errorf("Tx failed (exit code %d)", mwait.Receipt.ExitCode)
 	}
 
-	return nil
+	return mwait, nil
 }
@@ -170,4 +170,8 @@ func TestTransfer(t *testing.T) {
 
 	err = w.Transfer(ctx, addr1, addr2, "12")
 	require.NoError(t, err)
+
+	bal, err := w.Balance(ctx, addr1)
+	require.NoError(t, err)
+	require.Equal(t, fil.NewInt(30), bal)
 }